@@ -0,0 +1,131 @@
+package fasthttp
+
+import (
+	"time"
+)
+
+// AccessLogFormat is the format of access log lines.
+//
+// See Server.AccessLog and AppendAccessLog for details.
+type AccessLogFormat int
+
+const (
+	// AccessLogCommon is the Common Log Format used by Apache and nginx:
+	//
+	//     1.2.3.4 - - [10/Oct/2000:13:55:36 -0700] "GET /foo HTTP/1.1" 200 2326
+	AccessLogCommon AccessLogFormat = iota
+
+	// AccessLogJSON is one JSON object per line:
+	//
+	//     {"time":"2000-10-10T13:55:36.123-07:00","remote_ip":"1.2.3.4","method":"GET","uri":"/foo","proto":"HTTP/1.1","user_agent":"curl/7.64.1","status":200,"bytes":2326,"duration_us":135}
+	AccessLogJSON
+)
+
+// AppendAccessLog appends access log line for the request served by ctx
+// to dst and returns the extended dst.
+//
+// The line ends with '\n'. The function doesn't allocate memory for requests
+// from IPv4 addresses, so it may be called from RequestHandler
+// for custom access logging.
+func AppendAccessLog(dst []byte, ctx *RequestCtx, format AccessLogFormat) []byte {
+	dst = appendAccessLogRequest(dst, ctx, format)
+	return appendAccessLogResponse(dst, &ctx.Response, time.Since(ctx.Time()), format)
+}
+
+// appendAccessLogRequest appends the request part of access log line to dst.
+//
+// The request part is encoded before calling RequestHandler, since
+// the handler may modify the request or may be still running
+// after TimeoutHandler sent the response.
+func appendAccessLogRequest(dst []byte, ctx *RequestCtx, format AccessLogFormat) []byte {
+	h := &ctx.Request.Header
+	if format == AccessLogJSON {
+		dst = append(dst, `{"time":"`...)
+		dst = ctx.Time().AppendFormat(dst, "2006-01-02T15:04:05.000Z07:00")
+		dst = append(dst, `","remote_ip":"`...)
+		dst = appendAccessLogIP(dst, ctx)
+		dst = append(dst, `","method":`...)
+		dst = appendJSONString(dst, h.Method())
+		dst = append(dst, `,"uri":`...)
+		dst = appendJSONString(dst, h.RequestURI())
+		dst = append(dst, `,"proto":"`...)
+		dst = appendAccessLogProto(dst, h)
+		dst = append(dst, `","user_agent":`...)
+		return appendJSONString(dst, h.UserAgent())
+	}
+
+	dst = appendAccessLogIP(dst, ctx)
+	dst = append(dst, " - - ["...)
+	dst = ctx.Time().AppendFormat(dst, "02/Jan/2006:15:04:05 -0700")
+	dst = append(dst, `] "`...)
+	dst = appendAccessLogEscaped(dst, h.Method())
+	dst = append(dst, ' ')
+	dst = appendAccessLogEscaped(dst, h.RequestURI())
+	dst = append(dst, ' ')
+	dst = appendAccessLogProto(dst, h)
+	return append(dst, '"')
+}
+
+// appendAccessLogResponse appends the response part of access log line
+// for the request served during d to dst.
+func appendAccessLogResponse(dst []byte, resp *Response, d time.Duration, format AccessLogFormat) []byte {
+	bytesSent := len(resp.bodyBytes())
+	if resp.bodyStream != nil {
+		bytesSent = resp.Header.ContentLength()
+	}
+	if resp.SkipBody {
+		bytesSent = 0
+	}
+
+	if format == AccessLogJSON {
+		dst = append(dst, `,"status":`...)
+		dst = AppendUint(dst, resp.StatusCode())
+		dst = append(dst, `,"bytes":`...)
+		if bytesSent < 0 {
+			dst = append(dst, "null"...)
+		} else {
+			dst = AppendUint(dst, bytesSent)
+		}
+		dst = append(dst, `,"duration_us":`...)
+		dst = AppendUint(dst, int(d/time.Microsecond))
+		return append(dst, "}\n"...)
+	}
+
+	dst = append(dst, ' ')
+	dst = AppendUint(dst, resp.StatusCode())
+	dst = append(dst, ' ')
+	if bytesSent <= 0 {
+		dst = append(dst, '-')
+	} else {
+		dst = AppendUint(dst, bytesSent)
+	}
+	return append(dst, '\n')
+}
+
+func appendAccessLogIP(dst []byte, ctx *RequestCtx) []byte {
+	ip := ctx.RemoteIP()
+	if ip.To4() != nil {
+		return AppendIPv4(dst, ip)
+	}
+	return append(dst, ip.String()...)
+}
+
+func appendAccessLogProto(dst []byte, h *RequestHeader) []byte {
+	if h.IsHTTP11() {
+		return append(dst, strHTTP11...)
+	}
+	return append(dst, "HTTP/1.0"...)
+}
+
+// appendAccessLogEscaped appends s to dst, escaping quotes, backslashes
+// and non-printable chars as \xHH.
+func appendAccessLogEscaped(dst, s []byte) []byte {
+	for _, c := range s {
+		if c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			dst = append(dst, '\\', 'x', hexCharUpper(c>>4), hexCharUpper(c&0xf))
+			continue
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
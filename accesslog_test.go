@@ -0,0 +1,115 @@
+package fasthttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newAccessLogTestCtx(uri, userAgent string) *RequestCtx {
+	var req Request
+	req.Header.SetMethod("POST")
+	req.Header.SetRequestURI(uri)
+	req.Header.SetUserAgent(userAgent)
+	addr := &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5789}
+
+	var ctx RequestCtx
+	ctx.Init(&req, addr, nil)
+	ctx.time = time.Date(2000, time.October, 10, 13, 55, 36, 123e6, time.FixedZone("", -7*3600))
+	ctx.SetStatusCode(StatusNotFound)
+	ctx.SetBodyString("not found")
+	return &ctx
+}
+
+func TestAppendAccessLogCommon(t *testing.T) {
+	ctx := newAccessLogTestCtx("/foo?bar=\"baz\"", "curl")
+	line := AppendAccessLog(nil, ctx, AccessLogCommon)
+	expected := `1.2.3.4 - - [10/Oct/2000:13:55:36 -0700] "POST /foo?bar=\x22baz\x22 HTTP/1.1" 404 9` + "\n"
+	if string(line) != expected {
+		t.Fatalf("unexpected access log line\n%q\nExpecting\n%q", line, expected)
+	}
+
+	ctx.Response.SkipBody = true
+	line = AppendAccessLog(line[:0], ctx, AccessLogCommon)
+	if !strings.HasSuffix(string(line), `" 404 -`+"\n") {
+		t.Fatalf("unexpected access log line for skipped body: %q", line)
+	}
+}
+
+func TestAppendAccessLogJSON(t *testing.T) {
+	ctx := newAccessLogTestCtx("/foo?bar=\"baz\"", "agent\\\x01")
+	line := AppendAccessLog(nil, ctx, AccessLogJSON)
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		t.Fatalf("missing trailing newline in %q", line)
+	}
+	var v struct {
+		Time       string `json:"time"`
+		RemoteIP   string `json:"remote_ip"`
+		Method     string `json:"method"`
+		URI        string `json:"uri"`
+		Proto      string `json:"proto"`
+		UserAgent  string `json:"user_agent"`
+		Status     int    `json:"status"`
+		Bytes      int    `json:"bytes"`
+		DurationUS int64  `json:"duration_us"`
+	}
+	if err := json.Unmarshal(line, &v); err != nil {
+		t.Fatalf("cannot parse access log line %q: %s", line, err)
+	}
+	if v.Time != "2000-10-10T13:55:36.123-07:00" {
+		t.Fatalf("unexpected time %q", v.Time)
+	}
+	if v.RemoteIP != "1.2.3.4" || v.Method != "POST" || v.URI != "/foo?bar=\"baz\"" || v.Proto != "HTTP/1.1" {
+		t.Fatalf("unexpected request fields in %q", line)
+	}
+	if v.UserAgent != "agent\\\x01" {
+		t.Fatalf("unexpected user agent %q. Expecting %q", v.UserAgent, "agent\\\x01")
+	}
+	if v.Status != StatusNotFound || v.Bytes != 9 {
+		t.Fatalf("unexpected response fields in %q", line)
+	}
+}
+
+func TestAppendAccessLogNoAllocs(t *testing.T) {
+	ctx := newAccessLogTestCtx("/foo/bar?baz=1", "curl/7.64.1")
+	for _, format := range []AccessLogFormat{AccessLogCommon, AccessLogJSON} {
+		var buf []byte
+		n := testing.AllocsPerRun(100, func() {
+			buf = AppendAccessLog(buf[:0], ctx, format)
+		})
+		if n != 0 {
+			t.Fatalf("unexpected number of allocations for format %d: %v", format, n)
+		}
+	}
+}
+
+func TestServerAccessLog(t *testing.T) {
+	var accessLog bytes.Buffer
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("hello")
+		},
+		AccessLog: &accessLog,
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("GET /foo HTTP/1.1\r\nHost: aa\r\n\r\n")
+	rw.r.WriteString("HEAD /bar HTTP/1.0\r\nHost: aa\r\n\r\n")
+	if err := s.ServeConn(&fakeIPConn{rw}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(accessLog.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected number of access log lines: %d. Log:\n%s", len(lines), accessLog.String())
+	}
+	if !strings.HasPrefix(lines[0], "1.2.3.4 - - [") || !strings.HasSuffix(lines[0], `] "GET /foo HTTP/1.1" 200 5`) {
+		t.Fatalf("unexpected first access log line %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], `] "HEAD /bar HTTP/1.0" 200 -`) {
+		t.Fatalf("unexpected second access log line %q", lines[1])
+	}
+}
@@ -0,0 +1,167 @@
+package fasthttp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// AddrSelection determines how HostClient selects one of the addresses
+// listed in HostClient.Addr for sending the request.
+type AddrSelection int
+
+const (
+	// AddrRoundRobin selects the addresses in turn.
+	AddrRoundRobin AddrSelection = iota
+
+	// AddrLeastPending selects the address with the least number
+	// of pending requests, so slow addresses receive less traffic.
+	AddrLeastPending
+
+	// AddrFewestErrors selects the address with the fewest failed requests
+	// and dials during the last minute. Addresses with the same number
+	// of errors are selected by the least number of pending requests.
+	AddrFewestErrors
+)
+
+// addrErrorsWindow is the duration after which the per-address error
+// counters are reset for AddrFewestErrors.
+const addrErrorsWindow = time.Minute
+
+// addrStat contains per-address stats used by address selection.
+//
+// The fields are accessed atomically.
+type addrStat struct {
+	pending int32
+	errors  int32
+
+	// unhealthy is set if the last health probe failed.
+	unhealthy int32
+}
+
+// unhealthyScore is added to the score of unhealthy addresses,
+// so they are selected only if all the addresses are unhealthy.
+const unhealthyScore = 1 << 62
+
+// score returns the score of the address according to sel.
+//
+// The address with the lowest score is preferred.
+func (s *addrStat) score(sel AddrSelection) int64 {
+	var score int64
+	switch sel {
+	case AddrLeastPending:
+		score = int64(atomic.LoadInt32(&s.pending))
+	case AddrFewestErrors:
+		score = int64(atomic.LoadInt32(&s.errors))<<32 | int64(atomic.LoadInt32(&s.pending))
+	}
+	if atomic.LoadInt32(&s.unhealthy) != 0 {
+		score += unhealthyScore
+	}
+	return score
+}
+
+// selectAddrLocked returns the index of the address with the lowest score
+// among c.addrs, which aren't listed in tried.
+//
+// Addresses with the same score are selected in a round-robin manner.
+// It must be called under c.addrsLock.
+func (c *HostClient) selectAddrLocked(tried []string) int {
+	n := len(c.addrs)
+	if c.AddrSelection == AddrFewestErrors {
+		now := time.Now()
+		if now.Sub(c.addrErrorsResetTime) > addrErrorsWindow {
+			for i := range c.addrStats {
+				atomic.StoreInt32(&c.addrStats[i].errors, 0)
+			}
+			c.addrErrorsResetTime = now
+		}
+	}
+
+	start := int(c.addrIdx % uint32(n))
+	c.addrIdx++
+	best := -1
+	var bestScore int64
+	for i := 0; i < n; i++ {
+		idx := (start + i) % n
+		if containsString(tried, c.addrs[idx]) {
+			continue
+		}
+		score := c.addrStats[idx].score(c.AddrSelection)
+		if best < 0 || score < bestScore {
+			best = idx
+			bestScore = score
+		}
+	}
+	if best < 0 {
+		// All the addresses have been tried.
+		best = start
+	}
+	return best
+}
+
+// getAddrStat returns stats for addr if requests must be tracked
+// for address selection.
+func (c *HostClient) getAddrStat(addr string) *addrStat {
+	if c.AddrSelection == AddrRoundRobin && c.HealthCheck == nil {
+		return nil
+	}
+	c.addrsLock.Lock()
+	defer c.addrsLock.Unlock()
+	if len(c.addrs) < 2 {
+		return nil
+	}
+	for i, a := range c.addrs {
+		if a == addr {
+			return &c.addrStats[i]
+		}
+	}
+	return nil
+}
+
+// startAddrRequest marks cc as busy with a pending request.
+func startAddrRequest(cc *clientConn) {
+	if cc.addrStat != nil && !cc.addrPending {
+		atomic.AddInt32(&cc.addrStat.pending, 1)
+		cc.addrPending = true
+	}
+}
+
+// finishAddrRequest marks the pending request on cc as finished.
+func finishAddrRequest(cc *clientConn) {
+	if cc.addrPending {
+		atomic.AddInt32(&cc.addrStat.pending, -1)
+		cc.addrPending = false
+	}
+}
+
+// selectIdleConnLocked returns the index of the idle connection
+// to the address with the lowest score.
+//
+// It must be called under c.connsLock.
+func (c *HostClient) selectIdleConnLocked() int {
+	n := len(c.conns) - 1
+	best := n
+	var bestScore int64
+	if s := c.conns[n].addrStat; s != nil {
+		bestScore = s.score(c.AddrSelection)
+	}
+	for i := n - 1; i >= 0; i-- {
+		s := c.conns[i].addrStat
+		if s == nil {
+			continue
+		}
+		if score := s.score(c.AddrSelection); score < bestScore {
+			best = i
+			bestScore = score
+		}
+	}
+	return best
+}
+
+func containsString(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
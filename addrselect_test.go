@@ -0,0 +1,150 @@
+package fasthttp
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func TestHostClientNextAddrSelection(t *testing.T) {
+	c := &HostClient{
+		Addr:          "a,b,c",
+		AddrSelection: AddrLeastPending,
+	}
+	c.nextAddr(nil)
+	c.addrStats[0].pending = 2
+	c.addrStats[2].pending = 1
+	for i := 0; i < 3; i++ {
+		if addr := c.nextAddr(nil); addr != "b" {
+			t.Fatalf("unexpected addr: %q. Expecting %q", addr, "b")
+		}
+	}
+	if addr := c.nextAddr([]string{"b"}); addr != "c" {
+		t.Fatalf("unexpected addr: %q. Expecting %q", addr, "c")
+	}
+
+	// Addresses with the same score are selected in a round-robin manner.
+	c.addrStats[0].pending = 0
+	c.addrStats[2].pending = 0
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		seen[c.nextAddr(nil)] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("unexpected addrs selected: %v. Expecting all the addrs", seen)
+	}
+
+	c.AddrSelection = AddrFewestErrors
+	c.nextAddr(nil)
+	c.addrStats[0].errors = 1
+	c.addrStats[1].errors = 1
+	c.addrStats[1].pending = 5
+	c.addrStats[2].errors = 2
+	if addr := c.nextAddr(nil); addr != "a" {
+		t.Fatalf("unexpected addr: %q. Expecting %q", addr, "a")
+	}
+}
+
+func TestHostClientAddrLeastPending(t *testing.T) {
+	lns := map[string]*fasthttputil.InmemoryListener{
+		"slow": fasthttputil.NewInmemoryListener(),
+		"fast": fasthttputil.NewInmemoryListener(),
+	}
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	for addr, ln := range lns {
+		addr := addr
+		defer ln.Close()
+		s := &Server{
+			Handler: func(ctx *RequestCtx) {
+				if addr == "slow" {
+					started <- struct{}{}
+					<-unblock
+				}
+				ctx.WriteString(addr)
+			},
+		}
+		go s.Serve(ln)
+	}
+
+	c := &HostClient{
+		Addr:          "slow,fast",
+		AddrSelection: AddrLeastPending,
+		Dial: func(addr string) (net.Conn, error) {
+			return lns[addr].Dial()
+		},
+	}
+
+	ch := make(chan error, 1)
+	go func() {
+		_, _, err := c.Get(nil, "http://foobar/")
+		ch <- err
+	}()
+	<-started
+
+	// The slow address has a pending request, so the fast one is selected.
+	for i := 0; i < 5; i++ {
+		_, body, err := c.Get(nil, "http://foobar/")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(body) != "fast" {
+			t.Fatalf("unexpected response from %q. Expecting %q", body, "fast")
+		}
+	}
+	close(unblock)
+	if err := <-ch; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := range c.addrStats {
+		if n := atomic.LoadInt32(&c.addrStats[i].pending); n != 0 {
+			t.Fatalf("unexpected pending requests for %q: %d. Expecting 0", c.addrs[i], n)
+		}
+	}
+}
+
+func TestHostClientAddrFewestErrors(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("ok")
+		},
+	}
+	go s.Serve(ln)
+
+	var badDials uint32
+	c := &HostClient{
+		Addr:          "bad,good",
+		AddrSelection: AddrFewestErrors,
+		Dial: func(addr string) (net.Conn, error) {
+			if addr == "bad" {
+				atomic.AddUint32(&badDials, 1)
+				return nil, errors.New("connection refused")
+			}
+			return ln.Dial()
+		},
+		MaxConns: 10,
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/")
+	req.SetConnectionClose()
+
+	// The first dial tries only the first addr, since the addrs
+	// aren't initialized yet.
+	if err := c.Do(req, nil); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	for i := 0; i < 5; i++ {
+		if err := c.Do(req, nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if n := atomic.LoadUint32(&badDials); n != 1 {
+		t.Fatalf("unexpected number of dials to the bad addr: %d. Expecting 1", n)
+	}
+}
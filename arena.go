@@ -0,0 +1,62 @@
+package fasthttp
+
+// maxArenaRetainedSize is the maximum arena size retained between requests.
+//
+// Bigger arenas are dropped on reset, so rare huge requests don't pin
+// memory forever.
+const maxArenaRetainedSize = 64 * 1024
+
+// arena is a bump allocator for byte slices, which are freed all at once
+// by reset.
+//
+// Slices returned from arena have capacity equal to their length,
+// so appending to them never overwrites neighbour slices.
+// Slices returned from arena mustn't be used after reset.
+type arena struct {
+	b []byte
+}
+
+// reset frees all the slices allocated from a.
+func (a *arena) reset() {
+	if cap(a.b) > maxArenaRetainedSize {
+		a.b = nil
+		return
+	}
+	a.b = a.b[:0]
+}
+
+// alloc returns a copy of src allocated in a.
+func (a *arena) alloc(src []byte) []byte {
+	n := len(a.b)
+	a.b = append(a.b, src...)
+	return a.b[n:len(a.b):len(a.b)]
+}
+
+// allocDecodedArg returns decoded query arg src allocated in a.
+func (a *arena) allocDecodedArg(src []byte) []byte {
+	n := len(a.b)
+	a.b = decodeArgAppend(a.b, src)
+	return a.b[n:len(a.b):len(a.b)]
+}
+
+func appendArenaArg(args []argsKV, a *arena, key, value []byte) []argsKV {
+	var kv *argsKV
+	args, kv = allocArg(args)
+	kv.key = a.alloc(key)
+	kv.value = a.alloc(value)
+	return args
+}
+
+// resetArenaArgs clears all the args, which may refer to arena memory.
+//
+// This prevents from overwriting arena memory after arena reset
+// when the args are re-used.
+func resetArenaArgs(args []argsKV) []argsKV {
+	args = args[:cap(args)]
+	for i := range args {
+		kv := &args[i]
+		kv.key = nil
+		kv.value = nil
+	}
+	return args[:0]
+}
@@ -0,0 +1,94 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestArenaAlloc(t *testing.T) {
+	var a arena
+	foo := a.alloc([]byte("foo"))
+	bar := a.alloc([]byte("bar"))
+	if cap(foo) != len(foo) {
+		t.Fatalf("unexpected capacity %d. Expecting %d", cap(foo), len(foo))
+	}
+	foo = append(foo, "baz"...)
+	if string(foo) != "foobaz" {
+		t.Fatalf("unexpected value %q. Expecting %q", foo, "foobaz")
+	}
+	if string(bar) != "bar" {
+		t.Fatalf("neighbour value has been overwritten: %q. Expecting %q", bar, "bar")
+	}
+	v := a.allocDecodedArg([]byte("a%20b+c"))
+	if string(v) != "a b c" {
+		t.Fatalf("unexpected decoded value %q. Expecting %q", v, "a b c")
+	}
+
+	a.reset()
+	if len(a.b) != 0 {
+		t.Fatalf("unexpected arena size after reset: %d", len(a.b))
+	}
+
+	a.alloc(createFixedBody(2 * maxArenaRetainedSize))
+	a.reset()
+	if a.b != nil {
+		t.Fatalf("too big arena mustn't be retained after reset")
+	}
+}
+
+func TestRequestArena(t *testing.T) {
+	var req Request
+	req.enableArena()
+
+	for i := 0; i < 10; i++ {
+		s := fmt.Sprintf("POST /foo?x=%d&y=a%%20b HTTP/1.1\r\nHost: aaa\r\nX-Foo-%d: bar%d\r\nX-Baz: qwe\r\n"+
+			"Content-Type: application/x-www-form-urlencoded\r\nContent-Length: 7\r\n\r\nz=%05d", i, i, i, i)
+		br := bufio.NewReader(bytes.NewBufferString(s))
+		if err := req.Read(br); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		// Modify headers and args in order to make sure they don't
+		// overwrite each other.
+		req.Header.Set("X-Baz", "a-long-value-for-x-baz-header")
+		req.Header.Set("X-New", "new")
+		req.URI().QueryArgs().Add("added", "1")
+
+		expectedFoo := fmt.Sprintf("bar%d", i)
+		if v := req.Header.Peek(fmt.Sprintf("X-Foo-%d", i)); string(v) != expectedFoo {
+			t.Fatalf("unexpected header value %q. Expecting %q", v, expectedFoo)
+		}
+		if v := req.Header.Peek("X-Baz"); string(v) != "a-long-value-for-x-baz-header" {
+			t.Fatalf("unexpected header value %q. Expecting %q", v, "a-long-value-for-x-baz-header")
+		}
+		if v := req.Header.Peek("X-New"); string(v) != "new" {
+			t.Fatalf("unexpected header value %q. Expecting %q", v, "new")
+		}
+		args := req.URI().QueryArgs()
+		if v := args.Peek("x"); string(v) != fmt.Sprintf("%d", i) {
+			t.Fatalf("unexpected query arg %q. Expecting %q", v, fmt.Sprintf("%d", i))
+		}
+		if v := args.Peek("y"); string(v) != "a b" {
+			t.Fatalf("unexpected query arg %q. Expecting %q", v, "a b")
+		}
+		if v := args.Peek("added"); string(v) != "1" {
+			t.Fatalf("unexpected query arg %q. Expecting %q", v, "1")
+		}
+		expectedZ := fmt.Sprintf("%05d", i)
+		if v := req.PostArgs().Peek("z"); string(v) != expectedZ {
+			t.Fatalf("unexpected post arg %q. Expecting %q", v, expectedZ)
+		}
+		if v := req.Header.Peek(fmt.Sprintf("X-Foo-%d", i)); string(v) != expectedFoo {
+			t.Fatalf("unexpected header value %q. Expecting %q", v, expectedFoo)
+		}
+
+		var req1 Request
+		req.CopyTo(&req1)
+		req.Reset()
+		if v := req1.Header.Peek(fmt.Sprintf("X-Foo-%d", i)); string(v) != expectedFoo {
+			t.Fatalf("unexpected header value in the copy %q. Expecting %q", v, expectedFoo)
+		}
+	}
+}
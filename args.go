@@ -40,6 +40,9 @@ type Args struct {
 
 	args []argsKV
 	buf  []byte
+
+	// arena is used for parsed args if set.
+	arena *arena
 }
 
 type argsKV struct {
@@ -49,6 +52,10 @@ type argsKV struct {
 
 // Reset clears query args.
 func (a *Args) Reset() {
+	if a.arena != nil {
+		a.args = resetArenaArgs(a.args)
+		return
+	}
 	a.args = a.args[:0]
 }
 
@@ -83,6 +90,7 @@ func (a *Args) ParseBytes(b []byte) {
 
 	var s argsScanner
 	s.b = b
+	s.arena = a.arena
 
 	var kv *argsKV
 	a.args, kv = allocArg(a.args)
@@ -414,6 +422,16 @@ func peekArgStr(h []argsKV, k string) []byte {
 
 type argsScanner struct {
 	b []byte
+
+	// arena is used for decoded args if set.
+	arena *arena
+}
+
+func (s *argsScanner) decodeArg(dst, src []byte) []byte {
+	if s.arena != nil {
+		return s.arena.allocDecodedArg(src)
+	}
+	return decodeArgAppend(dst[:0], src)
 }
 
 func (s *argsScanner) next(kv *argsKV) bool {
@@ -428,15 +446,15 @@ func (s *argsScanner) next(kv *argsKV) bool {
 		case '=':
 			if isKey {
 				isKey = false
-				kv.key = decodeArgAppend(kv.key[:0], s.b[:i])
+				kv.key = s.decodeArg(kv.key, s.b[:i])
 				k = i + 1
 			}
 		case '&':
 			if isKey {
-				kv.key = decodeArgAppend(kv.key[:0], s.b[:i])
+				kv.key = s.decodeArg(kv.key, s.b[:i])
 				kv.value = kv.value[:0]
 			} else {
-				kv.value = decodeArgAppend(kv.value[:0], s.b[k:i])
+				kv.value = s.decodeArg(kv.value, s.b[k:i])
 			}
 			s.b = s.b[i+1:]
 			return true
@@ -444,10 +462,10 @@ func (s *argsScanner) next(kv *argsKV) bool {
 	}
 
 	if isKey {
-		kv.key = decodeArgAppend(kv.key[:0], s.b)
+		kv.key = s.decodeArg(kv.key, s.b)
 		kv.value = kv.value[:0]
 	} else {
-		kv.value = decodeArgAppend(kv.value[:0], s.b[k:])
+		kv.value = s.decodeArg(kv.value, s.b[k:])
 	}
 	s.b = s.b[len(s.b):]
 	return true
@@ -0,0 +1,74 @@
+package fasthttp
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// BackoffFunc returns the delay before the given retry attempt.
+//
+// attempt starts from 1 for the first retry.
+//
+// See HostClient.Backoff for details.
+type BackoffFunc func(attempt int) time.Duration
+
+// ExponentialBackoff returns BackoffFunc doubling the delay on each retry
+// starting from base until it reaches max.
+//
+// Wrap the returned BackoffFunc into FullJitter or EqualJitter,
+// so clients retrying simultaneously don't hit the host in waves.
+func ExponentialBackoff(base, max time.Duration) BackoffFunc {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// FullJitter returns BackoffFunc returning random delays in the range
+// [0..d), where d is the delay returned by b.
+func FullJitter(b BackoffFunc) BackoffFunc {
+	return func(attempt int) time.Duration {
+		d := b(attempt)
+		if d <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int63n(int64(d)))
+	}
+}
+
+// EqualJitter returns BackoffFunc returning random delays in the range
+// [d/2..d), where d is the delay returned by b.
+func EqualJitter(b BackoffFunc) BackoffFunc {
+	return func(attempt int) time.Duration {
+		d := b(attempt)
+		if d <= 1 {
+			return d
+		}
+		half := d / 2
+		return half + time.Duration(rand.Int63n(int64(d-half)))
+	}
+}
+
+// sleepBackoff sleeps for d or until ctx is canceled.
+//
+// It returns ctx error if ctx is canceled.
+func sleepBackoff(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := acquireTimer(d)
+	defer releaseTimer(t)
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,85 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff(100*time.Millisecond, time.Second)
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, d := range expected {
+		if v := b(i + 1); v != d {
+			t.Fatalf("unexpected delay for attempt %d: %s. Expecting %s", i+1, v, d)
+		}
+	}
+
+	full := FullJitter(b)
+	equal := EqualJitter(b)
+	for i := 0; i < 100; i++ {
+		if v := full(3); v < 0 || v >= 400*time.Millisecond {
+			t.Fatalf("unexpected full jitter delay: %s", v)
+		}
+		if v := equal(3); v < 200*time.Millisecond || v >= 400*time.Millisecond {
+			t.Fatalf("unexpected equal jitter delay: %s", v)
+		}
+	}
+}
+
+func TestHostClientBackoff(t *testing.T) {
+	var dials []time.Time
+	var attempts []int
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			dials = append(dials, time.Now())
+			return nil, errors.New("host is down")
+		},
+		MaxIdempotentRequestAttempts: 3,
+		RetryIf: func(req *Request, resp *Response, err error) bool {
+			return true
+		},
+		Backoff: func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return 50 * time.Millisecond
+		},
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/")
+	if err := c.Do(req, nil); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if len(dials) != 3 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 3", len(dials))
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Fatalf("unexpected backoff attempts: %v. Expecting [1 2]", attempts)
+	}
+	for i := 1; i < len(dials); i++ {
+		if d := dials[i].Sub(dials[i-1]); d < 50*time.Millisecond {
+			t.Fatalf("too small delay between attempts: %s", d)
+		}
+	}
+
+	// The delay is interrupted on context cancellation.
+	c.Backoff = func(attempt int) time.Duration {
+		return time.Hour
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.DoCtx(ctx, req, nil); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,62 @@
+package fasthttp
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+func acquireBrotliReader(r io.Reader) (*brotli.Reader, error) {
+	v := brotliReaderPool.Get()
+	if v == nil {
+		return brotli.NewReader(r), nil
+	}
+	zr := v.(*brotli.Reader)
+	if err := zr.Reset(r); err != nil {
+		return nil, err
+	}
+	return zr, nil
+}
+
+func releaseBrotliReader(zr *brotli.Reader) {
+	brotliReaderPool.Put(zr)
+}
+
+var brotliReaderPool sync.Pool
+
+// WriteUnbrotli writes brotli-decompressed p to w and returns the number
+// of uncompressed bytes written to w.
+func WriteUnbrotli(w io.Writer, p []byte) (int, error) {
+	r := &byteSliceReader{p}
+	zr, err := acquireBrotliReader(r)
+	if err != nil {
+		return 0, err
+	}
+	n, err := copyZeroAlloc(w, zr)
+	releaseBrotliReader(zr)
+	nn := int(n)
+	if int64(nn) != n {
+		return 0, fmt.Errorf("too much data unbrotlied: %d", n)
+	}
+	return nn, err
+}
+
+// AppendUnbrotliBytes appends brotli-decompressed src to dst and returns
+// the resulting dst.
+func AppendUnbrotliBytes(dst, src []byte) ([]byte, error) {
+	w := &byteSliceWriter{dst}
+	_, err := WriteUnbrotli(w, src)
+	return w.b, err
+}
+
+// AppendUnbrotliBytes appends brotli-decompressed src to dst and returns
+// the resulting dst.
+//
+// *DecompressLimitError is returned if the decompressed data exceeds l.
+func (l *DecompressLimits) AppendUnbrotliBytes(dst, src []byte) ([]byte, error) {
+	w := l.newWriter(dst, len(src))
+	_, err := WriteUnbrotli(w, src)
+	return w.bw.b, err
+}
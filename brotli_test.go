@@ -0,0 +1,66 @@
+package fasthttp
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func appendBrotliBytes(dst, src []byte, quality, lgwin int) []byte {
+	w := &byteSliceWriter{dst}
+	zw := brotli.NewWriterOptions(w, brotli.WriterOptions{
+		Quality: quality,
+		LGWin:   lgwin,
+	})
+	if _, err := zw.Write(src); err != nil {
+		panic(fmt.Sprintf("BUG: cannot brotli-compress data: %s", err))
+	}
+	if err := zw.Close(); err != nil {
+		panic(fmt.Sprintf("BUG: cannot brotli-compress data: %s", err))
+	}
+	return w.b
+}
+
+func TestUnbrotliBytes(t *testing.T) {
+	var text bytes.Buffer
+	words1 := []string{"time", "people", "world", "information", "company", "history", "service"}
+	words2 := []string{"Home", "About", "Contact", "Search", "News", "Products", "Support", "Help", "Login", "Register", "Privacy"}
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&text, "<li>line %d: the %s of the %s; %x</li>\n", i, words1[i%7], words2[i%11], i*i)
+	}
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 32*1024)
+	r.Read(random)
+	repeated := bytes.Repeat([]byte("foobar"), 10000)
+
+	for _, src := range [][]byte{nil, []byte("x"), text.Bytes(), random, repeated} {
+		for quality := brotli.BestSpeed; quality <= brotli.BestCompression; quality++ {
+			for lgwin := 10; lgwin <= 24; lgwin++ {
+				compressed := appendBrotliBytes(nil, src, quality, lgwin)
+				dst, err := AppendUnbrotliBytes([]byte("prefix"), compressed)
+				if err != nil {
+					t.Fatalf("unexpected error for quality=%d, lgwin=%d, len=%d: %s", quality, lgwin, len(src), err)
+				}
+				if !bytes.Equal(dst[len("prefix"):], src) || string(dst[:len("prefix")]) != "prefix" {
+					t.Fatalf("unexpected decompressed data for quality=%d, lgwin=%d, len=%d", quality, lgwin, len(src))
+				}
+			}
+		}
+	}
+}
+
+func TestUnbrotliBytesInvalid(t *testing.T) {
+	compressed := string(appendBrotliBytes(nil, bytes.Repeat([]byte("foobar"), 100), 11, 22))
+	for _, s := range []string{
+		"foobar",
+		compressed + "\x00",
+		compressed[:5] + "\xff" + compressed[6:],
+	} {
+		if _, err := AppendUnbrotliBytes(nil, []byte(s)); err == nil {
+			t.Fatalf("expecting non-nil error for %q", s)
+		}
+	}
+}
@@ -321,6 +321,23 @@ func hexCharUpper(c byte) byte {
 	return c - 10 + 'A'
 }
 
+// appendJSONString appends s as quoted JSON string to dst.
+func appendJSONString(dst, s []byte) []byte {
+	dst = append(dst, '"')
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			dst = append(dst, '\\', c)
+		case c < 0x20:
+			dst = append(dst, `\u00`...)
+			dst = append(dst, hexCharUpper(c>>4), hexCharUpper(c&0xf))
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, '"')
+}
+
 var hex2intTable = func() []byte {
 	b := make([]byte, 256)
 	for i := 0; i < 256; i++ {
@@ -363,13 +380,6 @@ var toUpperTable = func() [256]byte {
 	return a
 }()
 
-func lowercaseBytes(b []byte) {
-	for i := 0; i < len(b); i++ {
-		p := &b[i]
-		*p = toLowerTable[*p]
-	}
-}
-
 // b2s converts byte slice to a string without memory allocation.
 // See https://groups.google.com/forum/#!msg/Golang-Nuts/ENgbUzYvCuU/90yGx7GUAgAJ .
 //
@@ -6,3 +6,25 @@ const (
 	maxIntChars    = 9
 	maxHexIntChars = 7
 )
+
+// lowercaseBytes converts ASCII uppercase letters in b to lowercase.
+func lowercaseBytes(b []byte) {
+	for i := 0; i < len(b); i++ {
+		p := &b[i]
+		*p = toLowerTable[*p]
+	}
+}
+
+// caseInsensitiveEqual returns true if a and b are equal
+// ignoring the case of ASCII letters.
+func caseInsensitiveEqual(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if toLowerTable[a[i]] != toLowerTable[b[i]] {
+			return false
+		}
+	}
+	return true
+}
@@ -2,7 +2,67 @@
 
 package fasthttp
 
+import (
+	"encoding/binary"
+)
+
 const (
 	maxIntChars    = 18
 	maxHexIntChars = 15
 )
+
+const (
+	swarOnes      = 0x0101010101010101
+	swarHighBits  = 0x8080808080808080
+	swarLowBits   = 0x7f7f7f7f7f7f7f7f
+	swarBelowA    = (0x80 - 'A') * swarOnes
+	swarAboveZ    = (0x80 - 'Z' - 1) * swarOnes
+	swarLowerMask = swarHighBits >> 2
+)
+
+// lowercaseWord converts ASCII uppercase letters in the 8 bytes packed
+// into x to lowercase. Other bytes are left as is.
+func lowercaseWord(x uint64) uint64 {
+	heptets := x & swarLowBits
+	isUpper := (heptets + swarBelowA) &^ (heptets + swarAboveZ) &^ x & swarHighBits
+	return x | (isUpper >> 2 & swarLowerMask)
+}
+
+// lowercaseBytes converts ASCII uppercase letters in b to lowercase.
+//
+// b is processed in 8-byte words, which is substantially faster than
+// per-byte table lookups for typical header names and hosts.
+func lowercaseBytes(b []byte) {
+	for len(b) >= 8 {
+		x := binary.LittleEndian.Uint64(b)
+		binary.LittleEndian.PutUint64(b, lowercaseWord(x))
+		b = b[8:]
+	}
+	for i := 0; i < len(b); i++ {
+		p := &b[i]
+		*p = toLowerTable[*p]
+	}
+}
+
+// caseInsensitiveEqual returns true if a and b are equal
+// ignoring the case of ASCII letters.
+func caseInsensitiveEqual(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for len(a) >= 8 {
+		x := binary.LittleEndian.Uint64(a)
+		y := binary.LittleEndian.Uint64(b)
+		if x != y && lowercaseWord(x) != lowercaseWord(y) {
+			return false
+		}
+		a = a[8:]
+		b = b[8:]
+	}
+	for i := 0; i < len(a); i++ {
+		if toLowerTable[a[i]] != toLowerTable[b[i]] {
+			return false
+		}
+	}
+	return true
+}
@@ -258,6 +258,72 @@ func testParseUintSuccess(t *testing.T, s string, expectedN int) {
 	}
 }
 
+func TestLowercaseBytes(t *testing.T) {
+	testLowercaseBytes(t, "", "")
+	testLowercaseBytes(t, "foobar", "foobar")
+	testLowercaseBytes(t, "FooBar-BAZ@[`{", "foobar-baz@[`{")
+	testLowercaseBytes(t, "CONTENT-TYPE: TEXT/HTML", "content-type: text/html")
+	testLowercaseBytes(t, "ПРИВЕТ-WORLD", "ПРИВЕТ-world")
+
+	// Verify all the byte values at all the positions inside a word.
+	for i := 0; i < 256; i++ {
+		for pos := 0; pos < 16; pos++ {
+			b := []byte("ABCDEFGHIJKLMNOPQ")
+			b[pos] = byte(i)
+			expected := make([]byte, len(b))
+			for j, c := range b {
+				expected[j] = toLowerTable[c]
+			}
+			lowercaseBytes(b)
+			if !bytes.Equal(b, expected) {
+				t.Fatalf("unexpected lowercaseBytes result for byte %d at position %d: %q. Expecting %q", i, pos, b, expected)
+			}
+		}
+	}
+}
+
+func testLowercaseBytes(t *testing.T, s, expectedS string) {
+	b := []byte(s)
+	lowercaseBytes(b)
+	if string(b) != expectedS {
+		t.Fatalf("unexpected lowercaseBytes(%q)=%q. Expecting %q", s, b, expectedS)
+	}
+}
+
+func TestCaseInsensitiveEqual(t *testing.T) {
+	testCaseInsensitiveEqual(t, "", "", true)
+	testCaseInsensitiveEqual(t, "foo", "FOO", true)
+	testCaseInsensitiveEqual(t, "foo", "fo", false)
+	testCaseInsensitiveEqual(t, "Keep-Alive", "keep-alive", true)
+	testCaseInsensitiveEqual(t, "application/JSON; charset=UTF-8", "Application/json; Charset=utf-8", true)
+	testCaseInsensitiveEqual(t, "application/json; charset=utf-8", "application/json; charset=utf-9", false)
+	testCaseInsensitiveEqual(t, "@[`{", "`{@[", false)
+	testCaseInsensitiveEqual(t, "ПРИВЕТ", "привет", false)
+
+	// Verify all the byte pairs inside a word.
+	for i := 0; i < 256; i++ {
+		for j := 0; j < 256; j++ {
+			a := []byte("abcdefgh")
+			b := []byte("ABCDEFGH")
+			a[3] = byte(i)
+			b[3] = byte(j)
+			expected := toLowerTable[i] == toLowerTable[j]
+			if caseInsensitiveEqual(a, b) != expected {
+				t.Fatalf("unexpected caseInsensitiveEqual(%q, %q). Expecting %v", a, b, expected)
+			}
+		}
+	}
+}
+
+func testCaseInsensitiveEqual(t *testing.T, a, b string, expected bool) {
+	if caseInsensitiveEqual([]byte(a), []byte(b)) != expected {
+		t.Fatalf("unexpected caseInsensitiveEqual(%q, %q). Expecting %v", a, b, expected)
+	}
+	if caseInsensitiveEqual([]byte(b), []byte(a)) != expected {
+		t.Fatalf("unexpected caseInsensitiveEqual(%q, %q). Expecting %v", b, a, expected)
+	}
+}
+
 func TestAppendUnquotedArg(t *testing.T) {
 	testAppendUnquotedArg(t, "", "")
 	testAppendUnquotedArg(t, "abc", "abc")
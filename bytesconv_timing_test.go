@@ -121,6 +121,18 @@ func BenchmarkAppendUint(b *testing.B) {
 	})
 }
 
+func BenchmarkCaseInsensitiveEqual(b *testing.B) {
+	s1 := []byte("Application/JSON; Charset=UTF-8")
+	s2 := []byte("application/json; charset=utf-8")
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if !caseInsensitiveEqual(s1, s2) {
+				b.Fatalf("unexpected result for caseInsensitiveEqual(%q, %q)", s1, s2)
+			}
+		}
+	})
+}
+
 func BenchmarkLowercaseBytesNoop(b *testing.B) {
 	src := []byte("foobarbaz_lowercased_all")
 	b.RunParallel(func(pb *testing.PB) {
@@ -0,0 +1,276 @@
+package fasthttp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker configures HostClient for failing fast on requests
+// to an unhealthy host instead of waiting for dial and read timeouts.
+//
+// The circuit opens when the host fails MaxConsecutiveFailures requests
+// in a row or when the share of failed requests during Window exceeds
+// MaxErrorRate. Requests fail with *CircuitOpenError without being sent
+// while the circuit is open. HalfOpenRequests probe requests are sent
+// to the host after OpenDuration. The circuit closes if they succeed
+// and opens again otherwise.
+//
+// CircuitBreaker may be shared among HostClients, since the circuit state
+// is tracked by each HostClient individually.
+type CircuitBreaker struct {
+	// The number of consecutive failed requests opening the circuit.
+	//
+	// By default consecutive failures don't open the circuit.
+	MaxConsecutiveFailures int
+
+	// The share of failed requests during Window in the range (0..1]
+	// opening the circuit.
+	//
+	// By default the error rate doesn't open the circuit.
+	MaxErrorRate float64
+
+	// The minimum number of requests during Window required
+	// for MaxErrorRate check.
+	//
+	// By default 20 requests are required.
+	MinRequests int
+
+	// The duration of the window for MaxErrorRate check.
+	//
+	// By default 10 seconds window is used.
+	Window time.Duration
+
+	// The duration the circuit stays open before probe requests are sent.
+	//
+	// By default the circuit stays open for 5 seconds.
+	OpenDuration time.Duration
+
+	// The number of probe requests, which must succeed in order
+	// to close the circuit.
+	//
+	// By default a single probe request is sent.
+	HalfOpenRequests int
+
+	// Callback deciding whether the request failed.
+	//
+	// It is called with the error returned by the request attempt.
+	// resp may contain the response if err is nil, so 5xx responses
+	// may be treated as failures.
+	//
+	// By default only requests returning errors are failures.
+	IsFailure func(resp *Response, err error) bool
+}
+
+func (cb *CircuitBreaker) minRequests() int {
+	if cb.MinRequests <= 0 {
+		return 20
+	}
+	return cb.MinRequests
+}
+
+func (cb *CircuitBreaker) window() time.Duration {
+	if cb.Window <= 0 {
+		return 10 * time.Second
+	}
+	return cb.Window
+}
+
+func (cb *CircuitBreaker) openDuration() time.Duration {
+	if cb.OpenDuration <= 0 {
+		return 5 * time.Second
+	}
+	return cb.OpenDuration
+}
+
+func (cb *CircuitBreaker) halfOpenRequests() int {
+	if cb.HalfOpenRequests <= 0 {
+		return 1
+	}
+	return cb.HalfOpenRequests
+}
+
+func (cb *CircuitBreaker) isFailure(resp *Response, err error) bool {
+	if cb.IsFailure != nil {
+		return cb.IsFailure(resp, err)
+	}
+	return err != nil
+}
+
+// CircuitOpenError is returned by HostClient when the request isn't sent,
+// since the circuit for the host is open.
+//
+// See CircuitBreaker for details.
+type CircuitOpenError struct {
+	// Addr is the address of the host.
+	Addr string
+
+	// RetryAfter is the duration until the probe requests are allowed.
+	RetryAfter time.Duration
+}
+
+// Error implements error interface.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("the circuit for %q is open; retry after %s", e.Addr, e.RetryAfter)
+}
+
+// CircuitState is the state of the circuit for the host.
+type CircuitState int
+
+// Circuit states returned by HostClient.CircuitState.
+const (
+	// CircuitClosed means requests are sent to the host.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen means requests fail with *CircuitOpenError.
+	CircuitOpen
+
+	// CircuitHalfOpen means probe requests are sent to the host.
+	CircuitHalfOpen
+)
+
+// String returns human-readable circuit state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitState returns the state of the circuit for the host.
+//
+// CircuitClosed is always returned if HostClient.CircuitBreaker isn't set.
+func (c *HostClient) CircuitState() CircuitState {
+	c.circuit.lock.Lock()
+	state := c.circuit.state
+	if state == CircuitOpen && !time.Now().Before(c.circuit.openUntil) {
+		state = CircuitHalfOpen
+	}
+	c.circuit.lock.Unlock()
+	return state
+}
+
+// circuit tracks the circuit state for HostClient.
+type circuit struct {
+	lock sync.Mutex
+
+	state     CircuitState
+	openUntil time.Time
+
+	consecutiveFailures int
+
+	windowStart    time.Time
+	windowRequests int
+	windowFailures int
+
+	probesInFlight int
+	probeSuccesses int
+}
+
+// allow returns non-nil error if the request mustn't be sent.
+//
+// isProbe is set if the request is a probe request in half-open state.
+func (ct *circuit) allow(cb *CircuitBreaker, addr string, t time.Time) (isProbe bool, err error) {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	switch ct.state {
+	case CircuitClosed:
+		return false, nil
+	case CircuitOpen:
+		if t.Before(ct.openUntil) {
+			return false, &CircuitOpenError{
+				Addr:       addr,
+				RetryAfter: ct.openUntil.Sub(t),
+			}
+		}
+		ct.state = CircuitHalfOpen
+		ct.probeSuccesses = 0
+	}
+
+	// Half-open state.
+	if ct.probesInFlight+ct.probeSuccesses >= cb.halfOpenRequests() {
+		return false, &CircuitOpenError{
+			Addr: addr,
+		}
+	}
+	ct.probesInFlight++
+	return true, nil
+}
+
+// record registers the result of the request allowed by allow.
+func (ct *circuit) record(cb *CircuitBreaker, isProbe, failed bool, t time.Time) {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	if isProbe {
+		ct.probesInFlight--
+		if ct.state != CircuitHalfOpen {
+			return
+		}
+		if failed {
+			ct.open(cb, t)
+			return
+		}
+		ct.probeSuccesses++
+		if ct.probeSuccesses >= cb.halfOpenRequests() {
+			ct.close(t)
+		}
+		return
+	}
+	if ct.state != CircuitClosed {
+		// The request has been started before the circuit opened.
+		return
+	}
+
+	if t.Sub(ct.windowStart) > cb.window() {
+		ct.windowStart = t
+		ct.windowRequests = 0
+		ct.windowFailures = 0
+	}
+	ct.windowRequests++
+	if !failed {
+		ct.consecutiveFailures = 0
+		return
+	}
+	ct.windowFailures++
+	ct.consecutiveFailures++
+
+	if cb.MaxConsecutiveFailures > 0 && ct.consecutiveFailures >= cb.MaxConsecutiveFailures {
+		ct.open(cb, t)
+		return
+	}
+	if cb.MaxErrorRate > 0 && ct.windowRequests >= cb.minRequests() &&
+		float64(ct.windowFailures) >= cb.MaxErrorRate*float64(ct.windowRequests) {
+		ct.open(cb, t)
+	}
+}
+
+// cancel releases the request allowed by allow without recording its result.
+func (ct *circuit) cancel(isProbe bool) {
+	if !isProbe {
+		return
+	}
+	ct.lock.Lock()
+	ct.probesInFlight--
+	ct.lock.Unlock()
+}
+
+func (ct *circuit) open(cb *CircuitBreaker, t time.Time) {
+	ct.state = CircuitOpen
+	ct.openUntil = t.Add(cb.openDuration())
+}
+
+func (ct *circuit) close(t time.Time) {
+	ct.state = CircuitClosed
+	ct.consecutiveFailures = 0
+	ct.windowStart = t
+	ct.windowRequests = 0
+	ct.windowFailures = 0
+}
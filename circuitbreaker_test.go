@@ -0,0 +1,133 @@
+package fasthttp
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func TestHostClientCircuitBreaker(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetBodyString("ok")
+		},
+	}
+	go s.Serve(ln)
+
+	var dials uint32
+	var healthy uint32
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			atomic.AddUint32(&dials, 1)
+			if atomic.LoadUint32(&healthy) == 0 {
+				return nil, errors.New("host is down")
+			}
+			return ln.Dial()
+		},
+		MaxIdempotentRequestAttempts: 1,
+		CircuitBreaker: &CircuitBreaker{
+			MaxConsecutiveFailures: 3,
+			OpenDuration:           100 * time.Millisecond,
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := c.Get(nil, "http://foobar/"); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+	if c.CircuitState() != CircuitOpen {
+		t.Fatalf("unexpected circuit state: %s. Expecting %s", c.CircuitState(), CircuitOpen)
+	}
+	_, _, err := c.Get(nil, "http://foobar/")
+	coe, ok := err.(*CircuitOpenError)
+	if !ok {
+		t.Fatalf("unexpected error: %v. Expecting *CircuitOpenError", err)
+	}
+	if coe.Addr != "foobar" || coe.RetryAfter <= 0 {
+		t.Fatalf("unexpected error: %+v", coe)
+	}
+	if n := atomic.LoadUint32(&dials); n != 3 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 3", n)
+	}
+
+	// The failed probe opens the circuit again.
+	time.Sleep(150 * time.Millisecond)
+	if c.CircuitState() != CircuitHalfOpen {
+		t.Fatalf("unexpected circuit state: %s. Expecting %s", c.CircuitState(), CircuitHalfOpen)
+	}
+	if _, _, err = c.Get(nil, "http://foobar/"); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if _, _, err = c.Get(nil, "http://foobar/"); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if _, ok = err.(*CircuitOpenError); !ok {
+		t.Fatalf("unexpected error: %v. Expecting *CircuitOpenError", err)
+	}
+	if n := atomic.LoadUint32(&dials); n != 4 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 4", n)
+	}
+
+	// The successful probe closes the circuit.
+	atomic.StoreUint32(&healthy, 1)
+	time.Sleep(150 * time.Millisecond)
+	statusCode, body, err := c.Get(nil, "http://foobar/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK || string(body) != "ok" {
+		t.Fatalf("unexpected response: %d %q", statusCode, body)
+	}
+	if c.CircuitState() != CircuitClosed {
+		t.Fatalf("unexpected circuit state: %s. Expecting %s", c.CircuitState(), CircuitClosed)
+	}
+}
+
+func TestCircuitErrorRate(t *testing.T) {
+	cb := &CircuitBreaker{
+		MaxErrorRate: 0.5,
+		MinRequests:  4,
+		Window:       time.Second,
+		IsFailure: func(resp *Response, err error) bool {
+			return err != nil || resp.StatusCode() >= 500
+		},
+	}
+	var ct circuit
+	now := time.Now()
+
+	var resp Response
+	resp.SetStatusCode(StatusInternalServerError)
+	record := func(failed bool) {
+		t.Helper()
+		if _, err := ct.allow(cb, "foobar", now); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ct.record(cb, false, failed, now)
+	}
+
+	// Failures outside the window are forgotten.
+	record(cb.isFailure(&resp, nil))
+	now = now.Add(2 * time.Second)
+	record(false)
+	record(cb.isFailure(&resp, nil))
+	record(false)
+	if ct.state != CircuitClosed {
+		t.Fatalf("unexpected circuit state: %s. Expecting %s", ct.state, CircuitClosed)
+	}
+	record(cb.isFailure(nil, errors.New("error")))
+	if ct.state != CircuitOpen {
+		t.Fatalf("unexpected circuit state: %s. Expecting %s", ct.state, CircuitOpen)
+	}
+	if _, err := ct.allow(cb, "foobar", now); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+}
@@ -3,6 +3,7 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -24,7 +26,8 @@ import (
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get* for following
+// redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -37,6 +40,31 @@ func Do(req *Request, resp *Response) error {
 	return defaultClient.Do(req, resp)
 }
 
+// DoCtx performs the given http request and fills the given http response.
+//
+// The request is aborted when ctx is canceled.
+//
+// See Client.DoCtx for details.
+func DoCtx(ctx context.Context, req *Request, resp *Response) error {
+	return defaultClient.DoCtx(ctx, req, resp)
+}
+
+// DoRedirects performs the given http request and fills the given http response,
+// following up to maxRedirectsCount redirects.
+//
+// See Client.DoRedirects for details.
+func DoRedirects(req *Request, resp *Response, maxRedirectsCount int) error {
+	return defaultClient.DoRedirects(req, resp, maxRedirectsCount)
+}
+
+// DoRedirectsChain works like DoRedirects, but also appends the followed
+// redirects to dst and returns the extended dst.
+//
+// See Client.DoRedirectsChain for details.
+func DoRedirectsChain(dst []RedirectHop, req *Request, resp *Response, maxRedirectsCount int) ([]RedirectHop, error) {
+	return defaultClient.DoRedirectsChain(dst, req, resp, maxRedirectsCount)
+}
+
 // DoTimeout performs the given request and waits for response during
 // the given timeout duration.
 //
@@ -48,7 +76,8 @@ func Do(req *Request, resp *Response) error {
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get* for following
+// redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -75,7 +104,8 @@ func DoTimeout(req *Request, resp *Response, timeout time.Duration) error {
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get* for following
+// redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -157,31 +187,195 @@ type Client struct {
 	// Default Dial is used if not set.
 	Dial DialFunc
 
+	// Callback for establishing new connections to hosts, which may be
+	// canceled via ctx passed to DoCtx.
+	//
+	// DialCtx has precedence over Dial.
+	//
+	// Default dialer is used if neither Dial nor DialCtx is set.
+	DialCtx DialCtxFunc
+
+	// Timeout for each connection attempt made by the default dialer.
+	//
+	// The default dialer tries all the addresses the host resolves to,
+	// so a single unreachable address cannot consume the whole request
+	// timeout. Note that the timeout is applied per address, so dialing
+	// may take up to DialTimeout multiplied by the number of addresses.
+	// Use DoTimeout, DoDeadline or DoCtx for limiting the total duration.
+	//
+	// DefaultDialTimeout is used by default.
+	DialTimeout time.Duration
+
+	// Unix socket paths for request hosts.
+	//
+	// Requests to the given hosts are sent over unix sockets instead
+	// of TCP. For example, {"docker": "/var/run/docker.sock"} routes
+	// http://docker/v1.41/info requests to the Docker API socket.
+	// Dial and DialCtx aren't used for such hosts.
+	//
+	// Requests with unix scheme are sent over unix sockets too,
+	// see Do for details.
+	//
+	// Redirects from other hosts to these hosts and to uris with unix
+	// scheme aren't followed, so remote servers cannot reach the sockets.
+	//
+	// By default only requests with unix scheme use unix sockets.
+	UnixSockets map[string]string
+
+	// Timeout for TLS handshake with the host.
+	//
+	// The handshake is performed right after the connection is established,
+	// so slow handshakes don't consume WriteTimeout and ReadTimeout
+	// of the first request. Connections with timed out handshakes fail
+	// with ErrTLSHandshakeTimeout.
+	//
+	// By default the handshake is performed when the first request
+	// is written to the connection.
+	TLSHandshakeTimeout time.Duration
+
+	// Requires and verifies OCSP responses stapled by TLS hosts
+	// if set to true.
+	//
+	// The connection is closed if the host doesn't staple OCSP response
+	// for its certificate, if the response isn't signed by the certificate
+	// issuer or by its valid delegated responder, if the response is expired
+	// or if OCSPPolicy rejects the response. The connection is also closed
+	// if the host certificate chain isn't verified, i.e. if
+	// TLSConfig.InsecureSkipVerify is set.
+	//
+	// By default stapled OCSP responses aren't verified.
+	RequireOCSPStapling bool
+
+	// Callback implementing custom revocation policy for verified
+	// OCSP responses if RequireOCSPStapling is set.
+	//
+	// By default only certificates with OCSPGood status are accepted.
+	OCSPPolicy OCSPPolicyFunc
+
+	// Serialized ECHConfigList for Encrypted Client Hello with TLS hosts.
+	//
+	// The list is usually obtained from the HTTPS DNS record of the host.
+	// ECH hides the requested host name and other sensitive TLS handshake
+	// fields from network observers. The connection fails if the host
+	// rejects ECH - see ECHRetryConfigList for obtaining the config list
+	// suggested by the host in this case. Requests fail with
+	// ErrECHUnsupported if crypto/tls doesn't support ECH.
+	//
+	// By default ECH isn't used.
+	ECHConfigList []byte
+
 	// Attempt to connect to both ipv4 and ipv6 addresses if set to true.
 	//
 	// This option is used only if default TCP dialer is used,
-	// i.e. if Dial is blank.
+	// i.e. if Dial and DialCtx are blank. Addresses are dialed according
+	// to Happy Eyeballs algorithm. See DialDualStackContext for details.
 	//
 	// By default client connects only to ipv4 addresses,
 	// since unfortunately ipv6 remains broken in many networks worldwide :)
 	DialDualStack bool
 
+	// Resolver for host names used by the default dialer.
+	//
+	// This option is used only if default TCP dialer is used,
+	// i.e. if Dial and DialCtx are blank.
+	//
+	// net.DefaultResolver is used by default.
+	Resolver Resolver
+
+	// Duration for caching addresses resolved by the default dialer.
+	//
+	// Cached addresses may be removed before they expire
+	// via FlushDNSCache.
+	//
+	// DefaultDNSCacheDuration is used by default.
+	DNSCacheDuration time.Duration
+
+	// Callback for setting socket options on each dialed connection
+	// before it is used.
+	//
+	// The connection is closed if ConnControl returns an error.
+	//
+	// Socket options aren't set by default.
+	ConnControl ConnControlFunc
+
 	// TLS config for https connections.
 	//
+	// The config is cloned per host on the first use, so later changes
+	// to the config aren't applied to hosts already in use. Call
+	// SetTLSConfig for replacing the config, e.g. with rotated client
+	// certificates. Alternatively, tls.Config.GetClientCertificate may
+	// provide fresh client certificates on each handshake.
+	//
 	// Default TLS config is used if not set.
 	TLSConfig *tls.Config
 
+	// Whether to negotiate HTTP/2 via ALPN for https connections.
+	//
+	// See HostClient.EnableHTTP2 for details.
+	//
+	// By default HTTP/1.1 is used.
+	EnableHTTP2 bool
+
+	// Whether to use cleartext HTTP/2 (aka h2c) with prior knowledge
+	// for http connections.
+	//
+	// All the hosts requested over http must accept HTTP/2 connections,
+	// since there is no fallback to HTTP/1.1. See HostClient.EnableH2C
+	// for details.
+	//
+	// By default HTTP/1.1 is used for http connections.
+	EnableH2C bool
+
 	// Maximum number of connections per each host which may be established.
 	//
 	// DefaultMaxConnsPerHost is used if not set.
 	MaxConnsPerHost int
 
+	// Maximum duration for waiting for a free connection when
+	// MaxConnsPerHost connections to the host are busy.
+	//
+	// Waiting requests obtain free connections in FIFO order.
+	//
+	// By default ErrNoFreeConns is returned immediately.
+	MaxConnWaitTimeout time.Duration
+
 	// Idle keep-alive connections are closed after this duration.
 	//
 	// By default idle connections are closed
 	// after DefaultMaxIdleConnDuration.
 	MaxIdleConnDuration time.Duration
 
+	// Interval between runs of the background cleaner, which closes idle
+	// connections exceeding MaxIdleConnDuration and forgets hosts without
+	// requests during the last minute.
+	//
+	// Use SweepIdleConns for running the cleanup immediately.
+	//
+	// By default idle connections are checked every MaxIdleConnDuration,
+	// while idle hosts are checked every 10 seconds.
+	IdleCleanupInterval time.Duration
+
+	// Callback called when a keep-alive connection to the host is closed
+	// by the client due to the given reason.
+	//
+	// It allows finding out the cause of connection churn, e.g.
+	// correlating 'Connection: close' responses with backend restarts.
+	// remoteAddr is the address of the host the connection is established to.
+	// Connections closed due to errors aren't reported.
+	//
+	// By default evicted connections aren't reported.
+	OnConnEvicted func(remoteAddr string, reason ConnEvictionReason)
+
+	// Dial errors are cached for this duration if set.
+	//
+	// Requests to the address, which failed to dial during the last
+	// DialFailureCacheDuration, fail fast with the cached error instead
+	// of re-dialing the address. Use ResetDialFailures for clearing
+	// the cache.
+	//
+	// By default dial errors aren't cached.
+	DialFailureCacheDuration time.Duration
+
 	// Per-connection buffer size for responses' reading.
 	// This also limits the maximum header size.
 	//
@@ -195,18 +389,34 @@ type Client struct {
 
 	// Maximum duration for full response reading (including body).
 	//
+	// It may be overridden for distinct requests via Request.SetReadTimeout.
+	//
 	// By default response read timeout is unlimited.
 	ReadTimeout time.Duration
 
 	// Maximum duration for full request writing (including body).
 	//
+	// It may be overridden for distinct requests via Request.SetWriteTimeout.
+	//
 	// By default request write timeout is unlimited.
 	WriteTimeout time.Duration
 
+	// Maximum duration for waiting for 100 Continue response before
+	// sending the body of the request with 'Expect: 100-continue' header.
+	//
+	// The body isn't sent if the host responds with the final response
+	// instead of 100 Continue, e.g. if it rejects the request. The body
+	// is sent after the timeout if the host doesn't support 100-continue.
+	//
+	// By default the client waits for 100 Continue response for a second.
+	ContinueTimeout time.Duration
+
 	// Maximum response body size.
 	//
 	// The client returns ErrBodyTooLarge if this limit is greater than 0
-	// and response body is greater than the limit.
+	// and response body is greater than the limit. Body reading is aborted
+	// as soon as the limit is exceeded, so responses with too big
+	// Content-Length aren't read at all, and the connection is closed.
 	//
 	// By default response body size is unlimited.
 	MaxResponseBodySize int
@@ -214,9 +424,205 @@ type Client struct {
 	// The maximum number of idempotent requests the client can make.
 	MaxIdempotentRequestAttempts int
 
-	mLock sync.Mutex
-	m     map[string]*HostClient
-	ms    map[string]*HostClient
+	// Callback deciding whether the failed request must be retried.
+	//
+	// It is called with the error returned by the failed attempt.
+	// resp may be nil. The number of attempts is still limited
+	// by MaxIdempotentRequestAttempts. Requests with body streams
+	// and requests canceled via context are never retried.
+	//
+	// By default idempotent requests are retried on connection errors,
+	// while non-idempotent requests are retried only if the server
+	// closes the connection before sending the response.
+	RetryIf RetryIfFunc
+
+	// Callback returning the delay before retrying the failed request.
+	//
+	// The delay is interrupted if the context passed to DoCtx is canceled.
+	// See ExponentialBackoff, FullJitter and EqualJitter for ready-to-use
+	// strategies.
+	//
+	// By default failed requests are retried immediately.
+	Backoff BackoffFunc
+
+	// Callback receiving request and response sizes and duration
+	// for each successful request attempt.
+	//
+	// SizeStats.Label contains the host the request is sent to.
+	//
+	// By default request sizes aren't measured.
+	SizeStatsHandler SizeStatsHandler
+
+	// Hooks called in the order of appearance before each request attempt
+	// is sent, e.g. for setting auth or tracing headers.
+	//
+	// The request isn't sent and the hook error is returned to the caller
+	// if a hook returns an error. Hooks are called for each attempt,
+	// so they must be idempotent.
+	//
+	// By default requests are sent as is.
+	OnRequest []RequestHook
+
+	// Policy for header keys and values with CR or LF in requests
+	// sent by the client.
+	//
+	// The policy overrides the policy of the request header before
+	// OnRequest hooks are called, so it applies to headers set by the hooks,
+	// DefaultHeaders and SignRequest. Use RequestHeader.SetInjectionPolicy
+	// for headers set before sending the request.
+	//
+	// By default CR and LF are stripped (HeaderInjectionStrip).
+	HeaderInjectionPolicy HeaderInjectionPolicy
+
+	// Headers added to each request, which doesn't contain them,
+	// e.g. User-Agent, Authorization or tracing headers.
+	//
+	// Headers set in the request or by OnRequest hooks take precedence.
+	// The added headers are removed from the request after it is sent,
+	// so the request may be reused.
+	//
+	// By default no headers are added.
+	DefaultHeaders map[string]string
+
+	// Callback signing the request, e.g. with AWS SigV4 or HMAC signature.
+	//
+	// It is called for each request attempt after Host, Content-Length,
+	// User-Agent and other headers added by the client are set, just before
+	// the request header is written to the connection, so the signature
+	// covers the exact headers sent. The request isn't sent and the error
+	// is returned to the caller if the callback returns an error.
+	//
+	// By default requests aren't signed.
+	SignRequest RequestHook
+
+	// Hooks called in the order of appearance after the response
+	// is read, e.g. for logging.
+	//
+	// Hooks are called before the response body is read if the body
+	// is streamed. The hook error is returned to the caller.
+	//
+	// By default responses are returned as is.
+	OnResponse []ResponseHook
+
+	// Callback validating the response before the connection it has been
+	// read from is returned to the pool, e.g. for rejecting responses
+	// with suspicious framing in proxies.
+	//
+	// The connection is closed and the error is returned to the caller
+	// if the callback returns an error. Call resp.SetConnectionClose()
+	// for closing the connection without failing the request. The callback
+	// may modify resp, e.g. set 'Cache-Control: no-store' header for
+	// marking the response as non-cacheable. The callback is called
+	// before the response body is read if the body is streamed.
+	//
+	// By default responses aren't validated.
+	ValidateResponse ValidateResponseFunc
+
+	// Circuit breaker for failing fast on requests to unhealthy hosts.
+	//
+	// The circuit state is tracked for each host individually.
+	// See CircuitBreaker for details.
+	//
+	// By default requests are always sent to the host.
+	CircuitBreaker *CircuitBreaker
+
+	// The maximum number of requests per second sent to each host.
+	//
+	// Requests exceeding the limit wait until they may be sent
+	// or until the context passed to DoCtx is canceled. Retries
+	// are limited too.
+	//
+	// By default requests aren't rate limited.
+	RateLimit float64
+
+	// The maximum number of requests, which may be sent to each host
+	// at once without waiting, after the host has been idle.
+	//
+	// The burst is applied only if RateLimit is set.
+	//
+	// By default a single request may be sent at once.
+	RateLimitBurst int
+
+	// Policy for following redirects by Do, DoTimeout, DoDeadline and DoCtx.
+	//
+	// Get* functions follow redirects according to the policy too.
+	//
+	// By default Do* functions don't follow redirects, while Get* functions
+	// follow up to 16 301, 302 and 303 redirects.
+	RedirectPolicy *RedirectPolicy
+
+	// Policy for response bodies with the length distinct
+	// from Content-Length.
+	//
+	// See BodyLengthMismatchPolicy for details. Connections with mismatched
+	// bodies are closed.
+	//
+	// By default io.ErrUnexpectedEOF is returned for short bodies,
+	// while excess bytes after the body are ignored.
+	BodyLengthMismatchPolicy BodyLengthMismatchPolicy
+
+	// Streams response bodies delimited by connection close to the caller
+	// if set to true.
+	//
+	// Such responses have neither Content-Length nor chunked
+	// Transfer-Encoding. They are sent by some legacy servers. The body
+	// may be read via Response.BodyWriteTo or Response.Body.
+	// The connection is closed after the response is reset or released,
+	// so do not forget releasing such responses. MaxResponseBodySize
+	// and ReadTimeout apply to the streamed body.
+	//
+	// By default such bodies are read into memory before returning
+	// the response.
+	StreamCloseDelimitedBody bool
+
+	// Streams all the response bodies to the caller if set to true.
+	//
+	// The response is returned right after reading response headers,
+	// so huge bodies may be read incrementally via Response.BodyWriteTo
+	// instead of being loaded into memory. Response.Body reads the whole
+	// body into memory. The connection is returned to the pool only after
+	// the response body is read till the end and the response is reset
+	// or released, so do not forget releasing such responses.
+	// MaxResponseBodySize and ReadTimeout apply to the streamed body.
+	//
+	// By default response bodies are read into memory before returning
+	// the response.
+	StreamResponseBody bool
+
+	// Transparently decompresses response bodies compressed with gzip,
+	// deflate, br or zstd if set to true.
+	//
+	// 'Accept-Encoding: gzip, deflate, br, zstd' header is sent for requests
+	// without 'Accept-Encoding' header. 'Content-Encoding' header is removed
+	// from decompressed responses. Responses with other encodings
+	// are returned as is. MaxResponseBodySize limits the decompressed body
+	// size too. Streamed response bodies aren't decompressed.
+	//
+	// By default response bodies are returned as sent by the server.
+	DecompressResponseBody bool
+
+	// Limits for decompressing response bodies.
+	//
+	// The limits are applied to DecompressResponseBody and to
+	// Response.BodyGunzip, BodyInflate, BodyUnbrotli and BodyUnzstd* calls
+	// on the responses read by the client.
+	//
+	// By default decompressed response bodies are limited only
+	// by MaxResponseBodySize if DecompressResponseBody is set.
+	DecompressLimits *DecompressLimits
+
+	// Interns frequent response header values such as Content-Type
+	// if set to true.
+	//
+	// See ResponseHeader.SetInternValues for details.
+	//
+	// Response header values are copied by default.
+	InternHeaderValues bool
+
+	mLock  sync.Mutex
+	m      map[string]*HostClient
+	ms     map[string]*HostClient
+	dialer *tcpDialer
 }
 
 // Get appends url contents to dst and returns it as body.
@@ -276,7 +682,8 @@ func (c *Client) Post(dst []byte, url string, postArgs *Args) (statusCode int, b
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects unless Client.RedirectPolicy is set.
+// Use DoRedirects or Get* for following redirects otherwise.
 //
 // Response is ignored if resp is nil.
 //
@@ -303,7 +710,8 @@ func (c *Client) DoTimeout(req *Request, resp *Response, timeout time.Duration)
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects unless Client.RedirectPolicy is set.
+// Use DoRedirects or Get* for following redirects otherwise.
 //
 // Response is ignored if resp is nil.
 //
@@ -329,9 +737,19 @@ func (c *Client) DoDeadline(req *Request, resp *Response, deadline time.Time) er
 //   - from RequestURI if it contains full url with scheme and host;
 //   - from Host header otherwise.
 //
+// Requests with unix scheme are sent over unix socket. The socket path
+// is the uri path up to the first colon, while the request path follows
+// the colon, e.g. unix:///var/run/docker.sock:/v1.41/info sends
+// /v1.41/info request to /var/run/docker.sock. The request path
+// defaults to /. The request is sent with http://localhost uri and
+// the request path. Redirects to the same host are followed over
+// the socket. req retains the unix uri after the call unless redirects
+// lead it to another host.
+//
 // Response is ignored if resp is nil.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects unless Client.RedirectPolicy is set.
+// Use DoRedirects or Get* for following redirects otherwise.
 //
 // ErrNoFreeConns is returned if all Client.MaxConnsPerHost connections
 // to the requested host are busy.
@@ -339,6 +757,123 @@ func (c *Client) DoDeadline(req *Request, resp *Response, deadline time.Time) er
 // It is recommended obtaining req and resp via AcquireRequest
 // and AcquireResponse in performance-critical code.
 func (c *Client) Do(req *Request, resp *Response) error {
+	return c.DoCtx(context.Background(), req, resp)
+}
+
+// DoCtx performs the given http request and fills the given http response.
+//
+// The request is aborted when ctx is canceled, including connection
+// dialing, TLS handshake, request sending and response reading.
+// ctx.Err() is returned in this case. The connection used by the aborted
+// request is closed. Body stream of the response isn't controlled by ctx.
+//
+// See Do for details.
+func (c *Client) DoCtx(ctx context.Context, req *Request, resp *Response) error {
+	unixSocket, err := rewriteUnixRequestURI(req)
+	if err != nil {
+		return err
+	}
+	if len(unixSocket) > 0 {
+		defer restoreUnixRequestURI(req)
+	}
+	hc, err := c.hostClient(req, unixSocket)
+	if err != nil {
+		return err
+	}
+	if p := c.RedirectPolicy; p != nil {
+		_, err = hc.doRedirects(ctx, nil, req, resp, p.maxRedirects(), p)
+		return err
+	}
+	return hc.DoCtx(ctx, req, resp)
+}
+
+// DoWithBodyWriter performs the given http request, fills the given
+// http response header and writes the response body to w.
+//
+// The body is copied from the connection to w without buffering it
+// in resp, so large downloads may be written directly to files or pipes.
+// The body is written as is, i.e. DecompressResponseBody is ignored.
+// Errors returned by w are returned to the caller. The request isn't
+// retried after the response header is read.
+//
+// Response header is ignored if resp is nil.
+//
+// See Do for details.
+func (c *Client) DoWithBodyWriter(req *Request, resp *Response, w io.Writer) error {
+	return clientDoWithBodyWriter(req, resp, w, c)
+}
+
+// DoRedirects performs the given http request and fills the given http response,
+// following up to maxRedirectsCount redirects.
+//
+// Relative Location headers are resolved against the request uri.
+// Redirects to other hosts and schemes are followed without Authorization,
+// Proxy-Authorization and Cookie headers, while redirects from https
+// to http aren't followed unless RedirectPolicy allows them.
+//
+// 303 redirects and 301, 302 redirects for POST requests are followed
+// with GET request without body (HEAD requests remain HEAD), while other
+// redirects preserve the request method and body. Redirects for requests with body stream
+// cannot be replayed, so the redirect response is returned as is.
+//
+// Redirects to the same host are followed over the keep-alive connection
+// used by the previous hop without returning it to the pool.
+//
+// Client.RedirectPolicy is applied if set, except for its MaxRedirects.
+//
+// req is updated to the request sent during the last hop, while resp
+// contains the last response.
+//
+// Response is ignored if resp is nil.
+//
+// It is recommended obtaining req and resp via AcquireRequest
+// and AcquireResponse in performance-critical code.
+func (c *Client) DoRedirects(req *Request, resp *Response, maxRedirectsCount int) error {
+	unixSocket, err := rewriteUnixRequestURI(req)
+	if err != nil {
+		return err
+	}
+	if len(unixSocket) > 0 {
+		defer restoreUnixRequestURI(req)
+	}
+	hc, err := c.hostClient(req, unixSocket)
+	if err != nil {
+		return err
+	}
+	_, err = hc.doRedirects(context.Background(), nil, req, resp, maxRedirectsCount, c.RedirectPolicy)
+	return err
+}
+
+// DoRedirectsChain works like DoRedirects, but also appends the followed
+// redirects to dst and returns the extended dst.
+//
+// The chain allows auditing where the request actually landed. The final
+// request uri is available via req.URI() after the call. The chain is
+// returned on errors too, e.g. it contains all the redirects seen
+// if too many redirects are detected.
+func (c *Client) DoRedirectsChain(dst []RedirectHop, req *Request, resp *Response, maxRedirectsCount int) ([]RedirectHop, error) {
+	unixSocket, err := rewriteUnixRequestURI(req)
+	if err != nil {
+		return dst, err
+	}
+	if len(unixSocket) > 0 {
+		defer restoreUnixRequestURI(req)
+	}
+	hc, err := c.hostClient(req, unixSocket)
+	if err != nil {
+		return dst, err
+	}
+	if dst == nil {
+		dst = []RedirectHop{}
+	}
+	return hc.doRedirects(context.Background(), dst, req, resp, maxRedirectsCount, c.RedirectPolicy)
+}
+
+// hostClient returns HostClient for the host the given request must be sent to.
+//
+// unixSocket must contain the socket path returned from rewriteUnixURI
+// for requests with unix scheme.
+func (c *Client) hostClient(req *Request, unixSocket string) (*HostClient, error) {
 	uri := req.URI()
 	host := uri.Host()
 
@@ -347,7 +882,15 @@ func (c *Client) Do(req *Request, resp *Response) error {
 	if bytes.Equal(scheme, strHTTPS) {
 		isTLS = true
 	} else if !bytes.Equal(scheme, strHTTP) {
-		return fmt.Errorf("unsupported protocol %q. http and https are supported", scheme)
+		return nil, fmt.Errorf("%w %q. http, https and unix are supported", ErrUnsupportedProtocol, scheme)
+	}
+
+	var unixKey string
+	if len(unixSocket) > 0 {
+		// Distinct sockets share the same host in rewritten request uris.
+		unixKey = "unix:" + unixSocket
+	} else if s, ok := c.UnixSockets[string(host)]; ok {
+		unixSocket = s
 	}
 
 	startCleaner := false
@@ -365,25 +908,80 @@ func (c *Client) Do(req *Request, resp *Response) error {
 			c.m = m
 		}
 	}
-	hc := m[string(host)]
+	var hc *HostClient
+	if len(unixKey) > 0 {
+		hc = m[unixKey]
+	} else {
+		hc = m[string(host)]
+	}
 	if hc == nil {
 		hc = &HostClient{
 			Addr:                         addMissingPort(string(host), isTLS),
 			Name:                         c.Name,
 			Dial:                         c.Dial,
+			DialCtx:                      c.DialCtx,
+			DialTimeout:                  c.DialTimeout,
+			TLSHandshakeTimeout:          c.TLSHandshakeTimeout,
+			RequireOCSPStapling:          c.RequireOCSPStapling,
+			ECHConfigList:                c.ECHConfigList,
+			OCSPPolicy:                   c.OCSPPolicy,
 			DialDualStack:                c.DialDualStack,
+			Resolver:                     c.Resolver,
+			DNSCacheDuration:             c.DNSCacheDuration,
+			ConnControl:                  c.ConnControl,
 			IsTLS:                        isTLS,
 			TLSConfig:                    c.TLSConfig,
+			EnableHTTP2:                  c.EnableHTTP2,
+			EnableH2C:                    c.EnableH2C,
 			MaxConns:                     c.MaxConnsPerHost,
+			MaxConnWaitTimeout:           c.MaxConnWaitTimeout,
 			MaxIdleConnDuration:          c.MaxIdleConnDuration,
+			IdleCleanupInterval:          c.IdleCleanupInterval,
+			OnConnEvicted:                c.OnConnEvicted,
+			DialFailureCacheDuration:     c.DialFailureCacheDuration,
 			ReadBufferSize:               c.ReadBufferSize,
 			WriteBufferSize:              c.WriteBufferSize,
 			ReadTimeout:                  c.ReadTimeout,
 			WriteTimeout:                 c.WriteTimeout,
+			ContinueTimeout:              c.ContinueTimeout,
 			MaxResponseBodySize:          c.MaxResponseBodySize,
 			MaxIdempotentRequestAttempts: c.MaxIdempotentRequestAttempts,
+			RetryIf:                      c.RetryIf,
+			Backoff:                      c.Backoff,
+			SizeStatsHandler:             c.SizeStatsHandler,
+			OnRequest:                    c.OnRequest,
+			HeaderInjectionPolicy:        c.HeaderInjectionPolicy,
+			DefaultHeaders:               c.DefaultHeaders,
+			SignRequest:                  c.SignRequest,
+			OnResponse:                   c.OnResponse,
+			ValidateResponse:             c.ValidateResponse,
+			CircuitBreaker:               c.CircuitBreaker,
+			RateLimit:                    c.RateLimit,
+			RateLimitBurst:               c.RateLimitBurst,
+			InternHeaderValues:           c.InternHeaderValues,
+			StreamCloseDelimitedBody:     c.StreamCloseDelimitedBody,
+			StreamResponseBody:           c.StreamResponseBody,
+			DecompressResponseBody:       c.DecompressResponseBody,
+			DecompressLimits:             c.DecompressLimits,
+			BodyLengthMismatchPolicy:     c.BodyLengthMismatchPolicy,
+
+			parent: c,
+			dialer: c.getTCPDialer(),
+		}
+		if len(unixSocket) > 0 {
+			// Verify the certificate for the request host
+			// instead of the socket path.
+			hc.tlsServerName = hc.Addr
+			hc.Addr = unixSocket
+			hc.Dial = nil
+			hc.DialCtx = newUnixDialer(c.DialTimeout)
+			hc.dialer = nil
+		}
+		if len(unixKey) > 0 {
+			m[unixKey] = hc
+		} else {
+			m[string(host)] = hc
 		}
-		m[string(host)] = hc
 		if len(m) == 1 {
 			startCleaner = true
 		}
@@ -394,31 +992,204 @@ func (c *Client) Do(req *Request, resp *Response) error {
 		go c.mCleaner(m)
 	}
 
-	return hc.Do(req, resp)
+	return hc, nil
 }
 
-func (c *Client) mCleaner(m map[string]*HostClient) {
-	mustStop := false
-	for {
-		t := time.Now()
-		c.mLock.Lock()
-		for k, v := range m {
-			if t.Sub(v.LastUseTime()) > time.Minute {
-				delete(m, k)
-			}
+var errMissingUnixSocket = errors.New("missing unix socket path in request uri")
+
+// unixSocketHost is the host of http uris rewritten from unix scheme.
+const unixSocketHost = "localhost"
+
+// rewriteUnixRequestURI rewrites req uri with unix scheme via rewriteUnixURI
+// and remembers the socket path in req.
+//
+// restoreUnixRequestURI must be called for restoring the original uri
+// if non-empty socket path is returned.
+func rewriteUnixRequestURI(req *Request) (string, error) {
+	unixSocket, err := rewriteUnixURI(req.URI())
+	req.unixSocket = unixSocket
+	return unixSocket, err
+}
+
+// restoreUnixRequestURI restores req uri rewritten via rewriteUnixRequestURI
+// unless redirects led the request to another host.
+func restoreUnixRequestURI(req *Request) {
+	if len(req.unixSocket) > 0 {
+		restoreUnixURI(req.URI(), req.unixSocket)
+		req.unixSocket = ""
+	}
+}
+
+// rewriteUnixURI rewrites uri with unix scheme to http uri
+// and returns the unix socket path from the original uri.
+//
+// Empty socket path is returned for uris with other schemes.
+// restoreUnixURI may be used for restoring the original uri.
+func rewriteUnixURI(uri *URI) (string, error) {
+	if !bytes.Equal(uri.Scheme(), strUnix) {
+		return "", nil
+	}
+	path := uri.Path()
+	var reqPath []byte
+	if n := bytes.IndexByte(path, ':'); n >= 0 {
+		reqPath = path[n+1:]
+		path = path[:n]
+	}
+	if len(path) <= 1 {
+		return "", fmt.Errorf("%w %q", errMissingUnixSocket, uri.FullURI())
+	}
+	socket := string(path)
+	uri.SetSchemeBytes(strHTTP)
+	uri.SetHost(unixSocketHost)
+	if len(reqPath) == 0 {
+		reqPath = strSlash
+	}
+	uri.SetPathBytes(append([]byte(nil), reqPath...))
+	return socket, nil
+}
+
+// restoreUnixURI rewrites http uri obtained via rewriteUnixURI back
+// to uri with unix scheme for the given socket path.
+func restoreUnixURI(uri *URI, socket string) {
+	path := append([]byte(socket+":"), uri.Path()...)
+	uri.SetSchemeBytes(strUnix)
+	uri.SetHost("")
+	uri.SetPathBytes(path)
+}
+
+// newUnixDialer returns DialCtxFunc establishing connections
+// to unix sockets.
+func newUnixDialer(timeout time.Duration) DialCtxFunc {
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		d := net.Dialer{
+			Timeout: timeout,
 		}
-		if len(m) == 0 {
-			mustStop = true
+		return d.DialContext(ctx, "unix", addr)
+	}
+}
+
+// ResetDialFailures clears dial errors cached
+// due to DialFailureCacheDuration for all the hosts.
+func (c *Client) ResetDialFailures() {
+	c.mLock.Lock()
+	for _, hc := range c.m {
+		hc.ResetDialFailures()
+	}
+	for _, hc := range c.ms {
+		hc.ResetDialFailures()
+	}
+	c.mLock.Unlock()
+}
+
+// SetTLSConfig replaces TLSConfig for all the hosts.
+//
+// New connections use the given config, so client certificates
+// may be rotated without recreating the Client and losing its
+// connection pools. Already established connections aren't affected.
+func (c *Client) SetTLSConfig(cfg *tls.Config) {
+	c.mLock.Lock()
+	c.TLSConfig = cfg
+	for _, hc := range c.m {
+		hc.SetTLSConfig(cfg)
+	}
+	for _, hc := range c.ms {
+		hc.SetTLSConfig(cfg)
+	}
+	c.mLock.Unlock()
+}
+
+// SweepIdleConns closes idle connections exceeding MaxIdleConnDuration
+// for all the hosts and forgets hosts without requests during the last
+// minute.
+//
+// The cleanup is performed in background every IdleCleanupInterval,
+// so the function may be used for reclaiming memory right after
+// traffic spikes.
+func (c *Client) SweepIdleConns() {
+	c.mLock.Lock()
+	hcs := make([]*HostClient, 0, len(c.m)+len(c.ms))
+	for _, hc := range c.m {
+		hcs = append(hcs, hc)
+	}
+	for _, hc := range c.ms {
+		hcs = append(hcs, hc)
+	}
+	c.mLock.Unlock()
+
+	for _, hc := range hcs {
+		hc.SweepIdleConns()
+	}
+	t := time.Now()
+	c.evictIdleHostClients(c.m, t)
+	c.evictIdleHostClients(c.ms, t)
+}
+
+// FlushDNSCache removes addresses cached by the default dialer,
+// so host names are resolved again on the next dial.
+//
+// The cache shared by Dial* functions is flushed via FlushDNSCache
+// if neither Resolver nor DNSCacheDuration is set.
+func (c *Client) FlushDNSCache() {
+	c.mLock.Lock()
+	d := c.getTCPDialer()
+	c.mLock.Unlock()
+	if d == nil {
+		FlushDNSCache()
+		return
+	}
+	d.flushDNSCache()
+}
+
+// getTCPDialer returns the default dialer shared by HostClients
+// or nil if the dialer shared by Dial* functions must be used.
+//
+// It must be called under c.mLock.
+func (c *Client) getTCPDialer() *tcpDialer {
+	if c.Resolver == nil && c.DNSCacheDuration <= 0 {
+		return nil
+	}
+	if c.dialer == nil {
+		c.dialer = &tcpDialer{
+			DualStack:        c.DialDualStack,
+			Resolver:         c.Resolver,
+			DNSCacheDuration: c.DNSCacheDuration,
 		}
-		c.mLock.Unlock()
+	}
+	return c.dialer
+}
 
-		if mustStop {
+func (c *Client) mCleaner(m map[string]*HostClient) {
+	interval := c.IdleCleanupInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	for {
+		if c.evictIdleHostClients(m, time.Now()) {
 			break
 		}
-		time.Sleep(10 * time.Second)
+		time.Sleep(interval)
 	}
 }
 
+// evictIdleHostClients removes HostClients without requests during
+// the last minute from m.
+//
+// Returns true if m becomes empty.
+func (c *Client) evictIdleHostClients(m map[string]*HostClient, t time.Time) bool {
+	c.mLock.Lock()
+	for k, v := range m {
+		if t.Sub(v.LastUseTime()) > time.Minute {
+			delete(m, k)
+		}
+	}
+	isEmpty := len(m) == 0
+	c.mLock.Unlock()
+	return isEmpty
+}
+
 // DefaultMaxConnsPerHost is the maximum number of concurrent connections
 // http client may establish per host by default (i.e. if
 // Client.MaxConnsPerHost isn't set).
@@ -442,7 +1213,13 @@ const DefaultMaxIdleConnDuration = 10 * time.Second
 //   - foobar.com:8080
 type DialFunc func(addr string) (net.Conn, error)
 
-// HostClient balances http requests among hosts listed in Addr.
+// DialCtxFunc must establish connection to addr.
+//
+// It must return ctx.Err() as soon as ctx is canceled.
+// See DialFunc for details.
+type DialCtxFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// HostClient balances http requests among hosts listed in Addr.
 //
 // HostClient may be used for balancing load among multiple upstream hosts.
 // While multiple addresses passed to HostClient.Addr may be used for balancing
@@ -453,10 +1230,14 @@ type DialFunc func(addr string) (net.Conn, error)
 //
 // It is safe calling HostClient methods from concurrently running goroutines.
 type HostClient struct {
+	// pendingRequests is accessed atomically, so it must be 64-bit aligned
+	// on 32-bit arches. Keep it at the beginning of the struct.
+	pendingRequests uint64
+
 	noCopy noCopy
 
 	// Comma-separated list of upstream HTTP server host addresses,
-	// which are passed to Dial in a round-robin manner.
+	// which are passed to Dial according to AddrSelection.
 	//
 	// Each address may contain port if default dialer is used.
 	// For example,
@@ -466,6 +1247,23 @@ type HostClient struct {
 	//    - foobar.com:8080
 	Addr string
 
+	// Strategy for selecting one of the addresses listed in Addr.
+	//
+	// The strategy applies both to dialing new connections and to picking
+	// idle keep-alive connections, so a slow or failing address stops
+	// receiving an equal share of requests. See AddrSelection for details.
+	//
+	// By default AddrRoundRobin is used.
+	AddrSelection AddrSelection
+
+	// Active health probes for the addresses listed in Addr.
+	//
+	// Unhealthy addresses are removed from rotation until they recover.
+	// See AddrHealthCheck for details.
+	//
+	// By default addresses aren't probed.
+	HealthCheck *AddrHealthCheck
+
 	// Client name. Used in User-Agent request header.
 	Name string
 
@@ -474,28 +1272,158 @@ type HostClient struct {
 	// Default Dial is used if not set.
 	Dial DialFunc
 
+	// Callback for establishing new connection to the host, which may be
+	// canceled via ctx passed to DoCtx.
+	//
+	// DialCtx has precedence over Dial.
+	//
+	// Default dialer is used if neither Dial nor DialCtx is set.
+	DialCtx DialCtxFunc
+
+	// Timeout for each connection attempt made by the default dialer.
+	//
+	// The default dialer tries all the addresses the host resolves to,
+	// so a single unreachable address cannot consume the whole request
+	// timeout. Note that the timeout is applied per address, so dialing
+	// may take up to DialTimeout multiplied by the number of addresses.
+	// Use DoTimeout, DoDeadline or DoCtx for limiting the total duration.
+	//
+	// DefaultDialTimeout is used by default.
+	DialTimeout time.Duration
+
+	// Timeout for TLS handshake with the host.
+	//
+	// The handshake is performed right after the connection is established,
+	// so slow handshakes don't consume WriteTimeout and ReadTimeout
+	// of the first request. Connections with timed out handshakes fail
+	// with ErrTLSHandshakeTimeout.
+	//
+	// By default the handshake is performed when the first request
+	// is written to the connection.
+	TLSHandshakeTimeout time.Duration
+
+	// Requires and verifies OCSP responses stapled by TLS hosts
+	// if set to true.
+	//
+	// The connection is closed if the host doesn't staple OCSP response
+	// for its certificate, if the response isn't signed by the certificate
+	// issuer or by its valid delegated responder, if the response is expired
+	// or if OCSPPolicy rejects the response. The connection is also closed
+	// if the host certificate chain isn't verified, i.e. if
+	// TLSConfig.InsecureSkipVerify is set.
+	//
+	// By default stapled OCSP responses aren't verified.
+	RequireOCSPStapling bool
+
+	// Callback implementing custom revocation policy for verified
+	// OCSP responses if RequireOCSPStapling is set.
+	//
+	// By default only certificates with OCSPGood status are accepted.
+	OCSPPolicy OCSPPolicyFunc
+
+	// Serialized ECHConfigList for Encrypted Client Hello with TLS hosts.
+	//
+	// The list is usually obtained from the HTTPS DNS record of the host.
+	// ECH hides the requested host name and other sensitive TLS handshake
+	// fields from network observers. The connection fails if the host
+	// rejects ECH - see ECHRetryConfigList for obtaining the config list
+	// suggested by the host in this case. Requests fail with
+	// ErrECHUnsupported if crypto/tls doesn't support ECH.
+	//
+	// By default ECH isn't used.
+	ECHConfigList []byte
+
 	// Attempt to connect to both ipv4 and ipv6 host addresses
 	// if set to true.
 	//
 	// This option is used only if default TCP dialer is used,
-	// i.e. if Dial is blank.
+	// i.e. if Dial and DialCtx are blank. Addresses are dialed according
+	// to Happy Eyeballs algorithm. See DialDualStackContext for details.
 	//
 	// By default client connects only to ipv4 addresses,
 	// since unfortunately ipv6 remains broken in many networks worldwide :)
 	DialDualStack bool
 
+	// Resolver for host names used by the default dialer.
+	//
+	// This option is used only if default TCP dialer is used,
+	// i.e. if Dial and DialCtx are blank.
+	//
+	// net.DefaultResolver is used by default.
+	Resolver Resolver
+
+	// Duration for caching addresses resolved by the default dialer.
+	//
+	// Cached addresses may be removed before they expire
+	// via FlushDNSCache.
+	//
+	// DefaultDNSCacheDuration is used by default.
+	DNSCacheDuration time.Duration
+
+	// Callback for setting socket options on each dialed connection
+	// before it is used.
+	//
+	// The connection is closed if ConnControl returns an error.
+	//
+	// Socket options aren't set by default.
+	ConnControl ConnControlFunc
+
 	// Whether to use TLS (aka SSL or HTTPS) for host connections.
 	IsTLS bool
 
 	// Optional TLS config.
+	//
+	// The config is cloned per dialed address on the first use, so later
+	// changes to the config aren't applied to addresses already in use.
+	// Call SetTLSConfig for replacing the config, e.g. with rotated client
+	// certificates. Alternatively, tls.Config.GetClientCertificate may
+	// provide fresh client certificates on each handshake.
 	TLSConfig *tls.Config
 
+	// Whether to negotiate HTTP/2 via ALPN if IsTLS is set.
+	//
+	// Requests are multiplexed over a single HTTP/2 connection, so they
+	// don't wait for idle connections. Requests exceeding the number
+	// of concurrent streams allowed by the host wait for free streams.
+	// Request and Response are used as with HTTP/1.1, including
+	// StreamResponseBody, DoWithBodyWriter and Response.SetBodyTee.
+	// Streamed response bodies are flow-controlled, so the host doesn't
+	// send more data than the client buffers for the stream.
+	//
+	// HTTP/1.1 connections are used for a minute if the host doesn't
+	// negotiate HTTP/2. Then HTTP/2 is offered again, since the host
+	// may enable it later.
+	//
+	// By default HTTP/1.1 is used.
+	EnableHTTP2 bool
+
+	// Whether to use cleartext HTTP/2 (aka h2c) with prior knowledge
+	// if IsTLS isn't set.
+	//
+	// The host must accept HTTP/2 connections without upgrade from
+	// HTTP/1.1, since there is no fallback to HTTP/1.1. This is usually
+	// the case for internal services behind service mesh proxies.
+	// Requests are multiplexed the same way as with EnableHTTP2.
+	//
+	// By default HTTP/1.1 is used for connections without TLS.
+	EnableH2C bool
+
 	// Maximum number of connections which may be established to all hosts
 	// listed in Addr.
 	//
 	// DefaultMaxConnsPerHost is used if not set.
 	MaxConns int
 
+	// Maximum duration for waiting for a free connection when MaxConns
+	// connections are busy.
+	//
+	// Waiting requests obtain free connections in FIFO order.
+	// ErrNoFreeConns is returned if no connection becomes free
+	// during the given duration.
+	//
+	// By default ErrNoFreeConns is returned immediately.
+	MaxConnWaitTimeout time.Duration
+
 	// Keep-alive connections are closed after this duration.
 	//
 	// By default connection duration is unlimited.
@@ -507,6 +1435,35 @@ type HostClient struct {
 	// after DefaultMaxIdleConnDuration.
 	MaxIdleConnDuration time.Duration
 
+	// Interval between runs of the background cleaner, which closes idle
+	// connections exceeding MaxIdleConnDuration.
+	//
+	// Use SweepIdleConns for running the cleanup immediately.
+	//
+	// By default idle connections are checked every MaxIdleConnDuration.
+	IdleCleanupInterval time.Duration
+
+	// Callback called when a keep-alive connection to the host is closed
+	// by the client due to the given reason.
+	//
+	// It allows finding out the cause of connection churn, e.g.
+	// correlating 'Connection: close' responses with backend restarts.
+	// remoteAddr is the address of the host the connection is established to.
+	// Connections closed due to errors aren't reported.
+	//
+	// By default evicted connections aren't reported.
+	OnConnEvicted func(remoteAddr string, reason ConnEvictionReason)
+
+	// Dial errors are cached for this duration if set.
+	//
+	// Requests to the address, which failed to dial during the last
+	// DialFailureCacheDuration, fail fast with the cached error instead
+	// of re-dialing the address. Use ResetDialFailures for clearing
+	// the cache.
+	//
+	// By default dial errors aren't cached.
+	DialFailureCacheDuration time.Duration
+
 	// Per-connection buffer size for responses' reading.
 	// This also limits the maximum header size.
 	//
@@ -520,18 +1477,34 @@ type HostClient struct {
 
 	// Maximum duration for full response reading (including body).
 	//
+	// It may be overridden for distinct requests via Request.SetReadTimeout.
+	//
 	// By default response read timeout is unlimited.
 	ReadTimeout time.Duration
 
 	// Maximum duration for full request writing (including body).
 	//
+	// It may be overridden for distinct requests via Request.SetWriteTimeout.
+	//
 	// By default request write timeout is unlimited.
 	WriteTimeout time.Duration
 
+	// Maximum duration for waiting for 100 Continue response before
+	// sending the body of the request with 'Expect: 100-continue' header.
+	//
+	// The body isn't sent if the host responds with the final response
+	// instead of 100 Continue, e.g. if it rejects the request. The body
+	// is sent after the timeout if the host doesn't support 100-continue.
+	//
+	// By default the client waits for 100 Continue response for a second.
+	ContinueTimeout time.Duration
+
 	// Maximum response body size.
 	//
 	// The client returns ErrBodyTooLarge if this limit is greater than 0
-	// and response body is greater than the limit.
+	// and response body is greater than the limit. Body reading is aborted
+	// as soon as the limit is exceeded, so responses with too big
+	// Content-Length aren't read at all, and the connection is closed.
 	//
 	// By default response body size is unlimited.
 	MaxResponseBodySize int
@@ -539,26 +1512,242 @@ type HostClient struct {
 	// The maximum number of idempotent requests the client can make.
 	MaxIdempotentRequestAttempts int
 
+	// Callback deciding whether the failed request must be retried.
+	//
+	// It is called with the error returned by the failed attempt.
+	// resp may be nil. The number of attempts is still limited
+	// by MaxIdempotentRequestAttempts. Requests with body streams
+	// and requests canceled via context are never retried.
+	//
+	// By default idempotent requests are retried on connection errors,
+	// while non-idempotent requests are retried only if the server
+	// closes the connection before sending the response.
+	RetryIf RetryIfFunc
+
+	// Callback returning the delay before retrying the failed request.
+	//
+	// The delay is interrupted if the context passed to DoCtx is canceled.
+	// See ExponentialBackoff, FullJitter and EqualJitter for ready-to-use
+	// strategies.
+	//
+	// By default failed requests are retried immediately.
+	Backoff BackoffFunc
+
+	// Callback receiving request and response sizes and duration
+	// for each successful request attempt.
+	//
+	// SizeStats.Label contains HostClient.Addr.
+	//
+	// By default request sizes aren't measured.
+	SizeStatsHandler SizeStatsHandler
+
+	// Hooks called in the order of appearance before each request attempt
+	// is sent, e.g. for setting auth or tracing headers.
+	//
+	// The request isn't sent and the hook error is returned to the caller
+	// if a hook returns an error. Hooks are called for each attempt,
+	// so they must be idempotent.
+	//
+	// By default requests are sent as is.
+	OnRequest []RequestHook
+
+	// Policy for header keys and values with CR or LF in requests
+	// sent by the client.
+	//
+	// The policy overrides the policy of the request header before
+	// OnRequest hooks are called, so it applies to headers set by the hooks,
+	// DefaultHeaders and SignRequest. Use RequestHeader.SetInjectionPolicy
+	// for headers set before sending the request.
+	//
+	// By default CR and LF are stripped (HeaderInjectionStrip).
+	HeaderInjectionPolicy HeaderInjectionPolicy
+
+	// Headers added to each request, which doesn't contain them,
+	// e.g. User-Agent, Authorization or tracing headers.
+	//
+	// Headers set in the request or by OnRequest hooks take precedence.
+	// The added headers are removed from the request after it is sent,
+	// so the request may be reused.
+	//
+	// By default no headers are added.
+	DefaultHeaders map[string]string
+
+	// Callback signing the request, e.g. with AWS SigV4 or HMAC signature.
+	//
+	// It is called for each request attempt after Host, Content-Length,
+	// User-Agent and other headers added by the client are set, just before
+	// the request header is written to the connection, so the signature
+	// covers the exact headers sent. The request isn't sent and the error
+	// is returned to the caller if the callback returns an error.
+	//
+	// By default requests aren't signed.
+	SignRequest RequestHook
+
+	// Hooks called in the order of appearance after the response
+	// is read, e.g. for logging.
+	//
+	// Hooks are called before the response body is read if the body
+	// is streamed. The hook error is returned to the caller.
+	//
+	// By default responses are returned as is.
+	OnResponse []ResponseHook
+
+	// Callback validating the response before the connection it has been
+	// read from is returned to the pool, e.g. for rejecting responses
+	// with suspicious framing in proxies.
+	//
+	// The connection is closed and the error is returned to the caller
+	// if the callback returns an error. Call resp.SetConnectionClose()
+	// for closing the connection without failing the request. The callback
+	// may modify resp, e.g. set 'Cache-Control: no-store' header for
+	// marking the response as non-cacheable. The callback is called
+	// before the response body is read if the body is streamed.
+	//
+	// By default responses aren't validated.
+	ValidateResponse ValidateResponseFunc
+
+	// Circuit breaker for failing fast on requests to unhealthy host.
+	//
+	// See CircuitBreaker for details.
+	//
+	// By default requests are always sent to the host.
+	CircuitBreaker *CircuitBreaker
+
+	// The maximum number of requests per second sent to the host.
+	//
+	// Requests exceeding the limit wait until they may be sent
+	// or until the context passed to DoCtx is canceled. Retries
+	// are limited too.
+	//
+	// By default requests aren't rate limited.
+	RateLimit float64
+
+	// The maximum number of requests, which may be sent to the host
+	// at once without waiting, after the host has been idle.
+	//
+	// The burst is applied only if RateLimit is set.
+	//
+	// By default a single request may be sent at once.
+	RateLimitBurst int
+
+	// Policy for response bodies with the length distinct
+	// from Content-Length.
+	//
+	// See BodyLengthMismatchPolicy for details. Connections with mismatched
+	// bodies are closed.
+	//
+	// By default io.ErrUnexpectedEOF is returned for short bodies,
+	// while excess bytes after the body are ignored.
+	BodyLengthMismatchPolicy BodyLengthMismatchPolicy
+
+	// Streams response bodies delimited by connection close to the caller
+	// if set to true.
+	//
+	// Such responses have neither Content-Length nor chunked
+	// Transfer-Encoding. They are sent by some legacy servers. The body
+	// may be read via Response.BodyWriteTo or Response.Body.
+	// The connection is closed after the response is reset or released,
+	// so do not forget releasing such responses. MaxResponseBodySize
+	// and ReadTimeout apply to the streamed body.
+	//
+	// By default such bodies are read into memory before returning
+	// the response.
+	StreamCloseDelimitedBody bool
+
+	// Streams all the response bodies to the caller if set to true.
+	//
+	// The response is returned right after reading response headers,
+	// so huge bodies may be read incrementally via Response.BodyWriteTo
+	// instead of being loaded into memory. Response.Body reads the whole
+	// body into memory. The connection is returned to the pool only after
+	// the response body is read till the end and the response is reset
+	// or released, so do not forget releasing such responses.
+	// MaxResponseBodySize and ReadTimeout apply to the streamed body.
+	//
+	// By default response bodies are read into memory before returning
+	// the response.
+	StreamResponseBody bool
+
+	// Transparently decompresses response bodies compressed with gzip,
+	// deflate, br or zstd if set to true.
+	//
+	// 'Accept-Encoding: gzip, deflate, br, zstd' header is sent for requests
+	// without 'Accept-Encoding' header. 'Content-Encoding' header is removed
+	// from decompressed responses. Responses with other encodings
+	// are returned as is. MaxResponseBodySize limits the decompressed body
+	// size too. Streamed response bodies aren't decompressed.
+	//
+	// By default response bodies are returned as sent by the server.
+	DecompressResponseBody bool
+
+	// Limits for decompressing response bodies.
+	//
+	// The limits are applied to DecompressResponseBody and to
+	// Response.BodyGunzip, BodyInflate, BodyUnbrotli and BodyUnzstd* calls
+	// on the responses read by the client.
+	//
+	// By default decompressed response bodies are limited only
+	// by MaxResponseBodySize if DecompressResponseBody is set.
+	DecompressLimits *DecompressLimits
+
+	// Interns frequent response header values such as Content-Type
+	// if set to true.
+	//
+	// See ResponseHeader.SetInternValues for details.
+	//
+	// Response header values are copied by default.
+	InternHeaderValues bool
+
+	// parent is the Client the HostClient belongs to.
+	//
+	// It is used for following redirects to other hosts.
+	parent *Client
+
 	clientName  atomic.Value
 	lastUseTime uint32
 
 	connsLock  sync.Mutex
 	connsCount int
 	conns      []*clientConn
+	connsWait  []*connWaiter
+
+	addrsLock           sync.Mutex
+	addrs               []string
+	addrIdx             uint32
+	addrStats           []addrStat
+	addrErrorsResetTime time.Time
+	healthCheckerRun    bool
+
+	dialFailuresLock sync.Mutex
+	dialFailures     map[string]dialFailure
+
+	dialer     *tcpDialer
+	dialerOnce sync.Once
+
+	circuit circuit
+
+	rateLimiter rateLimiter
 
-	addrsLock sync.Mutex
-	addrs     []string
-	addrIdx   uint32
+	tlsConfigMap      map[string]*tls.Config
+	tlsConfigMapHTTP2 map[string]*tls.Config
+	tlsConfigMapLock  sync.Mutex
 
-	tlsConfigMap     map[string]*tls.Config
-	tlsConfigMapLock sync.Mutex
+	// tlsServerName overrides the TLS server name obtained from the dialed
+	// address if set. It is used for hosts mapped to unix sockets.
+	tlsServerName string
 
 	readerPool sync.Pool
 	writerPool sync.Pool
 
-	pendingRequests uint64
-
 	connsCleanerRun bool
+
+	http2Lock    sync.Mutex
+	http2Conn    *http2ClientConn
+	http2Dialing chan struct{}
+
+	// http2FallbackTime is the time the host didn't negotiate HTTP/2.
+	// HTTP/1.1 is used until http2FallbackDuration passes since then.
+	http2FallbackTime time.Time
 }
 
 type clientConn struct {
@@ -569,6 +1758,68 @@ type clientConn struct {
 
 	lastReadDeadlineTime  time.Time
 	lastWriteDeadlineTime time.Time
+
+	// Whether the deadlines have been set from per-request timeouts.
+	readDeadlineOverridden  bool
+	writeDeadlineOverridden bool
+
+	// addrStat contains stats for the address the connection is dialed to
+	// if HostClient.AddrSelection requires tracking requests.
+	addrStat    *addrStat
+	addrPending bool
+}
+
+// updateDeadline updates read or write deadline for cc according
+// to the given timeout.
+//
+// override must be set if timeout is a per-request timeout.
+func (cc *clientConn) updateDeadline(isWrite bool, timeout time.Duration, override bool) error {
+	lastDeadlineTime := &cc.lastReadDeadlineTime
+	overridden := &cc.readDeadlineOverridden
+	if isWrite {
+		lastDeadlineTime = &cc.lastWriteDeadlineTime
+		overridden = &cc.writeDeadlineOverridden
+	}
+
+	currentTime := time.Now()
+	if override || *overridden {
+		// The deadline set for the previous request may be arbitrarily
+		// distinct from the current one, so always update it.
+		deadline := zeroTime
+		if timeout > 0 {
+			deadline = currentTime.Add(timeout)
+		}
+		if err := cc.setDeadline(isWrite, deadline); err != nil {
+			return err
+		}
+		*overridden = override
+		*lastDeadlineTime = zeroTime
+		if !override && timeout > 0 {
+			*lastDeadlineTime = currentTime
+		}
+		return nil
+	}
+	if timeout <= 0 {
+		return nil
+	}
+
+	// Optimization: update deadline only if more than 25%
+	// of the last deadline exceeded.
+	// See https://github.com/golang/go/issues/15133 for details.
+	if currentTime.Sub(*lastDeadlineTime) > (timeout >> 2) {
+		if err := cc.setDeadline(isWrite, currentTime.Add(timeout)); err != nil {
+			return err
+		}
+		*lastDeadlineTime = currentTime
+	}
+	return nil
+}
+
+func (cc *clientConn) setDeadline(isWrite bool, deadline time.Time) error {
+	if isWrite {
+		return cc.c.SetWriteDeadline(deadline)
+	}
+	return cc.c.SetReadDeadline(deadline)
 }
 
 func (cc *clientConn) reset() {
@@ -577,6 +1828,10 @@ func (cc *clientConn) reset() {
 	cc.lastUseTime = zeroTime
 	cc.lastReadDeadlineTime = zeroTime
 	cc.lastWriteDeadlineTime = zeroTime
+	cc.readDeadlineOverridden = false
+	cc.writeDeadlineOverridden = false
+	cc.addrStat = nil
+	cc.addrPending = false
 }
 
 var startTimeUnix = time.Now().Unix()
@@ -722,12 +1977,95 @@ func clientPostURL(dst []byte, url string, postArgs *Args, c clientDoer) (status
 }
 
 var (
-	errMissingLocation  = errors.New("missing Location header for http redirect")
-	errTooManyRedirects = errors.New("too many redirects detected when doing the request")
+	// ErrMissingLocation is returned by clients if the redirect response
+	// has no Location header.
+	ErrMissingLocation = errors.New("missing Location header for http redirect")
+
+	// ErrTooManyRedirects is returned by clients if the number
+	// of redirects exceeds the limit.
+	ErrTooManyRedirects = errors.New("too many redirects detected when doing the request")
+
+	errRedirectHostChanged = errors.New("cannot follow redirect to another host or scheme without Client")
+	errCrossHostRedirect   = errors.New("redirect to another host or scheme is disallowed by RedirectPolicy")
+	errHTTPSDowngrade      = errors.New("redirect from https to http is disallowed by RedirectPolicy")
+	errUnixSocketRedirect  = errors.New("redirect from another host to unix socket is disallowed")
 )
 
 const maxRedirectsCount = 16
 
+// RedirectPolicy controls following redirects by Client.
+//
+// The zero value follows up to 16 redirects to any host except for
+// redirects from https to http. Credentials aren't forwarded to other
+// hosts and schemes.
+type RedirectPolicy struct {
+	// The maximum number of redirects to follow.
+	//
+	// Up to 16 redirects are followed by default.
+	MaxRedirects int
+
+	// Disallows following redirects to other hosts or schemes if set.
+	//
+	// Redirects to other hosts and schemes are followed by default.
+	DisallowCrossHost bool
+
+	// Forwards Authorization, Proxy-Authorization and Cookie headers
+	// when following redirects to other hosts or schemes if set to true.
+	//
+	// By default these headers are removed from the request before
+	// following such redirects, so credentials aren't leaked
+	// to third-party hosts.
+	ForwardCredentials bool
+
+	// Allows following redirects from https to http if set to true.
+	//
+	// By default such redirects aren't followed, since the request
+	// would be sent over unencrypted connection.
+	AllowHTTPSDowngrade bool
+
+	// Follows 307 and 308 redirects with GET request without body
+	// if set to true.
+	//
+	// By default 307 and 308 redirects preserve the request method and body.
+	DisablePreserveMethod bool
+
+	// Callback called before following each redirect.
+	//
+	// req is already updated for the next hop, so OnRedirect may modify it,
+	// e.g. drop credentials. resp contains the redirect response.
+	// The redirect isn't followed if OnRedirect returns an error.
+	// The error is returned to the caller in this case.
+	//
+	// By default all the redirects are followed.
+	OnRedirect func(req *Request, resp *Response) error
+}
+
+var defaultRedirectPolicy RedirectPolicy
+
+// RedirectHop describes a redirect followed by DoRedirectsChain.
+type RedirectHop struct {
+	// Method of the request, which received the redirect.
+	Method string
+
+	// Full uri of the request, which received the redirect.
+	URI string
+
+	// Status code of the redirect response.
+	StatusCode int
+
+	// Location header of the redirect response as is.
+	//
+	// It may be relative to URI.
+	Location string
+}
+
+func (p *RedirectPolicy) maxRedirects() int {
+	if p.MaxRedirects <= 0 {
+		return maxRedirectsCount
+	}
+	return p.MaxRedirects
+}
+
 func doRequestFollowRedirects(req *Request, dst []byte, url string, c clientDoer) (statusCode int, body []byte, err error) {
 	resp := AcquireResponse()
 	bodyBuf := resp.bodyBuffer()
@@ -735,6 +2073,10 @@ func doRequestFollowRedirects(req *Request, dst []byte, url string, c clientDoer
 	oldBody := bodyBuf.B
 	bodyBuf.B = dst
 
+	if holdRedirectConns(req) {
+		defer stopHoldingRedirectConns(req)
+	}
+
 	redirectsCount := 0
 	for {
 		req.parsedURI = false
@@ -751,15 +2093,20 @@ func doRequestFollowRedirects(req *Request, dst []byte, url string, c clientDoer
 
 		redirectsCount++
 		if redirectsCount > maxRedirectsCount {
-			err = errTooManyRedirects
+			err = ErrTooManyRedirects
 			break
 		}
 		location := resp.Header.peek(strLocation)
 		if len(location) == 0 {
-			err = errMissingLocation
+			err = ErrMissingLocation
+			break
+		}
+		redirectURL := getRedirectURL(url, location)
+		if cc, ok := c.(*Client); ok && cc.isUnixSocketRedirect(url, redirectURL) {
+			err = errUnixSocketRedirect
 			break
 		}
-		url = getRedirectURL(url, location)
+		url = redirectURL
 	}
 
 	body = bodyBuf.B
@@ -770,15 +2117,178 @@ func doRequestFollowRedirects(req *Request, dst []byte, url string, c clientDoer
 	return statusCode, body, err
 }
 
+// holdRedirectConns makes HostClient keeping the connection used for req
+// after receiving redirect response, so the next redirect hop to the same
+// host reuses the connection instead of acquiring it from the pool
+// or dialing new one.
+//
+// Returns false if connections are already held for req by the caller.
+// Otherwise stopHoldingRedirectConns must be called after following
+// redirects.
+func holdRedirectConns(req *Request) bool {
+	if req.holdRedirectConn {
+		return false
+	}
+	req.holdRedirectConn = true
+	return true
+}
+
+// stopHoldingRedirectConns returns the connection held for req to the pool.
+func stopHoldingRedirectConns(req *Request) {
+	req.holdRedirectConn = false
+	releaseRedirectConn(req)
+}
+
+func releaseRedirectConn(req *Request) {
+	if cc := req.redirectConn; cc != nil {
+		req.redirectConnClient.releaseConn(cc)
+		req.redirectConn = nil
+		req.redirectConnClient = nil
+	}
+}
+
+// acquireReqConn returns the connection held for req by the previous
+// redirect hop to c. Otherwise it acquires the connection from the pool.
+func (c *HostClient) acquireReqConn(ctx context.Context, req *Request) (*clientConn, error) {
+	if cc := req.redirectConn; cc != nil {
+		if req.redirectConnClient == c {
+			req.redirectConn = nil
+			req.redirectConnClient = nil
+			return cc, nil
+		}
+		// The redirect leads to another host.
+		releaseRedirectConn(req)
+	}
+	return c.acquireConn(ctx)
+}
+
+// releaseReqConn holds cc for the next hop if resp is a redirect
+// and redirects are followed for req. Otherwise cc is returned to the pool.
+func (c *HostClient) releaseReqConn(req *Request, resp *Response, cc *clientConn) {
+	if req.holdRedirectConn && isRedirectStatusCode(resp.StatusCode()) {
+		releaseRedirectConn(req)
+		req.redirectConn = cc
+		req.redirectConnClient = c
+		return
+	}
+	c.releaseConn(cc)
+}
+
+func isRedirectStatusCode(statusCode int) bool {
+	switch statusCode {
+	case StatusMovedPermanently, StatusFound, StatusSeeOther, StatusTemporaryRedirect, StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+// redirectPreservesBody returns true if req method and body must be preserved
+// when following the redirect with the given status code.
+//
+// preserveMethod is used for 307 and 308 redirects.
+func redirectPreservesBody(req *Request, statusCode int, preserveMethod bool) bool {
+	switch statusCode {
+	case StatusTemporaryRedirect, StatusPermanentRedirect:
+		return preserveMethod
+	case StatusSeeOther:
+		return false
+	default:
+		return !req.Header.IsPost()
+	}
+}
+
+// updateRedirectRequest updates req for following the redirect
+// to the given location.
+//
+// req is switched to GET request without body unless preserveBody is set.
+//
+// Returns true if the redirect changes request host or scheme.
+// downgrade is set if the redirect changes the scheme from https to http.
+func updateRedirectRequest(req *Request, location []byte, preserveBody bool) (hostChanged, downgrade bool) {
+	u := req.URI()
+	var buf [64]byte
+	b := append(buf[:0], u.Scheme()...)
+	schemeLen := len(b)
+	b = append(b, u.Host()...)
+
+	u.UpdateBytes(location)
+	hostChanged = !bytes.Equal(u.Scheme(), b[:schemeLen]) || !bytes.Equal(u.Host(), b[schemeLen:])
+	downgrade = bytes.Equal(b[:schemeLen], strHTTPS) && !bytes.Equal(u.Scheme(), strHTTPS)
+
+	if !preserveBody {
+		if !req.Header.IsHead() {
+			req.Header.SetMethodBytes(strGet)
+		}
+		req.ResetBody()
+		req.Header.del(strContentType)
+	}
+	return hostChanged, downgrade
+}
+
+// stripCredentials removes headers carrying credentials from req.
+func stripCredentials(req *Request) {
+	req.Header.del(strAuthorization)
+	req.Header.del(strProxyAuthorization)
+	req.Header.DelAllCookies()
+}
+
 func getRedirectURL(baseURL string, location []byte) string {
 	u := AcquireURI()
 	u.Update(baseURL)
+	// Resolve location against the uri sent over unix socket,
+	// so redirects to the same host are followed over the socket.
+	unixSocket, err := rewriteUnixURI(u)
 	u.UpdateBytes(location)
+	if err == nil && len(unixSocket) > 0 && bytes.Equal(u.Scheme(), strHTTP) && string(u.Host()) == unixSocketHost {
+		restoreUnixURI(u, unixSocket)
+	}
 	redirectURL := u.String()
 	ReleaseURI(u)
 	return redirectURL
 }
 
+// isUnixSocketRedirect returns true if the redirect from baseURL
+// to redirectURL leads to unix socket other than the socket baseURL
+// is sent over.
+//
+// Such redirects aren't followed, since otherwise remote servers could
+// reach local services listening on unix sockets.
+func (c *Client) isUnixSocketRedirect(baseURL, redirectURL string) bool {
+	u := AcquireURI()
+	u.Update(redirectURL)
+	socket := c.unixSocket(u)
+	if len(socket) > 0 {
+		u.Update(baseURL)
+		if c.unixSocket(u) == socket {
+			socket = ""
+		}
+	}
+	ReleaseURI(u)
+	return len(socket) > 0
+}
+
+// isUnixSocketURI returns true if requests to uri are sent over unix socket.
+func (c *Client) isUnixSocketURI(uri *URI) bool {
+	if bytes.Equal(uri.Scheme(), strUnix) {
+		return true
+	}
+	_, ok := c.UnixSockets[string(uri.Host())]
+	return ok
+}
+
+// unixSocket returns the socket path requests to uri are sent over.
+//
+// Empty path is returned for requests sent over TCP. uri is rewritten
+// via rewriteUnixURI if it has unix scheme.
+func (c *Client) unixSocket(uri *URI) string {
+	if bytes.Equal(uri.Scheme(), strUnix) {
+		socket, _ := rewriteUnixURI(uri)
+		return socket
+	}
+	return c.UnixSockets[string(uri.Host())]
+}
+
 var (
 	requestPool  sync.Pool
 	responsePool sync.Pool
@@ -834,7 +2344,8 @@ func ReleaseResponse(resp *Response) {
 // Request must contain at least non-zero RequestURI with full url (including
 // scheme and host) or non-zero Host header + RequestURI.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get* for following
+// redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -856,7 +2367,8 @@ func (c *HostClient) DoTimeout(req *Request, resp *Response, timeout time.Durati
 // Request must contain at least non-zero RequestURI with full url (including
 // scheme and host) or non-zero Host header + RequestURI.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get* for following
+// redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -872,6 +2384,29 @@ func (c *HostClient) DoDeadline(req *Request, resp *Response, deadline time.Time
 	return clientDoDeadline(req, resp, deadline, c)
 }
 
+// DoWithBodyWriter performs the given http request, fills the given
+// http response header and writes the response body to w.
+//
+// See Client.DoWithBodyWriter for details.
+func (c *HostClient) DoWithBodyWriter(req *Request, resp *Response, w io.Writer) error {
+	return clientDoWithBodyWriter(req, resp, w, c)
+}
+
+func clientDoWithBodyWriter(req *Request, resp *Response, w io.Writer, c clientDoer) error {
+	if resp == nil {
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+	streamResponseBodyOld := req.streamResponseBody
+	req.streamResponseBody = true
+	err := c.Do(req, resp)
+	req.streamResponseBody = streamResponseBodyOld
+	if err != nil {
+		return err
+	}
+	return resp.BodyWriteTo(w)
+}
+
 func clientDoTimeout(req *Request, resp *Response, timeout time.Duration, c clientDoer) error {
 	deadline := time.Now().Add(timeout)
 	return clientDoDeadline(req, resp, deadline, c)
@@ -894,6 +2429,21 @@ func clientDoDeadline(req *Request, resp *Response, deadline time.Time, c client
 	// may be accessed.
 	reqCopy := AcquireRequest()
 	req.CopyTo(reqCopy)
+	if req.bodyStream != nil {
+		// The body stream cannot be copied, so move it to reqCopy.
+		reqCopy.bodyStream = req.bodyStream
+		req.bodyStream = nil
+	}
+	holdRedirectConn := req.holdRedirectConn
+	if holdRedirectConn {
+		// The caller follows redirects, so the connection held for req
+		// must be used and held by reqCopy.
+		reqCopy.holdRedirectConn = true
+		reqCopy.redirectConn = req.redirectConn
+		reqCopy.redirectConnClient = req.redirectConnClient
+		req.redirectConn = nil
+		req.redirectConnClient = nil
+	}
 	respCopy := AcquireResponse()
 	if resp != nil {
 		swapResponseBody(resp, respCopy)
@@ -918,11 +2468,26 @@ func clientDoDeadline(req *Request, resp *Response, deadline time.Time, c client
 			respCopy.copyToSkipBody(resp)
 			swapResponseBody(resp, respCopy)
 		}
+		if holdRedirectConn {
+			req.redirectConn = reqCopy.redirectConn
+			req.redirectConnClient = reqCopy.redirectConnClient
+			reqCopy.holdRedirectConn = false
+			reqCopy.redirectConn = nil
+			reqCopy.redirectConnClient = nil
+		}
 		ReleaseResponse(respCopy)
 		ReleaseRequest(reqCopy)
 		errorChPool.Put(chv)
 	case <-tc.C:
 		err = ErrTimeout
+		if holdRedirectConn {
+			// Return the connection held by reqCopy to the pool
+			// after the request completes.
+			go func() {
+				<-ch
+				stopHoldingRedirectConns(reqCopy)
+			}()
+		}
 	}
 	releaseTimer(tc)
 
@@ -936,7 +2501,8 @@ var errorChPool sync.Pool
 // Request must contain at least non-zero RequestURI with full url (including
 // scheme and host) or non-zero Host header + RequestURI.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get* for following
+// redirects.
 //
 // Response is ignored if resp is nil.
 //
@@ -946,6 +2512,18 @@ var errorChPool sync.Pool
 // It is recommended obtaining req and resp via AcquireRequest
 // and AcquireResponse in performance-critical code.
 func (c *HostClient) Do(req *Request, resp *Response) error {
+	return c.DoCtx(context.Background(), req, resp)
+}
+
+// DoCtx performs the given http request and sets the corresponding response.
+//
+// The request is aborted when ctx is canceled, including connection
+// dialing, TLS handshake, request sending and response reading.
+// ctx.Err() is returned in this case. The connection used by the aborted
+// request is closed. Body stream of the response isn't controlled by ctx.
+//
+// See Do for details.
+func (c *HostClient) DoCtx(ctx context.Context, req *Request, resp *Response) error {
 	var err error
 	var retry bool
 	maxAttempts := c.MaxIdempotentRequestAttempts
@@ -954,14 +2532,36 @@ func (c *HostClient) Do(req *Request, resp *Response) error {
 	}
 	attempts := 0
 
+	// Requests with body stream cannot be retried without GetBody,
+	// since the stream is consumed by the first attempt.
+	hasBodyStream := req.IsBodyStream()
+	bodySize := req.Header.ContentLength()
+
 	atomic.AddUint64(&c.pendingRequests, 1)
 	for {
-		retry, err = c.do(req, resp)
-		if err == nil || !retry {
+		retry, err = c.do(ctx, req, resp)
+		if err == nil || hasBodyStream && req.GetBody == nil {
 			break
 		}
-
-		if !isIdempotent(req) {
+		if ctx.Err() != nil {
+			break
+		}
+		if _, ok := err.(*CircuitOpenError); ok {
+			// Retrying is pointless until the circuit is closed.
+			break
+		}
+
+		if c.RetryIf != nil {
+			retryErr := err
+			if retryErr == io.EOF {
+				retryErr = ErrConnectionClosed
+			}
+			if !c.RetryIf(req, resp, retryErr) {
+				break
+			}
+		} else if !retry {
+			break
+		} else if !isIdempotent(req) {
 			// Retry non-idempotent requests if the server closes
 			// the connection before sending the response.
 			//
@@ -977,15 +2577,139 @@ func (c *HostClient) Do(req *Request, resp *Response) error {
 		if attempts >= maxAttempts {
 			break
 		}
+		if c.Backoff != nil {
+			if err = sleepBackoff(ctx, c.Backoff(attempts)); err != nil {
+				break
+			}
+		}
+		if hasBodyStream {
+			if err = req.rewindBodyStream(bodySize); err != nil {
+				break
+			}
+		}
 	}
 	atomic.AddUint64(&c.pendingRequests, ^uint64(0))
 
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
 	if err == io.EOF {
 		err = ErrConnectionClosed
 	}
 	return err
 }
 
+// DoRedirects performs the given http request and fills the given http response,
+// following up to maxRedirectsCount redirects.
+//
+// Relative Location headers are resolved against the request uri.
+// Redirects to other hosts or schemes are followed only if HostClient
+// belongs to Client, otherwise an error is returned.
+//
+// See Client.DoRedirects for details.
+func (c *HostClient) DoRedirects(req *Request, resp *Response, maxRedirectsCount int) error {
+	_, err := c.doRedirects(context.Background(), nil, req, resp, maxRedirectsCount, nil)
+	return err
+}
+
+// DoRedirectsChain works like DoRedirects, but also appends the followed
+// redirects to dst and returns the extended dst.
+//
+// See Client.DoRedirectsChain for details.
+func (c *HostClient) DoRedirectsChain(dst []RedirectHop, req *Request, resp *Response, maxRedirectsCount int) ([]RedirectHop, error) {
+	if dst == nil {
+		dst = []RedirectHop{}
+	}
+	return c.doRedirects(context.Background(), dst, req, resp, maxRedirectsCount, nil)
+}
+
+// doRedirects follows redirects for req.
+//
+// The followed redirects are appended to chain unless it is nil.
+func (c *HostClient) doRedirects(ctx context.Context, chain []RedirectHop, req *Request, resp *Response, maxRedirectsCount int, p *RedirectPolicy) ([]RedirectHop, error) {
+	if resp == nil {
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+	if p == nil {
+		p = &defaultRedirectPolicy
+	}
+
+	if holdRedirectConns(req) {
+		defer stopHoldingRedirectConns(req)
+	}
+
+	hc := c
+	redirectsCount := 0
+	for {
+		hasBodyStream := req.IsBodyStream()
+		bodySize := req.Header.ContentLength()
+		if err := hc.DoCtx(ctx, req, resp); err != nil {
+			return chain, err
+		}
+		statusCode := resp.Header.StatusCode()
+		if !isRedirectStatusCode(statusCode) {
+			return chain, nil
+		}
+		preserveBody := redirectPreservesBody(req, statusCode, !p.DisablePreserveMethod)
+		if hasBodyStream && preserveBody && req.GetBody == nil {
+			// The body stream has been already consumed.
+			return chain, nil
+		}
+		if chain != nil {
+			chain = append(chain, RedirectHop{
+				Method:     string(req.Header.Method()),
+				URI:        req.URI().String(),
+				StatusCode: statusCode,
+				Location:   string(resp.Header.peek(strLocation)),
+			})
+		}
+
+		redirectsCount++
+		if redirectsCount > maxRedirectsCount {
+			return chain, ErrTooManyRedirects
+		}
+		location := resp.Header.peek(strLocation)
+		if len(location) == 0 {
+			return chain, ErrMissingLocation
+		}
+		hostChanged, downgrade := updateRedirectRequest(req, location, preserveBody)
+		if downgrade && !p.AllowHTTPSDowngrade {
+			return chain, errHTTPSDowngrade
+		}
+		if hostChanged {
+			if p.DisallowCrossHost {
+				return chain, errCrossHostRedirect
+			}
+			if !p.ForwardCredentials {
+				stripCredentials(req)
+			}
+			if hc.parent == nil {
+				return chain, errRedirectHostChanged
+			}
+			if hc.parent.isUnixSocketURI(req.URI()) {
+				return chain, errUnixSocketRedirect
+			}
+			// The request isn't sent over unix socket anymore.
+			req.unixSocket = ""
+			var err error
+			if hc, err = hc.parent.hostClient(req, ""); err != nil {
+				return chain, err
+			}
+		}
+		if p.OnRedirect != nil {
+			if err := p.OnRedirect(req, resp); err != nil {
+				return chain, err
+			}
+		}
+		if hasBodyStream && preserveBody {
+			if err := req.rewindBodyStream(bodySize); err != nil {
+				return chain, err
+			}
+		}
+	}
+}
+
 // PendingRequests returns the current number of requests the client
 // is executing.
 //
@@ -995,18 +2719,164 @@ func (c *HostClient) PendingRequests() int {
 	return int(atomic.LoadUint64(&c.pendingRequests))
 }
 
+// ConnsCount returns the number of connections to the host, including
+// connections in use, idle connections and connections being established.
+//
+// This function may be used for tuning MaxConns.
+func (c *HostClient) ConnsCount() int {
+	c.connsLock.Lock()
+	n := c.connsCount
+	c.connsLock.Unlock()
+	return n
+}
+
+// IdleConns returns the number of idle keep-alive connections to the host.
+//
+// This function may be used for tuning MaxIdleConnDuration.
+func (c *HostClient) IdleConns() int {
+	c.connsLock.Lock()
+	n := len(c.conns)
+	c.connsLock.Unlock()
+	return n
+}
+
+// ConnsSnapshot is a snapshot of HostClient connection pool state.
+//
+// See HostClient.ConnsSnapshot.
+type ConnsSnapshot struct {
+	// ConnsCount is the number of connections to the host.
+	//
+	// See HostClient.ConnsCount for details.
+	ConnsCount int
+
+	// IdleConns describes idle keep-alive connections to the host
+	// ordered from the least recently used.
+	IdleConns []IdleConnInfo
+}
+
+// IdleConnInfo describes idle keep-alive connection.
+type IdleConnInfo struct {
+	// Age is the duration since the connection has been established.
+	Age time.Duration
+
+	// LastUseTime is the time the connection has been returned to the pool.
+	LastUseTime time.Time
+}
+
+// ConnsSnapshot returns a snapshot of the connection pool state.
+//
+// This function may be used for exporting connection pool metrics.
+func (c *HostClient) ConnsSnapshot() ConnsSnapshot {
+	currentTime := time.Now()
+	c.connsLock.Lock()
+	s := ConnsSnapshot{
+		ConnsCount: c.connsCount,
+		IdleConns:  make([]IdleConnInfo, len(c.conns)),
+	}
+	for i, cc := range c.conns {
+		s.IdleConns[i] = IdleConnInfo{
+			Age:         currentTime.Sub(cc.createdTime),
+			LastUseTime: cc.lastUseTime,
+		}
+	}
+	c.connsLock.Unlock()
+	return s
+}
+
+// ConnEvictionReason is the reason for closing a keep-alive connection
+// passed to HostClient.OnConnEvicted.
+type ConnEvictionReason int
+
+// Connection eviction reasons passed to HostClient.OnConnEvicted.
+const (
+	// ConnEvictedServerClose means the host responded
+	// with 'Connection: close' header.
+	ConnEvictedServerClose ConnEvictionReason = iota
+
+	// ConnEvictedMaxConnDuration means the connection exceeded
+	// HostClient.MaxConnDuration.
+	ConnEvictedMaxConnDuration
+
+	// ConnEvictedIdle means the connection has been idle for more than
+	// HostClient.MaxIdleConnDuration.
+	ConnEvictedIdle
+)
+
+// String returns human-readable eviction reason.
+func (r ConnEvictionReason) String() string {
+	switch r {
+	case ConnEvictedServerClose:
+		return "server close"
+	case ConnEvictedMaxConnDuration:
+		return "max conn duration"
+	case ConnEvictedIdle:
+		return "idle"
+	default:
+		return fmt.Sprintf("ConnEvictionReason(%d)", int(r))
+	}
+}
+
+// RequestHook is called before the request is sent.
+//
+// See HostClient.OnRequest for details.
+type RequestHook func(req *Request) error
+
+// ResponseHook is called after the response for req is read.
+//
+// See HostClient.OnResponse for details.
+type ResponseHook func(req *Request, resp *Response) error
+
+// ValidateResponseFunc validates the response read for req.
+//
+// See HostClient.ValidateResponse for details.
+type ValidateResponseFunc func(req *Request, resp *Response) error
+
+// RetryIfFunc must return true if the request failed with the given
+// error must be retried.
+//
+// See HostClient.RetryIf for details.
+type RetryIfFunc func(req *Request, resp *Response, err error) bool
+
 func isIdempotent(req *Request) bool {
 	return req.Header.IsGet() || req.Header.IsHead() || req.Header.IsPut()
 }
 
-func (c *HostClient) do(req *Request, resp *Response) (bool, error) {
+func (c *HostClient) do(ctx context.Context, req *Request, resp *Response) (bool, error) {
 	nilResp := false
 	if resp == nil {
 		nilResp = true
 		resp = AcquireResponse()
 	}
 
-	ok, err := c.doNonNilReqResp(req, resp)
+	if err := c.waitRateLimit(ctx); err != nil {
+		if nilResp {
+			ReleaseResponse(resp)
+		}
+		return false, err
+	}
+
+	var isProbe bool
+	cb := c.CircuitBreaker
+	if cb != nil {
+		var err error
+		if isProbe, err = c.circuit.allow(cb, c.Addr, time.Now()); err != nil {
+			if nilResp {
+				ReleaseResponse(resp)
+			}
+			return false, err
+		}
+	}
+
+	ok, err := c.doNonNilReqResp(ctx, req, resp)
+
+	if cb != nil {
+		if ctx.Err() != nil {
+			// Requests canceled by the caller say nothing about the host health.
+			c.circuit.cancel(isProbe)
+		} else {
+			c.circuit.record(cb, isProbe, cb.isFailure(resp, err), time.Now())
+		}
+	}
 
 	if nilResp {
 		ReleaseResponse(resp)
@@ -1015,7 +2885,7 @@ func (c *HostClient) do(req *Request, resp *Response) (bool, error) {
 	return ok, err
 }
 
-func (c *HostClient) doNonNilReqResp(req *Request, resp *Response) (bool, error) {
+func (c *HostClient) doNonNilReqResp(ctx context.Context, req *Request, resp *Response) (retry bool, err error) {
 	if req == nil {
 		panic("BUG: req cannot be nil")
 	}
@@ -1027,96 +2897,514 @@ func (c *HostClient) doNonNilReqResp(req *Request, resp *Response) (bool, error)
 
 	// Free up resources occupied by response before sending the request,
 	// so the GC may reclaim these resources (e.g. response body).
-	resp.Reset()
+	resp.resetKeepBodyTee()
 
-	cc, err := c.acquireConn()
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	req.Header.injectionPolicy = c.HeaderInjectionPolicy
+	req.uri.injectionPolicy = c.HeaderInjectionPolicy
+	for _, h := range c.OnRequest {
+		if err := h(req); err != nil {
+			return false, err
+		}
+	}
+	if c.mayUseHTTP2() {
+		h2, err := c.acquireHTTP2Conn(ctx)
+		if err != nil {
+			return false, err
+		}
+		if h2 != nil {
+			return c.doHTTP2(ctx, h2, req, resp)
+		}
+	}
+	cc, err := c.acquireReqConn(ctx, req)
 	if err != nil {
 		return false, err
 	}
+	if s := cc.addrStat; s != nil {
+		defer func() {
+			if err != nil && ctx.Err() == nil {
+				atomic.AddInt32(&s.errors, 1)
+			}
+		}()
+	}
 	conn := cc.c
 
-	if c.WriteTimeout > 0 {
-		// Optimization: update write deadline only if more than 25%
-		// of the last write deadline exceeded.
-		// See https://github.com/golang/go/issues/15133 for details.
-		currentTime := time.Now()
-		if currentTime.Sub(cc.lastWriteDeadlineTime) > (c.WriteTimeout >> 2) {
-			if err = conn.SetWriteDeadline(currentTime.Add(c.WriteTimeout)); err != nil {
-				c.closeConn(cc)
-				return true, err
-			}
-			cc.lastWriteDeadlineTime = currentTime
-		}
+	var cw *ctxWatcher
+	if ctx.Done() != nil {
+		cw = startCtxWatcher(ctx, conn)
+		defer cw.stop()
+	}
+
+	writeTimeout := c.WriteTimeout
+	if req.writeTimeout > 0 {
+		writeTimeout = req.writeTimeout
+	}
+	if err = cc.updateDeadline(true, writeTimeout, req.writeTimeout > 0); err != nil {
+		c.closeConn(cc)
+		return true, err
 	}
 
 	resetConnection := false
+	maxConnDurationExceeded := false
 	if c.MaxConnDuration > 0 && time.Since(cc.createdTime) > c.MaxConnDuration && !req.ConnectionClose() {
 		req.SetConnectionClose()
 		resetConnection = true
+		maxConnDurationExceeded = true
 	}
 
+	defaultHeaders := c.setDefaultHeaders(req)
 	userAgentOld := req.Header.UserAgent()
 	if len(userAgentOld) == 0 {
 		req.Header.userAgent = c.getClientName()
 	}
+	setAcceptEncoding := c.DecompressResponseBody && len(req.Header.peek(strAcceptEncoding)) == 0
+	if setAcceptEncoding {
+		req.Header.SetCanonical(strAcceptEncoding, strClientAcceptEncoding)
+	}
+	var startTime time.Time
+	var sc *sizeCounter
 	bw := c.acquireWriter(conn)
-	err = req.Write(bw)
+	if c.SizeStatsHandler != nil {
+		startTime = time.Now()
+		sc = &sizeCounter{}
+		bw = startCountWrites(bw, conn, sc)
+	}
+	var br *bufio.Reader
+	var waitContinue continueFunc
+	headerRead := false
+	if req.MayContinue() {
+		waitContinue = func() (bool, error) {
+			br = c.acquireReader(conn)
+			sendBody, err := c.waitContinue(cc, br, resp, req)
+			headerRead = !sendBody && err == nil
+			return sendBody, err
+		}
+	}
+	var sign RequestHook
+	var signErr error
+	if c.SignRequest != nil {
+		sign = func(req *Request) error {
+			signErr = c.SignRequest(req)
+			return signErr
+		}
+	}
+	err = req.write(bw, waitContinue, sign)
 	if len(userAgentOld) == 0 {
 		req.Header.userAgent = userAgentOld
 	}
+	if setAcceptEncoding {
+		req.Header.del(strAcceptEncoding)
+	}
+	for _, key := range defaultHeaders {
+		req.Header.Del(key)
+	}
 
-	if resetConnection {
+	if maxConnDurationExceeded {
 		req.Header.ResetConnectionClose()
 	}
+	if headerRead {
+		// The host responded without reading the request body,
+		// so the connection cannot be reused.
+		resetConnection = true
+	}
 
 	if err == nil {
 		err = bw.Flush()
 	}
 	if err != nil {
+		if br != nil {
+			c.releaseReader(br)
+		}
 		c.releaseWriter(bw)
 		c.closeConn(cc)
-		return true, err
+		// Do not retry requests, which cannot be signed.
+		return signErr == nil, wrapConnResetError(err)
 	}
 	c.releaseWriter(bw)
 
-	if c.ReadTimeout > 0 {
-		// Optimization: update read deadline only if more than 25%
-		// of the last read deadline exceeded.
-		// See https://github.com/golang/go/issues/15133 for details.
-		currentTime := time.Now()
-		if currentTime.Sub(cc.lastReadDeadlineTime) > (c.ReadTimeout >> 2) {
-			if err = conn.SetReadDeadline(currentTime.Add(c.ReadTimeout)); err != nil {
-				c.closeConn(cc)
-				return true, err
-			}
-			cc.lastReadDeadlineTime = currentTime
-		}
+	readTimeout := c.ReadTimeout
+	if req.readTimeout > 0 {
+		readTimeout = req.readTimeout
+	}
+	if err = cc.updateDeadline(false, readTimeout, req.readTimeout > 0); err != nil {
+		c.closeConn(cc)
+		return true, err
 	}
 
 	if !req.Header.IsGet() && req.Header.IsHead() {
 		resp.SkipBody = true
 	}
 
-	br := c.acquireReader(conn)
-	if err = resp.ReadLimitBody(br, c.MaxResponseBodySize); err != nil {
+	if c.InternHeaderValues {
+		resp.Header.SetInternValues(true)
+	}
+
+	resp.bodyLengthPolicy = c.BodyLengthMismatchPolicy
+	resp.decompressLimits = c.DecompressLimits
+	if br == nil {
+		br = c.acquireReader(conn)
+	}
+	streamBody := c.StreamResponseBody || req.streamResponseBody
+	if headerRead {
+		if !streamBody {
+			err = resp.readBody(br, c.MaxResponseBodySize, c.StreamCloseDelimitedBody)
+		}
+	} else if streamBody {
+		err = resp.readHeader(br)
+	} else {
+		err = resp.readLimitBody(br, c.MaxResponseBodySize, c.StreamCloseDelimitedBody)
+	}
+	if err != nil {
 		if err == io.EOF && time.Since(cc.createdTime) < time.Second {
 			err = io.ErrUnexpectedEOF
 		}
 		c.releaseReader(br)
 		c.closeConn(cc)
-		return true, err
+		// Do not retry too big responses, since the host is likely
+		// to send the same response again.
+		return err != ErrBodyTooLarge, wrapConnResetError(err)
+	}
+	if cw.stop() {
+		// The connection has been closed on ctx cancellation.
+		c.releaseReader(br)
+		c.closeConn(cc)
+		return false, ctx.Err()
+	}
+	if c.OnConnEvicted != nil {
+		if maxConnDurationExceeded {
+			c.OnConnEvicted(conn.RemoteAddr().String(), ConnEvictedMaxConnDuration)
+		} else if resp.ConnectionClose() && !req.ConnectionClose() {
+			c.OnConnEvicted(conn.RemoteAddr().String(), ConnEvictedServerClose)
+		}
+	}
+	if c.SizeStatsHandler != nil {
+		c.SizeStatsHandler(SizeStats{
+			RequestSize:  sc.n,
+			ResponseSize: resp.Header.readSize + len(resp.bodyBytes()),
+			Duration:     time.Since(startTime),
+			StatusCode:   resp.StatusCode(),
+			Label:        c.Addr,
+		})
+	}
+	if c.ValidateResponse != nil {
+		if err = c.ValidateResponse(req, resp); err != nil {
+			c.releaseReader(br)
+			c.closeConn(cc)
+			return false, err
+		}
+	}
+	contentLength := resp.Header.ContentLength()
+	if (streamBody || c.StreamCloseDelimitedBody && contentLength == -2) && !resp.mustSkipBody() {
+		if c.MaxResponseBodySize > 0 && contentLength > c.MaxResponseBodySize {
+			c.releaseReader(br)
+			c.closeConn(cc)
+			return false, ErrBodyTooLarge
+		}
+		// The connection is released when the body stream is closed.
+		resp.bodyStream = &responseBodyStream{
+			c:             c,
+			cc:            cc,
+			br:            br,
+			contentLength: contentLength,
+			bytesLeft:     contentLength,
+			maxBodySize:   c.MaxResponseBodySize,
+			trailers:      &resp.Header,
+			closeConn:     resetConnection || req.ConnectionClose() || resp.ConnectionClose(),
+			policy:        c.BodyLengthMismatchPolicy,
+			tee:           resp.bodyTee,
+		}
+		return false, c.runResponseHooks(req, resp)
+	}
+	excessBody := false
+	if c.BodyLengthMismatchPolicy != BodyLengthMismatchDefault && br.Buffered() > 0 &&
+		!resp.mustSkipBody() && !isOnlyCRLF(mustPeekBuffered(br)) {
+		// HostClient doesn't pipeline requests, so the buffered data
+		// is the excess of the response body. Empty lines are ignored,
+		// since some servers send them after the response.
+		if err = resp.handleExcessBody(br); err != nil {
+			c.releaseReader(br)
+			c.closeConn(cc)
+			return false, err
+		}
+		// The connection cannot be reused after the excess body.
+		excessBody = true
 	}
 	c.releaseReader(br)
 
-	if resetConnection || req.ConnectionClose() || resp.ConnectionClose() {
+	if excessBody || resetConnection || req.ConnectionClose() || resp.ConnectionClose() {
 		c.closeConn(cc)
 	} else {
-		c.releaseConn(cc)
+		c.releaseReqConn(req, resp, cc)
 	}
 
+	if c.DecompressResponseBody && !resp.mustSkipBody() {
+		err = resp.decompressBody(c.MaxResponseBodySize)
+	}
+	if err == nil {
+		err = c.runResponseHooks(req, resp)
+	}
 	return false, err
 }
 
+// defaultContinueTimeout is the default duration for waiting
+// for 100 Continue response.
+const defaultContinueTimeout = time.Second
+
+// waitContinue waits for 100 Continue response after sending req header
+// with 'Expect: 100-continue' to cc.
+//
+// Returns true if the request body must be sent. The final response header
+// is read into resp if the host responds without 100 Continue.
+func (c *HostClient) waitContinue(cc *clientConn, br *bufio.Reader, resp *Response, req *Request) (bool, error) {
+	timeout := c.ContinueTimeout
+	if timeout <= 0 {
+		timeout = defaultContinueTimeout
+	}
+	if err := cc.updateDeadline(false, timeout, true); err != nil {
+		return false, err
+	}
+	if _, err := br.Peek(1); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			// The host doesn't support 100-continue, so send the body.
+			return true, nil
+		}
+		return false, err
+	}
+
+	readTimeout := c.ReadTimeout
+	if req.readTimeout > 0 {
+		readTimeout = req.readTimeout
+	}
+	if err := cc.updateDeadline(false, readTimeout, req.readTimeout > 0); err != nil {
+		return false, err
+	}
+	if err := resp.Header.Read(br); err != nil {
+		return false, err
+	}
+	return resp.Header.StatusCode() == StatusContinue, nil
+}
+
+func (c *HostClient) runResponseHooks(req *Request, resp *Response) error {
+	for _, h := range c.OnResponse {
+		if err := h(req, resp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ctxWatcher closes the connection when ctx is canceled, so pending
+// connection I/O is interrupted.
+type ctxWatcher struct {
+	stopCh chan struct{}
+	doneCh chan struct{}
+
+	// canceled is set before closing doneCh.
+	canceled bool
+	stopped  bool
+}
+
+func startCtxWatcher(ctx context.Context, conn net.Conn) *ctxWatcher {
+	w := &ctxWatcher{
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Unblock pending reads and writes on conn.
+			conn.Close()
+			w.canceled = true
+		case <-w.stopCh:
+		}
+		close(w.doneCh)
+	}()
+	return w
+}
+
+// stop stops the watcher and returns true if the connection
+// has been closed due to ctx cancellation.
+//
+// It is safe calling stop multiple times and on nil watcher.
+func (w *ctxWatcher) stop() bool {
+	if w == nil {
+		return false
+	}
+	if !w.stopped {
+		w.stopped = true
+		close(w.stopCh)
+		<-w.doneCh
+	}
+	return w.canceled
+}
+
+// upgradeConn writes req to conn and reads the response header to resp,
+// so conn may be switched to another protocol afterwards.
+//
+// The exchange is aborted when ctx is canceled or ReadTimeout elapses.
+// The returned reader contains data sent by the server after the response
+// header.
+func (c *HostClient) upgradeConn(ctx context.Context, conn net.Conn, req *Request, resp *Response) (*bufio.Reader, error) {
+	if c.ReadTimeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(c.ReadTimeout)); err != nil {
+			return nil, err
+		}
+	}
+	var cw *ctxWatcher
+	if ctx.Done() != nil {
+		cw = startCtxWatcher(ctx, conn)
+	}
+
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+	err := req.Write(bw)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = resp.Header.Read(br)
+	}
+	if cw.stop() {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return br, nil
+}
+
+// responseBodyStream streams response body from the connection.
+type responseBodyStream struct {
+	c  *HostClient
+	cc *clientConn
+	br *bufio.Reader
+
+	// contentLength is the response Content-Length.
+	//
+	// -1 means chunked body, -2 means body delimited by connection close.
+	contentLength int
+
+	// bytesLeft is the number of unread bytes in the body
+	// or in the current chunk.
+	bytesLeft int
+
+	maxBodySize int
+	bytesRead   int
+
+	// trailers receives trailers following the chunked body.
+	trailers trailerAppender
+
+	// closeConn is set if the connection mustn't be reused
+	// after reading the body.
+	closeConn bool
+
+	policy BodyLengthMismatchPolicy
+
+	// tee receives a copy of the read body.
+	tee io.Writer
+
+	done bool
+	err  error
+}
+
+func (b *responseBodyStream) Read(p []byte) (int, error) {
+	if b.done || b.br == nil {
+		return 0, io.EOF
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	var n int
+	var err error
+	switch b.contentLength {
+	case -2:
+		n, err = b.br.Read(p)
+	case -1:
+		if b.bytesLeft <= 0 {
+			if b.bytesLeft, err = parseChunkSize(b.br); err != nil {
+				break
+			}
+			if b.bytesLeft == 0 {
+				if err = readTrailers(b.br, b.trailers); err == nil {
+					err = io.EOF
+				}
+				break
+			}
+		}
+		if len(p) > b.bytesLeft {
+			p = p[:b.bytesLeft]
+		}
+		n, err = b.br.Read(p)
+		b.bytesLeft -= n
+		if b.bytesLeft == 0 && err == nil {
+			err = readCRLF(b.br)
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	default:
+		if b.bytesLeft == 0 {
+			err = io.EOF
+			break
+		}
+		if len(p) > b.bytesLeft {
+			p = p[:b.bytesLeft]
+		}
+		n, err = b.br.Read(p)
+		b.bytesLeft -= n
+		if err == io.EOF {
+			b.closeConn = true
+			switch b.policy {
+			case BodyLengthMismatchDefault:
+				err = io.ErrUnexpectedEOF
+			case BodyLengthMismatchError:
+				err = &ErrBodyLengthMismatch{
+					Expected: b.contentLength,
+					Actual:   b.bytesRead + n,
+				}
+			}
+		}
+	}
+
+	b.bytesRead += n
+	if b.maxBodySize > 0 && b.bytesRead > b.maxBodySize {
+		err = ErrBodyTooLarge
+	}
+	if n > 0 && b.tee != nil && (err == nil || err == io.EOF) {
+		if _, errTee := b.tee.Write(p[:n]); errTee != nil {
+			err = errTee
+		}
+	}
+	if err == io.EOF {
+		b.done = true
+	} else if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// Close releases the connection if the body has been read till the end.
+// Otherwise the connection is closed.
+func (b *responseBodyStream) Close() error {
+	if b.br == nil {
+		return nil
+	}
+	b.c.releaseReader(b.br)
+	b.br = nil
+	if b.done && !b.closeConn && b.contentLength != -2 {
+		b.c.releaseConn(b.cc)
+	} else {
+		b.c.closeConn(b.cc)
+	}
+	return nil
+}
+
 var (
 	// ErrNoFreeConns is returned when no free connections available
 	// to the given host.
@@ -1137,10 +3425,58 @@ var (
 	// to broken server.
 	ErrConnectionClosed = errors.New("the server closed connection before returning the first response byte. " +
 		"Make sure the server returns 'Connection: close' response header before closing the connection")
+
+	// ErrTLSHandshakeTimeout is returned from client methods if TLS
+	// handshake isn't completed during TLSHandshakeTimeout.
+	ErrTLSHandshakeTimeout = errors.New("tls handshake timed out")
+
+	// ErrUnsupportedProtocol is returned by Client for request uris
+	// with schemes other than http and https.
+	ErrUnsupportedProtocol = errors.New("unsupported protocol")
 )
 
-func (c *HostClient) acquireConn() (*clientConn, error) {
+// ErrConnClosedByPeer may be checked via errors.Is for errors returned
+// from client methods if the server resets the connection while
+// the request is sent or the response is read.
+//
+// The original error may be obtained via errors.As,
+// e.g. errors.As(err, &netErr) for netErr of net.Error type.
+// See also ErrConnectionClosed, which is returned if the server closes
+// the connection before sending the first response byte.
+var ErrConnClosedByPeer = errors.New("the server closed the connection")
+
+// connClosedByPeerError wraps connection reset errors, so they match
+// ErrConnClosedByPeer via errors.Is.
+type connClosedByPeerError struct {
+	err error
+}
+
+func (e *connClosedByPeerError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrConnClosedByPeer, e.err)
+}
+
+func (e *connClosedByPeerError) Is(target error) bool {
+	return target == ErrConnClosedByPeer
+}
+
+func (e *connClosedByPeerError) Unwrap() error {
+	return e.err
+}
+
+// wrapConnResetError wraps err into connClosedByPeerError if the server
+// reset the connection. Other errors are returned as is.
+func wrapConnResetError(err error) error {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return &connClosedByPeerError{
+			err: err,
+		}
+	}
+	return err
+}
+
+func (c *HostClient) acquireConn(ctx context.Context) (*clientConn, error) {
 	var cc *clientConn
+	var w *connWaiter
 	createConn := false
 	startCleaner := false
 
@@ -1159,7 +3495,20 @@ func (c *HostClient) acquireConn() (*clientConn, error) {
 				startCleaner = true
 				c.connsCleanerRun = true
 			}
+		} else if c.MaxConnWaitTimeout > 0 {
+			w = &connWaiter{
+				ready: make(chan struct{}),
+			}
+			c.connsWait = append(c.connsWait, w)
 		}
+	} else if c.AddrSelection != AddrRoundRobin || c.HealthCheck != nil {
+		i := c.selectIdleConnLocked()
+		cc = c.conns[i]
+		// Preserve the order of idle connections for closeIdleConns.
+		copy(c.conns[i:], c.conns[i+1:])
+		n--
+		c.conns[n] = nil
+		c.conns = c.conns[:n]
 	} else {
 		n--
 		cc = c.conns[n]
@@ -1169,8 +3518,21 @@ func (c *HostClient) acquireConn() (*clientConn, error) {
 	c.connsLock.Unlock()
 
 	if cc != nil {
+		startAddrRequest(cc)
 		return cc, nil
 	}
+	if w != nil {
+		var err error
+		if cc, err = c.waitConn(ctx, w); err != nil {
+			return nil, err
+		}
+		if cc != nil {
+			startAddrRequest(cc)
+			return cc, nil
+		}
+		// The connection slot has been handed over to w.
+		createConn = true
+	}
 	if !createConn {
 		return nil, ErrNoFreeConns
 	}
@@ -1179,50 +3541,77 @@ func (c *HostClient) acquireConn() (*clientConn, error) {
 		go c.connsCleaner()
 	}
 
-	conn, err := c.dialHostHard()
+	conn, addr, err := c.dialHostHardCtx(ctx, false)
 	if err != nil {
 		c.decConnsCount()
 		return nil, err
 	}
 	cc = acquireClientConn(conn)
+	cc.addrStat = c.getAddrStat(addr)
+	startAddrRequest(cc)
 
 	return cc, nil
 }
 
+// SweepIdleConns closes idle connections exceeding MaxIdleConnDuration.
+//
+// The cleanup is performed in background every IdleCleanupInterval,
+// so the function may be used for reclaiming memory right after
+// traffic spikes.
+func (c *HostClient) SweepIdleConns() {
+	c.closeIdleConns(nil, time.Now(), c.getMaxIdleConnDuration())
+}
+
+// FlushDNSCache removes addresses cached by the default dialer,
+// so the host name is resolved again on the next dial.
+//
+// The cache shared by Dial* functions is flushed via FlushDNSCache
+// if neither Resolver nor DNSCacheDuration is set.
+func (c *HostClient) FlushDNSCache() {
+	d := c.getTCPDialer()
+	if d == nil {
+		FlushDNSCache()
+		return
+	}
+	d.flushDNSCache()
+}
+
+// getTCPDialer returns the default dialer or nil if the dialer
+// shared by Dial* functions must be used.
+func (c *HostClient) getTCPDialer() *tcpDialer {
+	if c.Resolver == nil && c.DNSCacheDuration <= 0 {
+		return nil
+	}
+	c.dialerOnce.Do(func() {
+		if c.dialer == nil {
+			c.dialer = &tcpDialer{
+				DualStack:        c.DialDualStack,
+				Resolver:         c.Resolver,
+				DNSCacheDuration: c.DNSCacheDuration,
+			}
+		}
+	})
+	return c.dialer
+}
+
+func (c *HostClient) getMaxIdleConnDuration() time.Duration {
+	if c.MaxIdleConnDuration <= 0 {
+		return DefaultMaxIdleConnDuration
+	}
+	return c.MaxIdleConnDuration
+}
+
 func (c *HostClient) connsCleaner() {
 	var (
 		scratch             []*clientConn
-		maxIdleConnDuration = c.MaxIdleConnDuration
+		maxIdleConnDuration = c.getMaxIdleConnDuration()
+		interval            = c.IdleCleanupInterval
 	)
-	if maxIdleConnDuration <= 0 {
-		maxIdleConnDuration = DefaultMaxIdleConnDuration
+	if interval <= 0 {
+		interval = maxIdleConnDuration
 	}
 	for {
-		currentTime := time.Now()
-
-		// Determine idle connections to be closed.
-		c.connsLock.Lock()
-		conns := c.conns
-		n := len(conns)
-		i := 0
-		for i < n && currentTime.Sub(conns[i].lastUseTime) > maxIdleConnDuration {
-			i++
-		}
-		scratch = append(scratch[:0], conns[:i]...)
-		if i > 0 {
-			m := copy(conns, conns[i:])
-			for i = m; i < n; i++ {
-				conns[i] = nil
-			}
-			c.conns = conns[:m]
-		}
-		c.connsLock.Unlock()
-
-		// Close idle connections.
-		for i, cc := range scratch {
-			c.closeConn(cc)
-			scratch[i] = nil
-		}
+		scratch = c.closeIdleConns(scratch, time.Now(), maxIdleConnDuration)
 
 		// Determine whether to stop the connsCleaner.
 		c.connsLock.Lock()
@@ -1235,11 +3624,49 @@ func (c *HostClient) connsCleaner() {
 			break
 		}
 
-		time.Sleep(maxIdleConnDuration)
+		time.Sleep(interval)
+	}
+}
+
+// closeIdleConns closes connections, which are idle for more than
+// maxIdleConnDuration at currentTime.
+//
+// scratch is used as a temporary buffer. The returned buffer
+// may be passed to the next call.
+func (c *HostClient) closeIdleConns(scratch []*clientConn, currentTime time.Time, maxIdleConnDuration time.Duration) []*clientConn {
+	// Determine idle connections to be closed.
+	c.connsLock.Lock()
+	conns := c.conns
+	n := len(conns)
+	i := 0
+	for i < n && currentTime.Sub(conns[i].lastUseTime) > maxIdleConnDuration {
+		i++
+	}
+	scratch = append(scratch[:0], conns[:i]...)
+	if i > 0 {
+		m := copy(conns, conns[i:])
+		for i = m; i < n; i++ {
+			conns[i] = nil
+		}
+		c.conns = conns[:m]
+	}
+	c.connsLock.Unlock()
+
+	c.closeIdleHTTP2Conn(currentTime, maxIdleConnDuration)
+
+	// Close idle connections.
+	for i, cc := range scratch {
+		if c.OnConnEvicted != nil {
+			c.OnConnEvicted(cc.c.RemoteAddr().String(), ConnEvictedIdle)
+		}
+		c.closeConn(cc)
+		scratch[i] = nil
 	}
+	return scratch
 }
 
 func (c *HostClient) closeConn(cc *clientConn) {
+	finishAddrRequest(cc)
 	c.decConnsCount()
 	cc.c.Close()
 	releaseClientConn(cc)
@@ -1247,7 +3674,12 @@ func (c *HostClient) closeConn(cc *clientConn) {
 
 func (c *HostClient) decConnsCount() {
 	c.connsLock.Lock()
-	c.connsCount--
+	if w := c.popConnWaiter(); w != nil {
+		// Hand over the connection slot to the waiter.
+		close(w.ready)
+	} else {
+		c.connsCount--
+	}
 	c.connsLock.Unlock()
 }
 
@@ -1262,18 +3694,86 @@ func acquireClientConn(conn net.Conn) *clientConn {
 	return cc
 }
 
-func releaseClientConn(cc *clientConn) {
-	cc.reset()
-	clientConnPool.Put(cc)
+func releaseClientConn(cc *clientConn) {
+	cc.reset()
+	clientConnPool.Put(cc)
+}
+
+var clientConnPool sync.Pool
+
+func (c *HostClient) releaseConn(cc *clientConn) {
+	finishAddrRequest(cc)
+	cc.lastUseTime = time.Now()
+	c.connsLock.Lock()
+	if w := c.popConnWaiter(); w != nil {
+		w.cc = cc
+		close(w.ready)
+	} else {
+		c.conns = append(c.conns, cc)
+	}
+	c.connsLock.Unlock()
+}
+
+// connWaiter waits for a free connection.
+//
+// See HostClient.MaxConnWaitTimeout for details.
+type connWaiter struct {
+	// ready is closed when the waiter is served.
+	ready chan struct{}
+
+	// cc is the connection handed over to the waiter.
+	//
+	// The waiter may establish new connection if cc is nil,
+	// since connsCount slot is handed over to it.
+	cc *clientConn
+}
+
+// popConnWaiter removes the first waiter from c.connsWait.
+//
+// It must be called under c.connsLock.
+func (c *HostClient) popConnWaiter() *connWaiter {
+	if len(c.connsWait) == 0 {
+		return nil
+	}
+	w := c.connsWait[0]
+	c.connsWait[0] = nil
+	c.connsWait = c.connsWait[1:]
+	return w
 }
 
-var clientConnPool sync.Pool
+// waitConn waits until w is served for up to MaxConnWaitTimeout.
+//
+// nil connection is returned if w may establish new connection.
+func (c *HostClient) waitConn(ctx context.Context, w *connWaiter) (*clientConn, error) {
+	t := acquireTimer(c.MaxConnWaitTimeout)
+	defer releaseTimer(t)
+
+	var err error
+	select {
+	case <-w.ready:
+		return w.cc, nil
+	case <-t.C:
+		err = ErrNoFreeConns
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
 
-func (c *HostClient) releaseConn(cc *clientConn) {
-	cc.lastUseTime = time.Now()
 	c.connsLock.Lock()
-	c.conns = append(c.conns, cc)
+	select {
+	case <-w.ready:
+		// w has been served concurrently with the timeout.
+		c.connsLock.Unlock()
+		return w.cc, nil
+	default:
+	}
+	for i, x := range c.connsWait {
+		if x == w {
+			c.connsWait = append(c.connsWait[:i], c.connsWait[i+1:]...)
+			break
+		}
+	}
 	c.connsLock.Unlock()
+	return nil, err
 }
 
 func (c *HostClient) acquireWriter(conn net.Conn) *bufio.Writer {
@@ -1345,21 +3845,69 @@ func tlsServerName(addr string) string {
 	return host
 }
 
-func (c *HostClient) nextAddr() string {
+// nextAddr returns the address to dial.
+//
+// Addresses listed in tried are skipped by AddrSelection strategies
+// other than AddrRoundRobin if possible.
+func (c *HostClient) nextAddr(tried []string) string {
 	c.addrsLock.Lock()
 	if c.addrs == nil {
 		c.addrs = strings.Split(c.Addr, ",")
+		c.addrStats = make([]addrStat, len(c.addrs))
 	}
 	addr := c.addrs[0]
 	if len(c.addrs) > 1 {
-		addr = c.addrs[c.addrIdx%uint32(len(c.addrs))]
-		c.addrIdx++
+		if c.HealthCheck != nil {
+			c.startHealthCheckerLocked()
+		}
+		if c.AddrSelection == AddrRoundRobin && c.HealthCheck == nil {
+			addr = c.addrs[c.addrIdx%uint32(len(c.addrs))]
+			c.addrIdx++
+		} else {
+			addr = c.addrs[c.selectAddrLocked(tried)]
+		}
 	}
 	c.addrsLock.Unlock()
 	return addr
 }
 
-func (c *HostClient) dialHostHard() (conn net.Conn, err error) {
+type hostDialResult struct {
+	conn net.Conn
+	addr string
+	err  error
+}
+
+// dialHostHardCtx works like dialHostHard, but returns ctx.Err()
+// as soon as ctx is canceled.
+func (c *HostClient) dialHostHardCtx(ctx context.Context, http2 bool) (net.Conn, string, error) {
+	done := ctx.Done()
+	if done == nil {
+		return c.dialHostHard(ctx, http2)
+	}
+
+	ch := make(chan hostDialResult, 1)
+	go func() {
+		conn, addr, err := c.dialHostHard(ctx, http2)
+		ch <- hostDialResult{conn, addr, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.conn, r.addr, r.err
+	case <-done:
+		go func() {
+			// Close the connection dialed after ctx cancellation.
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, "", ctx.Err()
+	}
+}
+
+// dialHostHard returns the connection and the address it is dialed to.
+//
+// HTTP/2 is offered via ALPN to TLS hosts if http2 is set.
+func (c *HostClient) dialHostHard(ctx context.Context, http2 bool) (conn net.Conn, addr string, err error) {
 	// attempt to dial all the available hosts before giving up.
 
 	c.addrsLock.Lock()
@@ -1376,19 +3924,102 @@ func (c *HostClient) dialHostHard() (conn net.Conn, err error) {
 		timeout = DefaultDialTimeout
 	}
 	deadline := time.Now().Add(timeout)
+	var tried []string
 	for n > 0 {
-		addr := c.nextAddr()
+		addr = c.nextAddr(tried)
+		if c.AddrSelection != AddrRoundRobin || c.HealthCheck != nil {
+			tried = append(tried, addr)
+		}
+		if err = c.cachedDialFailure(addr); err != nil {
+			n--
+			continue
+		}
 		tlsConfig := c.cachedTLSConfig(addr)
-		conn, err = dialAddr(addr, c.Dial, c.DialDualStack, c.IsTLS, tlsConfig)
+		if http2 && tlsConfig != nil {
+			tlsConfig = c.cachedHTTP2TLSConfig(addr, tlsConfig)
+		}
+		conn, err = c.dialAddr(ctx, addr, tlsConfig)
 		if err == nil {
-			return conn, nil
+			return conn, addr, nil
+		}
+		if ctx.Err() != nil {
+			return nil, "", ctx.Err()
 		}
+		if s := c.getAddrStat(addr); s != nil {
+			atomic.AddInt32(&s.errors, 1)
+		}
+		c.cacheDialFailure(addr, err)
 		if time.Since(deadline) >= 0 {
 			break
 		}
 		n--
 	}
-	return nil, err
+	return nil, "", err
+}
+
+type dialFailure struct {
+	err      error
+	deadline time.Time
+}
+
+// cachedDialFailure returns the cached dial error for addr.
+//
+// nil is returned if there is no cached error for addr.
+func (c *HostClient) cachedDialFailure(addr string) error {
+	if c.DialFailureCacheDuration <= 0 {
+		return nil
+	}
+
+	var err error
+	c.dialFailuresLock.Lock()
+	if f, ok := c.dialFailures[addr]; ok {
+		if time.Since(f.deadline) < 0 {
+			err = f.err
+		} else {
+			delete(c.dialFailures, addr)
+		}
+	}
+	c.dialFailuresLock.Unlock()
+	return err
+}
+
+func (c *HostClient) cacheDialFailure(addr string, err error) {
+	if c.DialFailureCacheDuration <= 0 {
+		return
+	}
+
+	c.dialFailuresLock.Lock()
+	if c.dialFailures == nil {
+		c.dialFailures = make(map[string]dialFailure)
+	}
+	c.dialFailures[addr] = dialFailure{
+		err:      err,
+		deadline: time.Now().Add(c.DialFailureCacheDuration),
+	}
+	c.dialFailuresLock.Unlock()
+}
+
+// ResetDialFailures clears dial errors cached
+// due to DialFailureCacheDuration.
+func (c *HostClient) ResetDialFailures() {
+	c.dialFailuresLock.Lock()
+	c.dialFailures = nil
+	c.dialFailuresLock.Unlock()
+}
+
+// SetTLSConfig replaces TLSConfig.
+//
+// New connections use the given config, so client certificates
+// may be rotated without recreating the HostClient and losing its
+// connection pool. Already established connections aren't affected.
+func (c *HostClient) SetTLSConfig(cfg *tls.Config) {
+	c.tlsConfigMapLock.Lock()
+	c.TLSConfig = cfg
+	// Drop cached configs together with their TLS session caches,
+	// so sessions established with the previous config aren't resumed.
+	c.tlsConfigMap = nil
+	c.tlsConfigMapHTTP2 = nil
+	c.tlsConfigMapLock.Unlock()
 }
 
 func (c *HostClient) cachedTLSConfig(addr string) *tls.Config {
@@ -1402,7 +4033,14 @@ func (c *HostClient) cachedTLSConfig(addr string) *tls.Config {
 	}
 	cfg := c.tlsConfigMap[addr]
 	if cfg == nil {
-		cfg = newClientTLSConfig(c.TLSConfig, addr)
+		serverName := addr
+		if len(c.tlsServerName) > 0 {
+			serverName = c.tlsServerName
+		}
+		cfg = newClientTLSConfig(c.TLSConfig, serverName)
+		if len(c.ECHConfigList) > 0 {
+			setClientECH(cfg, c.ECHConfigList)
+		}
 		c.tlsConfigMap[addr] = cfg
 	}
 	c.tlsConfigMapLock.Unlock()
@@ -1410,7 +4048,96 @@ func (c *HostClient) cachedTLSConfig(addr string) *tls.Config {
 	return cfg
 }
 
-func dialAddr(addr string, dial DialFunc, dialDualStack, isTLS bool, tlsConfig *tls.Config) (net.Conn, error) {
+// cachedHTTP2TLSConfig returns a copy of cfg obtained via cachedTLSConfig,
+// which offers HTTP/2 via ALPN.
+//
+// The copy shares TLS session cache with cfg.
+func (c *HostClient) cachedHTTP2TLSConfig(addr string, cfg *tls.Config) *tls.Config {
+	c.tlsConfigMapLock.Lock()
+	if c.tlsConfigMapHTTP2 == nil {
+		c.tlsConfigMapHTTP2 = make(map[string]*tls.Config)
+	}
+	cfgHTTP2 := c.tlsConfigMapHTTP2[addr]
+	if cfgHTTP2 == nil {
+		cfgHTTP2 = cfg.Clone()
+		cfgHTTP2.NextProtos = append([]string{http2Proto}, cfg.NextProtos...)
+		if len(cfg.NextProtos) == 0 {
+			cfgHTTP2.NextProtos = append(cfgHTTP2.NextProtos, "http/1.1")
+		}
+		c.tlsConfigMapHTTP2[addr] = cfgHTTP2
+	}
+	c.tlsConfigMapLock.Unlock()
+
+	return cfgHTTP2
+}
+
+func (c *HostClient) dialAddr(ctx context.Context, addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	if c.IsTLS && len(c.ECHConfigList) > 0 && !echSupported {
+		return nil, ErrECHUnsupported
+	}
+	tracked, ok := reserveSocket()
+	if !ok {
+		return nil, ErrTooManyOpenSockets
+	}
+	var conn net.Conn
+	var err error
+	switch {
+	case c.DialCtx != nil:
+		conn, err = c.DialCtx(ctx, addr)
+	case c.Dial != nil:
+		conn, err = c.Dial(addr)
+	default:
+		addr = addMissingPort(addr, c.IsTLS)
+		if d := c.getTCPDialer(); d != nil {
+			conn, err = d.dialCtx(ctx, addr, c.DialTimeout)
+		} else if c.DialDualStack {
+			conn, err = DialDualStackContext(ctx, addr, c.DialTimeout)
+		} else {
+			conn, err = DialContext(ctx, addr, c.DialTimeout)
+		}
+	}
+	if err != nil {
+		if tracked {
+			releaseSocket()
+		}
+		return nil, err
+	}
+	conn, err = initDialedConn(conn, addr, tracked, c.ConnControl, c.IsTLS, tlsConfig)
+	if err != nil || !c.IsTLS || c.TLSHandshakeTimeout <= 0 && !c.RequireOCSPStapling {
+		return conn, err
+	}
+	tlsConn := conn.(*tls.Conn)
+	err = tlsHandshake(tlsConn, c.TLSHandshakeTimeout)
+	if err == nil && c.RequireOCSPStapling {
+		err = verifyStapledOCSP(tlsConn.ConnectionState(), c.OCSPPolicy, time.Now())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// tlsHandshake performs TLS handshake on conn for up to timeout.
+//
+// The handshake time isn't limited if timeout isn't positive.
+func tlsHandshake(conn *tls.Conn, timeout time.Duration) error {
+	if timeout <= 0 {
+		return conn.Handshake()
+	}
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if err := conn.Handshake(); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return ErrTLSHandshakeTimeout
+		}
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+func dialAddr(addr string, dial DialFunc, dialDualStack bool, connControl ConnControlFunc, isTLS bool, tlsConfig *tls.Config) (net.Conn, error) {
 	if dial == nil {
 		if dialDualStack {
 			dial = DialDualStack
@@ -1419,13 +4146,38 @@ func dialAddr(addr string, dial DialFunc, dialDualStack, isTLS bool, tlsConfig *
 		}
 		addr = addMissingPort(addr, isTLS)
 	}
+	tracked, ok := reserveSocket()
+	if !ok {
+		return nil, ErrTooManyOpenSockets
+	}
 	conn, err := dial(addr)
 	if err != nil {
+		if tracked {
+			releaseSocket()
+		}
 		return nil, err
 	}
+	return initDialedConn(conn, addr, tracked, connControl, isTLS, tlsConfig)
+}
+
+// initDialedConn sets socket options for the dialed conn and wraps it
+// into TLS connection if isTLS is set.
+//
+// tracked must be set if the socket has been reserved via reserveSocket.
+func initDialedConn(conn net.Conn, addr string, tracked bool, connControl ConnControlFunc, isTLS bool, tlsConfig *tls.Config) (net.Conn, error) {
 	if conn == nil {
 		panic("BUG: DialFunc returned (nil, nil)")
 	}
+	if err := controlConn(conn, connControl); err != nil {
+		conn.Close()
+		if tracked {
+			releaseSocket()
+		}
+		return nil, fmt.Errorf("cannot set socket options for connection to %q: %w", addr, err)
+	}
+	if tracked {
+		conn = wrapBudgetConn(conn)
+	}
 	if isTLS {
 		conn = tls.Client(conn, tlsConfig)
 	}
@@ -1447,6 +4199,22 @@ func (c *HostClient) getClientName() []byte {
 	return clientName
 }
 
+// setDefaultHeaders adds c.DefaultHeaders missing in req
+// and returns the added keys.
+func (c *HostClient) setDefaultHeaders(req *Request) []string {
+	if len(c.DefaultHeaders) == 0 {
+		return nil
+	}
+	var keys []string
+	for key, value := range c.DefaultHeaders {
+		if len(req.Header.Peek(key)) == 0 {
+			req.Header.Set(key, value)
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
 func addMissingPort(addr string, isTLS bool) string {
 	n := strings.Index(addr, ":")
 	if n >= 0 {
@@ -1479,9 +4247,38 @@ type PipelineClient struct {
 
 	// The maximum number of concurrent connections to the Addr.
 	//
+	// New connections are opened on demand until MaxConns is reached.
+	// See ScaleUpPendingRequests and ScaleUpLatency for details.
+	//
 	// A sinle connection is used by default.
 	MaxConns int
 
+	// The minimum number of connections to the Addr kept open.
+	//
+	// MinConns connections are opened on the first request and aren't
+	// closed on idle timeout. Connections above MinConns are dropped
+	// after MaxIdleConnDuration of inactivity, so the number of connections
+	// shrinks after load bursts.
+	//
+	// By default connections are opened on demand.
+	MinConns int
+
+	// The number of pending requests on the least loaded connection,
+	// which triggers opening a new connection if MaxConns isn't reached.
+	//
+	// By default a new connection is opened when all the connections
+	// have pending requests.
+	ScaleUpPendingRequests int
+
+	// Response latency on the least loaded connection, which triggers
+	// opening a new connection if MaxConns isn't reached.
+	//
+	// The latency is measured from sending the request till reading
+	// the response, so it includes the time spent in the pipeline.
+	//
+	// By default the latency isn't taken into account.
+	ScaleUpLatency time.Duration
+
 	// The maximum number of pending pipelined requests over
 	// a single connection to Addr.
 	//
@@ -1494,6 +4291,15 @@ type PipelineClient struct {
 	// By default requests are sent immediately to the server.
 	MaxBatchDelay time.Duration
 
+	// The maximum number of high-priority requests sent in a row
+	// while normal-priority requests are pending.
+	//
+	// This protects normal-priority requests from starvation.
+	// See DoPriority for details.
+	//
+	// DefaultMaxHighPriorityBurst is used by default.
+	MaxHighPriorityBurst int
+
 	// Callback for connection establishing to the host.
 	//
 	// Default Dial is used if not set.
@@ -1509,6 +4315,14 @@ type PipelineClient struct {
 	// since unfortunately ipv6 remains broken in many networks worldwide :)
 	DialDualStack bool
 
+	// Callback for setting socket options on each dialed connection
+	// before it is used.
+	//
+	// The connection is closed if ConnControl returns an error.
+	//
+	// Socket options aren't set by default.
+	ConnControl ConnControlFunc
+
 	// Whether to use TLS (aka SSL or HTTPS) for host connections.
 	IsTLS bool
 
@@ -1542,6 +4356,16 @@ type PipelineClient struct {
 	// By default request write timeout is unlimited.
 	WriteTimeout time.Duration
 
+	// Maximum response body size.
+	//
+	// ErrBodyTooLarge is returned if this limit is greater than 0
+	// and response body is greater than the limit. Body reading is aborted
+	// as soon as the limit is exceeded and the connection is closed,
+	// so the remaining pipelined requests on the connection fail.
+	//
+	// By default response body size is unlimited.
+	MaxResponseBodySize int
+
 	// Logger for logging client errors.
 	//
 	// By default standard logger from log package is used.
@@ -1554,24 +4378,38 @@ type PipelineClient struct {
 type pipelineConnClient struct {
 	noCopy noCopy
 
-	Addr                string
-	MaxPendingRequests  int
-	MaxBatchDelay       time.Duration
-	Dial                DialFunc
-	DialDualStack       bool
-	IsTLS               bool
-	TLSConfig           *tls.Config
-	MaxIdleConnDuration time.Duration
-	ReadBufferSize      int
-	WriteBufferSize     int
-	ReadTimeout         time.Duration
-	WriteTimeout        time.Duration
-	Logger              Logger
+	// latency is the latency of the last response in nanoseconds.
+	// It is measured only if trackLatency is set.
+	//
+	// It is accessed atomically, so it must be 64-bit aligned.
+	latency int64
+
+	Addr                 string
+	MaxPendingRequests   int
+	MaxBatchDelay        time.Duration
+	MaxHighPriorityBurst int
+	Dial                 DialFunc
+	DialDualStack        bool
+	ConnControl          ConnControlFunc
+	IsTLS                bool
+	TLSConfig            *tls.Config
+	MaxIdleConnDuration  time.Duration
+	ReadBufferSize       int
+	WriteBufferSize      int
+	ReadTimeout          time.Duration
+	WriteTimeout         time.Duration
+	MaxResponseBodySize  int
+	Logger               Logger
+
+	// keepOpen disables closing idle connection.
+	keepOpen     bool
+	trackLatency bool
 
 	workPool sync.Pool
 
 	chLock sync.Mutex
 	chW    chan *pipelineWork
+	chWHi  chan *pipelineWork
 	chR    chan *pipelineWork
 
 	tlsConfigLock sync.Mutex
@@ -1587,6 +4425,14 @@ type pipelineWork struct {
 	deadline time.Time
 	err      error
 	done     chan struct{}
+
+	// startTime is the time the request is written at.
+	// It is set only if the latency is tracked.
+	startTime time.Time
+
+	// canceled is set to non-zero if the caller stops waiting
+	// for the response, so the request mustn't be sent.
+	canceled uint32
 }
 
 // DoTimeout performs the given request and waits for response during
@@ -1624,11 +4470,32 @@ func (c *PipelineClient) DoTimeout(req *Request, resp *Response, timeout time.Du
 // It is recommended obtaining req and resp via AcquireRequest
 // and AcquireResponse in performance-critical code.
 func (c *PipelineClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
-	return c.getConnClient().DoDeadline(req, resp, deadline)
+	return c.getConnClient().DoDeadline(req, resp, deadline, PipelinePriorityNormal)
+}
+
+// DoCtx performs the given request and waits for response until
+// ctx is canceled.
+//
+// ctx.Err() is returned if ctx is canceled before the response is received.
+// The request is dropped without being sent if ctx is canceled while
+// the request is queued, so canceled requests don't occupy pipeline slots.
+//
+// See DoDeadline for details.
+func (c *PipelineClient) DoCtx(ctx context.Context, req *Request, resp *Response) error {
+	return c.getConnClient().DoCtx(ctx, req, resp, PipelinePriorityNormal)
+}
+
+// DoDeadlinePriority works like DoDeadline, but sends the request
+// with the given priority.
+//
+// See DoPriority for details.
+func (c *PipelineClient) DoDeadlinePriority(req *Request, resp *Response, deadline time.Time, priority PipelinePriority) error {
+	return c.getConnClient().DoDeadline(req, resp, deadline, priority)
 }
 
-func (c *pipelineConnClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+func (c *pipelineConnClient) DoDeadline(req *Request, resp *Response, deadline time.Time, priority PipelinePriority) error {
 	c.init()
+	chW := c.workCh(priority)
 
 	timeout := -time.Since(deadline)
 	if timeout < 0 {
@@ -1645,12 +4512,12 @@ func (c *pipelineConnClient) DoDeadline(req *Request, resp *Response, deadline t
 
 	// Put the request to outgoing queue
 	select {
-	case c.chW <- w:
-		// Fast path: len(c.ch) < cap(c.ch)
+	case chW <- w:
+		// Fast path: len(chW) < cap(chW)
 	default:
 		// Slow path
 		select {
-		case c.chW <- w:
+		case chW <- w:
 		case <-w.t.C:
 			releasePipelineWork(&c.workPool, w)
 			return ErrTimeout
@@ -1674,23 +4541,115 @@ func (c *pipelineConnClient) DoDeadline(req *Request, resp *Response, deadline t
 	return err
 }
 
+func (c *pipelineConnClient) DoCtx(ctx context.Context, req *Request, resp *Response, priority PipelinePriority) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.init()
+	chW := c.workCh(priority)
+
+	w := acquirePipelineWork(&c.workPool, 0)
+	if deadline, ok := ctx.Deadline(); ok {
+		w.deadline = deadline
+	}
+	w.req = &w.reqCopy
+	w.resp = &w.respCopy
+
+	// Make a copy of the request in order to avoid data races on cancellation
+	req.copyToSkipBody(&w.reqCopy)
+	swapRequestBody(req, &w.reqCopy)
+
+	// Put the request to outgoing queue
+	done := ctx.Done()
+	select {
+	case chW <- w:
+		// Fast path: len(chW) < cap(chW)
+	default:
+		// Slow path
+		select {
+		case chW <- w:
+		case <-done:
+			releasePipelineWork(&c.workPool, w)
+			return ctx.Err()
+		}
+	}
+
+	// Wait for the response
+	var err error
+	select {
+	case <-w.done:
+		if resp != nil {
+			w.respCopy.copyToSkipBody(resp)
+			swapResponseBody(resp, &w.respCopy)
+		}
+		err = w.err
+		releasePipelineWork(&c.workPool, w)
+	case <-done:
+		// The writer drops w if it isn't sent yet.
+		atomic.StoreUint32(&w.canceled, 1)
+		err = ctx.Err()
+	}
+
+	return err
+}
+
 // Do performs the given http request and sets the corresponding response.
 //
 // Request must contain at least non-zero RequestURI with full url (including
 // scheme and host) or non-zero Host header + RequestURI.
 //
-// The function doesn't follow redirects. Use Get* for following redirects.
+// The function doesn't follow redirects. Use DoRedirects or Get* for following
+// redirects.
 //
 // Response is ignored if resp is nil.
 //
 // It is recommended obtaining req and resp via AcquireRequest
 // and AcquireResponse in performance-critical code.
 func (c *PipelineClient) Do(req *Request, resp *Response) error {
-	return c.getConnClient().Do(req, resp)
+	return c.getConnClient().Do(req, resp, PipelinePriorityNormal)
+}
+
+// PipelinePriority is the priority of pipelined request.
+type PipelinePriority int
+
+const (
+	// PipelinePriorityNormal is the priority of requests sent via
+	// PipelineClient.Do, DoTimeout and DoDeadline.
+	PipelinePriorityNormal PipelinePriority = iota
+
+	// PipelinePriorityHigh requests jump ahead of pending
+	// normal-priority requests.
+	PipelinePriorityHigh
+)
+
+// DefaultMaxHighPriorityBurst is the default value
+// for PipelineClient.MaxHighPriorityBurst.
+const DefaultMaxHighPriorityBurst = 16
+
+// DoPriority works like Do, but sends the request with the given priority.
+//
+// Pending high-priority requests are sent to the server before pending
+// normal-priority requests, so latency-critical requests such as health
+// probes aren't delayed by bulk requests. Normal-priority request is sent
+// after each MaxHighPriorityBurst high-priority requests sent in a row,
+// so normal-priority requests aren't starved.
+//
+// Requests with different priorities have distinct pending queues,
+// each limited by MaxPendingRequests.
+func (c *PipelineClient) DoPriority(req *Request, resp *Response, priority PipelinePriority) error {
+	return c.getConnClient().Do(req, resp, priority)
+}
+
+func (c *pipelineConnClient) workCh(priority PipelinePriority) chan *pipelineWork {
+	if priority == PipelinePriorityHigh {
+		return c.chWHi
+	}
+	return c.chW
 }
 
-func (c *pipelineConnClient) Do(req *Request, resp *Response) error {
+func (c *pipelineConnClient) Do(req *Request, resp *Response, priority PipelinePriority) error {
 	c.init()
+	chW := c.workCh(priority)
 
 	w := acquirePipelineWork(&c.workPool, 0)
 	w.req = req
@@ -1702,17 +4661,17 @@ func (c *pipelineConnClient) Do(req *Request, resp *Response) error {
 
 	// Put the request to outgoing queue
 	select {
-	case c.chW <- w:
+	case chW <- w:
 	default:
 		// Try substituting the oldest w with the current one.
 		select {
-		case wOld := <-c.chW:
+		case wOld := <-chW:
 			wOld.err = ErrPipelineOverflow
 			wOld.done <- struct{}{}
 		default:
 		}
 		select {
-		case c.chW <- w:
+		case chW <- w:
 		default:
 			releasePipelineWork(&c.workPool, w)
 			return ErrPipelineOverflow
@@ -1737,8 +4696,13 @@ func (c *PipelineClient) getConnClient() *pipelineConnClient {
 
 func (c *PipelineClient) getConnClientUnlocked() *pipelineConnClient {
 	if len(c.connClients) == 0 {
+		for i := 1; i < c.MinConns; i++ {
+			// Open MinConns connections in advance.
+			c.newConnClient().init()
+		}
 		return c.newConnClient()
 	}
+	c.dropIdleConnClients()
 
 	// Return the client with the minimum number of pending requests.
 	minCC := c.connClients[0]
@@ -1759,30 +4723,66 @@ func (c *PipelineClient) getConnClientUnlocked() *pipelineConnClient {
 	}
 
 	maxConns := c.MaxConns
+	if maxConns < c.MinConns {
+		maxConns = c.MinConns
+	}
 	if maxConns <= 0 {
 		maxConns = 1
 	}
-	if len(c.connClients) < maxConns {
+	if len(c.connClients) < maxConns && c.mustScaleUp(minCC, minReqs) {
 		return c.newConnClient()
 	}
 	return minCC
 }
 
+// mustScaleUp returns true if a new connection must be opened,
+// since the least loaded connection cc has pendingRequests.
+func (c *PipelineClient) mustScaleUp(cc *pipelineConnClient, pendingRequests int) bool {
+	n := c.ScaleUpPendingRequests
+	if n <= 0 {
+		n = 1
+	}
+	if pendingRequests >= n {
+		return true
+	}
+	return c.ScaleUpLatency > 0 && time.Duration(atomic.LoadInt64(&cc.latency)) > c.ScaleUpLatency
+}
+
+// dropIdleConnClients drops clients above MinConns, which closed
+// their connections due to inactivity.
+func (c *PipelineClient) dropIdleConnClients() {
+	ccs := c.connClients[:0]
+	for _, cc := range c.connClients {
+		if cc.keepOpen || len(ccs) == 0 || !cc.isIdle() {
+			ccs = append(ccs, cc)
+		}
+	}
+	for i := len(ccs); i < len(c.connClients); i++ {
+		c.connClients[i] = nil
+	}
+	c.connClients = ccs
+}
+
 func (c *PipelineClient) newConnClient() *pipelineConnClient {
 	cc := &pipelineConnClient{
-		Addr:                c.Addr,
-		MaxPendingRequests:  c.MaxPendingRequests,
-		MaxBatchDelay:       c.MaxBatchDelay,
-		Dial:                c.Dial,
-		DialDualStack:       c.DialDualStack,
-		IsTLS:               c.IsTLS,
-		TLSConfig:           c.TLSConfig,
-		MaxIdleConnDuration: c.MaxIdleConnDuration,
-		ReadBufferSize:      c.ReadBufferSize,
-		WriteBufferSize:     c.WriteBufferSize,
-		ReadTimeout:         c.ReadTimeout,
-		WriteTimeout:        c.WriteTimeout,
-		Logger:              c.Logger,
+		Addr:                 c.Addr,
+		MaxPendingRequests:   c.MaxPendingRequests,
+		MaxBatchDelay:        c.MaxBatchDelay,
+		MaxHighPriorityBurst: c.MaxHighPriorityBurst,
+		Dial:                 c.Dial,
+		DialDualStack:        c.DialDualStack,
+		ConnControl:          c.ConnControl,
+		IsTLS:                c.IsTLS,
+		TLSConfig:            c.TLSConfig,
+		MaxIdleConnDuration:  c.MaxIdleConnDuration,
+		ReadBufferSize:       c.ReadBufferSize,
+		WriteBufferSize:      c.WriteBufferSize,
+		ReadTimeout:          c.ReadTimeout,
+		WriteTimeout:         c.WriteTimeout,
+		MaxResponseBodySize:  c.MaxResponseBodySize,
+		Logger:               c.Logger,
+		keepOpen:             len(c.connClients) < c.MinConns,
+		trackLatency:         c.ScaleUpLatency > 0,
 	}
 	c.connClients = append(c.connClients, cc)
 	return cc
@@ -1806,9 +4806,11 @@ func (c *pipelineConnClient) init() {
 		c.chR = make(chan *pipelineWork, maxPendingRequests)
 		if c.chW == nil {
 			c.chW = make(chan *pipelineWork, maxPendingRequests)
+			c.chWHi = make(chan *pipelineWork, maxPendingRequests)
 		}
 		go func() {
-			if err := c.worker(); err != nil {
+			connected, err := c.worker()
+			if err != nil {
 				c.logger().Printf("error in PipelineClient(%q): %s", c.Addr, err)
 				if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
 					// Throttle client reconnections on temporary errors
@@ -1821,17 +4823,28 @@ func (c *pipelineConnClient) init() {
 			// pending requests, which could be served on the next
 			// connection to the host.
 			c.chR = nil
+			pending := len(c.chW)+len(c.chWHi) > 0
 			c.chLock.Unlock()
+
+			// Requests queued after the connection has been stopped
+			// would wait for the next Do call otherwise. Do not reconnect
+			// after dial errors in order to avoid busy dial loops.
+			if connected && pending {
+				c.init()
+			}
 		}()
 	}
 	c.chLock.Unlock()
 }
 
-func (c *pipelineConnClient) worker() error {
+// worker serves the requests over a single connection to the host.
+//
+// connected is set to true if the connection has been established.
+func (c *pipelineConnClient) worker() (connected bool, err error) {
 	tlsConfig := c.cachedTLSConfig()
-	conn, err := dialAddr(c.Addr, c.Dial, c.DialDualStack, c.IsTLS, tlsConfig)
+	conn, err := dialAddr(c.Addr, c.Dial, c.DialDualStack, c.ConnControl, c.IsTLS, tlsConfig)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// Start reader and writer
@@ -1865,7 +4878,7 @@ func (c *pipelineConnClient) worker() error {
 		w.done <- struct{}{}
 	}
 
-	return err
+	return true, err
 }
 
 func (c *pipelineConnClient) cachedTLSConfig() *tls.Config {
@@ -1893,6 +4906,7 @@ func (c *pipelineConnClient) writer(conn net.Conn, stopCh <-chan struct{}) error
 	defer bw.Flush()
 	chR := c.chR
 	chW := c.chW
+	chWHi := c.chWHi
 	writeTimeout := c.WriteTimeout
 
 	maxIdleConnDuration := c.MaxIdleConnDuration
@@ -1900,6 +4914,10 @@ func (c *pipelineConnClient) writer(conn net.Conn, stopCh <-chan struct{}) error
 		maxIdleConnDuration = DefaultMaxIdleConnDuration
 	}
 	maxBatchDelay := c.MaxBatchDelay
+	maxHighPriorityBurst := c.MaxHighPriorityBurst
+	if maxHighPriorityBurst <= 0 {
+		maxHighPriorityBurst = DefaultMaxHighPriorityBurst
+	}
 
 	var (
 		stopTimer      = time.NewTimer(time.Hour)
@@ -1911,32 +4929,52 @@ func (c *pipelineConnClient) writer(conn net.Conn, stopCh <-chan struct{}) error
 		err error
 
 		lastWriteDeadlineTime time.Time
+
+		// The number of high-priority requests sent in a row.
+		highPriorityBurst int
 	)
 	close(instantTimerCh)
 	for {
 	againChW:
-		select {
-		case w = <-chW:
-			// Fast path: len(chW) > 0
-		default:
-			// Slow path
-			stopTimer.Reset(maxIdleConnDuration)
+		w = nil
+		if highPriorityBurst < maxHighPriorityBurst || len(chW) == 0 {
+			select {
+			case w = <-chWHi:
+				highPriorityBurst++
+			default:
+			}
+		}
+		if w == nil {
 			select {
 			case w = <-chW:
-			case <-stopTimer.C:
-				return nil
-			case <-stopCh:
-				return nil
-			case <-flushTimerCh:
-				if err = bw.Flush(); err != nil {
-					return err
+				// Fast path: len(chW) > 0
+				highPriorityBurst = 0
+			default:
+				// Slow path
+				stopTimer.Reset(maxIdleConnDuration)
+				select {
+				case w = <-chWHi:
+					highPriorityBurst++
+				case w = <-chW:
+					highPriorityBurst = 0
+				case <-stopTimer.C:
+					if !c.keepOpen {
+						return nil
+					}
+					goto againChW
+				case <-stopCh:
+					return nil
+				case <-flushTimerCh:
+					if err = bw.Flush(); err != nil {
+						return err
+					}
+					flushTimerCh = nil
+					goto againChW
 				}
-				flushTimerCh = nil
-				goto againChW
 			}
 		}
 
-		if !w.deadline.IsZero() && time.Since(w.deadline) >= 0 {
+		if atomic.LoadUint32(&w.canceled) != 0 || !w.deadline.IsZero() && time.Since(w.deadline) >= 0 {
 			w.err = ErrTimeout
 			w.done <- struct{}{}
 			continue
@@ -1956,12 +4994,15 @@ func (c *pipelineConnClient) writer(conn net.Conn, stopCh <-chan struct{}) error
 				lastWriteDeadlineTime = currentTime
 			}
 		}
+		if c.trackLatency {
+			w.startTime = time.Now()
+		}
 		if err = w.req.Write(bw); err != nil {
 			w.err = err
 			w.done <- struct{}{}
 			return err
 		}
-		if flushTimerCh == nil && (len(chW) == 0 || len(chR) == cap(chR)) {
+		if flushTimerCh == nil && (len(chW)+len(chWHi) == 0 || len(chR) == cap(chR)) {
 			if maxBatchDelay > 0 {
 				flushTimer.Reset(maxBatchDelay)
 				flushTimerCh = flushTimer.C
@@ -2003,6 +5044,7 @@ func (c *pipelineConnClient) reader(conn net.Conn, stopCh <-chan struct{}) error
 	br := bufio.NewReaderSize(conn, readBufferSize)
 	chR := c.chR
 	readTimeout := c.ReadTimeout
+	maxBodySize := c.MaxResponseBodySize
 
 	var (
 		w   *pipelineWork
@@ -2037,11 +5079,14 @@ func (c *pipelineConnClient) reader(conn net.Conn, stopCh <-chan struct{}) error
 				lastReadDeadlineTime = currentTime
 			}
 		}
-		if err = w.resp.Read(br); err != nil {
+		if err = w.resp.ReadLimitBody(br, maxBodySize); err != nil {
 			w.err = err
 			w.done <- struct{}{}
 			return err
 		}
+		if !w.startTime.IsZero() {
+			atomic.StoreInt64(&c.latency, int64(time.Since(w.startTime)))
+		}
 
 		w.done <- struct{}{}
 	}
@@ -2077,11 +5122,20 @@ func (c *pipelineConnClient) PendingRequests() int {
 	c.init()
 
 	c.chLock.Lock()
-	n := len(c.chR) + len(c.chW)
+	n := len(c.chR) + len(c.chW) + len(c.chWHi)
 	c.chLock.Unlock()
 	return n
 }
 
+// isIdle returns true if the connection is closed and there are no
+// pending requests.
+func (c *pipelineConnClient) isIdle() bool {
+	c.chLock.Lock()
+	idle := c.chR == nil && len(c.chW)+len(c.chWHi) == 0
+	c.chLock.Unlock()
+	return idle
+}
+
 var errPipelineConnStopped = errors.New("pipeline connection has been stopped")
 
 func acquirePipelineWork(pool *sync.Pool, timeout time.Duration) *pipelineWork {
@@ -2092,6 +5146,8 @@ func acquirePipelineWork(pool *sync.Pool, timeout time.Duration) *pipelineWork {
 		}
 	}
 	w := v.(*pipelineWork)
+	w.canceled = 0
+	w.startTime = zeroTime
 	if timeout > 0 {
 		if w.t == nil {
 			w.t = time.NewTimer(timeout)
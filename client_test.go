@@ -2,15 +2,23 @@ package fasthttp
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -128,6 +136,224 @@ func TestPipelineClientDoBatchDelayConcurrentMultiConn(t *testing.T) {
 	testPipelineClientDoConcurrent(t, 10, 5*time.Millisecond, 3)
 }
 
+func TestPipelineClientDoPriority(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	var (
+		pathsLock sync.Mutex
+		paths     []string
+	)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			pathsLock.Lock()
+			paths = append(paths, string(ctx.Path()))
+			pathsLock.Unlock()
+		},
+	}
+	go s.Serve(ln)
+
+	dialCh := make(chan struct{})
+	c := &PipelineClient{
+		Dial: func(addr string) (net.Conn, error) {
+			<-dialCh
+			return ln.Dial()
+		},
+		MaxHighPriorityBurst: 2,
+		Logger:               &customLogger{},
+	}
+
+	// Queue the requests while the connection is being established.
+	var wg sync.WaitGroup
+	doRequest := func(path string, priority PipelinePriority) {
+		n := c.PendingRequests() + 1
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var req Request
+			req.SetRequestURI("http://foobar" + path)
+			if err := c.DoPriority(&req, nil, priority); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+		for c.PendingRequests() < n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		doRequest(fmt.Sprintf("/n%d", i), PipelinePriorityNormal)
+	}
+	for i := 0; i < 5; i++ {
+		doRequest(fmt.Sprintf("/h%d", i), PipelinePriorityHigh)
+	}
+	close(dialCh)
+	wg.Wait()
+
+	expectedPaths := "/h0 /h1 /n0 /h2 /h3 /n1 /h4 /n2 /n3"
+	if s := strings.Join(paths, " "); s != expectedPaths {
+		t.Fatalf("unexpected order of requests %q. Expecting %q", s, expectedPaths)
+	}
+}
+
+func TestPipelineClientDoCtx(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	var (
+		pathsLock sync.Mutex
+		paths     []string
+	)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			pathsLock.Lock()
+			paths = append(paths, string(ctx.Path()))
+			pathsLock.Unlock()
+		},
+	}
+	go s.Serve(ln)
+
+	dialCh := make(chan struct{})
+	c := &PipelineClient{
+		Dial: func(addr string) (net.Conn, error) {
+			<-dialCh
+			return ln.Dial()
+		},
+		Logger: &customLogger{},
+	}
+
+	// Requests canceled while the connection is being established
+	// mustn't be sent.
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		var req Request
+		req.SetRequestURI("http://foobar/canceled")
+		errCh <- c.DoCtx(ctx, &req, nil)
+	}()
+	for c.PendingRequests() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	var req Request
+	req.SetRequestURI("http://foobar/expired")
+	if err := c.DoCtx(ctx, &req, nil); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.DeadlineExceeded)
+	}
+
+	close(dialCh)
+	var resp Response
+	req.SetRequestURI("http://foobar/ok")
+	if err := c.DoCtx(context.Background(), &req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	pathsLock.Lock()
+	defer pathsLock.Unlock()
+	if s := strings.Join(paths, " "); s != "/ok" {
+		t.Fatalf("unexpected requests sent %q. Expecting %q", s, "/ok")
+	}
+}
+
+func TestPipelineClientScaling(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	var dials uint32
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/slow" {
+				time.Sleep(5 * time.Millisecond)
+			}
+		},
+	}
+	go s.Serve(ln)
+
+	newClient := func() *PipelineClient {
+		atomic.StoreUint32(&dials, 0)
+		return &PipelineClient{
+			Dial: func(addr string) (net.Conn, error) {
+				atomic.AddUint32(&dials, 1)
+				return ln.Dial()
+			},
+			MaxIdleConnDuration: 100 * time.Millisecond,
+			Logger:              &customLogger{},
+		}
+	}
+	connClients := func(c *PipelineClient) int {
+		c.connClientsLock.Lock()
+		n := len(c.connClients)
+		c.connClientsLock.Unlock()
+		return n
+	}
+	doRequests := func(c *PipelineClient, path string, concurrency int) {
+		t.Helper()
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var req Request
+				req.SetRequestURI("http://foobar" + path)
+				if err := c.Do(&req, nil); err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	// MinConns connections must be opened on the first request.
+	c := newClient()
+	c.MinConns = 3
+	doRequests(c, "/", 1)
+	if n := connClients(c); n != 3 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 3", n)
+	}
+	for atomic.LoadUint32(&dials) < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Connections above MinConns must be dropped after idle timeout.
+	c = newClient()
+	c.MinConns = 1
+	c.MaxConns = 4
+	doRequests(c, "/slow", 10)
+	if n := connClients(c); n <= 1 {
+		t.Fatalf("unexpected number of connections: %d. Expecting more than 1", n)
+	}
+	time.Sleep(300 * time.Millisecond)
+	doRequests(c, "/", 1)
+	if n := connClients(c); n != 1 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 1", n)
+	}
+
+	// New connections mustn't be opened below ScaleUpPendingRequests.
+	c = newClient()
+	c.MaxConns = 4
+	c.ScaleUpPendingRequests = 100
+	doRequests(c, "/slow", 10)
+	if n := connClients(c); n != 1 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 1", n)
+	}
+
+	// New connections must be opened on high latency.
+	c.ScaleUpLatency = time.Millisecond
+	c.connClients = nil
+	doRequests(c, "/slow", 1)
+	doRequests(c, "/slow", 10)
+	if n := connClients(c); n <= 1 {
+		t.Fatalf("unexpected number of connections: %d. Expecting more than 1", n)
+	}
+}
+
 func testPipelineClientDoConcurrent(t *testing.T, concurrency int, maxBatchDelay time.Duration, maxConns int) {
 	ln := fasthttputil.NewInmemoryListener()
 
@@ -569,649 +795,3506 @@ func TestClientFollowRedirects(t *testing.T) {
 	}
 }
 
-func TestClientGetTimeoutSuccess(t *testing.T) {
-	addr := "127.0.0.1:56889"
+func TestClientConnControl(t *testing.T) {
+	addr := "127.0.0.1:56779"
 	s := startEchoServer(t, "tcp", addr)
 	defer s.Stop()
 
-	addr = "http://" + addr
-	testClientGetTimeoutSuccess(t, &defaultClient, addr, 100)
+	var calls uint32
+	c := &Client{
+		ConnControl: func(rc syscall.RawConn) error {
+			atomic.AddUint32(&calls, 1)
+			return rc.Control(func(fd uintptr) {})
+		},
+	}
+	for i := 0; i < 3; i++ {
+		statusCode, _, err := c.Get(nil, "http://"+addr+"/foo")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
+		}
+	}
+	if n := atomic.LoadUint32(&calls); n != 1 {
+		t.Fatalf("unexpected number of ConnControl calls: %d. Expecting 1", n)
+	}
+
+	c = &Client{
+		ConnControl: func(rc syscall.RawConn) error {
+			return fmt.Errorf("foobar")
+		},
+	}
+	_, _, err := c.Get(nil, "http://"+addr+"/foo")
+	if err == nil || !strings.Contains(err.Error(), "foobar") {
+		t.Fatalf("unexpected error: %v. Expecting ConnControl error", err)
+	}
 }
 
-func TestClientGetTimeoutSuccessConcurrent(t *testing.T) {
-	addr := "127.0.0.1:56989"
-	s := startEchoServer(t, "tcp", addr)
-	defer s.Stop()
+func TestHostClientDoRedirects(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
 
-	addr = "http://" + addr
-	var wg sync.WaitGroup
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			testClientGetTimeoutSuccess(t, &defaultClient, addr, 100)
-		}()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/a/foo":
+				ctx.Response.Header.Set("Location", "bar?x=1")
+				ctx.SetStatusCode(StatusTemporaryRedirect)
+			case "/a/bar":
+				ctx.Response.Header.Set("Location", "/baz")
+				ctx.SetStatusCode(StatusSeeOther)
+			case "/loop":
+				ctx.Response.Header.Set("Location", "/loop")
+				ctx.SetStatusCode(StatusFound)
+			case "/other":
+				ctx.Response.Header.Set("Location", "http://other.com/baz")
+				ctx.SetStatusCode(StatusMovedPermanently)
+			default:
+				fmt.Fprintf(ctx, "%s %s %s %s", ctx.Method(), ctx.Host(), ctx.RequestURI(), ctx.PostBody())
+			}
+		},
 	}
-	wg.Wait()
-}
+	go s.Serve(ln)
 
-func TestClientDoTimeoutSuccess(t *testing.T) {
-	addr := "127.0.0.1:63897"
-	s := startEchoServer(t, "tcp", addr)
-	defer s.Stop()
+	dial := func(addr string) (net.Conn, error) {
+		return ln.Dial()
+	}
+	c := &HostClient{
+		Addr: "foobar.com",
+		Dial: dial,
+	}
 
-	addr = "http://" + addr
-	testClientDoTimeoutSuccess(t, &defaultClient, addr, 100)
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("http://foobar.com/a/foo")
+	req.SetBodyString("abc")
+	if err := c.DoRedirects(req, resp, 16); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode())
+	}
+	if string(resp.Body()) != "GET foobar.com /baz " {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "GET foobar.com /baz ")
+	}
+
+	req.Reset()
+	req.Header.SetMethod("PUT")
+	req.SetRequestURI("/a/foo")
+	req.Header.SetHost("foobar.com")
+	req.SetBodyString("abc")
+	if err := c.DoRedirects(req, resp, 1); err != ErrTooManyRedirects {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTooManyRedirects)
+	}
+	if string(req.URI().FullURI()) != "http://foobar.com/a/bar?x=1" {
+		t.Fatalf("unexpected request uri %q. Expecting %q", req.URI().FullURI(), "http://foobar.com/a/bar?x=1")
+	}
+	if !req.Header.IsPut() || string(req.Body()) != "abc" {
+		t.Fatalf("307 redirect must preserve method and body. Got %q %q", req.Header.Method(), req.Body())
+	}
+
+	req.Reset()
+	req.SetRequestURI("http://foobar.com/loop")
+	if err := c.DoRedirects(req, resp, 3); err != ErrTooManyRedirects {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTooManyRedirects)
+	}
+
+	req.Reset()
+	req.SetRequestURI("http://foobar.com/other")
+	if err := c.DoRedirects(req, resp, 16); err != errRedirectHostChanged {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errRedirectHostChanged)
+	}
+
+	// Client follows redirects to other hosts.
+	cc := &Client{
+		Dial: dial,
+	}
+	req.Reset()
+	req.SetRequestURI("http://foobar.com/other")
+	if err := cc.DoRedirects(req, resp, 16); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "GET other.com /baz " {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "GET other.com /baz ")
+	}
 }
 
-func TestClientDoTimeoutSuccessConcurrent(t *testing.T) {
-	addr := "127.0.0.1:63898"
-	s := startEchoServer(t, "tcp", addr)
-	defer s.Stop()
+type idleConnsCheckDoer struct {
+	hc      *HostClient
+	timeout time.Duration
+	calls   int
+	idle    []int
+}
 
-	addr = "http://" + addr
-	var wg sync.WaitGroup
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			testClientDoTimeoutSuccess(t, &defaultClient, addr, 100)
-		}()
+func (d *idleConnsCheckDoer) Do(req *Request, resp *Response) error {
+	if d.calls > 0 {
+		d.idle = append(d.idle, d.hc.IdleConns())
 	}
-	wg.Wait()
+	d.calls++
+	if d.timeout > 0 {
+		return d.hc.DoTimeout(req, resp, d.timeout)
+	}
+	return d.hc.Do(req, resp)
 }
 
-func TestClientGetTimeoutError(t *testing.T) {
-	c := &Client{
-		Dial: func(addr string) (net.Conn, error) {
-			return &readTimeoutConn{t: time.Second}, nil
+func TestClientRedirectConnReuse(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/a":
+				ctx.Redirect("/b", StatusFound)
+			case "/b":
+				ctx.Redirect("/c", StatusTemporaryRedirect)
+			case "/other":
+				ctx.Redirect("http://other.com/c", StatusFound)
+			default:
+				fmt.Fprintf(ctx, "%s%s", ctx.Host(), ctx.Path())
+			}
 		},
 	}
+	go s.Serve(ln)
 
-	testClientGetTimeoutError(t, c, 100)
-}
-
-func TestClientGetTimeoutErrorConcurrent(t *testing.T) {
-	c := &Client{
+	// Get helpers keep the connection between hops.
+	hc := &HostClient{
+		Addr: "foobar",
 		Dial: func(addr string) (net.Conn, error) {
-			return &readTimeoutConn{t: time.Second}, nil
+			return ln.Dial()
 		},
-		MaxConnsPerHost: 1000,
+	}
+	d := &idleConnsCheckDoer{hc: hc}
+	statusCode, body, err := doRequestFollowRedirects(AcquireRequest(), nil, "http://foobar/a", d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusTemporaryRedirect {
+		t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusTemporaryRedirect)
+	}
+	if len(d.idle) != 1 || d.idle[0] != 0 {
+		t.Fatalf("unexpected idle conns between hops: %v. Expecting [0]", d.idle)
+	}
+	if n := hc.IdleConns(); n != 1 {
+		t.Fatalf("unexpected idle conns after redirects: %d. Expecting 1; body %q", n, body)
 	}
 
-	var wg sync.WaitGroup
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			testClientGetTimeoutError(t, c, 100)
-		}()
+	// DoTimeout keeps the connection held for the request copy.
+	d = &idleConnsCheckDoer{hc: hc, timeout: time.Second}
+	statusCode, _, err = doRequestFollowRedirects(AcquireRequest(), nil, "http://foobar/a", d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusTemporaryRedirect {
+		t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusTemporaryRedirect)
+	}
+	if len(d.idle) != 1 || d.idle[0] != 0 {
+		t.Fatalf("unexpected idle conns between hops with DoTimeout: %v. Expecting [0]", d.idle)
+	}
+	if n := hc.IdleConns(); n != 1 {
+		t.Fatalf("unexpected idle conns after redirects with DoTimeout: %d. Expecting 1", n)
 	}
-	wg.Wait()
-}
 
-func TestClientDoTimeoutError(t *testing.T) {
-	c := &Client{
+	// GetTimeout keeps the connection between hops.
+	var idle []int
+	hcTimeout := &HostClient{
+		Addr: "foobar",
 		Dial: func(addr string) (net.Conn, error) {
-			return &readTimeoutConn{t: time.Second}, nil
+			return ln.Dial()
 		},
 	}
+	hcTimeout.OnRequest = []RequestHook{func(req *Request) error {
+		idle = append(idle, hcTimeout.IdleConns())
+		return nil
+	}}
+	statusCode, _, err = hcTimeout.GetTimeout(nil, "http://foobar/a", time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusTemporaryRedirect {
+		t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusTemporaryRedirect)
+	}
+	if len(idle) != 2 || idle[0] != 0 || idle[1] != 0 {
+		t.Fatalf("unexpected idle conns with GetTimeout: %v. Expecting [0 0]", idle)
+	}
+	if n := hcTimeout.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected conns count with GetTimeout: %d. Expecting 1", n)
+	}
 
-	testClientDoTimeoutError(t, c, 100)
-}
-
-func TestClientDoTimeoutErrorConcurrent(t *testing.T) {
-	c := &Client{
+	// Redirect policy keeps the connection between hops to the same host.
+	var hops []string
+	var c *Client
+	c = &Client{
 		Dial: func(addr string) (net.Conn, error) {
-			return &readTimeoutConn{t: time.Second}, nil
+			return ln.Dial()
 		},
-		MaxConnsPerHost: 1000,
+		RedirectPolicy: &RedirectPolicy{
+			OnRedirect: func(req *Request, resp *Response) error {
+				hc, err := c.hostClient(req, "")
+				if err != nil {
+					return err
+				}
+				hops = append(hops, fmt.Sprintf("%s %d/%d", req.URI().Host(), hc.IdleConns(), hc.ConnsCount()))
+				return nil
+			},
+		},
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar.com/a")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "foobar.com/c" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "foobar.com/c")
+	}
+	if len(hops) != 2 || hops[0] != "foobar.com 0/1" || hops[1] != "foobar.com 0/1" {
+		t.Fatalf("unexpected redirect hops: %q", hops)
 	}
 
-	var wg sync.WaitGroup
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			testClientDoTimeoutError(t, c, 100)
-		}()
+	// The held connection is released on redirect to another host.
+	req.Reset()
+	req.SetRequestURI("http://foobar.com/other")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "other.com/c" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "other.com/c")
+	}
+	req.SetRequestURI("http://foobar.com/")
+	hc, err = c.hostClient(req, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := hc.IdleConns(); n != 1 {
+		t.Fatalf("unexpected idle conns: %d. Expecting 1", n)
+	}
+	if req.redirectConn != nil || req.holdRedirectConn {
+		t.Fatalf("the connection must be released after following redirects")
 	}
-	wg.Wait()
 }
 
-func testClientDoTimeoutError(t *testing.T, c *Client, n int) {
-	var req Request
-	var resp Response
+func TestClientDoRedirectsChain(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/a/foo":
+				ctx.Response.Header.Set("Location", "bar?x=1")
+				ctx.SetStatusCode(StatusTemporaryRedirect)
+			case "/a/bar":
+				ctx.Response.Header.Set("Location", "http://other.com/baz")
+				ctx.SetStatusCode(StatusSeeOther)
+			case "/loop":
+				ctx.Response.Header.Set("Location", "/loop")
+				ctx.SetStatusCode(StatusFound)
+			default:
+				fmt.Fprintf(ctx, "%s %s %s %s", ctx.Method(), ctx.Host(), ctx.RequestURI(), ctx.PostBody())
+			}
+		},
+	}
+	go s.Serve(ln)
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	req.Header.SetMethod("PUT")
+	req.SetRequestURI("http://foobar.com/a/foo")
+	req.SetBodyString("abc")
+	chain, err := c.DoRedirectsChain(nil, req, resp, 16)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "GET other.com /baz " {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "GET other.com /baz ")
+	}
+	expectedChain := []RedirectHop{
+		{
+			Method:     "PUT",
+			URI:        "http://foobar.com/a/foo",
+			StatusCode: StatusTemporaryRedirect,
+			Location:   "bar?x=1",
+		},
+		{
+			Method:     "PUT",
+			URI:        "http://foobar.com/a/bar?x=1",
+			StatusCode: StatusSeeOther,
+			Location:   "http://other.com/baz",
+		},
+	}
+	if !reflect.DeepEqual(chain, expectedChain) {
+		t.Fatalf("unexpected chain %+v. Expecting %+v", chain, expectedChain)
+	}
+
+	// No redirects.
+	req.Reset()
 	req.SetRequestURI("http://foobar.com/baz")
-	for i := 0; i < n; i++ {
-		err := c.DoTimeout(&req, &resp, time.Millisecond)
-		if err == nil {
-			t.Fatalf("expecting error")
-		}
-		if err != ErrTimeout {
-			t.Fatalf("unexpected error: %s. Expecting %s", err, ErrTimeout)
+	chain, err = c.DoRedirectsChain(chain[:0], req, resp, 16)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(chain) != 0 {
+		t.Fatalf("unexpected chain %+v. Expecting empty chain", chain)
+	}
+
+	// The chain is returned on errors.
+	req.Reset()
+	req.SetRequestURI("http://foobar.com/loop")
+	chain, err = c.DoRedirectsChain(nil, req, resp, 2)
+	if err != ErrTooManyRedirects {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTooManyRedirects)
+	}
+	if len(chain) != 3 {
+		t.Fatalf("unexpected chain length: %d. Expecting 3", len(chain))
+	}
+	for _, hop := range chain {
+		if hop.URI != "http://foobar.com/loop" || hop.StatusCode != StatusFound {
+			t.Fatalf("unexpected redirect hop %+v", hop)
 		}
 	}
 }
 
-func testClientGetTimeoutError(t *testing.T, c *Client, n int) {
-	buf := make([]byte, 10)
-	for i := 0; i < n; i++ {
-		statusCode, body, err := c.GetTimeout(buf, "http://foobar.com/baz", time.Millisecond)
-		if err == nil {
-			t.Fatalf("expecting error")
+func TestClientTrailers(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Response.Header.SetTrailer("X-Echo", string(ctx.Request.Header.PeekTrailer("X-Foo")))
+			ctx.SetBody(ctx.PostBody())
+		},
+	}
+	go s.Serve(ln)
+
+	for _, stream := range []bool{false, true} {
+		c := &HostClient{
+			Addr: "foobar",
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+			StreamResponseBody: stream,
 		}
-		if err != ErrTimeout {
-			t.Fatalf("unexpected error: %s. Expecting %s", err, ErrTimeout)
+		req := AcquireRequest()
+		resp := AcquireResponse()
+		req.Header.SetMethod("POST")
+		req.SetRequestURI("http://foobar/")
+		req.SetBodyString("abc")
+		req.Header.SetTrailer("X-Foo", "bar")
+		if err := c.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
 		}
-		if statusCode != 0 {
-			t.Fatalf("unexpected statusCode=%d. Expecting %d", statusCode, 0)
+		var buf bytes.Buffer
+		if err := resp.BodyWriteTo(&buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
 		}
-		if body == nil {
-			t.Fatalf("body must be non-nil")
+		if buf.String() != "abc" {
+			t.Fatalf("unexpected body %q. Expecting %q", buf.String(), "abc")
 		}
+		if v := resp.Header.PeekTrailer("X-Echo"); string(v) != "bar" {
+			t.Fatalf("unexpected trailer value %q. Expecting %q", v, "bar")
+		}
+		ReleaseRequest(req)
+		ReleaseResponse(resp)
 	}
 }
 
-type readTimeoutConn struct {
-	net.Conn
-	t time.Duration
-}
+func TestClientSetTLSConfig(t *testing.T) {
+	ca := newTestOCSPCA(t)
+	serverCert := ca.issue(t, 1)
+	ln, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			// Force new connection with new handshake per each request.
+			ctx.SetConnectionClose()
+			fmt.Fprintf(ctx, "%s", ctx.TLSConnectionState().PeerCertificates[0].SerialNumber)
+		},
+	}
+	go s.Serve(ln)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	newTLSConfig := func(serial int64) *tls.Config {
+		return &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{ca.issue(t, serial)},
+		}
+	}
+	c := &Client{
+		TLSConfig: newTLSConfig(2),
+	}
+	url := "https://" + ln.Addr().String() + "/"
+	test := func(expectedSerial string) {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			statusCode, body, err := c.Get(nil, url)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if statusCode != StatusOK || string(body) != expectedSerial {
+				t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, expectedSerial)
+			}
+		}
+	}
+	test("2")
 
-func (r *readTimeoutConn) Read(p []byte) (int, error) {
-	time.Sleep(r.t)
-	return 0, io.EOF
+	// New connections must use the rotated client certificate.
+	c.SetTLSConfig(newTLSConfig(3))
+	test("3")
+	if c.TLSConfig.Certificates[0].Leaf.SerialNumber.Int64() != 3 {
+		t.Fatalf("TLSConfig hasn't been replaced")
+	}
 }
 
-func (r *readTimeoutConn) Write(p []byte) (int, error) {
-	return len(p), nil
+type countingReader struct {
+	r io.Reader
+	n int32
 }
 
-func (r *readTimeoutConn) Close() error {
-	return nil
+func (r *countingReader) Read(p []byte) (int, error) {
+	atomic.AddInt32(&r.n, 1)
+	return r.r.Read(p)
 }
 
-func TestClientNonIdempotentRetry(t *testing.T) {
-	dialsCount := 0
-	c := &Client{
-		Dial: func(addr string) (net.Conn, error) {
-			dialsCount++
-			switch dialsCount {
-			case 1, 2:
-				return &readErrorConn{}, nil
-			case 3:
-				return &singleReadConn{
-					s: "HTTP/1.1 345 OK\r\nContent-Type: foobar\r\nContent-Length: 7\r\n\r\n0123456",
-				}, nil
-			default:
-				t.Fatalf("unexpected number of dials: %d", dialsCount)
+func TestHostClientExpectContinue(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.PostBody())
+		},
+		ContinueHandler: func(ctx *RequestCtx) bool {
+			if string(ctx.Path()) == "/reject" {
+				ctx.Error("rejected", StatusUnauthorized)
+				return false
 			}
-			panic("unreachable")
+			return true
 		},
+		Logger: &customLogger{},
 	}
+	go s.Serve(ln)
 
-	// This POST must fail.
-	dialsCount = 0
-	statusCode, body, err := c.Post(nil, "http://foobar/a/b", nil)
-	if err == nil {
-		t.Fatalf("expecting non-nil error")
+	var reasonsLock sync.Mutex
+	var reasons []ConnEvictionReason
+	c := &HostClient{
+		Addr:            ln.Addr().String(),
+		ContinueTimeout: 5 * time.Second,
+		OnConnEvicted: func(remoteAddr string, reason ConnEvictionReason) {
+			reasonsLock.Lock()
+			reasons = append(reasons, reason)
+			reasonsLock.Unlock()
+		},
 	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
 
-	// Verify that idempotent GET succeeds.
-	dialsCount = 0
-	statusCode, body, err = c.Get(nil, "http://foobar/a/b")
+	// The body is sent after 100 Continue response.
+	req.Header.SetMethod("POST")
+	req.Header.Set("Expect", "100-continue")
+	req.SetRequestURI("http://" + c.Addr + "/accept")
+	req.SetBodyString("foobar")
+	start := time.Now()
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK || string(resp.Body()) != "foobar" {
+		t.Fatalf("unexpected response: %d %q", resp.StatusCode(), resp.Body())
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("too long request duration: %s", d)
+	}
+
+	// The body isn't sent if the request is rejected.
+	cr := &countingReader{r: strings.NewReader("foobar")}
+	req.SetRequestURI("http://" + c.Addr + "/reject")
+	req.SetBodyStream(cr, 6)
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusUnauthorized || string(resp.Body()) != "rejected" {
+		t.Fatalf("unexpected response: %d %q", resp.StatusCode(), resp.Body())
+	}
+	if n := atomic.LoadInt32(&cr.n); n != 0 {
+		t.Fatalf("unexpected number of body reads: %d. Expecting 0", n)
+	}
+	// The connection closed after the rejection mustn't be reported
+	// as exceeding MaxConnDuration.
+	reasonsLock.Lock()
+	for _, reason := range reasons {
+		if reason == ConnEvictedMaxConnDuration {
+			t.Fatalf("unexpected eviction reason: %s", reason)
+		}
+	}
+	reasonsLock.Unlock()
+
+	// The connection is closed after the rejection, so the next request succeeds.
+	req.SetRequestURI("http://" + c.Addr + "/accept")
+	req.SetBodyString("baz")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "baz" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "baz")
+	}
+}
+
+func TestHostClientExpectContinueTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
-	if statusCode != 345 {
-		t.Fatalf("unexpected status code: %d. Expecting 345", statusCode)
+	defer ln.Close()
+
+	// The server doesn't send 100 Continue response.
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		var req Request
+		if err := req.Header.Read(br); err != nil {
+			return
+		}
+		if err := req.ContinueReadBody(br, 0); err != nil {
+			return
+		}
+		var resp Response
+		resp.SetBody(req.Body())
+		bw := bufio.NewWriter(conn)
+		resp.Write(bw)
+		bw.Flush()
+	}()
+
+	c := &HostClient{
+		Addr:            ln.Addr().String(),
+		ContinueTimeout: 50 * time.Millisecond,
+		ReadTimeout:     5 * time.Second,
 	}
-	if string(body) != "0123456" {
-		t.Fatalf("unexpected body: %q. Expecting %q", body, "0123456")
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.Header.SetMethod("POST")
+	req.Header.Set("Expect", "100-continue")
+	req.SetRequestURI("http://" + c.Addr + "/")
+	req.SetBodyString("foobar")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK || string(resp.Body()) != "foobar" {
+		t.Fatalf("unexpected response: %d %q", resp.StatusCode(), resp.Body())
 	}
 }
 
-func TestClientIdempotentRequest(t *testing.T) {
-	dialsCount := 0
-	c := &Client{
-		Dial: func(addr string) (net.Conn, error) {
-			dialsCount++
-			switch dialsCount {
-			case 1:
-				return &singleReadConn{
-					s: "invalid response",
-				}, nil
-			case 2:
-				return &writeErrorConn{}, nil
-			case 3:
-				return &readErrorConn{}, nil
-			case 4:
-				return &singleReadConn{
-					s: "HTTP/1.1 345 OK\r\nContent-Type: foobar\r\nContent-Length: 7\r\n\r\n0123456",
-				}, nil
+func TestClientRedirectPolicy(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/foo":
+				ctx.Response.Header.Set("Location", "/bar")
+				ctx.SetStatusCode(StatusTemporaryRedirect)
+			case "/other":
+				ctx.Response.Header.Set("Location", "http://other.com/bar")
+				ctx.SetStatusCode(StatusFound)
 			default:
-				t.Fatalf("unexpected number of dials: %d", dialsCount)
+				fmt.Fprintf(ctx, "%s %s %s %s %s", ctx.Method(), ctx.Host(), ctx.RequestURI(),
+					ctx.Request.Header.Peek("Authorization"), ctx.PostBody())
 			}
-			panic("unreachable")
 		},
 	}
+	go s.Serve(ln)
 
-	// idempotent GET must succeed.
-	statusCode, body, err := c.Get(nil, "http://foobar/a/b")
-	if err != nil {
+	var hops []string
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		RedirectPolicy: &RedirectPolicy{
+			DisablePreserveMethod: true,
+			OnRedirect: func(req *Request, resp *Response) error {
+				hops = append(hops, fmt.Sprintf("%d %s", resp.StatusCode(), req.URI().FullURI()))
+				req.Header.Del("Authorization")
+				return nil
+			},
+		},
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	// Do follows redirects according to the policy.
+	req.Header.SetMethod("PUT")
+	req.SetRequestURI("http://foobar.com/foo")
+	req.Header.Set("Authorization", "secret")
+	req.SetBodyString("abc")
+	if err := c.Do(req, resp); err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
-	if statusCode != 345 {
-		t.Fatalf("unexpected status code: %d. Expecting 345", statusCode)
+	if string(resp.Body()) != "GET foobar.com /bar  " {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "GET foobar.com /bar  ")
 	}
-	if string(body) != "0123456" {
-		t.Fatalf("unexpected body: %q. Expecting %q", body, "0123456")
+	if len(hops) != 1 || hops[0] != "307 http://foobar.com/bar" {
+		t.Fatalf("unexpected redirect hops: %q", hops)
 	}
 
-	var args Args
+	req.Reset()
+	req.SetRequestURI("http://foobar.com/other")
+	if err := c.DoTimeout(req, resp, time.Second); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "GET other.com /bar  " {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "GET other.com /bar  ")
+	}
 
-	// non-idempotent POST must fail on incorrect singleReadConn
-	dialsCount = 0
-	_, _, err = c.Post(nil, "http://foobar/a/b", &args)
-	if err == nil {
-		t.Fatalf("expecting error")
+	c.RedirectPolicy.DisallowCrossHost = true
+	req.Reset()
+	req.SetRequestURI("http://foobar.com/other")
+	if err := c.Do(req, resp); err != errCrossHostRedirect {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errCrossHostRedirect)
 	}
 
-	// non-idempotent POST must fail on incorrect singleReadConn
-	dialsCount = 0
-	_, _, err = c.Post(nil, "http://foobar/a/b", nil)
-	if err == nil {
-		t.Fatalf("expecting error")
+	errStop := errors.New("stop")
+	c.RedirectPolicy.OnRedirect = func(req *Request, resp *Response) error {
+		return errStop
+	}
+	req.Reset()
+	req.SetRequestURI("http://foobar.com/foo")
+	if err := c.Do(req, resp); err != errStop {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errStop)
+	}
+	if resp.StatusCode() != StatusTemporaryRedirect {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusTemporaryRedirect)
 	}
 }
 
-type writeErrorConn struct {
-	net.Conn
-}
+func TestClientRedirectCredentials(t *testing.T) {
+	handler := func(ctx *RequestCtx) {
+		switch string(ctx.Path()) {
+		case "/same":
+			ctx.Redirect("/bar", StatusFound)
+		case "/other":
+			ctx.Redirect("http://other.com/bar", StatusFound)
+		case "/downgrade":
+			ctx.Redirect("http://foobar.com/bar", StatusFound)
+		default:
+			fmt.Fprintf(ctx, "%s %s|%s|%s|%s", ctx.URI().Scheme(), ctx.Host(), ctx.Request.Header.Peek("Authorization"),
+				ctx.Request.Header.Peek("Proxy-Authorization"), ctx.Request.Header.Cookie("foo"))
+		}
+	}
 
-func (w *writeErrorConn) Write(p []byte) (int, error) {
-	return 1, fmt.Errorf("error")
-}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go (&Server{Handler: handler}).Serve(ln)
 
-func (w *writeErrorConn) Close() error {
-	return nil
-}
+	cert, err := tls.LoadX509KeyPair("./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key")
+	if err != nil {
+		t.Fatalf("cannot load TLS certificate: %s", err)
+	}
+	lnTLS := fasthttputil.NewInmemoryListener()
+	defer lnTLS.Close()
+	go (&Server{Handler: handler}).Serve(tls.NewListener(lnTLS, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}))
 
-type readErrorConn struct {
-	net.Conn
-}
+	c := &Client{
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		Dial: func(addr string) (net.Conn, error) {
+			if strings.HasSuffix(addr, ":443") {
+				return lnTLS.Dial()
+			}
+			return ln.Dial()
+		},
+		RedirectPolicy: &RedirectPolicy{},
+	}
 
-func (r *readErrorConn) Read(p []byte) (int, error) {
-	return 0, fmt.Errorf("error")
-}
+	testRedirectCredentials := func(uri, expectedBody string) {
+		t.Helper()
+		req := AcquireRequest()
+		defer ReleaseRequest(req)
+		resp := AcquireResponse()
+		defer ReleaseResponse(resp)
 
-func (r *readErrorConn) Write(p []byte) (int, error) {
-	return len(p), nil
-}
+		req.SetRequestURI(uri)
+		req.Header.Set("Authorization", "secret")
+		req.Header.Set("Proxy-Authorization", "proxy-secret")
+		req.Header.SetCookie("foo", "bar")
+		if err := c.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error for %q: %s", uri, err)
+		}
+		if string(resp.Body()) != expectedBody {
+			t.Fatalf("unexpected body for %q: %q. Expecting %q", uri, resp.Body(), expectedBody)
+		}
+	}
 
-func (r *readErrorConn) Close() error {
-	return nil
-}
+	// Credentials are forwarded to the same host.
+	testRedirectCredentials("http://foobar.com/same", "http foobar.com|secret|proxy-secret|bar")
 
-type singleReadConn struct {
-	net.Conn
-	s string
-	n int
-}
+	// Credentials are stripped by default on redirects to other hosts.
+	testRedirectCredentials("http://foobar.com/other", "http other.com|||")
 
-func (r *singleReadConn) Read(p []byte) (int, error) {
-	if len(r.s) == r.n {
-		return 0, io.EOF
+	// https to http redirects are refused by default.
+	req := AcquireRequest()
+	req.SetRequestURI("https://foobar.com/downgrade")
+	req.Header.Set("Authorization", "secret")
+	if err := c.Do(req, nil); err != errHTTPSDowngrade {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errHTTPSDowngrade)
 	}
-	n := copy(p, []byte(r.s[r.n:]))
-	r.n += n
-	return n, nil
-}
+	ReleaseRequest(req)
 
-func (r *singleReadConn) Write(p []byte) (int, error) {
-	return len(p), nil
-}
+	// Scheme change strips credentials too.
+	c.RedirectPolicy.AllowHTTPSDowngrade = true
+	testRedirectCredentials("https://foobar.com/downgrade", "http foobar.com|||")
 
-func (r *singleReadConn) Close() error {
-	return nil
+	// Credentials are forwarded on explicit opt-in.
+	c.RedirectPolicy.ForwardCredentials = true
+	testRedirectCredentials("http://foobar.com/other", "http other.com|secret|proxy-secret|bar")
 }
 
-func TestClientHTTPSInvalidServerName(t *testing.T) {
-	addrHTTPS := "127.0.0.1:57794"
-	sHTTPS := startEchoServerTLS(t, "tcp", addrHTTPS)
-	defer sHTTPS.Stop()
+func TestHostClientConnsSnapshot(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
 
-	var c Client
+	handlerCh := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/slow" {
+				handlerCh <- struct{}{}
+				<-handlerCh
+			}
+		},
+	}
+	go s.Serve(ln)
 
-	addr := "https://" + addrHTTPS
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	if n := c.ConnsCount(); n != 0 {
+		t.Fatalf("unexpected number of conns: %d. Expecting 0", n)
+	}
 
-	for i := 0; i < 10; i++ {
-		_, _, err := c.GetTimeout(nil, addr, time.Second)
-		if err == nil {
-			t.Fatalf("expecting TLS error")
-		}
+	startTime := time.Now()
+	if _, _, err := c.Get(nil, "http://foobar/"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := c.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected number of conns: %d. Expecting 1", n)
+	}
+	if n := c.IdleConns(); n != 1 {
+		t.Fatalf("unexpected number of idle conns: %d. Expecting 1", n)
+	}
+	snapshot := c.ConnsSnapshot()
+	if snapshot.ConnsCount != 1 || len(snapshot.IdleConns) != 1 {
+		t.Fatalf("unexpected snapshot: %+v", snapshot)
+	}
+	ci := snapshot.IdleConns[0]
+	if ci.Age < 0 || ci.Age > time.Since(startTime) || ci.LastUseTime.Before(startTime) {
+		t.Fatalf("unexpected idle conn info: %+v", ci)
+	}
+
+	// The connection isn't idle while it is in use.
+	ch := make(chan error, 1)
+	go func() {
+		_, _, err := c.Get(nil, "http://foobar/slow")
+		ch <- err
+	}()
+	<-handlerCh
+	if n := c.IdleConns(); n != 0 {
+		t.Fatalf("unexpected number of idle conns: %d. Expecting 0", n)
+	}
+	if n := c.ConnsSnapshot().ConnsCount; n != 1 {
+		t.Fatalf("unexpected number of conns: %d. Expecting 1", n)
+	}
+	handlerCh <- struct{}{}
+	if err := <-ch; err != nil {
+		t.Fatalf("unexpected error: %s", err)
 	}
 }
 
-func TestClientHTTPSConcurrent(t *testing.T) {
-	addrHTTP := "127.0.0.1:56793"
-	sHTTP := startEchoServer(t, "tcp", addrHTTP)
-	defer sHTTP.Stop()
+func TestHostClientPerRequestTimeouts(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
 
-	addrHTTPS := "127.0.0.1:56794"
-	sHTTPS := startEchoServerTLS(t, "tcp", addrHTTPS)
-	defer sHTTPS.Stop()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/slow" {
+				time.Sleep(200 * time.Millisecond)
+			}
+		},
+	}
+	go s.Serve(ln)
 
-	c := &Client{
-		TLSConfig: &tls.Config{
-			InsecureSkipVerify: true,
+	dials := 0
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			dials++
+			return ln.Dial()
 		},
 	}
 
-	var wg sync.WaitGroup
-	for i := 0; i < 4; i++ {
-		wg.Add(1)
-		addr := "http://" + addrHTTP
-		if i&1 != 0 {
-			addr = "https://" + addrHTTPS
-		}
-		go func() {
-			defer wg.Done()
-			testClientGet(t, c, addr, 20)
-			testClientPost(t, c, addr, 10)
-		}()
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	req.SetRequestURI("http://foobar/fast")
+	req.SetReadTimeout(100 * time.Millisecond)
+	req.SetWriteTimeout(100 * time.Millisecond)
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
 	}
-	wg.Wait()
-}
 
-func TestClientManyServers(t *testing.T) {
-	var addrs []string
-	for i := 0; i < 10; i++ {
-		addr := fmt.Sprintf("127.0.0.1:%d", 56904+i)
-		s := startEchoServer(t, "tcp", addr)
-		defer s.Stop()
-		addrs = append(addrs, addr)
+	// Deadlines set for the previous request mustn't affect the request
+	// without per-request timeouts sent over the same connection.
+	req.Reset()
+	req.SetRequestURI("http://foobar/slow")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if dials != 1 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 1", dials)
 	}
 
-	var wg sync.WaitGroup
-	for i := 0; i < 4; i++ {
-		wg.Add(1)
-		addr := "http://" + addrs[i]
-		go func() {
-			defer wg.Done()
-			testClientGet(t, &defaultClient, addr, 20)
-			testClientPost(t, &defaultClient, addr, 10)
-		}()
+	req.SetReadTimeout(50 * time.Millisecond)
+	if err := c.Do(req, resp); err == nil {
+		t.Fatalf("expecting timeout error")
 	}
-	wg.Wait()
 }
 
-func TestClientGet(t *testing.T) {
-	addr := "127.0.0.1:56789"
-	s := startEchoServer(t, "tcp", addr)
-	defer s.Stop()
+func TestHostClientDialFailureCache(t *testing.T) {
+	errDial := errors.New("connection refused")
+	dials := 0
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			dials++
+			return nil, errDial
+		},
+		DialFailureCacheDuration: time.Hour,
+	}
 
-	addr = "http://" + addr
-	testClientGet(t, &defaultClient, addr, 100)
-}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar/")
 
-func TestClientPost(t *testing.T) {
-	addr := "127.0.0.1:56798"
-	s := startEchoServer(t, "tcp", addr)
-	defer s.Stop()
+	for i := 0; i < 3; i++ {
+		if err := c.Do(req, resp); err != errDial {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, errDial)
+		}
+	}
+	if dials != 1 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 1", dials)
+	}
 
-	addr = "http://" + addr
-	testClientPost(t, &defaultClient, addr, 100)
+	c.ResetDialFailures()
+	if err := c.Do(req, resp); err != errDial {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errDial)
+	}
+	if dials != 2 {
+		t.Fatalf("unexpected number of dials after reset: %d. Expecting 2", dials)
+	}
+
+	c.ResetDialFailures()
+	c.DialFailureCacheDuration = time.Millisecond
+	for i := 0; i < 2; i++ {
+		if err := c.Do(req, resp); err != errDial {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, errDial)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if dials != 4 {
+		t.Fatalf("unexpected number of dials after cache expiration: %d. Expecting 4", dials)
+	}
 }
 
-func TestClientConcurrent(t *testing.T) {
-	addr := "127.0.0.1:55780"
-	s := startEchoServer(t, "tcp", addr)
-	defer s.Stop()
+func TestHostClientDoCtx(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
 
-	addr = "http://" + addr
-	var wg sync.WaitGroup
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			testClientGet(t, &defaultClient, addr, 30)
-			testClientPost(t, &defaultClient, addr, 10)
-		}()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/slow" {
+				time.Sleep(time.Second)
+			}
+			ctx.WriteString("ok")
+		},
 	}
-	wg.Wait()
-}
+	go s.Serve(ln)
 
-func skipIfNotUnix(tb testing.TB) {
-	switch runtime.GOOS {
-	case "android", "nacl", "plan9", "windows":
-		tb.Skipf("%s does not support unix sockets", runtime.GOOS)
+	dialCh := make(chan struct{}, 1)
+	dialCh <- struct{}{}
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			<-dialCh
+			return ln.Dial()
+		},
 	}
-	if runtime.GOOS == "darwin" && (runtime.GOARCH == "arm" || runtime.GOARCH == "arm64") {
-		tb.Skip("iOS does not support unix, unixgram")
+	connsCount := func() int {
+		c.connsLock.Lock()
+		defer c.connsLock.Unlock()
+		return c.connsCount
 	}
-}
-
-func TestHostClientGet(t *testing.T) {
-	skipIfNotUnix(t)
-	addr := "TestHostClientGet.unix"
-	s := startEchoServer(t, "unix", addr)
-	defer s.Stop()
-	c := createEchoClient(t, "unix", addr)
 
-	testHostClientGet(t, c, 100)
-}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://foobar/")
 
-func TestHostClientPost(t *testing.T) {
-	skipIfNotUnix(t)
-	addr := "./TestHostClientPost.unix"
-	s := startEchoServer(t, "unix", addr)
-	defer s.Stop()
-	c := createEchoClient(t, "unix", addr)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := c.DoCtx(ctx, &req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+	}
+	cancel()
 
-	testHostClientPost(t, c, 100)
-}
+	// The connection must remain usable after the request with ctx.
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
 
-func TestHostClientConcurrent(t *testing.T) {
-	skipIfNotUnix(t)
-	addr := "./TestHostClientConcurrent.unix"
-	s := startEchoServer(t, "unix", addr)
-	defer s.Stop()
-	c := createEchoClient(t, "unix", addr)
+	// Abort response reading.
+	req.SetRequestURI("http://foobar/slow")
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	startTime := time.Now()
+	if err := c.DoCtx(ctx, &req, &resp); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.DeadlineExceeded)
+	}
+	cancel()
+	if d := time.Since(startTime); d > 500*time.Millisecond {
+		t.Fatalf("too long request abortion: %s", d)
+	}
+	if n := connsCount(); n != 0 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 0", n)
+	}
 
-	var wg sync.WaitGroup
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			testHostClientGet(t, c, 30)
-			testHostClientPost(t, c, 10)
-		}()
+	// Abort dialing.
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := c.DoCtx(ctx, &req, &resp); err != context.Canceled {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
 	}
-	wg.Wait()
-}
+	if n := connsCount(); n != 0 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 0", n)
+	}
+	dialCh <- struct{}{}
 
-func testClientGet(t *testing.T, c clientGetter, addr string, n int) {
-	var buf []byte
-	for i := 0; i < n; i++ {
-		uri := fmt.Sprintf("%s/foo/%d?bar=baz", addr, i)
-		statusCode, body, err := c.Get(buf, uri)
-		buf = body
-		if err != nil {
-			t.Fatalf("unexpected error when doing http request: %s", err)
-		}
-		if statusCode != StatusOK {
-			t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
-		}
-		resultURI := string(body)
-		if resultURI != uri {
-			t.Fatalf("unexpected uri %q. Expecting %q", resultURI, uri)
-		}
+	// Canceled ctx fails the request without dialing.
+	if err := c.DoCtx(ctx, &req, &resp); err != context.Canceled {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
 	}
 }
 
-func testClientDoTimeoutSuccess(t *testing.T, c *Client, addr string, n int) {
-	var req Request
-	var resp Response
+func TestHostClientStreamCloseDelimitedBody(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
 
-	for i := 0; i < n; i++ {
-		uri := fmt.Sprintf("%s/foo/%d?bar=baz", addr, i)
-		req.SetRequestURI(uri)
-		if err := c.DoTimeout(&req, &resp, time.Second); err != nil {
-			t.Fatalf("unexpected error: %s", err)
-		}
-		if resp.StatusCode() != StatusOK {
-			t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
-		}
-		resultURI := string(resp.Body())
-		if strings.HasPrefix(uri, "https") {
-			resultURI = uri[:5] + resultURI[4:]
-		}
-		if resultURI != uri {
-			t.Fatalf("unexpected uri %q. Expecting %q", resultURI, uri)
+	unblockCh := make(chan struct{})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			br := bufio.NewReader(conn)
+			var req Request
+			if err := req.Read(br); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\nfirst part, "))
+			<-unblockCh
+			conn.Write([]byte("second part"))
+			conn.Close()
 		}
+	}()
+
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		StreamCloseDelimitedBody: true,
+	}
+	connsCount := func() int {
+		c.connsLock.Lock()
+		defer c.connsLock.Unlock()
+		return c.connsCount
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/")
+	resp := AcquireResponse()
+
+	// The response must be returned before the server closes the connection.
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.bodyStream == nil {
+		t.Fatalf("expecting streamed response body")
+	}
+	if n := connsCount(); n != 1 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 1", n)
+	}
+	close(unblockCh)
+	var buf bytes.Buffer
+	if err := resp.BodyWriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if buf.String() != "first part, second part" {
+		t.Fatalf("unexpected body %q. Expecting %q", buf.String(), "first part, second part")
+	}
+	ReleaseResponse(resp)
+	if n := connsCount(); n != 0 {
+		t.Fatalf("unexpected number of connections after reading the body: %d. Expecting 0", n)
+	}
+
+	// MaxResponseBodySize applies to streamed bodies.
+	c.MaxResponseBodySize = 5
+	resp = AcquireResponse()
+	defer ReleaseResponse(resp)
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := resp.BodyWriteTo(&buf); err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBodyTooLarge)
 	}
 }
 
-func testClientGetTimeoutSuccess(t *testing.T, c *Client, addr string, n int) {
-	var buf []byte
-	for i := 0; i < n; i++ {
-		uri := fmt.Sprintf("%s/foo/%d?bar=baz", addr, i)
-		statusCode, body, err := c.GetTimeout(buf, uri, time.Second)
-		buf = body
-		if err != nil {
-			t.Fatalf("unexpected error when doing http request: %s", err)
+func TestHostClientStreamResponseBody(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	body := createFixedBody(100000)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/chunked" {
+				ctx.SetBodyStream(bytes.NewReader(body), -1)
+				return
+			}
+			ctx.SetBody(body)
+		},
+	}
+	go s.Serve(ln)
+
+	dials := 0
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			dials++
+			return ln.Dial()
+		},
+		StreamResponseBody: true,
+	}
+
+	for _, path := range []string{"/fixed", "/chunked", "/fixed", "/chunked"} {
+		req := AcquireRequest()
+		req.SetRequestURI("http://foobar" + path)
+		resp := AcquireResponse()
+		var tee bytes.Buffer
+		resp.SetBodyTee(&tee)
+		if err := c.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
 		}
-		if statusCode != StatusOK {
-			t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
+		if !resp.IsBodyStream() {
+			t.Fatalf("expecting streamed response body for %q", path)
 		}
-		resultURI := string(body)
-		if strings.HasPrefix(uri, "https") {
-			resultURI = uri[:5] + resultURI[4:]
+		var buf bytes.Buffer
+		if err := resp.BodyWriteTo(&buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
 		}
-		if resultURI != uri {
-			t.Fatalf("unexpected uri %q. Expecting %q", resultURI, uri)
+		if !bytes.Equal(buf.Bytes(), body) {
+			t.Fatalf("unexpected body for %q", path)
 		}
+		if !bytes.Equal(tee.Bytes(), body) {
+			t.Fatalf("unexpected tee contents for %q", path)
+		}
+		ReleaseResponse(resp)
+		ReleaseRequest(req)
+	}
+	// The connection must be reused after reading the whole body.
+	if dials != 1 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 1", dials)
+	}
+
+	// Partially read body closes the connection.
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/chunked")
+	resp := AcquireResponse()
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ReleaseResponse(resp)
+	if err := c.Do(req, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if dials != 2 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 2", dials)
+	}
+
+	// Too large Content-Length is rejected before streaming the body.
+	c.MaxResponseBodySize = 1000
+	req.SetRequestURI("http://foobar/fixed")
+	resp = AcquireResponse()
+	defer ReleaseResponse(resp)
+	if err := c.Do(req, resp); err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBodyTooLarge)
+	}
+	req.SetRequestURI("http://foobar/chunked")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := resp.BodyWriteTo(ioutil.Discard); err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBodyTooLarge)
 	}
 }
 
-func testClientPost(t *testing.T, c clientPoster, addr string, n int) {
-	var buf []byte
-	var args Args
-	for i := 0; i < n; i++ {
-		uri := fmt.Sprintf("%s/foo/%d?bar=baz", addr, i)
-		args.Set("xx", fmt.Sprintf("yy%d", i))
-		args.Set("zzz", fmt.Sprintf("qwe_%d", i))
-		argsS := args.String()
-		statusCode, body, err := c.Post(buf, uri, &args)
-		buf = body
-		if err != nil {
-			t.Fatalf("unexpected error when doing http request: %s", err)
+func TestClientChunkedRequestBodyStream(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.PostBody())
+		},
+	}
+	go s.Serve(ln)
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+
+	body := createFixedBody(10000)
+	for _, timeout := range []time.Duration{0, time.Second} {
+		req := AcquireRequest()
+		req.Header.SetMethod("POST")
+		req.SetRequestURI("http://foobar/")
+		// Hide the body size behind a reader, which isn't *io.LimitedReader.
+		req.SetBodyStream(bufio.NewReader(bytes.NewReader(body)), -1)
+		resp := AcquireResponse()
+		var err error
+		if timeout > 0 {
+			err = c.DoTimeout(req, resp, timeout)
+		} else {
+			err = c.Do(req, resp)
 		}
-		if statusCode != StatusOK {
-			t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
 		}
-		s := string(body)
-		if s != argsS {
-			t.Fatalf("unexpected response %q. Expecting %q", s, argsS)
+		if !bytes.Equal(resp.Body(), body) {
+			t.Fatalf("unexpected response body with timeout=%s: got %d bytes. Expecting %d bytes", timeout, len(resp.Body()), len(body))
 		}
+		ReleaseResponse(resp)
+		ReleaseRequest(req)
 	}
-}
 
-func testHostClientGet(t *testing.T, c *HostClient, n int) {
-	testClientGet(t, c, "http://google.com", n)
+	// Requests with body stream mustn't be retried, since the stream
+	// has been already consumed.
+	failingDials := 0
+	hc := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			failingDials++
+			conn, err := ln.Dial()
+			if err != nil {
+				return nil, err
+			}
+			return &closingConn{Conn: conn}, nil
+		},
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.Header.SetMethod("PUT")
+	req.SetRequestURI("http://foobar/")
+	req.SetBodyStream(bufio.NewReader(bytes.NewReader(body)), -1)
+	if err := hc.Do(req, nil); err == nil {
+		t.Fatalf("expecting error")
+	}
+	if failingDials != 1 {
+		t.Fatalf("unexpected number of attempts: %d. Expecting 1", failingDials)
+	}
 }
 
-func testHostClientPost(t *testing.T, c *HostClient, n int) {
-	testClientPost(t, c, "http://post-host.com", n)
+// closingConn closes the connection after writing the request.
+type closingConn struct {
+	net.Conn
 }
 
-type clientPoster interface {
-	Post(dst []byte, uri string, postArgs *Args) (int, []byte, error)
+func (c *closingConn) Read(p []byte) (int, error) {
+	c.Conn.Close()
+	return 0, io.EOF
 }
 
-type clientGetter interface {
-	Get(dst []byte, uri string) (int, []byte, error)
-}
+func TestHostClientBodyLengthMismatch(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req Request
+			if err := req.Read(bufio.NewReader(conn)); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nabcdef"))
+		}
+	}()
 
-func createEchoClient(t *testing.T, network, addr string) *HostClient {
-	return &HostClient{
-		Addr: addr,
+	c := &HostClient{
+		Addr: "foobar",
 		Dial: func(addr string) (net.Conn, error) {
-			return net.Dial(network, addr)
+			return ln.Dial()
 		},
 	}
-}
-
-type testEchoServer struct {
-	s  *Server
-	ln net.Listener
-	ch chan struct{}
-	t  *testing.T
-}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/")
+	req.SetConnectionClose()
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
 
-func (s *testEchoServer) Stop() {
-	s.ln.Close()
-	select {
-	case <-s.ch:
-	case <-time.After(time.Second):
-		s.t.Fatalf("timeout when waiting for server close")
+	// Excess bytes are ignored by default.
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "abc" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "abc")
 	}
-}
-
-func startEchoServerTLS(t *testing.T, network, addr string) *testEchoServer {
-	return startEchoServerExt(t, network, addr, true)
-}
 
-func startEchoServer(t *testing.T, network, addr string) *testEchoServer {
-	return startEchoServerExt(t, network, addr, false)
-}
+	c.BodyLengthMismatchPolicy = BodyLengthMismatchError
+	err := c.Do(req, resp)
+	e, ok := err.(*ErrBodyLengthMismatch)
+	if !ok {
+		t.Fatalf("unexpected error: %v. Expecting ErrBodyLengthMismatch", err)
+	}
+	if e.Expected != 3 || e.Actual != 6 {
+		t.Fatalf("unexpected mismatch: expected=%d, actual=%d. Expecting expected=3, actual=6", e.Expected, e.Actual)
+	}
 
-func startEchoServerExt(t *testing.T, network, addr string, isTLS bool) *testEchoServer {
-	if network == "unix" {
-		os.Remove(addr)
+	c.BodyLengthMismatchPolicy = BodyLengthMismatchTruncate
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
 	}
-	var ln net.Listener
-	var err error
-	if isTLS {
-		certFile := "./ssl-cert-snakeoil.pem"
-		keyFile := "./ssl-cert-snakeoil.key"
-		cert, err1 := tls.LoadX509KeyPair(certFile, keyFile)
-		if err1 != nil {
-			t.Fatalf("Cannot load TLS certificate: %s", err1)
-		}
-		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		}
-		ln, err = tls.Listen(network, addr, tlsConfig)
-	} else {
-		ln, err = net.Listen(network, addr)
+	if string(resp.Body()) != "abc" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "abc")
 	}
-	if err != nil {
-		t.Fatalf("cannot listen %q: %s", addr, err)
+
+	c.BodyLengthMismatchPolicy = BodyLengthMismatchAccept
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
 	}
+	if string(resp.Body()) != "abcdef" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "abcdef")
+	}
+}
 
-	s := &Server{
-		Handler: func(ctx *RequestCtx) {
-			if ctx.IsGet() {
-				ctx.Success("text/plain", ctx.URI().FullURI())
-			} else if ctx.IsPost() {
-				ctx.PostArgs().WriteTo(ctx)
+func TestHostClientBodyLengthMismatchDecompress(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	body := AppendGzipBytes(nil, []byte("foobar"))
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
 			}
+			var req Request
+			if err := req.Read(bufio.NewReader(conn)); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%sexcess", len(body), body)
+		}
+	}()
+
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
 		},
+		BodyLengthMismatchPolicy: BodyLengthMismatchTruncate,
+		DecompressResponseBody:   true,
 	}
-	ch := make(chan struct{})
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/")
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	// The truncated body is decompressed.
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "foobar")
+	}
+	if v := resp.Header.Peek("Content-Encoding"); len(v) > 0 {
+		t.Fatalf("unexpected Content-Encoding: %q. Expecting empty value", v)
+	}
+	if n := c.ConnsCount(); n != 0 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 0", n)
+	}
+}
+
+func TestHostClientResponseHooksExcessBody(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
 	go func() {
-		err := s.Serve(ln)
-		if err != nil {
-			t.Fatalf("unexpected error returned from Serve(): %s", err)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req Request
+			if err := req.Read(bufio.NewReader(conn)); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nabcdef"))
 		}
-		close(ch)
 	}()
-	return &testEchoServer{
-		s:  s,
-		ln: ln,
-		ch: ch,
-		t:  t,
+
+	var bodies []string
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		OnResponse: []ResponseHook{
+			func(req *Request, resp *Response) error {
+				bodies = append(bodies, string(resp.Body()))
+				return nil
+			},
+		},
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/")
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	for _, policy := range []BodyLengthMismatchPolicy{BodyLengthMismatchTruncate, BodyLengthMismatchAccept} {
+		c.BodyLengthMismatchPolicy = policy
+		if err := c.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if len(bodies) != 2 || bodies[0] != "abc" || bodies[1] != "abcdef" {
+		t.Fatalf("unexpected bodies seen by OnResponse: %q. Expecting %q", bodies, []string{"abc", "abcdef"})
+	}
+}
+
+func TestClientGetTimeoutSuccess(t *testing.T) {
+	addr := "127.0.0.1:56889"
+	s := startEchoServer(t, "tcp", addr)
+	defer s.Stop()
+
+	addr = "http://" + addr
+	testClientGetTimeoutSuccess(t, &defaultClient, addr, 100)
+}
+
+func TestClientGetTimeoutSuccessConcurrent(t *testing.T) {
+	addr := "127.0.0.1:56989"
+	s := startEchoServer(t, "tcp", addr)
+	defer s.Stop()
+
+	addr = "http://" + addr
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testClientGetTimeoutSuccess(t, &defaultClient, addr, 100)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientDoTimeoutSuccess(t *testing.T) {
+	addr := "127.0.0.1:63897"
+	s := startEchoServer(t, "tcp", addr)
+	defer s.Stop()
+
+	addr = "http://" + addr
+	testClientDoTimeoutSuccess(t, &defaultClient, addr, 100)
+}
+
+func TestClientDoTimeoutSuccessConcurrent(t *testing.T) {
+	addr := "127.0.0.1:63898"
+	s := startEchoServer(t, "tcp", addr)
+	defer s.Stop()
+
+	addr = "http://" + addr
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testClientDoTimeoutSuccess(t, &defaultClient, addr, 100)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientGetTimeoutError(t *testing.T) {
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return &readTimeoutConn{t: time.Second}, nil
+		},
+	}
+
+	testClientGetTimeoutError(t, c, 100)
+}
+
+func TestClientGetTimeoutErrorConcurrent(t *testing.T) {
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return &readTimeoutConn{t: time.Second}, nil
+		},
+		MaxConnsPerHost: 1000,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testClientGetTimeoutError(t, c, 100)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientDoTimeoutError(t *testing.T) {
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return &readTimeoutConn{t: time.Second}, nil
+		},
+	}
+
+	testClientDoTimeoutError(t, c, 100)
+}
+
+func TestClientDoTimeoutErrorConcurrent(t *testing.T) {
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return &readTimeoutConn{t: time.Second}, nil
+		},
+		MaxConnsPerHost: 1000,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testClientDoTimeoutError(t, c, 100)
+		}()
+	}
+	wg.Wait()
+}
+
+func testClientDoTimeoutError(t *testing.T, c *Client, n int) {
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://foobar.com/baz")
+	for i := 0; i < n; i++ {
+		err := c.DoTimeout(&req, &resp, time.Millisecond)
+		if err == nil {
+			t.Fatalf("expecting error")
+		}
+		if err != ErrTimeout {
+			t.Fatalf("unexpected error: %s. Expecting %s", err, ErrTimeout)
+		}
+	}
+}
+
+func testClientGetTimeoutError(t *testing.T, c *Client, n int) {
+	buf := make([]byte, 10)
+	for i := 0; i < n; i++ {
+		statusCode, body, err := c.GetTimeout(buf, "http://foobar.com/baz", time.Millisecond)
+		if err == nil {
+			t.Fatalf("expecting error")
+		}
+		if err != ErrTimeout {
+			t.Fatalf("unexpected error: %s. Expecting %s", err, ErrTimeout)
+		}
+		if statusCode != 0 {
+			t.Fatalf("unexpected statusCode=%d. Expecting %d", statusCode, 0)
+		}
+		if body == nil {
+			t.Fatalf("body must be non-nil")
+		}
+	}
+}
+
+type readTimeoutConn struct {
+	net.Conn
+	t time.Duration
+}
+
+func (r *readTimeoutConn) Read(p []byte) (int, error) {
+	time.Sleep(r.t)
+	return 0, io.EOF
+}
+
+func (r *readTimeoutConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (r *readTimeoutConn) Close() error {
+	return nil
+}
+
+func TestClientNonIdempotentRetry(t *testing.T) {
+	dialsCount := 0
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			dialsCount++
+			switch dialsCount {
+			case 1, 2:
+				return &readErrorConn{}, nil
+			case 3:
+				return &singleReadConn{
+					s: "HTTP/1.1 345 OK\r\nContent-Type: foobar\r\nContent-Length: 7\r\n\r\n0123456",
+				}, nil
+			default:
+				t.Fatalf("unexpected number of dials: %d", dialsCount)
+			}
+			panic("unreachable")
+		},
+	}
+
+	// This POST must fail.
+	dialsCount = 0
+	statusCode, body, err := c.Post(nil, "http://foobar/a/b", nil)
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+
+	// Verify that idempotent GET succeeds.
+	dialsCount = 0
+	statusCode, body, err = c.Get(nil, "http://foobar/a/b")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != 345 {
+		t.Fatalf("unexpected status code: %d. Expecting 345", statusCode)
+	}
+	if string(body) != "0123456" {
+		t.Fatalf("unexpected body: %q. Expecting %q", body, "0123456")
+	}
+}
+
+func TestClientIdempotentRequest(t *testing.T) {
+	dialsCount := 0
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			dialsCount++
+			switch dialsCount {
+			case 1:
+				return &singleReadConn{
+					s: "invalid response",
+				}, nil
+			case 2:
+				return &writeErrorConn{}, nil
+			case 3:
+				return &readErrorConn{}, nil
+			case 4:
+				return &singleReadConn{
+					s: "HTTP/1.1 345 OK\r\nContent-Type: foobar\r\nContent-Length: 7\r\n\r\n0123456",
+				}, nil
+			default:
+				t.Fatalf("unexpected number of dials: %d", dialsCount)
+			}
+			panic("unreachable")
+		},
+	}
+
+	// idempotent GET must succeed.
+	statusCode, body, err := c.Get(nil, "http://foobar/a/b")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != 345 {
+		t.Fatalf("unexpected status code: %d. Expecting 345", statusCode)
+	}
+	if string(body) != "0123456" {
+		t.Fatalf("unexpected body: %q. Expecting %q", body, "0123456")
+	}
+
+	var args Args
+
+	// non-idempotent POST must fail on incorrect singleReadConn
+	dialsCount = 0
+	_, _, err = c.Post(nil, "http://foobar/a/b", &args)
+	if err == nil {
+		t.Fatalf("expecting error")
+	}
+
+	// non-idempotent POST must fail on incorrect singleReadConn
+	dialsCount = 0
+	_, _, err = c.Post(nil, "http://foobar/a/b", nil)
+	if err == nil {
+		t.Fatalf("expecting error")
+	}
+}
+
+func TestClientRetryIf(t *testing.T) {
+	dialsCount := 0
+	var retryErrs []error
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			dialsCount++
+			switch dialsCount {
+			case 1:
+				return &writeErrorConn{}, nil
+			case 2:
+				return &readErrorConn{}, nil
+			default:
+				return &singleReadConn{
+					s: "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo",
+				}, nil
+			}
+		},
+		RetryIf: func(req *Request, resp *Response, err error) bool {
+			retryErrs = append(retryErrs, err)
+			return true
+		},
+	}
+
+	// non-idempotent POST must be retried if RetryIf allows it.
+	var args Args
+	statusCode, body, err := c.Post(nil, "http://foobar/a/b", &args)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
+	}
+	if string(body) != "foo" {
+		t.Fatalf("unexpected body: %q. Expecting %q", body, "foo")
+	}
+	if len(retryErrs) != 2 {
+		t.Fatalf("unexpected number of RetryIf calls: %d. Expecting 2", len(retryErrs))
+	}
+
+	// idempotent GET mustn't be retried if RetryIf denies it.
+	dialsCount = 0
+	retryErrs = nil
+	c = &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			dialsCount++
+			return &readErrorConn{}, nil
+		},
+		RetryIf: func(req *Request, resp *Response, err error) bool {
+			retryErrs = append(retryErrs, err)
+			return false
+		},
+	}
+	if _, _, err = c.Get(nil, "http://foobar/a/b"); err == nil {
+		t.Fatalf("expecting error")
+	}
+	if dialsCount != 1 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 1", dialsCount)
+	}
+	if len(retryErrs) != 1 || retryErrs[0] != err {
+		t.Fatalf("unexpected errors passed to RetryIf: %v. Expecting [%v]", retryErrs, err)
+	}
+}
+
+func TestClientRetryBodyStream(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/redirect" {
+				ctx.Redirect("/echo", StatusTemporaryRedirect)
+				return
+			}
+			ctx.SetBody(ctx.PostBody())
+		},
+	}
+	go s.Serve(ln)
+
+	dialsCount := 0
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			dialsCount++
+			if dialsCount == 1 {
+				// The first attempt consumes the body stream.
+				return &readErrorConn{}, nil
+			}
+			return ln.Dial()
+		},
+		RetryIf: func(req *Request, resp *Response, err error) bool {
+			return true
+		},
+	}
+
+	test := func(uri string, getBody bool, expectedBody string) {
+		t.Helper()
+		dialsCount = 0
+		getBodyCalls := 0
+		req := AcquireRequest()
+		resp := AcquireResponse()
+		defer ReleaseRequest(req)
+		defer ReleaseResponse(resp)
+		req.Header.SetMethod("POST")
+		req.SetRequestURI(uri)
+		req.SetBodyStream(strings.NewReader("foobar"), 6)
+		if getBody {
+			req.GetBody = func() (io.Reader, error) {
+				getBodyCalls++
+				return strings.NewReader("foobar"), nil
+			}
+		}
+		err := c.DoRedirects(req, resp, 1)
+		if !getBody {
+			if err == nil {
+				t.Fatalf("expecting error")
+			}
+			if dialsCount != 1 {
+				t.Fatalf("unexpected number of dials: %d. Expecting 1", dialsCount)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(resp.Body()) != expectedBody {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+		}
+		if getBodyCalls != 1 {
+			t.Fatalf("unexpected number of GetBody calls: %d. Expecting 1", getBodyCalls)
+		}
+	}
+
+	test("http://foobar/echo", false, "")
+	test("http://foobar/echo", true, "foobar")
+
+	// The body must be re-acquired for the redirect preserving the body.
+	// The idle connection from the previous request is reused.
+	test("http://foobar/redirect", true, "foobar")
+}
+
+type writeErrorConn struct {
+	net.Conn
+}
+
+func (w *writeErrorConn) Write(p []byte) (int, error) {
+	return 1, fmt.Errorf("error")
+}
+
+func (w *writeErrorConn) Close() error {
+	return nil
+}
+
+type readErrorConn struct {
+	net.Conn
+}
+
+func (r *readErrorConn) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("error")
+}
+
+func (r *readErrorConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (r *readErrorConn) Close() error {
+	return nil
+}
+
+type singleReadConn struct {
+	net.Conn
+	s string
+	n int
+}
+
+func (r *singleReadConn) Read(p []byte) (int, error) {
+	if len(r.s) == r.n {
+		return 0, io.EOF
+	}
+	n := copy(p, []byte(r.s[r.n:]))
+	r.n += n
+	return n, nil
+}
+
+func (r *singleReadConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (r *singleReadConn) Close() error {
+	return nil
+}
+
+func TestHostClientMaxResponseBodySizeEarlyAbort(t *testing.T) {
+	test := func(response string) {
+		t.Helper()
+		conn := &singleReadConn{
+			s: response,
+		}
+		c := &HostClient{
+			Addr: "foobar",
+			Dial: func(addr string) (net.Conn, error) {
+				return conn, nil
+			},
+			MaxResponseBodySize: 10,
+		}
+		_, _, err := c.Get(nil, "http://foobar/")
+		if err != ErrBodyTooLarge {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBodyTooLarge)
+		}
+		// The body mustn't be read after exceeding the limit.
+		if len(conn.s)-conn.n < 100 {
+			t.Fatalf("too much data read: %d bytes out of %d", conn.n, len(conn.s))
+		}
+		if n := c.ConnsCount(); n != 0 {
+			t.Fatalf("unexpected number of connections left: %d. Expecting 0", n)
+		}
+	}
+	big := strings.Repeat("x", 64*1024)
+	test("HTTP/1.1 200 OK\r\nContent-Length: 1099511627776\r\n\r\n" + big)
+	test("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n10000\r\n" + big)
+}
+
+func TestPipelineClientMaxResponseBodySize(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/big" {
+				ctx.SetBodyString(strings.Repeat("x", 100))
+				return
+			}
+			ctx.SetBodyString("ok")
+		},
+		Logger: &customLogger{},
+	}
+	go s.Serve(ln)
+
+	c := &PipelineClient{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		MaxResponseBodySize: 10,
+		Logger:              &customLogger{},
+	}
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://foobar/big")
+	if err := c.Do(&req, &resp); err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBodyTooLarge)
+	}
+
+	// Wait until the connection is closed. Otherwise the next request
+	// may be written to the connection, which is being closed.
+	c.connClientsLock.Lock()
+	cc := c.connClients[0]
+	c.connClientsLock.Unlock()
+	for i := 0; ; i++ {
+		cc.chLock.Lock()
+		stopped := cc.chR == nil
+		cc.chLock.Unlock()
+		if stopped {
+			break
+		}
+		if i > 500 {
+			t.Fatalf("timeout when waiting for the connection to be closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The next request must be sent over a new connection.
+	req.SetRequestURI("http://foobar/ok")
+	if err := c.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+	}
+}
+
+func TestClientHTTPSInvalidServerName(t *testing.T) {
+	addrHTTPS := "127.0.0.1:57794"
+	sHTTPS := startEchoServerTLS(t, "tcp", addrHTTPS)
+	defer sHTTPS.Stop()
+
+	var c Client
+
+	addr := "https://" + addrHTTPS
+
+	for i := 0; i < 10; i++ {
+		_, _, err := c.GetTimeout(nil, addr, time.Second)
+		if err == nil {
+			t.Fatalf("expecting TLS error")
+		}
+	}
+}
+
+func TestClientHTTPSConcurrent(t *testing.T) {
+	addrHTTP := "127.0.0.1:56793"
+	sHTTP := startEchoServer(t, "tcp", addrHTTP)
+	defer sHTTP.Stop()
+
+	addrHTTPS := "127.0.0.1:56794"
+	sHTTPS := startEchoServerTLS(t, "tcp", addrHTTPS)
+	defer sHTTPS.Stop()
+
+	c := &Client{
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		addr := "http://" + addrHTTP
+		if i&1 != 0 {
+			addr = "https://" + addrHTTPS
+		}
+		go func() {
+			defer wg.Done()
+			testClientGet(t, c, addr, 20)
+			testClientPost(t, c, addr, 10)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientManyServers(t *testing.T) {
+	var addrs []string
+	for i := 0; i < 10; i++ {
+		addr := fmt.Sprintf("127.0.0.1:%d", 56904+i)
+		s := startEchoServer(t, "tcp", addr)
+		defer s.Stop()
+		addrs = append(addrs, addr)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		addr := "http://" + addrs[i]
+		go func() {
+			defer wg.Done()
+			testClientGet(t, &defaultClient, addr, 20)
+			testClientPost(t, &defaultClient, addr, 10)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientGet(t *testing.T) {
+	addr := "127.0.0.1:56789"
+	s := startEchoServer(t, "tcp", addr)
+	defer s.Stop()
+
+	addr = "http://" + addr
+	testClientGet(t, &defaultClient, addr, 100)
+}
+
+func TestClientPost(t *testing.T) {
+	addr := "127.0.0.1:56798"
+	s := startEchoServer(t, "tcp", addr)
+	defer s.Stop()
+
+	addr = "http://" + addr
+	testClientPost(t, &defaultClient, addr, 100)
+}
+
+func TestClientConcurrent(t *testing.T) {
+	addr := "127.0.0.1:55780"
+	s := startEchoServer(t, "tcp", addr)
+	defer s.Stop()
+
+	addr = "http://" + addr
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testClientGet(t, &defaultClient, addr, 30)
+			testClientPost(t, &defaultClient, addr, 10)
+		}()
+	}
+	wg.Wait()
+}
+
+func skipIfNotUnix(tb testing.TB) {
+	switch runtime.GOOS {
+	case "android", "nacl", "plan9", "windows":
+		tb.Skipf("%s does not support unix sockets", runtime.GOOS)
+	}
+	if runtime.GOOS == "darwin" && (runtime.GOARCH == "arm" || runtime.GOARCH == "arm64") {
+		tb.Skip("iOS does not support unix, unixgram")
+	}
+}
+
+func TestHostClientGet(t *testing.T) {
+	skipIfNotUnix(t)
+	addr := "TestHostClientGet.unix"
+	s := startEchoServer(t, "unix", addr)
+	defer s.Stop()
+	c := createEchoClient(t, "unix", addr)
+
+	testHostClientGet(t, c, 100)
+}
+
+func TestHostClientPost(t *testing.T) {
+	skipIfNotUnix(t)
+	addr := "./TestHostClientPost.unix"
+	s := startEchoServer(t, "unix", addr)
+	defer s.Stop()
+	c := createEchoClient(t, "unix", addr)
+
+	testHostClientPost(t, c, 100)
+}
+
+func TestClientUnixSocket(t *testing.T) {
+	skipIfNotUnix(t)
+	addr, err := filepath.Abs("./TestClientUnixSocket.unix")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s := startEchoServer(t, "unix", addr)
+	defer s.Stop()
+
+	c := &Client{
+		UnixSockets: map[string]string{
+			"docker": addr,
+		},
+	}
+	testGet := func(uri, expectedBody string) {
+		t.Helper()
+		statusCode, body, err := c.Get(nil, uri)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
+		}
+		if string(body) != expectedBody {
+			t.Fatalf("unexpected body: %q. Expecting %q", body, expectedBody)
+		}
+	}
+	testGet("unix://"+addr+":/v1.41/info?foo=bar", "http://localhost/v1.41/info?foo=bar")
+	testGet("unix://"+addr, "http://localhost/")
+	testGet("http://docker/containers/json", "http://docker/containers/json")
+
+	_, _, err = c.Get(nil, "unix:///:/foo")
+	if !errors.Is(err, errMissingUnixSocket) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errMissingUnixSocket)
+	}
+}
+
+func TestClientUnixSocketRedirect(t *testing.T) {
+	skipIfNotUnix(t)
+	addr, err := filepath.Abs("./TestClientUnixSocketRedirect.unix")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	os.Remove(addr)
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatalf("cannot listen %q: %s", addr, err)
+	}
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/absolute":
+				ctx.Redirect("http://localhost/relative", StatusFound)
+			case "/relative":
+				ctx.Response.Header.Set("Location", "/target")
+				ctx.SetStatusCode(StatusFound)
+			default:
+				ctx.Success("text/plain", ctx.URI().FullURI())
+			}
+		},
+	}
+	go s.Serve(ln)
+
+	var c Client
+	statusCode, body, err := c.Get(nil, "unix://"+addr+":/absolute")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
+	}
+	if string(body) != "http://localhost/target" {
+		t.Fatalf("unexpected body: %q. Expecting %q", body, "http://localhost/target")
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("unix://" + addr + ":/absolute")
+	if err := c.DoRedirects(req, resp, 5); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "http://localhost/target" {
+		t.Fatalf("unexpected body: %q. Expecting %q", resp.Body(), "http://localhost/target")
+	}
+	// The request uri must keep pointing to the socket, so the request
+	// may be repeated.
+	expectedURI := "unix://" + addr + ":/target"
+	if uri := req.URI().String(); uri != expectedURI {
+		t.Fatalf("unexpected request uri: %q. Expecting %q", uri, expectedURI)
+	}
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "http://localhost/target" {
+		t.Fatalf("unexpected body: %q. Expecting %q", resp.Body(), "http://localhost/target")
+	}
+}
+
+func TestClientUnixSocketRedirectFromRemoteHost(t *testing.T) {
+	skipIfNotUnix(t)
+	addr, err := filepath.Abs("./TestClientUnixSocketRedirectFromRemoteHost.unix")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	os.Remove(addr)
+	unixLn, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Fatalf("cannot listen %q: %s", addr, err)
+	}
+	defer unixLn.Close()
+	var unixRequests uint32
+	unixServer := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/leave" {
+				ctx.Redirect("http://foobar/localhost", StatusFound)
+				return
+			}
+			atomic.AddUint32(&unixRequests, 1)
+			ctx.Success("text/plain", []byte("secret"))
+		},
+	}
+	go unixServer.Serve(unixLn)
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/host":
+				ctx.Redirect("http://docker/", StatusFound)
+			case "/unix":
+				ctx.Redirect("unix://"+addr+":/", StatusFound)
+			case "/localhost":
+				ctx.Redirect("http://localhost/target", StatusFound)
+			default:
+				ctx.Success("text/plain", ctx.URI().FullURI())
+			}
+		},
+	}
+	go s.Serve(ln)
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		UnixSockets: map[string]string{
+			"docker": addr,
+		},
+		RedirectPolicy: &RedirectPolicy{},
+	}
+	// Client.Get follows redirects on its own without RedirectPolicy.
+	cGet := &Client{
+		Dial:        c.Dial,
+		UnixSockets: c.UnixSockets,
+	}
+	for _, path := range []string{"/host", "/unix"} {
+		if _, _, err := c.Get(nil, "http://foobar"+path); err != errUnixSocketRedirect {
+			t.Fatalf("unexpected error for %s: %v. Expecting %v", path, err, errUnixSocketRedirect)
+		}
+		if _, _, err := cGet.Get(nil, "http://foobar"+path); err != errUnixSocketRedirect {
+			t.Fatalf("unexpected error for %s without RedirectPolicy: %v. Expecting %v", path, err, errUnixSocketRedirect)
+		}
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar/host")
+	if err := c.Do(req, resp); err != errUnixSocketRedirect {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errUnixSocketRedirect)
+	}
+	if n := atomic.LoadUint32(&unixRequests); n != 0 {
+		t.Fatalf("unexpected number of requests sent over unix socket: %d. Expecting 0", n)
+	}
+
+	// Redirects from the socket to other hosts are followed over TCP,
+	// and the request uri isn't restored to unix scheme then.
+	req.SetRequestURI("unix://" + addr + ":/leave")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "http://localhost/target" {
+		t.Fatalf("unexpected body: %q. Expecting %q", resp.Body(), "http://localhost/target")
+	}
+	if uri := req.URI().String(); uri != "http://localhost/target" {
+		t.Fatalf("unexpected request uri: %q. Expecting %q", uri, "http://localhost/target")
+	}
+}
+
+func TestClientUnixSocketTLS(t *testing.T) {
+	skipIfNotUnix(t)
+	addr, err := filepath.Abs("./TestClientUnixSocketTLS.unix")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cert, err := tls.LoadX509KeyPair("./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key")
+	if err != nil {
+		t.Fatalf("cannot load TLS certificate: %s", err)
+	}
+	serverNameCh := make(chan string, 1)
+	os.Remove(addr)
+	ln, err := tls.Listen("unix", addr, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			serverNameCh <- hello.ServerName
+			return &cert, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot listen %q: %s", addr, err)
+	}
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetBodyString("ok")
+		},
+	}
+	go s.Serve(ln)
+
+	c := &Client{
+		UnixSockets: map[string]string{
+			"docker": addr,
+		},
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+	statusCode, body, err := c.Get(nil, "https://docker/info")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK || string(body) != "ok" {
+		t.Fatalf("unexpected response: %d %q", statusCode, body)
+	}
+	if serverName := <-serverNameCh; serverName != "docker" {
+		t.Fatalf("unexpected server name: %q. Expecting %q", serverName, "docker")
+	}
+}
+
+func TestHostClientConcurrent(t *testing.T) {
+	skipIfNotUnix(t)
+	addr := "./TestHostClientConcurrent.unix"
+	s := startEchoServer(t, "unix", addr)
+	defer s.Stop()
+	c := createEchoClient(t, "unix", addr)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testHostClientGet(t, c, 30)
+			testHostClientPost(t, c, 10)
+		}()
+	}
+	wg.Wait()
+}
+
+func testClientGet(t *testing.T, c clientGetter, addr string, n int) {
+	var buf []byte
+	for i := 0; i < n; i++ {
+		uri := fmt.Sprintf("%s/foo/%d?bar=baz", addr, i)
+		statusCode, body, err := c.Get(buf, uri)
+		buf = body
+		if err != nil {
+			t.Fatalf("unexpected error when doing http request: %s", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
+		}
+		resultURI := string(body)
+		if resultURI != uri {
+			t.Fatalf("unexpected uri %q. Expecting %q", resultURI, uri)
+		}
+	}
+}
+
+func testClientDoTimeoutSuccess(t *testing.T, c *Client, addr string, n int) {
+	var req Request
+	var resp Response
+
+	for i := 0; i < n; i++ {
+		uri := fmt.Sprintf("%s/foo/%d?bar=baz", addr, i)
+		req.SetRequestURI(uri)
+		if err := c.DoTimeout(&req, &resp, time.Second); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if resp.StatusCode() != StatusOK {
+			t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+		}
+		resultURI := string(resp.Body())
+		if strings.HasPrefix(uri, "https") {
+			resultURI = uri[:5] + resultURI[4:]
+		}
+		if resultURI != uri {
+			t.Fatalf("unexpected uri %q. Expecting %q", resultURI, uri)
+		}
+	}
+}
+
+func testClientGetTimeoutSuccess(t *testing.T, c *Client, addr string, n int) {
+	var buf []byte
+	for i := 0; i < n; i++ {
+		uri := fmt.Sprintf("%s/foo/%d?bar=baz", addr, i)
+		statusCode, body, err := c.GetTimeout(buf, uri, time.Second)
+		buf = body
+		if err != nil {
+			t.Fatalf("unexpected error when doing http request: %s", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
+		}
+		resultURI := string(body)
+		if strings.HasPrefix(uri, "https") {
+			resultURI = uri[:5] + resultURI[4:]
+		}
+		if resultURI != uri {
+			t.Fatalf("unexpected uri %q. Expecting %q", resultURI, uri)
+		}
+	}
+}
+
+func testClientPost(t *testing.T, c clientPoster, addr string, n int) {
+	var buf []byte
+	var args Args
+	for i := 0; i < n; i++ {
+		uri := fmt.Sprintf("%s/foo/%d?bar=baz", addr, i)
+		args.Set("xx", fmt.Sprintf("yy%d", i))
+		args.Set("zzz", fmt.Sprintf("qwe_%d", i))
+		argsS := args.String()
+		statusCode, body, err := c.Post(buf, uri, &args)
+		buf = body
+		if err != nil {
+			t.Fatalf("unexpected error when doing http request: %s", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
+		}
+		s := string(body)
+		if s != argsS {
+			t.Fatalf("unexpected response %q. Expecting %q", s, argsS)
+		}
+	}
+}
+
+func testHostClientGet(t *testing.T, c *HostClient, n int) {
+	testClientGet(t, c, "http://google.com", n)
+}
+
+func testHostClientPost(t *testing.T, c *HostClient, n int) {
+	testClientPost(t, c, "http://post-host.com", n)
+}
+
+type clientPoster interface {
+	Post(dst []byte, uri string, postArgs *Args) (int, []byte, error)
+}
+
+type clientGetter interface {
+	Get(dst []byte, uri string) (int, []byte, error)
+}
+
+func createEchoClient(t *testing.T, network, addr string) *HostClient {
+	return &HostClient{
+		Addr: addr,
+		Dial: func(addr string) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+}
+
+type testEchoServer struct {
+	s  *Server
+	ln net.Listener
+	ch chan struct{}
+	t  *testing.T
+}
+
+func (s *testEchoServer) Stop() {
+	s.ln.Close()
+	select {
+	case <-s.ch:
+	case <-time.After(time.Second):
+		s.t.Fatalf("timeout when waiting for server close")
+	}
+}
+
+func startEchoServerTLS(t *testing.T, network, addr string) *testEchoServer {
+	return startEchoServerExt(t, network, addr, true)
+}
+
+func startEchoServer(t *testing.T, network, addr string) *testEchoServer {
+	return startEchoServerExt(t, network, addr, false)
+}
+
+func startEchoServerExt(t *testing.T, network, addr string, isTLS bool) *testEchoServer {
+	if network == "unix" {
+		os.Remove(addr)
+	}
+	var ln net.Listener
+	var err error
+	if isTLS {
+		certFile := "./ssl-cert-snakeoil.pem"
+		keyFile := "./ssl-cert-snakeoil.key"
+		cert, err1 := tls.LoadX509KeyPair(certFile, keyFile)
+		if err1 != nil {
+			t.Fatalf("Cannot load TLS certificate: %s", err1)
+		}
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+		ln, err = tls.Listen(network, addr, tlsConfig)
+	} else {
+		ln, err = net.Listen(network, addr)
+	}
+	if err != nil {
+		t.Fatalf("cannot listen %q: %s", addr, err)
+	}
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if ctx.IsGet() {
+				ctx.Success("text/plain", ctx.URI().FullURI())
+			} else if ctx.IsPost() {
+				ctx.PostArgs().WriteTo(ctx)
+			}
+		},
+	}
+	ch := make(chan struct{})
+	go func() {
+		err := s.Serve(ln)
+		if err != nil {
+			t.Fatalf("unexpected error returned from Serve(): %s", err)
+		}
+		close(ch)
+	}()
+	return &testEchoServer{
+		s:  s,
+		ln: ln,
+		ch: ch,
+		t:  t,
+	}
+}
+
+func TestInterleaveAddrFamilies(t *testing.T) {
+	addrs := []net.TCPAddr{
+		{IP: net.ParseIP("::1")},
+		{IP: net.ParseIP("::2")},
+		{IP: net.ParseIP("::3")},
+		{IP: net.ParseIP("1.1.1.1")},
+		{IP: net.ParseIP("2.2.2.2")},
+	}
+	result := interleaveAddrFamilies(addrs)
+	expected := []string{"::1", "1.1.1.1", "::2", "2.2.2.2", "::3"}
+	if len(result) != len(expected) {
+		t.Fatalf("unexpected number of addrs: %d. Expecting %d", len(result), len(expected))
+	}
+	for i, addr := range result {
+		if addr.IP.String() != expected[i] {
+			t.Fatalf("unexpected addr #%d: %s. Expecting %s", i, addr.IP, expected[i])
+		}
+	}
+}
+
+func TestDialHappyEyeballs(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer ln.Close()
+
+	// Obtain a closed port, so dialing it fails with connection refused.
+	lnClosed, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	closedAddr := *lnClosed.Addr().(*net.TCPAddr)
+	lnClosed.Close()
+
+	addrs := []net.TCPAddr{closedAddr, *ln.Addr().(*net.TCPAddr)}
+	concurrencyCh := make(chan struct{}, 1)
+	conn, err := dialHappyEyeballs(context.Background(), addrs, time.Second, concurrencyCh)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if conn.RemoteAddr().String() != ln.Addr().String() {
+		t.Fatalf("unexpected remote addr: %s. Expecting %s", conn.RemoteAddr(), ln.Addr())
+	}
+	conn.Close()
+
+	if _, err = dialHappyEyeballs(context.Background(), addrs[:1], time.Second, concurrencyCh); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	if len(concurrencyCh) != 0 {
+		t.Fatalf("dial concurrency slots must be released")
+	}
+
+	// Dials wait for free concurrency slot.
+	concurrencyCh <- struct{}{}
+	if _, err = dialHappyEyeballs(context.Background(), addrs[1:], 50*time.Millisecond, concurrencyCh); err != ErrDialTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrDialTimeout)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = dialHappyEyeballs(ctx, addrs[1:], time.Second, concurrencyCh); err != context.Canceled {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+	}
+}
+
+func TestHostClientDialCtx(t *testing.T) {
+	dialStarted := make(chan struct{})
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return nil, errors.New("Dial mustn't be called if DialCtx is set")
+		},
+		DialCtx: func(ctx context.Context, addr string) (net.Conn, error) {
+			close(dialStarted)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar/")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-dialStarted
+		cancel()
+	}()
+	if err := c.DoCtx(ctx, req, resp); err != context.Canceled {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+	}
+	if c.ConnsCount() != 0 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 0", c.ConnsCount())
+	}
+}
+
+type testResolver struct {
+	lookups uint32
+}
+
+func (r *testResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	atomic.AddUint32(&r.lookups, 1)
+	if host != "foobar.local" {
+		return nil, fmt.Errorf("unexpected host %q", host)
+	}
+	return []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}, nil
+}
+
+func TestHostClientResolver(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetBodyString("ok")
+		},
+	}
+	go s.Serve(ln)
+	defer ln.Close()
+
+	r := &testResolver{}
+	addr := fmt.Sprintf("foobar.local:%d", ln.Addr().(*net.TCPAddr).Port)
+	c := &Client{
+		Resolver:         r,
+		DNSCacheDuration: time.Hour,
+	}
+	get := func() {
+		t.Helper()
+		req := AcquireRequest()
+		defer ReleaseRequest(req)
+		resp := AcquireResponse()
+		defer ReleaseResponse(resp)
+		req.SetRequestURI("http://" + addr + "/")
+		// Force dialing new connection on the next request.
+		req.SetConnectionClose()
+		if err := c.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if resp.StatusCode() != StatusOK || string(resp.Body()) != "ok" {
+			t.Fatalf("unexpected response: %d %q", resp.StatusCode(), resp.Body())
+		}
+	}
+
+	get()
+	get()
+	if n := atomic.LoadUint32(&r.lookups); n != 1 {
+		t.Fatalf("unexpected number of lookups: %d. Expecting 1", n)
+	}
+	c.FlushDNSCache()
+	get()
+	if n := atomic.LoadUint32(&r.lookups); n != 2 {
+		t.Fatalf("unexpected number of lookups: %d. Expecting 2", n)
+	}
+}
+
+func TestHostClientValidateResponse(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetBodyString("ok")
+		},
+	}
+	go s.Serve(ln)
+
+	var dials uint32
+	errInvalid := errors.New("invalid response")
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			atomic.AddUint32(&dials, 1)
+			return ln.Dial()
+		},
+		ValidateResponse: func(req *Request, resp *Response) error {
+			switch string(req.URI().Path()) {
+			case "/invalid":
+				return errInvalid
+			case "/close":
+				resp.SetConnectionClose()
+				resp.Header.Set("Cache-Control", "no-store")
+			}
+			return nil
+		},
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	do := func(path string, expectedErr error, expectedDials uint32) {
+		t.Helper()
+		req.SetRequestURI("http://foobar" + path)
+		if err := c.Do(req, resp); err != expectedErr {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, expectedErr)
+		}
+		if n := atomic.LoadUint32(&dials); n != expectedDials {
+			t.Fatalf("unexpected number of dials: %d. Expecting %d", n, expectedDials)
+		}
+	}
+
+	do("/", nil, 1)
+	do("/", nil, 1)
+	do("/close", nil, 1)
+	if string(resp.Header.Peek("Cache-Control")) != "no-store" {
+		t.Fatalf("unexpected Cache-Control header %q. Expecting %q", resp.Header.Peek("Cache-Control"), "no-store")
+	}
+	if c.ConnsCount() != 0 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 0", c.ConnsCount())
+	}
+	do("/", nil, 2)
+	do("/invalid", errInvalid, 2)
+	if c.ConnsCount() != 0 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 0", c.ConnsCount())
+	}
+	do("/", nil, 3)
+}
+
+func TestClientTypedErrors(t *testing.T) {
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return nil, ErrDialTimeout
+		},
+	}
+
+	_, _, err := c.Get(nil, "ftp://foobar/")
+	if !errors.Is(err, ErrUnsupportedProtocol) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrUnsupportedProtocol)
+	}
+
+	// Dial errors are returned as is.
+	_, _, err = c.Get(nil, "http://foobar/")
+	if err != ErrDialTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrDialTimeout)
+	}
+
+	// Connection resets in the middle of the response match ErrConnClosedByPeer.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req Request
+			if err := req.Read(bufio.NewReader(conn)); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\nabc"))
+			time.Sleep(10 * time.Millisecond)
+			// Send RST instead of FIN.
+			conn.(*net.TCPConn).SetLinger(0)
+			conn.Close()
+		}
+	}()
+	_, _, err = Get(nil, "http://"+ln.Addr().String()+"/")
+	if !errors.Is(err, ErrConnClosedByPeer) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrConnClosedByPeer)
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) {
+		t.Fatalf("unexpected error type %T. Expecting net.Error", err)
+	}
+
+	// Errors returned when reading response headers wrap the cause.
+	var h ResponseHeader
+	err = h.Read(bufio.NewReader(&timeoutReader{}))
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("unexpected error: %v. Expecting timeout error", err)
+	}
+}
+
+// timeoutReader returns partial response header followed by timeout error.
+type timeoutReader struct {
+	done bool
+}
+
+func (r *timeoutReader) Read(p []byte) (int, error) {
+	if !r.done {
+		r.done = true
+		return copy(p, "HTTP/1.1 200 OK\r\n"), nil
+	}
+	return 0, timeoutReaderError{}
+}
+
+type timeoutReaderError struct{}
+
+func (timeoutReaderError) Error() string   { return "read timeout" }
+func (timeoutReaderError) Timeout() bool   { return true }
+func (timeoutReaderError) Temporary() bool { return true }
+
+func TestHostClientMaxConnWaitTimeout(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			time.Sleep(20 * time.Millisecond)
+			ctx.SetBodyString("ok")
+		},
+	}
+	go s.Serve(ln)
+
+	var dials uint32
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			atomic.AddUint32(&dials, 1)
+			return ln.Dial()
+		},
+		MaxConns:           1,
+		MaxConnWaitTimeout: 5 * time.Second,
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statusCode, body, err := c.Get(nil, "http://foobar/")
+			if err == nil && (statusCode != StatusOK || string(body) != "ok") {
+				err = fmt.Errorf("unexpected response: %d %q", statusCode, body)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if n := atomic.LoadUint32(&dials); n != 1 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 1", n)
+	}
+
+	// ErrNoFreeConns is returned if no connection becomes free in time.
+	c.MaxConnWaitTimeout = 5 * time.Millisecond
+	ch := make(chan error, 1)
+	go func() {
+		_, _, err := c.Get(nil, "http://foobar/")
+		ch <- err
+	}()
+	for c.IdleConns() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, _, err := c.Get(nil, "http://foobar/"); err != ErrNoFreeConns {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrNoFreeConns)
+	}
+	if err := <-ch; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.connsLock.Lock()
+	n := len(c.connsWait)
+	c.connsLock.Unlock()
+	if n != 0 {
+		t.Fatalf("unexpected number of waiters: %d. Expecting 0", n)
+	}
+}
+
+func TestHostClientTLSHandshakeTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer ln.Close()
+
+	// The server accepts connections, but never completes TLS handshake.
+	connCh := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			connCh <- conn
+		}
+	}()
+
+	c := &HostClient{
+		Addr:                ln.Addr().String(),
+		IsTLS:               true,
+		TLSConfig:           &tls.Config{InsecureSkipVerify: true},
+		TLSHandshakeTimeout: 50 * time.Millisecond,
+		ReadTimeout:         time.Hour,
+		WriteTimeout:        time.Hour,
+	}
+	startTime := time.Now()
+	_, _, err = c.Get(nil, "https://"+ln.Addr().String()+"/")
+	if !errors.Is(err, ErrTLSHandshakeTimeout) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTLSHandshakeTimeout)
+	}
+	if d := time.Since(startTime); d > time.Second {
+		t.Fatalf("too long handshake: %s", d)
+	}
+	select {
+	case conn := <-connCh:
+		conn.Close()
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	// The handshake deadline doesn't apply to requests.
+	es := startEchoServerTLS(t, "tcp", "127.0.0.1:0")
+	defer es.Stop()
+	c = &HostClient{
+		Addr:                es.ln.Addr().String(),
+		IsTLS:               true,
+		TLSConfig:           &tls.Config{InsecureSkipVerify: true},
+		TLSHandshakeTimeout: 50 * time.Millisecond,
+	}
+	for i := 0; i < 2; i++ {
+		statusCode, _, err := c.Get(nil, "https://"+c.Addr+"/")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code: %d. Expecting %d", statusCode, StatusOK)
+		}
+		time.Sleep(60 * time.Millisecond)
+	}
+}
+
+func TestClientDecompressResponseBody(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	body := strings.Repeat("foobar", 100)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Request.Header.Peek("Accept-Encoding")) != "gzip, deflate, br, zstd" {
+				ctx.Error("unexpected Accept-Encoding", StatusBadRequest)
+				return
+			}
+			encoding := string(ctx.Path()[1:])
+			switch encoding {
+			case "gzip":
+				ctx.SetBody(AppendGzipBytes(nil, []byte(body)))
+			case "deflate":
+				ctx.SetBody(AppendDeflateBytes(nil, []byte(body)))
+			case "br":
+				ctx.SetBody(appendBrotliBytes(nil, []byte(body), 5, 22))
+			case "zstd":
+				ctx.SetBody(AppendZstdBytes(nil, []byte(body)))
+			default:
+				ctx.SetBodyString("compressed")
+			}
+			ctx.Response.Header.Set("Content-Encoding", encoding)
+		},
+	}
+	go s.Serve(ln)
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		DecompressResponseBody: true,
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	for _, encoding := range []string{"gzip", "deflate", "br", "zstd"} {
+		req.SetRequestURI("http://foobar/" + encoding)
+		if err := c.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error for %s: %s", encoding, err)
+		}
+		if resp.StatusCode() != StatusOK {
+			t.Fatalf("unexpected status code for %s: %d. Expecting %d", encoding, resp.StatusCode(), StatusOK)
+		}
+		if string(resp.Body()) != body {
+			t.Fatalf("unexpected body for %s: %q. Expecting %q", encoding, resp.Body(), body)
+		}
+		if v := resp.Header.Peek("Content-Encoding"); len(v) > 0 {
+			t.Fatalf("unexpected Content-Encoding for %s: %q. Expecting empty value", encoding, v)
+		}
+		if len(req.Header.Peek("Accept-Encoding")) > 0 {
+			t.Fatalf("Accept-Encoding mustn't be left in the request")
+		}
+	}
+
+	// Unsupported encodings are returned as is.
+	req.SetRequestURI("http://foobar/compress")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "compressed" || string(resp.Header.Peek("Content-Encoding")) != "compress" {
+		t.Fatalf("unexpected response for compress: %q, %q", resp.Header.Peek("Content-Encoding"), resp.Body())
+	}
+
+	// MaxResponseBodySize limits the decompressed body.
+	c = &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		DecompressResponseBody: true,
+		MaxResponseBodySize:    len(body) - 1,
+	}
+	req.SetRequestURI("http://foobar/zstd")
+	if err := c.Do(req, resp); err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBodyTooLarge)
+	}
+
+	// DecompressLimits limits the decompressed body.
+	c = &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		DecompressResponseBody: true,
+		DecompressLimits:       &DecompressLimits{MaxRatio: 2},
+	}
+	req.SetRequestURI("http://foobar/gzip")
+	if _, ok := c.Do(req, resp).(*DecompressLimitError); !ok {
+		t.Fatalf("expecting *DecompressLimitError")
+	}
+
+	// DecompressLimits are applied to Response.BodyGunzip too.
+	c = &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		DecompressLimits: &DecompressLimits{MaxRatio: 2},
+	}
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br, zstd")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := resp.BodyGunzip(); err == nil {
+		t.Fatalf("expecting non-nil error from BodyGunzip")
+	}
+	c = &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if b, err := resp.BodyGunzip(); err != nil || string(b) != body {
+		t.Fatalf("unexpected BodyGunzip result: %q, %v. Expecting %q", b, err, body)
+	}
+	req.SetRequestURI("http://foobar/br")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if b, err := resp.BodyUnbrotli(); err != nil || string(b) != body {
+		t.Fatalf("unexpected BodyUnbrotli result: %q, %v. Expecting %q", b, err, body)
+	}
+}
+
+func TestHostClientRequestResponseHooks(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	var requests uint32
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			atomic.AddUint32(&requests, 1)
+			ctx.Write(ctx.Request.Header.Peek("Authorization"))
+		},
+	}
+	go s.Serve(ln)
+
+	errHook := errors.New("hook error")
+	var calls []string
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		OnRequest: []RequestHook{
+			func(req *Request) error {
+				calls = append(calls, "request1")
+				req.Header.Set("Authorization", "Bearer foobar")
+				return nil
+			},
+			func(req *Request) error {
+				calls = append(calls, "request2")
+				if string(req.URI().Path()) == "/forbidden" {
+					return errHook
+				}
+				return nil
+			},
+		},
+		OnResponse: []ResponseHook{
+			func(req *Request, resp *Response) error {
+				calls = append(calls, fmt.Sprintf("response %d", resp.StatusCode()))
+				return nil
+			},
+		},
+	}
+
+	statusCode, body, err := c.Get(nil, "http://foobar/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK || string(body) != "Bearer foobar" {
+		t.Fatalf("unexpected response: %d %q", statusCode, body)
+	}
+	expectedCalls := []string{"request1", "request2", "response 200"}
+	if fmt.Sprint(calls) != fmt.Sprint(expectedCalls) {
+		t.Fatalf("unexpected hook calls: %q. Expecting %q", calls, expectedCalls)
+	}
+
+	calls = calls[:0]
+	if _, _, err = c.Get(nil, "http://foobar/forbidden"); err != errHook {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errHook)
+	}
+	expectedCalls = []string{"request1", "request2"}
+	if fmt.Sprint(calls) != fmt.Sprint(expectedCalls) {
+		t.Fatalf("unexpected hook calls: %q. Expecting %q", calls, expectedCalls)
+	}
+	if n := atomic.LoadUint32(&requests); n != 1 {
+		t.Fatalf("unexpected number of requests sent: %d. Expecting 1", n)
+	}
+}
+
+func TestHostClientSignRequest(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	var requests uint32
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			atomic.AddUint32(&requests, 1)
+			ctx.Write(ctx.Request.Header.Peek("X-Signature"))
+		},
+	}
+	go s.Serve(ln)
+
+	errSign := errors.New("sign error")
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		SignRequest: func(req *Request) error {
+			if string(req.URI().Path()) == "/unsigned" {
+				return errSign
+			}
+			sig := fmt.Sprintf("%s %s %d %s", req.Header.Host(), req.Header.UserAgent(),
+				req.Header.ContentLength(), req.Body())
+			req.Header.Set("X-Signature", sig)
+			return nil
+		},
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar/")
+	req.Header.SetMethod("POST")
+	req.SetBodyString("foobar")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedSig := fmt.Sprintf("foobar %s 6 foobar", defaultUserAgent)
+	if string(resp.Body()) != expectedSig {
+		t.Fatalf("unexpected signature: %q. Expecting %q", resp.Body(), expectedSig)
+	}
+
+	req.SetRequestURI("http://foobar/unsigned")
+	if err := c.Do(req, resp); err != errSign {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errSign)
+	}
+	if n := atomic.LoadUint32(&requests); n != 1 {
+		t.Fatalf("unexpected number of requests sent: %d. Expecting 1", n)
+	}
+}
+
+func TestHostClientDefaultHeaders(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			fmt.Fprintf(ctx, "%s|%s|%s", ctx.Request.Header.UserAgent(),
+				ctx.Request.Header.Peek("Authorization"), ctx.Request.Header.Peek("X-Trace"))
+		},
+	}
+	go s.Serve(ln)
+
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		DefaultHeaders: map[string]string{
+			"User-Agent":    "default-agent",
+			"Authorization": "Bearer default",
+			"X-Trace":       "default-trace",
+		},
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar/")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedBody := "default-agent|Bearer default|default-trace"
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body: %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	if len(req.Header.Peek("Authorization")) > 0 || len(req.Header.UserAgent()) > 0 {
+		t.Fatalf("default headers must be removed from the request after it is sent")
+	}
+
+	// Request headers take precedence over the default headers.
+	req.Header.Set("Authorization", "Bearer custom")
+	req.Header.SetUserAgent("custom-agent")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedBody = "custom-agent|Bearer custom|default-trace"
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body: %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	if string(req.Header.Peek("Authorization")) != "Bearer custom" {
+		t.Fatalf("unexpected Authorization header: %q. Expecting %q", req.Header.Peek("Authorization"), "Bearer custom")
+	}
+	if len(req.Header.Peek("X-Trace")) > 0 {
+		t.Fatalf("unexpected X-Trace header: %q", req.Header.Peek("X-Trace"))
+	}
+}
+
+func TestHostClientHeaderInjectionPolicy(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.Request.Header.Peek("X-Trace"))
+		},
+	}
+	go s.Serve(ln)
+
+	newClient := func(p HeaderInjectionPolicy) *HostClient {
+		return &HostClient{
+			Addr: "foobar",
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+			OnRequest: []RequestHook{
+				func(req *Request) error {
+					req.Header.Set("X-Trace", "foo\r\nX-Evil: 1")
+					return nil
+				},
+			},
+			HeaderInjectionPolicy: p,
+		}
+	}
+
+	var req Request
+	var resp Response
+	req.SetRequestURI("http://foobar/")
+	if err := newClient(HeaderInjectionError).Do(&req, &resp); !errors.Is(err, ErrHeaderInjection) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrHeaderInjection)
+	}
+
+	req.Reset()
+	req.SetRequestURI("http://foobar/")
+	if err := newClient(HeaderInjectionStrip).Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "fooX-Evil: 1" {
+		t.Fatalf("unexpected body: %q. Expecting %q", resp.Body(), "fooX-Evil: 1")
+	}
+}
+
+func TestClientDoWithBodyWriter(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	body := createFixedBody(100000)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Response.Header.Set("X-Foo", "bar")
+			ctx.SetStatusCode(StatusCreated)
+			ctx.Write(body)
+		},
+	}
+	go s.Serve(ln)
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar/")
+	for i := 0; i < 3; i++ {
+		var buf bytes.Buffer
+		if err := c.DoWithBodyWriter(req, resp, &buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if resp.StatusCode() != StatusCreated {
+			t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusCreated)
+		}
+		if string(resp.Header.Peek("X-Foo")) != "bar" {
+			t.Fatalf("unexpected X-Foo header: %q. Expecting %q", resp.Header.Peek("X-Foo"), "bar")
+		}
+		if len(resp.Body()) > 0 {
+			t.Fatalf("unexpected response body with %d bytes", len(resp.Body()))
+		}
+		if !bytes.Equal(buf.Bytes(), body) {
+			t.Fatalf("unexpected body with %d bytes. Expecting %d bytes", buf.Len(), len(body))
+		}
+	}
+
+	// HEAD response has no body.
+	headReq := AcquireRequest()
+	defer ReleaseRequest(headReq)
+	headReq.SetRequestURI("http://foobar/")
+	headReq.Header.SetMethod("HEAD")
+	var buf bytes.Buffer
+	if err := c.DoWithBodyWriter(headReq, nil, &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if buf.Len() > 0 {
+		t.Fatalf("unexpected body for HEAD request: %q", buf.Bytes())
+	}
+
+	if err := c.DoWithBodyWriter(req, resp, errorWriter{}); err == nil || err.Error() != "write error" {
+		t.Fatalf("unexpected error: %v. Expecting %q", err, "write error")
+	}
+}
+
+func TestHostClientOnConnEvicted(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/close" {
+				ctx.SetConnectionClose()
+			}
+			ctx.WriteString("ok")
+		},
+	}
+	go s.Serve(ln)
+
+	var reasons []string
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		OnConnEvicted: func(remoteAddr string, reason ConnEvictionReason) {
+			if len(remoteAddr) == 0 {
+				t.Errorf("missing remote addr")
+			}
+			reasons = append(reasons, reason.String())
+		},
+	}
+	for _, path := range []string{"/", "/close"} {
+		if _, _, err := c.Get(nil, "http://foobar"+path); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// Connections closed on the client request aren't reported.
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/")
+	req.SetConnectionClose()
+	if err := c.Do(req, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.MaxConnDuration = time.Millisecond
+	if _, _, err := c.Get(nil, "http://foobar/"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, _, err := c.Get(nil, "http://foobar/"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	c.MaxConnDuration = 0
+	if _, _, err := c.Get(nil, "http://foobar/"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.closeIdleConns(nil, time.Now().Add(time.Hour), time.Minute)
+
+	expectedReasons := []string{"server close", "max conn duration", "idle"}
+	if fmt.Sprint(reasons) != fmt.Sprint(expectedReasons) {
+		t.Fatalf("unexpected eviction reasons: %q. Expecting %q", reasons, expectedReasons)
+	}
+}
+
+func TestClientSweepIdleConns(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("ok")
+		},
+	}
+	go s.Serve(ln)
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		MaxIdleConnDuration: 10 * time.Millisecond,
+		IdleCleanupInterval: time.Hour,
+	}
+	if _, _, err := c.Get(nil, "http://foobar/"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.mLock.Lock()
+	hc := c.m["foobar"]
+	c.mLock.Unlock()
+	if hc == nil {
+		t.Fatalf("missing HostClient for foobar")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	// The background cleaner mustn't run until IdleCleanupInterval.
+	if n := hc.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 1", n)
+	}
+
+	c.SweepIdleConns()
+	if n := hc.ConnsCount(); n != 0 {
+		t.Fatalf("unexpected number of connections after the sweep: %d. Expecting 0", n)
+	}
+
+	// Connections used recently mustn't be closed by the sweep.
+	if _, _, err := c.Get(nil, "http://foobar/"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	hc.MaxIdleConnDuration = time.Hour
+	hc.SweepIdleConns()
+	if n := hc.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected number of connections after the sweep: %d. Expecting 1", n)
 	}
 }
@@ -0,0 +1,111 @@
+package fasthttp
+
+import (
+	"sync"
+)
+
+// RequestCoalescer deduplicates concurrent identical GET and HEAD
+// requests into a single request sent via Client.
+//
+// The response for the sent request is copied to all the callers waiting
+// for identical requests, so hot keys don't cause thundering herds
+// on the host. Requests are coalesced only while the first of them
+// is in flight, i.e. responses aren't cached.
+//
+// Requests with other methods and requests with body stream are sent
+// via Client as is. Response body streams aren't supported, so Client
+// mustn't stream response bodies.
+//
+// It is safe calling RequestCoalescer methods from concurrently running
+// goroutines.
+type RequestCoalescer struct {
+	// Client used for sending requests.
+	Client Doer
+
+	// Callback returning the key for the given request.
+	//
+	// Concurrent requests with the same key are coalesced. The callback
+	// may return an empty key for disabling coalescing for the request.
+	//
+	// By default the key consists of request uri and all the request
+	// headers, so requests with distinct Authorization or Cookie
+	// headers aren't coalesced.
+	KeyFunc func(req *Request) string
+
+	lock  sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	resp *Response
+	err  error
+	done chan struct{}
+
+	// waiters is the number of callers, which didn't copy resp yet.
+	// It is protected by RequestCoalescer.lock.
+	waiters int
+}
+
+// Do sends req via Client unless an identical request is already in flight
+// and stores the response to resp.
+//
+// The error returned for the coalesced request is returned to all
+// the callers.
+func (rc *RequestCoalescer) Do(req *Request, resp *Response) error {
+	if !req.Header.IsGet() && !req.Header.IsHead() || req.IsBodyStream() {
+		return rc.Client.Do(req, resp)
+	}
+	key := rc.key(req)
+	if len(key) == 0 {
+		return rc.Client.Do(req, resp)
+	}
+
+	rc.lock.Lock()
+	if rc.calls == nil {
+		rc.calls = make(map[string]*coalescedCall)
+	}
+	call := rc.calls[key]
+	isLeader := call == nil
+	if isLeader {
+		call = &coalescedCall{
+			resp: AcquireResponse(),
+			done: make(chan struct{}),
+		}
+		rc.calls[key] = call
+	}
+	call.waiters++
+	rc.lock.Unlock()
+
+	if isLeader {
+		call.err = rc.Client.Do(req, call.resp)
+		rc.lock.Lock()
+		delete(rc.calls, key)
+		rc.lock.Unlock()
+		close(call.done)
+	} else {
+		<-call.done
+	}
+
+	err := call.err
+	if err == nil && resp != nil {
+		call.resp.CopyTo(resp)
+	}
+	rc.lock.Lock()
+	call.waiters--
+	if call.waiters == 0 {
+		ReleaseResponse(call.resp)
+		call.resp = nil
+	}
+	rc.lock.Unlock()
+	return err
+}
+
+func (rc *RequestCoalescer) key(req *Request) string {
+	if rc.KeyFunc != nil {
+		return rc.KeyFunc(req)
+	}
+	b := req.URI().FullURI()
+	b = append(b, '\n')
+	b = append(b, req.Header.Header()...)
+	return string(b)
+}
@@ -0,0 +1,103 @@
+package fasthttp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func TestRequestCoalescer(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	var requests uint32
+	releaseCh := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			n := atomic.AddUint32(&requests, 1)
+			if string(ctx.Path()) == "/slow" {
+				<-releaseCh
+			}
+			fmt.Fprintf(ctx, "response %d", n)
+		},
+	}
+	go s.Serve(ln)
+
+	rc := &RequestCoalescer{
+		Client: &HostClient{
+			Addr: "foobar",
+			Dial: func(addr string) (net.Conn, error) {
+				return ln.Dial()
+			},
+		},
+	}
+	waiters := func() int {
+		rc.lock.Lock()
+		defer rc.lock.Unlock()
+		n := 0
+		for _, call := range rc.calls {
+			n += call.waiters
+		}
+		return n
+	}
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	bodies := make([]string, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var req Request
+			var resp Response
+			req.SetRequestURI("http://foobar/slow")
+			if err := rc.Do(&req, &resp); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			bodies[i] = string(resp.Body())
+		}(i)
+	}
+	for waiters() < concurrency {
+		time.Sleep(time.Millisecond)
+	}
+	close(releaseCh)
+	wg.Wait()
+
+	if n := atomic.LoadUint32(&requests); n != 1 {
+		t.Fatalf("unexpected number of requests sent: %d. Expecting 1", n)
+	}
+	for i, body := range bodies {
+		if body != "response 1" {
+			t.Fatalf("unexpected body for request #%d: %q. Expecting %q", i, body, "response 1")
+		}
+	}
+	if n := waiters(); n != 0 {
+		t.Fatalf("unexpected number of waiters left: %d", n)
+	}
+
+	// Requests aren't cached.
+	test := func(method string, header string, expectedBody string) {
+		t.Helper()
+		var req Request
+		var resp Response
+		req.Header.SetMethod(method)
+		req.SetRequestURI("http://foobar/")
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		if err := rc.Do(&req, &resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(resp.Body()) != expectedBody {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+		}
+	}
+	test("GET", "", "response 2")
+	test("GET", "foo", "response 3")
+	test("POST", "", "response 4")
+}
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/VictoriaMetrics/fasthttp/stackless"
 	"github.com/klauspost/compress/flate"
 	"github.com/klauspost/compress/gzip"
 	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
 	"github.com/valyala/bytebufferpool"
 )
 
@@ -23,6 +26,45 @@ const (
 	CompressHuffmanOnly        = -2 // flate.HuffmanOnly
 )
 
+// SetStacklessCompression enables or disables stackless compression.
+//
+// Stackless compression runs compressors on a limited number of dedicated
+// goroutines, so goroutines serving requests don't need big stacks
+// for compression. This saves a lot of memory for servers compressing
+// many small responses from high number of concurrently running goroutines,
+// but adds overhead on passing data between goroutines for servers
+// compressing few large responses.
+//
+// Compressors are run directly on the calling goroutine if stackless
+// compression is disabled.
+//
+// Stackless compression is enabled by default.
+// See also stackless.SetWorkersCount for tuning stackless workers.
+func SetStacklessCompression(enable bool) {
+	v := uint32(1)
+	if enable {
+		v = 0
+	}
+	atomic.StoreUint32(&disableStacklessCompression, v)
+}
+
+var disableStacklessCompression uint32
+
+func isStacklessCompressionEnabled() bool {
+	return atomic.LoadUint32(&disableStacklessCompression) == 0
+}
+
+// callCompressFunc calls stacklessF(ctx) if stackless compression is enabled.
+//
+// f(ctx) is called on the current goroutine if stackless compression
+// is disabled or if stacklessF cannot process ctx due to high load.
+func callCompressFunc(stacklessF func(ctx interface{}) bool, f func(ctx interface{}), ctx interface{}) {
+	if isStacklessCompressionEnabled() && stacklessF(ctx) {
+		return
+	}
+	f(ctx)
+}
+
 func acquireGzipReader(r io.Reader) (*gzip.Reader, error) {
 	v := gzipReaderPool.Get()
 	if v == nil {
@@ -74,6 +116,9 @@ func resetFlateReader(zr io.ReadCloser, r io.Reader) error {
 var flateReaderPool sync.Pool
 
 func acquireStacklessGzipWriter(w io.Writer, level int) stackless.Writer {
+	if !isStacklessCompressionEnabled() {
+		return acquireRealGzipWriter(w, level)
+	}
 	nLevel := normalizeCompressLevel(level)
 	p := stacklessGzipWriterPoolMap[nLevel]
 	v := p.Get()
@@ -88,6 +133,10 @@ func acquireStacklessGzipWriter(w io.Writer, level int) stackless.Writer {
 }
 
 func releaseStacklessGzipWriter(sw stackless.Writer, level int) {
+	if zw, ok := sw.(*gzip.Writer); ok {
+		releaseRealGzipWriter(zw, level)
+		return
+	}
 	sw.Close()
 	nLevel := normalizeCompressLevel(level)
 	p := stacklessGzipWriterPoolMap[nLevel]
@@ -160,7 +209,7 @@ func WriteGzipLevel(w io.Writer, p []byte, level int) (int, error) {
 			p:     p,
 			level: level,
 		}
-		stacklessWriteGzip(ctx)
+		callCompressFunc(stacklessWriteGzip, nonblockingWriteGzip, ctx)
 		return len(p), nil
 	default:
 		zw := acquireStacklessGzipWriter(w, level)
@@ -257,7 +306,7 @@ func WriteDeflateLevel(w io.Writer, p []byte, level int) (int, error) {
 			p:     p,
 			level: level,
 		}
-		stacklessWriteDeflate(ctx)
+		callCompressFunc(stacklessWriteDeflate, nonblockingWriteDeflate, ctx)
 		return len(p), nil
 	default:
 		zw := acquireStacklessDeflateWriter(w, level)
@@ -345,6 +394,9 @@ func (r *byteSliceReader) Read(p []byte) (int, error) {
 }
 
 func acquireStacklessDeflateWriter(w io.Writer, level int) stackless.Writer {
+	if !isStacklessCompressionEnabled() {
+		return acquireRealDeflateWriter(w, level)
+	}
 	nLevel := normalizeCompressLevel(level)
 	p := stacklessDeflateWriterPoolMap[nLevel]
 	v := p.Get()
@@ -359,6 +411,10 @@ func acquireStacklessDeflateWriter(w io.Writer, level int) stackless.Writer {
 }
 
 func releaseStacklessDeflateWriter(sw stackless.Writer, level int) {
+	if zw, ok := sw.(*zlib.Writer); ok {
+		releaseRealDeflateWriter(zw, level)
+		return
+	}
 	sw.Close()
 	nLevel := normalizeCompressLevel(level)
 	p := stacklessDeflateWriterPoolMap[nLevel]
@@ -438,3 +494,210 @@ func normalizeCompressLevel(level int) int {
 	}
 	return level + 2
 }
+
+// connCompressors holds compressors reused during connection lifetime.
+//
+// See Server.ReuseCompressors for details.
+type connCompressors struct {
+	gzip      *gzip.Writer
+	gzipLevel int
+
+	deflate      *zlib.Writer
+	deflateLevel int
+
+	zstd     *zstd.Encoder
+	zstdDict *ZstdDict
+
+	w   byteSliceWriter
+	ctx connCompressCtx
+}
+
+type connCompressor interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+type connCompressCtx struct {
+	zw connCompressor
+	w  io.Writer
+	p  []byte
+}
+
+func (cc *connCompressors) appendGzipBytes(dst, src []byte, level int) []byte {
+	if cc.gzip != nil && cc.gzipLevel != level {
+		cc.releaseGzip()
+	}
+	if cc.gzip == nil {
+		cc.gzip = acquireRealGzipWriter(ioutil.Discard, level)
+		cc.gzipLevel = level
+	}
+	return cc.appendCompressedBytes(dst, src, cc.gzip)
+}
+
+func (cc *connCompressors) appendDeflateBytes(dst, src []byte, level int) []byte {
+	if cc.deflate != nil && cc.deflateLevel != level {
+		cc.releaseDeflate()
+	}
+	if cc.deflate == nil {
+		cc.deflate = acquireRealDeflateWriter(ioutil.Discard, level)
+		cc.deflateLevel = level
+	}
+	return cc.appendCompressedBytes(dst, src, cc.deflate)
+}
+
+func (cc *connCompressors) appendZstdBytes(dst, src []byte, d *ZstdDict) []byte {
+	if cc.zstd != nil && cc.zstdDict != d {
+		cc.releaseZstd()
+	}
+	if cc.zstd == nil {
+		cc.zstd = acquireZstdEncoder(d)
+		cc.zstdDict = d
+	}
+	return cc.zstd.EncodeAll(src, dst)
+}
+
+func (cc *connCompressors) appendCompressedBytes(dst, src []byte, zw connCompressor) []byte {
+	cc.w.b = dst
+	ctx := &cc.ctx
+	ctx.zw = zw
+	ctx.w = &cc.w
+	ctx.p = src
+	callCompressFunc(stacklessWriteConnCompressor, nonblockingWriteConnCompressor, ctx)
+	ctx.zw = nil
+	ctx.w = nil
+	ctx.p = nil
+	dst = cc.w.b
+	cc.w.b = nil
+	return dst
+}
+
+var stacklessWriteConnCompressor = stackless.NewFunc(nonblockingWriteConnCompressor)
+
+func nonblockingWriteConnCompressor(ctxv interface{}) {
+	ctx := ctxv.(*connCompressCtx)
+	zw := ctx.zw
+	zw.Reset(ctx.w)
+	if _, err := zw.Write(ctx.p); err != nil {
+		panic(fmt.Sprintf("BUG: compressor.Write for len(p)=%d returned unexpected error: %s", len(ctx.p), err))
+	}
+	if err := zw.Close(); err != nil {
+		panic(fmt.Sprintf("BUG: compressor.Close returned unexpected error: %s", err))
+	}
+
+	// Do not hold a reference to the written data.
+	zw.Reset(ioutil.Discard)
+}
+
+func (cc *connCompressors) releaseGzip() {
+	releaseRealGzipWriter(cc.gzip, cc.gzipLevel)
+	cc.gzip = nil
+}
+
+func (cc *connCompressors) releaseDeflate() {
+	releaseRealDeflateWriter(cc.deflate, cc.deflateLevel)
+	cc.deflate = nil
+}
+
+func (cc *connCompressors) releaseZstd() {
+	releaseZstdEncoder(cc.zstd, cc.zstdDict)
+	cc.zstd = nil
+	cc.zstdDict = nil
+}
+
+// release returns the compressors to global pools.
+func (cc *connCompressors) release() {
+	if cc.gzip != nil {
+		cc.releaseGzip()
+	}
+	if cc.deflate != nil {
+		cc.releaseDeflate()
+	}
+	if cc.zstd != nil {
+		cc.releaseZstd()
+	}
+}
+
+// DecompressLimits limits the size of decompressed data in order to protect
+// from decompression bombs - small compressed payloads, which expand
+// to huge amounts of data.
+//
+// nil *DecompressLimits doesn't limit decompressed data.
+type DecompressLimits struct {
+	// The maximum size of decompressed data in bytes.
+	//
+	// The size is unlimited by default.
+	MaxSize int
+
+	// The maximum ratio between decompressed and compressed data sizes.
+	//
+	// The ratio is unlimited by default.
+	MaxRatio int
+}
+
+// DecompressLimitError is returned when decompressed data exceeds
+// DecompressLimits.
+type DecompressLimitError struct {
+	// Limit is the exceeded limit for decompressed data size in bytes.
+	Limit int
+
+	// CompressedSize is the size of compressed data in bytes.
+	CompressedSize int
+}
+
+// Error implements error interface.
+func (e *DecompressLimitError) Error() string {
+	return fmt.Sprintf("decompressed data exceeds %d bytes; compressed size is %d bytes", e.Limit, e.CompressedSize)
+}
+
+// AppendGunzipBytes appends gunzipped src to dst and returns the resulting dst.
+//
+// *DecompressLimitError is returned if the decompressed data exceeds l.
+func (l *DecompressLimits) AppendGunzipBytes(dst, src []byte) ([]byte, error) {
+	w := l.newWriter(dst, len(src))
+	_, err := WriteGunzip(w, src)
+	return w.bw.b, err
+}
+
+// AppendInflateBytes appends inflated src to dst and returns the resulting dst.
+//
+// *DecompressLimitError is returned if the decompressed data exceeds l.
+func (l *DecompressLimits) AppendInflateBytes(dst, src []byte) ([]byte, error) {
+	w := l.newWriter(dst, len(src))
+	_, err := WriteInflate(w, src)
+	return w.bw.b, err
+}
+
+func (l *DecompressLimits) newWriter(dst []byte, compressedSize int) *decompressLimitWriter {
+	limit := int(^uint(0) >> 1)
+	if l != nil {
+		if l.MaxSize > 0 {
+			limit = l.MaxSize
+		}
+		if l.MaxRatio > 0 && compressedSize < limit/l.MaxRatio {
+			limit = compressedSize * l.MaxRatio
+		}
+	}
+	return &decompressLimitWriter{
+		bw:             byteSliceWriter{dst},
+		limit:          limit,
+		compressedSize: compressedSize,
+	}
+}
+
+type decompressLimitWriter struct {
+	bw             byteSliceWriter
+	n              int
+	limit          int
+	compressedSize int
+}
+
+func (w *decompressLimitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit-w.n {
+		return 0, &DecompressLimitError{
+			Limit:          w.limit,
+			CompressedSize: w.compressedSize,
+		}
+	}
+	w.n += len(p)
+	return w.bw.Write(p)
+}
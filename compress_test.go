@@ -103,6 +103,56 @@ func testDeflateBytesSingleCase(s string) error {
 	return nil
 }
 
+func TestStacklessCompressionDisabled(t *testing.T) {
+	SetStacklessCompression(false)
+	defer SetStacklessCompression(true)
+
+	if err := testGzipBytes(); err != nil {
+		t.Fatal(err)
+	}
+	if err := testDeflateBytes(); err != nil {
+		t.Fatal(err)
+	}
+	if err := testGzipCompress(); err != nil {
+		t.Fatal(err)
+	}
+	if err := testFlateCompress(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnCompressors(t *testing.T) {
+	var cc connCompressors
+	defer cc.release()
+
+	for i := 0; i < 10; i++ {
+		src := createFixedBody(1000 * i)
+		for _, level := range []int{CompressBestSpeed, CompressDefaultCompression} {
+			b, err := AppendGunzipBytes(nil, cc.appendGzipBytes(nil, src, level))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(b, src) {
+				t.Fatalf("unexpected gunzipped data for len(src)=%d, level=%d", len(src), level)
+			}
+			b, err = AppendInflateBytes(nil, cc.appendDeflateBytes(nil, src, level))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(b, src) {
+				t.Fatalf("unexpected inflated data for len(src)=%d, level=%d", len(src), level)
+			}
+		}
+		b, err := AppendUnzstdBytes(nil, cc.appendZstdBytes(nil, src, nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(b, src) {
+			t.Fatalf("unexpected zstd-decompressed data for len(src)=%d", len(src))
+		}
+	}
+}
+
 func TestGzipCompressSerial(t *testing.T) {
 	if err := testGzipCompress(); err != nil {
 		t.Fatal(err)
@@ -214,3 +264,81 @@ func testConcurrent(concurrency int, f func() error) error {
 	}
 	return nil
 }
+
+func TestDecompressLimits(t *testing.T) {
+	src := make([]byte, 1024*1024)
+	encoders := map[string]func(src []byte) []byte{
+		"gzip":    func(src []byte) []byte { return AppendGzipBytes(nil, src) },
+		"deflate": func(src []byte) []byte { return AppendDeflateBytes(nil, src) },
+		"zstd":    func(src []byte) []byte { return AppendZstdBytes(nil, src) },
+		"br":      func(src []byte) []byte { return appendBrotliBytes(nil, src, 5, 22) },
+	}
+	for encoding, encode := range encoders {
+		compressed := encode(src)
+		decompress := func(l *DecompressLimits) ([]byte, error) {
+			switch encoding {
+			case "gzip":
+				return l.AppendGunzipBytes(nil, compressed)
+			case "deflate":
+				return l.AppendInflateBytes(nil, compressed)
+			case "br":
+				return l.AppendUnbrotliBytes(nil, compressed)
+			default:
+				return l.AppendUnzstdBytes(nil, compressed, nil)
+			}
+		}
+
+		for _, l := range []*DecompressLimits{nil, {}, {MaxSize: len(src), MaxRatio: len(src)}} {
+			dst, err := decompress(l)
+			if err != nil {
+				t.Fatalf("unexpected error for %s with limits %+v: %s", encoding, l, err)
+			}
+			if !bytes.Equal(dst, src) {
+				t.Fatalf("unexpected %s-decompressed data with limits %+v", encoding, l)
+			}
+		}
+
+		for _, l := range []*DecompressLimits{{MaxSize: 1000}, {MaxRatio: 10}} {
+			_, err := decompress(l)
+			e, ok := err.(*DecompressLimitError)
+			if !ok {
+				t.Fatalf("unexpected error for %s with limits %+v: %v. Expecting *DecompressLimitError", encoding, l, err)
+			}
+			if e.CompressedSize != len(compressed) {
+				t.Fatalf("unexpected compressed size for %s: %d. Expecting %d", encoding, e.CompressedSize, len(compressed))
+			}
+		}
+	}
+}
+
+func TestDecompressLimitsZstdDecoderReuse(t *testing.T) {
+	src := bytes.Repeat([]byte("foobar"), 1000)
+	compressed := AppendZstdBytes(nil, src)
+	l := &DecompressLimits{MaxSize: len(src)}
+	for i := 0; i < 10; i++ {
+		dst, err := l.AppendUnzstdBytes(nil, compressed, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(dst, src) {
+			t.Fatalf("unexpected decompressed data")
+		}
+		if len(zstdStreamDecoders) == 0 {
+			t.Fatalf("expecting pooled zstd decoder")
+		}
+	}
+
+	// The pooled decoder must be usable after exceeding the limit.
+	l.MaxSize = 10
+	if _, err := l.AppendUnzstdBytes(nil, compressed, nil); err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	l.MaxSize = 0
+	dst, err := l.AppendUnzstdBytes(nil, compressed, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(dst, src) {
+		t.Fatalf("unexpected decompressed data after exceeding the limit")
+	}
+}
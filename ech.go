@@ -0,0 +1,100 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrECHUnsupported is returned if Encrypted Client Hello is requested,
+// while crypto/tls doesn't support it. ECH requires Go 1.25 or newer.
+var ErrECHUnsupported = errors.New("Encrypted Client Hello isn't supported by crypto/tls; build with Go 1.25 or newer")
+
+// ECHKey is the private key for the Encrypted Client Hello config
+// published to clients.
+type ECHKey struct {
+	// Config is the marshaled ECHConfig associated with PrivateKey.
+	//
+	// It must match the config published to clients byte-for-byte.
+	Config []byte
+
+	// PrivateKey is the marshaled HPKE private key for the KEM used
+	// in Config.
+	PrivateKey []byte
+
+	// SendAsRetry enables sending Config to clients, which attempted
+	// ECH with unknown config, so they may retry with Config.
+	SendAsRetry bool
+}
+
+// ECHKeys holds Encrypted Client Hello keys used by the server.
+//
+// Keys may be replaced via Set at any time, e.g. when the published
+// ECH configs are rotated, without restarting the server.
+// New handshakes use the new keys.
+//
+// Set Server.ECHKeys for enabling ECH on Server.ServeTLS* and
+// Server.ListenAndServeTLS*. Use ConfigureServer for custom TLS listeners.
+type ECHKeys struct {
+	keys atomic.Value
+}
+
+// Set replaces ECH keys with the given keys.
+func (k *ECHKeys) Set(keys []ECHKey) {
+	k.keys.Store(append([]ECHKey(nil), keys...))
+}
+
+// Get returns the current ECH keys.
+//
+// The returned slice mustn't be modified.
+func (k *ECHKeys) Get() []ECHKey {
+	keys, _ := k.keys.Load().([]ECHKey)
+	return keys
+}
+
+// ConfigureServer enables ECH with the current keys for TLS server
+// using cfg.
+//
+// ErrECHUnsupported is returned if crypto/tls doesn't support ECH.
+func (k *ECHKeys) ConfigureServer(cfg *tls.Config) error {
+	if !echSupported {
+		return ErrECHUnsupported
+	}
+	setServerECH(cfg, k)
+	return nil
+}
+
+// ConfigureClientECH enables ECH with the given serialized ECHConfigList
+// for TLS client using cfg.
+//
+// The handshake fails if the server rejects ECH. Use ECHRetryConfigList
+// for obtaining the config list suggested by the server in this case.
+//
+// ErrECHUnsupported is returned if crypto/tls doesn't support ECH.
+func ConfigureClientECH(cfg *tls.Config, configList []byte) error {
+	if !echSupported {
+		return ErrECHUnsupported
+	}
+	setClientECH(cfg, configList)
+	return nil
+}
+
+// ECHAccepted returns true if Encrypted Client Hello has been accepted
+// for the TLS connection with the given state.
+//
+// The state may be obtained via RequestCtx.TLSConnectionState
+// on the server side.
+func ECHAccepted(state *tls.ConnectionState) bool {
+	return state != nil && echAccepted(state)
+}
+
+// ECHRetryConfigList returns the ECHConfigList suggested by the server,
+// which rejected ECH, from the handshake error err.
+//
+// The client may retry the request with the returned config list.
+// false is returned if err isn't caused by ECH rejection or the server
+// didn't suggest configs.
+func ECHRetryConfigList(err error) ([]byte, bool) {
+	configList := echRetryConfigList(err)
+	return configList, len(configList) > 0
+}
@@ -0,0 +1,45 @@
+//go:build go1.25
+// +build go1.25
+
+package fasthttp
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+const echSupported = true
+
+func setClientECH(cfg *tls.Config, configList []byte) {
+	cfg.EncryptedClientHelloConfigList = append([]byte(nil), configList...)
+	if cfg.MinVersion < tls.VersionTLS13 {
+		cfg.MinVersion = tls.VersionTLS13
+	}
+}
+
+func setServerECH(cfg *tls.Config, k *ECHKeys) {
+	cfg.GetEncryptedClientHelloKeys = func(*tls.ClientHelloInfo) ([]tls.EncryptedClientHelloKey, error) {
+		keys := k.Get()
+		dst := make([]tls.EncryptedClientHelloKey, len(keys))
+		for i := range keys {
+			dst[i] = tls.EncryptedClientHelloKey{
+				Config:      keys[i].Config,
+				PrivateKey:  keys[i].PrivateKey,
+				SendAsRetry: keys[i].SendAsRetry,
+			}
+		}
+		return dst, nil
+	}
+}
+
+func echAccepted(state *tls.ConnectionState) bool {
+	return state.ECHAccepted
+}
+
+func echRetryConfigList(err error) []byte {
+	var re *tls.ECHRejectionError
+	if !errors.As(err, &re) {
+		return nil
+	}
+	return re.RetryConfigList
+}
@@ -0,0 +1,132 @@
+//go:build go1.25
+// +build go1.25
+
+package fasthttp
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestECHConfig returns ECHConfig with X25519 HPKE key
+// for the given publicName and the corresponding private key.
+func newTestECHConfig(t *testing.T, configID byte, publicName string) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	pub := key.PublicKey().Bytes()
+
+	var contents []byte
+	contents = append(contents, configID)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0020) // DHKEM(X25519, HKDF-SHA256)
+	contents = binary.BigEndian.AppendUint16(contents, uint16(len(pub)))
+	contents = append(contents, pub...)
+	contents = binary.BigEndian.AppendUint16(contents, 4)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // HKDF-SHA256
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // AES-128-GCM
+	contents = append(contents, 0)                             // maximum_name_length
+	contents = append(contents, byte(len(publicName)))
+	contents = append(contents, publicName...)
+	contents = binary.BigEndian.AppendUint16(contents, 0) // extensions
+
+	var config []byte
+	config = binary.BigEndian.AppendUint16(config, 0xfe0d)
+	config = binary.BigEndian.AppendUint16(config, uint16(len(contents)))
+	config = append(config, contents...)
+	return config, key.Bytes()
+}
+
+func newTestECHConfigList(configs ...[]byte) []byte {
+	var n int
+	for _, config := range configs {
+		n += len(config)
+	}
+	list := binary.BigEndian.AppendUint16(nil, uint16(n))
+	for _, config := range configs {
+		list = append(list, config...)
+	}
+	return list
+}
+
+func TestClientServerECH(t *testing.T) {
+	ca := newTestOCSPCA(t)
+	cert := ca.issue(t, 42)
+
+	config1, key1 := newTestECHConfig(t, 1, "public.example.com")
+	config2, key2 := newTestECHConfig(t, 2, "public.example.com")
+	keys := &ECHKeys{}
+	keys.Set([]ECHKey{{Config: config1, PrivateKey: key1, SendAsRetry: true}})
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if ECHAccepted(ctx.TLSConnectionState()) {
+				ctx.SetBodyString("ech")
+			} else {
+				ctx.SetBodyString("no ech")
+			}
+		},
+		ECHKeys: keys,
+	}
+	tlsLn, err := s.newCertListener(ln, &cert)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	go s.Serve(tlsLn)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	newClient := func(configList []byte) *HostClient {
+		return &HostClient{
+			Addr:          ln.Addr().String(),
+			IsTLS:         true,
+			TLSConfig:     &tls.Config{RootCAs: roots, ServerName: "example.com"},
+			ECHConfigList: configList,
+			ReadTimeout:   5 * time.Second,
+		}
+	}
+
+	c := newClient(newTestECHConfigList(config1))
+	statusCode, body, err := c.Get(nil, "https://example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK || string(body) != "ech" {
+		t.Fatalf("unexpected response: %d %q", statusCode, body)
+	}
+
+	// Rotate keys. The client with the stale config must receive
+	// the new config list for retrying.
+	keys.Set([]ECHKey{{Config: config2, PrivateKey: key2, SendAsRetry: true}})
+	_, _, err = c.Get(nil, "https://example.com/")
+	if err == nil {
+		// The connection with the old keys may be reused.
+		c = newClient(newTestECHConfigList(config1))
+		_, _, err = c.Get(nil, "https://example.com/")
+	}
+	retryList, ok := ECHRetryConfigList(err)
+	if !ok {
+		t.Fatalf("expecting ECH rejection error; got %v", err)
+	}
+
+	c = newClient(retryList)
+	statusCode, body, err = c.Get(nil, "https://example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK || string(body) != "ech" {
+		t.Fatalf("unexpected response: %d %q", statusCode, body)
+	}
+}
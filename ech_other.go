@@ -0,0 +1,22 @@
+//go:build !go1.25
+// +build !go1.25
+
+package fasthttp
+
+import (
+	"crypto/tls"
+)
+
+const echSupported = false
+
+func setClientECH(cfg *tls.Config, configList []byte) {}
+
+func setServerECH(cfg *tls.Config, k *ECHKeys) {}
+
+func echAccepted(state *tls.ConnectionState) bool {
+	return false
+}
+
+func echRetryConfigList(err error) []byte {
+	return nil
+}
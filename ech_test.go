@@ -0,0 +1,54 @@
+package fasthttp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestECHKeys(t *testing.T) {
+	var k ECHKeys
+	if keys := k.Get(); len(keys) != 0 {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	keys := []ECHKey{
+		{Config: []byte("config1"), PrivateKey: []byte("key1"), SendAsRetry: true},
+		{Config: []byte("config2"), PrivateKey: []byte("key2")},
+	}
+	k.Set(keys)
+	keys[0].SendAsRetry = false
+	got := k.Get()
+	if len(got) != 2 || !got[0].SendAsRetry || !bytes.Equal(got[1].Config, []byte("config2")) {
+		t.Fatalf("unexpected keys: %v", got)
+	}
+
+	k.Set(nil)
+	if keys := k.Get(); len(keys) != 0 {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestECHConfigure(t *testing.T) {
+	var k ECHKeys
+	err := k.ConfigureServer(&tls.Config{})
+	if echSupported != (err == nil) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = ConfigureClientECH(&tls.Config{}, []byte("foobar"))
+	if echSupported != (err == nil) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !echSupported && !errors.Is(err, ErrECHUnsupported) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrECHUnsupported)
+	}
+
+	if ECHAccepted(nil) {
+		t.Fatalf("ECH mustn't be accepted for nil state")
+	}
+	if _, ok := ECHRetryConfigList(fmt.Errorf("foobar")); ok {
+		t.Fatalf("unexpected retry config list")
+	}
+}
@@ -0,0 +1,6 @@
+// Package fasthttpproxy provides dialers for sending fasthttp requests
+// via HTTP and SOCKS5 proxies.
+//
+// The returned dialers may be passed to fasthttp.Client.Dial
+// and fasthttp.HostClient.Dial.
+package fasthttpproxy
@@ -0,0 +1,110 @@
+package fasthttpproxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp"
+)
+
+// FasthttpHTTPDialer returns fasthttp.DialFunc, which establishes
+// connections via the given HTTP proxy using CONNECT method.
+//
+// The proxy must be in the form host:port or user:password@host:port.
+// The credentials are sent in Proxy-Authorization header.
+//
+// Example usage:
+//
+//	c := &fasthttp.Client{
+//	    Dial: fasthttpproxy.FasthttpHTTPDialer("username:password@localhost:8080"),
+//	}
+func FasthttpHTTPDialer(proxy string) fasthttp.DialFunc {
+	return FasthttpHTTPDialerTimeout(proxy, 0)
+}
+
+// FasthttpHTTPDialerTimeout works like FasthttpHTTPDialer, but limits
+// the duration of connecting to the proxy and of the CONNECT handshake
+// to the given timeout.
+//
+// fasthttp.DefaultDialTimeout is used if timeout isn't positive.
+func FasthttpHTTPDialerTimeout(proxy string, timeout time.Duration) fasthttp.DialFunc {
+	proxyAddr, auth := parseHTTPProxy(proxy)
+	return func(addr string) (net.Conn, error) {
+		return dialHTTPProxy(proxyAddr, auth, addr, timeout)
+	}
+}
+
+// parseHTTPProxy returns proxy address and Proxy-Authorization header
+// value for the given proxy in the form [user:password@]host:port.
+func parseHTTPProxy(proxy string) (proxyAddr, auth string) {
+	proxy = strings.TrimPrefix(proxy, "http://")
+	n := strings.LastIndexByte(proxy, '@')
+	if n < 0 {
+		return proxy, ""
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(proxy[:n]))
+	return proxy[n+1:], "Basic " + credentials
+}
+
+func dialHTTPProxy(proxyAddr, auth, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialProxy(proxyAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if auth != "" {
+		req += "Proxy-Authorization: " + auth + "\r\n"
+	}
+	req += "\r\n"
+	if _, err = conn.Write([]byte(req)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot send CONNECT request to proxy %q: %s", proxyAddr, err)
+	}
+
+	// The proxy doesn't send data after the response header until
+	// the client sends data to the tunnel, so the reader cannot consume
+	// tunneled data.
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	resp.SkipBody = true
+	if err = resp.Read(bufio.NewReader(conn)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot read CONNECT response from proxy %q: %s", proxyAddr, err)
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %q cannot connect to %q: unexpected status code %d",
+			proxyAddr, addr, resp.StatusCode())
+	}
+	return finishProxyHandshake(conn)
+}
+
+// dialProxy dials proxyAddr and sets the deadline for the proxy handshake.
+func dialProxy(proxyAddr string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		timeout = fasthttp.DefaultDialTimeout
+	}
+	conn, err := fasthttp.DialTimeout(proxyAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// finishProxyHandshake clears the deadline set by dialProxy.
+func finishProxyHandshake(conn net.Conn) (net.Conn, error) {
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
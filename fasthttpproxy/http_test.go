@@ -0,0 +1,77 @@
+package fasthttpproxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/VictoriaMetrics/fasthttp"
+)
+
+func TestFasthttpHTTPDialer(t *testing.T) {
+	ln := startHTTPProxy(t, "Basic Zm9vOmJhcg==")
+	defer ln.Close()
+	proxyAddr := ln.Addr().String()
+
+	conn, err := FasthttpHTTPDialer("foo:bar@" + proxyAddr)("foobar:80")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	testEchoConn(t, conn)
+
+	if _, err = FasthttpHTTPDialer("foo:baz@" + proxyAddr)("foobar:80"); err == nil {
+		t.Fatalf("expecting non-nil error for invalid credentials")
+	}
+}
+
+// startHTTPProxy starts HTTP proxy, which requires the given
+// Proxy-Authorization header and echoes the data sent to CONNECT tunnels
+// to foobar:80.
+func startHTTPProxy(t *testing.T, auth string) net.Listener {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				var req fasthttp.Request
+				if err := req.Read(br); err != nil {
+					return
+				}
+				if string(req.Header.Method()) != "CONNECT" || string(req.Header.RequestURI()) != "foobar:80" {
+					conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+					return
+				}
+				if string(req.Header.Peek("Proxy-Authorization")) != auth {
+					conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+					return
+				}
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+				io.Copy(conn, br)
+			}()
+		}
+	}()
+	return ln
+}
+
+func testEchoConn(t *testing.T, conn net.Conn) {
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("unexpected data read from the tunnel: %q. Expecting %q", buf, "ping")
+	}
+}
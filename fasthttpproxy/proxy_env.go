@@ -0,0 +1,178 @@
+package fasthttpproxy
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp"
+)
+
+// FasthttpProxyHTTPDialer returns fasthttp.DialFunc, which establishes
+// connections via proxies from environment variables.
+//
+// HTTPS_PROXY is used for addrs with 443 port, while HTTP_PROXY is used
+// for the remaining addrs. Addrs matching NO_PROXY are dialed directly.
+// Lowercase variable names are supported too. Proxies with socks5 scheme
+// are dialed via SOCKS5 protocol, while the remaining proxies are dialed
+// via HTTP CONNECT method.
+//
+// Environment variables are read when the function is called.
+func FasthttpProxyHTTPDialer() fasthttp.DialFunc {
+	return FasthttpProxyHTTPDialerTimeout(0)
+}
+
+// FasthttpProxyHTTPDialerTimeout works like FasthttpProxyHTTPDialer,
+// but limits the duration of connecting to the proxy and of the proxy
+// handshake to the given timeout.
+//
+// fasthttp.DefaultDialTimeout is used if timeout isn't positive.
+func FasthttpProxyHTTPDialerTimeout(timeout time.Duration) fasthttp.DialFunc {
+	pe, err := proxyEnvFromEnvironment()
+	if err != nil {
+		return func(addr string) (net.Conn, error) {
+			return nil, err
+		}
+	}
+	return func(addr string) (net.Conn, error) {
+		return pe.dial(addr, timeout)
+	}
+}
+
+// proxyEnv holds proxies obtained from environment variables.
+type proxyEnv struct {
+	httpProxy  proxyDialer
+	httpsProxy proxyDialer
+	noProxy    []string
+}
+
+// proxyDialer dials addr via a proxy.
+type proxyDialer func(addr string, timeout time.Duration) (net.Conn, error)
+
+func proxyEnvFromEnvironment() (*proxyEnv, error) {
+	return newProxyEnv(getEnvAny("HTTP_PROXY", "http_proxy"), getEnvAny("HTTPS_PROXY", "https_proxy"),
+		getEnvAny("NO_PROXY", "no_proxy"))
+}
+
+func newProxyEnv(httpProxy, httpsProxy, noProxy string) (*proxyEnv, error) {
+	var pe proxyEnv
+	var err error
+	if pe.httpProxy, err = newProxyDialer(httpProxy); err != nil {
+		return nil, err
+	}
+	if pe.httpsProxy, err = newProxyDialer(httpsProxy); err != nil {
+		return nil, err
+	}
+	for _, s := range strings.Split(noProxy, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s != "" {
+			pe.noProxy = append(pe.noProxy, s)
+		}
+	}
+	return &pe, nil
+}
+
+func newProxyDialer(proxy string) (proxyDialer, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse proxy %q: %s", proxy, err)
+	}
+	switch u.Scheme {
+	case "http":
+		proxyAddr, auth := parseHTTPProxy(strings.TrimPrefix(proxy, "http://"))
+		return func(addr string, timeout time.Duration) (net.Conn, error) {
+			return dialHTTPProxy(proxyAddr, auth, addr, timeout)
+		}, nil
+	case "socks5", "socks5h":
+		sp, err := parseSocks5Proxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		return sp.dial, nil
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q for proxy %q", u.Scheme, proxy)
+	}
+}
+
+func (pe *proxyEnv) dial(addr string, timeout time.Duration) (net.Conn, error) {
+	if pd := pe.proxyFor(addr); pd != nil {
+		return pd(addr, timeout)
+	}
+	if timeout <= 0 {
+		return fasthttp.Dial(addr)
+	}
+	return fasthttp.DialTimeout(addr, timeout)
+}
+
+// proxyFor returns the proxy for the given addr or nil if addr
+// must be dialed directly.
+func (pe *proxyEnv) proxyFor(addr string) proxyDialer {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	pd := pe.httpProxy
+	if port == "443" {
+		pd = pe.httpsProxy
+	}
+	if pd == nil || matchNoProxy(pe.noProxy, strings.ToLower(host), port) {
+		return nil
+	}
+	return pd
+}
+
+// matchNoProxy returns true if host:port matches one of NO_PROXY entries.
+//
+// Entries may contain IP addresses, CIDR ranges and domain names
+// with optional port. Domain names match their subdomains too.
+// The "*" entry matches all the hosts.
+func matchNoProxy(noProxy []string, host, port string) bool {
+	ip := net.ParseIP(host)
+	for _, entry := range noProxy {
+		if entry == "*" {
+			return true
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		entry = strings.TrimPrefix(entry, "*")
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func getEnvAny(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,73 @@
+package fasthttpproxy
+
+import (
+	"testing"
+)
+
+func TestMatchNoProxy(t *testing.T) {
+	noProxy := []string{"localhost", ".example.com", "10.0.0.0/8", "192.168.1.1", "foo.bar:8080"}
+	f := func(host, port string, expected bool) {
+		t.Helper()
+		if result := matchNoProxy(noProxy, host, port); result != expected {
+			t.Fatalf("unexpected result for %s:%s: %v. Expecting %v", host, port, result, expected)
+		}
+	}
+	f("localhost", "80", true)
+	f("example.com", "80", true)
+	f("www.example.com", "443", true)
+	f("notexample.com", "80", false)
+	f("10.1.2.3", "80", true)
+	f("11.1.2.3", "80", false)
+	f("192.168.1.1", "80", true)
+	f("192.168.1.2", "80", false)
+	f("foo.bar", "8080", true)
+	f("foo.bar", "80", false)
+
+	if !matchNoProxy([]string{"*"}, "foobar", "80") {
+		t.Fatalf("the * entry must match all the hosts")
+	}
+}
+
+func TestProxyEnvProxyFor(t *testing.T) {
+	pe, err := newProxyEnv("http-proxy:3128", "socks5://https-proxy:1080", "localhost")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if pe.proxyFor("foobar:80") == nil {
+		t.Fatalf("expecting HTTP_PROXY for foobar:80")
+	}
+	if pe.proxyFor("foobar:443") == nil {
+		t.Fatalf("expecting HTTPS_PROXY for foobar:443")
+	}
+	if pe.proxyFor("localhost:80") != nil {
+		t.Fatalf("expecting direct connection for localhost:80")
+	}
+
+	pe, err = newProxyEnv("http-proxy:3128", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if pe.proxyFor("foobar:443") != nil {
+		t.Fatalf("expecting direct connection for foobar:443 without HTTPS_PROXY")
+	}
+
+	if _, err = newProxyEnv("ftp://proxy:21", "", ""); err == nil {
+		t.Fatalf("expecting non-nil error for unsupported scheme")
+	}
+}
+
+func TestProxyEnvDial(t *testing.T) {
+	ln := startHTTPProxy(t, "")
+	defer ln.Close()
+
+	pe, err := newProxyEnv(ln.Addr().String(), "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn, err := pe.dial("foobar:80", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	testEchoConn(t, conn)
+}
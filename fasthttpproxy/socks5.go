@@ -0,0 +1,225 @@
+package fasthttpproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp"
+)
+
+// FasthttpSocksDialer returns fasthttp.DialFunc, which establishes
+// connections via the given SOCKS5 proxy.
+//
+// The proxy must be in the form socks5://[user:password@]host:port.
+// Host names are resolved by the proxy.
+//
+// Example usage:
+//
+//	c := &fasthttp.Client{
+//	    Dial: fasthttpproxy.FasthttpSocksDialer("socks5://localhost:9050"),
+//	}
+func FasthttpSocksDialer(proxy string) fasthttp.DialFunc {
+	return FasthttpSocksDialerTimeout(proxy, 0)
+}
+
+// FasthttpSocksDialerTimeout works like FasthttpSocksDialer, but limits
+// the duration of connecting to the proxy and of the SOCKS5 handshake
+// to the given timeout.
+//
+// fasthttp.DefaultDialTimeout is used if timeout isn't positive.
+func FasthttpSocksDialerTimeout(proxy string, timeout time.Duration) fasthttp.DialFunc {
+	sp, err := parseSocks5Proxy(proxy)
+	if err != nil {
+		return func(addr string) (net.Conn, error) {
+			return nil, err
+		}
+	}
+	return func(addr string) (net.Conn, error) {
+		return sp.dial(addr, timeout)
+	}
+}
+
+type socks5Proxy struct {
+	addr     string
+	user     string
+	password string
+	hasAuth  bool
+}
+
+func parseSocks5Proxy(proxy string) (*socks5Proxy, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse SOCKS5 proxy %q: %s", proxy, err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("unsupported scheme %q for SOCKS5 proxy %q", u.Scheme, proxy)
+	}
+	sp := &socks5Proxy{
+		addr: u.Host,
+	}
+	if u.User != nil {
+		sp.user = u.User.Username()
+		sp.password, _ = u.User.Password()
+		sp.hasAuth = true
+		if len(sp.user) > 255 || len(sp.password) > 255 {
+			return nil, fmt.Errorf("too long credentials for SOCKS5 proxy %q", u.Host)
+		}
+	}
+	return sp, nil
+}
+
+// SOCKS5 protocol constants. See https://tools.ietf.org/html/rfc1928 .
+const (
+	socks5Version = 5
+
+	socks5AuthNone            = 0
+	socks5AuthPassword        = 2
+	socks5AuthNoAcceptable    = 0xff
+	socks5AuthPasswordVersion = 1
+
+	socks5CmdConnect = 1
+
+	socks5AddrIPv4   = 1
+	socks5AddrDomain = 3
+	socks5AddrIPv6   = 4
+)
+
+var socks5Errors = []string{
+	"",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+func (sp *socks5Proxy) dial(addr string, timeout time.Duration) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port in addr %q", addr)
+	}
+	if len(host) > 255 {
+		return nil, fmt.Errorf("too long host name in addr %q", addr)
+	}
+
+	conn, err := dialProxy(sp.addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err = sp.handshake(conn, host, port); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot connect to %q via SOCKS5 proxy %q: %s", addr, sp.addr, err)
+	}
+	return finishProxyHandshake(conn)
+}
+
+func (sp *socks5Proxy) handshake(conn net.Conn, host string, port int) error {
+	buf := make([]byte, 0, 6+len(host))
+
+	// Negotiate authentication method.
+	buf = append(buf, socks5Version)
+	if sp.hasAuth {
+		buf = append(buf, 2, socks5AuthNone, socks5AuthPassword)
+	} else {
+		buf = append(buf, 1, socks5AuthNone)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != socks5Version {
+		return fmt.Errorf("unexpected protocol version %d", buf[0])
+	}
+	switch buf[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if !sp.hasAuth {
+			return errors.New("the proxy requires authentication")
+		}
+		// See https://tools.ietf.org/html/rfc1929 .
+		buf = append(buf[:0], socks5AuthPasswordVersion, byte(len(sp.user)))
+		buf = append(buf, sp.user...)
+		buf = append(buf, byte(len(sp.password)))
+		buf = append(buf, sp.password...)
+		if _, err := conn.Write(buf); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return err
+		}
+		if buf[1] != 0 {
+			return errors.New("authentication failed")
+		}
+	case socks5AuthNoAcceptable:
+		return errors.New("no acceptable authentication methods")
+	default:
+		return fmt.Errorf("unsupported authentication method %d", buf[1])
+	}
+
+	// Send CONNECT request.
+	buf = append(buf[:0], socks5Version, socks5CmdConnect, 0)
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf = append(buf, socks5AddrIPv4)
+			buf = append(buf, ip4...)
+		} else {
+			buf = append(buf, socks5AddrIPv6)
+			buf = append(buf, ip.To16()...)
+		}
+	} else {
+		buf = append(buf, socks5AddrDomain, byte(len(host)))
+		buf = append(buf, host...)
+	}
+	buf = append(buf, byte(port>>8), byte(port))
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+
+	// Read the reply and skip the bound address.
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return err
+	}
+	if buf[0] != socks5Version {
+		return fmt.Errorf("unexpected protocol version %d", buf[0])
+	}
+	if code := int(buf[1]); code != 0 {
+		if code < len(socks5Errors) {
+			return errors.New(socks5Errors[code])
+		}
+		return fmt.Errorf("unknown error code %d", code)
+	}
+	var n int
+	switch buf[3] {
+	case socks5AddrIPv4:
+		n = net.IPv4len
+	case socks5AddrIPv6:
+		n = net.IPv6len
+	case socks5AddrDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		n = int(buf[0])
+	default:
+		return fmt.Errorf("unknown address type %d", buf[3])
+	}
+	// Bound address is followed by 2-byte port.
+	if cap(buf) < n+2 {
+		buf = make([]byte, n+2)
+	}
+	_, err := io.ReadFull(conn, buf[:n+2])
+	return err
+}
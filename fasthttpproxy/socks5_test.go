@@ -0,0 +1,119 @@
+package fasthttpproxy
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+func TestFasthttpSocksDialer(t *testing.T) {
+	ln := startSocks5Proxy(t, "foo", "bar")
+	defer ln.Close()
+	proxyAddr := ln.Addr().String()
+
+	conn, err := FasthttpSocksDialer("socks5://foo:bar@" + proxyAddr)("foobar:80")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	testEchoConn(t, conn)
+
+	if _, err = FasthttpSocksDialer("socks5://foo:baz@" + proxyAddr)("foobar:80"); err == nil {
+		t.Fatalf("expecting non-nil error for invalid credentials")
+	}
+	if _, err = FasthttpSocksDialer("socks5://" + proxyAddr)("foobar:80"); err == nil {
+		t.Fatalf("expecting non-nil error for missing credentials")
+	}
+	if _, err = FasthttpSocksDialer("http://" + proxyAddr)("foobar:80"); err == nil {
+		t.Fatalf("expecting non-nil error for unsupported scheme")
+	}
+}
+
+// startSocks5Proxy starts SOCKS5 proxy, which requires the given
+// credentials and echoes the data sent to connections to foobar:80.
+func startSocks5Proxy(t *testing.T, user, password string) net.Listener {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if serveSocks5Handshake(conn, user, password) {
+					io.Copy(conn, conn)
+				}
+			}()
+		}
+	}()
+	return ln
+}
+
+func serveSocks5Handshake(conn net.Conn, user, password string) bool {
+	buf := make([]byte, 512)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return false
+	}
+	methods := buf[2 : 2+buf[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return false
+	}
+	hasPasswordAuth := false
+	for _, m := range methods {
+		if m == socks5AuthPassword {
+			hasPasswordAuth = true
+		}
+	}
+	if !hasPasswordAuth {
+		conn.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+		return false
+	}
+	conn.Write([]byte{socks5Version, socks5AuthPassword})
+
+	// Read username and password.
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return false
+	}
+	u := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, u); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return false
+	}
+	p := make([]byte, buf[0])
+	if _, err := io.ReadFull(conn, p); err != nil {
+		return false
+	}
+	if string(u) != user || string(p) != password {
+		conn.Write([]byte{socks5AuthPasswordVersion, 1})
+		return false
+	}
+	conn.Write([]byte{socks5AuthPasswordVersion, 0})
+
+	// Read CONNECT request for foobar:80.
+	if _, err := io.ReadFull(conn, buf[:5]); err != nil {
+		return false
+	}
+	if buf[1] != socks5CmdConnect || buf[3] != socks5AddrDomain {
+		conn.Write([]byte{socks5Version, 7, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		return false
+	}
+	host := make([]byte, buf[4])
+	if _, err := io.ReadFull(conn, host); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return false
+	}
+	if string(host) != "foobar" || int(buf[0])<<8|int(buf[1]) != 80 {
+		conn.Write([]byte{socks5Version, 4, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		return false
+	}
+	conn.Write([]byte{socks5Version, 0, 0, socks5AddrIPv4, 127, 0, 0, 1, 0, 80})
+	return true
+}
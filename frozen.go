@@ -0,0 +1,67 @@
+package fasthttp
+
+// FrozenResponse is an immutable snapshot of response headers and body.
+//
+// Unlike Response, FrozenResponse may be shared among concurrently running
+// goroutines without synchronization, so it is suitable for caching layers,
+// which serve the same response to many clients. FrozenResponse mustn't
+// be copied by value. Use Response.Freeze for obtaining FrozenResponse.
+type FrozenResponse struct {
+	noCopy noCopy
+
+	header ResponseHeader
+	body   []byte
+}
+
+// Freeze returns immutable snapshot of resp headers and body.
+//
+// Body stream is read into the snapshot if it is set. resp may be
+// modified or released after the call, since the snapshot doesn't
+// refer resp memory.
+func (resp *Response) Freeze() *FrozenResponse {
+	fr := &FrozenResponse{}
+	resp.Header.CopyTo(&fr.header)
+	fr.body = append([]byte(nil), resp.Body()...)
+	return fr
+}
+
+// StatusCode returns response status code.
+func (fr *FrozenResponse) StatusCode() int {
+	return fr.header.StatusCode()
+}
+
+// Body returns response body.
+//
+// The returned value mustn't be modified, since it is shared among
+// all the FrozenResponse users.
+func (fr *FrozenResponse) Body() []byte {
+	return fr.body
+}
+
+// Peek returns header value for the given key.
+//
+// The returned value mustn't be modified, since it is shared among
+// all the FrozenResponse users.
+func (fr *FrozenResponse) Peek(key string) []byte {
+	var buf [64]byte
+	k := append(buf[:0], key...)
+	normalizeHeaderKey(k, false)
+	return fr.header.peek(k)
+}
+
+// VisitAll calls f for each header.
+//
+// f must not retain references to key and/or value after returning.
+// Copy key and/or value contents before returning if you need retaining them.
+func (fr *FrozenResponse) VisitAll(f func(key, value []byte)) {
+	fr.header.VisitAll(f)
+}
+
+// CopyTo copies the snapshot headers and body to dst.
+//
+// dst may be modified after the call without affecting the snapshot.
+func (fr *FrozenResponse) CopyTo(dst *Response) {
+	dst.Reset()
+	fr.header.CopyTo(&dst.Header)
+	dst.bodyBuffer().Set(fr.body)
+}
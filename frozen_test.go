@@ -0,0 +1,75 @@
+package fasthttp
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFrozenResponse(t *testing.T) {
+	var resp Response
+	resp.SetStatusCode(StatusNotFound)
+	resp.Header.SetContentType("text/html")
+	resp.Header.Set("X-Foo", "bar")
+	resp.SetBodyString("not found")
+
+	fr := resp.Freeze()
+
+	// Modifications of the original response mustn't affect the snapshot.
+	resp.Reset()
+	resp.Header.Set("X-Foo", "baz")
+	resp.SetBodyString("modified")
+
+	if fr.StatusCode() != StatusNotFound {
+		t.Fatalf("unexpected status code: %d. Expecting %d", fr.StatusCode(), StatusNotFound)
+	}
+	if string(fr.Body()) != "not found" {
+		t.Fatalf("unexpected body %q. Expecting %q", fr.Body(), "not found")
+	}
+	if v := fr.Peek("x-foo"); string(v) != "bar" {
+		t.Fatalf("unexpected header value %q. Expecting %q", v, "bar")
+	}
+	if v := fr.Peek("Content-Type"); string(v) != "text/html" {
+		t.Fatalf("unexpected content-type %q. Expecting %q", v, "text/html")
+	}
+	n := 0
+	fr.VisitAll(func(key, value []byte) {
+		n++
+	})
+	if n != 2 {
+		t.Fatalf("unexpected number of headers visited: %d. Expecting 2", n)
+	}
+}
+
+func TestFrozenResponseConcurrentCopyTo(t *testing.T) {
+	var resp Response
+	resp.Header.Set("X-Foo", "bar")
+	resp.SetBodyString("foobar")
+	fr := resp.Freeze()
+
+	ch := make(chan error, 10)
+	for i := 0; i < cap(ch); i++ {
+		go func(i int) {
+			var dst Response
+			for j := 0; j < 100; j++ {
+				fr.CopyTo(&dst)
+				if string(dst.Body()) != "foobar" {
+					ch <- fmt.Errorf("unexpected body %q. Expecting %q", dst.Body(), "foobar")
+					return
+				}
+				if v := dst.Header.Peek("X-Foo"); string(v) != "bar" {
+					ch <- fmt.Errorf("unexpected header value %q. Expecting %q", v, "bar")
+					return
+				}
+				// Modifications of the copy mustn't affect the snapshot.
+				dst.Header.Set("X-Foo", fmt.Sprintf("baz%d", i))
+				dst.AppendBodyString("baz")
+			}
+			ch <- nil
+		}(i)
+	}
+	for i := 0; i < cap(ch); i++ {
+		if err := <-ch; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
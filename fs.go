@@ -7,11 +7,11 @@ import (
 	"html"
 	"io"
 	"io/ioutil"
-	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -198,6 +198,16 @@ type FS struct {
 	// Path to the root directory to serve files from.
 	Root string
 
+	// Root directories for virtual hosts.
+	//
+	// Files for requests with Host header matching a key of the map
+	// are served from the corresponding directory. Hosts are matched
+	// case-insensitively without port. Root is used for other hosts.
+	// Each root directory has its own file handle cache.
+	//
+	// By default files for all the hosts are served from Root.
+	VHostRoots map[string]string
+
 	// List of index file names to try opening during directory access.
 	//
 	// For example:
@@ -219,6 +229,14 @@ type FS struct {
 	// By default index pages aren't generated.
 	GenerateIndexPages bool
 
+	// Generator of index pages for directories.
+	//
+	// It is used only if GenerateIndexPages is set. DirIndexJSON may be used
+	// for serving machine-readable directory listings.
+	//
+	// DirIndexHTML is used by default.
+	DirIndex DirIndexFunc
+
 	// Transparently compresses responses if set to true.
 	//
 	// The server tries minimizing CPU usage by caching compressed files.
@@ -253,8 +271,25 @@ type FS struct {
 	// FSCompressedFileSuffix is used by default.
 	CompressedFileSuffix string
 
-	once sync.Once
-	h    RequestHandler
+	// Registry of MIME types used for detecting Content-Type
+	// by file extension.
+	//
+	// DefaultMIMETypes is used by default.
+	MIMETypes *MIMETypes
+
+	// Disables Content-Type detection by file contents for files
+	// with extensions missing in MIMETypes.
+	//
+	// application/octet-stream is sent for such files if set.
+	//
+	// By default Content-Type is sniffed from the first 512 bytes
+	// of such files.
+	DisableContentSniffing bool
+
+	once   sync.Once
+	h      RequestHandler
+	fh     *fsHandler
+	vhosts map[string]*fsHandler
 }
 
 // FSCompressedFileSuffix is the suffix FS adds to the original file names
@@ -315,9 +350,59 @@ func (fs *FS) NewRequestHandler() RequestHandler {
 	return fs.h
 }
 
+// PurgeCache removes cached file handles for the given path,
+// so the next request for the path re-reads the file from Root.
+//
+// path must match the request path after PathRewrite. Handles for all
+// the encoding variants of the file in Root and VHostRoots are removed. Handles in use
+// by pending requests are closed after the requests complete.
+func (fs *FS) PurgeCache(path string) {
+	fs.once.Do(fs.initRequestHandler)
+	path = string(stripTrailingSlashes([]byte(path)))
+	fs.fh.purgeCache(path)
+	for _, vh := range fs.vhosts {
+		vh.purgeCache(path)
+	}
+}
+
 func (fs *FS) initRequestHandler() {
-	root := fs.Root
+	h := fs.newFSHandler(fs.Root)
+	fs.fh = h
+	if len(fs.VHostRoots) == 0 {
+		fs.h = h.handleRequest
+		return
+	}
+
+	vhosts := make(map[string]*fsHandler, len(fs.VHostRoots))
+	for host, root := range fs.VHostRoots {
+		b := []byte(host)
+		lowercaseBytes(b)
+		vhosts[string(b)] = fs.newFSHandler(root)
+	}
+	fs.vhosts = vhosts
+	fs.h = func(ctx *RequestCtx) {
+		if vh := lookupVHost(vhosts, ctx.Host()); vh != nil {
+			vh.handleRequest(ctx)
+			return
+		}
+		h.handleRequest(ctx)
+	}
+}
+
+// lookupVHost returns handler for the given host with optional port.
+//
+// nil is returned if there is no handler for the host.
+func lookupVHost(vhosts map[string]*fsHandler, host []byte) *fsHandler {
+	if n := bytes.LastIndexByte(host, ':'); n >= 0 && bytes.IndexByte(host[n:], ']') < 0 {
+		host = host[:n]
+	}
+	var buf [64]byte
+	b := append(buf[:0], host...)
+	lowercaseBytes(b)
+	return vhosts[string(b)]
+}
 
+func (fs *FS) newFSHandler(root string) *fsHandler {
 	// serve files from the current working directory if root is empty
 	if len(root) == 0 {
 		root = "."
@@ -336,16 +421,27 @@ func (fs *FS) initRequestHandler() {
 	if len(compressedFileSuffix) == 0 {
 		compressedFileSuffix = FSCompressedFileSuffix
 	}
+	mimeTypes := fs.MIMETypes
+	if mimeTypes == nil {
+		mimeTypes = DefaultMIMETypes
+	}
+	dirIndex := fs.DirIndex
+	if dirIndex == nil {
+		dirIndex = DirIndexHTML
+	}
 
 	h := &fsHandler{
 		root:                 root,
 		indexNames:           fs.IndexNames,
 		pathRewrite:          fs.PathRewrite,
 		generateIndexPages:   fs.GenerateIndexPages,
+		dirIndex:             dirIndex,
 		compress:             fs.Compress,
 		acceptByteRange:      fs.AcceptByteRange,
 		cacheDuration:        cacheDuration,
 		compressedFileSuffix: compressedFileSuffix,
+		mimeTypes:            mimeTypes,
+		disableSniffing:      fs.DisableContentSniffing,
 		cache:                make(map[string]*fsFile),
 		compressedCache:      make(map[string]*fsFile),
 	}
@@ -358,7 +454,7 @@ func (fs *FS) initRequestHandler() {
 		}
 	}()
 
-	fs.h = h.handleRequest
+	return h
 }
 
 type fsHandler struct {
@@ -366,13 +462,17 @@ type fsHandler struct {
 	indexNames           []string
 	pathRewrite          PathRewriteFunc
 	generateIndexPages   bool
+	dirIndex             DirIndexFunc
 	compress             bool
 	acceptByteRange      bool
 	cacheDuration        time.Duration
 	compressedFileSuffix string
+	mimeTypes            *MIMETypes
+	disableSniffing      bool
 
 	cache           map[string]*fsFile
 	compressedCache map[string]*fsFile
+	purgedFiles     []*fsFile
 	cacheLock       sync.Mutex
 
 	smallFileReaderPool sync.Pool
@@ -388,6 +488,7 @@ type fsFile struct {
 
 	lastModified    time.Time
 	lastModifiedStr []byte
+	etag            []byte
 
 	t            time.Time
 	readersCount int
@@ -625,7 +726,9 @@ func (h *fsHandler) cleanCache(pendingFiles []*fsFile) []*fsFile {
 	h.cacheLock.Lock()
 
 	// Close files which couldn't be closed before due to non-zero
-	// readers count on the previous run.
+	// readers count on the previous run or which were purged.
+	pendingFiles = append(pendingFiles, h.purgedFiles...)
+	h.purgedFiles = nil
 	var remainingFiles []*fsFile
 	for _, ff := range pendingFiles {
 		if ff.readersCount > 0 {
@@ -648,6 +751,19 @@ func (h *fsHandler) cleanCache(pendingFiles []*fsFile) []*fsFile {
 	return pendingFiles
 }
 
+func (h *fsHandler) purgeCache(path string) {
+	h.cacheLock.Lock()
+	for _, cache := range []map[string]*fsFile{h.cache, h.compressedCache} {
+		if ff, ok := cache[path]; ok {
+			// The file may have pending readers, so postpone its closing
+			// till the next cleanCache run.
+			h.purgedFiles = append(h.purgedFiles, ff)
+			delete(cache, path)
+		}
+	}
+	h.cacheLock.Unlock()
+}
+
 func cleanCacheNolock(cache map[string]*fsFile, pendingFiles, filesToRelease []*fsFile, cacheDuration time.Duration) ([]*fsFile, []*fsFile) {
 	t := time.Now()
 	for k, ff := range cache {
@@ -752,6 +868,9 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 	if !ctx.IfModifiedSince(ff.lastModified) {
 		ff.decReadersCount()
 		ctx.NotModified()
+		if h.compress {
+			ctx.Response.Header.SetCanonical(strVary, strAcceptEncoding)
+		}
 		return
 	}
 
@@ -766,12 +885,17 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 	if ff.compressed {
 		hdr.SetCanonical(strContentEncoding, strGzip)
 	}
+	if h.compress {
+		// The response depends on Accept-Encoding request header,
+		// so caches must store distinct variants of the response.
+		hdr.SetCanonical(strVary, strAcceptEncoding)
+	}
 
 	statusCode := StatusOK
 	contentLength := ff.contentLength
 	if h.acceptByteRange {
 		hdr.SetCanonical(strAcceptRanges, strBytes)
-		if len(byteRange) > 0 {
+		if len(byteRange) > 0 && ctx.IfRange(ff.etag, ff.lastModified) {
 			startPos, endPos, err := ParseByteRange(byteRange, contentLength)
 			if err != nil {
 				r.(io.Closer).Close()
@@ -794,6 +918,7 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 	}
 
 	hdr.SetCanonical(strLastModified, ff.lastModifiedStr)
+	hdr.SetCanonical(strETag, ff.etag)
 	if !ctx.IsHead() {
 		ctx.SetBodyStream(r, contentLength)
 	} else {
@@ -812,6 +937,20 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 	ctx.SetStatusCode(statusCode)
 }
 
+// appendFileETag appends strong ETag for the file with the given
+// modification time and size to dst.
+func appendFileETag(dst []byte, lastModified time.Time, size int, compressed bool) []byte {
+	dst = append(dst, '"')
+	dst = strconv.AppendInt(dst, lastModified.UnixNano(), 16)
+	dst = append(dst, '-')
+	dst = strconv.AppendInt(dst, int64(size), 16)
+	if compressed {
+		// Distinct encodings of the file must have distinct entity tags.
+		dst = append(dst, "-gzip"...)
+	}
+	return append(dst, '"')
+}
+
 type byteRangeUpdater interface {
 	UpdateByteRange(startPos, endPos int) error
 }
@@ -896,22 +1035,107 @@ var (
 	errNoCreatePermission = errors.New("no 'create file' permissions")
 )
 
-func (h *fsHandler) createDirIndex(base *URI, dirPath string, mustCompress bool) (*fsFile, error) {
-	w := &ByteBuffer{}
+// DirEntry describes a file or a directory in the directory index page.
+type DirEntry struct {
+	// Name is the file name without the path.
+	Name string
+
+	// IsDir is set if the entry is a directory.
+	IsDir bool
+
+	// Size is the file size in bytes.
+	Size int64
+
+	// ModTime is the last modification time of the file.
+	ModTime time.Time
+}
+
+// DirIndexFunc must write directory index page to w and return
+// its Content-Type.
+//
+// base is the requested directory URI. entries are sorted by name.
+//
+// DirIndexFunc mustn't retain references to base and entries after returning.
+type DirIndexFunc func(w io.Writer, base *URI, entries []DirEntry) (contentType string, err error)
+
+// DirIndexHTML writes HTML directory index page with links to entries.
+//
+// This is the default FS.DirIndex.
+func DirIndexHTML(w io.Writer, base *URI, entries []DirEntry) (string, error) {
+	bw := &ByteBuffer{}
 
 	basePathEscaped := html.EscapeString(string(base.Path()))
-	fmt.Fprintf(w, "<html><head><title>%s</title><style>.dir { font-weight: bold }</style></head><body>", basePathEscaped)
-	fmt.Fprintf(w, "<h1>%s</h1>", basePathEscaped)
-	fmt.Fprintf(w, "<ul>")
+	fmt.Fprintf(bw, "<html><head><title>%s</title><style>.dir { font-weight: bold }</style></head><body>", basePathEscaped)
+	fmt.Fprintf(bw, "<h1>%s</h1>", basePathEscaped)
+	fmt.Fprintf(bw, "<ul>")
 
 	if len(basePathEscaped) > 1 {
 		var parentURI URI
 		base.CopyTo(&parentURI)
 		parentURI.Update(string(base.Path()) + "/..")
 		parentPathEscaped := html.EscapeString(string(parentURI.Path()))
-		fmt.Fprintf(w, `<li><a href="%s" class="dir">..</a></li>`, parentPathEscaped)
+		fmt.Fprintf(bw, `<li><a href="%s" class="dir">..</a></li>`, parentPathEscaped)
+	}
+
+	var u URI
+	base.CopyTo(&u)
+	u.Update(string(u.Path()) + "/")
+
+	for _, e := range entries {
+		u.Update(e.Name)
+		pathEscaped := html.EscapeString(string(u.Path()))
+		auxStr := "dir"
+		className := "dir"
+		if !e.IsDir {
+			auxStr = fmt.Sprintf("file, %d bytes", e.Size)
+			className = "file"
+		}
+		fmt.Fprintf(bw, `<li><a href="%s" class="%s">%s</a>, %s, last modified %s</li>`,
+			pathEscaped, className, html.EscapeString(e.Name), auxStr, e.ModTime)
 	}
 
+	fmt.Fprintf(bw, "</ul></body></html>")
+
+	if _, err := w.Write(bw.B); err != nil {
+		return "", err
+	}
+	return "text/html; charset=utf-8", nil
+}
+
+// DirIndexJSON writes directory index as JSON array of objects
+// with name, dir, size and mtime fields. mtime is formatted
+// according to RFC 3339.
+func DirIndexJSON(w io.Writer, base *URI, entries []DirEntry) (string, error) {
+	var b []byte
+	b = append(b, '[')
+	for i, e := range entries {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"name":`...)
+		b = appendJSONString(b, []byte(e.Name))
+		b = append(b, `,"dir":`...)
+		b = strconv.AppendBool(b, e.IsDir)
+		b = append(b, `,"size":`...)
+		b = strconv.AppendInt(b, e.Size, 10)
+		b = append(b, `,"mtime":"`...)
+		b = e.ModTime.AppendFormat(b, time.RFC3339)
+		b = append(b, `"}`...)
+	}
+	b = append(b, ']')
+	if _, err := w.Write(b); err != nil {
+		return "", err
+	}
+	return "application/json", nil
+}
+
+type dirEntriesByName []DirEntry
+
+func (s dirEntriesByName) Len() int           { return len(s) }
+func (s dirEntriesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s dirEntriesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (h *fsHandler) createDirIndex(base *URI, dirPath string, mustCompress bool) (*fsFile, error) {
 	f, err := os.Open(dirPath)
 	if err != nil {
 		return nil, err
@@ -923,39 +1147,28 @@ func (h *fsHandler) createDirIndex(base *URI, dirPath string, mustCompress bool)
 		return nil, err
 	}
 
-	fm := make(map[string]os.FileInfo, len(fileinfos))
-	var filenames []string
+	entries := make([]DirEntry, 0, len(fileinfos))
 	for _, fi := range fileinfos {
 		name := fi.Name()
 		if strings.HasSuffix(name, h.compressedFileSuffix) {
 			// Do not show compressed files on index page.
 			continue
 		}
-		fm[name] = fi
-		filenames = append(filenames, name)
+		entries = append(entries, DirEntry{
+			Name:    name,
+			IsDir:   fi.IsDir(),
+			Size:    fi.Size(),
+			ModTime: fsModTime(fi.ModTime()),
+		})
 	}
+	sort.Sort(dirEntriesByName(entries))
 
-	var u URI
-	base.CopyTo(&u)
-	u.Update(string(u.Path()) + "/")
-
-	sort.Sort(sort.StringSlice(filenames))
-	for _, name := range filenames {
-		u.Update(name)
-		pathEscaped := html.EscapeString(string(u.Path()))
-		fi := fm[name]
-		auxStr := "dir"
-		className := "dir"
-		if !fi.IsDir() {
-			auxStr = fmt.Sprintf("file, %d bytes", fi.Size())
-			className = "file"
-		}
-		fmt.Fprintf(w, `<li><a href="%s" class="%s">%s</a>, %s, last modified %s</li>`,
-			pathEscaped, className, html.EscapeString(name), auxStr, fsModTime(fi.ModTime()))
+	w := &ByteBuffer{}
+	contentType, err := h.dirIndex(w, base, entries)
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Fprintf(w, "</ul></body></html>")
-
 	if mustCompress {
 		var zbuf ByteBuffer
 		zbuf.B = AppendGzipBytesLevel(zbuf.B, w.B, CompressDefaultCompression)
@@ -967,11 +1180,12 @@ func (h *fsHandler) createDirIndex(base *URI, dirPath string, mustCompress bool)
 	ff := &fsFile{
 		h:               h,
 		dirIndex:        dirIndex,
-		contentType:     "text/html; charset=utf-8",
+		contentType:     contentType,
 		contentLength:   len(dirIndex),
 		compressed:      mustCompress,
 		lastModified:    lastModified,
 		lastModifiedStr: AppendHTTPDate(nil, lastModified),
+		etag:            appendFileETag(nil, lastModified, len(dirIndex), mustCompress),
 
 		t: lastModified,
 	}
@@ -1134,7 +1348,10 @@ func (h *fsHandler) newFSFile(f *os.File, fileInfo os.FileInfo, compressed bool)
 
 	// detect content-type
 	ext := fileExtension(fileInfo.Name(), compressed, h.compressedFileSuffix)
-	contentType := mime.TypeByExtension(ext)
+	contentType := h.mimeTypes.Get(ext)
+	if len(contentType) == 0 && h.disableSniffing {
+		contentType = "application/octet-stream"
+	}
 	if len(contentType) == 0 {
 		data, err := readFileHeader(f, compressed)
 		if err != nil {
@@ -1152,6 +1369,7 @@ func (h *fsHandler) newFSFile(f *os.File, fileInfo os.FileInfo, compressed bool)
 		compressed:      compressed,
 		lastModified:    lastModified,
 		lastModifiedStr: AppendHTTPDate(nil, lastModified),
+		etag:            appendFileETag(nil, lastModified, contentLength, compressed),
 
 		t: time.Now(),
 	}
@@ -3,6 +3,7 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"path"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -304,6 +306,153 @@ func getFileContents(path string) ([]byte, error) {
 	return ioutil.ReadAll(f)
 }
 
+func TestFSIfRange(t *testing.T) {
+	fs := &FS{
+		Root:            ".",
+		AcceptByteRange: true,
+	}
+	h := fs.NewRequestHandler()
+
+	serve := func(ifRange string) *RequestCtx {
+		var ctx RequestCtx
+		var req Request
+		req.SetRequestURI("http://foobar.com/fs.go")
+		req.Header.Set("Range", "bytes=0-9")
+		if len(ifRange) > 0 {
+			req.Header.Set("If-Range", ifRange)
+		}
+		ctx.Init(&req, nil, nil)
+		h(&ctx)
+		return &ctx
+	}
+
+	ctx := serve("")
+	if ctx.Response.StatusCode() != StatusPartialContent {
+		t.Fatalf("unexpected status code: %d. Expecting %d", ctx.Response.StatusCode(), StatusPartialContent)
+	}
+	etag := string(ctx.Response.Header.Peek("ETag"))
+	if len(etag) == 0 || etag[0] != '"' {
+		t.Fatalf("unexpected ETag %q", etag)
+	}
+	lastModified := string(ctx.Response.Header.Peek("Last-Modified"))
+
+	for _, v := range []string{etag, lastModified} {
+		if code := serve(v).Response.StatusCode(); code != StatusPartialContent {
+			t.Fatalf("unexpected status code for If-Range %q: %d. Expecting %d", v, code, StatusPartialContent)
+		}
+	}
+	for _, v := range []string{`"foobar"`, "W/" + etag, "Tue, 10 Nov 2009 23:00:00 GMT", "foobar"} {
+		if code := serve(v).Response.StatusCode(); code != StatusOK {
+			t.Fatalf("unexpected status code for If-Range %q: %d. Expecting %d", v, code, StatusOK)
+		}
+	}
+}
+
+func TestFSVaryAndPurgeCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttp-fs")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := dir + "/foo.txt"
+	writeFile := func(s string, modTime time.Time) {
+		if err := ioutil.WriteFile(filePath, []byte(s), 0644); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	writeFile(strings.Repeat("foobar", 100), time.Unix(1e9, 0))
+
+	fs := &FS{
+		Root:     dir,
+		Compress: true,
+	}
+	h := fs.NewRequestHandler()
+
+	serve := func(acceptEncoding string) *Response {
+		var ctx RequestCtx
+		var req Request
+		req.SetRequestURI("http://foobar.com/foo.txt")
+		if len(acceptEncoding) > 0 {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		ctx.Init(&req, nil, nil)
+		h(&ctx)
+		var resp Response
+		ctx.Response.CopyTo(&resp)
+		resp.SetBody(ctx.Response.Body())
+		return &resp
+	}
+
+	resp := serve("")
+	zresp := serve("gzip")
+	for _, r := range []*Response{resp, zresp} {
+		if v := r.Header.Peek("Vary"); string(v) != "Accept-Encoding" {
+			t.Fatalf("unexpected Vary header %q. Expecting %q", v, "Accept-Encoding")
+		}
+	}
+	if v := zresp.Header.Peek("Content-Encoding"); string(v) != "gzip" {
+		t.Fatalf("unexpected Content-Encoding %q. Expecting %q", v, "gzip")
+	}
+	etag := string(resp.Header.Peek("ETag"))
+	zetag := string(zresp.Header.Peek("ETag"))
+	if len(etag) == 0 || etag == zetag {
+		t.Fatalf("encoding variants must have distinct ETags; got %q and %q", etag, zetag)
+	}
+
+	// Cached file handles are used until the cache is purged.
+	writeFile(strings.Repeat("bazqux", 100), time.Unix(2e9, 0))
+	if v := serve("").Header.Peek("ETag"); string(v) != etag {
+		t.Fatalf("unexpected ETag %q. Expecting cached %q", v, etag)
+	}
+
+	fs.PurgeCache("/foo.txt")
+	resp = serve("")
+	if !strings.HasPrefix(string(resp.Body()), "bazqux") {
+		t.Fatalf("unexpected body after cache purge %q", resp.Body())
+	}
+	zresp = serve("gzip")
+	body, err := zresp.BodyGunzip()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != string(resp.Body()) {
+		t.Fatalf("unexpected compressed body after cache purge %q. Expecting %q", body, resp.Body())
+	}
+}
+
+func TestFSVHostRoots(t *testing.T) {
+	fs := &FS{
+		Root: ".",
+		VHostRoots: map[string]string{
+			"Foo.com": "./fasthttputil/",
+		},
+	}
+	h := fs.NewRequestHandler()
+
+	testFSVHostRoot(t, h, "foo.com", "/pipeconns.go", StatusOK)
+	testFSVHostRoot(t, h, "FOO.com:8080", "/pipeconns.go", StatusOK)
+	testFSVHostRoot(t, h, "foo.com", "/fs.go", StatusNotFound)
+	testFSVHostRoot(t, h, "bar.com", "/fs.go", StatusOK)
+	testFSVHostRoot(t, h, "bar.com", "/pipeconns.go", StatusNotFound)
+}
+
+func testFSVHostRoot(t *testing.T, h RequestHandler, host, path string, expectedStatusCode int) {
+	var ctx RequestCtx
+	var req Request
+	req.SetRequestURI(path)
+	req.Header.SetHost(host)
+	ctx.Init(&req, nil, nil)
+	h(&ctx)
+	if ctx.Response.StatusCode() != expectedStatusCode {
+		t.Fatalf("unexpected status code for host=%q, path=%q: %d. Expecting %d",
+			host, path, ctx.Response.StatusCode(), expectedStatusCode)
+	}
+}
+
 func TestParseByteRangeSuccess(t *testing.T) {
 	testParseByteRangeSuccess(t, "bytes=0-0", 1, 0, 0)
 	testParseByteRangeSuccess(t, "bytes=1234-6789", 6790, 1234, 6789)
@@ -617,6 +766,117 @@ func testStripPathSlashes(t *testing.T, path string, stripSlashes int, expectedP
 	}
 }
 
+func TestFSMIMETypes(t *testing.T) {
+	fs := &FS{
+		Root:      ".",
+		MIMETypes: NewMIMETypes(map[string]string{".go": "text/x-go"}),
+	}
+	h := fs.NewRequestHandler()
+	var ctx RequestCtx
+	var req Request
+	req.SetRequestURI("http://foobar.com/fs.go")
+	ctx.Init(&req, nil, nil)
+	h(&ctx)
+	if v := ctx.Response.Header.ContentType(); string(v) != "text/x-go" {
+		t.Fatalf("unexpected Content-Type %q. Expecting %q", v, "text/x-go")
+	}
+
+	// Files with unknown extensions are sniffed by default.
+	req.SetRequestURI("http://foobar.com/LICENSE")
+	ctx.Init(&req, nil, nil)
+	h(&ctx)
+	if v := ctx.Response.Header.ContentType(); string(v) != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected Content-Type %q. Expecting %q", v, "text/plain; charset=utf-8")
+	}
+
+	fs = &FS{
+		Root:                   ".",
+		MIMETypes:              &MIMETypes{},
+		DisableContentSniffing: true,
+	}
+	h = fs.NewRequestHandler()
+	req.SetRequestURI("http://foobar.com/fs.go")
+	ctx.Init(&req, nil, nil)
+	h(&ctx)
+	if v := ctx.Response.Header.ContentType(); string(v) != "application/octet-stream" {
+		t.Fatalf("unexpected Content-Type %q. Expecting %q", v, "application/octet-stream")
+	}
+}
+
+func TestFSDirIndex(t *testing.T) {
+	fs := &FS{
+		Root:               ".",
+		GenerateIndexPages: true,
+		DirIndex:           DirIndexJSON,
+	}
+	h := fs.NewRequestHandler()
+	var ctx RequestCtx
+	var req Request
+	req.SetRequestURI("http://foobar.com/fasthttputil")
+	ctx.Init(&req, nil, nil)
+	h(&ctx)
+	if v := ctx.Response.Header.ContentType(); string(v) != "application/json" {
+		t.Fatalf("unexpected Content-Type %q. Expecting %q", v, "application/json")
+	}
+	var entries []struct {
+		Name  string    `json:"name"`
+		IsDir bool      `json:"dir"`
+		Size  int64     `json:"size"`
+		MTime time.Time `json:"mtime"`
+	}
+	if err := json.Unmarshal(ctx.Response.Body(), &entries); err != nil {
+		t.Fatalf("cannot parse JSON index %q: %s", ctx.Response.Body(), err)
+	}
+	fi, err := os.Stat("fasthttputil/doc.go")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	found := false
+	for i, e := range entries {
+		if i > 0 && entries[i-1].Name >= e.Name {
+			t.Fatalf("entries aren't sorted by name: %q, %q", entries[i-1].Name, e.Name)
+		}
+		if e.Name == "doc.go" {
+			found = true
+			if e.IsDir || e.Size != fi.Size() || !e.MTime.Equal(fsModTime(fi.ModTime())) {
+				t.Fatalf("unexpected entry for doc.go: %+v", e)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("missing doc.go in JSON index %q", ctx.Response.Body())
+	}
+
+	var base string
+	var names []string
+	fs = &FS{
+		Root:               ".",
+		GenerateIndexPages: true,
+		DirIndex: func(w io.Writer, u *URI, entries []DirEntry) (string, error) {
+			base = string(u.Path())
+			for _, e := range entries {
+				names = append(names, e.Name)
+			}
+			_, err := fmt.Fprintf(w, "%d entries", len(entries))
+			return "text/plain; charset=utf-8", err
+		},
+	}
+	h = fs.NewRequestHandler()
+	req.SetRequestURI("http://foobar.com/fasthttputil/")
+	ctx.Init(&req, nil, nil)
+	h(&ctx)
+	if base != "/fasthttputil/" {
+		t.Fatalf("unexpected base path %q. Expecting %q", base, "/fasthttputil/")
+	}
+	expectedBody := fmt.Sprintf("%d entries", len(names))
+	if string(ctx.Response.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", ctx.Response.Body(), expectedBody)
+	}
+	if v := ctx.Response.Header.ContentType(); string(v) != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected Content-Type %q. Expecting %q", v, "text/plain; charset=utf-8")
+	}
+}
+
 func TestFileExtension(t *testing.T) {
 	testFileExtension(t, "foo.bar", false, "zzz", ".bar")
 	testFileExtension(t, "foobar", false, "zzz", "")
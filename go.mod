@@ -3,6 +3,7 @@ module github.com/VictoriaMetrics/fasthttp
 go 1.13
 
 require (
+	github.com/andybalholm/brotli v1.0.5
 	github.com/klauspost/compress v1.12.2
 	github.com/valyala/bytebufferpool v1.0.0
 	github.com/valyala/tcplisten v0.0.0-20161114210144-ceec8f93295a
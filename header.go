@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -27,13 +28,36 @@ type ResponseHeader struct {
 	contentLength      int
 	contentLengthBytes []byte
 
-	contentType []byte
-	server      []byte
+	contentType    []byte
+	contentTypeBuf []byte
+	server         []byte
 
 	h     []argsKV
 	bufKV argsKV
 
 	cookies []argsKV
+
+	// trailers are sent after the chunked body.
+	trailers []argsKV
+
+	// readSize is the size of the header read from the wire.
+	readSize int
+
+	internValues       bool
+	disableNormalizing bool
+
+	// injectionPolicy is applied to keys and values with CR or LF
+	// passed to header setters.
+	injectionPolicy HeaderInjectionPolicy
+
+	// injectionRejected is set if a value with CR or LF has been
+	// rejected due to HeaderInjectionError policy.
+	injectionRejected bool
+
+	// injections contains keys and values with CR or LF, which have been
+	// sanitized or rejected, if recordInjections is set.
+	injections       []HeaderInjection
+	recordInjections bool
 }
 
 // RequestHeader represents HTTP request header.
@@ -58,18 +82,137 @@ type RequestHeader struct {
 	contentLength      int
 	contentLengthBytes []byte
 
-	method      []byte
-	requestURI  []byte
-	host        []byte
-	contentType []byte
-	userAgent   []byte
+	method         []byte
+	requestURI     []byte
+	host           []byte
+	contentType    []byte
+	contentTypeBuf []byte
+	userAgent      []byte
+	userAgentBuf   []byte
 
 	h     []argsKV
 	bufKV argsKV
 
 	cookies []argsKV
 
+	// trailers are sent after the chunked body.
+	trailers []argsKV
+
 	rawHeaders []byte
+
+	// readSize is the size of the header read from the wire.
+	readSize int
+
+	internValues       bool
+	disableNormalizing bool
+	strictParsing      bool
+
+	// injectionPolicy is applied to keys and values with CR or LF
+	// passed to header setters.
+	injectionPolicy HeaderInjectionPolicy
+
+	// injectionRejected is set if a value with CR or LF has been
+	// rejected due to HeaderInjectionError policy.
+	injectionRejected bool
+
+	// arena is used for parsed headers if set.
+	arena *arena
+
+	// rawRecord receives raw header bytes on read if set.
+	rawRecord *[]byte
+}
+
+// SetInternValues enables or disables interning of frequent header values
+// such as Content-Type when reading the header.
+//
+// Interned values are shared between headers, so repeated values aren't
+// copied on each read. Values returned by ContentType and Peek are
+// immutable when interning is enabled, i.e. they mustn't be modified
+// in place. Modified interned values are detected and aren't shared
+// anymore. The setting isn't cleared by Reset.
+//
+// Header values are copied by default.
+func (h *ResponseHeader) SetInternValues(internValues bool) {
+	h.internValues = internValues
+}
+
+// SetDisableNormalizing disables or enables header names' normalization.
+//
+// By default all the header names are normalized by uppercasing
+// the first letter and all the first letters following dashes,
+// while lowercasing all the other letters.
+// Examples:
+//
+//     * CONNECTION -> Connection
+//     * conteNT-tYPE -> Content-Type
+//     * foo-bar-baz -> Foo-Bar-Baz
+//
+// Disable header names' normalization only if you know what are you doing.
+// Header names are then stored and sent as is, so they must be passed
+// to Set, Add and Del with the original casing, while Peek compares them
+// case-insensitively. Names of headers handled specially such as
+// Content-Type and Content-Length are always normalized. The setting
+// isn't cleared by Reset.
+func (h *ResponseHeader) SetDisableNormalizing(disableNormalizing bool) {
+	h.disableNormalizing = disableNormalizing
+}
+
+// SetInjectionPolicy sets the policy for keys and values with CR or LF
+// passed to header setters such as Set, Add, SetCanonical, SetCookie,
+// SetContentType, SetServer and SetTrailer. Use SetUnsafe for setting
+// trusted headers without checks. The setting isn't cleared by Reset.
+//
+// HeaderInjectionStrip is used by default.
+func (h *ResponseHeader) SetInjectionPolicy(p HeaderInjectionPolicy) {
+	h.injectionPolicy = p
+}
+
+// SetInternValues enables or disables interning of frequent header values
+// such as Content-Type and User-Agent when reading the header.
+//
+// Interned values are shared between headers, so repeated values aren't
+// copied on each read. Values returned by ContentType, UserAgent and Peek
+// are immutable when interning is enabled, i.e. they mustn't be modified
+// in place. Modified interned values are detected and aren't shared
+// anymore. The setting isn't cleared by Reset.
+//
+// Header values are copied by default.
+func (h *RequestHeader) SetInternValues(internValues bool) {
+	h.internValues = internValues
+}
+
+// SetDisableNormalizing disables or enables header names' normalization.
+//
+// See ResponseHeader.SetDisableNormalizing for details.
+func (h *RequestHeader) SetDisableNormalizing(disableNormalizing bool) {
+	h.disableNormalizing = disableNormalizing
+}
+
+// SetStrictParsing enables or disables strict parsing of the header.
+//
+// Headers with obsolete line folding, with chars other than tokens
+// in names (including whitespace before the colon) and with NUL
+// or other control chars in values are rejected when reading the header
+// in strict mode. The setting isn't cleared by Reset.
+//
+// Such headers are tolerated by default.
+func (h *RequestHeader) SetStrictParsing(strictParsing bool) {
+	h.strictParsing = strictParsing
+}
+
+// SetInjectionPolicy sets the policy for keys and values with CR or LF
+// passed to header setters such as Set, Add, SetCanonical, SetCookie,
+// SetHost, SetUserAgent, SetMethod, SetRequestURI and SetTrailer.
+//
+// The policy is applied to Request.URI setters for host and query string
+// too. They strip CR and LF unless the policy is HeaderInjectionAllow
+// or HeaderInjectionPanic, since they cannot report errors. Use SetUnsafe
+// for setting trusted headers without checks. The setting isn't cleared
+// by Reset.
+//
+// HeaderInjectionStrip is used by default.
+func (h *RequestHeader) SetInjectionPolicy(p HeaderInjectionPolicy) {
+	h.injectionPolicy = p
 }
 
 // SetContentRange sets 'Content-Range: bytes startPos-endPos/contentLength'
@@ -278,12 +421,20 @@ func (h *ResponseHeader) ContentType() []byte {
 
 // SetContentType sets Content-Type header value.
 func (h *ResponseHeader) SetContentType(contentType string) {
-	h.contentType = append(h.contentType[:0], contentType...)
+	h.SetContentTypeBytes(s2b(contentType))
 }
 
 // SetContentTypeBytes sets Content-Type header value.
 func (h *ResponseHeader) SetContentTypeBytes(contentType []byte) {
-	h.contentType = append(h.contentType[:0], contentType...)
+	_, contentType, ok := h.checkInjection(strContentType, contentType)
+	if !ok {
+		return
+	}
+	h.setContentTypeBytes(contentType)
+}
+
+func (h *ResponseHeader) setContentTypeBytes(contentType []byte) {
+	setHeaderValue(&h.contentType, &h.contentTypeBuf, contentType)
 }
 
 // Server returns Server header value.
@@ -293,11 +444,15 @@ func (h *ResponseHeader) Server() []byte {
 
 // SetServer sets Server header value.
 func (h *ResponseHeader) SetServer(server string) {
-	h.server = append(h.server[:0], server...)
+	h.SetServerBytes(s2b(server))
 }
 
 // SetServerBytes sets Server header value.
 func (h *ResponseHeader) SetServerBytes(server []byte) {
+	_, server, ok := h.checkInjection(strServer, server)
+	if !ok {
+		return
+	}
 	h.server = append(h.server[:0], server...)
 }
 
@@ -309,14 +464,21 @@ func (h *RequestHeader) ContentType() []byte {
 
 // SetContentType sets Content-Type header value.
 func (h *RequestHeader) SetContentType(contentType string) {
-	h.parseRawHeaders()
-	h.contentType = append(h.contentType[:0], contentType...)
+	h.SetContentTypeBytes(s2b(contentType))
 }
 
 // SetContentTypeBytes sets Content-Type header value.
 func (h *RequestHeader) SetContentTypeBytes(contentType []byte) {
+	_, contentType, ok := h.checkInjection(strContentType, contentType)
+	if !ok {
+		return
+	}
+	h.setContentTypeBytes(contentType)
+}
+
+func (h *RequestHeader) setContentTypeBytes(contentType []byte) {
 	h.parseRawHeaders()
-	h.contentType = append(h.contentType[:0], contentType...)
+	setHeaderValue(&h.contentType, &h.contentTypeBuf, contentType)
 }
 
 // SetMultipartFormBoundary sets the following Content-Type:
@@ -416,12 +578,19 @@ func (h *RequestHeader) Host() []byte {
 
 // SetHost sets Host header value.
 func (h *RequestHeader) SetHost(host string) {
-	h.parseRawHeaders()
-	h.host = append(h.host[:0], host...)
+	h.SetHostBytes(s2b(host))
 }
 
 // SetHostBytes sets Host header value.
 func (h *RequestHeader) SetHostBytes(host []byte) {
+	_, host, ok := h.checkInjection(strHost, host)
+	if !ok {
+		return
+	}
+	h.setHostBytes(host)
+}
+
+func (h *RequestHeader) setHostBytes(host []byte) {
 	h.parseRawHeaders()
 	h.host = append(h.host[:0], host...)
 }
@@ -434,14 +603,21 @@ func (h *RequestHeader) UserAgent() []byte {
 
 // SetUserAgent sets User-Agent header value.
 func (h *RequestHeader) SetUserAgent(userAgent string) {
-	h.parseRawHeaders()
-	h.userAgent = append(h.userAgent[:0], userAgent...)
+	h.SetUserAgentBytes(s2b(userAgent))
 }
 
 // SetUserAgentBytes sets User-Agent header value.
 func (h *RequestHeader) SetUserAgentBytes(userAgent []byte) {
+	_, userAgent, ok := h.checkInjection(strUserAgent, userAgent)
+	if !ok {
+		return
+	}
+	h.setUserAgentBytes(userAgent)
+}
+
+func (h *RequestHeader) setUserAgentBytes(userAgent []byte) {
 	h.parseRawHeaders()
-	h.userAgent = append(h.userAgent[:0], userAgent...)
+	setHeaderValue(&h.userAgent, &h.userAgentBuf, userAgent)
 }
 
 // Referer returns Referer header value.
@@ -469,11 +645,18 @@ func (h *RequestHeader) Method() []byte {
 
 // SetMethod sets HTTP request method.
 func (h *RequestHeader) SetMethod(method string) {
-	h.method = append(h.method[:0], method...)
+	h.SetMethodBytes(s2b(method))
 }
 
 // SetMethodBytes sets HTTP request method.
 func (h *RequestHeader) SetMethodBytes(method []byte) {
+	if hasCRLF(method) {
+		_, m, ok := h.checkInjection(nil, method)
+		if !ok {
+			return
+		}
+		method = m
+	}
 	h.method = append(h.method[:0], method...)
 }
 
@@ -490,13 +673,20 @@ func (h *RequestHeader) RequestURI() []byte {
 // RequestURI must be properly encoded.
 // Use URI.RequestURI for constructing proper RequestURI if unsure.
 func (h *RequestHeader) SetRequestURI(requestURI string) {
-	h.requestURI = append(h.requestURI[:0], requestURI...)
+	h.SetRequestURIBytes(s2b(requestURI))
 }
 
 // SetRequestURIBytes sets RequestURI for the first HTTP request line.
 // RequestURI must be properly encoded.
 // Use URI.RequestURI for constructing proper RequestURI if unsure.
 func (h *RequestHeader) SetRequestURIBytes(requestURI []byte) {
+	if hasCRLF(requestURI) {
+		_, u, ok := h.checkInjection(nil, requestURI)
+		if !ok {
+			return
+		}
+		requestURI = u
+	}
 	h.requestURI = append(h.requestURI[:0], requestURI...)
 }
 
@@ -533,6 +723,19 @@ func (h *RequestHeader) IsDelete() bool {
 	return bytes.Equal(h.Method(), strDelete)
 }
 
+// IsOptions returns true if request method is OPTIONS.
+func (h *RequestHeader) IsOptions() bool {
+	return bytes.Equal(h.Method(), strOptions)
+}
+
+// IsAsteriskForm returns true if the request is server-wide
+// 'OPTIONS *' request, i.e. its' RequestURI is in asterisk-form.
+//
+// See https://tools.ietf.org/html/rfc7230#section-5.3.4 for details.
+func (h *RequestHeader) IsAsteriskForm() bool {
+	return bytes.Equal(h.requestURI, strAsterisk) && h.IsOptions()
+}
+
 // IsHTTP11 returns true if the request is HTTP/1.1.
 func (h *RequestHeader) IsHTTP11() bool {
 	return !h.noHTTP11
@@ -598,6 +801,10 @@ func (h *ResponseHeader) Reset() {
 
 	h.h = h.h[:0]
 	h.cookies = h.cookies[:0]
+	h.trailers = h.trailers[:0]
+	h.readSize = 0
+	h.injectionRejected = false
+	h.injections = h.injections[:0]
 }
 
 // Reset clears request header.
@@ -615,12 +822,19 @@ func (h *RequestHeader) Reset() {
 	h.contentType = h.contentType[:0]
 	h.userAgent = h.userAgent[:0]
 
-	h.h = h.h[:0]
+	if h.arena != nil {
+		h.h = resetArenaArgs(h.h)
+	} else {
+		h.h = h.h[:0]
+	}
 	h.cookies = h.cookies[:0]
 	h.cookiesCollected = false
+	h.trailers = h.trailers[:0]
 
 	h.rawHeaders = h.rawHeaders[:0]
 	h.rawHeadersParsed = false
+	h.readSize = 0
+	h.injectionRejected = false
 }
 
 // CopyTo copies all the headers to dst.
@@ -633,10 +847,13 @@ func (h *ResponseHeader) CopyTo(dst *ResponseHeader) {
 	dst.statusCode = h.statusCode
 	dst.contentLength = h.contentLength
 	dst.contentLengthBytes = append(dst.contentLengthBytes[:0], h.contentLengthBytes...)
-	dst.contentType = append(dst.contentType[:0], h.contentType...)
+	setHeaderValue(&dst.contentType, &dst.contentTypeBuf, h.contentType)
 	dst.server = append(dst.server[:0], h.server...)
 	dst.h = copyArgs(dst.h, h.h)
 	dst.cookies = copyArgs(dst.cookies, h.cookies)
+	dst.trailers = copyArgs(dst.trailers, h.trailers)
+	dst.injectionRejected = h.injectionRejected
+	dst.injections = append(dst.injections[:0], h.injections...)
 }
 
 // CopyTo copies all the headers to dst.
@@ -652,13 +869,15 @@ func (h *RequestHeader) CopyTo(dst *RequestHeader) {
 	dst.method = append(dst.method[:0], h.method...)
 	dst.requestURI = append(dst.requestURI[:0], h.requestURI...)
 	dst.host = append(dst.host[:0], h.host...)
-	dst.contentType = append(dst.contentType[:0], h.contentType...)
-	dst.userAgent = append(dst.userAgent[:0], h.userAgent...)
+	setHeaderValue(&dst.contentType, &dst.contentTypeBuf, h.contentType)
+	setHeaderValue(&dst.userAgent, &dst.userAgentBuf, h.userAgent)
 	dst.h = copyArgs(dst.h, h.h)
 	dst.cookies = copyArgs(dst.cookies, h.cookies)
 	dst.cookiesCollected = h.cookiesCollected
+	dst.trailers = copyArgs(dst.trailers, h.trailers)
 	dst.rawHeaders = append(dst.rawHeaders[:0], h.rawHeaders...)
 	dst.rawHeadersParsed = h.rawHeadersParsed
+	dst.injectionRejected = h.injectionRejected
 }
 
 // VisitAll calls f for each header.
@@ -743,14 +962,14 @@ func (h *RequestHeader) VisitAll(f func(key, value []byte)) {
 
 // Del deletes header with the given key.
 func (h *ResponseHeader) Del(key string) {
-	k := getHeaderKeyBytes(&h.bufKV, key)
+	k := getHeaderKeyBytes(&h.bufKV, key, h.disableNormalizing)
 	h.del(k)
 }
 
 // DelBytes deletes header with the given key.
 func (h *ResponseHeader) DelBytes(key []byte) {
 	h.bufKV.key = append(h.bufKV.key[:0], key...)
-	normalizeHeaderKey(h.bufKV.key)
+	normalizeHeaderKey(h.bufKV.key, h.disableNormalizing)
 	h.del(h.bufKV.key)
 }
 
@@ -774,7 +993,7 @@ func (h *ResponseHeader) del(key []byte) {
 // Del deletes header with the given key.
 func (h *RequestHeader) Del(key string) {
 	h.parseRawHeaders()
-	k := getHeaderKeyBytes(&h.bufKV, key)
+	k := getHeaderKeyBytes(&h.bufKV, key, h.disableNormalizing)
 	h.del(k)
 }
 
@@ -782,7 +1001,7 @@ func (h *RequestHeader) Del(key string) {
 func (h *RequestHeader) DelBytes(key []byte) {
 	h.parseRawHeaders()
 	h.bufKV.key = append(h.bufKV.key[:0], key...)
-	normalizeHeaderKey(h.bufKV.key)
+	normalizeHeaderKey(h.bufKV.key, h.disableNormalizing)
 	h.del(h.bufKV.key)
 }
 
@@ -810,8 +1029,12 @@ func (h *RequestHeader) del(key []byte) {
 // Multiple headers with the same key may be added with this function.
 // Use Set for setting a single header for the given key.
 func (h *ResponseHeader) Add(key, value string) {
-	k := getHeaderKeyBytes(&h.bufKV, key)
-	h.h = appendArg(h.h, b2s(k), value)
+	kb, vb, ok := h.checkInjection(s2b(key), s2b(value))
+	if !ok {
+		return
+	}
+	k := getHeaderKeyBytes(&h.bufKV, b2s(kb), h.disableNormalizing)
+	h.h = appendArg(h.h, b2s(k), b2s(vb))
 }
 
 // AddBytesK adds the given 'key: value' header.
@@ -842,7 +1065,7 @@ func (h *ResponseHeader) AddBytesKV(key, value []byte) {
 //
 // Use Add for setting multiple header values under the same key.
 func (h *ResponseHeader) Set(key, value string) {
-	initHeaderKV(&h.bufKV, key, value)
+	initHeaderKV(&h.bufKV, key, value, h.disableNormalizing)
 	h.SetCanonical(h.bufKV.key, h.bufKV.value)
 }
 
@@ -858,7 +1081,7 @@ func (h *ResponseHeader) SetBytesK(key []byte, value string) {
 //
 // Use AddBytesV for setting multiple header values under the same key.
 func (h *ResponseHeader) SetBytesV(key string, value []byte) {
-	k := getHeaderKeyBytes(&h.bufKV, key)
+	k := getHeaderKeyBytes(&h.bufKV, key, h.disableNormalizing)
 	h.SetCanonical(k, value)
 }
 
@@ -867,18 +1090,57 @@ func (h *ResponseHeader) SetBytesV(key string, value []byte) {
 // Use AddBytesKV for setting multiple header values under the same key.
 func (h *ResponseHeader) SetBytesKV(key, value []byte) {
 	h.bufKV.key = append(h.bufKV.key[:0], key...)
-	normalizeHeaderKey(h.bufKV.key)
+	normalizeHeaderKey(h.bufKV.key, h.disableNormalizing)
 	h.SetCanonical(h.bufKV.key, value)
 }
 
 // SetCanonical sets the given 'key: value' header assuming that
 // key is in canonical form.
+//
+// Keys and values containing CR or LF are handled according
+// to the policy set via SetInjectionPolicy.
 func (h *ResponseHeader) SetCanonical(key, value []byte) {
+	key, value, ok := h.checkInjection(key, value)
+	if !ok {
+		return
+	}
+	h.setCanonical(key, value)
+}
+
+// checkInjection applies the header injection policy to key and value
+// and records them if they have been sanitized or rejected.
+func (h *ResponseHeader) checkInjection(key, value []byte) ([]byte, []byte, bool) {
+	k, v, ok := checkHeaderInjection(h.injectionPolicy, key, value)
+	if !ok {
+		h.injectionRejected = true
+	}
+	if h.recordInjections && (!ok || len(k) != len(key) || len(v) != len(value)) {
+		h.injections = append(h.injections, HeaderInjection{
+			Key:      append([]byte(nil), key...),
+			Value:    append([]byte(nil), value...),
+			Rejected: !ok,
+		})
+	}
+	return k, v, ok
+}
+
+// SetUnsafe sets the given 'key: value' header without checking
+// it for CR and LF.
+//
+// It must be used only for trusted keys and values, since CR and LF
+// allow injecting arbitrary headers into the message.
+// See SetInjectionPolicy for details.
+func (h *ResponseHeader) SetUnsafe(key, value string) {
+	initHeaderKV(&h.bufKV, key, value, h.disableNormalizing)
+	h.setCanonical(h.bufKV.key, h.bufKV.value)
+}
+
+func (h *ResponseHeader) setCanonical(key, value []byte) {
 	switch string(key) {
 	case "Content-Type":
-		h.SetContentTypeBytes(value)
+		h.setContentTypeBytes(value)
 	case "Server":
-		h.SetServerBytes(value)
+		h.server = append(h.server[:0], value...)
 	case "Set-Cookie":
 		var kv *argsKV
 		h.cookies, kv = allocArg(h.cookies)
@@ -909,14 +1171,22 @@ func (h *ResponseHeader) SetCanonical(key, value []byte) {
 //
 // It is save re-using the cookie after the function returns.
 func (h *ResponseHeader) SetCookie(cookie *Cookie) {
-	h.cookies = setArgBytes(h.cookies, cookie.Key(), cookie.Cookie())
+	key, value, ok := h.checkInjection(cookie.Key(), cookie.Cookie())
+	if !ok {
+		return
+	}
+	h.cookies = setArgBytes(h.cookies, key, value)
 }
 
 // SetCookie sets 'key: value' cookies.
 func (h *RequestHeader) SetCookie(key, value string) {
+	kb, vb, ok := h.checkInjection(s2b(key), s2b(value))
+	if !ok {
+		return
+	}
 	h.parseRawHeaders()
 	h.collectCookies()
-	h.cookies = setArg(h.cookies, key, value)
+	h.cookies = setArgBytes(h.cookies, kb, vb)
 }
 
 // SetCookieBytesK sets 'key: value' cookies.
@@ -994,8 +1264,12 @@ func (h *RequestHeader) DelAllCookies() {
 // Multiple headers with the same key may be added with this function.
 // Use Set for setting a single header for the given key.
 func (h *RequestHeader) Add(key, value string) {
-	k := getHeaderKeyBytes(&h.bufKV, key)
-	h.h = appendArg(h.h, b2s(k), value)
+	kb, vb, ok := h.checkInjection(s2b(key), s2b(value))
+	if !ok {
+		return
+	}
+	k := getHeaderKeyBytes(&h.bufKV, b2s(kb), h.disableNormalizing)
+	h.h = appendArg(h.h, b2s(k), b2s(vb))
 }
 
 // AddBytesK adds the given 'key: value' header.
@@ -1026,7 +1300,7 @@ func (h *RequestHeader) AddBytesKV(key, value []byte) {
 //
 // Use Add for setting multiple header values under the same key.
 func (h *RequestHeader) Set(key, value string) {
-	initHeaderKV(&h.bufKV, key, value)
+	initHeaderKV(&h.bufKV, key, value, h.disableNormalizing)
 	h.SetCanonical(h.bufKV.key, h.bufKV.value)
 }
 
@@ -1042,7 +1316,7 @@ func (h *RequestHeader) SetBytesK(key []byte, value string) {
 //
 // Use AddBytesV for setting multiple header values under the same key.
 func (h *RequestHeader) SetBytesV(key string, value []byte) {
-	k := getHeaderKeyBytes(&h.bufKV, key)
+	k := getHeaderKeyBytes(&h.bufKV, key, h.disableNormalizing)
 	h.SetCanonical(k, value)
 }
 
@@ -1051,21 +1325,53 @@ func (h *RequestHeader) SetBytesV(key string, value []byte) {
 // Use AddBytesKV for setting multiple header values under the same key.
 func (h *RequestHeader) SetBytesKV(key, value []byte) {
 	h.bufKV.key = append(h.bufKV.key[:0], key...)
-	normalizeHeaderKey(h.bufKV.key)
+	normalizeHeaderKey(h.bufKV.key, h.disableNormalizing)
 	h.SetCanonical(h.bufKV.key, value)
 }
 
 // SetCanonical sets the given 'key: value' header assuming that
 // key is in canonical form.
+//
+// Keys and values containing CR or LF are handled according
+// to the policy set via SetInjectionPolicy.
 func (h *RequestHeader) SetCanonical(key, value []byte) {
+	key, value, ok := h.checkInjection(key, value)
+	if !ok {
+		return
+	}
+	h.setCanonical(key, value)
+}
+
+// checkInjection applies the header injection policy to key and value
+// and records the rejection.
+func (h *RequestHeader) checkInjection(key, value []byte) ([]byte, []byte, bool) {
+	k, v, ok := checkHeaderInjection(h.injectionPolicy, key, value)
+	if !ok {
+		h.injectionRejected = true
+	}
+	return k, v, ok
+}
+
+// SetUnsafe sets the given 'key: value' header without checking
+// it for CR and LF.
+//
+// It must be used only for trusted keys and values, since CR and LF
+// allow injecting arbitrary headers into the message.
+// See SetInjectionPolicy for details.
+func (h *RequestHeader) SetUnsafe(key, value string) {
+	initHeaderKV(&h.bufKV, key, value, h.disableNormalizing)
+	h.setCanonical(h.bufKV.key, h.bufKV.value)
+}
+
+func (h *RequestHeader) setCanonical(key, value []byte) {
 	h.parseRawHeaders()
 	switch string(key) {
 	case "Host":
-		h.SetHostBytes(value)
+		h.setHostBytes(value)
 	case "Content-Type":
-		h.SetContentTypeBytes(value)
+		h.setContentTypeBytes(value)
 	case "User-Agent":
-		h.SetUserAgentBytes(value)
+		h.setUserAgentBytes(value)
 	case "Cookie":
 		h.collectCookies()
 		h.cookies = parseRequestCookies(h.cookies, value)
@@ -1088,12 +1394,230 @@ func (h *RequestHeader) SetCanonical(key, value []byte) {
 	}
 }
 
+// SetTrailer sets the given 'key: value' trailer header.
+//
+// Trailers are sent after the body, so the response body is sent
+// with chunked transfer encoding if trailers are set. Trailers may be set
+// while the body stream is being written, e.g. from the callback passed
+// to SetBodyStreamWriter, if they are set before the callback returns.
+// Trailer names set before sending the header are announced
+// in Trailer header.
+//
+// Headers, which mustn't be sent in trailers such as Content-Length,
+// Transfer-Encoding or Host, are ignored.
+func (h *ResponseHeader) SetTrailer(key, value string) {
+	kb, vb, ok := h.checkInjection(s2b(key), s2b(value))
+	if !ok {
+		return
+	}
+	initHeaderKV(&h.bufKV, b2s(kb), b2s(vb), h.disableNormalizing)
+	h.trailers = setTrailer(h.trailers, h.bufKV.key, h.bufKV.value)
+}
+
+// SetTrailerBytesKV sets the given 'key: value' trailer header.
+//
+// See SetTrailer for details.
+func (h *ResponseHeader) SetTrailerBytesKV(key, value []byte) {
+	key, value, ok := h.checkInjection(key, value)
+	if !ok {
+		return
+	}
+	h.bufKV.key = append(h.bufKV.key[:0], key...)
+	normalizeHeaderKey(h.bufKV.key, h.disableNormalizing)
+	h.trailers = setTrailer(h.trailers, h.bufKV.key, value)
+}
+
+// PeekTrailer returns trailer header value for the given key.
+//
+// Trailers of the read response are available after reading the whole body.
+//
+// Returned value is valid until the next call to ResponseHeader.
+// Do not store references to returned value. Make copies instead.
+func (h *ResponseHeader) PeekTrailer(key string) []byte {
+	k := getHeaderKeyBytes(&h.bufKV, key, h.disableNormalizing)
+	return peekHeaderArg(h.trailers, k, h.disableNormalizing)
+}
+
+// DelTrailer deletes trailer header with the given key.
+func (h *ResponseHeader) DelTrailer(key string) {
+	k := getHeaderKeyBytes(&h.bufKV, key, h.disableNormalizing)
+	h.trailers = delAllArgsBytes(h.trailers, k)
+}
+
+// VisitAllTrailer calls f for each trailer header.
+//
+// f must not retain references to key and/or value after returning.
+// Copy key and/or value contents before returning if you need retaining them.
+func (h *ResponseHeader) VisitAllTrailer(f func(key, value []byte)) {
+	visitArgs(h.trailers, f)
+}
+
+func (h *ResponseHeader) appendParsedTrailer(key, value []byte) {
+	h.bufKV.key = append(h.bufKV.key[:0], key...)
+	normalizeHeaderKey(h.bufKV.key, h.disableNormalizing)
+	if !isForbiddenTrailer(h.bufKV.key) {
+		h.trailers = appendArgBytes(h.trailers, h.bufKV.key, value)
+	}
+}
+
+// SetTrailer sets the given 'key: value' trailer header.
+//
+// Trailers are sent after the body, so the request body is sent
+// with chunked transfer encoding if trailers are set. Trailer names
+// are announced in Trailer header.
+//
+// Headers, which mustn't be sent in trailers such as Content-Length,
+// Transfer-Encoding or Host, are ignored.
+func (h *RequestHeader) SetTrailer(key, value string) {
+	kb, vb, ok := h.checkInjection(s2b(key), s2b(value))
+	if !ok {
+		return
+	}
+	initHeaderKV(&h.bufKV, b2s(kb), b2s(vb), h.disableNormalizing)
+	h.trailers = setTrailer(h.trailers, h.bufKV.key, h.bufKV.value)
+}
+
+// SetTrailerBytesKV sets the given 'key: value' trailer header.
+//
+// See SetTrailer for details.
+func (h *RequestHeader) SetTrailerBytesKV(key, value []byte) {
+	key, value, ok := h.checkInjection(key, value)
+	if !ok {
+		return
+	}
+	h.bufKV.key = append(h.bufKV.key[:0], key...)
+	normalizeHeaderKey(h.bufKV.key, h.disableNormalizing)
+	h.trailers = setTrailer(h.trailers, h.bufKV.key, value)
+}
+
+// PeekTrailer returns trailer header value for the given key.
+//
+// Trailers of the read request are available after reading the whole body.
+//
+// Returned value is valid until the next call to RequestHeader.
+// Do not store references to returned value. Make copies instead.
+func (h *RequestHeader) PeekTrailer(key string) []byte {
+	k := getHeaderKeyBytes(&h.bufKV, key, h.disableNormalizing)
+	return peekHeaderArg(h.trailers, k, h.disableNormalizing)
+}
+
+// DelTrailer deletes trailer header with the given key.
+func (h *RequestHeader) DelTrailer(key string) {
+	k := getHeaderKeyBytes(&h.bufKV, key, h.disableNormalizing)
+	h.trailers = delAllArgsBytes(h.trailers, k)
+}
+
+// VisitAllTrailer calls f for each trailer header.
+//
+// f must not retain references to key and/or value after returning.
+// Copy key and/or value contents before returning if you need retaining them.
+func (h *RequestHeader) VisitAllTrailer(f func(key, value []byte)) {
+	visitArgs(h.trailers, f)
+}
+
+func (h *RequestHeader) appendParsedTrailer(key, value []byte) {
+	h.bufKV.key = append(h.bufKV.key[:0], key...)
+	normalizeHeaderKey(h.bufKV.key, h.disableNormalizing)
+	if !isForbiddenTrailer(h.bufKV.key) {
+		h.trailers = appendArgBytes(h.trailers, h.bufKV.key, value)
+	}
+}
+
+func (h *ResponseHeader) peekTrailers() []argsKV {
+	return h.trailers
+}
+
+func (h *RequestHeader) peekTrailers() []argsKV {
+	return h.trailers
+}
+
+func setTrailer(trailers []argsKV, key, value []byte) []argsKV {
+	if isForbiddenTrailer(key) {
+		return trailers
+	}
+	return setArgBytes(trailers, key, value)
+}
+
+// forbiddenTrailers contains headers, which mustn't be sent in trailers.
+//
+// See https://tools.ietf.org/html/rfc7230#section-4.1.2 .
+var forbiddenTrailers = [][]byte{
+	[]byte("Authorization"),
+	[]byte("Cache-Control"),
+	strConnection,
+	strContentEncoding,
+	strContentLength,
+	[]byte("Content-Range"),
+	strContentType,
+	strExpect,
+	strHost,
+	[]byte("Keep-Alive"),
+	[]byte("Max-Forwards"),
+	[]byte("Pragma"),
+	[]byte("Proxy-Authenticate"),
+	[]byte("Proxy-Authorization"),
+	[]byte("Range"),
+	[]byte("Te"),
+	strTrailer,
+	strTransferEncoding,
+	[]byte("Www-Authenticate"),
+}
+
+func isForbiddenTrailer(key []byte) bool {
+	for _, k := range forbiddenTrailers {
+		if caseInsensitiveEqual(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// trailerAppender receives trailers read after the chunked body.
+type trailerAppender interface {
+	appendParsedTrailer(key, value []byte)
+}
+
+// readTrailers reads trailer section after the last chunk from r
+// and passes the read trailers to t.
+//
+// Trailers are discarded if t is nil.
+func readTrailers(r *bufio.Reader, t trailerAppender) error {
+	// Limit the trailer section size by the reader buffer size
+	// like the header size is limited.
+	bytesLeft := r.Size()
+	for {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("cannot read trailer: %w", err)
+		}
+		bytesLeft -= len(line)
+		if bytesLeft < 0 {
+			return fmt.Errorf("cannot read trailer: %w", errSmallBuffer)
+		}
+		if isOnlyCRLF(line) {
+			return nil
+		}
+		var s headerScanner
+		s.b = line
+		s.keepKeys = true
+		if !s.next() || len(s.key) == 0 {
+			return fmt.Errorf("cannot parse trailer %q", line)
+		}
+		if t != nil {
+			t.appendParsedTrailer(s.key, s.value)
+		}
+	}
+}
+
 // Peek returns header value for the given key.
 //
 // Returned value is valid until the next call to ResponseHeader.
 // Do not store references to returned value. Make copies instead.
 func (h *ResponseHeader) Peek(key string) []byte {
-	k := getHeaderKeyBytes(&h.bufKV, key)
+	k := getHeaderKeyBytes(&h.bufKV, key, h.disableNormalizing)
 	return h.peek(k)
 }
 
@@ -1103,7 +1627,7 @@ func (h *ResponseHeader) Peek(key string) []byte {
 // Do not store references to returned value. Make copies instead.
 func (h *ResponseHeader) PeekBytes(key []byte) []byte {
 	h.bufKV.key = append(h.bufKV.key[:0], key...)
-	normalizeHeaderKey(h.bufKV.key)
+	normalizeHeaderKey(h.bufKV.key, h.disableNormalizing)
 	return h.peek(h.bufKV.key)
 }
 
@@ -1112,7 +1636,7 @@ func (h *ResponseHeader) PeekBytes(key []byte) []byte {
 // Returned value is valid until the next call to RequestHeader.
 // Do not store references to returned value. Make copies instead.
 func (h *RequestHeader) Peek(key string) []byte {
-	k := getHeaderKeyBytes(&h.bufKV, key)
+	k := getHeaderKeyBytes(&h.bufKV, key, h.disableNormalizing)
 	return h.peek(k)
 }
 
@@ -1122,7 +1646,7 @@ func (h *RequestHeader) Peek(key string) []byte {
 // Do not store references to returned value. Make copies instead.
 func (h *RequestHeader) PeekBytes(key []byte) []byte {
 	h.bufKV.key = append(h.bufKV.key[:0], key...)
-	normalizeHeaderKey(h.bufKV.key)
+	normalizeHeaderKey(h.bufKV.key, h.disableNormalizing)
 	return h.peek(h.bufKV.key)
 }
 
@@ -1136,11 +1660,11 @@ func (h *ResponseHeader) peek(key []byte) []byte {
 		if h.ConnectionClose() {
 			return strClose
 		}
-		return peekArgBytes(h.h, key)
+		return peekHeaderArg(h.h, key, h.disableNormalizing)
 	case "Content-Length":
 		return h.contentLengthBytes
 	default:
-		return peekArgBytes(h.h, key)
+		return peekHeaderArg(h.h, key, h.disableNormalizing)
 	}
 }
 
@@ -1157,14 +1681,31 @@ func (h *RequestHeader) peek(key []byte) []byte {
 		if h.ConnectionClose() {
 			return strClose
 		}
-		return peekArgBytes(h.h, key)
+		return peekHeaderArg(h.h, key, h.disableNormalizing)
 	case "Content-Length":
 		return h.contentLengthBytes
 	default:
-		return peekArgBytes(h.h, key)
+		return peekHeaderArg(h.h, key, h.disableNormalizing)
 	}
 }
 
+// peekHeaderArg returns the value of the header with the given key.
+//
+// Header names are compared case-insensitively if disableNormalizing is set,
+// since they are stored as is.
+func peekHeaderArg(h []argsKV, key []byte, disableNormalizing bool) []byte {
+	if !disableNormalizing {
+		return peekArgBytes(h, key)
+	}
+	for i, n := 0, len(h); i < n; i++ {
+		kv := &h[i]
+		if caseInsensitiveEqual(kv.key, key) {
+			return kv.value
+		}
+	}
+	return nil
+}
+
 // Cookie returns cookie for the given key.
 func (h *RequestHeader) Cookie(key string) []byte {
 	h.parseRawHeaders()
@@ -1221,17 +1762,18 @@ func (h *ResponseHeader) tryRead(r *bufio.Reader, n int) error {
 		// This is for go 1.6 bug. See https://github.com/golang/go/issues/14121 .
 		if err == bufio.ErrBufferFull {
 			return &ErrSmallBuffer{
-				error: fmt.Errorf("error when reading response headers: %s", errSmallBuffer),
+				error: fmt.Errorf("error when reading response headers: %w", errSmallBuffer),
 			}
 		}
 
-		return fmt.Errorf("error when reading response headers: %s", err)
+		return fmt.Errorf("error when reading response headers: %w", err)
 	}
 	b = mustPeekBuffered(r)
 	headersLen, errParse := h.parse(b)
 	if errParse != nil {
 		return headerError("response", err, errParse, b)
 	}
+	h.readSize = headersLen
 	mustDiscard(r, headersLen)
 	return nil
 }
@@ -1259,7 +1801,7 @@ func headerError(typ string, err, errParse error, b []byte) error {
 }
 
 func headerErrorMsg(typ string, err error, b []byte) error {
-	return fmt.Errorf("error when reading %s headers: %s. Buffer size=%d, contents: %s", typ, err, len(b), bufferSnippet(b))
+	return fmt.Errorf("error when reading %s headers: %w. Buffer size=%d, contents: %s", typ, err, len(b), bufferSnippet(b))
 }
 
 // Read reads request header from r.
@@ -1292,17 +1834,21 @@ func (h *RequestHeader) tryRead(r *bufio.Reader, n int) error {
 		// This is for go 1.6 bug. See https://github.com/golang/go/issues/14121 .
 		if err == bufio.ErrBufferFull {
 			return &ErrSmallBuffer{
-				error: fmt.Errorf("error when reading request headers: %s", errSmallBuffer),
+				error: fmt.Errorf("error when reading request headers: %w", errSmallBuffer),
 			}
 		}
 
-		return fmt.Errorf("error when reading request headers: %s", err)
+		return fmt.Errorf("error when reading request headers: %w", err)
 	}
 	b = mustPeekBuffered(r)
 	headersLen, errParse := h.parse(b)
 	if errParse != nil {
 		return headerError("request", err, errParse, b)
 	}
+	if h.rawRecord != nil {
+		*h.rawRecord = append(*h.rawRecord, b[:headersLen]...)
+	}
+	h.readSize = headersLen
 	mustDiscard(r, headersLen)
 	return nil
 }
@@ -1349,7 +1895,13 @@ func refreshServerDate() {
 }
 
 // Write writes response header to w.
+//
+// ErrHeaderInjection is returned if a header value has been rejected
+// due to HeaderInjectionError policy.
 func (h *ResponseHeader) Write(w *bufio.Writer) error {
+	if h.injectionRejected {
+		return ErrHeaderInjection
+	}
 	_, err := w.Write(h.Header())
 	return err
 }
@@ -1358,6 +1910,9 @@ func (h *ResponseHeader) Write(w *bufio.Writer) error {
 //
 // WriteTo implements io.WriterTo interface.
 func (h *ResponseHeader) WriteTo(w io.Writer) (int64, error) {
+	if h.injectionRejected {
+		return 0, ErrHeaderInjection
+	}
 	n, err := w.Write(h.Header())
 	return int64(n), err
 }
@@ -1417,6 +1972,10 @@ func (h *ResponseHeader) AppendBytes(dst []byte) []byte {
 		}
 	}
 
+	if len(h.trailers) > 0 && h.ContentLength() == -1 {
+		dst = appendTrailerHeader(dst, h.trailers)
+	}
+
 	if h.ConnectionClose() {
 		dst = appendHeaderLine(dst, strConnection, strClose)
 	}
@@ -1425,7 +1984,13 @@ func (h *ResponseHeader) AppendBytes(dst []byte) []byte {
 }
 
 // Write writes request header to w.
+//
+// ErrHeaderInjection is returned if a header value has been rejected
+// due to HeaderInjectionError policy.
 func (h *RequestHeader) Write(w *bufio.Writer) error {
+	if h.injectionRejected {
+		return ErrHeaderInjection
+	}
 	_, err := w.Write(h.Header())
 	return err
 }
@@ -1434,6 +1999,9 @@ func (h *RequestHeader) Write(w *bufio.Writer) error {
 //
 // WriteTo implements io.WriterTo interface.
 func (h *RequestHeader) WriteTo(w io.Writer) (int64, error) {
+	if h.injectionRejected {
+		return 0, ErrHeaderInjection
+	}
 	n, err := w.Write(h.Header())
 	return int64(n), err
 }
@@ -1506,6 +2074,10 @@ func (h *RequestHeader) AppendBytes(dst []byte) []byte {
 		dst = append(dst, strCRLF...)
 	}
 
+	if len(h.trailers) > 0 && h.ContentLength() == -1 {
+		dst = appendTrailerHeader(dst, h.trailers)
+	}
+
 	if h.ConnectionClose() {
 		dst = appendHeaderLine(dst, strConnection, strClose)
 	}
@@ -1513,6 +2085,20 @@ func (h *RequestHeader) AppendBytes(dst []byte) []byte {
 	return append(dst, strCRLF...)
 }
 
+// appendTrailerHeader appends Trailer header announcing the names
+// of the given trailers to dst.
+func appendTrailerHeader(dst []byte, trailers []argsKV) []byte {
+	dst = append(dst, strTrailer...)
+	dst = append(dst, strColonSpace...)
+	for i := range trailers {
+		if i > 0 {
+			dst = append(dst, ", "...)
+		}
+		dst = append(dst, trailers[i].key...)
+	}
+	return append(dst, strCRLF...)
+}
+
 func appendHeaderLine(dst, key, value []byte) []byte {
 	dst = append(dst, key...)
 	dst = append(dst, strColonSpace...)
@@ -1543,7 +2129,9 @@ func (h *RequestHeader) parse(buf []byte) (int, error) {
 	}
 
 	var n int
-	if !h.noBody() || h.noHTTP11 {
+	if !h.noBody() || h.noHTTP11 || h.strictParsing {
+		// Headers are parsed lazily for requests without body,
+		// so parse them now in strict mode for detecting malformed headers.
 		n, err = h.parseHeaders(buf[m:])
 		if err != nil {
 			return 0, err
@@ -1561,66 +2149,33 @@ func (h *RequestHeader) parse(buf []byte) (int, error) {
 }
 
 func (h *ResponseHeader) parseFirstLine(buf []byte) (int, error) {
-	bNext := buf
-	var b []byte
-	var err error
-	for len(b) == 0 {
-		if b, bNext, err = nextLine(bNext); err != nil {
+	statusCode, isHTTP11, n, err := ParseStatusLine(buf)
+	if err != nil {
+		if err == errNeedMore {
 			return 0, err
 		}
+		return 0, fmt.Errorf("%w. Response %q", err, buf)
 	}
-
-	// parse protocol
-	n := bytes.IndexByte(b, ' ')
-	if n < 0 {
-		return 0, fmt.Errorf("cannot find whitespace in the first line of response %q", buf)
-	}
-	h.noHTTP11 = !bytes.Equal(b[:n], strHTTP11)
-	b = b[n+1:]
-
-	// parse status code
-	h.statusCode, n, err = parseUintBuf(b)
-	if err != nil {
-		return 0, fmt.Errorf("cannot parse response status code: %s. Response %q", err, buf)
-	}
-	if len(b) > n && b[n] != ' ' {
-		return 0, fmt.Errorf("unexpected char at the end of status code. Response %q", buf)
-	}
-
-	return len(buf) - len(bNext), nil
+	h.noHTTP11 = !isHTTP11
+	h.statusCode = statusCode
+	return n, nil
 }
 
 func (h *RequestHeader) parseFirstLine(buf []byte) (int, error) {
-	bNext := buf
-	var b []byte
-	var err error
-	for len(b) == 0 {
-		if b, bNext, err = nextLine(bNext); err != nil {
+	method, requestURI, isHTTP11, n, err := ParseRequestLine(buf)
+	if err != nil {
+		if err == errNeedMore {
 			return 0, err
 		}
+		return 0, fmt.Errorf("%w in %q", err, buf)
 	}
-
-	// parse method
-	n := bytes.IndexByte(b, ' ')
-	if n <= 0 {
-		return 0, fmt.Errorf("cannot find http request method in %q", buf)
-	}
-	h.method = append(h.method[:0], b[:n]...)
-	b = b[n+1:]
-
-	// parse requestURI
-	n = bytes.LastIndexByte(b, ' ')
-	if n < 0 {
-		h.noHTTP11 = true
-		n = len(b)
-	} else if n == 0 {
-		return 0, fmt.Errorf("requestURI cannot be empty in %q", buf)
-	} else if !bytes.Equal(b[n+1:], strHTTP11) {
-		h.noHTTP11 = true
+	if bytes.Equal(requestURI, strAsterisk) && !bytes.Equal(method, strOptions) {
+		return 0, fmt.Errorf("%w in %q", errAsteriskFormNotOptions, buf)
 	}
-	h.requestURI = append(h.requestURI[:0], b[:n]...)
-
-	return len(buf) - len(bNext), nil
+	h.method = append(h.method[:0], method...)
+	h.requestURI = append(h.requestURI[:0], requestURI...)
+	h.noHTTP11 = !isHTTP11
+	return n, nil
 }
 
 func peekRawHeader(buf, key []byte) []byte {
@@ -1655,13 +2210,27 @@ func peekRawHeader(buf, key []byte) []byte {
 }
 
 func readRawHeaders(dst, buf []byte) ([]byte, int, error) {
+	n, err := rawHeadersLen(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	if n <= 2 {
+		// empty headers
+		return dst, n, nil
+	}
+	return append(dst, buf[:n]...), n, nil
+}
+
+// rawHeadersLen returns the length of the header block in buf
+// including the trailing empty line.
+func rawHeadersLen(buf []byte) (int, error) {
 	n := bytes.IndexByte(buf, '\n')
 	if n < 0 {
-		return nil, 0, errNeedMore
+		return 0, errNeedMore
 	}
 	if (n == 1 && buf[0] == '\r') || n == 0 {
 		// empty headers
-		return dst, n + 1, nil
+		return n + 1, nil
 	}
 
 	n++
@@ -1671,13 +2240,12 @@ func readRawHeaders(dst, buf []byte) ([]byte, int, error) {
 		b = b[m:]
 		m = bytes.IndexByte(b, '\n')
 		if m < 0 {
-			return nil, 0, errNeedMore
+			return 0, errNeedMore
 		}
 		m++
 		n += m
 		if (m == 2 && b[0] == '\r') || m == 1 {
-			dst = append(dst, buf[:n]...)
-			return dst, n, nil
+			return n, nil
 		}
 	}
 }
@@ -1688,12 +2256,17 @@ func (h *ResponseHeader) parseHeaders(buf []byte) (int, error) {
 
 	var s headerScanner
 	s.b = buf
+	s.disableNormalizing = h.disableNormalizing
 	var err error
 	var kv *argsKV
 	for s.next() {
 		switch string(s.key) {
 		case "Content-Type":
-			h.contentType = append(h.contentType[:0], s.value...)
+			if h.internValues {
+				setHeaderValueInterned(&h.contentType, &h.contentTypeBuf, s.value, &contentTypeInterner)
+			} else {
+				setHeaderValue(&h.contentType, &h.contentTypeBuf, s.value)
+			}
 		case "Server":
 			h.server = append(h.server[:0], s.value...)
 		case "Content-Length":
@@ -1714,7 +2287,7 @@ func (h *ResponseHeader) parseHeaders(buf []byte) (int, error) {
 			kv.key = getCookieKey(kv.key, s.value)
 			kv.value = append(kv.value[:0], s.value...)
 		case "Connection":
-			if bytes.Equal(s.value, strClose) {
+			if caseInsensitiveEqual(s.value, strClose) {
 				h.connectionClose = true
 			} else {
 				h.connectionClose = false
@@ -1739,26 +2312,44 @@ func (h *ResponseHeader) parseHeaders(buf []byte) (int, error) {
 	if h.noHTTP11 && !h.connectionClose {
 		// close connection for non-http/1.1 response unless 'Connection: keep-alive' is set.
 		v := peekArgBytes(h.h, strConnection)
-		h.connectionClose = !hasHeaderValue(v, strKeepAlive) && !hasHeaderValue(v, strKeepAliveCamelCase)
+		h.connectionClose = !hasHeaderValue(v, strKeepAlive)
 	}
 
 	return len(buf) - len(s.b), nil
 }
 
+func (h *RequestHeader) appendParsedArg(key, value []byte) {
+	if h.arena != nil {
+		h.h = appendArenaArg(h.h, h.arena, key, value)
+	} else {
+		h.h = appendArgBytes(h.h, key, value)
+	}
+}
+
 func (h *RequestHeader) parseHeaders(buf []byte) (int, error) {
 	h.contentLength = -2
 
 	var s headerScanner
 	s.b = buf
+	s.disableNormalizing = h.disableNormalizing
+	s.strict = h.strictParsing
 	var err error
 	for s.next() {
 		switch string(s.key) {
 		case "Host":
 			h.host = append(h.host[:0], s.value...)
 		case "User-Agent":
-			h.userAgent = append(h.userAgent[:0], s.value...)
+			if h.internValues {
+				setHeaderValueInterned(&h.userAgent, &h.userAgentBuf, s.value, &userAgentInterner)
+			} else {
+				setHeaderValue(&h.userAgent, &h.userAgentBuf, s.value)
+			}
 		case "Content-Type":
-			h.contentType = append(h.contentType[:0], s.value...)
+			if h.internValues {
+				setHeaderValueInterned(&h.contentType, &h.contentTypeBuf, s.value, &contentTypeInterner)
+			} else {
+				setHeaderValue(&h.contentType, &h.contentTypeBuf, s.value)
+			}
 		case "Content-Length":
 			if h.contentLength != -1 {
 				if h.contentLength, err = parseContentLength(s.value); err != nil {
@@ -1773,14 +2364,14 @@ func (h *RequestHeader) parseHeaders(buf []byte) (int, error) {
 				h.h = setArgBytes(h.h, strTransferEncoding, strChunked)
 			}
 		case "Connection":
-			if bytes.Equal(s.value, strClose) {
+			if caseInsensitiveEqual(s.value, strClose) {
 				h.connectionClose = true
 			} else {
 				h.connectionClose = false
-				h.h = appendArgBytes(h.h, s.key, s.value)
+				h.appendParsedArg(s.key, s.value)
 			}
 		default:
-			h.h = appendArgBytes(h.h, s.key, s.value)
+			h.appendParsedArg(s.key, s.value)
 		}
 	}
 	if s.err != nil {
@@ -1798,7 +2389,7 @@ func (h *RequestHeader) parseHeaders(buf []byte) (int, error) {
 	if h.noHTTP11 && !h.connectionClose {
 		// close connection for non-http/1.1 request unless 'Connection: keep-alive' is set.
 		v := peekArgBytes(h.h, strConnection)
-		h.connectionClose = !hasHeaderValue(v, strKeepAlive) && !hasHeaderValue(v, strKeepAliveCamelCase)
+		h.connectionClose = !hasHeaderValue(v, strKeepAlive)
 	}
 
 	return len(buf) - len(s.b), nil
@@ -1851,6 +2442,13 @@ type headerScanner struct {
 	key   []byte
 	value []byte
 	err   error
+
+	// keepKeys leaves header names intact if set.
+	keepKeys           bool
+	disableNormalizing bool
+
+	// strict rejects malformed headers instead of tolerating them.
+	strict bool
 }
 
 func (s *headerScanner) next() bool {
@@ -1863,13 +2461,28 @@ func (s *headerScanner) next() bool {
 		s.b = s.b[1:]
 		return false
 	}
+	if s.strict && bLen >= 1 && (s.b[0] == ' ' || s.b[0] == '\t') {
+		s.err = errObsFoldHeader
+		return false
+	}
 	n := bytes.IndexByte(s.b, ':')
 	if n < 0 {
+		if s.strict && bytes.IndexByte(s.b, '\n') >= 0 {
+			// The line is complete, but it has no colon.
+			s.err = errInvalidHeaderName
+			return false
+		}
 		s.err = errNeedMore
 		return false
 	}
 	s.key = s.b[:n]
-	normalizeHeaderKey(s.key)
+	if s.strict && !isValidHeaderName(s.key) {
+		s.err = errInvalidHeaderName
+		return false
+	}
+	if !s.keepKeys {
+		normalizeHeaderKey(s.key, s.disableNormalizing)
+	}
 	n++
 	for len(s.b) > n && s.b[n] == ' ' {
 		n++
@@ -1890,6 +2503,39 @@ func (s *headerScanner) next() bool {
 		n--
 	}
 	s.value = s.value[:n]
+	if s.strict && !isValidHeaderValue(s.value) {
+		s.err = errInvalidHeaderValue
+		return false
+	}
+	return true
+}
+
+// isValidHeaderName returns true if b is non-empty and consists of
+// token chars. See https://tools.ietf.org/html/rfc7230#section-3.2.6 .
+func isValidHeaderName(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '!', c == '#', c == '$', c == '%', c == '&', c == '\'', c == '*', c == '+',
+			c == '-', c == '.', c == '^', c == '_', c == '`', c == '|', c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isValidHeaderValue returns true if b contains no control chars
+// except for horizontal tab.
+func isValidHeaderValue(b []byte) bool {
+	for _, c := range b {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
 	return true
 }
 
@@ -1928,7 +2574,7 @@ func hasHeaderValue(s, value []byte) bool {
 	var vs headerValueScanner
 	vs.b = s
 	for vs.next() {
-		if bytes.Equal(vs.value, value) {
+		if caseInsensitiveEqual(vs.value, value) {
 			return true
 		}
 	}
@@ -1947,18 +2593,46 @@ func nextLine(b []byte) ([]byte, []byte, error) {
 	return b[:n], b[nNext+1:], nil
 }
 
-func initHeaderKV(kv *argsKV, key, value string) {
-	kv.key = getHeaderKeyBytes(kv, key)
+func initHeaderKV(kv *argsKV, key, value string, disableNormalizing bool) {
+	kv.key = getHeaderKeyBytes(kv, key, disableNormalizing)
 	kv.value = append(kv.value[:0], value...)
 }
 
-func getHeaderKeyBytes(kv *argsKV, key string) []byte {
+func getHeaderKeyBytes(kv *argsKV, key string, disableNormalizing bool) []byte {
 	kv.key = append(kv.key[:0], key...)
-	normalizeHeaderKey(kv.key)
+	normalizeHeaderKey(kv.key, disableNormalizing)
 	return kv.key
 }
 
-func normalizeHeaderKey(b []byte) {
+// normalizeHeaderKey normalizes header name b in place.
+//
+// Only names of specially handled headers are normalized
+// if disableNormalizing is set.
+func normalizeHeaderKey(b []byte, disableNormalizing bool) {
+	n := len(b)
+	if n == 0 {
+		return
+	}
+	if disableNormalizing {
+		for _, special := range specialHeaderNames {
+			if caseInsensitiveEqual(b, special) {
+				copy(b, special)
+				return
+			}
+		}
+		return
+	}
+
+	normalizeHeaderKeyDefault(b)
+	if m := getHeaderNames(); m != nil {
+		// The lookup is skipped until RegisterHeaderName is called.
+		if name, ok := m[string(b)]; ok {
+			copy(b, name)
+		}
+	}
+}
+
+func normalizeHeaderKeyDefault(b []byte) {
 	n := len(b)
 	if n == 0 {
 		return
@@ -1978,6 +2652,75 @@ func normalizeHeaderKey(b []byte) {
 	}
 }
 
+// RegisterHeaderName registers the given header name as well-known.
+//
+// Header keys matching the registered name case-insensitively are normalized
+// to the registered name as is, i.e. 'X-TENANT-ID' becomes 'X-Tenant-ID'
+// after RegisterHeaderName("X-Tenant-ID").
+//
+// Header names handled specially by RequestHeader and ResponseHeader
+// such as Content-Type, Content-Length or Host cannot be registered
+// with different case.
+//
+// RegisterHeaderName is intended to be called at init time for frequently
+// used custom headers such as 'X-Tenant-ID' or 'traceparent'.
+func RegisterHeaderName(name string) error {
+	if len(name) == 0 {
+		return errors.New("header name cannot be empty")
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c == ':' || c >= 0x7f {
+			return fmt.Errorf("invalid char %q in header name %q", c, name)
+		}
+	}
+	key := make([]byte, len(name))
+	copy(key, name)
+	normalizeHeaderKeyDefault(key)
+	for _, special := range specialHeaderNames {
+		if bytes.Equal(key, special) && name != string(special) {
+			return fmt.Errorf("cannot register header name %q, since %q is handled specially", name, special)
+		}
+	}
+
+	headerNamesLock.Lock()
+	mOld := getHeaderNames()
+	m := make(map[string][]byte, len(mOld)+1)
+	for k, v := range mOld {
+		m[k] = v
+	}
+	m[string(key)] = []byte(name)
+	headerNames.Store(m)
+	headerNamesLock.Unlock()
+	return nil
+}
+
+var specialHeaderNames = [][]byte{
+	strContentType,
+	strContentLength,
+	strServer,
+	strSetCookie,
+	strCookie,
+	strConnection,
+	strTransferEncoding,
+	strDate,
+	strHost,
+	strUserAgent,
+}
+
+var (
+	headerNamesLock sync.Mutex
+	headerNames     atomic.Value
+)
+
+func getHeaderNames() map[string][]byte {
+	v := headerNames.Load()
+	if v == nil {
+		return nil
+	}
+	return v.(map[string][]byte)
+}
+
 // AppendNormalizedHeaderKey appends normalized header key (name) to dst
 // and returns the resulting dst.
 //
@@ -1988,9 +2731,12 @@ func normalizeHeaderKey(b []byte) {
 //   * coNTENT-TYPe -> Content-Type
 //   * HOST -> Host
 //   * foo-bar-baz -> Foo-Bar-Baz
+//
+// Names registered via RegisterHeaderName are normalized to the registered
+// form.
 func AppendNormalizedHeaderKey(dst []byte, key string) []byte {
 	dst = append(dst, key...)
-	normalizeHeaderKey(dst[len(dst)-len(key):])
+	normalizeHeaderKey(dst[len(dst)-len(key):], false)
 	return dst
 }
 
@@ -2004,6 +2750,9 @@ func AppendNormalizedHeaderKey(dst []byte, key string) []byte {
 //   * coNTENT-TYPe -> Content-Type
 //   * HOST -> Host
 //   * foo-bar-baz -> Foo-Bar-Baz
+//
+// Names registered via RegisterHeaderName are normalized to the registered
+// form.
 func AppendNormalizedHeaderKeyBytes(dst, key []byte) []byte {
 	return AppendNormalizedHeaderKey(dst, b2s(key))
 }
@@ -2011,6 +2760,12 @@ func AppendNormalizedHeaderKeyBytes(dst, key []byte) []byte {
 var (
 	errNeedMore    = errors.New("need more data: cannot find trailing lf")
 	errSmallBuffer = errors.New("small read buffer. Increase ReadBufferSize")
+
+	errObsFoldHeader      = errors.New("obsolete line folding in header isn't allowed")
+	errInvalidHeaderName  = errors.New("invalid header name")
+	errInvalidHeaderValue = errors.New("invalid char in header value")
+
+	errAsteriskFormNotOptions = errors.New("asterisk-form request target is allowed only for OPTIONS requests")
 )
 
 // ErrSmallBuffer is returned when the provided buffer size is too small
@@ -2035,3 +2790,204 @@ func mustDiscard(r *bufio.Reader, n int) {
 		panic(fmt.Sprintf("bufio.Reader.Discard(%d) failed: %s", n, err))
 	}
 }
+
+// setHeaderValue copies v to buf and sets dst to buf.
+//
+// dst may refer to interned value, so it mustn't be modified in place.
+func setHeaderValue(dst, buf *[]byte, v []byte) {
+	*buf = append((*buf)[:0], v...)
+	*dst = *buf
+}
+
+// setHeaderValueInterned sets dst to interned v if v is interned by vi.
+//
+// Otherwise v is copied to buf.
+func setHeaderValueInterned(dst, buf *[]byte, v []byte, vi *headerValueInterner) {
+	if iv := vi.intern(v); iv != nil {
+		*dst = iv
+		return
+	}
+	setHeaderValue(dst, buf, v)
+}
+
+const (
+	// maxInternedValueLen is the maximum length of interned header value.
+	maxInternedValueLen = 256
+
+	// maxInternedValues is the maximum number of interned values
+	// per headerValueInterner. Interned values are reset when the limit
+	// is reached.
+	maxInternedValues = 4096
+
+	// internPendingValues is the number of new values, which are
+	// collected before becoming visible for interning.
+	internPendingValues = 64
+)
+
+var (
+	contentTypeInterner headerValueInterner
+	userAgentInterner   headerValueInterner
+)
+
+// headerValueInterner holds shared copies of frequently seen header values.
+//
+// Lookups are lock-free. New values become visible for lookups in batches,
+// so the lock is taken only for values, which aren't interned yet.
+type headerValueInterner struct {
+	m atomic.Value
+
+	lock    sync.Mutex
+	pending map[string][]byte
+}
+
+// intern returns interned copy of b.
+//
+// nil is returned if b isn't interned yet. The returned value mustn't
+// be modified.
+func (vi *headerValueInterner) intern(b []byte) []byte {
+	if len(b) == 0 || len(b) > maxInternedValueLen {
+		return nil
+	}
+	m, _ := vi.m.Load().(map[string][]byte)
+	if v, ok := m[string(b)]; ok {
+		if bytes.Equal(v, b) {
+			return v
+		}
+		// The interned value has been modified in place by the caller,
+		// so do not share it anymore.
+		return nil
+	}
+
+	vi.lock.Lock()
+	if vi.pending == nil {
+		vi.pending = make(map[string][]byte)
+	}
+	if _, ok := vi.pending[string(b)]; !ok {
+		v := append([]byte(nil), b...)
+		vi.pending[string(v)] = v[:len(v):len(v)]
+		if len(vi.pending) >= internPendingValues {
+			m, _ = vi.m.Load().(map[string][]byte)
+			vi.flushPendingLocked(m)
+		}
+	}
+	vi.lock.Unlock()
+	return nil
+}
+
+func (vi *headerValueInterner) flushPendingLocked(m map[string][]byte) {
+	if len(m)+len(vi.pending) > maxInternedValues {
+		// Drop the old values, since they may be no longer frequent.
+		m = nil
+	}
+	mNew := make(map[string][]byte, len(m)+len(vi.pending))
+	for k, v := range m {
+		mNew[k] = v
+	}
+	for k, v := range vi.pending {
+		mNew[k] = v
+	}
+	vi.m.Store(mNew)
+	vi.pending = nil
+}
+
+// HeaderInjectionPolicy determines how header setters handle keys
+// and values containing CR or LF, which could be used for injecting
+// arbitrary headers or splitting responses.
+//
+// See Server.HeaderInjectionPolicy, Client.HeaderInjectionPolicy
+// and ResponseHeader.SetInjectionPolicy.
+type HeaderInjectionPolicy int32
+
+const (
+	// HeaderInjectionStrip removes CR and LF from keys and values.
+	HeaderInjectionStrip HeaderInjectionPolicy = iota
+
+	// HeaderInjectionError rejects keys and values with CR or LF.
+	// Writing the header returns ErrHeaderInjection after the rejection.
+	HeaderInjectionError
+
+	// HeaderInjectionPanic panics on keys and values with CR or LF.
+	// It is useful for catching bugs during development.
+	HeaderInjectionPanic
+
+	// HeaderInjectionAllow stores keys and values as is.
+	HeaderInjectionAllow
+)
+
+// HeaderInjection describes a header key and value with CR or LF,
+// which have been sanitized or rejected according to HeaderInjectionPolicy.
+//
+// See Server.HeaderInjectionHandler for details.
+type HeaderInjection struct {
+	// Key and Value contain the original key and value with CR or LF.
+	Key   []byte
+	Value []byte
+
+	// Rejected is set if the header has been rejected
+	// due to HeaderInjectionError policy. Otherwise CR and LF
+	// have been stripped.
+	Rejected bool
+}
+
+// ErrHeaderInjection is returned when writing the header with rejected
+// values. See HeaderInjectionError.
+var ErrHeaderInjection = errors.New("header key or value with CR or LF has been rejected")
+
+func hasCRLF(b []byte) bool {
+	return bytes.IndexByte(b, '\r') >= 0 || bytes.IndexByte(b, '\n') >= 0
+}
+
+// checkHeaderInjection applies the header injection policy p to key
+// and value.
+//
+// It returns false if key and value must be rejected. The returned key
+// and value are copies if CR or LF have been stripped.
+func checkHeaderInjection(p HeaderInjectionPolicy, key, value []byte) ([]byte, []byte, bool) {
+	keyHasCRLF := hasCRLF(key)
+	valueHasCRLF := hasCRLF(value)
+	if !keyHasCRLF && !valueHasCRLF {
+		return key, value, true
+	}
+	switch p {
+	case HeaderInjectionAllow:
+		return key, value, true
+	case HeaderInjectionError:
+		return key, value, false
+	case HeaderInjectionPanic:
+		panic(fmt.Sprintf("BUG: header %q: %q contains CR or LF", key, value))
+	}
+	if keyHasCRLF {
+		key = stripCRLF(append([]byte(nil), key...))
+	}
+	if valueHasCRLF {
+		value = stripCRLF(append([]byte(nil), value...))
+	}
+	return key, value, true
+}
+
+// stripURICRLF removes CR and LF from b in place according
+// to the header injection policy p.
+func stripURICRLF(p HeaderInjectionPolicy, b []byte) []byte {
+	if !hasCRLF(b) {
+		return b
+	}
+	switch p {
+	case HeaderInjectionAllow:
+		return b
+	case HeaderInjectionPanic:
+		panic(fmt.Sprintf("BUG: uri part %q contains CR or LF", b))
+	}
+	return stripCRLF(b)
+}
+
+// stripCRLF removes CR and LF from b in place.
+func stripCRLF(b []byte) []byte {
+	n := 0
+	for _, c := range b {
+		if c != '\r' && c != '\n' {
+			b[n] = c
+			n++
+		}
+	}
+	return b[:n]
+}
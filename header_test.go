@@ -3,6 +3,7 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -60,6 +61,208 @@ func TestResponseHeaderEmptyValueFromString(t *testing.T) {
 	}
 }
 
+func TestRegisterHeaderName(t *testing.T) {
+	if err := RegisterHeaderName("X-Registered-ID"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := RegisterHeaderName("x-lowercase-registered"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, name := range []string{"", "foo bar", "foo:bar", "content-type"} {
+		if err := RegisterHeaderName(name); err == nil {
+			t.Fatalf("expecting non-nil error for %q", name)
+		}
+	}
+	if err := RegisterHeaderName("Content-Type"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	testRegisteredHeaderName(t, "x-registered-id", "X-Registered-ID")
+	testRegisteredHeaderName(t, "X-REGISTERED-ID", "X-Registered-ID")
+	testRegisteredHeaderName(t, "X-Registered-ID", "X-Registered-ID")
+	testRegisteredHeaderName(t, "X-Lowercase-Registered", "x-lowercase-registered")
+	testRegisteredHeaderName(t, "content-TYPE", "Content-Type")
+	testRegisteredHeaderName(t, "foo-bar", "Foo-Bar")
+
+	var h RequestHeader
+	s := "GET / HTTP/1.1\r\nHost: aaa\r\nX-REGISTERED-ID: 123\r\nX-Lowercase-Registered: abc\r\n\r\n"
+	if err := h.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var keys []string
+	h.VisitAll(func(k, v []byte) {
+		keys = append(keys, string(k))
+	})
+	expectedKeys := "Host,X-Registered-ID,x-lowercase-registered"
+	if strings.Join(keys, ",") != expectedKeys {
+		t.Fatalf("unexpected keys %q. Expecting %q", keys, expectedKeys)
+	}
+	if v := h.Peek("x-registered-id"); string(v) != "123" {
+		t.Fatalf("unexpected value %q. Expecting %q", v, "123")
+	}
+}
+
+func testRegisteredHeaderName(t *testing.T, key, expectedKey string) {
+	k := AppendNormalizedHeaderKey(nil, key)
+	if string(k) != expectedKey {
+		t.Fatalf("unexpected normalized key %q for %q. Expecting %q", k, key, expectedKey)
+	}
+}
+
+func TestRequestHeaderInternValues(t *testing.T) {
+	var h RequestHeader
+	h.SetInternValues(true)
+
+	// Read enough distinct values for making them visible for interning.
+	for i := 0; i < 2*internPendingValues; i++ {
+		ct := fmt.Sprintf("application/x-interned-%d", i)
+		ua := fmt.Sprintf("interned-agent/%d", i)
+		testRequestHeaderInternValues(t, &h, ct, ua)
+	}
+	for i := 0; i < 2*internPendingValues; i++ {
+		ct := fmt.Sprintf("application/x-interned-%d", i)
+		ua := fmt.Sprintf("interned-agent/%d", i)
+		testRequestHeaderInternValues(t, &h, ct, ua)
+	}
+
+	// Make sure setters don't modify the interned value.
+	testRequestHeaderInternValues(t, &h, "application/x-interned-0", "interned-agent/0")
+	h.SetContentType("foo/bar")
+	h.SetUserAgent("foobar")
+	if iv := contentTypeInterner.intern([]byte("application/x-interned-0")); string(iv) != "application/x-interned-0" {
+		t.Fatalf("unexpected interned value %q. Expecting %q", iv, "application/x-interned-0")
+	}
+	if iv := userAgentInterner.intern([]byte("interned-agent/0")); string(iv) != "interned-agent/0" {
+		t.Fatalf("unexpected interned value %q. Expecting %q", iv, "interned-agent/0")
+	}
+
+	h.Reset()
+	if !h.internValues {
+		t.Fatalf("Reset mustn't clear SetInternValues setting")
+	}
+}
+
+func testRequestHeaderInternValues(t *testing.T, h *RequestHeader, contentType, userAgent string) {
+	s := fmt.Sprintf("POST / HTTP/1.1\r\nHost: aaa\r\nContent-Type: %s\r\nUser-Agent: %s\r\nContent-Length: 0\r\n\r\n", contentType, userAgent)
+	if err := h.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(h.ContentType()) != contentType {
+		t.Fatalf("unexpected content-type %q. Expecting %q", h.ContentType(), contentType)
+	}
+	if string(h.UserAgent()) != userAgent {
+		t.Fatalf("unexpected user-agent %q. Expecting %q", h.UserAgent(), userAgent)
+	}
+}
+
+func TestResponseHeaderInternValues(t *testing.T) {
+	var h ResponseHeader
+	h.SetInternValues(true)
+
+	for i := 0; i < 3*internPendingValues; i++ {
+		ct := fmt.Sprintf("text/x-interned-%d", i%(internPendingValues+1))
+		s := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: %s\r\nContent-Length: 0\r\n\r\n", ct)
+		if err := h.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(h.ContentType()) != ct {
+			t.Fatalf("unexpected content-type %q. Expecting %q", h.ContentType(), ct)
+		}
+	}
+
+	var h1 ResponseHeader
+	h.CopyTo(&h1)
+	h1.SetContentType("foo/bar")
+	ct := fmt.Sprintf("text/x-interned-%d", (3*internPendingValues-1)%(internPendingValues+1))
+	if string(h.ContentType()) != ct {
+		t.Fatalf("unexpected content-type %q. Expecting %q", h.ContentType(), ct)
+	}
+}
+
+func TestHeaderInternValuesModified(t *testing.T) {
+	var vi headerValueInterner
+	for i := 0; i < internPendingValues; i++ {
+		vi.intern([]byte(fmt.Sprintf("value-%d", i)))
+	}
+	v := vi.intern([]byte("value-0"))
+	if string(v) != "value-0" {
+		t.Fatalf("unexpected interned value %q. Expecting %q", v, "value-0")
+	}
+
+	// Emulate the caller modifying the interned value in place.
+	v[0] = 'V'
+	if iv := vi.intern([]byte("value-0")); iv != nil {
+		t.Fatalf("unexpected modified interned value %q", iv)
+	}
+
+	var h ResponseHeader
+	h.SetInternValues(true)
+	s := "HTTP/1.1 200 OK\r\nContent-Type: value-0\r\nContent-Length: 0\r\n\r\n"
+	if err := h.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(h.ContentType()) != "value-0" {
+		t.Fatalf("unexpected content-type %q. Expecting %q", h.ContentType(), "value-0")
+	}
+}
+
+func TestRequestHeaderDisableNormalizing(t *testing.T) {
+	var req Request
+	req.SetDisableNormalizing(true)
+	s := "POST / HTTP/1.1\r\nHost: aaa\r\nx-foo-BAR: baz\r\ncontent-TYPE: text/plain\r\nCONTENT-length: 3\r\n\r\nabc"
+	if err := req.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	h := &req.Header
+	if v := h.Peek("X-Foo-Bar"); string(v) != "baz" {
+		t.Fatalf("unexpected header value %q. Expecting %q", v, "baz")
+	}
+	if string(h.ContentType()) != "text/plain" || h.ContentLength() != 3 {
+		t.Fatalf("special headers must be parsed regardless of casing. Got content-type %q, content-length %d",
+			h.ContentType(), h.ContentLength())
+	}
+	h.Set("x-Custom", "qwe")
+	h.Set("user-agent", "ua")
+	hs := h.String()
+	for _, line := range []string{"x-foo-BAR: baz\r\n", "x-Custom: qwe\r\n", "User-Agent: ua\r\n", "Content-Type: text/plain\r\n"} {
+		if !strings.Contains(hs, line) {
+			t.Fatalf("cannot find %q in the header\n%s", line, hs)
+		}
+	}
+
+	// The setting survives Reset.
+	req.Reset()
+	h.Set("x-lower", "1")
+	if !strings.Contains(h.String(), "x-lower: 1\r\n") {
+		t.Fatalf("header names must be kept as is after Reset\n%s", h.String())
+	}
+
+	req.SetDisableNormalizing(false)
+	h.Set("x-lower", "2")
+	if !strings.Contains(h.String(), "X-Lower: 2\r\n") {
+		t.Fatalf("header names must be normalized\n%s", h.String())
+	}
+}
+
+func TestResponseHeaderDisableNormalizing(t *testing.T) {
+	var resp Response
+	resp.SetDisableNormalizing(true)
+	s := "HTTP/1.1 200 OK\r\nx-FOO: bar\r\ncontent-length: 0\r\n\r\n"
+	if err := resp.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v := resp.Header.Peek("x-foo"); string(v) != "bar" {
+		t.Fatalf("unexpected header value %q. Expecting %q", v, "bar")
+	}
+	resp.Header.Add("x-trace-ID", "1")
+	hs := resp.Header.String()
+	for _, line := range []string{"x-FOO: bar\r\n", "x-trace-ID: 1\r\n", "Content-Length: 0\r\n"} {
+		if !strings.Contains(hs, line) {
+			t.Fatalf("cannot find %q in the header\n%s", line, hs)
+		}
+	}
+}
+
 func TestRequestHeaderEmptyValueFromHeader(t *testing.T) {
 	var h1 RequestHeader
 	h1.SetRequestURI("/foo/bar")
@@ -284,6 +487,8 @@ func TestHasHeaderValue(t *testing.T) {
 	testHasHeaderValue(t, "foo  ,   bar,  baz   ,", "ba", false)
 	testHasHeaderValue(t, "foo, ", "", true)
 	testHasHeaderValue(t, "foo", "", false)
+	testHasHeaderValue(t, "Keep-Alive, UPGRADE", "keep-alive", true)
+	testHasHeaderValue(t, "Keep-Alive, UPGRADE", "Upgrade", true)
 }
 
 func testHasHeaderValue(t *testing.T, s, value string, has bool) {
@@ -412,6 +617,11 @@ func TestAppendNormalizedHeaderKeyBytes(t *testing.T) {
 	testAppendNormalizedHeaderKeyBytes(t, "", "")
 	testAppendNormalizedHeaderKeyBytes(t, "Content-Type", "Content-Type")
 	testAppendNormalizedHeaderKeyBytes(t, "foO-bAr-BAZ", "Foo-Bar-Baz")
+	testAppendNormalizedHeaderKeyBytes(t, "x-forwarded-for", "X-Forwarded-For")
+	testAppendNormalizedHeaderKeyBytes(t, "ACCESS-CONTROL-REQUEST-HEADERS", "Access-Control-Request-Headers")
+	testAppendNormalizedHeaderKeyBytes(t, "a--b-", "A--b-")
+	testAppendNormalizedHeaderKeyBytes(t, "-foo", "-foo")
+	testAppendNormalizedHeaderKeyBytes(t, "X-ПРИВЕТ-A", "X-ПРИВЕТ-A")
 }
 
 func testAppendNormalizedHeaderKeyBytes(t *testing.T, key, expectedKey string) {
@@ -439,6 +649,25 @@ func TestRequestHeaderHTTP10ConnectionClose(t *testing.T) {
 	}
 }
 
+func TestRequestHeaderConnectionCaseInsensitive(t *testing.T) {
+	s := "GET / HTTP/1.1\r\nHost: foobar\r\nConnection: Close\r\n\r\n"
+	var h RequestHeader
+	if err := h.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !h.ConnectionClose() {
+		t.Fatalf("expecting 'Connection: close' request header")
+	}
+
+	s = "GET / HTTP/1.0\r\nHost: foobar\r\nConnection: KEEP-ALIVE\r\n\r\n"
+	if err := h.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if h.ConnectionClose() {
+		t.Fatalf("unexpected 'Connection: close' request header")
+	}
+}
+
 func TestRequestHeaderHTTP10ConnectionKeepAlive(t *testing.T) {
 	s := "GET / HTTP/1.0\r\nHost: foobar\r\nConnection: keep-alive\r\n\r\n"
 	var h RequestHeader
@@ -2009,6 +2238,185 @@ func TestRequestHeaderReadError(t *testing.T) {
 	testRequestHeaderReadError(t, h, "GET  HTTP/1.1\r\nHost: google.com\r\n\r\n")
 }
 
+func TestRequestHeaderStrictParsing(t *testing.T) {
+	test := func(headers string, expectedErr error) {
+		t.Helper()
+		var h RequestHeader
+		br := bufio.NewReader(bytes.NewBufferString(headers))
+		if err := h.Read(br); err != nil {
+			t.Fatalf("unexpected error in tolerant mode for %q: %s", headers, err)
+		}
+
+		h.SetStrictParsing(true)
+		br = bufio.NewReader(bytes.NewBufferString(headers))
+		err := h.Read(br)
+		if expectedErr == nil {
+			if err != nil {
+				t.Fatalf("unexpected error in strict mode for %q: %s", headers, err)
+			}
+			return
+		}
+		if !errors.Is(err, expectedErr) {
+			t.Fatalf("unexpected error in strict mode for %q: %v. Expecting %v", headers, err, expectedErr)
+		}
+	}
+
+	test("GET / HTTP/1.1\r\nHost: foo.com\r\nX-Foo: bar\tbaz\r\n\r\n", nil)
+	test("POST / HTTP/1.1\r\nHost: foo.com\r\nContent-Length: 0\r\nX-F!#$%&'*+-.^_`|~oo: bar\r\n\r\n", nil)
+
+	// obs-fold
+	test("GET / HTTP/1.1\r\nHost: foo.com\r\nX-Foo: bar\r\n baz: qux\r\n\r\n", errObsFoldHeader)
+	test("GET / HTTP/1.1\r\nHost: foo.com\r\nX-Foo: bar\r\n\tbaz: qux\r\n\r\n", errObsFoldHeader)
+
+	// whitespace before colon
+	test("GET / HTTP/1.1\r\nHost : foo.com\r\n\r\n", errInvalidHeaderName)
+	test("POST / HTTP/1.1\r\nHost: foo.com\r\nContent-Length\t: 0\r\n\r\n", errInvalidHeaderName)
+
+	// line without colon
+	test("GET / HTTP/1.1\r\nHost: foo.com\r\nX-Foo\r\nX-Bar: baz\r\n\r\n", errInvalidHeaderName)
+
+	// control chars in value
+	test("GET / HTTP/1.1\r\nHost: foo.com\r\nX-Foo: bar\x00baz\r\n\r\n", errInvalidHeaderValue)
+	test("GET / HTTP/1.1\r\nHost: foo.com\r\nX-Foo: bar\rbaz\r\n\r\n", errInvalidHeaderValue)
+	test("GET / HTTP/1.1\r\nHost: foo.com\r\nX-Foo: bar\x7f\r\n\r\n", errInvalidHeaderValue)
+}
+
+func TestHeaderInjection(t *testing.T) {
+	// HeaderInjectionStrip is used by default.
+	var h ResponseHeader
+	h.Set("X-Foo", "bar\r\nSet-Cookie: evil=1")
+	h.Add("X-Bar\n", "baz")
+	h.SetCanonical([]byte("Location"), []byte("/foo\r\n\r\n<html>"))
+	var c Cookie
+	c.SetKey("session")
+	c.SetValue("x\r\nX-Evil: 1")
+	h.SetCookie(&c)
+	s := h.String()
+	if strings.Contains(s, "\r\nSet-Cookie: evil") || strings.Contains(s, "\r\nX-Evil") || strings.Contains(s, "\r\n\r\n<html>") {
+		t.Fatalf("unexpected injected header in %q", s)
+	}
+	if v := h.Peek("X-Foo"); string(v) != "barSet-Cookie: evil=1" {
+		t.Fatalf("unexpected value: %q. Expecting %q", v, "barSet-Cookie: evil=1")
+	}
+	if v := h.Peek("X-Bar"); string(v) != "baz" {
+		t.Fatalf("unexpected value: %q. Expecting %q", v, "baz")
+	}
+
+	var req RequestHeader
+	req.SetCookie("foo", "bar\r\nX-Evil: 1")
+	if v := req.Cookie("foo"); string(v) != "barX-Evil: 1" {
+		t.Fatalf("unexpected cookie: %q. Expecting %q", v, "barX-Evil: 1")
+	}
+
+	var u URI
+	u.SetHost("foo.com\r\nX-Evil: 1")
+	u.SetQueryString("a=b\r\nX-Evil: 1")
+	if strings.ContainsAny(string(u.FullURI()), "\r\n") {
+		t.Fatalf("unexpected CR or LF in uri %q", u.FullURI())
+	}
+
+	// SetUnsafe skips the check.
+	h.Reset()
+	h.SetUnsafe("X-Trusted", "foo\r\n bar")
+	if v := h.Peek("X-Trusted"); string(v) != "foo\r\n bar" {
+		t.Fatalf("unexpected value: %q. Expecting %q", v, "foo\r\n bar")
+	}
+
+	h.SetInjectionPolicy(HeaderInjectionError)
+	h.Reset()
+	h.Set("X-Foo", "bar")
+	h.Set("X-Evil", "bar\r\nSet-Cookie: evil=1")
+	if len(h.Peek("X-Evil")) > 0 {
+		t.Fatalf("the rejected header mustn't be set")
+	}
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	if err := h.Write(bw); err != ErrHeaderInjection {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrHeaderInjection)
+	}
+	if _, err := h.WriteTo(&buf); err != ErrHeaderInjection {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrHeaderInjection)
+	}
+	h.Reset()
+	if err := h.Write(bw); err != nil {
+		t.Fatalf("unexpected error after Reset: %s", err)
+	}
+
+	h.SetInjectionPolicy(HeaderInjectionPanic)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expecting panic")
+			}
+		}()
+		h.Set("X-Evil", "bar\nbaz")
+	}()
+
+	h.SetInjectionPolicy(HeaderInjectionAllow)
+	h.Reset()
+	h.Set("X-Foo", "bar\r\n baz")
+	if v := h.Peek("X-Foo"); string(v) != "bar\r\n baz" {
+		t.Fatalf("unexpected value: %q. Expecting %q", v, "bar\r\n baz")
+	}
+
+	// The policy is per header.
+	var h1 ResponseHeader
+	h1.Set("X-Foo", "bar\r\n baz")
+	if v := h1.Peek("X-Foo"); string(v) != "bar baz" {
+		t.Fatalf("unexpected value: %q. Expecting %q", v, "bar baz")
+	}
+}
+
+func TestHeaderInjectionTypedSetters(t *testing.T) {
+	var h ResponseHeader
+	h.SetContentType("text/html\r\nX-Evil: 1")
+	h.SetServer("foo\r\nX-Evil: 1")
+	h.SetTrailer("X-Trailer", "foo\r\nX-Evil: 1")
+	if s := h.String(); strings.Contains(s, "\r\nX-Evil") {
+		t.Fatalf("unexpected injected header in %q", s)
+	}
+	if v := h.ContentType(); string(v) != "text/htmlX-Evil: 1" {
+		t.Fatalf("unexpected content type: %q. Expecting %q", v, "text/htmlX-Evil: 1")
+	}
+	if v := h.PeekTrailer("X-Trailer"); string(v) != "fooX-Evil: 1" {
+		t.Fatalf("unexpected trailer: %q. Expecting %q", v, "fooX-Evil: 1")
+	}
+
+	var req RequestHeader
+	req.SetMethod("GET\r\nX-Evil: 1")
+	req.SetRequestURI("/foo\r\nX-Evil: 1")
+	req.SetHost("foo.com\r\nX-Evil: 1")
+	req.SetContentType("text/plain\r\nX-Evil: 1")
+	req.SetUserAgent("agent\r\nX-Evil: 1")
+	req.SetReferer("http://foo.com/\r\nX-Evil: 1")
+	req.SetMultipartFormBoundary("foo\r\nX-Evil: 1")
+	req.SetTrailer("X-Trailer", "foo\r\nX-Evil: 1")
+	if s := req.String(); strings.Contains(s, "\r\nX-Evil") {
+		t.Fatalf("unexpected injected header in %q", s)
+	}
+	if v := req.RequestURI(); string(v) != "/fooX-Evil: 1" {
+		t.Fatalf("unexpected request uri: %q. Expecting %q", v, "/fooX-Evil: 1")
+	}
+
+	req.Reset()
+	req.SetInjectionPolicy(HeaderInjectionError)
+	req.SetUserAgent("agent\r\nX-Evil: 1")
+	if len(req.UserAgent()) > 0 {
+		t.Fatalf("the rejected user agent mustn't be set")
+	}
+	var buf bytes.Buffer
+	if _, err := req.WriteTo(&buf); err != ErrHeaderInjection {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrHeaderInjection)
+	}
+
+	// SetUnsafe skips the check for typed headers too.
+	h.Reset()
+	h.SetUnsafe("Content-Type", "foo\r\n bar")
+	if v := h.ContentType(); string(v) != "foo\r\n bar" {
+		t.Fatalf("unexpected content type: %q. Expecting %q", v, "foo\r\n bar")
+	}
+}
+
 func testResponseHeaderReadError(t *testing.T, h *ResponseHeader, headers string) {
 	r := bytes.NewBufferString(headers)
 	br := bufio.NewReader(r)
@@ -0,0 +1,198 @@
+package fasthttp
+
+import (
+	"bufio"
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// AddrHealthCheck configures active health probes for the addresses
+// listed in HostClient.Addr.
+//
+// Addresses failing the probe are removed from rotation until
+// the probe succeeds again. Requests are sent to unhealthy addresses
+// only if all the addresses are unhealthy.
+//
+// AddrHealthCheck may be shared among HostClients, since the health
+// of addresses is tracked by each HostClient individually.
+type AddrHealthCheck struct {
+	// Request path for probes.
+	//
+	// By default "/" is requested.
+	Path string
+
+	// Interval between probes of each address.
+	//
+	// By default addresses are probed every 10 seconds.
+	Interval time.Duration
+
+	// Timeout for each probe.
+	//
+	// By default the probe times out after 5 seconds.
+	Timeout time.Duration
+
+	// Callback deciding whether the address is healthy according
+	// to the probe response.
+	//
+	// By default responses with 2xx status codes are healthy.
+	IsHealthy func(resp *Response) bool
+}
+
+// healthCheckIdleDuration is the duration after which health probes
+// for unused HostClient are stopped.
+const healthCheckIdleDuration = time.Minute
+
+func (hc *AddrHealthCheck) path() string {
+	if len(hc.Path) == 0 {
+		return "/"
+	}
+	return hc.Path
+}
+
+func (hc *AddrHealthCheck) interval() time.Duration {
+	if hc.Interval <= 0 {
+		return 10 * time.Second
+	}
+	return hc.Interval
+}
+
+func (hc *AddrHealthCheck) timeout() time.Duration {
+	if hc.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return hc.Timeout
+}
+
+func (hc *AddrHealthCheck) isHealthy(resp *Response) bool {
+	if hc.IsHealthy != nil {
+		return hc.IsHealthy(resp)
+	}
+	statusCode := resp.StatusCode()
+	return statusCode >= 200 && statusCode < 300
+}
+
+// IsAddrHealthy returns false if the last health probe
+// of the given address from Addr failed.
+//
+// true is always returned if HealthCheck isn't set.
+func (c *HostClient) IsAddrHealthy(addr string) bool {
+	c.addrsLock.Lock()
+	defer c.addrsLock.Unlock()
+	for i, a := range c.addrs {
+		if a == addr {
+			return atomic.LoadInt32(&c.addrStats[i].unhealthy) == 0
+		}
+	}
+	return true
+}
+
+// startHealthCheckerLocked starts health probes for c.addrs
+// unless they are already running.
+//
+// It must be called under c.addrsLock.
+func (c *HostClient) startHealthCheckerLocked() {
+	if c.HealthCheck == nil || len(c.addrs) < 2 || c.healthCheckerRun {
+		return
+	}
+	c.healthCheckerRun = true
+	go c.healthChecker(c.HealthCheck)
+}
+
+func (c *HostClient) healthChecker(hc *AddrHealthCheck) {
+	for {
+		c.addrsLock.Lock()
+		addrs := c.addrs
+		c.addrsLock.Unlock()
+		for i, addr := range addrs {
+			c.setAddrHealth(i, c.probeAddr(hc, addr))
+		}
+
+		time.Sleep(hc.interval())
+
+		// Stop probing if c isn't used.
+		c.addrsLock.Lock()
+		mustStop := time.Since(c.LastUseTime()) > healthCheckIdleDuration
+		if mustStop {
+			c.healthCheckerRun = false
+		}
+		c.addrsLock.Unlock()
+		if mustStop {
+			return
+		}
+	}
+}
+
+// probeAddr sends health probe to addr and returns true
+// if the address is healthy.
+func (c *HostClient) probeAddr(hc *AddrHealthCheck, addr string) bool {
+	timeout := hc.timeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := c.dialAddr(ctx, addr, c.cachedTLSConfig(addr))
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return false
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.Header.SetRequestURI(hc.path())
+	req.Header.SetHost(addr)
+	req.Header.SetUserAgentBytes(c.getClientName())
+	req.SetConnectionClose()
+
+	bw := bufio.NewWriter(conn)
+	if err = req.Write(bw); err != nil {
+		return false
+	}
+	if err = bw.Flush(); err != nil {
+		return false
+	}
+	if err = resp.Read(bufio.NewReader(conn)); err != nil {
+		return false
+	}
+	return hc.isHealthy(resp)
+}
+
+// setAddrHealth updates the health of c.addrs[idx].
+//
+// Idle connections to the address are closed when it becomes unhealthy.
+func (c *HostClient) setAddrHealth(idx int, healthy bool) {
+	s := &c.addrStats[idx]
+	if healthy {
+		atomic.StoreInt32(&s.unhealthy, 0)
+		return
+	}
+	if atomic.SwapInt32(&s.unhealthy, 1) == 0 {
+		c.closeAddrIdleConns(s)
+	}
+}
+
+// closeAddrIdleConns closes idle connections to the address with stats s.
+func (c *HostClient) closeAddrIdleConns(s *addrStat) {
+	var scratch []*clientConn
+	c.connsLock.Lock()
+	conns := c.conns[:0]
+	for _, cc := range c.conns {
+		if cc.addrStat == s {
+			scratch = append(scratch, cc)
+		} else {
+			conns = append(conns, cc)
+		}
+	}
+	for i := len(conns); i < len(c.conns); i++ {
+		c.conns[i] = nil
+	}
+	c.conns = conns
+	c.connsLock.Unlock()
+
+	for _, cc := range scratch {
+		c.closeConn(cc)
+	}
+}
@@ -0,0 +1,114 @@
+package fasthttp
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func waitAddrHealth(t *testing.T, c *HostClient, addr string, healthy bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.IsAddrHealthy(addr) != healthy {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %q health to become %v", addr, healthy)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHostClientHealthCheck(t *testing.T) {
+	var sick uint32 = 1
+	lns := map[string]*fasthttputil.InmemoryListener{
+		"sick": fasthttputil.NewInmemoryListener(),
+		"well": fasthttputil.NewInmemoryListener(),
+	}
+	for addr, ln := range lns {
+		addr := addr
+		defer ln.Close()
+		s := &Server{
+			Handler: func(ctx *RequestCtx) {
+				if string(ctx.Path()) == "/health" {
+					if addr == "sick" && atomic.LoadUint32(&sick) == 1 {
+						ctx.SetStatusCode(StatusServiceUnavailable)
+					}
+					return
+				}
+				ctx.WriteString(addr)
+			},
+		}
+		go s.Serve(ln)
+	}
+
+	c := &HostClient{
+		Addr: "sick,well",
+		HealthCheck: &AddrHealthCheck{
+			Path:     "/health",
+			Interval: 10 * time.Millisecond,
+		},
+		Dial: func(addr string) (net.Conn, error) {
+			return lns[addr].Dial()
+		},
+	}
+
+	// Close connections after each request, so every request
+	// selects the address.
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/")
+	req.SetConnectionClose()
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	// The first request starts health probes.
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	waitAddrHealth(t, c, "sick", false)
+	if !c.IsAddrHealthy("well") {
+		t.Fatalf("expecting healthy addr %q", "well")
+	}
+	for i := 0; i < 10; i++ {
+		if err := c.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if body := resp.Body(); string(body) != "well" {
+			t.Fatalf("unexpected response from %q. Expecting %q", body, "well")
+		}
+	}
+
+	// The recovered address is returned to rotation.
+	atomic.StoreUint32(&sick, 0)
+	waitAddrHealth(t, c, "sick", true)
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		if err := c.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		seen[string(resp.Body())] = true
+	}
+	if !seen["sick"] {
+		t.Fatalf("expecting requests to the recovered addr %q", "sick")
+	}
+}
+
+func TestAddrHealthCheckIsHealthy(t *testing.T) {
+	var hc AddrHealthCheck
+	var resp Response
+	if !hc.isHealthy(&resp) {
+		t.Fatalf("expecting healthy response with status %d", resp.StatusCode())
+	}
+	resp.SetStatusCode(StatusBadGateway)
+	if hc.isHealthy(&resp) {
+		t.Fatalf("expecting unhealthy response with status %d", resp.StatusCode())
+	}
+	hc.IsHealthy = func(resp *Response) bool {
+		return resp.StatusCode() < 600
+	}
+	if !hc.isHealthy(&resp) {
+		t.Fatalf("expecting healthy response with status %d", resp.StatusCode())
+	}
+}
@@ -0,0 +1,399 @@
+package fasthttp
+
+import (
+	"errors"
+	"sync"
+)
+
+// HPACK header compression for HTTP/2. See https://tools.ietf.org/html/rfc7541 .
+
+// hpackDefaultTableSize is the default size of the dynamic table.
+const hpackDefaultTableSize = 4096
+
+// hpackEntryOverhead is added to the size of each dynamic table entry.
+// See RFC 7541, section 4.1.
+const hpackEntryOverhead = 32
+
+var (
+	errHPACKCorrupt        = errors.New("corrupted HPACK header block")
+	errHPACKInvalidIndex   = errors.New("invalid HPACK table index")
+	errHPACKInvalidHuffman = errors.New("invalid HPACK Huffman-encoded string")
+	errHPACKTableSize      = errors.New("HPACK dynamic table size update exceeds the limit")
+)
+
+type hpackField struct {
+	name  string
+	value string
+}
+
+func (f *hpackField) size() int {
+	return len(f.name) + len(f.value) + hpackEntryOverhead
+}
+
+// hpackDecoder decodes header blocks received over a single connection.
+//
+// The dynamic table is shared by all the header blocks of the connection,
+// so the blocks must be decoded in the order they are received.
+type hpackDecoder struct {
+	// dynTable contains dynamic table entries, the newest entry is the last.
+	dynTable []hpackField
+	dynSize  int
+
+	// maxSize is the current dynamic table size set by the encoder.
+	maxSize int
+
+	// maxSizeLimit is the limit for maxSize announced to the encoder.
+	maxSizeLimit int
+
+	nameBuf  []byte
+	valueBuf []byte
+}
+
+func newHPACKDecoder(maxSizeLimit int) *hpackDecoder {
+	return &hpackDecoder{
+		maxSize:      maxSizeLimit,
+		maxSizeLimit: maxSizeLimit,
+	}
+}
+
+// decode calls f for each header field in the header block b.
+//
+// name and value passed to f are valid only until f returns.
+func (d *hpackDecoder) decode(b []byte, f func(name, value []byte) error) error {
+	sizeUpdateAllowed := true
+	for len(b) > 0 {
+		c := b[0]
+		var err error
+		switch {
+		case c&0x80 != 0:
+			// Indexed header field.
+			var idx uint64
+			if idx, b, err = hpackReadInt(b, 7); err != nil {
+				return err
+			}
+			hf, err := d.field(idx)
+			if err != nil {
+				return err
+			}
+			if err = f(s2b(hf.name), s2b(hf.value)); err != nil {
+				return err
+			}
+		case c&0xc0 == 0x40:
+			// Literal header field with incremental indexing.
+			if b, err = d.decodeLiteral(b, 6, true, f); err != nil {
+				return err
+			}
+		case c&0xe0 == 0x20:
+			// Dynamic table size update.
+			if !sizeUpdateAllowed {
+				return errHPACKCorrupt
+			}
+			var size uint64
+			if size, b, err = hpackReadInt(b, 5); err != nil {
+				return err
+			}
+			if size > uint64(d.maxSizeLimit) {
+				return errHPACKTableSize
+			}
+			d.maxSize = int(size)
+			d.evict()
+			continue
+		default:
+			// Literal header field without indexing or never indexed.
+			if b, err = d.decodeLiteral(b, 4, false, f); err != nil {
+				return err
+			}
+		}
+		// Table size updates are allowed only at the beginning of the block.
+		sizeUpdateAllowed = false
+	}
+	return nil
+}
+
+func (d *hpackDecoder) decodeLiteral(b []byte, prefix uint8, index bool, f func(name, value []byte) error) ([]byte, error) {
+	idx, b, err := hpackReadInt(b, prefix)
+	if err != nil {
+		return b, err
+	}
+	var name []byte
+	if idx > 0 {
+		hf, err := d.field(idx)
+		if err != nil {
+			return b, err
+		}
+		name = append(d.nameBuf[:0], hf.name...)
+	} else {
+		if name, b, err = hpackReadString(d.nameBuf[:0], b); err != nil {
+			return b, err
+		}
+	}
+	d.nameBuf = name
+	var value []byte
+	if value, b, err = hpackReadString(d.valueBuf[:0], b); err != nil {
+		return b, err
+	}
+	d.valueBuf = value
+	if index {
+		d.add(hpackField{
+			name:  string(name),
+			value: string(value),
+		})
+	}
+	return b, f(name, value)
+}
+
+// field returns the header field for the given index in the combined
+// static and dynamic table.
+func (d *hpackDecoder) field(idx uint64) (*hpackField, error) {
+	if idx == 0 {
+		return nil, errHPACKInvalidIndex
+	}
+	if idx <= uint64(len(hpackStaticTable)) {
+		return &hpackStaticTable[idx-1], nil
+	}
+	idx -= uint64(len(hpackStaticTable))
+	if idx > uint64(len(d.dynTable)) {
+		return nil, errHPACKInvalidIndex
+	}
+	return &d.dynTable[len(d.dynTable)-int(idx)], nil
+}
+
+func (d *hpackDecoder) add(hf hpackField) {
+	size := hf.size()
+	if size > d.maxSize {
+		// Entries larger than the table empty the table.
+		d.dynTable = d.dynTable[:0]
+		d.dynSize = 0
+		return
+	}
+	d.dynTable = append(d.dynTable, hf)
+	d.dynSize += size
+	d.evict()
+}
+
+// evict removes the oldest entries until the table fits maxSize.
+func (d *hpackDecoder) evict() {
+	n := 0
+	for d.dynSize > d.maxSize {
+		d.dynSize -= d.dynTable[n].size()
+		n++
+	}
+	if n > 0 {
+		m := copy(d.dynTable, d.dynTable[n:])
+		for i := m; i < len(d.dynTable); i++ {
+			d.dynTable[i] = hpackField{}
+		}
+		d.dynTable = d.dynTable[:m]
+	}
+}
+
+// hpackReadInt reads the integer with the given prefix bits from b.
+// See RFC 7541, section 5.1.
+func hpackReadInt(b []byte, prefix uint8) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, b, errHPACKCorrupt
+	}
+	mask := uint64(1)<<prefix - 1
+	n := uint64(b[0]) & mask
+	b = b[1:]
+	if n < mask {
+		return n, b, nil
+	}
+	var m uint8
+	for len(b) > 0 {
+		c := b[0]
+		b = b[1:]
+		n += uint64(c&0x7f) << m
+		if c&0x80 == 0 {
+			return n, b, nil
+		}
+		m += 7
+		if m >= 63 {
+			break
+		}
+	}
+	return 0, b, errHPACKCorrupt
+}
+
+// hpackReadString appends the string literal read from b to dst.
+// See RFC 7541, section 5.2.
+func hpackReadString(dst, b []byte) ([]byte, []byte, error) {
+	if len(b) == 0 {
+		return dst, b, errHPACKCorrupt
+	}
+	isHuffman := b[0]&0x80 != 0
+	n, b, err := hpackReadInt(b, 7)
+	if err != nil {
+		return dst, b, err
+	}
+	if n > uint64(len(b)) {
+		return dst, b, errHPACKCorrupt
+	}
+	s := b[:n]
+	b = b[n:]
+	if !isHuffman {
+		return append(dst, s...), b, nil
+	}
+	dst, err = hpackAppendHuffmanDecoded(dst, s)
+	return dst, b, err
+}
+
+// hpackAppendInt appends the integer n with the given prefix bits
+// to dst. flags are stored in the bits preceding the prefix.
+func hpackAppendInt(dst []byte, flags byte, prefix uint8, n uint64) []byte {
+	mask := uint64(1)<<prefix - 1
+	if n < mask {
+		return append(dst, flags|byte(n))
+	}
+	dst = append(dst, flags|byte(mask))
+	n -= mask
+	for n >= 0x80 {
+		dst = append(dst, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(dst, byte(n))
+}
+
+// hpackAppendString appends the string literal s to dst.
+//
+// s is Huffman-encoded if this makes it shorter.
+func hpackAppendString(dst, s []byte) []byte {
+	if n := hpackHuffmanEncodedLen(s); n < len(s) {
+		dst = hpackAppendInt(dst, 0x80, 7, uint64(n))
+		return hpackAppendHuffmanEncoded(dst, s)
+	}
+	dst = hpackAppendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// hpackAppendField appends the header field with lowercase name
+// to the header block dst.
+//
+// Header fields aren't added to the dynamic table, so the decoder state
+// doesn't depend on the encoded fields.
+func hpackAppendField(dst, name, value []byte) []byte {
+	nameIdx := 0
+	for i := range hpackStaticTable {
+		hf := &hpackStaticTable[i]
+		if hf.name != string(name) {
+			if nameIdx > 0 {
+				// Entries with the same name are adjacent.
+				break
+			}
+			continue
+		}
+		if hf.value == string(value) {
+			return hpackAppendInt(dst, 0x80, 7, uint64(i+1))
+		}
+		if nameIdx == 0 {
+			nameIdx = i + 1
+		}
+	}
+	// Literal header field without indexing.
+	dst = hpackAppendInt(dst, 0, 4, uint64(nameIdx))
+	if nameIdx == 0 {
+		dst = hpackAppendString(dst, name)
+	}
+	return hpackAppendString(dst, value)
+}
+
+func hpackHuffmanEncodedLen(s []byte) int {
+	n := 0
+	for _, c := range s {
+		n += int(hpackHuffmanCodeLens[c])
+	}
+	return (n + 7) / 8
+}
+
+func hpackAppendHuffmanEncoded(dst, s []byte) []byte {
+	var x uint64
+	var n uint8
+	for _, c := range s {
+		l := hpackHuffmanCodeLens[c]
+		x = x<<l | uint64(hpackHuffmanCodes[c])
+		n += l
+		for n >= 8 {
+			n -= 8
+			dst = append(dst, byte(x>>n))
+		}
+	}
+	if n > 0 {
+		// Pad the last octet with the most significant bits of EOS.
+		dst = append(dst, byte(x<<(8-n))|byte(0xff>>n))
+	}
+	return dst
+}
+
+// hpackHuffmanNode is a node of the Huffman decoding tree.
+//
+// children contain either indexes of internal nodes or symbols
+// with hpackHuffmanLeaf bit set. Zero means there is no child,
+// since the root isn't a child of any node.
+type hpackHuffmanNode struct {
+	children [2]uint16
+}
+
+const hpackHuffmanLeaf = 0x8000
+
+var (
+	hpackHuffmanTree     []hpackHuffmanNode
+	hpackHuffmanTreeOnce sync.Once
+)
+
+func initHPACKHuffmanTree() {
+	tree := make([]hpackHuffmanNode, 1, 256)
+	for sym := range hpackHuffmanCodes {
+		code := hpackHuffmanCodes[sym]
+		l := hpackHuffmanCodeLens[sym]
+		node := 0
+		for i := int(l) - 1; i > 0; i-- {
+			bit := (code >> uint(i)) & 1
+			next := tree[node].children[bit]
+			if next == 0 {
+				next = uint16(len(tree))
+				tree[node].children[bit] = next
+				tree = append(tree, hpackHuffmanNode{})
+			}
+			node = int(next)
+		}
+		tree[node].children[code&1] = hpackHuffmanLeaf | uint16(sym)
+	}
+	hpackHuffmanTree = tree
+}
+
+// hpackAppendHuffmanDecoded appends Huffman-decoded s to dst.
+// See RFC 7541, section 5.2.
+func hpackAppendHuffmanDecoded(dst, s []byte) ([]byte, error) {
+	hpackHuffmanTreeOnce.Do(initHPACKHuffmanTree)
+	tree := hpackHuffmanTree
+	node := 0
+	// padBits is the number of bits read since the last decoded symbol.
+	padBits := 0
+	// padOnes is set if all these bits are ones.
+	padOnes := true
+	for _, c := range s {
+		for i := 7; i >= 0; i-- {
+			bit := (c >> uint(i)) & 1
+			next := tree[node].children[bit]
+			if next == 0 {
+				// EOS or invalid code.
+				return dst, errHPACKInvalidHuffman
+			}
+			padBits++
+			padOnes = padOnes && bit == 1
+			if next&hpackHuffmanLeaf != 0 {
+				dst = append(dst, byte(next))
+				node = 0
+				padBits = 0
+				padOnes = true
+				continue
+			}
+			node = int(next)
+		}
+	}
+	// Padding must be shorter than 8 bits and consist of EOS prefix.
+	if padBits > 7 || !padOnes {
+		return dst, errHPACKInvalidHuffman
+	}
+	return dst, nil
+}
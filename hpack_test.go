@@ -0,0 +1,147 @@
+package fasthttp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testHPACKDecode(t *testing.T, d *hpackDecoder, block string, expected []string) {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.Replace(block, " ", "", -1))
+	if err != nil {
+		t.Fatalf("cannot decode hex %q: %s", block, err)
+	}
+	var fields []string
+	if err := d.decode(b, func(name, value []byte) error {
+		fields = append(fields, fmt.Sprintf("%s: %s", name, value))
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Join(fields, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected fields %q. Expecting %q", fields, expected)
+	}
+}
+
+func TestHPACKDecoderRequests(t *testing.T) {
+	// See RFC 7541, Appendix C.4.
+	d := newHPACKDecoder(hpackDefaultTableSize)
+	testHPACKDecode(t, d, "8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff", []string{
+		":method: GET",
+		":scheme: http",
+		":path: /",
+		":authority: www.example.com",
+	})
+	testHPACKDecode(t, d, "8286 84be 5886 a8eb 1064 9cbf", []string{
+		":method: GET",
+		":scheme: http",
+		":path: /",
+		":authority: www.example.com",
+		"cache-control: no-cache",
+	})
+	testHPACKDecode(t, d, "8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf", []string{
+		":method: GET",
+		":scheme: https",
+		":path: /index.html",
+		":authority: www.example.com",
+		"custom-key: custom-value",
+	})
+	if d.dynSize != 164 {
+		t.Fatalf("unexpected dynamic table size: %d. Expecting 164", d.dynSize)
+	}
+}
+
+func TestHPACKDecoderResponsesEviction(t *testing.T) {
+	// See RFC 7541, Appendix C.6.
+	d := newHPACKDecoder(256)
+	testHPACKDecode(t, d, "4882 6402 5885 aec3 771a 4b61 96d0 7abe 9410 54d4 44a8 2005 9504 0b81 66e0 82a6 "+
+		"2d1b ff6e 919d 29ad 1718 63c7 8f0b 97c8 e9ae 82ae 43d3", []string{
+		":status: 302",
+		"cache-control: private",
+		"date: Mon, 21 Oct 2013 20:13:21 GMT",
+		"location: https://www.example.com",
+	})
+	testHPACKDecode(t, d, "4883 640e ffc1 c0bf", []string{
+		":status: 307",
+		"cache-control: private",
+		"date: Mon, 21 Oct 2013 20:13:21 GMT",
+		"location: https://www.example.com",
+	})
+	testHPACKDecode(t, d, "88c1 6196 d07a be94 1054 d444 a820 0595 040b 8166 e084 a62d 1bff c05a 839b d9ab "+
+		"77ad 94e7 821d d7f2 e6c7 b335 dfdf cd5b 3960 d5af 2708 7f36 72c1 ab27 0fb5 291f 9587 3160 65c0 03ed "+
+		"4ee5 b106 3d50 07", []string{
+		":status: 200",
+		"cache-control: private",
+		"date: Mon, 21 Oct 2013 20:13:22 GMT",
+		"location: https://www.example.com",
+		"content-encoding: gzip",
+		"set-cookie: foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1",
+	})
+	if d.dynSize != 215 || len(d.dynTable) != 3 {
+		t.Fatalf("unexpected dynamic table size: %d bytes, %d entries. Expecting 215 bytes, 3 entries", d.dynSize, len(d.dynTable))
+	}
+}
+
+func TestHPACKDecoderInvalid(t *testing.T) {
+	for _, block := range []string{
+		// Index 0.
+		"80",
+		// Index beyond the dynamic table.
+		"be",
+		// Truncated string.
+		"4005 6162",
+		// Truncated integer.
+		"7f",
+		// Table size update exceeding the limit.
+		"3fe2 1f",
+		// Table size update after header field.
+		"8220",
+		// Huffman padding longer than 7 bits.
+		"4081 ff81 ff",
+		// Huffman-encoded EOS.
+		"4084 ffff fffc 8161",
+	} {
+		b, err := hex.DecodeString(strings.Replace(block, " ", "", -1))
+		if err != nil {
+			t.Fatalf("cannot decode hex %q: %s", block, err)
+		}
+		d := newHPACKDecoder(hpackDefaultTableSize)
+		if err := d.decode(b, func(name, value []byte) error { return nil }); err == nil {
+			t.Fatalf("expecting error for block %q", block)
+		}
+	}
+}
+
+func TestHPACKAppendField(t *testing.T) {
+	var b []byte
+	b = hpackAppendField(b, []byte(":method"), []byte("GET"))
+	b = hpackAppendField(b, []byte(":path"), []byte("/foo"))
+	b = hpackAppendField(b, []byte(":authority"), []byte("www.example.com"))
+	b = hpackAppendField(b, []byte("x-custom"), []byte(strings.Repeat("x", 200)))
+	b = hpackAppendField(b, []byte("x-binary"), []byte("\x01\xff"))
+
+	// The encoder doesn't use the dynamic table, so the same block
+	// is decoded identically multiple times.
+	d := newHPACKDecoder(hpackDefaultTableSize)
+	for i := 0; i < 2; i++ {
+		testHPACKDecode(t, d, hex.EncodeToString(b), []string{
+			":method: GET",
+			":path: /foo",
+			":authority: www.example.com",
+			"x-custom: " + strings.Repeat("x", 200),
+			"x-binary: \x01\xff",
+		})
+	}
+	if len(d.dynTable) != 0 {
+		t.Fatalf("unexpected dynamic table entries: %d", len(d.dynTable))
+	}
+
+	// See RFC 7541, Appendix C.4.1.
+	b = hpackAppendString(nil, []byte("www.example.com"))
+	if s := hex.EncodeToString(b); s != "8cf1e3c2e5f23a6ba0ab90f4ff" {
+		t.Fatalf("unexpected encoded string %q. Expecting %q", s, "8cf1e3c2e5f23a6ba0ab90f4ff")
+	}
+}
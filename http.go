@@ -5,10 +5,13 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"mime/multipart"
 	"os"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/valyala/bytebufferpool"
 )
@@ -27,23 +30,64 @@ type Request struct {
 	// Copying Header by value is forbidden. Use pointer to Header instead.
 	Header RequestHeader
 
+	// GetBody returns a new reader with the request body.
+	//
+	// HostClient calls GetBody for re-acquiring the body stream set
+	// via SetBodyStream* when the request is retried or is redirected
+	// with the body preserved, since the original stream is consumed
+	// by the first attempt. The returned reader must provide the same
+	// data as the original stream. It is closed after reading
+	// if it implements io.Closer.
+	//
+	// By default requests with body stream aren't retried.
+	GetBody func() (io.Reader, error)
+
 	uri      URI
 	postArgs Args
+	formArgs Args
 
 	bodyStream io.Reader
 	w          requestBodyWriter
 	body       *bytebufferpool.ByteBuffer
+	bodyTee    io.Writer
 
 	multipartForm         *multipart.Form
 	multipartFormBoundary string
 
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
 	// Group bool members in order to reduce Request object size.
 	parsedURI      bool
 	parsedPostArgs bool
+	parsedFormArgs bool
 
 	keepBodyBuffer bool
 
+	// streamResponseBody forces clients to stream the response body.
+	streamResponseBody bool
+
+	// holdRedirectConn makes clients holding redirectConn between
+	// redirect hops to the same host.
+	holdRedirectConn   bool
+	redirectConn       *clientConn
+	redirectConnClient *HostClient
+
+	// unixSocket is the socket path for the request uri rewritten by Client
+	// from unix scheme. It is cleared when redirects lead the request
+	// to another host, so the uri isn't restored to unix scheme then.
+	unixSocket string
+
 	isTLS bool
+
+	bodyLengthPolicy BodyLengthMismatchPolicy
+
+	// decompressLimits limits BodyGunzip, BodyInflate, BodyUnbrotli
+	// and BodyUnzstd* if set.
+	decompressLimits *DecompressLimits
+
+	// arena holds parsed headers and args if set.
+	arena *arena
 }
 
 // Response represents HTTP response.
@@ -63,6 +107,7 @@ type Response struct {
 	bodyStream io.Reader
 	w          responseBodyWriter
 	body       *bytebufferpool.ByteBuffer
+	bodyTee    io.Writer
 
 	// Response.Read() skips reading body if set to true.
 	// Use it for reading HEAD responses.
@@ -72,6 +117,12 @@ type Response struct {
 	SkipBody bool
 
 	keepBodyBuffer bool
+
+	bodyLengthPolicy BodyLengthMismatchPolicy
+
+	// decompressLimits limits BodyGunzip, BodyInflate, BodyUnbrotli,
+	// BodyUnzstd* and the body decompression by clients if set.
+	decompressLimits *DecompressLimits
 }
 
 // SetHost sets host for the request.
@@ -93,12 +144,14 @@ func (req *Request) Host() []byte {
 func (req *Request) SetRequestURI(requestURI string) {
 	req.Header.SetRequestURI(requestURI)
 	req.parsedURI = false
+	req.parsedFormArgs = false
 }
 
 // SetRequestURIBytes sets RequestURI.
 func (req *Request) SetRequestURIBytes(requestURI []byte) {
 	req.Header.SetRequestURIBytes(requestURI)
 	req.parsedURI = false
+	req.parsedFormArgs = false
 }
 
 // RequestURI returns request's URI.
@@ -140,6 +193,44 @@ func (req *Request) SetConnectionClose() {
 	req.Header.SetConnectionClose()
 }
 
+// SetReadTimeout sets the maximum duration for reading the response
+// to req, including the response body.
+//
+// It overrides HostClient.ReadTimeout for req, so different requests
+// sent by the same client may have distinct timeouts. Zero d removes
+// the override.
+func (req *Request) SetReadTimeout(d time.Duration) {
+	req.readTimeout = d
+}
+
+// SetWriteTimeout sets the maximum duration for writing req,
+// including the request body.
+//
+// It overrides HostClient.WriteTimeout for req, so slow uploads may be
+// given more time without affecting the response read timeout.
+// Zero d removes the override.
+func (req *Request) SetWriteTimeout(d time.Duration) {
+	req.writeTimeout = d
+}
+
+// SetDisableNormalizing disables or enables normalization of request
+// header names.
+//
+// This allows preserving the original header names' casing for specific
+// requests. See ResponseHeader.SetDisableNormalizing for details.
+func (req *Request) SetDisableNormalizing(disableNormalizing bool) {
+	req.Header.SetDisableNormalizing(disableNormalizing)
+}
+
+// SetDisableNormalizing disables or enables normalization of response
+// header names.
+//
+// This allows preserving the original header names' casing for specific
+// responses. See ResponseHeader.SetDisableNormalizing for details.
+func (resp *Response) SetDisableNormalizing(disableNormalizing bool) {
+	resp.Header.SetDisableNormalizing(disableNormalizing)
+}
+
 // SendFile registers file on the given path to be used as response body
 // when Write is called.
 //
@@ -171,11 +262,16 @@ func (resp *Response) SendFile(path string) error {
 // If bodySize is >= 0, then the bodyStream must provide exactly bodySize bytes
 // before returning io.EOF.
 //
-// If bodySize < 0, then bodyStream is read until io.EOF.
+// If bodySize < 0, then bodyStream is read until io.EOF. The client sends
+// such bodies with 'Transfer-Encoding: chunked', so data may be piped
+// to the server without buffering it in memory.
 //
 // bodyStream.Close() is called after finishing reading all body data
 // if it implements io.Closer.
 //
+// The client doesn't retry requests with body stream, since the stream
+// cannot be read twice. Set GetBody for enabling retries.
+//
 // Note that GET and HEAD requests cannot have body.
 //
 // See also SetBodyStreamWriter.
@@ -202,6 +298,35 @@ func (resp *Response) SetBodyStream(bodyStream io.Reader, bodySize int) {
 	resp.Header.SetContentLength(bodySize)
 }
 
+// SetBodyTee sets w, which receives a copy of the request body
+// read by subsequent Read, ReadLimitBody and ContinueReadBody calls.
+//
+// This allows hashing or auditing the body without copying it
+// to additional buffers. The body is written to w as read from
+// the connection, i.e. before decompression. Errors returned by w
+// are returned from the read call. Multipart form bodies aren't
+// streamed to temporary files if w is set.
+//
+// w is reset by Reset. See also Server.RequestBodyTee.
+func (req *Request) SetBodyTee(w io.Writer) {
+	req.bodyTee = w
+}
+
+// SetBodyTee sets w, which receives a copy of the response body
+// read by subsequent Read and ReadLimitBody calls.
+//
+// Clients preserve w, so it may be set before calling Client.Do.
+// DoTimeout and DoDeadline ignore w though. Body streams returned
+// by clients with StreamResponseBody copy the body to w while
+// the stream is read.
+// The body is written to w as read from the connection, i.e. before
+// decompression. Errors returned by w are returned from the read call.
+//
+// w is reset by Reset.
+func (resp *Response) SetBodyTee(w io.Writer) {
+	resp.bodyTee = w
+}
+
 // IsBodyStream returns true if body is set via SetBodyStream*
 func (req *Request) IsBodyStream() bool {
 	return req.bodyStream != nil
@@ -332,8 +457,11 @@ var (
 // This method may be used if the request header contains
 // 'Content-Encoding: gzip' for reading un-gzipped body.
 // Use Body for reading gzipped request body.
+//
+// *DecompressLimitError is returned if the un-gzipped body exceeds
+// Server.DecompressLimits.
 func (req *Request) BodyGunzip() ([]byte, error) {
-	return gunzipData(req.Body())
+	return gunzipData(req.Body(), req.decompressLimits)
 }
 
 // BodyGunzip returns un-gzipped body data.
@@ -341,11 +469,17 @@ func (req *Request) BodyGunzip() ([]byte, error) {
 // This method may be used if the response header contains
 // 'Content-Encoding: gzip' for reading un-gzipped body.
 // Use Body for reading gzipped response body.
+//
+// *DecompressLimitError is returned if the un-gzipped body exceeds
+// HostClient.DecompressLimits.
 func (resp *Response) BodyGunzip() ([]byte, error) {
-	return gunzipData(resp.Body())
+	return gunzipData(resp.Body(), resp.decompressLimits)
 }
 
-func gunzipData(p []byte) ([]byte, error) {
+func gunzipData(p []byte, l *DecompressLimits) ([]byte, error) {
+	if l != nil {
+		return limitedData(l.AppendGunzipBytes(nil, p))
+	}
 	var bb ByteBuffer
 	_, err := WriteGunzip(&bb, p)
 	if err != nil {
@@ -354,13 +488,25 @@ func gunzipData(p []byte) ([]byte, error) {
 	return bb.B, nil
 }
 
+// limitedData returns b decompressed with DecompressLimits
+// or nil on error.
+func limitedData(b []byte, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 // BodyInflate returns inflated body data.
 //
 // This method may be used if the response header contains
 // 'Content-Encoding: deflate' for reading inflated request body.
 // Use Body for reading deflated request body.
+//
+// *DecompressLimitError is returned if the inflated body exceeds
+// Server.DecompressLimits.
 func (req *Request) BodyInflate() ([]byte, error) {
-	return inflateData(req.Body())
+	return inflateData(req.Body(), req.decompressLimits)
 }
 
 // BodyInflate returns inflated body data.
@@ -368,11 +514,17 @@ func (req *Request) BodyInflate() ([]byte, error) {
 // This method may be used if the response header contains
 // 'Content-Encoding: deflate' for reading inflated response body.
 // Use Body for reading deflated response body.
+//
+// *DecompressLimitError is returned if the inflated body exceeds
+// HostClient.DecompressLimits.
 func (resp *Response) BodyInflate() ([]byte, error) {
-	return inflateData(resp.Body())
+	return inflateData(resp.Body(), resp.decompressLimits)
 }
 
-func inflateData(p []byte) ([]byte, error) {
+func inflateData(p []byte, l *DecompressLimits) ([]byte, error) {
+	if l != nil {
+		return limitedData(l.AppendInflateBytes(nil, p))
+	}
 	var bb ByteBuffer
 	_, err := WriteInflate(&bb, p)
 	if err != nil {
@@ -381,6 +533,173 @@ func inflateData(p []byte) ([]byte, error) {
 	return bb.B, nil
 }
 
+// BodyUnbrotli returns brotli-decompressed body data.
+//
+// This method may be used if the request header contains
+// 'Content-Encoding: br' for reading decompressed request body.
+// Use Body for reading compressed request body.
+//
+// *DecompressLimitError is returned if the decompressed body exceeds
+// Server.DecompressLimits.
+func (req *Request) BodyUnbrotli() ([]byte, error) {
+	return unbrotliData(req.Body(), req.decompressLimits)
+}
+
+// BodyUnbrotli returns brotli-decompressed body data.
+//
+// This method may be used if the response header contains
+// 'Content-Encoding: br' for reading decompressed response body.
+// Use Body for reading compressed response body.
+//
+// *DecompressLimitError is returned if the decompressed body exceeds
+// HostClient.DecompressLimits.
+func (resp *Response) BodyUnbrotli() ([]byte, error) {
+	return unbrotliData(resp.Body(), resp.decompressLimits)
+}
+
+func unbrotliData(p []byte, l *DecompressLimits) ([]byte, error) {
+	if l != nil {
+		return limitedData(l.AppendUnbrotliBytes(nil, p))
+	}
+	return limitedData(AppendUnbrotliBytes(nil, p))
+}
+
+// BodyUnzstd returns zstd-decompressed body data.
+//
+// This method may be used if the request header contains
+// 'Content-Encoding: zstd' for reading decompressed request body.
+// Use BodyUnzstdDict if the body is compressed with shared dictionary.
+//
+// *DecompressLimitError is returned if the decompressed body exceeds
+// Server.DecompressLimits.
+func (req *Request) BodyUnzstd() ([]byte, error) {
+	return req.BodyUnzstdDict(nil)
+}
+
+// BodyUnzstdDict returns body data decompressed with the given
+// zstd dictionary.
+func (req *Request) BodyUnzstdDict(d *ZstdDict) ([]byte, error) {
+	return unzstdData(req.Body(), d, req.decompressLimits)
+}
+
+// BodyUnzstd returns zstd-decompressed body data.
+//
+// This method may be used if the response header contains
+// 'Content-Encoding: zstd' for reading decompressed response body.
+// Use BodyUnzstdDict if the body is compressed with shared dictionary.
+//
+// *DecompressLimitError is returned if the decompressed body exceeds
+// HostClient.DecompressLimits.
+func (resp *Response) BodyUnzstd() ([]byte, error) {
+	return resp.BodyUnzstdDict(nil)
+}
+
+// BodyUnzstdDict returns body data decompressed with the given
+// zstd dictionary.
+func (resp *Response) BodyUnzstdDict(d *ZstdDict) ([]byte, error) {
+	return unzstdData(resp.Body(), d, resp.decompressLimits)
+}
+
+func unzstdData(p []byte, d *ZstdDict, l *DecompressLimits) ([]byte, error) {
+	if l != nil {
+		return limitedData(l.AppendUnzstdBytes(nil, p, d))
+	}
+	return AppendUnzstdBytesDict(nil, p, d)
+}
+
+// BodyDecompress returns body data decompressed according to
+// 'Content-Encoding' request header.
+//
+// gzip, deflate, br and zstd encodings are supported. Body is returned as is
+// if the request has no 'Content-Encoding' header. *DecompressLimitError
+// is returned if the decompressed body exceeds l, so this method may be
+// used for protecting servers from decompression bombs.
+func (req *Request) BodyDecompress(l *DecompressLimits) ([]byte, error) {
+	return decompressData(req.Body(), req.Header.peek(strContentEncoding), l)
+}
+
+// BodyDecompress returns body data decompressed according to
+// 'Content-Encoding' response header.
+//
+// gzip, deflate, br and zstd encodings are supported. Body is returned as is
+// if the response has no 'Content-Encoding' header. *DecompressLimitError
+// is returned if the decompressed body exceeds l.
+func (resp *Response) BodyDecompress(l *DecompressLimits) ([]byte, error) {
+	return decompressData(resp.Body(), resp.Header.peek(strContentEncoding), l)
+}
+
+// decompressBody replaces gzip, deflate, br or zstd compressed body
+// with the decompressed body and removes 'Content-Encoding' header.
+//
+// The body is left as is for other encodings. ErrBodyTooLarge is returned
+// if the decompressed body exceeds maxBodySize, while *DecompressLimitError
+// is returned if it exceeds resp.decompressLimits.
+func (resp *Response) decompressBody(maxBodySize int) error {
+	ce := resp.Header.peek(strContentEncoding)
+	if !bytes.Equal(ce, strGzip) && !bytes.Equal(ce, strDeflate) && !bytes.Equal(ce, strBr) && !bytes.Equal(ce, strZstd) {
+		return nil
+	}
+	var l *DecompressLimits
+	if resp.decompressLimits != nil {
+		lCopy := *resp.decompressLimits
+		l = &lCopy
+	}
+	if maxBodySize > 0 && (l == nil || l.MaxSize <= 0 || l.MaxSize > maxBodySize) {
+		if l == nil {
+			l = &DecompressLimits{}
+		}
+		l.MaxSize = maxBodySize
+	}
+	body, err := decompressData(resp.bodyBytes(), ce, l)
+	if err != nil {
+		if e, ok := err.(*DecompressLimitError); ok && maxBodySize > 0 && e.Limit == maxBodySize {
+			return ErrBodyTooLarge
+		}
+		return err
+	}
+	resp.bodyBuffer().Set(body)
+	resp.Header.del(strContentEncoding)
+	resp.Header.SetContentLength(len(body))
+	return nil
+}
+
+func decompressData(p, contentEncoding []byte, l *DecompressLimits) ([]byte, error) {
+	switch {
+	case len(contentEncoding) == 0 || bytes.Equal(contentEncoding, strIdentity):
+		return p, nil
+	case bytes.Equal(contentEncoding, strGzip):
+		return l.AppendGunzipBytes(nil, p)
+	case bytes.Equal(contentEncoding, strDeflate):
+		return l.AppendInflateBytes(nil, p)
+	case bytes.Equal(contentEncoding, strBr):
+		return l.AppendUnbrotliBytes(nil, p)
+	case bytes.Equal(contentEncoding, strZstd):
+		return l.AppendUnzstdBytes(nil, p, nil)
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", contentEncoding)
+	}
+}
+
+// CompressBodyZstd compresses request body with zstd using the given
+// dictionary and sets 'Content-Encoding: zstd' request header.
+//
+// The dictionary isn't used if d is nil. The request body isn't compressed
+// if the request already contains 'Content-Encoding' header.
+// The server must decompress the body with the same dictionary.
+func (req *Request) CompressBodyZstd(d *ZstdDict) {
+	if len(req.Header.peek(strContentEncoding)) > 0 {
+		// It looks like the body is already compressed.
+		// Do not compress it again.
+		return
+	}
+	body := req.Body()
+	w := requestBodyPool.Get()
+	w.B = AppendZstdBytesDict(w.B, body, d)
+	req.ResetBody()
+	req.body = w
+	req.Header.SetCanonical(strContentEncoding, strZstd)
+}
+
 // BodyWriteTo writes request body to w.
 func (req *Request) BodyWriteTo(w io.Writer) error {
 	if req.bodyStream != nil {
@@ -504,6 +823,7 @@ func (resp *Response) SwapBody(body []byte) []byte {
 // It is forbidden to use the body passed to SwapBody after
 // the function returns.
 func (req *Request) SwapBody(body []byte) []byte {
+	req.resetBodyArgs()
 	bb := req.bodyBuffer()
 
 	if req.bodyStream != nil {
@@ -521,6 +841,44 @@ func (req *Request) SwapBody(body []byte) []byte {
 	return oldBody
 }
 
+// BodyHashed writes the request body to h exactly as it is sent
+// by Write and returns h.Sum(nil), e.g. for signing the request.
+//
+// The body stream is read into memory, so the request may be sent
+// after hashing. Note that such a body is sent with Content-Length
+// instead of chunked Transfer-Encoding. h isn't reset before writing
+// the body.
+func (req *Request) BodyHashed(h hash.Hash) ([]byte, error) {
+	if err := req.readBodyStream(); err != nil {
+		return nil, err
+	}
+	body := req.bodyBytes()
+	if req.onlyMultipartForm() {
+		var err error
+		body, err = marshalMultipartForm(req.multipartForm, req.multipartFormBoundary)
+		if err != nil {
+			return nil, fmt.Errorf("error when marshaling multipart form: %s", err)
+		}
+	}
+	// hash.Hash.Write never returns errors.
+	h.Write(body)
+	return h.Sum(nil), nil
+}
+
+// readBodyStream reads the body stream into the request body.
+func (req *Request) readBodyStream() error {
+	if req.bodyStream == nil {
+		return nil
+	}
+	bodyBuf := req.bodyBuffer()
+	bodyBuf.Reset()
+	_, err := copyZeroAlloc(bodyBuf, req.bodyStream)
+	if err1 := req.closeBodyStream(); err == nil {
+		err = err1
+	}
+	return err
+}
+
 // Body returns request body.
 //
 // The returned body is valid until the request modification.
@@ -552,6 +910,7 @@ func (req *Request) AppendBody(p []byte) {
 
 // AppendBodyString appends s to request body.
 func (req *Request) AppendBodyString(s string) {
+	req.resetBodyArgs()
 	req.RemoveMultipartFormFiles()
 	req.closeBodyStream()
 	req.bodyBuffer().WriteString(s)
@@ -566,6 +925,7 @@ func (req *Request) SetBody(body []byte) {
 
 // SetBodyString sets request body.
 func (req *Request) SetBodyString(body string) {
+	req.resetBodyArgs()
 	req.RemoveMultipartFormFiles()
 	req.closeBodyStream()
 	req.bodyBuffer().SetString(body)
@@ -573,6 +933,7 @@ func (req *Request) SetBodyString(body string) {
 
 // ResetBody resets request body.
 func (req *Request) ResetBody() {
+	req.resetBodyArgs()
 	req.RemoveMultipartFormFiles()
 	req.closeBodyStream()
 	if req.body != nil {
@@ -604,7 +965,10 @@ func (req *Request) copyToSkipBody(dst *Request) {
 
 	req.postArgs.CopyTo(&dst.postArgs)
 	dst.parsedPostArgs = req.parsedPostArgs
+	dst.GetBody = req.GetBody
 	dst.isTLS = req.isTLS
+	dst.readTimeout = req.readTimeout
+	dst.writeTimeout = req.writeTimeout
 
 	// do not copy multipartForm - it will be automatically
 	// re-created on the first call to MultipartForm.
@@ -639,6 +1003,8 @@ func swapResponseBody(a, b *Response) {
 // URI returns request URI
 func (req *Request) URI() *URI {
 	req.parseURI()
+	// The caller may modify query args via the returned uri.
+	req.parsedFormArgs = false
 	return &req.uri
 }
 
@@ -654,6 +1020,8 @@ func (req *Request) parseURI() {
 // PostArgs returns POST arguments.
 func (req *Request) PostArgs() *Args {
 	req.parsePostArgs()
+	// The caller may modify the returned args.
+	req.parsedFormArgs = false
 	return &req.postArgs
 }
 
@@ -669,6 +1037,48 @@ func (req *Request) parsePostArgs() {
 	req.postArgs.ParseBytes(req.bodyBytes())
 }
 
+// FormArgs returns form arguments combined from the query string,
+// POST or PUT body and multipart form values.
+//
+// The arguments are ordered by precedence: query string arguments go
+// first, then body arguments and then multipart form values. So Peek
+// returns the value with the highest precedence, while PeekMulti returns
+// all the values for the given key. Empty values take precedence too,
+// i.e. 'foo=' in the query string hides 'foo' value in the body.
+//
+// The args are rebuilt after the request uri, query args or body change.
+// Changes to the returned args aren't reflected in the request.
+func (req *Request) FormArgs() *Args {
+	if req.parsedFormArgs {
+		return &req.formArgs
+	}
+
+	req.formArgs.Reset()
+	req.URI().QueryArgs().VisitAll(req.formArgs.AddBytesKV)
+	req.PostArgs().VisitAll(req.formArgs.AddBytesKV)
+	if mf, err := req.MultipartForm(); err == nil && len(mf.Value) > 0 {
+		keys := make([]string, 0, len(mf.Value))
+		for k := range mf.Value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range mf.Value[k] {
+				req.formArgs.Add(k, v)
+			}
+		}
+	}
+	req.parsedFormArgs = true
+	return &req.formArgs
+}
+
+// resetBodyArgs invalidates the args parsed from the request body.
+func (req *Request) resetBodyArgs() {
+	req.postArgs.Reset()
+	req.parsedPostArgs = false
+	req.parsedFormArgs = false
+}
+
 // ErrNoMultipartForm means that the request's Content-Type
 // isn't 'multipart/form-data'.
 var ErrNoMultipartForm = errors.New("request has no multipart/form-data Content-Type")
@@ -681,6 +1091,8 @@ var ErrNoMultipartForm = errors.New("request has no multipart/form-data Content-
 // RemoveMultipartFormFiles must be called after returned multipart form
 // is processed.
 func (req *Request) MultipartForm() (*multipart.Form, error) {
+	// The caller may modify the returned form values.
+	req.parsedFormArgs = false
 	if req.multipartForm != nil {
 		return req.multipartForm, nil
 	}
@@ -768,6 +1180,84 @@ func WriteMultipartForm(w io.Writer, f *multipart.Form, boundary string) error {
 	return nil
 }
 
+// MultipartFormWriter must write multipart/form-data parts to mw.
+//
+// Parts may be created via mw.WriteField, mw.CreateFormField,
+// mw.CreateFormFile and mw.CreatePart. File contents may be copied
+// from io.Reader into the part writer, so big files aren't loaded
+// into memory. The writer must not call mw.Close.
+//
+// The request isn't sent if MultipartFormWriter returns an error.
+type MultipartFormWriter func(mw *multipart.Writer) error
+
+// SetMultipartFormWriter registers the given fw for streaming
+// multipart/form-data request body with the given boundary.
+//
+// Parts written by fw are sent to the server with
+// 'Transfer-Encoding: chunked' as they are generated, so the encoded
+// body isn't held in memory. fw is called from a separate goroutine
+// when the request is written. Random boundary is generated
+// if boundary is empty.
+//
+// The client doesn't retry requests with multipart form writer,
+// since fw may be called only once.
+//
+// See also SetBodyStreamWriter.
+func (req *Request) SetMultipartFormWriter(boundary string, fw MultipartFormWriter) {
+	if len(boundary) == 0 {
+		boundary = multipart.NewWriter(nil).Boundary()
+	}
+	req.SetBodyStream(&multipartFormReader{
+		boundary: boundary,
+		fw:       fw,
+	}, -1)
+	req.Header.SetMultipartFormBoundary(boundary)
+}
+
+// multipartFormReader replays multipart form written by fw.
+//
+// fw is started on the first Read call.
+type multipartFormReader struct {
+	boundary string
+	fw       MultipartFormWriter
+	pr       *io.PipeReader
+}
+
+func (r *multipartFormReader) Read(p []byte) (int, error) {
+	if r.pr == nil {
+		r.start()
+	}
+	return r.pr.Read(p)
+}
+
+func (r *multipartFormReader) Close() error {
+	if r.pr == nil {
+		return nil
+	}
+	// Unblock fw if the body hasn't been read till the end.
+	return r.pr.Close()
+}
+
+func (r *multipartFormReader) start() {
+	pr, pw := io.Pipe()
+	r.pr = pr
+	go func() {
+		bw := bufio.NewWriter(pw)
+		mw := multipart.NewWriter(bw)
+		err := mw.SetBoundary(r.boundary)
+		if err != nil {
+			err = fmt.Errorf("cannot use form boundary %q: %s", r.boundary, err)
+		} else if err = r.fw(mw); err != nil {
+			err = fmt.Errorf("error when writing multipart form: %w", err)
+		} else if err = mw.Close(); err != nil {
+			err = fmt.Errorf("error when closing multipart form writer: %s", err)
+		} else {
+			err = bw.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+}
+
 func readMultipartForm(r io.Reader, boundary string, size, maxInMemoryFileSize int) (*multipart.Form, error) {
 	// Do not care about memory allocations here, since they are tiny
 	// compared to multipart data (aka multi-MB files) usually sent
@@ -789,6 +1279,9 @@ func readMultipartForm(r io.Reader, boundary string, size, maxInMemoryFileSize i
 func (req *Request) Reset() {
 	req.Header.Reset()
 	req.resetSkipHeader()
+	req.bodyTee = nil
+	req.bodyLengthPolicy = BodyLengthMismatchDefault
+	req.decompressLimits = nil
 }
 
 func (req *Request) resetSkipHeader() {
@@ -797,7 +1290,28 @@ func (req *Request) resetSkipHeader() {
 	req.parsedURI = false
 	req.postArgs.Reset()
 	req.parsedPostArgs = false
+	req.formArgs.Reset()
+	req.parsedFormArgs = false
+	req.GetBody = nil
 	req.isTLS = false
+	req.readTimeout = 0
+	req.writeTimeout = 0
+
+	if req.arena != nil {
+		// Header may still refer to arena memory if it isn't reset yet.
+		req.Header.h = resetArenaArgs(req.Header.h)
+		req.arena.reset()
+	}
+}
+
+// enableArena makes req storing parsed headers and args in arena.
+func (req *Request) enableArena() {
+	a := &arena{}
+	req.arena = a
+	req.Header.arena = a
+	req.uri.queryArgs.arena = a
+	req.postArgs.arena = a
+	req.formArgs.arena = a
 }
 
 // RemoveMultipartFormFiles removes multipart/form-data temporary files
@@ -814,9 +1328,17 @@ func (req *Request) RemoveMultipartFormFiles() {
 
 // Reset clears response contents.
 func (resp *Response) Reset() {
+	resp.resetKeepBodyTee()
+	resp.bodyTee = nil
+}
+
+// resetKeepBodyTee resets resp except for the tee set via SetBodyTee.
+func (resp *Response) resetKeepBodyTee() {
 	resp.Header.Reset()
 	resp.resetSkipHeader()
 	resp.SkipBody = false
+	resp.bodyLengthPolicy = BodyLengthMismatchDefault
+	resp.decompressLimits = nil
 }
 
 func (resp *Response) resetSkipHeader() {
@@ -873,14 +1395,28 @@ func (req *Request) readLimitBody(r *bufio.Reader, maxBodySize int, getOnly bool
 	// Do not reset the request here - the caller must reset it before
 	// calling this method.
 
-	err := req.Header.Read(r)
-	if err != nil {
+	if err := req.readHeader(r, getOnly); err != nil {
+		return err
+	}
+	return req.readBody(r, maxBodySize)
+}
+
+// readHeader reads the request header from r.
+func (req *Request) readHeader(r *bufio.Reader, getOnly bool) error {
+	if err := req.Header.Read(r); err != nil {
 		return err
 	}
 	if getOnly && !req.Header.IsGet() {
 		return errGetOnly
 	}
+	return nil
+}
 
+// readBody reads the body of the request with already read header from r.
+//
+// The body isn't read if the request contains 'Expect: 100-continue'
+// header.
+func (req *Request) readBody(r *bufio.Reader, maxBodySize int) error {
 	if req.Header.noBody() {
 		return nil
 	}
@@ -928,7 +1464,7 @@ func (req *Request) ContinueReadBody(r *bufio.Reader, maxBodySize int) error {
 		// This way we limit memory usage for large file uploads, since their contents
 		// is streamed into temporary files if file size exceeds defaultMaxInMemoryFileSize.
 		req.multipartFormBoundary = string(req.Header.MultipartFormBoundary())
-		if len(req.multipartFormBoundary) > 0 && len(req.Header.peek(strContentEncoding)) == 0 {
+		if len(req.multipartFormBoundary) > 0 && len(req.Header.peek(strContentEncoding)) == 0 && req.bodyTee == nil {
 			req.multipartForm, err = readMultipartForm(r, req.multipartFormBoundary, contentLength, defaultMaxInMemoryFileSize)
 			if err != nil {
 				req.Reset()
@@ -948,11 +1484,19 @@ func (req *Request) ContinueReadBody(r *bufio.Reader, maxBodySize int) error {
 
 	bodyBuf := req.bodyBuffer()
 	bodyBuf.Reset()
-	bodyBuf.B, err = readBody(r, contentLength, maxBodySize, bodyBuf.B)
+	bodyBuf.B, err = readBody(r, contentLength, maxBodySize, bodyBuf.B, &req.Header)
+	err = checkBodyLength(bodyBuf.B, contentLength, err, req.bodyLengthPolicy)
+	if err == nil && req.bodyTee != nil {
+		_, err = req.bodyTee.Write(bodyBuf.B)
+	}
 	if err != nil {
 		req.Reset()
 		return err
 	}
+	if len(bodyBuf.B) < contentLength {
+		// The client closed the connection before sending the whole body.
+		req.Header.SetConnectionClose()
+	}
 	req.Header.SetContentLength(len(bodyBuf.B))
 	return nil
 }
@@ -971,31 +1515,65 @@ func (resp *Response) Read(r *bufio.Reader) error {
 //
 // io.EOF is returned if r is closed before reading the first header byte.
 func (resp *Response) ReadLimitBody(r *bufio.Reader, maxBodySize int) error {
-	resp.resetSkipHeader()
-	err := resp.Header.Read(r)
-	if err != nil {
+	return resp.readLimitBody(r, maxBodySize, false)
+}
+
+// readLimitBody reads the response from r.
+//
+// The body delimited by connection close is left unread in r
+// if skipCloseDelimitedBody is set.
+func (resp *Response) readLimitBody(r *bufio.Reader, maxBodySize int, skipCloseDelimitedBody bool) error {
+	if err := resp.readHeader(r); err != nil {
 		return err
 	}
-	if resp.Header.StatusCode() == StatusContinue {
-		// Read the next response according to http://www.w3.org/Protocols/rfc2616/rfc2616-sec8.html .
-		if err = resp.Header.Read(r); err != nil {
-			return err
-		}
-	}
+	return resp.readBody(r, maxBodySize, skipCloseDelimitedBody)
+}
 
+// readBody reads the body of the response with already read header from r.
+//
+// See readLimitBody for details.
+func (resp *Response) readBody(r *bufio.Reader, maxBodySize int, skipCloseDelimitedBody bool) error {
+	var err error
+	if skipCloseDelimitedBody && resp.Header.ContentLength() == -2 {
+		return nil
+	}
 	if !resp.mustSkipBody() {
 		bodyBuf := resp.bodyBuffer()
 		bodyBuf.Reset()
-		bodyBuf.B, err = readBody(r, resp.Header.ContentLength(), maxBodySize, bodyBuf.B)
+		contentLength := resp.Header.ContentLength()
+		bodyBuf.B, err = readBody(r, contentLength, maxBodySize, bodyBuf.B, &resp.Header)
+		err = checkBodyLength(bodyBuf.B, contentLength, err, resp.bodyLengthPolicy)
+		if err == nil && resp.bodyTee != nil {
+			_, err = resp.bodyTee.Write(bodyBuf.B)
+		}
 		if err != nil {
-			resp.Reset()
+			// Preserve the tee for retries.
+			resp.resetKeepBodyTee()
 			return err
 		}
+		if len(bodyBuf.B) < contentLength {
+			// The server closed the connection before sending the whole body.
+			resp.Header.SetConnectionClose()
+		}
 		resp.Header.SetContentLength(len(bodyBuf.B))
 	}
 	return nil
 }
 
+// readHeader reads the response header from r, skipping
+// 100 Continue responses.
+func (resp *Response) readHeader(r *bufio.Reader) error {
+	resp.resetSkipHeader()
+	if err := resp.Header.Read(r); err != nil {
+		return err
+	}
+	if resp.Header.StatusCode() == StatusContinue {
+		// Read the next response according to http://www.w3.org/Protocols/rfc2616/rfc2616-sec8.html .
+		return resp.Header.Read(r)
+	}
+	return nil
+}
+
 func (resp *Response) mustSkipBody() bool {
 	return resp.SkipBody || resp.Header.mustSkipContentLength()
 }
@@ -1003,6 +1581,12 @@ func (resp *Response) mustSkipBody() bool {
 var errRequestHostRequired = errors.New("missing required Host header in request")
 
 // WriteTo writes request to w. It implements io.WriterTo.
+//
+// The written bytes match the bytes sent by clients, so they may be
+// stored for auditing or replaying the request later via Request.Read.
+// Clients additionally set User-Agent header if it is missing
+// and may set Accept-Encoding header if DecompressResponseBody is set.
+// The body stream is consumed by WriteTo.
 func (req *Request) WriteTo(w io.Writer) (int64, error) {
 	return writeBufio(req, w)
 }
@@ -1085,6 +1669,19 @@ func (req *Request) onlyMultipartForm() bool {
 //
 // See also WriteTo.
 func (req *Request) Write(w *bufio.Writer) error {
+	return req.write(w, nil, nil)
+}
+
+// continueFunc is called after sending the request header with
+// 'Expect: 100-continue'. The request body is sent only if it returns true.
+type continueFunc func() (bool, error)
+
+// write writes req to w.
+//
+// If waitContinue is set, w is flushed after writing the request header
+// and the non-empty body is written only if waitContinue returns true.
+// If sign is set, it is called just before writing the request header.
+func (req *Request) write(w *bufio.Writer, waitContinue continueFunc, sign RequestHook) error {
 	if len(req.Header.Host()) == 0 || req.parsedURI {
 		uri := req.URI()
 		host := uri.Host()
@@ -1096,7 +1693,7 @@ func (req *Request) Write(w *bufio.Writer) error {
 	}
 
 	if req.bodyStream != nil {
-		return req.writeBodyStream(w)
+		return req.writeBodyStream(w, waitContinue, sign)
 	}
 
 	body := req.bodyBytes()
@@ -1110,13 +1707,30 @@ func (req *Request) Write(w *bufio.Writer) error {
 	}
 
 	hasBody := !req.Header.noBody()
+	if len(body) == 0 {
+		waitContinue = nil
+	}
+	if hasBody && len(req.Header.trailers) > 0 {
+		// Trailers may be sent only after chunked body.
+		req.Header.SetContentLength(-1)
+		if err = req.writeHeader(w, sign); err != nil {
+			return err
+		}
+		if ok, err := continueBody(w, waitContinue); !ok {
+			return err
+		}
+		return writeBodyBytesChunked(w, body, req.Header.trailers)
+	}
 	if hasBody {
 		req.Header.SetContentLength(len(body))
 	}
-	if err = req.Header.Write(w); err != nil {
+	if err = req.writeHeader(w, sign); err != nil {
 		return err
 	}
 	if hasBody {
+		if ok, err := continueBody(w, waitContinue); !ok {
+			return err
+		}
 		_, err = w.Write(body)
 	} else if len(body) > 0 {
 		return fmt.Errorf("non-zero body for non-POST request. body=%q", body)
@@ -1124,6 +1738,29 @@ func (req *Request) Write(w *bufio.Writer) error {
 	return err
 }
 
+// writeHeader calls sign if it is set and writes req header to w.
+func (req *Request) writeHeader(w *bufio.Writer, sign RequestHook) error {
+	if sign != nil {
+		if err := sign(req); err != nil {
+			return err
+		}
+	}
+	return req.Header.Write(w)
+}
+
+// continueBody flushes w and calls waitContinue if it is set.
+//
+// Returns true if the request body must be written to w.
+func continueBody(w *bufio.Writer, waitContinue continueFunc) (bool, error) {
+	if waitContinue == nil {
+		return true, nil
+	}
+	if err := w.Flush(); err != nil {
+		return false, err
+	}
+	return waitContinue()
+}
+
 // WriteGzip writes response with gzipped body to w.
 //
 // The method gzips response body and sets 'Content-Encoding: gzip'
@@ -1149,7 +1786,7 @@ func (resp *Response) WriteGzip(w *bufio.Writer) error {
 //
 // WriteGzipLevel doesn't flush response to w for performance reasons.
 func (resp *Response) WriteGzipLevel(w *bufio.Writer, level int) error {
-	if err := resp.gzipBody(level); err != nil {
+	if err := resp.gzipBody(level, nil); err != nil {
 		return err
 	}
 	return resp.Write(w)
@@ -1180,13 +1817,13 @@ func (resp *Response) WriteDeflate(w *bufio.Writer) error {
 //
 // WriteDeflateLevel doesn't flush response to w for performance reasons.
 func (resp *Response) WriteDeflateLevel(w *bufio.Writer, level int) error {
-	if err := resp.deflateBody(level); err != nil {
+	if err := resp.deflateBody(level, nil); err != nil {
 		return err
 	}
 	return resp.Write(w)
 }
 
-func (resp *Response) gzipBody(level int) error {
+func (resp *Response) gzipBody(level int, cc *connCompressors) error {
 	if len(resp.Header.peek(strContentEncoding)) > 0 {
 		// It looks like the body is already compressed.
 		// Do not compress it again.
@@ -1207,18 +1844,28 @@ func (resp *Response) gzipBody(level int) error {
 		// Do not care about memory allocations here, since gzip is slow
 		// and allocates a lot of memory by itself.
 		bs := resp.bodyStream
-		resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
-			zw := acquireStacklessGzipWriter(sw, level)
-			fw := &flushWriter{
-				wf: zw,
-				bw: sw,
-			}
-			copyZeroAlloc(fw, bs)
-			releaseStacklessGzipWriter(zw, level)
-			if bsc, ok := bs.(io.Closer); ok {
-				bsc.Close()
-			}
-		})
+		if bsw := unstartedStreamWriter(bs); bsw != nil {
+			// Compress the data generated by StreamWriter on the fly
+			// instead of proxying it via additional goroutine.
+			resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
+				zw := acquireStacklessGzipWriter(sw, level)
+				writeCompressedStream(zw, sw, bsw)
+				releaseStacklessGzipWriter(zw, level)
+			})
+		} else {
+			resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
+				zw := acquireStacklessGzipWriter(sw, level)
+				fw := &flushWriter{
+					wf: zw,
+					bw: sw,
+				}
+				copyZeroAlloc(fw, bs)
+				releaseStacklessGzipWriter(zw, level)
+				if bsc, ok := bs.(io.Closer); ok {
+					bsc.Close()
+				}
+			})
+		}
 	} else {
 		bodyBytes := resp.bodyBytes()
 		if len(bodyBytes) < minCompressLen {
@@ -1228,7 +1875,11 @@ func (resp *Response) gzipBody(level int) error {
 			return nil
 		}
 		w := responseBodyPool.Get()
-		w.B = AppendGzipBytesLevel(w.B, bodyBytes, level)
+		if cc != nil {
+			w.B = cc.appendGzipBytes(w.B, bodyBytes, level)
+		} else {
+			w.B = AppendGzipBytesLevel(w.B, bodyBytes, level)
+		}
 
 		// Hack: swap resp.body with w.
 		if resp.body != nil {
@@ -1240,7 +1891,7 @@ func (resp *Response) gzipBody(level int) error {
 	return nil
 }
 
-func (resp *Response) deflateBody(level int) error {
+func (resp *Response) deflateBody(level int, cc *connCompressors) error {
 	if len(resp.Header.peek(strContentEncoding)) > 0 {
 		// It looks like the body is already compressed.
 		// Do not compress it again.
@@ -1261,18 +1912,28 @@ func (resp *Response) deflateBody(level int) error {
 		// Do not care about memory allocations here, since flate is slow
 		// and allocates a lot of memory by itself.
 		bs := resp.bodyStream
-		resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
-			zw := acquireStacklessDeflateWriter(sw, level)
-			fw := &flushWriter{
-				wf: zw,
-				bw: sw,
-			}
-			copyZeroAlloc(fw, bs)
-			releaseStacklessDeflateWriter(zw, level)
-			if bsc, ok := bs.(io.Closer); ok {
-				bsc.Close()
-			}
-		})
+		if bsw := unstartedStreamWriter(bs); bsw != nil {
+			// Compress the data generated by StreamWriter on the fly
+			// instead of proxying it via additional goroutine.
+			resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
+				zw := acquireStacklessDeflateWriter(sw, level)
+				writeCompressedStream(zw, sw, bsw)
+				releaseStacklessDeflateWriter(zw, level)
+			})
+		} else {
+			resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
+				zw := acquireStacklessDeflateWriter(sw, level)
+				fw := &flushWriter{
+					wf: zw,
+					bw: sw,
+				}
+				copyZeroAlloc(fw, bs)
+				releaseStacklessDeflateWriter(zw, level)
+				if bsc, ok := bs.(io.Closer); ok {
+					bsc.Close()
+				}
+			})
+		}
 	} else {
 		bodyBytes := resp.bodyBytes()
 		if len(bodyBytes) < minCompressLen {
@@ -1282,7 +1943,11 @@ func (resp *Response) deflateBody(level int) error {
 			return nil
 		}
 		w := responseBodyPool.Get()
-		w.B = AppendDeflateBytesLevel(w.B, bodyBytes, level)
+		if cc != nil {
+			w.B = cc.appendDeflateBytes(w.B, bodyBytes, level)
+		} else {
+			w.B = AppendDeflateBytesLevel(w.B, bodyBytes, level)
+		}
 
 		// Hack: swap resp.body with w.
 		if resp.body != nil {
@@ -1294,9 +1959,94 @@ func (resp *Response) deflateBody(level int) error {
 	return nil
 }
 
+func (resp *Response) zstdBody(d *ZstdDict, cc *connCompressors) error {
+	if len(resp.Header.peek(strContentEncoding)) > 0 {
+		// It looks like the body is already compressed.
+		// Do not compress it again.
+		return nil
+	}
+
+	if !resp.Header.isCompressibleContentType() {
+		// The content-type cannot be compressed.
+		return nil
+	}
+
+	if resp.bodyStream != nil {
+		// Reset Content-Length to -1, since it is impossible
+		// to determine body size beforehand of streamed compression.
+		resp.Header.SetContentLength(-1)
+
+		bs := resp.bodyStream
+		bsw := unstartedStreamWriter(bs)
+		resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
+			zw := acquireZstdEncoder(d)
+			zw.Reset(sw)
+			if bsw != nil {
+				writeCompressedStream(zw, sw, bsw)
+			} else {
+				fw := &flushWriter{
+					wf: zw,
+					bw: sw,
+				}
+				copyZeroAlloc(fw, bs)
+				if bsc, ok := bs.(io.Closer); ok {
+					bsc.Close()
+				}
+			}
+			zw.Close()
+			releaseZstdEncoder(zw, d)
+		})
+	} else {
+		bodyBytes := resp.bodyBytes()
+		if len(bodyBytes) < minCompressLen && d == nil {
+			// There is no sense in spending CPU time on small body compression
+			// without dictionary, since there is a very high probability
+			// that the compressed body size will be bigger than the original
+			// body size.
+			return nil
+		}
+		w := responseBodyPool.Get()
+		if cc != nil {
+			w.B = cc.appendZstdBytes(w.B, bodyBytes, d)
+		} else {
+			w.B = AppendZstdBytesDict(w.B, bodyBytes, d)
+		}
+
+		// Hack: swap resp.body with w.
+		if resp.body != nil {
+			responseBodyPool.Put(resp.body)
+		}
+		resp.body = w
+	}
+	resp.Header.SetCanonical(strContentEncoding, strZstd)
+	return nil
+}
+
 // Bodies with sizes smaller than minCompressLen aren't compressed at all
 const minCompressLen = 200
 
+// writeCompressedStream writes the data generated by bsw to zw.
+//
+// The data is buffered in a buffer of limited size and is flushed
+// to sw via zw when the buffer is full or when bsw flushes it.
+func writeCompressedStream(zw writeFlusher, sw *bufio.Writer, bsw StreamWriter) {
+	fw := &flushWriter{
+		wf: zw,
+		bw: sw,
+	}
+	var bw *bufio.Writer
+	v := streamWriterBufPool.Get()
+	if v == nil {
+		bw = bufio.NewWriter(fw)
+	} else {
+		bw = v.(*bufio.Writer)
+		bw.Reset(fw)
+	}
+	bsw(bw)
+	bw.Flush()
+	streamWriterBufPool.Put(bw)
+}
+
 type writeFlusher interface {
 	io.Writer
 	Flush() error
@@ -1334,6 +2084,14 @@ func (resp *Response) Write(w *bufio.Writer) error {
 	}
 
 	body := resp.bodyBytes()
+	if sendBody && len(resp.Header.trailers) > 0 {
+		// Trailers may be sent only after chunked body.
+		resp.Header.SetContentLength(-1)
+		if err := resp.Header.Write(w); err != nil {
+			return err
+		}
+		return writeBodyBytesChunked(w, body, resp.Header.trailers)
+	}
 	bodyLen := len(body)
 	if sendBody || bodyLen > 0 {
 		resp.Header.SetContentLength(bodyLen)
@@ -1349,7 +2107,7 @@ func (resp *Response) Write(w *bufio.Writer) error {
 	return nil
 }
 
-func (req *Request) writeBodyStream(w *bufio.Writer) error {
+func (req *Request) writeBodyStream(w *bufio.Writer, waitContinue continueFunc, sign RequestHook) error {
 	var err error
 
 	contentLength := req.Header.ContentLength()
@@ -1365,14 +2123,22 @@ func (req *Request) writeBodyStream(w *bufio.Writer) error {
 			}
 		}
 	}
+	if contentLength == 0 {
+		waitContinue = nil
+	}
+	sendBody := false
 	if contentLength >= 0 {
-		if err = req.Header.Write(w); err == nil {
-			err = writeBodyFixedSize(w, req.bodyStream, int64(contentLength))
+		if err = req.writeHeader(w, sign); err == nil {
+			if sendBody, err = continueBody(w, waitContinue); sendBody {
+				err = writeBodyFixedSize(w, req.bodyStream, int64(contentLength))
+			}
 		}
 	} else {
 		req.Header.SetContentLength(-1)
-		if err = req.Header.Write(w); err == nil {
-			err = writeBodyChunked(w, req.bodyStream)
+		if err = req.writeHeader(w, sign); err == nil {
+			if sendBody, err = continueBody(w, waitContinue); sendBody {
+				err = writeBodyChunked(w, req.bodyStream, req.Header.peekTrailers)
+			}
 		}
 	}
 	err1 := req.closeBodyStream()
@@ -1402,10 +2168,15 @@ func (resp *Response) writeBodyStream(w *bufio.Writer, sendBody bool) error {
 		if err = resp.Header.Write(w); err == nil && sendBody {
 			err = writeBodyFixedSize(w, resp.bodyStream, int64(contentLength))
 		}
+	} else if contentLength == -2 {
+		// The body is delimited by closing the connection.
+		if err = resp.Header.Write(w); err == nil && sendBody {
+			_, err = copyZeroAlloc(w, resp.bodyStream)
+		}
 	} else {
 		resp.Header.SetContentLength(-1)
 		if err = resp.Header.Write(w); err == nil && sendBody {
-			err = writeBodyChunked(w, resp.bodyStream)
+			err = writeBodyChunked(w, resp.bodyStream, resp.Header.peekTrailers)
 		}
 	}
 	err1 := resp.closeBodyStream()
@@ -1415,6 +2186,17 @@ func (resp *Response) writeBodyStream(w *bufio.Writer, sendBody bool) error {
 	return err
 }
 
+// rewindBodyStream sets body stream of the given size obtained
+// from GetBody.
+func (req *Request) rewindBodyStream(bodySize int) error {
+	bodyStream, err := req.GetBody()
+	if err != nil {
+		return fmt.Errorf("cannot obtain request body stream: %w", err)
+	}
+	req.SetBodyStream(bodyStream, bodySize)
+	return nil
+}
+
 func (req *Request) closeBodyStream() error {
 	if req.bodyStream == nil {
 		return nil
@@ -1475,7 +2257,12 @@ type httpWriter interface {
 	Write(w *bufio.Writer) error
 }
 
-func writeBodyChunked(w *bufio.Writer, r io.Reader) error {
+// writeBodyChunked writes the body read from r in chunks to w
+// followed by the last chunk with the given trailers.
+//
+// trailers are obtained after reading r till the end, so they may be set
+// while r is read.
+func writeBodyChunked(w *bufio.Writer, r io.Reader, trailers func() []argsKV) error {
 	bufv := copyBufPool.Get().(*copyBuf)
 	buf := bufv.b[:]
 
@@ -1488,10 +2275,7 @@ func writeBodyChunked(w *bufio.Writer, r io.Reader) error {
 				panic("BUG: io.Reader returned 0, nil")
 			}
 			if err == io.EOF {
-				if err = writeChunk(w, buf[:0]); err != nil {
-					break
-				}
-				err = nil
+				err = writeLastChunk(w, trailers())
 			}
 			break
 		}
@@ -1504,6 +2288,57 @@ func writeBodyChunked(w *bufio.Writer, r io.Reader) error {
 	return err
 }
 
+// bufferBodyStream reads up to maxSize bytes from the body stream
+// with unknown size.
+//
+// The stream is replaced with the read body if it ends within maxSize
+// bytes, so the response is sent with Content-Length header. Otherwise
+// the read part is sent in front of the remaining stream.
+func (resp *Response) bufferBodyStream(maxSize int) error {
+	if resp.bodyStream == nil || resp.Header.ContentLength() != -1 || limitedReaderSize(resp.bodyStream) >= 0 {
+		return nil
+	}
+	bb := resp.bodyBuffer()
+	bb.Reset()
+	n, err := copyZeroAlloc(bb, io.LimitReader(resp.bodyStream, int64(maxSize)+1))
+	if err != nil {
+		resp.closeBodyStream()
+		return err
+	}
+	if n <= int64(maxSize) {
+		// The whole body has been read.
+		return resp.closeBodyStream()
+	}
+	resp.bodyStream = &prefixedReader{
+		prefix: bb.B,
+		r:      resp.bodyStream,
+	}
+	return nil
+}
+
+// prefixedReader reads prefix and then r.
+type prefixedReader struct {
+	prefix []byte
+	r      io.Reader
+}
+
+func (pr *prefixedReader) Read(p []byte) (int, error) {
+	if len(pr.prefix) > 0 {
+		n := copy(p, pr.prefix)
+		pr.prefix = pr.prefix[n:]
+		return n, nil
+	}
+	return pr.r.Read(p)
+}
+
+// Close closes r if it implements io.Closer.
+func (pr *prefixedReader) Close() error {
+	if c, ok := pr.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 func limitedReaderSize(r io.Reader) int64 {
 	lr, ok := r.(*io.LimitedReader)
 	if !ok {
@@ -1557,6 +2392,36 @@ var copyBufPool = sync.Pool{
 	},
 }
 
+// writeBodyBytesChunked writes body as a single chunk followed
+// by the last chunk with the given trailers to w.
+func writeBodyBytesChunked(w *bufio.Writer, body []byte, trailers []argsKV) error {
+	if len(body) > 0 {
+		writeHexInt(w, len(body))
+		w.Write(strCRLF)
+		w.Write(body)
+		w.Write(strCRLF)
+	}
+	return writeLastChunk(w, trailers)
+}
+
+// writeLastChunk writes the last chunk followed by the given trailers to w.
+func writeLastChunk(w *bufio.Writer, trailers []argsKV) error {
+	w.Write(strZeroChunk)
+	for i := range trailers {
+		kv := &trailers[i]
+		w.Write(kv.key)
+		w.Write(strColonSpace)
+		w.Write(kv.value)
+		w.Write(strCRLF)
+	}
+	_, err := w.Write(strCRLF)
+	err1 := w.Flush()
+	if err == nil {
+		err = err1
+	}
+	return err
+}
+
 func writeChunk(w *bufio.Writer, b []byte) error {
 	n := len(b)
 	writeHexInt(w, n)
@@ -1574,7 +2439,99 @@ func writeChunk(w *bufio.Writer, b []byte) error {
 // the given limit.
 var ErrBodyTooLarge = errors.New("body size exceeds the given limit")
 
-func readBody(r *bufio.Reader, contentLength int, maxBodySize int, dst []byte) ([]byte, error) {
+// BodyLengthMismatchPolicy determines how to handle bodies with the length
+// distinct from Content-Length header value.
+//
+// Bodies shorter than Content-Length are detected when the peer closes
+// the connection before sending the whole body. Bodies longer than
+// Content-Length are detected only by the client if excess bytes
+// are already received after the response, since the server cannot
+// distinguish them from pipelined requests.
+type BodyLengthMismatchPolicy int
+
+const (
+	// BodyLengthMismatchDefault returns io.ErrUnexpectedEOF on short bodies
+	// and ignores excess bytes after the body.
+	BodyLengthMismatchDefault BodyLengthMismatchPolicy = iota
+
+	// BodyLengthMismatchError returns ErrBodyLengthMismatch
+	// on body length mismatch.
+	BodyLengthMismatchError
+
+	// BodyLengthMismatchTruncate accepts short bodies as is and drops
+	// excess bytes after the body.
+	BodyLengthMismatchTruncate
+
+	// BodyLengthMismatchAccept accepts short bodies as is and appends
+	// excess bytes after the body to the body.
+	BodyLengthMismatchAccept
+)
+
+// ErrBodyLengthMismatch is returned if the body length differs
+// from Content-Length header value.
+//
+// See BodyLengthMismatchPolicy for details.
+type ErrBodyLengthMismatch struct {
+	// Expected is the body length from Content-Length header.
+	Expected int
+
+	// Actual is the number of received body bytes.
+	Actual int
+}
+
+func (e *ErrBodyLengthMismatch) Error() string {
+	return fmt.Sprintf("body length mismatch: Content-Length is %d bytes, while %d bytes received", e.Expected, e.Actual)
+}
+
+// Unwrap returns io.ErrUnexpectedEOF for short bodies.
+func (e *ErrBodyLengthMismatch) Unwrap() error {
+	if e.Actual < e.Expected {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// handleExcessBody applies resp.bodyLengthPolicy to the data
+// buffered in r after the response body.
+func (resp *Response) handleExcessBody(r *bufio.Reader) error {
+	excess := mustPeekBuffered(r)
+	switch resp.bodyLengthPolicy {
+	case BodyLengthMismatchError:
+		n := len(resp.bodyBytes())
+		return &ErrBodyLengthMismatch{
+			Expected: n,
+			Actual:   n + len(excess),
+		}
+	case BodyLengthMismatchAccept:
+		resp.AppendBody(excess)
+		resp.Header.SetContentLength(len(resp.bodyBytes()))
+	}
+	return nil
+}
+
+// checkBodyLength applies policy to the body, which has been read
+// with the given err.
+func checkBodyLength(body []byte, contentLength int, err error, policy BodyLengthMismatchPolicy) error {
+	if err != io.ErrUnexpectedEOF || contentLength < 0 {
+		return err
+	}
+	switch policy {
+	case BodyLengthMismatchDefault:
+		return err
+	case BodyLengthMismatchError:
+		return &ErrBodyLengthMismatch{
+			Expected: contentLength,
+			Actual:   len(body),
+		}
+	}
+	return nil
+}
+
+// readBody reads the body of the given contentLength from r and appends
+// it to dst.
+//
+// Trailers following the chunked body are passed to t unless it is nil.
+func readBody(r *bufio.Reader, contentLength int, maxBodySize int, dst []byte, t trailerAppender) ([]byte, error) {
 	dst = dst[:0]
 	if contentLength >= 0 {
 		if maxBodySize > 0 && contentLength > maxBodySize {
@@ -1583,7 +2540,7 @@ func readBody(r *bufio.Reader, contentLength int, maxBodySize int, dst []byte) (
 		return appendBodyFixedSize(r, dst, contentLength)
 	}
 	if contentLength == -1 {
-		return readBodyChunked(r, maxBodySize, dst)
+		return readBodyChunked(r, maxBodySize, dst, t)
 	}
 	return readBodyIdentity(r, maxBodySize, dst)
 }
@@ -1653,7 +2610,7 @@ func appendBodyFixedSize(r *bufio.Reader, dst []byte, n int) ([]byte, error) {
 	}
 }
 
-func readBodyChunked(r *bufio.Reader, maxBodySize int, dst []byte) ([]byte, error) {
+func readBodyChunked(r *bufio.Reader, maxBodySize int, dst []byte, t trailerAppender) ([]byte, error) {
 	if len(dst) > 0 {
 		panic("BUG: expected zero-length buffer")
 	}
@@ -1664,6 +2621,9 @@ func readBodyChunked(r *bufio.Reader, maxBodySize int, dst []byte) ([]byte, erro
 		if err != nil {
 			return dst, err
 		}
+		if chunkSize == 0 {
+			return dst, readTrailers(r, t)
+		}
 		if maxBodySize > 0 && len(dst)+chunkSize > maxBodySize {
 			return dst, ErrBodyTooLarge
 		}
@@ -1675,9 +2635,6 @@ func readBodyChunked(r *bufio.Reader, maxBodySize int, dst []byte) ([]byte, erro
 			return dst, fmt.Errorf("cannot find crlf at the end of chunk")
 		}
 		dst = dst[:len(dst)-strCRLFLen]
-		if chunkSize == 0 {
-			return dst, nil
-		}
 	}
 }
 
@@ -1688,14 +2645,14 @@ func parseChunkSize(r *bufio.Reader) (int, error) {
 	}
 	c, err := r.ReadByte()
 	if err != nil {
-		return -1, fmt.Errorf("cannot read '\r' char at the end of chunk size: %s", err)
+		return -1, fmt.Errorf("cannot read '\r' char at the end of chunk size: %w", err)
 	}
 	if c != '\r' {
 		return -1, fmt.Errorf("unexpected char %q at the end of chunk size. Expected %q", c, '\r')
 	}
 	c, err = r.ReadByte()
 	if err != nil {
-		return -1, fmt.Errorf("cannot read '\n' char at the end of chunk size: %s", err)
+		return -1, fmt.Errorf("cannot read '\n' char at the end of chunk size: %w", err)
 	}
 	if c != '\n' {
 		return -1, fmt.Errorf("unexpected char %q at the end of chunk size. Expected %q", c, '\n')
@@ -1703,6 +2660,23 @@ func parseChunkSize(r *bufio.Reader) (int, error) {
 	return n, nil
 }
 
+// readCRLF reads CRLF at the end of chunk from r.
+func readCRLF(r *bufio.Reader) error {
+	for _, exp := range strCRLF {
+		c, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if c != exp {
+			return fmt.Errorf("cannot find crlf at the end of chunk")
+		}
+	}
+	return nil
+}
+
 func round2(n int) int {
 	if n <= 0 {
 		return 0
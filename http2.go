@@ -0,0 +1,1705 @@
+package fasthttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/valyala/bytebufferpool"
+)
+
+// HTTP/2 client transport. See https://tools.ietf.org/html/rfc7540 .
+
+// http2Proto is the ALPN protocol id for HTTP/2 over TLS.
+const http2Proto = "h2"
+
+const http2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Frame types.
+const (
+	http2FrameData         = 0x0
+	http2FrameHeaders      = 0x1
+	http2FrameRSTStream    = 0x3
+	http2FrameSettings     = 0x4
+	http2FramePushPromise  = 0x5
+	http2FramePing         = 0x6
+	http2FrameGoAway       = 0x7
+	http2FrameWindowUpdate = 0x8
+	http2FrameContinuation = 0x9
+)
+
+// Frame flags.
+const (
+	http2FlagEndStream  = 0x1
+	http2FlagAck        = 0x1
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+)
+
+// Settings.
+const (
+	http2SettingEnablePush           = 0x2
+	http2SettingMaxConcurrentStreams = 0x3
+	http2SettingInitialWindowSize    = 0x4
+	http2SettingMaxFrameSize         = 0x5
+)
+
+// Error codes.
+const (
+	http2ErrCodeNo            = 0x0
+	http2ErrCodeProtocol      = 0x1
+	http2ErrCodeFlowControl   = 0x3
+	http2ErrCodeFrameSize     = 0x6
+	http2ErrCodeRefusedStream = 0x7
+	http2ErrCodeCancel        = 0x8
+	http2ErrCodeCompression   = 0x9
+)
+
+const (
+	http2FrameHeaderSize     = 9
+	http2DefaultMaxFrameSize = 16384
+	http2MaxFrameSizeLimit   = 1<<24 - 1
+	http2DefaultWindowSize   = 65535
+	http2MaxWindowSize       = 1<<31 - 1
+	http2MaxStreamID         = 1<<31 - 1
+
+	// http2DefaultMaxStreams limits concurrent streams until the server
+	// announces its limit.
+	http2DefaultMaxStreams = 100
+
+	// http2StreamWindowSize is the receive window for each stream.
+	http2StreamWindowSize = 1 << 20
+
+	// http2ConnWindowSize is the receive window for the connection.
+	http2ConnWindowSize = 1 << 24
+
+	// http2MaxHeaderBlockSize limits the size of the received header block.
+	http2MaxHeaderBlockSize = 1 << 20
+
+	// http2FallbackDuration is the duration HTTP/1.1 is used for
+	// after the host doesn't negotiate HTTP/2.
+	http2FallbackDuration = time.Minute
+)
+
+var (
+	errHTTP2Protocol    = errors.New("HTTP/2 protocol error")
+	errHTTP2FrameSize   = errors.New("HTTP/2 frame size error")
+	errHTTP2FlowControl = errors.New("HTTP/2 flow control error")
+	errHTTP2Idle        = errors.New("idle HTTP/2 connection closed")
+)
+
+// http2ConnError is returned when the host closes HTTP/2 connection
+// with an error code.
+type http2ConnError struct {
+	code uint32
+}
+
+func (e *http2ConnError) Error() string {
+	return fmt.Sprintf("HTTP/2 connection closed by the host with error code %d", e.code)
+}
+
+// http2StreamError is returned when the host resets HTTP/2 stream
+// with an error code.
+type http2StreamError struct {
+	code uint32
+}
+
+func (e *http2StreamError) Error() string {
+	return fmt.Sprintf("HTTP/2 stream reset by the host with error code %d", e.code)
+}
+
+// mayUseHTTP2 returns true if HTTP/2 may be used for host connections.
+func (c *HostClient) mayUseHTTP2() bool {
+	if c.IsTLS {
+		return c.EnableHTTP2
+	}
+	return c.EnableH2C
+}
+
+// acquireHTTP2Conn returns HTTP/2 connection to the host.
+//
+// nil is returned if the host doesn't support HTTP/2, so HTTP/1.1
+// connections must be used instead.
+func (c *HostClient) acquireHTTP2Conn(ctx context.Context) (*http2ClientConn, error) {
+	for {
+		c.http2Lock.Lock()
+		if cc := c.http2Conn; cc != nil {
+			if cc.canTakeNewRequest() {
+				c.http2Lock.Unlock()
+				return cc, nil
+			}
+			c.http2Conn = nil
+		}
+		if !c.http2FallbackTime.IsZero() && time.Since(c.http2FallbackTime) < http2FallbackDuration {
+			c.http2Lock.Unlock()
+			return nil, nil
+		}
+		dialing := c.http2Dialing
+		if dialing == nil {
+			c.http2Dialing = make(chan struct{})
+		}
+		c.http2Lock.Unlock()
+		if dialing == nil {
+			break
+		}
+		// Wait for the connection dialed by concurrent request,
+		// so requests are multiplexed over a single connection.
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	cc, unsupported, err := c.dialHTTP2Conn(ctx)
+	c.http2Lock.Lock()
+	c.http2Conn = cc
+	if unsupported {
+		c.http2FallbackTime = time.Now()
+	} else if err == nil {
+		c.http2FallbackTime = time.Time{}
+	}
+	close(c.http2Dialing)
+	c.http2Dialing = nil
+	c.http2Lock.Unlock()
+	return cc, err
+}
+
+// dialHTTP2Conn dials HTTP/2 connection to the host.
+//
+// unsupported is set if the host doesn't negotiate HTTP/2. The dialed
+// connection is added to idle HTTP/1.1 connections in this case.
+func (c *HostClient) dialHTTP2Conn(ctx context.Context) (cc *http2ClientConn, unsupported bool, err error) {
+	maxConns := c.MaxConns
+	if maxConns <= 0 {
+		maxConns = DefaultMaxConnsPerHost
+	}
+	startCleaner := false
+	c.connsLock.Lock()
+	if c.connsCount >= maxConns {
+		c.connsLock.Unlock()
+		return nil, false, ErrNoFreeConns
+	}
+	c.connsCount++
+	if !c.connsCleanerRun {
+		startCleaner = true
+		c.connsCleanerRun = true
+	}
+	c.connsLock.Unlock()
+
+	if startCleaner {
+		go c.connsCleaner()
+	}
+
+	conn, addr, err := c.dialHostHardCtx(ctx, true)
+	if err != nil {
+		c.decConnsCount()
+		return nil, false, err
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		timeout := c.TLSHandshakeTimeout
+		if timeout <= 0 {
+			timeout = c.WriteTimeout
+		}
+		if err = tlsHandshake(tlsConn, timeout); err != nil {
+			conn.Close()
+			c.decConnsCount()
+			return nil, false, err
+		}
+		if tlsConn.ConnectionState().NegotiatedProtocol != http2Proto {
+			hc := acquireClientConn(conn)
+			hc.addrStat = c.getAddrStat(addr)
+			c.releaseConn(hc)
+			return nil, true, nil
+		}
+	}
+	cc = newHTTP2ClientConn(c, conn)
+	if err = cc.writePreface(); err != nil {
+		conn.Close()
+		c.decConnsCount()
+		return nil, false, err
+	}
+	go cc.readLoop()
+	return cc, false, nil
+}
+
+// closeIdleHTTP2Conn closes HTTP/2 connection without active streams,
+// which is idle for more than maxIdleConnDuration at currentTime.
+func (c *HostClient) closeIdleHTTP2Conn(currentTime time.Time, maxIdleConnDuration time.Duration) {
+	c.http2Lock.Lock()
+	cc := c.http2Conn
+	c.http2Lock.Unlock()
+	if cc == nil || !cc.closeIfIdle(currentTime, maxIdleConnDuration) {
+		return
+	}
+	if c.OnConnEvicted != nil {
+		c.OnConnEvicted(cc.conn.RemoteAddr().String(), ConnEvictedIdle)
+	}
+}
+
+// doHTTP2 performs req over HTTP/2 connection cc.
+func (c *HostClient) doHTTP2(ctx context.Context, cc *http2ClientConn, req *Request, resp *Response) (bool, error) {
+	writeTimeout := c.WriteTimeout
+	if req.writeTimeout > 0 {
+		writeTimeout = req.writeTimeout
+	}
+	readTimeout := c.ReadTimeout
+	if req.readTimeout > 0 {
+		readTimeout = req.readTimeout
+	}
+
+	defaultHeaders := c.setDefaultHeaders(req)
+	userAgentOld := req.Header.UserAgent()
+	if len(userAgentOld) == 0 {
+		req.Header.userAgent = c.getClientName()
+	}
+	setAcceptEncoding := c.DecompressResponseBody && len(req.Header.peek(strAcceptEncoding)) == 0
+	if setAcceptEncoding {
+		req.Header.SetCanonical(strAcceptEncoding, strClientAcceptEncoding)
+	}
+	var startTime time.Time
+	if c.SizeStatsHandler != nil {
+		startTime = time.Now()
+	}
+	s := &http2Stream{
+		maxBodySize: c.MaxResponseBodySize,
+		skipBody:    !req.Header.IsGet() && req.Header.IsHead() || resp.SkipBody,
+		done:        make(chan struct{}),
+		written:     make(chan struct{}),
+	}
+	if (c.StreamResponseBody || req.streamResponseBody) && !s.skipBody {
+		s.headersDone = make(chan struct{})
+	}
+	retry, err := cc.roundTrip(ctx, s, req, writeTimeout, readTimeout)
+	if len(userAgentOld) == 0 {
+		req.Header.userAgent = userAgentOld
+	}
+	if setAcceptEncoding {
+		req.Header.del(strAcceptEncoding)
+	}
+	for _, key := range defaultHeaders {
+		req.Header.Del(key)
+	}
+	if err != nil {
+		return retry, err
+	}
+
+	if c.InternHeaderValues {
+		resp.Header.SetInternValues(true)
+	}
+	resp.bodyLengthPolicy = c.BodyLengthMismatchPolicy
+	resp.decompressLimits = c.DecompressLimits
+	if _, err = resp.Header.parse(s.header); err != nil {
+		// The streamed body mustn't be read then.
+		cc.resetStream(s, err)
+		return false, err
+	}
+	// HTTP/2 doesn't use Connection and Transfer-Encoding headers,
+	// so the body length is determined by the stream end.
+	resp.Header.ResetConnectionClose()
+	var bodyStream *http2BodyStream
+	responseSize := len(s.header)
+	if s.headersDone != nil {
+		// The stream is reset if the body stream is closed
+		// before reading the whole body.
+		bodyStream = &http2BodyStream{
+			cc:       cc,
+			s:        s,
+			trailers: &resp.Header,
+			tee:      resp.bodyTee,
+		}
+	} else {
+		if s.skipBody {
+			resp.SkipBody = true
+		} else {
+			resp.SetBody(s.body)
+			resp.Header.SetContentLength(len(s.body))
+			if resp.bodyTee != nil {
+				if _, err = resp.bodyTee.Write(s.body); err != nil {
+					return false, err
+				}
+			}
+			responseSize += len(s.body)
+		}
+		for i := range s.trailers {
+			kv := &s.trailers[i]
+			resp.Header.appendParsedTrailer(kv.key, kv.value)
+		}
+	}
+
+	if c.SizeStatsHandler != nil {
+		c.SizeStatsHandler(SizeStats{
+			RequestSize:  s.sentSize,
+			ResponseSize: responseSize,
+			Duration:     time.Since(startTime),
+			StatusCode:   resp.StatusCode(),
+			Label:        c.Addr,
+		})
+	}
+	if c.ValidateResponse != nil {
+		if err = c.ValidateResponse(req, resp); err != nil {
+			if bodyStream != nil {
+				bodyStream.Close()
+			}
+			return false, err
+		}
+	}
+	if bodyStream != nil {
+		resp.bodyStream = bodyStream
+		return false, c.runResponseHooks(req, resp)
+	}
+	if c.DecompressResponseBody && !resp.mustSkipBody() {
+		err = resp.decompressBody(c.MaxResponseBodySize)
+	}
+	if err == nil {
+		err = c.runResponseHooks(req, resp)
+	}
+	return false, err
+}
+
+// http2BodyStream reads the streamed response body from HTTP/2 stream.
+type http2BodyStream struct {
+	cc *http2ClientConn
+	s  *http2Stream
+
+	// trailers receives trailers following the body.
+	trailers trailerAppender
+
+	// tee receives a copy of the read body.
+	tee io.Writer
+
+	done bool
+}
+
+func (b *http2BodyStream) Read(p []byte) (int, error) {
+	if b.done || b.s == nil {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	cc, s := b.cc, b.s
+	cc.lock.Lock()
+	for len(s.body) == 0 && !s.finished {
+		cc.cond.Wait()
+	}
+	n := copy(p, s.body)
+	s.body = s.body[n:]
+	var ack int32
+	if n > 0 && !s.finished {
+		s.recvUnacked += int32(n)
+		if s.recvUnacked >= http2StreamWindowSize/2 {
+			ack = s.recvUnacked
+			s.recvUnacked = 0
+		}
+	}
+	var err error
+	if len(s.body) == 0 && s.finished {
+		err = s.err
+		if err == nil {
+			err = io.EOF
+		}
+	}
+	cc.lock.Unlock()
+
+	if ack > 0 {
+		if werr := cc.writeControlWindowUpdate(s.id, ack); werr != nil {
+			cc.closeWithError(werr)
+		}
+	}
+	if err == io.EOF {
+		for i := range s.trailers {
+			kv := &s.trailers[i]
+			b.trailers.appendParsedTrailer(kv.key, kv.value)
+		}
+	}
+	if n > 0 && b.tee != nil && (err == nil || err == io.EOF) {
+		if _, errTee := b.tee.Write(p[:n]); errTee != nil {
+			err = errTee
+		}
+	}
+	if err == io.EOF {
+		b.done = true
+	}
+	return n, err
+}
+
+// Close resets the stream if the body hasn't been read till the end.
+func (b *http2BodyStream) Close() error {
+	if b.s == nil {
+		return nil
+	}
+	b.cc.resetStream(b.s, errHTTP2BodyStreamClosed)
+	b.s = nil
+	return nil
+}
+
+var errHTTP2BodyStreamClosed = errors.New("HTTP/2 response body stream closed")
+
+// http2ClientConn multiplexes requests over a single HTTP/2 connection.
+type http2ClientConn struct {
+	c           *HostClient
+	conn        net.Conn
+	scheme      []byte
+	createdTime time.Time
+
+	// wLock serializes frame writes.
+	wLock sync.Mutex
+	bw    *bufio.Writer
+	fbuf  []byte
+
+	lock          sync.Mutex
+	cond          sync.Cond
+	streams       map[uint32]*http2Stream
+	activeStreams int
+	nextStreamID  uint32
+	maxStreams    int
+	maxFrameSize  int
+	sendWindow    int32
+
+	// peerWindowSize is the initial send window for new streams.
+	peerWindowSize int32
+	lastUseTime    time.Time
+
+	// draining is set if new streams mustn't be opened, e.g. after
+	// GOAWAY frame. The connection is closed when active streams finish.
+	draining bool
+
+	// err is set when the connection is closed.
+	err error
+
+	// The fields below are accessed only by readLoop.
+	br          *bufio.Reader
+	dec         *hpackDecoder
+	rbuf        []byte
+	recvUnacked int32
+
+	// headerStream is the id of the stream, which header block
+	// is continued by CONTINUATION frames.
+	headerStream uint32
+	headerFlags  byte
+	headerBlock  []byte
+
+	// goAwayCode is the error code from GOAWAY frame.
+	goAwayCode uint32
+}
+
+type http2Stream struct {
+	id uint32
+
+	// The fields below are protected by http2ClientConn.lock.
+	sendWindow int32
+	finished   bool
+	responded  bool
+
+	// err is set before done is closed.
+	err  error
+	done chan struct{}
+
+	// written is closed when the request is written.
+	written chan struct{}
+
+	sentSize int
+
+	// headersDone is closed when response headers are received
+	// if the response body is streamed.
+	headersDone chan struct{}
+
+	// The fields below are accessed by readLoop until done is closed.
+	// body and recvUnacked are protected by http2ClientConn.lock
+	// if the response body is streamed, since they are accessed
+	// by http2BodyStream then.
+	maxBodySize int
+	skipBody    bool
+	gotHeaders  bool
+	header      []byte
+	body        []byte
+	bodySize    int
+	trailers    []argsKV
+	recvUnacked int32
+}
+
+func newHTTP2ClientConn(c *HostClient, conn net.Conn) *http2ClientConn {
+	scheme := strHTTP
+	if c.IsTLS {
+		scheme = strHTTPS
+	}
+	readBufferSize := c.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	writeBufferSize := c.WriteBufferSize
+	if writeBufferSize <= 0 {
+		writeBufferSize = defaultWriteBufferSize
+	}
+	currentTime := time.Now()
+	cc := &http2ClientConn{
+		c:              c,
+		conn:           conn,
+		scheme:         scheme,
+		createdTime:    currentTime,
+		bw:             bufio.NewWriterSize(conn, writeBufferSize),
+		streams:        make(map[uint32]*http2Stream),
+		nextStreamID:   1,
+		maxStreams:     http2DefaultMaxStreams,
+		maxFrameSize:   http2DefaultMaxFrameSize,
+		sendWindow:     http2DefaultWindowSize,
+		peerWindowSize: http2DefaultWindowSize,
+		lastUseTime:    currentTime,
+		br:             bufio.NewReaderSize(conn, readBufferSize),
+		dec:            newHPACKDecoder(hpackDefaultTableSize),
+	}
+	cc.cond.L = &cc.lock
+	return cc
+}
+
+// canTakeNewRequest returns true if new streams may be opened on cc.
+func (cc *http2ClientConn) canTakeNewRequest() bool {
+	c := cc.c
+	cc.lock.Lock()
+	if cc.err == nil && !cc.draining && c.MaxConnDuration > 0 && time.Since(cc.createdTime) > c.MaxConnDuration {
+		cc.draining = true
+		if c.OnConnEvicted != nil {
+			defer c.OnConnEvicted(cc.conn.RemoteAddr().String(), ConnEvictedMaxConnDuration)
+		}
+		if cc.activeStreams == 0 {
+			cc.lock.Unlock()
+			cc.closeWithError(io.EOF)
+			return false
+		}
+	}
+	ok := cc.err == nil && !cc.draining
+	cc.lock.Unlock()
+	return ok
+}
+
+// closeIfIdle closes cc if it has no active streams and is idle for more
+// than maxIdleConnDuration at currentTime.
+func (cc *http2ClientConn) closeIfIdle(currentTime time.Time, maxIdleConnDuration time.Duration) bool {
+	cc.lock.Lock()
+	idle := cc.err == nil && cc.activeStreams == 0 && currentTime.Sub(cc.lastUseTime) > maxIdleConnDuration
+	if idle {
+		cc.draining = true
+	}
+	cc.lock.Unlock()
+	if idle {
+		cc.closeWithError(errHTTP2Idle)
+	}
+	return idle
+}
+
+// closeWithError closes cc and finishes its active streams.
+func (cc *http2ClientConn) closeWithError(err error) {
+	cc.lock.Lock()
+	if cc.err != nil {
+		cc.lock.Unlock()
+		return
+	}
+	cc.err = err
+	streams := make([]*http2Stream, 0, len(cc.streams))
+	for id, s := range cc.streams {
+		delete(cc.streams, id)
+		s.finished = true
+		s.err = err
+		if err == io.EOF && s.responded {
+			s.err = io.ErrUnexpectedEOF
+		}
+		streams = append(streams, s)
+	}
+	// Streams reserved via reserveStream are finished by their requests.
+	cc.activeStreams -= len(streams)
+	cc.cond.Broadcast()
+	cc.lock.Unlock()
+
+	cc.conn.Close()
+	for _, s := range streams {
+		close(s.done)
+	}
+	cc.c.decConnsCount()
+}
+
+// finishStream finishes s with the given err.
+//
+// false is returned if s is already finished.
+func (cc *http2ClientConn) finishStream(s *http2Stream, err error) bool {
+	cc.lock.Lock()
+	if s.finished {
+		cc.lock.Unlock()
+		return false
+	}
+	s.finished = true
+	s.err = err
+	if cc.streams[s.id] == s {
+		delete(cc.streams, s.id)
+	}
+	cc.activeStreams--
+	cc.lastUseTime = time.Now()
+	mustClose := cc.draining && cc.activeStreams == 0
+	cc.cond.Broadcast()
+	cc.lock.Unlock()
+
+	close(s.done)
+	if mustClose {
+		cc.closeWithError(io.EOF)
+	}
+	return true
+}
+
+// resetStream finishes s with the given err and resets it on the host.
+//
+// The stream is reset with PROTOCOL_ERROR code if err is caused
+// by malformed response. Otherwise CANCEL code is used.
+func (cc *http2ClientConn) resetStream(s *http2Stream, err error) {
+	if !cc.finishStream(s, err) || s.id == 0 {
+		return
+	}
+	code := uint32(http2ErrCodeCancel)
+	if errors.Is(err, errHTTP2Protocol) {
+		code = http2ErrCodeProtocol
+	}
+	cc.wLock.Lock()
+	werr := cc.writeRSTStream(s.id, code)
+	cc.wLock.Unlock()
+	if werr != nil {
+		cc.closeWithError(werr)
+	}
+}
+
+// roundTrip writes req to the new stream s and waits for the response.
+//
+// It returns after receiving response headers if s.headersDone is set.
+func (cc *http2ClientConn) roundTrip(ctx context.Context, s *http2Stream, req *Request, writeTimeout, readTimeout time.Duration) (bool, error) {
+	if err := cc.reserveStream(ctx); err != nil {
+		return true, err
+	}
+
+	if len(req.Header.Host()) == 0 || req.parsedURI {
+		uri := req.URI()
+		host := uri.Host()
+		if len(host) == 0 {
+			cc.finishStream(s, errRequestHostRequired)
+			return false, errRequestHostRequired
+		}
+		req.Header.SetHostBytes(host)
+		req.Header.SetRequestURIBytes(uri.RequestURI())
+	}
+	var body []byte
+	var trailers []argsKV
+	bodyStream := req.bodyStream
+	if bodyStream != nil {
+		if req.Header.ContentLength() < 0 {
+			if n := limitedReaderSize(bodyStream); n >= 0 && int64(int(n)) == n {
+				req.Header.SetContentLength(int(n))
+			}
+		}
+	} else {
+		body = req.bodyBytes()
+		if req.onlyMultipartForm() {
+			var err error
+			body, err = marshalMultipartForm(req.multipartForm, req.multipartFormBoundary)
+			if err != nil {
+				cc.finishStream(s, err)
+				return false, fmt.Errorf("error when marshaling multipart form: %s", err)
+			}
+			req.Header.SetMultipartFormBoundary(req.multipartFormBoundary)
+		}
+		if !req.Header.noBody() {
+			req.Header.SetContentLength(len(body))
+			trailers = req.Header.trailers
+		} else if len(body) > 0 {
+			err := fmt.Errorf("non-zero body for non-POST request. body=%q", body)
+			cc.finishStream(s, err)
+			return false, err
+		}
+	}
+	if sign := cc.c.SignRequest; sign != nil {
+		if err := sign(req); err != nil {
+			cc.finishStream(s, err)
+			return false, err
+		}
+	}
+
+	hb := headerBlockPool.Get()
+	// HTTP/2 requests contain only path and query in :path
+	// pseudo-header, while the request uri may be absolute.
+	hb.B = appendHTTP2RequestHeaders(hb.B[:0], &req.Header, cc.scheme, req.URI().RequestURI())
+	endStream := bodyStream == nil && len(body) == 0 && len(trailers) == 0
+	err := cc.openStream(s, hb.B, endStream, writeTimeout)
+	s.sentSize = len(hb.B)
+	headerBlockPool.Put(hb)
+	if err != nil {
+		return true, err
+	}
+
+	if ctx.Done() != nil || writeTimeout > 0 || readTimeout > 0 {
+		go cc.watchStream(ctx, s, writeTimeout, readTimeout)
+	}
+	if !endStream {
+		err = cc.writeBody(s, body, bodyStream, trailers, req.Header.peekTrailers, writeTimeout)
+	}
+	if bodyStream != nil {
+		if cerr := req.closeBodyStream(); err == nil {
+			err = cerr
+		}
+	}
+	close(s.written)
+	if err != nil {
+		cc.resetStream(s, err)
+	}
+
+	select {
+	case <-s.done:
+	case <-s.headersDone:
+		// The response body is read via http2BodyStream.
+		return false, nil
+	}
+	if s.err != nil {
+		if s.err == io.EOF {
+			// The stream hasn't been processed by the host,
+			// so it may be retried.
+			return true, io.EOF
+		}
+		if _, ok := s.err.(*http2StreamError); ok {
+			// The host has rejected the request.
+			return false, s.err
+		}
+		return !s.responded && s.err != ErrBodyTooLarge && s.err != ErrTimeout && ctx.Err() == nil, s.err
+	}
+	return false, nil
+}
+
+// reserveStream reserves a slot for new stream on cc.
+//
+// It waits until the number of active streams drops below the limit
+// announced by the host.
+func (cc *http2ClientConn) reserveStream(ctx context.Context) error {
+	var stop chan struct{}
+	cc.lock.Lock()
+	for cc.err == nil && !cc.draining && cc.activeStreams >= cc.maxStreams {
+		if err := ctx.Err(); err != nil {
+			cc.lock.Unlock()
+			if stop != nil {
+				close(stop)
+			}
+			return err
+		}
+		if stop == nil && ctx.Done() != nil {
+			stop = make(chan struct{})
+			go cc.wakeOnDone(ctx.Done(), stop)
+		}
+		cc.cond.Wait()
+	}
+	err := cc.err
+	if err != nil || cc.draining {
+		// The request may be retried over another connection.
+		err = io.EOF
+	} else {
+		cc.activeStreams++
+	}
+	cc.lock.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+	return err
+}
+
+// wakeOnDone wakes up goroutines waiting on cc.cond when done is closed.
+func (cc *http2ClientConn) wakeOnDone(done <-chan struct{}, stop <-chan struct{}) {
+	select {
+	case <-done:
+		cc.lock.Lock()
+		cc.cond.Broadcast()
+		cc.lock.Unlock()
+	case <-stop:
+	}
+}
+
+// openStream assigns id to s reserved via reserveStream and writes
+// HEADERS frame with the given header block.
+func (cc *http2ClientConn) openStream(s *http2Stream, headerBlock []byte, endStream bool, writeTimeout time.Duration) error {
+	cc.wLock.Lock()
+	cc.lock.Lock()
+	if cc.err != nil || cc.draining {
+		cc.lock.Unlock()
+		cc.wLock.Unlock()
+		cc.finishStream(s, io.EOF)
+		return io.EOF
+	}
+	s.id = cc.nextStreamID
+	cc.nextStreamID += 2
+	if cc.nextStreamID > http2MaxStreamID {
+		// Stream ids are exhausted, so new requests need new connection.
+		cc.draining = true
+	}
+	s.sendWindow = cc.peerWindowSize
+	cc.streams[s.id] = s
+	maxFrameSize := cc.maxFrameSize
+	cc.lock.Unlock()
+
+	err := cc.setWriteDeadline(writeTimeout)
+	flags := byte(http2FlagEndHeaders)
+	if endStream {
+		flags |= http2FlagEndStream
+	}
+	if err == nil {
+		err = cc.writeHeaders(s.id, flags, headerBlock, maxFrameSize)
+	}
+	if err == nil {
+		err = cc.bw.Flush()
+	}
+	cc.wLock.Unlock()
+	if err != nil {
+		cc.closeWithError(err)
+	}
+	return err
+}
+
+// writeBody writes request body and trailers to s.
+//
+// Trailers of the request with body stream are obtained after reading
+// the whole stream, so they may be set while the stream is read.
+func (cc *http2ClientConn) writeBody(s *http2Stream, body []byte, bodyStream io.Reader, trailers []argsKV, peekTrailers func() []argsKV, writeTimeout time.Duration) error {
+	if bodyStream == nil {
+		if err := cc.writeData(s, body, len(trailers) == 0, writeTimeout); err != nil {
+			return err
+		}
+	} else {
+		bufv := copyBufPool.Get().(*copyBuf)
+		defer copyBufPool.Put(bufv)
+		buf := bufv.b[:]
+		for {
+			n, err := bodyStream.Read(buf)
+			if n > 0 {
+				if werr := cc.writeData(s, buf[:n], false, writeTimeout); werr != nil {
+					return werr
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		trailers = peekTrailers()
+		if len(trailers) == 0 {
+			return cc.writeData(s, nil, true, writeTimeout)
+		}
+	}
+	if len(trailers) == 0 {
+		return nil
+	}
+
+	hb := headerBlockPool.Get()
+	defer headerBlockPool.Put(hb)
+	for i := range trailers {
+		kv := &trailers[i]
+		hb.B = hpackAppendField(hb.B, appendLowercase(nil, kv.key), kv.value)
+	}
+	cc.wLock.Lock()
+	cc.lock.Lock()
+	maxFrameSize := cc.maxFrameSize
+	cc.lock.Unlock()
+	err := cc.setWriteDeadline(writeTimeout)
+	if err == nil {
+		err = cc.writeHeaders(s.id, http2FlagEndHeaders|http2FlagEndStream, hb.B, maxFrameSize)
+	}
+	if err == nil {
+		err = cc.bw.Flush()
+	}
+	cc.wLock.Unlock()
+	if err != nil {
+		cc.closeWithError(err)
+	}
+	return err
+}
+
+// writeData writes p to s in DATA frames according to flow control windows.
+func (cc *http2ClientConn) writeData(s *http2Stream, p []byte, endStream bool, writeTimeout time.Duration) error {
+	for {
+		n, maxFrameSize, err := cc.awaitSendWindow(s, len(p))
+		if err != nil {
+			return err
+		}
+		chunk := p[:n]
+		p = p[n:]
+		end := endStream && len(p) == 0
+		cc.wLock.Lock()
+		err = cc.setWriteDeadline(writeTimeout)
+		for err == nil && (len(chunk) > 0 || end) {
+			m := len(chunk)
+			if m > maxFrameSize {
+				m = maxFrameSize
+			}
+			var flags byte
+			if end && m == len(chunk) {
+				flags = http2FlagEndStream
+			}
+			err = cc.writeFrame(http2FrameData, flags, s.id, chunk[:m])
+			chunk = chunk[m:]
+			if flags != 0 {
+				break
+			}
+		}
+		if err == nil {
+			err = cc.bw.Flush()
+		}
+		cc.wLock.Unlock()
+		if err != nil {
+			cc.closeWithError(err)
+			return err
+		}
+		s.sentSize += n
+		if len(p) == 0 {
+			return nil
+		}
+	}
+}
+
+// awaitSendWindow waits until up to want bytes may be sent to s.
+func (cc *http2ClientConn) awaitSendWindow(s *http2Stream, want int) (int, int, error) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	for {
+		if s.finished {
+			if s.err == nil {
+				// The host has responded without reading the whole body.
+				return 0, 0, errHTTP2StreamFinished
+			}
+			return 0, 0, s.err
+		}
+		n := want
+		if n > int(s.sendWindow) {
+			n = int(s.sendWindow)
+		}
+		if n > int(cc.sendWindow) {
+			n = int(cc.sendWindow)
+		}
+		if n > 0 || want == 0 {
+			s.sendWindow -= int32(n)
+			cc.sendWindow -= int32(n)
+			return n, cc.maxFrameSize, nil
+		}
+		cc.cond.Wait()
+	}
+}
+
+var errHTTP2StreamFinished = errors.New("HTTP/2 stream finished before the request body is sent")
+
+// watchStream resets s on ctx cancellation or timeout.
+//
+// ctx isn't watched after receiving headers of the streamed response,
+// while the timeout applies to the streamed body too.
+func (cc *http2ClientConn) watchStream(ctx context.Context, s *http2Stream, writeTimeout, readTimeout time.Duration) {
+	var timer *time.Timer
+	var tc <-chan time.Time
+	if writeTimeout > 0 {
+		timer = time.NewTimer(writeTimeout)
+		tc = timer.C
+	}
+	written := s.written
+	headersDone := s.headersDone
+	ctxDone := ctx.Done()
+	for {
+		select {
+		case <-s.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-headersDone:
+			headersDone = nil
+			ctxDone = nil
+		case <-ctxDone:
+			cc.resetStream(s, ctx.Err())
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-tc:
+			cc.resetStream(s, ErrTimeout)
+			return
+		case <-written:
+			written = nil
+			if timer != nil {
+				timer.Stop()
+				timer = nil
+				tc = nil
+			}
+			if readTimeout > 0 {
+				timer = time.NewTimer(readTimeout)
+				tc = timer.C
+			}
+		}
+	}
+}
+
+// setWriteDeadline sets write deadline for the frames written under wLock.
+func (cc *http2ClientConn) setWriteDeadline(timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = cc.c.WriteTimeout
+	}
+	deadline := zeroTime
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	return cc.conn.SetWriteDeadline(deadline)
+}
+
+func (cc *http2ClientConn) writePreface() error {
+	if err := cc.setWriteDeadline(0); err != nil {
+		return err
+	}
+	if _, err := cc.bw.WriteString(http2ClientPreface); err != nil {
+		return err
+	}
+	var settings [18]byte
+	binary.BigEndian.PutUint16(settings[0:], http2SettingEnablePush)
+	binary.BigEndian.PutUint32(settings[2:], 0)
+	binary.BigEndian.PutUint16(settings[6:], http2SettingInitialWindowSize)
+	binary.BigEndian.PutUint32(settings[8:], http2StreamWindowSize)
+	binary.BigEndian.PutUint16(settings[12:], http2SettingMaxFrameSize)
+	binary.BigEndian.PutUint32(settings[14:], http2DefaultMaxFrameSize)
+	if err := cc.writeFrame(http2FrameSettings, 0, 0, settings[:]); err != nil {
+		return err
+	}
+	if err := cc.writeWindowUpdate(0, http2ConnWindowSize-http2DefaultWindowSize); err != nil {
+		return err
+	}
+	return cc.bw.Flush()
+}
+
+// writeFrame writes the frame to cc.bw. It must be called under wLock.
+func (cc *http2ClientConn) writeFrame(typ, flags byte, streamID uint32, payload []byte) error {
+	var hdr [http2FrameHeaderSize]byte
+	n := len(payload)
+	hdr[0] = byte(n >> 16)
+	hdr[1] = byte(n >> 8)
+	hdr[2] = byte(n)
+	hdr[3] = typ
+	hdr[4] = flags
+	binary.BigEndian.PutUint32(hdr[5:], streamID)
+	if _, err := cc.bw.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := cc.bw.Write(payload)
+	return err
+}
+
+// writeHeaders writes the header block in HEADERS and CONTINUATION frames.
+func (cc *http2ClientConn) writeHeaders(streamID uint32, flags byte, block []byte, maxFrameSize int) error {
+	typ := byte(http2FrameHeaders)
+	endHeaders := flags & http2FlagEndHeaders
+	flags &^= http2FlagEndHeaders
+	for {
+		n := len(block)
+		f := flags
+		if n > maxFrameSize {
+			n = maxFrameSize
+		} else {
+			f |= endHeaders
+		}
+		if err := cc.writeFrame(typ, f, streamID, block[:n]); err != nil {
+			return err
+		}
+		block = block[n:]
+		if len(block) == 0 {
+			return nil
+		}
+		typ = http2FrameContinuation
+		flags = 0
+	}
+}
+
+func (cc *http2ClientConn) writeRSTStream(streamID, code uint32) error {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], code)
+	if err := cc.setWriteDeadline(0); err != nil {
+		return err
+	}
+	if err := cc.writeFrame(http2FrameRSTStream, 0, streamID, b[:]); err != nil {
+		return err
+	}
+	return cc.bw.Flush()
+}
+
+func (cc *http2ClientConn) writeWindowUpdate(streamID uint32, n int32) error {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	return cc.writeFrame(http2FrameWindowUpdate, 0, streamID, b[:])
+}
+
+// writeControl writes the control frame from readLoop.
+func (cc *http2ClientConn) writeControl(typ, flags byte, streamID uint32, payload []byte) error {
+	cc.wLock.Lock()
+	err := cc.setWriteDeadline(0)
+	if err == nil {
+		err = cc.writeFrame(typ, flags, streamID, payload)
+	}
+	if err == nil {
+		err = cc.bw.Flush()
+	}
+	cc.wLock.Unlock()
+	return err
+}
+
+func (cc *http2ClientConn) readLoop() {
+	err := cc.readFrames()
+	if errors.Is(err, errHTTP2Protocol) || errors.Is(err, errHTTP2FrameSize) ||
+		errors.Is(err, errHTTP2FlowControl) || isHPACKError(err) {
+		cc.writeGoAway(err)
+	} else if err == io.EOF && cc.goAwayCode != http2ErrCodeNo {
+		err = &http2ConnError{code: cc.goAwayCode}
+	}
+	cc.closeWithError(err)
+}
+
+func isHPACKError(err error) bool {
+	return err == errHPACKCorrupt || err == errHPACKInvalidIndex || err == errHPACKInvalidHuffman || err == errHPACKTableSize
+}
+
+// writeGoAway notifies the host about the connection error err.
+func (cc *http2ClientConn) writeGoAway(err error) {
+	code := uint32(http2ErrCodeProtocol)
+	switch {
+	case errors.Is(err, errHTTP2FrameSize):
+		code = http2ErrCodeFrameSize
+	case errors.Is(err, errHTTP2FlowControl):
+		code = http2ErrCodeFlowControl
+	case isHPACKError(err):
+		code = http2ErrCodeCompression
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[4:], code)
+	// The connection is closed anyway, so the error is ignored.
+	cc.writeControl(http2FrameGoAway, 0, 0, b[:])
+}
+
+// readFrames reads and processes frames until the connection is closed.
+func (cc *http2ClientConn) readFrames() error {
+	var hdr [http2FrameHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(cc.br, hdr[:]); err != nil {
+			return err
+		}
+		n := int(hdr[0])<<16 | int(hdr[1])<<8 | int(hdr[2])
+		typ := hdr[3]
+		flags := hdr[4]
+		streamID := binary.BigEndian.Uint32(hdr[5:]) & http2MaxStreamID
+		if n > http2DefaultMaxFrameSize {
+			return fmt.Errorf("%w: frame size %d exceeds %d", errHTTP2FrameSize, n, http2DefaultMaxFrameSize)
+		}
+		if cap(cc.rbuf) < n {
+			cc.rbuf = make([]byte, n)
+		}
+		payload := cc.rbuf[:n]
+		if _, err := io.ReadFull(cc.br, payload); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if cc.headerStream != 0 && (typ != http2FrameContinuation || streamID != cc.headerStream) {
+			return fmt.Errorf("%w: expecting CONTINUATION frame for stream %d", errHTTP2Protocol, cc.headerStream)
+		}
+		var err error
+		switch typ {
+		case http2FrameData:
+			err = cc.processData(streamID, flags, payload)
+		case http2FrameHeaders:
+			err = cc.processHeaders(streamID, flags, payload)
+		case http2FrameContinuation:
+			err = cc.processContinuation(streamID, flags, payload)
+		case http2FrameRSTStream:
+			err = cc.processRSTStream(streamID, payload)
+		case http2FrameSettings:
+			err = cc.processSettings(streamID, flags, payload)
+		case http2FramePushPromise:
+			// Server push is disabled in the client settings.
+			err = fmt.Errorf("%w: unexpected PUSH_PROMISE frame", errHTTP2Protocol)
+		case http2FramePing:
+			err = cc.processPing(streamID, flags, payload)
+		case http2FrameGoAway:
+			err = cc.processGoAway(streamID, payload)
+		case http2FrameWindowUpdate:
+			err = cc.processWindowUpdate(streamID, payload)
+		default:
+			// Unknown frames and PRIORITY frames are ignored.
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// stream returns active stream with the given id.
+//
+// nil is returned for closed streams.
+func (cc *http2ClientConn) stream(streamID uint32) *http2Stream {
+	cc.lock.Lock()
+	s := cc.streams[streamID]
+	cc.lock.Unlock()
+	return s
+}
+
+// removePadding returns payload of the padded frame without padding.
+func removePadding(flags byte, payload []byte) ([]byte, error) {
+	if flags&http2FlagPadded == 0 {
+		return payload, nil
+	}
+	if len(payload) == 0 || int(payload[0]) >= len(payload) {
+		return nil, fmt.Errorf("%w: invalid padding", errHTTP2Protocol)
+	}
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+func (cc *http2ClientConn) processData(streamID uint32, flags byte, payload []byte) error {
+	if streamID == 0 {
+		return fmt.Errorf("%w: DATA frame for stream 0", errHTTP2Protocol)
+	}
+	// Flow control accounts for the whole frame payload including padding.
+	n := int32(len(payload))
+	cc.recvUnacked += n
+	if cc.recvUnacked >= http2ConnWindowSize/2 {
+		if err := cc.writeControlWindowUpdate(0, cc.recvUnacked); err != nil {
+			return err
+		}
+		cc.recvUnacked = 0
+	}
+	data, err := removePadding(flags, payload)
+	if err != nil {
+		return err
+	}
+	s := cc.stream(streamID)
+	if s == nil {
+		return nil
+	}
+	if !s.gotHeaders {
+		cc.resetStream(s, fmt.Errorf("%w: DATA frame before response headers", errHTTP2Protocol))
+		return nil
+	}
+	if !s.skipBody {
+		if s.maxBodySize > 0 && s.bodySize+len(data) > s.maxBodySize {
+			cc.resetStream(s, ErrBodyTooLarge)
+			return nil
+		}
+		s.bodySize += len(data)
+	}
+	if s.headersDone != nil {
+		return cc.processStreamedData(s, flags, n, data)
+	}
+	if !s.skipBody {
+		s.body = append(s.body, data...)
+	}
+	if flags&http2FlagEndStream != 0 {
+		cc.finishStream(s, nil)
+		return nil
+	}
+	s.recvUnacked += n
+	if s.recvUnacked >= http2StreamWindowSize/2 {
+		if err := cc.writeControlWindowUpdate(streamID, s.recvUnacked); err != nil {
+			return err
+		}
+		s.recvUnacked = 0
+	}
+	return nil
+}
+
+// processStreamedData passes data received in the frame of size n
+// to http2BodyStream reading s.
+//
+// The stream window is updated by http2BodyStream when the data is read,
+// so the host doesn't send more data than the window allows to buffer.
+func (cc *http2ClientConn) processStreamedData(s *http2Stream, flags byte, n int32, data []byte) error {
+	cc.lock.Lock()
+	s.body = append(s.body, data...)
+	// Padding is never read, so it is acknowledged right away.
+	s.recvUnacked += n - int32(len(data))
+	ack := s.recvUnacked >= http2StreamWindowSize/2
+	if ack {
+		n = s.recvUnacked
+		s.recvUnacked = 0
+	}
+	cc.cond.Broadcast()
+	cc.lock.Unlock()
+	if flags&http2FlagEndStream != 0 {
+		cc.finishStream(s, nil)
+		return nil
+	}
+	if ack {
+		return cc.writeControlWindowUpdate(s.id, n)
+	}
+	return nil
+}
+
+func (cc *http2ClientConn) writeControlWindowUpdate(streamID uint32, n int32) error {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	return cc.writeControl(http2FrameWindowUpdate, 0, streamID, b[:])
+}
+
+func (cc *http2ClientConn) processHeaders(streamID uint32, flags byte, payload []byte) error {
+	if streamID == 0 {
+		return fmt.Errorf("%w: HEADERS frame for stream 0", errHTTP2Protocol)
+	}
+	block, err := removePadding(flags, payload)
+	if err != nil {
+		return err
+	}
+	if flags&http2FlagPriority != 0 {
+		if len(block) < 5 {
+			return fmt.Errorf("%w: too short HEADERS frame", errHTTP2FrameSize)
+		}
+		block = block[5:]
+	}
+	cc.headerBlock = append(cc.headerBlock[:0], block...)
+	cc.headerFlags = flags
+	if flags&http2FlagEndHeaders == 0 {
+		cc.headerStream = streamID
+		return nil
+	}
+	return cc.processHeaderBlock(streamID)
+}
+
+func (cc *http2ClientConn) processContinuation(streamID uint32, flags byte, payload []byte) error {
+	if cc.headerStream == 0 {
+		return fmt.Errorf("%w: unexpected CONTINUATION frame", errHTTP2Protocol)
+	}
+	if len(cc.headerBlock)+len(payload) > http2MaxHeaderBlockSize {
+		return fmt.Errorf("%w: header block exceeds %d bytes", errHTTP2Protocol, http2MaxHeaderBlockSize)
+	}
+	cc.headerBlock = append(cc.headerBlock, payload...)
+	if flags&http2FlagEndHeaders == 0 {
+		return nil
+	}
+	cc.headerStream = 0
+	return cc.processHeaderBlock(streamID)
+}
+
+// processHeaderBlock decodes the complete header block for the stream.
+//
+// The block is decoded even for closed streams in order to keep
+// HPACK decoder state in sync with the host.
+func (cc *http2ClientConn) processHeaderBlock(streamID uint32) error {
+	s := cc.stream(streamID)
+	if s == nil {
+		return cc.dec.decode(cc.headerBlock, func(name, value []byte) error {
+			return nil
+		})
+	}
+	endStream := cc.headerFlags&http2FlagEndStream != 0
+	if s.gotHeaders {
+		// Trailers.
+		if !endStream {
+			return fmt.Errorf("%w: trailers without END_STREAM flag for stream %d", errHTTP2Protocol, streamID)
+		}
+		err := cc.dec.decode(cc.headerBlock, func(name, value []byte) error {
+			if !isValidHTTP2FieldName(name) || !isValidHTTP2FieldValue(value) {
+				return errHTTP2MalformedHeaders
+			}
+			s.trailers = appendArgBytes(s.trailers, name, value)
+			return nil
+		})
+		if err == errHTTP2MalformedHeaders {
+			cc.resetStream(s, fmt.Errorf("%w: malformed trailers", errHTTP2Protocol))
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cc.finishStream(s, nil)
+		return nil
+	}
+
+	statusCode := 0
+	s.header = s.header[:0]
+	err := cc.dec.decode(cc.headerBlock, func(name, value []byte) error {
+		if len(name) > 0 && name[0] == ':' {
+			if statusCode > 0 || len(s.header) > 0 || string(name) != string(strHTTP2Status) {
+				return errHTTP2MalformedHeaders
+			}
+			n, err := ParseUint(value)
+			if err != nil || n < 100 || n > 999 {
+				return errHTTP2MalformedHeaders
+			}
+			statusCode = n
+			return nil
+		}
+		if statusCode == 0 || !isValidHTTP2FieldName(name) || !isValidHTTP2FieldValue(value) {
+			return errHTTP2MalformedHeaders
+		}
+		if isHTTP2ConnectionHeader(name) {
+			return nil
+		}
+		s.header = append(s.header, name...)
+		s.header = append(s.header, ':', ' ')
+		s.header = append(s.header, value...)
+		s.header = append(s.header, strCRLF...)
+		return nil
+	})
+	if err == errHTTP2MalformedHeaders || err == nil && statusCode == 0 {
+		cc.resetStream(s, fmt.Errorf("%w: malformed response headers", errHTTP2Protocol))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cc.lock.Lock()
+	s.responded = true
+	cc.lock.Unlock()
+	if statusCode < 200 {
+		// Informational responses are skipped.
+		if endStream {
+			cc.resetStream(s, fmt.Errorf("%w: informational response ends the stream", errHTTP2Protocol))
+		}
+		s.header = s.header[:0]
+		return nil
+	}
+	header := append(s.header[:0:0], "HTTP/1.1 "...)
+	header = AppendUint(header, statusCode)
+	header = append(header, ' ')
+	header = append(header, StatusMessage(statusCode)...)
+	header = append(header, strCRLF...)
+	header = append(header, s.header...)
+	s.header = append(header, strCRLF...)
+	s.gotHeaders = true
+	if s.headersDone != nil {
+		close(s.headersDone)
+	}
+	if endStream {
+		cc.finishStream(s, nil)
+	}
+	return nil
+}
+
+var errHTTP2MalformedHeaders = errors.New("malformed HTTP/2 headers")
+
+// isValidHTTP2FieldName returns true if name is a lowercase token.
+//
+// Pseudo-header names starting with colon aren't valid field names.
+func isValidHTTP2FieldName(name []byte) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			continue
+		}
+		switch c {
+		case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~':
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+func isValidHTTP2FieldValue(value []byte) bool {
+	for _, c := range value {
+		if c == '\r' || c == '\n' || c == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isHTTP2ConnectionHeader returns true for connection-specific headers,
+// which aren't used in HTTP/2.
+func isHTTP2ConnectionHeader(name []byte) bool {
+	switch string(name) {
+	case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+		return true
+	}
+	return false
+}
+
+func (cc *http2ClientConn) processRSTStream(streamID uint32, payload []byte) error {
+	if len(payload) != 4 {
+		return fmt.Errorf("%w: invalid RST_STREAM frame size %d", errHTTP2FrameSize, len(payload))
+	}
+	if streamID == 0 {
+		return fmt.Errorf("%w: RST_STREAM frame for stream 0", errHTTP2Protocol)
+	}
+	s := cc.stream(streamID)
+	if s == nil {
+		return nil
+	}
+	var err error
+	code := binary.BigEndian.Uint32(payload)
+	switch code {
+	case http2ErrCodeRefusedStream:
+		// The stream hasn't been processed by the host.
+		err = io.EOF
+	case http2ErrCodeNo:
+		if s.gotHeaders {
+			// The host has sent the whole response without reading
+			// the whole request body.
+			err = nil
+			break
+		}
+		fallthrough
+	default:
+		err = &http2StreamError{code: code}
+	}
+	cc.finishStream(s, err)
+	return nil
+}
+
+func (cc *http2ClientConn) processSettings(streamID uint32, flags byte, payload []byte) error {
+	if streamID != 0 {
+		return fmt.Errorf("%w: SETTINGS frame for stream %d", errHTTP2Protocol, streamID)
+	}
+	if flags&http2FlagAck != 0 {
+		if len(payload) != 0 {
+			return fmt.Errorf("%w: non-empty SETTINGS ack", errHTTP2FrameSize)
+		}
+		return nil
+	}
+	if len(payload)%6 != 0 {
+		return fmt.Errorf("%w: invalid SETTINGS frame size %d", errHTTP2FrameSize, len(payload))
+	}
+	cc.lock.Lock()
+	for b := payload; len(b) > 0; b = b[6:] {
+		id := binary.BigEndian.Uint16(b)
+		v := binary.BigEndian.Uint32(b[2:])
+		switch id {
+		case http2SettingMaxConcurrentStreams:
+			if v > http2MaxStreamID {
+				v = http2MaxStreamID
+			}
+			cc.maxStreams = int(v)
+		case http2SettingInitialWindowSize:
+			if v > http2MaxWindowSize {
+				cc.lock.Unlock()
+				return fmt.Errorf("%w: invalid initial window size %d", errHTTP2FlowControl, v)
+			}
+			delta := int32(v) - cc.peerWindowSize
+			cc.peerWindowSize = int32(v)
+			for _, s := range cc.streams {
+				s.sendWindow += delta
+			}
+		case http2SettingMaxFrameSize:
+			if v < http2DefaultMaxFrameSize || v > http2MaxFrameSizeLimit {
+				cc.lock.Unlock()
+				return fmt.Errorf("%w: invalid max frame size %d", errHTTP2Protocol, v)
+			}
+			cc.maxFrameSize = int(v)
+		}
+	}
+	cc.cond.Broadcast()
+	cc.lock.Unlock()
+	return cc.writeControl(http2FrameSettings, http2FlagAck, 0, nil)
+}
+
+func (cc *http2ClientConn) processPing(streamID uint32, flags byte, payload []byte) error {
+	if len(payload) != 8 {
+		return fmt.Errorf("%w: invalid PING frame size %d", errHTTP2FrameSize, len(payload))
+	}
+	if streamID != 0 {
+		return fmt.Errorf("%w: PING frame for stream %d", errHTTP2Protocol, streamID)
+	}
+	if flags&http2FlagAck != 0 {
+		return nil
+	}
+	return cc.writeControl(http2FramePing, http2FlagAck, 0, payload)
+}
+
+func (cc *http2ClientConn) processGoAway(streamID uint32, payload []byte) error {
+	if len(payload) < 8 {
+		return fmt.Errorf("%w: invalid GOAWAY frame size %d", errHTTP2FrameSize, len(payload))
+	}
+	if streamID != 0 {
+		return fmt.Errorf("%w: GOAWAY frame for stream %d", errHTTP2Protocol, streamID)
+	}
+	lastStreamID := binary.BigEndian.Uint32(payload) & http2MaxStreamID
+	cc.goAwayCode = binary.BigEndian.Uint32(payload[4:])
+
+	cc.lock.Lock()
+	cc.draining = true
+	var unprocessed []*http2Stream
+	for id, s := range cc.streams {
+		if id > lastStreamID {
+			unprocessed = append(unprocessed, s)
+		}
+	}
+	mustClose := cc.activeStreams == 0
+	cc.lock.Unlock()
+
+	// Streams not processed by the host may be retried.
+	for _, s := range unprocessed {
+		cc.finishStream(s, io.EOF)
+	}
+	if mustClose {
+		return io.EOF
+	}
+	return nil
+}
+
+func (cc *http2ClientConn) processWindowUpdate(streamID uint32, payload []byte) error {
+	if len(payload) != 4 {
+		return fmt.Errorf("%w: invalid WINDOW_UPDATE frame size %d", errHTTP2FrameSize, len(payload))
+	}
+	n := binary.BigEndian.Uint32(payload) & http2MaxWindowSize
+	if n == 0 {
+		if streamID == 0 {
+			return fmt.Errorf("%w: zero window increment", errHTTP2Protocol)
+		}
+		if s := cc.stream(streamID); s != nil {
+			cc.resetStream(s, fmt.Errorf("%w: zero window increment", errHTTP2Protocol))
+		}
+		return nil
+	}
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	window := &cc.sendWindow
+	if streamID != 0 {
+		s := cc.streams[streamID]
+		if s == nil {
+			return nil
+		}
+		window = &s.sendWindow
+	}
+	if int64(*window)+int64(n) > http2MaxWindowSize {
+		return fmt.Errorf("%w: window exceeds %d", errHTTP2FlowControl, http2MaxWindowSize)
+	}
+	*window += int32(n)
+	cc.cond.Broadcast()
+	return nil
+}
+
+var headerBlockPool bytebufferpool.Pool
+
+// appendHTTP2RequestHeaders appends HPACK-encoded header block for h to dst.
+func appendHTTP2RequestHeaders(dst []byte, h *RequestHeader, scheme, path []byte) []byte {
+	dst = hpackAppendField(dst, strHTTP2Method, h.Method())
+	dst = hpackAppendField(dst, strHTTP2Scheme, scheme)
+	dst = hpackAppendField(dst, strHTTP2Authority, h.Host())
+	dst = hpackAppendField(dst, strHTTP2Path, path)
+	var name []byte
+	h.VisitAll(func(key, value []byte) {
+		name = appendLowercase(name[:0], key)
+		if string(name) == "host" || isHTTP2ConnectionHeader(name) {
+			return
+		}
+		if string(name) == string(strHTTP2TE) && !caseInsensitiveEqual(value, strTrailers) {
+			return
+		}
+		dst = hpackAppendField(dst, name, value)
+	})
+	return dst
+}
+
+func appendLowercase(dst, s []byte) []byte {
+	for _, c := range s {
+		dst = append(dst, toLowerTable[c])
+	}
+	return dst
+}
@@ -0,0 +1,859 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func newTestHTTP2Server(t *testing.T, h http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewUnstartedServer(h)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	return ts
+}
+
+func newTestHTTP2Client(ts *httptest.Server) *HostClient {
+	return &HostClient{
+		Addr:        ts.Listener.Addr().String(),
+		IsTLS:       true,
+		EnableHTTP2: true,
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+}
+
+func TestHostClientHTTP2(t *testing.T) {
+	ts := newTestHTTP2Server(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(StatusBadRequest)
+			return
+		}
+		w.Header().Set("Trailer", "X-Body-Size")
+		w.Header().Set("Content-Type", "text/foo")
+		w.Header().Add("X-Multi", "a")
+		w.Header().Add("X-Multi", "b")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "xxx"})
+		fmt.Fprintf(w, "%s %s %s %s %q %q %s", r.Proto, r.Method, r.Host, r.URL.RequestURI(),
+			r.Header.Get("X-Foo"), r.Header.Get("Cookie"), r.Header.Get("User-Agent"))
+		if len(body) > 0 {
+			fmt.Fprintf(w, " %d", len(body))
+		}
+		w.Header().Set("X-Body-Size", fmt.Sprintf("%d", len(body)))
+	})
+	defer ts.Close()
+
+	c := newTestHTTP2Client(ts)
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	req.SetRequestURI("https://foobar.com/foo?bar=baz")
+	req.Header.Set("X-Foo", "foo")
+	req.Header.SetCookie("a", "b")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	expectedBody := `HTTP/2.0 GET foobar.com /foo?bar=baz "foo" "a=b" fasthttp`
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	if resp.Header.ContentLength() != len(expectedBody) {
+		t.Fatalf("unexpected content length: %d. Expecting %d", resp.Header.ContentLength(), len(expectedBody))
+	}
+	if string(resp.Header.ContentType()) != "text/foo" {
+		t.Fatalf("unexpected content type %q. Expecting %q", resp.Header.ContentType(), "text/foo")
+	}
+	var multi []string
+	resp.Header.VisitAll(func(key, value []byte) {
+		if string(key) == "X-Multi" {
+			multi = append(multi, string(value))
+		}
+	})
+	if strings.Join(multi, ",") != "a,b" {
+		t.Fatalf("unexpected X-Multi headers %q. Expecting %q", multi, []string{"a", "b"})
+	}
+	var cookie Cookie
+	cookie.SetKey("session")
+	if !resp.Header.Cookie(&cookie) || string(cookie.Value()) != "xxx" {
+		t.Fatalf("missing session cookie in the response: %q", resp.Header.Header())
+	}
+	if v := resp.Header.PeekTrailer("X-Body-Size"); string(v) != "0" {
+		t.Fatalf("unexpected trailer %q. Expecting %q", v, "0")
+	}
+	if resp.ConnectionClose() {
+		t.Fatalf("unexpected 'Connection: close' header")
+	}
+
+	// The body exceeds the initial flow control window.
+	body := bytes.Repeat([]byte("x"), 1<<20)
+	req.Reset()
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("https://foobar.com/post")
+	req.SetBody(body)
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedBody = fmt.Sprintf(`HTTP/2.0 POST foobar.com /post "" "" fasthttp %d`, len(body))
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+
+	// Body stream with unknown size.
+	req.Reset()
+	req.Header.SetMethod("PUT")
+	req.SetRequestURI("https://foobar.com/stream")
+	req.SetBodyStream(bytes.NewReader(body), -1)
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedBody = fmt.Sprintf(`HTTP/2.0 PUT foobar.com /stream "" "" fasthttp %d`, len(body))
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+
+	// HEAD responses have no body.
+	req.Reset()
+	req.Header.SetMethod("HEAD")
+	req.SetRequestURI("https://foobar.com/head")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(resp.Body()) != 0 {
+		t.Fatalf("unexpected body for HEAD request: %q", resp.Body())
+	}
+
+	if n := c.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected connections count: %d. Expecting 1", n)
+	}
+}
+
+func TestHostClientHTTP2Concurrent(t *testing.T) {
+	ts := newTestHTTP2Server(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprintf(w, "%s %s", r.Proto, r.URL.Path)
+	})
+	defer ts.Close()
+
+	c := newTestHTTP2Client(ts)
+	var wg sync.WaitGroup
+	errCh := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			uri := fmt.Sprintf("https://foobar.com/%d", i)
+			statusCode, body, err := c.Get(nil, uri)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if expected := fmt.Sprintf("HTTP/2.0 /%d", i); statusCode != StatusOK || string(body) != expected {
+				errCh <- fmt.Errorf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, expected)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatal(err)
+	}
+
+	// Requests are multiplexed over a single connection.
+	if n := c.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected connections count: %d. Expecting 1", n)
+	}
+}
+
+func TestHostClientHTTP2Fallback(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key")
+	if err != nil {
+		t.Fatalf("cannot load TLS certificate: %s", err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatalf("cannot listen: %s", err)
+	}
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetBodyString("ok")
+		},
+	}
+	go s.Serve(ln)
+
+	c := &HostClient{
+		Addr:        ln.Addr().String(),
+		IsTLS:       true,
+		EnableHTTP2: true,
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+	for i := 0; i < 3; i++ {
+		statusCode, body, err := c.Get(nil, "https://foobar.com/")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if statusCode != StatusOK || string(body) != "ok" {
+			t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, "ok")
+		}
+	}
+	fallbackTime := c.http2FallbackTime
+	if fallbackTime.IsZero() {
+		t.Fatalf("HTTP/2 must be marked as unsupported by the host")
+	}
+	if n := c.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected connections count: %d. Expecting 1", n)
+	}
+	if n := c.IdleConns(); n != 1 {
+		t.Fatalf("unexpected idle connections: %d. Expecting 1", n)
+	}
+
+	// HTTP/2 is offered again after the fallback duration.
+	c.http2FallbackTime = fallbackTime.Add(-http2FallbackDuration)
+	if _, _, err := c.Get(nil, "https://foobar.com/"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !c.http2FallbackTime.After(fallbackTime) {
+		t.Fatalf("HTTP/2 must be offered again after the fallback duration")
+	}
+	if n := c.ConnsCount(); n != 2 {
+		t.Fatalf("unexpected connections count: %d. Expecting 2", n)
+	}
+}
+
+func TestHostClientHTTP2Timeout(t *testing.T) {
+	ts := newTestHTTP2Server(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		fmt.Fprintf(w, "ok")
+	})
+	defer ts.Close()
+
+	c := newTestHTTP2Client(ts)
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	req.SetRequestURI("https://foobar.com/slow")
+	req.SetReadTimeout(50 * time.Millisecond)
+	if err := c.Do(req, resp); err != ErrTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req.SetReadTimeout(0)
+	if err := c.DoCtx(ctx, req, resp); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.DeadlineExceeded)
+	}
+
+	// The connection remains usable after the streams are reset.
+	req.SetRequestURI("https://foobar.com/")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+	}
+	if n := c.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected connections count: %d. Expecting 1", n)
+	}
+}
+
+func TestHostClientHTTP2StreamResponseBody(t *testing.T) {
+	// The body exceeds the stream receive window, so it cannot be
+	// received without reading the body stream.
+	body := bytes.Repeat([]byte("0123456789"), 4*http2StreamWindowSize/10)
+	ts := newTestHTTP2Server(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Trailer")
+		w.Write(body)
+		w.Header().Set("X-Trailer", "foo")
+	})
+	defer ts.Close()
+
+	c := newTestHTTP2Client(ts)
+	c.StreamResponseBody = true
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	var tee bytes.Buffer
+	resp.SetBodyTee(&tee)
+	req.SetRequestURI("https://foobar.com/")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.bodyStream == nil {
+		t.Fatalf("expecting non-nil body stream")
+	}
+	b, err := ioutil.ReadAll(resp.bodyStream)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(b, body) {
+		t.Fatalf("unexpected body of %d bytes. Expecting %d bytes", len(b), len(body))
+	}
+	if !bytes.Equal(tee.Bytes(), body) {
+		t.Fatalf("unexpected tee body of %d bytes. Expecting %d bytes", tee.Len(), len(body))
+	}
+	if v := resp.Header.PeekTrailer("X-Trailer"); string(v) != "foo" {
+		t.Fatalf("unexpected trailer %q. Expecting %q", v, "foo")
+	}
+
+	// The stream is reset if the body isn't read till the end,
+	// while the connection remains usable.
+	resp.SetBodyTee(nil)
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := io.ReadFull(resp.bodyStream, make([]byte, 100)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Reset()
+
+	// DoWithBodyWriter streams the body over HTTP/2 too.
+	c.StreamResponseBody = false
+	var w bytes.Buffer
+	if err := c.DoWithBodyWriter(req, resp, &w); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(w.Bytes(), body) {
+		t.Fatalf("unexpected body of %d bytes. Expecting %d bytes", w.Len(), len(body))
+	}
+	if n := c.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected connections count: %d. Expecting 1", n)
+	}
+
+	// The tee receives the body read into memory too.
+	tee.Reset()
+	resp.SetBodyTee(&tee)
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(resp.Body(), body) || !bytes.Equal(tee.Bytes(), body) {
+		t.Fatalf("unexpected body of %d bytes and tee of %d bytes. Expecting %d bytes", len(resp.Body()), tee.Len(), len(body))
+	}
+}
+
+func TestHostClientHTTP2MaxResponseBodySize(t *testing.T) {
+	ts := newTestHTTP2Server(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 1<<16))
+	})
+	defer ts.Close()
+
+	c := newTestHTTP2Client(ts)
+	c.MaxResponseBodySize = 1 << 10
+	if _, _, err := c.Get(nil, "https://foobar.com/"); err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBodyTooLarge)
+	}
+	c.MaxResponseBodySize = 0
+	statusCode, body, err := c.Get(nil, "https://foobar.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK || len(body) != 1<<16 {
+		t.Fatalf("unexpected response %d with %d bytes body", statusCode, len(body))
+	}
+}
+
+func TestHostClientHTTP2IdleConn(t *testing.T) {
+	ts := newTestHTTP2Server(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ok")
+	})
+	defer ts.Close()
+
+	var evicted []ConnEvictionReason
+	var lock sync.Mutex
+	c := newTestHTTP2Client(ts)
+	c.MaxIdleConnDuration = 50 * time.Millisecond
+	c.OnConnEvicted = func(addr string, reason ConnEvictionReason) {
+		lock.Lock()
+		evicted = append(evicted, reason)
+		lock.Unlock()
+	}
+	if _, _, err := c.Get(nil, "https://foobar.com/"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := c.ConnsCount(); n != 0 {
+		t.Fatalf("unexpected connections count: %d. Expecting 0", n)
+	}
+	lock.Lock()
+	if len(evicted) != 1 || evicted[0] != ConnEvictedIdle {
+		t.Fatalf("unexpected evictions: %v. Expecting [%v]", evicted, ConnEvictedIdle)
+	}
+	lock.Unlock()
+
+	// New connection is dialed for the next request.
+	if _, _, err := c.Get(nil, "https://foobar.com/"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// testHTTP2ServerConn is a minimal HTTP/2 server connection, which allows
+// sending frames in the order not used by net/http.
+type testHTTP2ServerConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	dec  *hpackDecoder
+}
+
+func newTestHTTP2ServerConn(conn net.Conn) (*testHTTP2ServerConn, error) {
+	sc := &testHTTP2ServerConn{
+		conn: conn,
+		br:   bufio.NewReader(conn),
+		dec:  newHPACKDecoder(hpackDefaultTableSize),
+	}
+	preface := make([]byte, len(http2ClientPreface))
+	if _, err := io.ReadFull(sc.br, preface); err != nil {
+		return nil, err
+	}
+	if string(preface) != http2ClientPreface {
+		return nil, fmt.Errorf("unexpected preface %q", preface)
+	}
+	if err := sc.writeFrame(http2FrameSettings, 0, 0, nil); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+func (sc *testHTTP2ServerConn) writeFrame(typ, flags byte, streamID uint32, payload []byte) error {
+	var hdr [http2FrameHeaderSize]byte
+	hdr[0] = byte(len(payload) >> 16)
+	hdr[1] = byte(len(payload) >> 8)
+	hdr[2] = byte(len(payload))
+	hdr[3] = typ
+	hdr[4] = flags
+	binary.BigEndian.PutUint32(hdr[5:], streamID)
+	if _, err := sc.conn.Write(append(hdr[:], payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// testHTTP2Request contains pseudo-headers of the request received
+// by testHTTP2ServerConn.
+type testHTTP2Request struct {
+	streamID  uint32
+	scheme    string
+	authority string
+	path      string
+}
+
+// readRequest reads frames until the request without body is received.
+func (sc *testHTTP2ServerConn) readRequest() (*testHTTP2Request, error) {
+	var hdr [http2FrameHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(sc.br, hdr[:]); err != nil {
+			return nil, err
+		}
+		n := int(hdr[0])<<16 | int(hdr[1])<<8 | int(hdr[2])
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(sc.br, payload); err != nil {
+			return nil, err
+		}
+		typ := hdr[3]
+		flags := hdr[4]
+		streamID := binary.BigEndian.Uint32(hdr[5:])
+		switch typ {
+		case http2FrameSettings:
+			if flags&http2FlagAck == 0 {
+				if err := sc.writeFrame(http2FrameSettings, http2FlagAck, 0, nil); err != nil {
+					return nil, err
+				}
+			}
+		case http2FrameHeaders:
+			if flags&(http2FlagEndHeaders|http2FlagEndStream) != http2FlagEndHeaders|http2FlagEndStream {
+				return nil, fmt.Errorf("unexpected HEADERS flags %x", flags)
+			}
+			r := &testHTTP2Request{
+				streamID: streamID,
+			}
+			if err := sc.dec.decode(payload, func(name, value []byte) error {
+				switch string(name) {
+				case ":scheme":
+					r.scheme = string(value)
+				case ":authority":
+					r.authority = string(value)
+				case ":path":
+					r.path = string(value)
+				}
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+			return r, nil
+		}
+	}
+}
+
+// readRSTStream reads frames until RST_STREAM frame for the given stream
+// is received and returns its error code.
+func (sc *testHTTP2ServerConn) readRSTStream(streamID uint32) (uint32, error) {
+	var hdr [http2FrameHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(sc.br, hdr[:]); err != nil {
+			return 0, err
+		}
+		n := int(hdr[0])<<16 | int(hdr[1])<<8 | int(hdr[2])
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(sc.br, payload); err != nil {
+			return 0, err
+		}
+		if hdr[3] == http2FrameRSTStream && binary.BigEndian.Uint32(hdr[5:]) == streamID && n == 4 {
+			return binary.BigEndian.Uint32(payload), nil
+		}
+	}
+}
+
+func (sc *testHTTP2ServerConn) writeResponse(streamID uint32, statusCode int, body string) error {
+	var hb []byte
+	hb = hpackAppendField(hb, strHTTP2Status, AppendUint(nil, statusCode))
+	if err := sc.writeFrame(http2FrameHeaders, http2FlagEndHeaders, streamID, hb); err != nil {
+		return err
+	}
+	return sc.writeFrame(http2FrameData, http2FlagEndStream, streamID, []byte(body))
+}
+
+func (sc *testHTTP2ServerConn) writeGoAway(lastStreamID, code uint32) error {
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:], lastStreamID)
+	binary.BigEndian.PutUint32(b[4:], code)
+	return sc.writeFrame(http2FrameGoAway, 0, 0, b[:])
+}
+
+func TestHostClientHTTP2GoAway(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair("./ssl-cert-snakeoil.pem", "./ssl-cert-snakeoil.key")
+	if err != nil {
+		t.Fatalf("cannot load TLS certificate: %s", err)
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	serverErr := make(chan error, 2)
+	go func() {
+		serve := func(connNum int) error {
+			conn, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			tlsConn := tls.Server(conn, &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				NextProtos:   []string{http2Proto},
+			})
+			sc, err := newTestHTTP2ServerConn(tlsConn)
+			if err != nil {
+				return err
+			}
+			for {
+				r, err := sc.readRequest()
+				if err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return err
+				}
+				switch {
+				case connNum == 0 && r.path == "/first":
+					// Respond and ask the client to use new connection.
+					if err := sc.writeResponse(r.streamID, StatusOK, fmt.Sprintf("%d %s", connNum, r.path)); err != nil {
+						return err
+					}
+					if err := sc.writeGoAway(r.streamID, http2ErrCodeNo); err != nil {
+						return err
+					}
+				case connNum == 1 && r.path == "/refused":
+					var b [4]byte
+					binary.BigEndian.PutUint32(b[:], http2ErrCodeRefusedStream)
+					if err := sc.writeFrame(http2FrameRSTStream, 0, r.streamID, b[:]); err != nil {
+						return err
+					}
+				case connNum == 1 && r.path == "/reset":
+					var b [4]byte
+					binary.BigEndian.PutUint32(b[:], http2ErrCodeCancel)
+					if err := sc.writeFrame(http2FrameRSTStream, 0, r.streamID, b[:]); err != nil {
+						return err
+					}
+				default:
+					if err := sc.writeResponse(r.streamID, StatusOK, fmt.Sprintf("%d %s", connNum, r.path)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		for i := 0; i < 2; i++ {
+			serverErr <- serve(i)
+		}
+	}()
+
+	var dials int
+	c := &HostClient{
+		Addr:        "foobar.com",
+		IsTLS:       true,
+		EnableHTTP2: true,
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+		Dial: func(addr string) (net.Conn, error) {
+			dials++
+			return ln.Dial()
+		},
+		MaxIdempotentRequestAttempts: 3,
+	}
+	for _, path := range []string{"/first", "/second", "/third"} {
+		statusCode, body, err := c.Get(nil, "https://foobar.com"+path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		connNum := 0
+		if path != "/first" {
+			connNum = 1
+		}
+		if expected := fmt.Sprintf("%d %s", connNum, path); statusCode != StatusOK || string(body) != expected {
+			t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, expected)
+		}
+	}
+	if dials != 2 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 2", dials)
+	}
+
+	// Refused streams are retried, since they aren't processed.
+	_, _, err = c.Get(nil, "https://foobar.com/refused")
+	if err != ErrConnectionClosed {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrConnectionClosed)
+	}
+	// Reset streams aren't retried.
+	_, _, err = c.Get(nil, "https://foobar.com/reset")
+	var se *http2StreamError
+	if !errors.As(err, &se) || se.code != http2ErrCodeCancel {
+		t.Fatalf("unexpected error: %v. Expecting stream error with code %d", err, http2ErrCodeCancel)
+	}
+	if dials != 2 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 2", dials)
+	}
+
+	if err := <-serverErr; err != nil {
+		t.Fatalf("unexpected server error: %s", err)
+	}
+}
+
+func TestHostClientH2C(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- func() error {
+			conn, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			sc, err := newTestHTTP2ServerConn(conn)
+			if err != nil {
+				return err
+			}
+			for {
+				r, err := sc.readRequest()
+				if err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return err
+				}
+				body := fmt.Sprintf("%s://%s%s", r.scheme, r.authority, r.path)
+				if err := sc.writeResponse(r.streamID, StatusOK, body); err != nil {
+					return err
+				}
+			}
+		}()
+	}()
+
+	var dials int
+	c := &HostClient{
+		Addr:      "foobar.com",
+		EnableH2C: true,
+		Dial: func(addr string) (net.Conn, error) {
+			dials++
+			return ln.Dial()
+		},
+	}
+	for i := 0; i < 3; i++ {
+		uri := fmt.Sprintf("http://foobar.com/foo?i=%d", i)
+		statusCode, body, err := c.Get(nil, uri)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if statusCode != StatusOK || string(body) != uri {
+			t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, uri)
+		}
+	}
+	if dials != 1 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 1", dials)
+	}
+	if n := c.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected number of connections: %d. Expecting 1", n)
+	}
+
+	c.closeIdleHTTP2Conn(time.Now().Add(time.Hour), time.Second)
+	if err := <-serverErr; err != nil {
+		t.Fatalf("unexpected server error: %s", err)
+	}
+}
+
+func TestClientH2C(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- func() error {
+			conn, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			sc, err := newTestHTTP2ServerConn(conn)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < 2; i++ {
+				r, err := sc.readRequest()
+				if err != nil {
+					return err
+				}
+				body := fmt.Sprintf("%s://%s%s", r.scheme, r.authority, r.path)
+				if err := sc.writeResponse(r.streamID, StatusOK, body); err != nil {
+					return err
+				}
+			}
+			return nil
+		}()
+	}()
+
+	c := &Client{
+		EnableH2C: true,
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	for i := 0; i < 2; i++ {
+		uri := fmt.Sprintf("http://foobar.com/foo?i=%d", i)
+		statusCode, body, err := c.Get(nil, uri)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if statusCode != StatusOK || string(body) != uri {
+			t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, uri)
+		}
+	}
+	if err := <-serverErr; err != nil {
+		t.Fatalf("unexpected server error: %s", err)
+	}
+}
+
+func TestHostClientHTTP2MalformedHeaderNames(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	names := []string{"X-Upper", "bad name", "foo\r\nbar", "foo\x00", "foo:bar", ""}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- func() error {
+			conn, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			sc, err := newTestHTTP2ServerConn(conn)
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				r, err := sc.readRequest()
+				if err != nil {
+					return err
+				}
+				var hb []byte
+				hb = hpackAppendField(hb, strHTTP2Status, []byte("200"))
+				hb = hpackAppendField(hb, []byte(name), []byte("value"))
+				if err := sc.writeFrame(http2FrameHeaders, http2FlagEndHeaders|http2FlagEndStream, r.streamID, hb); err != nil {
+					return err
+				}
+				code, err := sc.readRSTStream(r.streamID)
+				if err != nil {
+					return err
+				}
+				if code != http2ErrCodeProtocol {
+					return fmt.Errorf("unexpected RST_STREAM code for %q: %d. Expecting %d", name, code, http2ErrCodeProtocol)
+				}
+			}
+			// The connection remains usable after the stream reset.
+			r, err := sc.readRequest()
+			if err != nil {
+				return err
+			}
+			return sc.writeResponse(r.streamID, StatusOK, "ok")
+		}()
+	}()
+
+	c := &HostClient{
+		Addr:      "foobar.com",
+		EnableH2C: true,
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		MaxIdempotentRequestAttempts: 1,
+	}
+	for _, name := range names {
+		_, _, err := c.Get(nil, "http://foobar.com/")
+		if !errors.Is(err, errHTTP2Protocol) {
+			t.Fatalf("unexpected error for %q: %v. Expecting %v", name, err, errHTTP2Protocol)
+		}
+	}
+	statusCode, body, err := c.Get(nil, "http://foobar.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK || string(body) != "ok" {
+		t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, "ok")
+	}
+	if err := <-serverErr; err != nil {
+		t.Fatalf("unexpected server error: %s", err)
+	}
+}
@@ -3,6 +3,8 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -417,6 +419,83 @@ func TestRequestBodyStreamMultipleBodyCalls(t *testing.T) {
 	}
 }
 
+func TestRequestCompressBodyZstd(t *testing.T) {
+	var req Request
+	req.SetRequestURI("http://foobar.com/aaa")
+	req.Header.SetMethod("POST")
+	req.SetBodyString("foobar")
+	req.CompressBodyZstd(nil)
+	ce := req.Header.Peek("Content-Encoding")
+	if string(ce) != "zstd" {
+		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, "zstd")
+	}
+
+	s := req.String()
+	br := bufio.NewReader(bytes.NewBufferString(s))
+	var req1 Request
+	if err := req1.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body, err := req1.BodyUnzstd()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "foobar")
+	}
+
+	// an attempt to compress already compressed body
+	req.CompressBodyZstd(nil)
+	body, err = req.BodyUnzstd()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "foobar")
+	}
+}
+
+func TestRequestBodyDecompress(t *testing.T) {
+	var req Request
+	req.SetBodyString("foobar")
+	body, err := req.BodyDecompress(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != "foobar" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "foobar")
+	}
+
+	for _, encoding := range []string{"gzip", "deflate", "zstd"} {
+		req.SetBody(bytes.Repeat([]byte("foobar"), 1000))
+		switch encoding {
+		case "gzip":
+			req.SetBody(AppendGzipBytes(nil, req.Body()))
+		case "deflate":
+			req.SetBody(AppendDeflateBytes(nil, req.Body()))
+		default:
+			req.SetBody(AppendZstdBytes(nil, req.Body()))
+		}
+		req.Header.Set("Content-Encoding", encoding)
+
+		body, err := req.BodyDecompress(&DecompressLimits{MaxSize: 6000})
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", encoding, err)
+		}
+		if len(body) != 6000 {
+			t.Fatalf("unexpected body length for %s: %d. Expecting 6000", encoding, len(body))
+		}
+		if _, err = req.BodyDecompress(&DecompressLimits{MaxSize: 5999}); err == nil {
+			t.Fatalf("expecting error for %s", encoding)
+		}
+	}
+
+	req.Header.Set("Content-Encoding", "br")
+	if _, err = req.BodyDecompress(nil); err == nil {
+		t.Fatalf("expecting error for unsupported encoding")
+	}
+}
+
 func TestResponseBodyStreamMultipleBodyCalls(t *testing.T) {
 	var r Response
 
@@ -596,6 +675,59 @@ func TestRequestWriteTo(t *testing.T) {
 	}
 }
 
+func TestRequestBodyHashed(t *testing.T) {
+	var r Request
+	r.SetRequestURI("http://foobar.com/aaa")
+	r.Header.SetMethod("POST")
+	r.SetBodyStream(bytes.NewBufferString("request body"), -1)
+
+	expectedHash := sha256.Sum256([]byte("request body"))
+	h, err := r.BodyHashed(sha256.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(h, expectedHash[:]) {
+		t.Fatalf("unexpected hash %x. Expecting %x", h, expectedHash)
+	}
+
+	// The hashed body is sent after hashing.
+	var buf ByteBuffer
+	if _, err = r.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var r1 Request
+	if err = r1.Read(bufio.NewReader(bytes.NewReader(buf.B))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(r1.Body()) != "request body" {
+		t.Fatalf("unexpected body %q. Expecting %q", r1.Body(), "request body")
+	}
+
+	// Multipart form is hashed as it is sent.
+	var r2 Request
+	r2.SetRequestURI("http://foobar.com/aaa")
+	r2.Header.SetMethod("POST")
+	r2.multipartForm = &multipart.Form{
+		Value: map[string][]string{
+			"foo": {"bar"},
+		},
+	}
+	r2.multipartFormBoundary = "boundary"
+	h, err = r2.BodyHashed(sha256.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	buf.Reset()
+	if _, err = r2.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body := buf.B[bytes.Index(buf.B, []byte("\r\n\r\n"))+4:]
+	expectedHash = sha256.Sum256(body)
+	if !bytes.Equal(h, expectedHash[:]) {
+		t.Fatalf("unexpected hash %x. Expecting %x", h, expectedHash)
+	}
+}
+
 func TestResponseSkipBody(t *testing.T) {
 	var r Response
 
@@ -978,6 +1110,90 @@ func TestRequestMultipartForm(t *testing.T) {
 	testRequestMultipartForm(t, "foobar", req.Body(), 3)
 }
 
+func TestRequestSetMultipartFormWriter(t *testing.T) {
+	fileData := strings.Repeat("0123456789", 10000)
+
+	var req Request
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("http://foobar/upload")
+	req.SetMultipartFormWriter("foobar", func(mw *multipart.Writer) error {
+		if err := mw.WriteField("key", "value"); err != nil {
+			return err
+		}
+		fw, err := mw.CreateFormFile("file", "file.txt")
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, strings.NewReader(fileData))
+		return err
+	})
+	if !req.IsBodyStream() {
+		t.Fatalf("expecting body stream")
+	}
+
+	var w bytes.Buffer
+	bw := bufio.NewWriter(&w)
+	if err := req.Write(bw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := bw.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var req1 Request
+	if err := req1.Read(bufio.NewReader(&w)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(req1.Header.MultipartFormBoundary()) != "foobar" {
+		t.Fatalf("unexpected boundary %q. Expecting %q", req1.Header.MultipartFormBoundary(), "foobar")
+	}
+	f, err := req1.MultipartForm()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer req1.RemoveMultipartFormFiles()
+	if v := f.Value["key"]; len(v) != 1 || v[0] != "value" {
+		t.Fatalf("unexpected form value %q. Expecting %q", v, "value")
+	}
+	fhs := f.File["file"]
+	if len(fhs) != 1 || fhs[0].Filename != "file.txt" {
+		t.Fatalf("unexpected form file %v", fhs)
+	}
+	fh, err := fhs[0].Open()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, err := ioutil.ReadAll(fh)
+	fh.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(data) != fileData {
+		t.Fatalf("unexpected file contents with length %d. Expecting length %d", len(data), len(fileData))
+	}
+}
+
+func TestRequestSetMultipartFormWriterError(t *testing.T) {
+	var req Request
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("http://foobar/upload")
+	req.SetMultipartFormWriter("", func(mw *multipart.Writer) error {
+		if err := mw.WriteField("key", "value"); err != nil {
+			return err
+		}
+		return io.ErrUnexpectedEOF
+	})
+	if len(req.Header.MultipartFormBoundary()) == 0 {
+		t.Fatalf("expecting non-empty random boundary")
+	}
+
+	bw := bufio.NewWriter(ioutil.Discard)
+	err := req.Write(bw)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
 func testRequestMultipartForm(t *testing.T, boundary string, formData []byte, partsCount int) []byte {
 	s := fmt.Sprintf("POST / HTTP/1.1\r\nHost: aaa\r\nContent-Type: multipart/form-data; boundary=%s\r\nContent-Length: %d\r\n\r\n%s",
 		boundary, len(formData), formData)
@@ -1040,6 +1256,43 @@ func TestResponseReadLimitBody(t *testing.T) {
 	testResponseReadLimitBodyError(t, "HTTP/1.1 400 OK\r\nContent-Type: aa\r\n\r\n123456", 5)
 }
 
+func TestRequestResponseBodyTee(t *testing.T) {
+	var tee bytes.Buffer
+	var req Request
+	req.SetBodyTee(&tee)
+	br := bufio.NewReader(strings.NewReader("POST /foo HTTP/1.1\r\nHost: aaa.com\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nfoo\r\n3\r\nbar\r\n0\r\n\r\n" +
+		"POST /foo HTTP/1.1\r\nHost: aaa.com\r\nContent-Length: 3\r\n\r\nbaz"))
+	if err := req.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := req.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tee.String() != "foobarbaz" {
+		t.Fatalf("unexpected tee contents: %q. Expecting %q", tee.String(), "foobarbaz")
+	}
+	req.Reset()
+	if req.bodyTee != nil {
+		t.Fatalf("the tee must be reset")
+	}
+
+	var resp Response
+	resp.SetBodyTee(errorWriter{})
+	br = bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nfoo"))
+	if err := resp.Read(br); err == nil {
+		t.Fatalf("expecting error from the tee")
+	}
+	if resp.bodyTee == nil {
+		t.Fatalf("the tee must be preserved after the error")
+	}
+}
+
+type errorWriter struct{}
+
+func (errorWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write error")
+}
+
 func TestRequestReadLimitBody(t *testing.T) {
 	// request with content-length
 	testRequestReadLimitBodySuccess(t, "POST /foo HTTP/1.1\r\nHost: aaa.com\r\nContent-Length: 9\r\nContent-Type: aaa\r\n\r\n123456789", 9)
@@ -1052,6 +1305,66 @@ func TestRequestReadLimitBody(t *testing.T) {
 	testRequestReadLimitBodyError(t, "POST /a HTTP/1.1\r\nHost: a.com\r\nTransfer-Encoding: chunked\r\nContent-Type: aa\r\n\r\n6\r\nfoobar\r\n3\r\nbaz\r\n0\r\n\r\n", 8)
 }
 
+func TestReadBodyLengthMismatch(t *testing.T) {
+	respStr := "HTTP/1.1 200 OK\r\nContent-Type: aa\r\nContent-Length: 10\r\n\r\n12345"
+	var resp Response
+	err := resp.Read(bufio.NewReader(bytes.NewBufferString(respStr)))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected error: %v. Expecting %v by default", err, io.ErrUnexpectedEOF)
+	}
+
+	resp.bodyLengthPolicy = BodyLengthMismatchError
+	err = resp.Read(bufio.NewReader(bytes.NewBufferString(respStr)))
+	e, ok := err.(*ErrBodyLengthMismatch)
+	if !ok {
+		t.Fatalf("unexpected error: %v. Expecting ErrBodyLengthMismatch", err)
+	}
+	if e.Expected != 10 || e.Actual != 5 {
+		t.Fatalf("unexpected mismatch: expected=%d, actual=%d. Expecting expected=10, actual=5", e.Expected, e.Actual)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("short body error must wrap io.ErrUnexpectedEOF")
+	}
+
+	for _, policy := range []BodyLengthMismatchPolicy{BodyLengthMismatchTruncate, BodyLengthMismatchAccept} {
+		resp.bodyLengthPolicy = policy
+		if err := resp.Read(bufio.NewReader(bytes.NewBufferString(respStr))); err != nil {
+			t.Fatalf("unexpected error for policy %d: %s", policy, err)
+		}
+		if string(resp.Body()) != "12345" {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "12345")
+		}
+		if !resp.ConnectionClose() {
+			t.Fatalf("expecting connection close for the short body")
+		}
+	}
+
+	reqStr := "POST /foo HTTP/1.1\r\nHost: aaa.com\r\nContent-Length: 9\r\n\r\n1234"
+	var req Request
+	err = req.Read(bufio.NewReader(bytes.NewBufferString(reqStr)))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected error: %v. Expecting %v by default", err, io.ErrUnexpectedEOF)
+	}
+	req.bodyLengthPolicy = BodyLengthMismatchError
+	err = req.Read(bufio.NewReader(bytes.NewBufferString(reqStr)))
+	if _, ok := err.(*ErrBodyLengthMismatch); !ok {
+		t.Fatalf("unexpected error: %v. Expecting ErrBodyLengthMismatch", err)
+	}
+	req.bodyLengthPolicy = BodyLengthMismatchAccept
+	if err := req.Read(bufio.NewReader(bytes.NewBufferString(reqStr))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(req.Body()) != "1234" || !req.Header.ConnectionClose() {
+		t.Fatalf("unexpected request body %q or missing connection close", req.Body())
+	}
+
+	req.Reset()
+	resp.Reset()
+	if req.bodyLengthPolicy != BodyLengthMismatchDefault || resp.bodyLengthPolicy != BodyLengthMismatchDefault {
+		t.Fatalf("Reset must clear body length policy")
+	}
+}
+
 func testResponseReadLimitBodyError(t *testing.T, s string, maxBodySize int) {
 	var req Response
 	r := bytes.NewBufferString(s)
@@ -1300,6 +1613,116 @@ func TestRequestReadChunked(t *testing.T) {
 	verifyTrailer(t, rb, "trail")
 }
 
+func TestRequestReadChunkedTrailers(t *testing.T) {
+	var req Request
+
+	s := "POST /foo HTTP/1.1\r\nHost: google.com\r\nTransfer-Encoding: chunked\r\nTrailer: foo-bar, Content-Length\r\n\r\n" +
+		"3\r\nabc\r\n0\r\nfoo-bar: baz\r\nContent-Length: 123\r\nX-Checksum:  xxx \r\n\r\ntrail"
+	rb := bufio.NewReader(bytes.NewBufferString(s))
+	if err := req.Read(rb); err != nil {
+		t.Fatalf("Unexpected error when reading chunked request: %s", err)
+	}
+	if string(req.Body()) != "abc" {
+		t.Fatalf("Unexpected body %q. Expected %q", req.Body(), "abc")
+	}
+	if v := req.Header.PeekTrailer("Foo-Bar"); string(v) != "baz" {
+		t.Fatalf("Unexpected trailer value %q. Expected %q", v, "baz")
+	}
+	if v := req.Header.PeekTrailer("X-Checksum"); string(v) != "xxx" {
+		t.Fatalf("Unexpected trailer value %q. Expected %q", v, "xxx")
+	}
+	// Forbidden trailers are ignored.
+	if v := req.Header.PeekTrailer("Content-Length"); v != nil {
+		t.Fatalf("Unexpected trailer value %q. Expected nil", v)
+	}
+	if req.Header.ContentLength() != 3 {
+		t.Fatalf("Unexpected content length %d. Expected 3", req.Header.ContentLength())
+	}
+	verifyTrailer(t, rb, "trail")
+
+	// Malformed trailer.
+	s = "POST /foo HTTP/1.1\r\nHost: google.com\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\nfoobar\r\n\r\n"
+	rb = bufio.NewReader(bytes.NewBufferString(s))
+	if err := req.Read(rb); err == nil {
+		t.Fatalf("Expecting error when reading malformed trailer")
+	}
+}
+
+func TestResponseWriteTrailers(t *testing.T) {
+	var resp Response
+	resp.SetBodyString("foobar")
+	resp.Header.SetTrailer("x-checksum", "123")
+	resp.Header.SetTrailerBytesKV([]byte("X-Foo"), []byte("bar"))
+	resp.Header.SetTrailer("Content-Length", "100")
+
+	s := resp.String()
+	expectedSuffix := "Transfer-Encoding: chunked\r\nTrailer: X-Checksum, X-Foo\r\n\r\n" +
+		"6\r\nfoobar\r\n0\r\nX-Checksum: 123\r\nX-Foo: bar\r\n\r\n"
+	if !strings.HasSuffix(s, expectedSuffix) {
+		t.Fatalf("Unexpected response %q. Expecting suffix %q", s, expectedSuffix)
+	}
+
+	var resp1 Response
+	if err := resp1.Read(bufio.NewReader(bytes.NewBufferString(s))); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(resp1.Body()) != "foobar" {
+		t.Fatalf("Unexpected body %q. Expected %q", resp1.Body(), "foobar")
+	}
+	var trailers []string
+	resp1.Header.VisitAllTrailer(func(key, value []byte) {
+		trailers = append(trailers, string(key)+"="+string(value))
+	})
+	if strings.Join(trailers, ",") != "X-Checksum=123,X-Foo=bar" {
+		t.Fatalf("Unexpected trailers %q", trailers)
+	}
+
+	// Trailers are set while the body stream is written.
+	resp.Reset()
+	resp.SetBodyStreamWriter(func(w *bufio.Writer) {
+		w.WriteString("foobar")
+		resp.Header.SetTrailer("X-Checksum", "456")
+	})
+	s = resp.String()
+	expectedSuffix = "6\r\nfoobar\r\n0\r\nX-Checksum: 456\r\n\r\n"
+	if !strings.HasSuffix(s, expectedSuffix) {
+		t.Fatalf("Unexpected response %q. Expecting suffix %q", s, expectedSuffix)
+	}
+
+	resp.Reset()
+	resp.Header.SetTrailer("X-Foo", "bar")
+	resp.Header.DelTrailer("X-Foo")
+	resp.SetBodyString("foobar")
+	if s = resp.String(); strings.Contains(s, "chunked") {
+		t.Fatalf("Unexpected chunked response without trailers %q", s)
+	}
+}
+
+func TestRequestWriteTrailers(t *testing.T) {
+	var req Request
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("http://foobar.com/")
+	req.SetBodyString("abc")
+	req.Header.SetTrailer("X-Foo", "bar")
+
+	var req1 Request
+	if err := req1.Read(bufio.NewReader(bytes.NewBufferString(req.String()))); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if string(req1.Body()) != "abc" {
+		t.Fatalf("Unexpected body %q. Expected %q", req1.Body(), "abc")
+	}
+	if v := req1.Header.PeekTrailer("X-Foo"); string(v) != "bar" {
+		t.Fatalf("Unexpected trailer value %q. Expected %q", v, "bar")
+	}
+
+	var req2 Request
+	req1.CopyTo(&req2)
+	if v := req2.Header.PeekTrailer("X-Foo"); string(v) != "bar" {
+		t.Fatalf("Unexpected trailer value %q. Expected %q", v, "bar")
+	}
+}
+
 func TestResponseReadWithoutBody(t *testing.T) {
 	var resp Response
 
@@ -1742,7 +2165,7 @@ func testReadBodyChunked(t *testing.T, b []byte, bodySize int) {
 
 	r := bytes.NewBuffer(chunkedBody)
 	br := bufio.NewReader(r)
-	b, err := readBody(br, -1, 0, nil)
+	b, err := readBody(br, -1, 0, nil, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error for bodySize=%d: %s. body=%q, chunkedBody=%q", bodySize, err, body, chunkedBody)
 	}
@@ -1759,7 +2182,7 @@ func testReadBodyFixedSize(t *testing.T, b []byte, bodySize int) {
 
 	r := bytes.NewBuffer(bodyWithTrailer)
 	br := bufio.NewReader(r)
-	b, err := readBody(br, bodySize, 0, nil)
+	b, err := readBody(br, bodySize, 0, nil, nil)
 	if err != nil {
 		t.Fatalf("Unexpected error in ReadResponseBody(%d): %s", bodySize, err)
 	}
@@ -0,0 +1,122 @@
+package fasthttp
+
+import (
+	"bufio"
+	"time"
+)
+
+// JSONStreamFormat is the format of response body written
+// via RequestCtx.SetBodyStreamJSON.
+type JSONStreamFormat int
+
+const (
+	// JSONStreamNDJSON writes newline-delimited JSON with each element
+	// on a separate line.
+	JSONStreamNDJSON JSONStreamFormat = iota
+
+	// JSONStreamArray writes all the elements as a single JSON array.
+	JSONStreamArray
+)
+
+// JSONStreamFunc must write JSON elements to w.
+//
+// JSONStreamFunc must return immediately if w returns error, since this
+// means the client has been disconnected.
+type JSONStreamFunc func(w *JSONStreamWriter)
+
+// JSONStreamWriter writes JSON elements to response body.
+//
+// It is passed to JSONStreamFunc by RequestCtx.SetBodyStreamJSON.
+type JSONStreamWriter struct {
+	w             *bufio.Writer
+	format        JSONStreamFormat
+	flushInterval time.Duration
+
+	lastFlushTime time.Time
+	elements      int
+	err           error
+}
+
+// SetBodyStreamJSON streams response body as a sequence of JSON elements
+// written by f.
+//
+// Content-Type is set to application/x-ndjson for JSONStreamNDJSON format
+// and to application/json for JSONStreamArray format.
+//
+// Written elements are flushed to the client if flushInterval passed since
+// the previous flush. Each element is flushed immediately if flushInterval
+// is zero.
+//
+// Access to RequestCtx and/or its' members is forbidden from f.
+// See also SetBodyStreamWriter.
+func (ctx *RequestCtx) SetBodyStreamJSON(format JSONStreamFormat, flushInterval time.Duration, f JSONStreamFunc) {
+	if format == JSONStreamArray {
+		ctx.SetContentType("application/json")
+	} else {
+		ctx.SetContentType("application/x-ndjson")
+	}
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		jw := &JSONStreamWriter{
+			w:             w,
+			format:        format,
+			flushInterval: flushInterval,
+			lastFlushTime: time.Now(),
+		}
+		f(jw)
+		jw.finish()
+	})
+}
+
+// Write writes the given JSON-encoded element to the stream.
+//
+// element mustn't contain newlines for JSONStreamNDJSON format.
+// The element is written as is, i.e. it isn't validated.
+//
+// Non-nil error is returned if the client has been disconnected.
+// The error is returned on all the subsequent calls.
+func (jw *JSONStreamWriter) Write(element []byte) error {
+	if jw.err != nil {
+		return jw.err
+	}
+	if jw.format == JSONStreamArray {
+		if jw.elements == 0 {
+			jw.w.WriteByte('[')
+		} else {
+			jw.w.WriteByte(',')
+		}
+		jw.w.Write(element)
+	} else {
+		jw.w.Write(element)
+		jw.w.WriteByte('\n')
+	}
+	jw.elements++
+
+	if jw.flushInterval <= 0 {
+		return jw.Flush()
+	}
+	if t := time.Now(); t.Sub(jw.lastFlushTime) >= jw.flushInterval {
+		jw.lastFlushTime = t
+		return jw.Flush()
+	}
+	return nil
+}
+
+// Flush sends all the written elements to the client.
+//
+// Non-nil error is returned if the client has been disconnected.
+func (jw *JSONStreamWriter) Flush() error {
+	if jw.err == nil {
+		jw.err = jw.w.Flush()
+	}
+	return jw.err
+}
+
+func (jw *JSONStreamWriter) finish() {
+	if jw.err != nil || jw.format != JSONStreamArray {
+		return
+	}
+	if jw.elements == 0 {
+		jw.w.WriteByte('[')
+	}
+	jw.w.WriteByte(']')
+}
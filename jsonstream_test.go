@@ -0,0 +1,74 @@
+package fasthttp
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRequestCtxSetBodyStreamJSON(t *testing.T) {
+	testRequestCtxSetBodyStreamJSON(t, JSONStreamNDJSON, 0, 3, "application/x-ndjson", "{\"n\":0}\n{\"n\":1}\n{\"n\":2}\n")
+	testRequestCtxSetBodyStreamJSON(t, JSONStreamNDJSON, time.Hour, 0, "application/x-ndjson", "")
+	testRequestCtxSetBodyStreamJSON(t, JSONStreamArray, time.Hour, 3, "application/json", `[{"n":0},{"n":1},{"n":2}]`)
+	testRequestCtxSetBodyStreamJSON(t, JSONStreamArray, 0, 0, "application/json", `[]`)
+}
+
+func testRequestCtxSetBodyStreamJSON(t *testing.T, format JSONStreamFormat, flushInterval time.Duration, n int,
+	expectedContentType, expectedBody string) {
+	var ctx RequestCtx
+	var req Request
+	ctx.Init(&req, nil, nil)
+	ctx.SetBodyStreamJSON(format, flushInterval, func(w *JSONStreamWriter) {
+		for i := 0; i < n; i++ {
+			if err := w.Write([]byte(fmt.Sprintf(`{"n":%d}`, i))); err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+		}
+	})
+	if v := ctx.Response.Header.ContentType(); string(v) != expectedContentType {
+		t.Fatalf("unexpected Content-Type %q. Expecting %q", v, expectedContentType)
+	}
+	if body := ctx.Response.Body(); string(body) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+	}
+}
+
+func TestRequestCtxSetBodyStreamJSONDisconnect(t *testing.T) {
+	var ctx RequestCtx
+	var req Request
+	ctx.Init(&req, nil, nil)
+
+	ch := make(chan error, 1)
+	ctx.SetBodyStreamJSON(JSONStreamNDJSON, 0, func(w *JSONStreamWriter) {
+		for {
+			if err := w.Write([]byte(`"foobar"`)); err != nil {
+				ch <- err
+				return
+			}
+		}
+	})
+
+	// Read the first element and then emulate client disconnect.
+	r := ctx.Response.bodyStream
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(buf) != "\"foobar\"\n" {
+		t.Fatalf("unexpected element %q. Expecting %q", buf, "\"foobar\"\n")
+	}
+	if err := r.(io.Closer).Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case err := <-ch:
+		if err == nil {
+			t.Fatalf("expecting error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
@@ -0,0 +1,109 @@
+package fasthttp
+
+import (
+	"sync"
+)
+
+// MIMETypes is a registry of MIME types for file extensions.
+//
+// It is used by FS and ServeFile for detecting Content-Type of the served
+// files instead of the global state of the mime package, which depends
+// on the OS files.
+//
+// The zero value is an empty registry. It is safe calling MIMETypes methods
+// from concurrently running goroutines.
+type MIMETypes struct {
+	lock  sync.RWMutex
+	types map[string]string
+}
+
+// DefaultMIMETypes is the registry used by FS if FS.MIMETypes isn't set.
+//
+// It contains MIME types for commonly used file extensions. Additional types
+// may be registered via DefaultMIMETypes.Set.
+var DefaultMIMETypes = NewMIMETypes(defaultMIMETypes)
+
+var defaultMIMETypes = map[string]string{
+	".avif":  "image/avif",
+	".css":   "text/css; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".gif":   "image/gif",
+	".gz":    "application/gzip",
+	".htm":   "text/html; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".ico":   "image/vnd.microsoft.icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".map":   "application/json",
+	".md":    "text/markdown; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".mp3":   "audio/mpeg",
+	".mp4":   "video/mp4",
+	".ogg":   "audio/ogg",
+	".otf":   "font/otf",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".tar":   "application/x-tar",
+	".ttf":   "font/ttf",
+	".txt":   "text/plain; charset=utf-8",
+	".wasm":  "application/wasm",
+	".webm":  "video/webm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "text/xml; charset=utf-8",
+	".zip":   "application/zip",
+}
+
+// NewMIMETypes returns new registry containing the given
+// extension -> MIME type mapping.
+//
+// Extensions must start with a dot, e.g. ".html".
+func NewMIMETypes(types map[string]string) *MIMETypes {
+	var mt MIMETypes
+	for ext, contentType := range types {
+		mt.Set(ext, contentType)
+	}
+	return &mt
+}
+
+// Set registers the given MIME type for the given file extension.
+//
+// The extension must start with a dot, e.g. ".html". Extensions
+// are case-insensitive.
+func (mt *MIMETypes) Set(ext, contentType string) {
+	b := []byte(ext)
+	lowercaseBytes(b)
+	mt.lock.Lock()
+	if mt.types == nil {
+		mt.types = make(map[string]string)
+	}
+	mt.types[string(b)] = contentType
+	mt.lock.Unlock()
+}
+
+// Del removes MIME type registered for the given file extension.
+func (mt *MIMETypes) Del(ext string) {
+	b := []byte(ext)
+	lowercaseBytes(b)
+	mt.lock.Lock()
+	delete(mt.types, string(b))
+	mt.lock.Unlock()
+}
+
+// Get returns MIME type registered for the given file extension.
+//
+// Empty string is returned if there is no MIME type for the extension.
+func (mt *MIMETypes) Get(ext string) string {
+	var buf [16]byte
+	b := append(buf[:0], ext...)
+	lowercaseBytes(b)
+
+	mt.lock.RLock()
+	contentType := mt.types[string(b)]
+	mt.lock.RUnlock()
+	return contentType
+}
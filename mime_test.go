@@ -0,0 +1,37 @@
+package fasthttp
+
+import (
+	"testing"
+)
+
+func TestMIMETypes(t *testing.T) {
+	var mt MIMETypes
+	if v := mt.Get(".foo"); v != "" {
+		t.Fatalf("unexpected MIME type for empty registry: %q", v)
+	}
+
+	mt.Set(".Foo", "application/x-foo")
+	for _, ext := range []string{".foo", ".FOO", ".fOo"} {
+		if v := mt.Get(ext); v != "application/x-foo" {
+			t.Fatalf("unexpected MIME type for %q: %q. Expecting %q", ext, v, "application/x-foo")
+		}
+	}
+	mt.Del(".FOO")
+	if v := mt.Get(".foo"); v != "" {
+		t.Fatalf("unexpected MIME type after deletion: %q", v)
+	}
+
+	if v := DefaultMIMETypes.Get(".HTML"); v != "text/html; charset=utf-8" {
+		t.Fatalf("unexpected MIME type for .HTML: %q", v)
+	}
+	if v := DefaultMIMETypes.Get(".very-long-unknown-extension"); v != "" {
+		t.Fatalf("unexpected MIME type for unknown extension: %q", v)
+	}
+
+	n := testing.AllocsPerRun(100, func() {
+		DefaultMIMETypes.Get(".css")
+	})
+	if n != 0 {
+		t.Fatalf("unexpected number of allocations: %v. Expecting 0", n)
+	}
+}
@@ -0,0 +1,117 @@
+package fasthttp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Flags for length-prefixed message frames used by gRPC and gRPC-Web.
+//
+// See https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-WEB.md .
+const (
+	// MessageFrameCompressed is set if the message is compressed.
+	MessageFrameCompressed byte = 0x01
+
+	// MessageFrameTrailers is set if the frame contains trailers
+	// instead of the message.
+	MessageFrameTrailers byte = 0x80
+)
+
+// messageFrameHeaderSize is the size of flags byte plus big-endian
+// uint32 message length.
+const messageFrameHeaderSize = 5
+
+// ErrMessageFrameTooLarge is returned when the message frame exceeds
+// the given size limit.
+var ErrMessageFrameTooLarge = errors.New("message frame too large")
+
+// AppendMessageFrame appends length-prefixed frame with the given flags
+// and msg to dst and returns the extended dst.
+//
+// The frame consists of flags byte, big-endian uint32 msg length and msg.
+func AppendMessageFrame(dst []byte, flags byte, msg []byte) []byte {
+	var hdr [messageFrameHeaderSize]byte
+	hdr[0] = flags
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+	dst = append(dst, hdr[:]...)
+	return append(dst, msg...)
+}
+
+// WriteMessageFrame writes length-prefixed frame with the given flags
+// and msg to w.
+//
+// See AppendMessageFrame for details.
+func WriteMessageFrame(w io.Writer, flags byte, msg []byte) error {
+	var hdr [messageFrameHeaderSize]byte
+	hdr[0] = flags
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(msg)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+// ReadMessageFrame reads length-prefixed frame from r, appends its message
+// to dst and returns the frame flags and the extended dst.
+//
+// io.EOF is returned if r ends before the frame, while io.ErrUnexpectedEOF
+// is returned if r ends in the middle of the frame.
+//
+// If maxSize > 0 and the message size exceeds maxSize, then
+// ErrMessageFrameTooLarge is returned without reading the message.
+func ReadMessageFrame(r io.Reader, dst []byte, maxSize int) (byte, []byte, error) {
+	var hdr [messageFrameHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, dst, err
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if maxSize > 0 && uint64(size) > uint64(maxSize) {
+		return 0, dst, ErrMessageFrameTooLarge
+	}
+
+	// Grow dst step by step, so bogus sizes don't allocate
+	// the memory before the message is actually read.
+	const maxStep = 64 * 1024
+	for size > 0 {
+		n := int(size)
+		if size > maxStep {
+			n = maxStep
+		}
+		dstLen := len(dst)
+		dst = append(dst, make([]byte, n)...)
+		if _, err := io.ReadFull(r, dst[dstLen:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, dst[:dstLen], err
+		}
+		size -= uint32(n)
+	}
+	return hdr[0], dst, nil
+}
+
+// ParseMessageFrame parses the first length-prefixed frame in src
+// and returns the frame flags, the message and the remaining tail of src.
+//
+// The returned message refers to src. This allows parsing frames
+// in buffered request and response bodies without copying.
+//
+// If maxSize > 0 and the message size exceeds maxSize, then
+// ErrMessageFrameTooLarge is returned. io.ErrUnexpectedEOF is returned
+// if src contains incomplete frame.
+func ParseMessageFrame(src []byte, maxSize int) (byte, []byte, []byte, error) {
+	if len(src) < messageFrameHeaderSize {
+		return 0, nil, src, io.ErrUnexpectedEOF
+	}
+	size := binary.BigEndian.Uint32(src[1:messageFrameHeaderSize])
+	if maxSize > 0 && uint64(size) > uint64(maxSize) {
+		return 0, nil, src, ErrMessageFrameTooLarge
+	}
+	if uint64(size) > uint64(len(src)-messageFrameHeaderSize) {
+		return 0, nil, src, io.ErrUnexpectedEOF
+	}
+	end := messageFrameHeaderSize + int(size)
+	return src[0], src[messageFrameHeaderSize:end], src[end:], nil
+}
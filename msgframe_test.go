@@ -0,0 +1,88 @@
+package fasthttp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMessageFrame(t *testing.T) {
+	big := []byte(strings.Repeat("x", 100000))
+
+	var buf bytes.Buffer
+	for _, msg := range [][]byte{[]byte("foo"), nil, big} {
+		if err := WriteMessageFrame(&buf, 0, msg); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	b := AppendMessageFrame(nil, MessageFrameTrailers, []byte("grpc-status: 0\r\n"))
+	buf.Write(b)
+	body := append([]byte(nil), buf.Bytes()...)
+
+	r := bytes.NewReader(body)
+	testRead := func(expectedFlags byte, expectedMsg []byte) {
+		t.Helper()
+		flags, msg, err := ReadMessageFrame(r, []byte("prefix"), 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if flags != expectedFlags {
+			t.Fatalf("unexpected flags: %d. Expecting %d", flags, expectedFlags)
+		}
+		if !bytes.Equal(msg, append([]byte("prefix"), expectedMsg...)) {
+			t.Fatalf("unexpected message with length %d", len(msg))
+		}
+	}
+	testRead(0, []byte("foo"))
+	testRead(0, nil)
+	testRead(0, big)
+	testRead(MessageFrameTrailers, []byte("grpc-status: 0\r\n"))
+	if _, _, err := ReadMessageFrame(r, nil, 0); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+
+	tail := body
+	var msgs [][]byte
+	for len(tail) > 0 {
+		var msg []byte
+		var err error
+		if _, msg, tail, err = ParseMessageFrame(tail, 0); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) != 4 || string(msgs[0]) != "foo" || len(msgs[1]) != 0 || !bytes.Equal(msgs[2], big) {
+		t.Fatalf("unexpected messages parsed")
+	}
+}
+
+func TestMessageFrameErrors(t *testing.T) {
+	frame := AppendMessageFrame(nil, 0, []byte("foobar"))
+
+	if _, _, err := ReadMessageFrame(bytes.NewReader(frame), nil, 5); err != ErrMessageFrameTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrMessageFrameTooLarge)
+	}
+	if _, _, _, err := ParseMessageFrame(frame, 5); err != ErrMessageFrameTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrMessageFrameTooLarge)
+	}
+
+	for _, n := range []int{1, 5, len(frame) - 1} {
+		if _, _, err := ReadMessageFrame(bytes.NewReader(frame[:n]), nil, 0); err != io.ErrUnexpectedEOF {
+			t.Fatalf("unexpected error for %d bytes: %v. Expecting %v", n, err, io.ErrUnexpectedEOF)
+		}
+		if _, _, _, err := ParseMessageFrame(frame[:n], 0); err != io.ErrUnexpectedEOF {
+			t.Fatalf("unexpected error for %d bytes: %v. Expecting %v", n, err, io.ErrUnexpectedEOF)
+		}
+	}
+
+	// Bogus size mustn't allocate the memory before reading the message.
+	bogus := []byte{0, 0xff, 0xff, 0xff, 0xff, 'x'}
+	_, msg, err := ReadMessageFrame(bytes.NewReader(bogus), nil, 0)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.ErrUnexpectedEOF)
+	}
+	if cap(msg) > 128*1024 {
+		t.Fatalf("too much memory allocated for bogus frame: %d bytes", cap(msg))
+	}
+}
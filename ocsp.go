@@ -0,0 +1,648 @@
+package fasthttp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	// Register hash functions used in OCSP responses.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// OCSPStatus is the certificate revocation status from OCSP response.
+type OCSPStatus int
+
+// OCSP statuses. See https://tools.ietf.org/html/rfc6960#section-2.2 .
+const (
+	// OCSPGood means the certificate isn't revoked.
+	OCSPGood OCSPStatus = iota
+
+	// OCSPRevoked means the certificate is revoked.
+	OCSPRevoked
+
+	// OCSPUnknown means the responder doesn't know about the certificate.
+	OCSPUnknown
+)
+
+// String returns human-readable OCSP status.
+func (s OCSPStatus) String() string {
+	switch s {
+	case OCSPGood:
+		return "good"
+	case OCSPRevoked:
+		return "revoked"
+	case OCSPUnknown:
+		return "unknown"
+	default:
+		return fmt.Sprintf("OCSPStatus(%d)", int(s))
+	}
+}
+
+// OCSPResponse is the verified OCSP response stapled by the host
+// for its certificate.
+//
+// See HostClient.RequireOCSPStapling for details.
+type OCSPResponse struct {
+	// Status is the revocation status of the certificate.
+	Status OCSPStatus
+
+	// ProducedAt is the time the response has been signed at.
+	ProducedAt time.Time
+
+	// ThisUpdate is the time the status is known to be correct at.
+	ThisUpdate time.Time
+
+	// NextUpdate is the time newer status will be available at.
+	//
+	// NextUpdate is zero if newer status is always available.
+	NextUpdate time.Time
+
+	// RevokedAt is the revocation time for revoked certificates.
+	RevokedAt time.Time
+
+	// RevocationReason is the CRL reason code for revoked certificates.
+	//
+	// See https://tools.ietf.org/html/rfc5280#section-5.3.1 .
+	RevocationReason int
+}
+
+// OCSPPolicyFunc returns non-nil error if the connection to the host
+// presenting cert with the given verified OCSP response mustn't be used.
+//
+// See HostClient.OCSPPolicy for details.
+type OCSPPolicyFunc func(cert *x509.Certificate, resp *OCSPResponse) error
+
+var (
+	// ErrOCSPResponseMissing is returned from client methods if the host
+	// doesn't staple OCSP response, while RequireOCSPStapling is set.
+	ErrOCSPResponseMissing = errors.New("the host didn't staple OCSP response for its certificate")
+
+	// ErrCertificateRevoked is returned from client methods if the stapled
+	// OCSP response says the host certificate is revoked.
+	ErrCertificateRevoked = errors.New("the host certificate is revoked")
+)
+
+// defaultOCSPPolicy accepts only certificates with good status.
+func defaultOCSPPolicy(cert *x509.Certificate, resp *OCSPResponse) error {
+	switch resp.Status {
+	case OCSPGood:
+		return nil
+	case OCSPRevoked:
+		return ErrCertificateRevoked
+	default:
+		return fmt.Errorf("unexpected OCSP status for the host certificate: %s", resp.Status)
+	}
+}
+
+// ocspClockSkew is the allowed clock skew between the client
+// and the OCSP responder.
+const ocspClockSkew = 5 * time.Minute
+
+// verifyStapledOCSP verifies OCSP response stapled to the TLS connection
+// with the given state and applies policy to it.
+func verifyStapledOCSP(state tls.ConnectionState, policy OCSPPolicyFunc, now time.Time) error {
+	if len(state.OCSPResponse) == 0 {
+		return ErrOCSPResponseMissing
+	}
+	if len(state.VerifiedChains) == 0 {
+		// The chain isn't verified if TLSConfig.InsecureSkipVerify is set,
+		// so the issuer sent by the host cannot be trusted.
+		return errors.New("cannot verify OCSP response for unverified host certificate chain")
+	}
+	chain := state.VerifiedChains[0]
+	if len(chain) < 2 {
+		return errors.New("cannot verify OCSP response without the host certificate issuer")
+	}
+	leaf, issuer := chain[0], chain[1]
+	resp, err := parseOCSPResponse(state.OCSPResponse, leaf, issuer, now)
+	if err != nil {
+		return fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	if policy == nil {
+		policy = defaultOCSPPolicy
+	}
+	return policy(leaf, resp)
+}
+
+type ocspResponseASN1 struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID           ocspCertID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          ocspRevokedInfo  `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+var oidOCSPBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+var ocspHashes = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}, crypto.SHA1},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}, crypto.SHA256},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}, crypto.SHA384},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}, crypto.SHA512},
+}
+
+var ocspSignatureAlgorithms = []struct {
+	oid  asn1.ObjectIdentifier
+	algo x509.SignatureAlgorithm
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, x509.SHA1WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, x509.SHA256WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, x509.SHA384WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, x509.SHA512WithRSA},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}, x509.ECDSAWithSHA1},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, x509.ECDSAWithSHA256},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, x509.ECDSAWithSHA384},
+	{asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, x509.ECDSAWithSHA512},
+	{asn1.ObjectIdentifier{1, 3, 101, 112}, x509.PureEd25519},
+}
+
+// parseOCSPResponse parses and verifies DER-encoded OCSP response
+// for leaf certificate issued by issuer.
+func parseOCSPResponse(der []byte, leaf, issuer *x509.Certificate, now time.Time) (*OCSPResponse, error) {
+	var r ocspResponseASN1
+	if rest, err := asn1.Unmarshal(der, &r); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data after OCSP response")
+	}
+	if r.Status != 0 {
+		return nil, fmt.Errorf("unsuccessful OCSP response status %d", r.Status)
+	}
+	if !r.Response.ResponseType.Equal(oidOCSPBasicResponse) {
+		return nil, fmt.Errorf("unsupported OCSP response type %s", r.Response.ResponseType)
+	}
+
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(r.Response.Response, &basic); err != nil {
+		return nil, err
+	}
+	var data ocspResponseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return nil, err
+	}
+
+	signer, err := ocspSigner(basic.Certificates, issuer, now)
+	if err != nil {
+		return nil, err
+	}
+	sigAlgo := x509.UnknownSignatureAlgorithm
+	for _, sa := range ocspSignatureAlgorithms {
+		if sa.oid.Equal(basic.SignatureAlgorithm.Algorithm) {
+			sigAlgo = sa.algo
+			break
+		}
+	}
+	if sigAlgo == x509.UnknownSignatureAlgorithm {
+		return nil, fmt.Errorf("unsupported signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	if err := signer.CheckSignature(sigAlgo, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		return nil, fmt.Errorf("bad signature: %w", err)
+	}
+
+	for i := range data.Responses {
+		sr := &data.Responses[i]
+		ok, err := ocspCertIDMatches(&sr.CertID, leaf, issuer)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if now.Add(ocspClockSkew).Before(sr.ThisUpdate) {
+			return nil, fmt.Errorf("the response is issued in the future at %s", sr.ThisUpdate)
+		}
+		if !sr.NextUpdate.IsZero() && now.Add(-ocspClockSkew).After(sr.NextUpdate) {
+			return nil, fmt.Errorf("the response expired at %s", sr.NextUpdate)
+		}
+		resp := &OCSPResponse{
+			ProducedAt: data.ProducedAt,
+			ThisUpdate: sr.ThisUpdate,
+			NextUpdate: sr.NextUpdate,
+		}
+		switch {
+		case bool(sr.Good):
+			resp.Status = OCSPGood
+		case bool(sr.Unknown):
+			resp.Status = OCSPUnknown
+		default:
+			resp.Status = OCSPRevoked
+			resp.RevokedAt = sr.Revoked.RevocationTime
+			resp.RevocationReason = int(sr.Revoked.Reason)
+		}
+		return resp, nil
+	}
+	return nil, errors.New("the response doesn't contain status for the host certificate")
+}
+
+// ocspSigner returns the certificate the OCSP response must be signed with.
+//
+// The response is signed either by the issuer or by the delegated
+// responder certificate issued by the issuer and valid at now.
+func ocspSigner(certs []asn1.RawValue, issuer *x509.Certificate, now time.Time) (*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return issuer, nil
+	}
+	responder, err := x509.ParseCertificate(certs[0].FullBytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse responder certificate: %w", err)
+	}
+	if bytes.Equal(responder.Raw, issuer.Raw) {
+		return issuer, nil
+	}
+	if err := responder.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("the responder certificate isn't issued by the host certificate issuer: %w", err)
+	}
+	if now.Add(ocspClockSkew).Before(responder.NotBefore) {
+		return nil, fmt.Errorf("the responder certificate isn't valid until %s", responder.NotBefore)
+	}
+	if now.Add(-ocspClockSkew).After(responder.NotAfter) {
+		return nil, fmt.Errorf("the responder certificate expired at %s", responder.NotAfter)
+	}
+	for _, eku := range responder.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageOCSPSigning {
+			return responder, nil
+		}
+	}
+	return nil, errors.New("the responder certificate isn't authorized for signing OCSP responses")
+}
+
+// ocspCertIDMatches returns true if id identifies leaf certificate
+// issued by issuer.
+func ocspCertIDMatches(id *ocspCertID, leaf, issuer *x509.Certificate) (bool, error) {
+	if id.SerialNumber == nil || id.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		return false, nil
+	}
+	h := crypto.Hash(0)
+	for _, oh := range ocspHashes {
+		if oh.oid.Equal(id.HashAlgorithm.Algorithm) {
+			h = oh.hash
+			break
+		}
+	}
+	if h == 0 {
+		return false, fmt.Errorf("unsupported hash algorithm %s", id.HashAlgorithm.Algorithm)
+	}
+	nameHash, keyHash, err := ocspIssuerHashes(h, issuer)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(nameHash, id.NameHash) && bytes.Equal(keyHash, id.IssuerKeyHash), nil
+}
+
+// ocspIssuerHashes returns hashes of issuer name and public key
+// used for identifying certificates in OCSP.
+func ocspIssuerHashes(h crypto.Hash, issuer *x509.Certificate) (nameHash, keyHash []byte, err error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse issuer public key: %w", err)
+	}
+	hh := h.New()
+	hh.Write(issuer.RawSubject)
+	nameHash = hh.Sum(nil)
+	hh = h.New()
+	hh.Write(spki.PublicKey.RightAlign())
+	keyHash = hh.Sum(nil)
+	return nameHash, keyHash, nil
+}
+
+type ocspRequestASN1 struct {
+	TBSRequest ocspTBSRequest
+}
+
+type ocspTBSRequest struct {
+	Version     int `asn1:"explicit,tag:0,default:0,optional"`
+	RequestList []ocspSingleRequest
+}
+
+type ocspSingleRequest struct {
+	Cert ocspCertID
+}
+
+// newOCSPRequest returns DER-encoded OCSP request for leaf certificate
+// issued by issuer.
+func newOCSPRequest(leaf, issuer *x509.Certificate) ([]byte, error) {
+	nameHash, keyHash, err := ocspIssuerHashes(crypto.SHA1, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspRequestASN1{
+		TBSRequest: ocspTBSRequest{
+			RequestList: []ocspSingleRequest{
+				{
+					Cert: ocspCertID{
+						HashAlgorithm: pkix.AlgorithmIdentifier{
+							Algorithm:  ocspHashes[0].oid,
+							Parameters: asn1.NullRawValue,
+						},
+						NameHash:      nameHash,
+						IssuerKeyHash: keyHash,
+						SerialNumber:  leaf.SerialNumber,
+					},
+				},
+			},
+		},
+	})
+}
+
+// OCSPStapler fetches OCSP responses for server certificates from
+// OCSP responders and staples them to TLS handshakes.
+//
+// OCSP responses are refreshed in background before they expire.
+// Expired responses aren't stapled if they cannot be refreshed.
+//
+// Set Server.OCSPStapler for stapling OCSP responses to certificates
+// passed to Server.ServeTLS* and Server.ListenAndServeTLS*. Use
+// GetCertificate as tls.Config.GetCertificate for custom TLS listeners.
+//
+// Call Stop when OCSPStapler is no longer needed.
+type OCSPStapler struct {
+	// Client used for fetching OCSP responses.
+	//
+	// By default Client with 10 seconds read and write timeouts is used.
+	Client Doer
+
+	// The interval between attempts to fetch OCSP response after failures.
+	//
+	// By default failed fetches are retried every minute.
+	RetryInterval time.Duration
+
+	// Logger for OCSP response fetch errors.
+	//
+	// By default standard logger from log package is used.
+	Logger Logger
+
+	lock   sync.Mutex
+	certs  []*ocspStapledCert
+	stopCh chan struct{}
+	stopWG sync.WaitGroup
+}
+
+type ocspStapledCert struct {
+	cert   tls.Certificate
+	leaf   *x509.Certificate
+	issuer *x509.Certificate
+
+	// stapled holds *tls.Certificate with the current OCSP response.
+	stapled atomic.Value
+
+	// The fields below are accessed only by the refreshing goroutine
+	// after the certificate is added.
+	expiresAt   time.Time
+	nextRefresh time.Time
+}
+
+const (
+	ocspFetchTimeout           = 10 * time.Second
+	ocspDefaultRefreshInterval = time.Hour
+)
+
+var defaultOCSPClient = &Client{
+	ReadTimeout:  ocspFetchTimeout,
+	WriteTimeout: ocspFetchTimeout,
+}
+
+// AddCertificate registers cert for OCSP stapling.
+//
+// cert must contain the issuer certificate after the leaf certificate,
+// while the leaf certificate must contain OCSP responder url.
+// OCSP response is fetched before returning, so it is stapled to the first
+// handshakes. Fetch errors are logged, since the response is re-fetched
+// in background.
+func (s *OCSPStapler) AddCertificate(cert tls.Certificate) error {
+	if len(cert.Certificate) < 2 {
+		return errors.New("OCSP stapling requires the issuer certificate in the certificate chain")
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("cannot parse certificate: %w", err)
+		}
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return fmt.Errorf("cannot parse issuer certificate: %w", err)
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return fmt.Errorf("certificate for %q doesn't contain OCSP responder url", leaf.Subject.CommonName)
+	}
+
+	cert.OCSPStaple = nil
+	sc := &ocspStapledCert{
+		cert:   cert,
+		leaf:   leaf,
+		issuer: issuer,
+	}
+	sc.stapled.Store(&sc.cert)
+	s.refresh(sc, time.Now())
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.certs = append(s.certs, sc)
+	if s.stopCh == nil {
+		stopCh := make(chan struct{})
+		s.stopCh = stopCh
+		s.stopWG.Add(1)
+		go func() {
+			defer s.stopWG.Done()
+			s.run(stopCh)
+		}()
+	}
+	return nil
+}
+
+// GetCertificate returns the registered certificate for the given
+// client hello with the current OCSP response stapled.
+//
+// The certificate matching the requested server name is returned.
+// The first registered certificate is returned if there is no match.
+//
+// GetCertificate may be used as tls.Config.GetCertificate.
+func (s *OCSPStapler) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.lock.Lock()
+	certs := s.certs
+	s.lock.Unlock()
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates are registered in OCSPStapler")
+	}
+	if hello != nil && hello.ServerName != "" {
+		for _, sc := range certs {
+			if sc.leaf.VerifyHostname(hello.ServerName) == nil {
+				return sc.stapled.Load().(*tls.Certificate), nil
+			}
+		}
+	}
+	return certs[0].stapled.Load().(*tls.Certificate), nil
+}
+
+// Stop stops refreshing OCSP responses.
+//
+// Refreshing is resumed after the next AddCertificate call.
+func (s *OCSPStapler) Stop() {
+	s.lock.Lock()
+	stopCh := s.stopCh
+	s.stopCh = nil
+	s.lock.Unlock()
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	s.stopWG.Wait()
+}
+
+func (s *OCSPStapler) retryInterval() time.Duration {
+	if s.RetryInterval <= 0 {
+		return time.Minute
+	}
+	return s.RetryInterval
+}
+
+func (s *OCSPStapler) logger() Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return defaultLogger
+}
+
+func (s *OCSPStapler) run(stopCh <-chan struct{}) {
+	t := time.NewTicker(s.retryInterval())
+	defer t.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-t.C:
+			s.lock.Lock()
+			certs := s.certs
+			s.lock.Unlock()
+			now := time.Now()
+			for _, sc := range certs {
+				if !now.Before(sc.nextRefresh) {
+					s.refresh(sc, now)
+				}
+			}
+		}
+	}
+}
+
+// refresh fetches OCSP response for sc and staples it.
+func (s *OCSPStapler) refresh(sc *ocspStapledCert, now time.Time) {
+	staple, resp, err := s.fetch(sc, now)
+	if err != nil {
+		s.logger().Printf("cannot fetch OCSP response for certificate %q from %q: %s", sc.leaf.Subject.CommonName, sc.leaf.OCSPServer[0], err)
+		sc.nextRefresh = now.Add(s.retryInterval())
+		if !sc.expiresAt.IsZero() && now.After(sc.expiresAt) {
+			// Do not staple expired response.
+			sc.expiresAt = time.Time{}
+			sc.stapled.Store(&sc.cert)
+		}
+		return
+	}
+	if resp.Status == OCSPRevoked {
+		s.logger().Printf("certificate %q is revoked at %s according to OCSP response", sc.leaf.Subject.CommonName, resp.RevokedAt)
+	}
+	cert := sc.cert
+	cert.OCSPStaple = staple
+	sc.stapled.Store(&cert)
+	sc.expiresAt = resp.NextUpdate
+
+	// Refresh the response in the middle of its validity period,
+	// so there is enough time for retries.
+	if resp.NextUpdate.IsZero() {
+		sc.nextRefresh = now.Add(ocspDefaultRefreshInterval)
+	} else {
+		sc.nextRefresh = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+		if sc.nextRefresh.Before(now) {
+			sc.nextRefresh = now.Add(s.retryInterval())
+		}
+	}
+}
+
+// fetch fetches and verifies OCSP response for sc from its OCSP responder.
+func (s *OCSPStapler) fetch(sc *ocspStapledCert, now time.Time) ([]byte, *OCSPResponse, error) {
+	body, err := newOCSPRequest(sc.leaf, sc.issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create OCSP request: %w", err)
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.SetRequestURI(sc.leaf.OCSPServer[0])
+	req.Header.SetMethodBytes(strPost)
+	req.Header.SetContentType("application/ocsp-request")
+	req.SetBody(body)
+
+	c := s.Client
+	if c == nil {
+		c = defaultOCSPClient
+	}
+	if err := c.Do(req, resp); err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode() != StatusOK {
+		return nil, nil, fmt.Errorf("unexpected status code %d", resp.StatusCode())
+	}
+	staple := append([]byte(nil), resp.Body()...)
+	r, err := parseOCSPResponse(staple, sc.leaf, sc.issuer, now)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	return staple, r, nil
+}
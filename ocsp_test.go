@@ -0,0 +1,491 @@
+package fasthttp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+type testOCSPCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestOCSPCA(t *testing.T) *testOCSPCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse certificate: %s", err)
+	}
+	return &testOCSPCA{
+		cert: cert,
+		key:  key,
+	}
+}
+
+func (ca *testOCSPCA) issue(t *testing.T, serial int64) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"example.com", "public.example.com"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{"http://ocsp.example.com/"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse certificate: %s", err)
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+}
+
+// responder returns delegated OCSP responder certificate issued by ca
+// and valid from notBefore till notAfter.
+func (ca *testOCSPCA) responder(t *testing.T, notBefore, notAfter time.Time) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test ocsp responder"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("cannot create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse certificate: %s", err)
+	}
+	return cert, key
+}
+
+// ocspResponse returns OCSP response for the certificate with the given
+// serial signed by signer. The optional certs are embedded into the response.
+func (ca *testOCSPCA) ocspResponse(t *testing.T, serial int64, status OCSPStatus, nextUpdate time.Time, signer *ecdsa.PrivateKey, certs ...*x509.Certificate) []byte {
+	t.Helper()
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(ca.cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		t.Fatalf("cannot parse public key: %s", err)
+	}
+	nameHash := sha1.Sum(ca.cert.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+
+	sr := ocspSingleResponse{
+		CertID: ocspCertID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  ocspHashes[0].oid,
+				Parameters: asn1.NullRawValue,
+			},
+			NameHash:      nameHash[:],
+			IssuerKeyHash: keyHash[:],
+			SerialNumber:  big.NewInt(serial),
+		},
+		ThisUpdate: time.Now().Add(-time.Minute).UTC(),
+		NextUpdate: nextUpdate.UTC(),
+	}
+	switch status {
+	case OCSPGood:
+		sr.Good = true
+	case OCSPUnknown:
+		sr.Unknown = true
+	default:
+		sr.Revoked = ocspRevokedInfo{
+			RevocationTime: time.Now().Add(-time.Hour).UTC(),
+			Reason:         1,
+		}
+	}
+	keyHashDER, err := asn1.Marshal(keyHash[:])
+	if err != nil {
+		t.Fatalf("cannot marshal responder id: %s", err)
+	}
+	tbs, err := asn1.Marshal(ocspResponseData{
+		RawResponderID: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        2,
+			IsCompound: true,
+			Bytes:      keyHashDER,
+		},
+		ProducedAt: time.Now().Add(-time.Minute).UTC(),
+		Responses:  []ocspSingleResponse{sr},
+	})
+	if err != nil {
+		t.Fatalf("cannot marshal response data: %s", err)
+	}
+	var rawCerts []asn1.RawValue
+	for _, c := range certs {
+		rawCerts = append(rawCerts, asn1.RawValue{FullBytes: c.Raw})
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("cannot sign response: %s", err)
+	}
+	basic, err := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData: asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2},
+		},
+		Signature: asn1.BitString{
+			Bytes:     sig,
+			BitLength: 8 * len(sig),
+		},
+		Certificates: rawCerts,
+	})
+	if err != nil {
+		t.Fatalf("cannot marshal basic response: %s", err)
+	}
+	resp, err := asn1.Marshal(ocspResponseASN1{
+		Response: ocspResponseBytes{
+			ResponseType: oidOCSPBasicResponse,
+			Response:     basic,
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot marshal response: %s", err)
+	}
+	return resp
+}
+
+func TestParseOCSPResponse(t *testing.T) {
+	ca := newTestOCSPCA(t)
+	leaf := ca.issue(t, 42).Leaf
+	now := time.Now()
+	nextUpdate := now.Add(time.Hour)
+
+	resp, err := parseOCSPResponse(ca.ocspResponse(t, 42, OCSPGood, nextUpdate, ca.key), leaf, ca.cert, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.Status != OCSPGood {
+		t.Fatalf("unexpected status: %s. Expecting %s", resp.Status, OCSPGood)
+	}
+	if !resp.NextUpdate.Equal(nextUpdate.Truncate(time.Second)) {
+		t.Fatalf("unexpected next update: %s. Expecting %s", resp.NextUpdate, nextUpdate)
+	}
+
+	resp, err = parseOCSPResponse(ca.ocspResponse(t, 42, OCSPRevoked, nextUpdate, ca.key), leaf, ca.cert, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.Status != OCSPRevoked || resp.RevokedAt.IsZero() || resp.RevocationReason != 1 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if err = defaultOCSPPolicy(leaf, resp); err != ErrCertificateRevoked {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrCertificateRevoked)
+	}
+
+	resp, err = parseOCSPResponse(ca.ocspResponse(t, 42, OCSPUnknown, time.Time{}, ca.key), leaf, ca.cert, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.Status != OCSPUnknown || !resp.NextUpdate.IsZero() {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+
+	// Expired response.
+	if _, err = parseOCSPResponse(ca.ocspResponse(t, 42, OCSPGood, now.Add(-time.Hour), ca.key), leaf, ca.cert, now); err == nil {
+		t.Fatalf("expecting non-nil error for expired response")
+	}
+
+	// Response for another certificate.
+	if _, err = parseOCSPResponse(ca.ocspResponse(t, 43, OCSPGood, nextUpdate, ca.key), leaf, ca.cert, now); err == nil {
+		t.Fatalf("expecting non-nil error for response for another certificate")
+	}
+
+	// Response signed by unknown key.
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key: %s", err)
+	}
+	if _, err = parseOCSPResponse(ca.ocspResponse(t, 42, OCSPGood, nextUpdate, otherKey), leaf, ca.cert, now); err == nil {
+		t.Fatalf("expecting non-nil error for response with bad signature")
+	}
+
+	if _, err = parseOCSPResponse([]byte("foobar"), leaf, ca.cert, now); err == nil {
+		t.Fatalf("expecting non-nil error for malformed response")
+	}
+
+	// Response signed by delegated responder.
+	responder, responderKey := ca.responder(t, now.Add(-time.Hour), now.Add(time.Hour))
+	resp, err = parseOCSPResponse(ca.ocspResponse(t, 42, OCSPGood, nextUpdate, responderKey, responder), leaf, ca.cert, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.Status != OCSPGood {
+		t.Fatalf("unexpected status: %s. Expecting %s", resp.Status, OCSPGood)
+	}
+
+	// Response signed by expired delegated responder.
+	responder, responderKey = ca.responder(t, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if _, err = parseOCSPResponse(ca.ocspResponse(t, 42, OCSPGood, nextUpdate, responderKey, responder), leaf, ca.cert, now); err == nil {
+		t.Fatalf("expecting non-nil error for response signed by expired responder")
+	}
+
+	// Response signed by not yet valid delegated responder.
+	responder, responderKey = ca.responder(t, now.Add(time.Hour), now.Add(2*time.Hour))
+	if _, err = parseOCSPResponse(ca.ocspResponse(t, 42, OCSPGood, nextUpdate, responderKey, responder), leaf, ca.cert, now); err == nil {
+		t.Fatalf("expecting non-nil error for response signed by not yet valid responder")
+	}
+}
+
+func TestVerifyStapledOCSPUnverifiedChain(t *testing.T) {
+	ca := newTestOCSPCA(t)
+	leaf := ca.issue(t, 42).Leaf
+	staple := ca.ocspResponse(t, 42, OCSPGood, time.Now().Add(time.Hour), ca.key)
+
+	state := tls.ConnectionState{
+		OCSPResponse:     staple,
+		PeerCertificates: []*x509.Certificate{leaf, ca.cert},
+	}
+	if err := verifyStapledOCSP(state, nil, time.Now()); err == nil {
+		t.Fatalf("expecting non-nil error for unverified certificate chain")
+	}
+
+	state.VerifiedChains = [][]*x509.Certificate{{leaf, ca.cert}}
+	if err := verifyStapledOCSP(state, nil, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestHostClientRequireOCSPStapling(t *testing.T) {
+	ca := newTestOCSPCA(t)
+	cert := ca.issue(t, 42)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	test := func(staple []byte, policy OCSPPolicyFunc, expectedErr error) {
+		t.Helper()
+		cert.OCSPStaple = staple
+		ln, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer ln.Close()
+		s := &Server{
+			Handler: func(ctx *RequestCtx) {
+				ctx.SetBodyString("ok")
+			},
+			Logger: &customLogger{},
+		}
+		go s.Serve(ln)
+
+		c := &HostClient{
+			Addr:                ln.Addr().String(),
+			IsTLS:               true,
+			TLSConfig:           &tls.Config{RootCAs: roots},
+			RequireOCSPStapling: true,
+			OCSPPolicy:          policy,
+		}
+		statusCode, body, err := c.Get(nil, "https://"+c.Addr+"/")
+		if expectedErr != nil {
+			if !errors.Is(err, expectedErr) {
+				t.Fatalf("unexpected error: %v. Expecting %v", err, expectedErr)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if statusCode != StatusOK || string(body) != "ok" {
+			t.Fatalf("unexpected response: %d %q", statusCode, body)
+		}
+	}
+
+	nextUpdate := time.Now().Add(time.Hour)
+	test(ca.ocspResponse(t, 42, OCSPGood, nextUpdate, ca.key), nil, nil)
+	test(nil, nil, ErrOCSPResponseMissing)
+	revoked := ca.ocspResponse(t, 42, OCSPRevoked, nextUpdate, ca.key)
+	test(revoked, nil, ErrCertificateRevoked)
+
+	// Custom policy may accept revoked certificates.
+	test(revoked, func(cert *x509.Certificate, resp *OCSPResponse) error {
+		if resp.Status != OCSPRevoked {
+			return errors.New("unexpected status")
+		}
+		return nil
+	}, nil)
+}
+
+func TestServerOCSPStapler(t *testing.T) {
+	ca := newTestOCSPCA(t)
+	cert := ca.issue(t, 42)
+
+	// OCSP responder.
+	var fetches uint32
+	responderLn := fasthttputil.NewInmemoryListener()
+	defer responderLn.Close()
+	responder := &Server{
+		Handler: func(ctx *RequestCtx) {
+			atomic.AddUint32(&fetches, 1)
+			if string(ctx.Request.Header.ContentType()) != "application/ocsp-request" {
+				ctx.Error("unexpected content type", StatusBadRequest)
+				return
+			}
+			var req ocspRequestASN1
+			if _, err := asn1.Unmarshal(ctx.PostBody(), &req); err != nil || len(req.TBSRequest.RequestList) != 1 {
+				ctx.Error("malformed request", StatusBadRequest)
+				return
+			}
+			ok, err := ocspCertIDMatches(&req.TBSRequest.RequestList[0].Cert, cert.Leaf, ca.cert)
+			if err != nil || !ok {
+				ctx.Error("unknown certificate", StatusBadRequest)
+				return
+			}
+			// The response expiring soon is refreshed every RetryInterval.
+			ctx.SetBody(ca.ocspResponse(t, 42, OCSPGood, time.Now().Add(time.Second), ca.key))
+		},
+	}
+	go responder.Serve(responderLn)
+
+	stapler := &OCSPStapler{
+		Client: &HostClient{
+			Addr: "ocsp.example.com",
+			Dial: func(addr string) (net.Conn, error) {
+				return responderLn.Dial()
+			},
+		},
+		RetryInterval: 10 * time.Millisecond,
+		Logger:        &customLogger{},
+	}
+	defer stapler.Stop()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetBodyString("ok")
+		},
+		OCSPStapler: stapler,
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[1]})...)
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	go s.ServeTLSEmbed(ln, certPEM, keyPEM)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	c := &HostClient{
+		Addr:                ln.Addr().String(),
+		IsTLS:               true,
+		TLSConfig:           &tls.Config{RootCAs: roots},
+		RequireOCSPStapling: true,
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		statusCode, body, err := c.Get(nil, "https://"+c.Addr+"/")
+		if err == nil {
+			if statusCode != StatusOK || string(body) != "ok" {
+				t.Fatalf("unexpected response: %d %q", statusCode, body)
+			}
+			break
+		}
+		// The server may be not started yet.
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stapled, err := stapler.GetCertificate(&tls.ClientHelloInfo{ServerName: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(stapled.OCSPStaple) == 0 {
+		t.Fatalf("expecting non-empty OCSP staple")
+	}
+	for atomic.LoadUint32(&fetches) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout when waiting for OCSP response refresh")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOCSPStaplerAddCertificate(t *testing.T) {
+	ca := newTestOCSPCA(t)
+	cert := ca.issue(t, 42)
+
+	var s OCSPStapler
+	defer s.Stop()
+	if _, err := s.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Fatalf("expecting non-nil error without certificates")
+	}
+
+	leafOnly := cert
+	leafOnly.Certificate = cert.Certificate[:1]
+	if err := s.AddCertificate(leafOnly); err == nil {
+		t.Fatalf("expecting non-nil error for certificate without issuer")
+	}
+
+	noResponder := cert
+	noResponder.Leaf.OCSPServer = nil
+	if err := s.AddCertificate(noResponder); err == nil {
+		t.Fatalf("expecting non-nil error for certificate without OCSP responder")
+	}
+}
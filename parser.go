@@ -0,0 +1,185 @@
+package fasthttp
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrNeedMore is returned by Parse* functions if the buffer doesn't contain
+// the whole frame yet. Read more data and call the function again.
+var ErrNeedMore = errNeedMore
+
+var (
+	errNoRequestMethod      = errors.New("cannot find http request method")
+	errEmptyRequestURI      = errors.New("requestURI cannot be empty")
+	errNoResponseProtocol   = errors.New("cannot find whitespace in the first line of response")
+	errInvalidStatusCode    = errors.New("cannot parse response status code")
+	errStatusCodeTrailing   = errors.New("unexpected char at the end of status code")
+	errChunkSizeTrailing    = errors.New("unexpected char at the end of chunk size. Expected crlf")
+	errChunkMissingTrailing = errors.New("cannot find crlf at the end of chunk")
+)
+
+// ParseRequestLine parses the request line such as 'GET /foo HTTP/1.1\r\n'
+// at the beginning of b.
+//
+// Empty lines before the request line are skipped. The returned method
+// and requestURI point to b. n is the number of bytes occupied by the request
+// line in b. ErrNeedMore is returned if b doesn't contain the whole line.
+//
+// The function doesn't allocate memory.
+func ParseRequestLine(b []byte) (method, requestURI []byte, isHTTP11 bool, n int, err error) {
+	line, bNext, err := nextNonEmptyLine(b)
+	if err != nil {
+		return nil, nil, false, 0, err
+	}
+
+	m := bytes.IndexByte(line, ' ')
+	if m <= 0 {
+		return nil, nil, false, 0, errNoRequestMethod
+	}
+	method = line[:m]
+	line = line[m+1:]
+
+	m = bytes.LastIndexByte(line, ' ')
+	if m < 0 {
+		requestURI = line
+	} else if m == 0 {
+		return nil, nil, false, 0, errEmptyRequestURI
+	} else {
+		requestURI = line[:m]
+		isHTTP11 = bytes.Equal(line[m+1:], strHTTP11)
+	}
+	return method, requestURI, isHTTP11, len(b) - len(bNext), nil
+}
+
+// ParseStatusLine parses the response status line such as
+// 'HTTP/1.1 200 OK\r\n' at the beginning of b.
+//
+// Empty lines before the status line are skipped. n is the number of bytes
+// occupied by the status line in b. ErrNeedMore is returned if b doesn't
+// contain the whole line.
+//
+// The function doesn't allocate memory.
+func ParseStatusLine(b []byte) (statusCode int, isHTTP11 bool, n int, err error) {
+	line, bNext, err := nextNonEmptyLine(b)
+	if err != nil {
+		return 0, false, 0, err
+	}
+
+	m := bytes.IndexByte(line, ' ')
+	if m < 0 {
+		return 0, false, 0, errNoResponseProtocol
+	}
+	isHTTP11 = bytes.Equal(line[:m], strHTTP11)
+	line = line[m+1:]
+
+	statusCode, m, err = parseUintBuf(line)
+	if err != nil {
+		return 0, false, 0, errInvalidStatusCode
+	}
+	if len(line) > m && line[m] != ' ' {
+		return 0, false, 0, errStatusCodeTrailing
+	}
+	return statusCode, isHTTP11, len(b) - len(bNext), nil
+}
+
+// ParseHeaders parses the header block at the beginning of b
+// and calls f for each header.
+//
+// The header block must end with an empty line. Header keys are passed to f
+// as is, without normalizing. key and value point to b, so f mustn't retain
+// them. n is the number of bytes occupied by the header block in b,
+// including the trailing empty line. ErrNeedMore is returned if b doesn't
+// contain the whole header block.
+//
+// The function doesn't allocate memory.
+func ParseHeaders(b []byte, f func(key, value []byte)) (n int, err error) {
+	// Verify the header block is complete before calling f,
+	// so f isn't called multiple times for the same headers on ErrNeedMore.
+	if n, err = rawHeadersLen(b); err != nil {
+		return 0, err
+	}
+
+	var s headerScanner
+	s.b = b[:n]
+	s.keepKeys = true
+	for s.next() {
+		f(s.key, s.value)
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	return n, nil
+}
+
+// ParseChunkSize parses the chunk size line such as '1a\r\n'
+// at the beginning of b.
+//
+// n is the number of bytes occupied by the chunk size line in b.
+// ErrNeedMore is returned if b doesn't contain the whole line.
+//
+// The function doesn't allocate memory.
+func ParseChunkSize(b []byte) (chunkSize, n int, err error) {
+	for n < len(b) {
+		k := int(hex2intTable[b[n]])
+		if k == 16 {
+			break
+		}
+		if n >= maxHexIntChars {
+			return -1, 0, errTooLargeHexNum
+		}
+		chunkSize = (chunkSize << 4) | k
+		n++
+	}
+	if n == len(b) {
+		return -1, 0, ErrNeedMore
+	}
+	if n == 0 {
+		return -1, 0, errEmptyHexNum
+	}
+	if b[n] != '\r' {
+		return -1, 0, errChunkSizeTrailing
+	}
+	if n+1 == len(b) {
+		return -1, 0, ErrNeedMore
+	}
+	if b[n+1] != '\n' {
+		return -1, 0, errChunkSizeTrailing
+	}
+	return chunkSize, n + 2, nil
+}
+
+// ParseChunk parses the chunk of chunked body at the beginning of b.
+//
+// The returned data points to b. Empty data means the last chunk.
+// n is the number of bytes occupied by the chunk in b, including
+// the chunk size line and the trailing crlf. ErrNeedMore is returned
+// if b doesn't contain the whole chunk.
+//
+// The function doesn't allocate memory.
+func ParseChunk(b []byte) (data []byte, n int, err error) {
+	chunkSize, m, err := ParseChunkSize(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	n = m + chunkSize + len(strCRLF)
+	if len(b) < n {
+		return nil, 0, ErrNeedMore
+	}
+	if !bytes.Equal(b[n-len(strCRLF):n], strCRLF) {
+		return nil, 0, errChunkMissingTrailing
+	}
+	return b[m : m+chunkSize], n, nil
+}
+
+func nextNonEmptyLine(b []byte) ([]byte, []byte, error) {
+	bNext := b
+	var line []byte
+	var err error
+	for len(line) == 0 {
+		if line, bNext, err = nextLine(bNext); err != nil {
+			return nil, nil, err
+		}
+	}
+	return line, bNext, nil
+}
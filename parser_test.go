@@ -0,0 +1,159 @@
+package fasthttp
+
+import (
+	"testing"
+)
+
+func TestParseRequestLine(t *testing.T) {
+	testParseRequestLine(t, "GET /foo?bar HTTP/1.1\r\nHost: aaa\r\n\r\n", "GET", "/foo?bar", true, 23)
+	testParseRequestLine(t, "\r\nPOST / HTTP/1.0\n", "POST", "/", false, 18)
+	testParseRequestLine(t, "GET /foo\r\n", "GET", "/foo", false, 10)
+
+	testParseRequestLineError(t, "GET /foo HTTP/1.1", ErrNeedMore)
+	testParseRequestLineError(t, "\r\n", ErrNeedMore)
+	testParseRequestLineError(t, "GET\r\n", errNoRequestMethod)
+	testParseRequestLineError(t, " /foo HTTP/1.1\r\n", errNoRequestMethod)
+	testParseRequestLineError(t, "GET  HTTP/1.1\r\n", errEmptyRequestURI)
+}
+
+func testParseRequestLine(t *testing.T, s, expectedMethod, expectedRequestURI string, expectedHTTP11 bool, expectedN int) {
+	method, requestURI, isHTTP11, n, err := ParseRequestLine([]byte(s))
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", s, err)
+	}
+	if string(method) != expectedMethod {
+		t.Fatalf("unexpected method %q. Expecting %q", method, expectedMethod)
+	}
+	if string(requestURI) != expectedRequestURI {
+		t.Fatalf("unexpected requestURI %q. Expecting %q", requestURI, expectedRequestURI)
+	}
+	if isHTTP11 != expectedHTTP11 {
+		t.Fatalf("unexpected isHTTP11 %v. Expecting %v", isHTTP11, expectedHTTP11)
+	}
+	if n != expectedN {
+		t.Fatalf("unexpected n %d. Expecting %d", n, expectedN)
+	}
+}
+
+func testParseRequestLineError(t *testing.T, s string, expectedErr error) {
+	if _, _, _, _, err := ParseRequestLine([]byte(s)); err != expectedErr {
+		t.Fatalf("unexpected error when parsing %q: %v. Expecting %v", s, err, expectedErr)
+	}
+}
+
+func TestParseStatusLine(t *testing.T) {
+	testParseStatusLine(t, "HTTP/1.1 200 OK\r\nServer: aaa\r\n\r\n", 200, true, 17)
+	testParseStatusLine(t, "\nHTTP/1.0 404\n", 404, false, 14)
+
+	testParseStatusLineError(t, "HTTP/1.1 200 OK", ErrNeedMore)
+	testParseStatusLineError(t, "HTTP/1.1\r\n", errNoResponseProtocol)
+	testParseStatusLineError(t, "HTTP/1.1 foo\r\n", errInvalidStatusCode)
+	testParseStatusLineError(t, "HTTP/1.1 200OK\r\n", errStatusCodeTrailing)
+}
+
+func testParseStatusLine(t *testing.T, s string, expectedStatusCode int, expectedHTTP11 bool, expectedN int) {
+	statusCode, isHTTP11, n, err := ParseStatusLine([]byte(s))
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", s, err)
+	}
+	if statusCode != expectedStatusCode {
+		t.Fatalf("unexpected status code %d. Expecting %d", statusCode, expectedStatusCode)
+	}
+	if isHTTP11 != expectedHTTP11 {
+		t.Fatalf("unexpected isHTTP11 %v. Expecting %v", isHTTP11, expectedHTTP11)
+	}
+	if n != expectedN {
+		t.Fatalf("unexpected n %d. Expecting %d", n, expectedN)
+	}
+}
+
+func testParseStatusLineError(t *testing.T, s string, expectedErr error) {
+	if _, _, _, err := ParseStatusLine([]byte(s)); err != expectedErr {
+		t.Fatalf("unexpected error when parsing %q: %v. Expecting %v", s, err, expectedErr)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	s := "Host: foo.com\r\ncontent-TYPE:  text/plain \r\nX-Empty:\r\n\r\nbody"
+	var headers []string
+	n, err := ParseHeaders([]byte(s), func(key, value []byte) {
+		headers = append(headers, string(key)+"="+string(value))
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n != len(s)-len("body") {
+		t.Fatalf("unexpected n %d. Expecting %d", n, len(s)-len("body"))
+	}
+	expectedHeaders := []string{"Host=foo.com", "content-TYPE=text/plain", "X-Empty="}
+	if len(headers) != len(expectedHeaders) {
+		t.Fatalf("unexpected headers %q. Expecting %q", headers, expectedHeaders)
+	}
+	for i := range headers {
+		if headers[i] != expectedHeaders[i] {
+			t.Fatalf("unexpected header %q. Expecting %q", headers[i], expectedHeaders[i])
+		}
+	}
+
+	n, err = ParseHeaders([]byte("Host: foo.com\r\nX-Foo: bar\r\n"), func(key, value []byte) {
+		t.Fatalf("unexpected call for incomplete header block")
+	})
+	if err != ErrNeedMore || n != 0 {
+		t.Fatalf("unexpected result for incomplete header block: %d, %v. Expecting 0, %v", n, err, ErrNeedMore)
+	}
+}
+
+func TestParseChunk(t *testing.T) {
+	testParseChunk(t, "5\r\nhello\r\n0\r\n\r\n", "hello", 10)
+	testParseChunk(t, "0\r\n\r\n", "", 5)
+	testParseChunk(t, "1A\r\n01234567890123456789012345\r\n", "01234567890123456789012345", 32)
+
+	testParseChunkError(t, "", ErrNeedMore)
+	testParseChunkError(t, "5", ErrNeedMore)
+	testParseChunkError(t, "5\r", ErrNeedMore)
+	testParseChunkError(t, "5\r\nhel", ErrNeedMore)
+	testParseChunkError(t, "5\r\nhello\r", ErrNeedMore)
+	testParseChunkError(t, "\r\n", errEmptyHexNum)
+	testParseChunkError(t, "5;ext\r\nhello\r\n", errChunkSizeTrailing)
+	testParseChunkError(t, "5\rxhello\r\n", errChunkSizeTrailing)
+	testParseChunkError(t, "5\r\nhelloxx", errChunkMissingTrailing)
+	testParseChunkError(t, "1111111111111111111\r\n", errTooLargeHexNum)
+}
+
+func testParseChunk(t *testing.T, s, expectedData string, expectedN int) {
+	data, n, err := ParseChunk([]byte(s))
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", s, err)
+	}
+	if string(data) != expectedData {
+		t.Fatalf("unexpected data %q. Expecting %q", data, expectedData)
+	}
+	if n != expectedN {
+		t.Fatalf("unexpected n %d. Expecting %d", n, expectedN)
+	}
+}
+
+func testParseChunkError(t *testing.T, s string, expectedErr error) {
+	if _, _, err := ParseChunk([]byte(s)); err != expectedErr {
+		t.Fatalf("unexpected error when parsing %q: %v. Expecting %v", s, err, expectedErr)
+	}
+}
+
+func TestParsersNoAllocs(t *testing.T) {
+	req := []byte("GET /foo HTTP/1.1\r\nHost: foo.com\r\nUser-Agent: bar\r\n\r\n")
+	resp := []byte("HTTP/1.1 200 OK\r\n")
+	chunk := []byte("5\r\nhello\r\n")
+	var headersCount int
+	f := func(key, value []byte) {
+		headersCount++
+	}
+	n := testing.AllocsPerRun(100, func() {
+		_, _, _, m, _ := ParseRequestLine(req)
+		ParseHeaders(req[m:], f)
+		ParseStatusLine(resp)
+		ParseChunk(chunk)
+	})
+	if n != 0 {
+		t.Fatalf("unexpected number of allocations: %v", n)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 )
 
 type perIPConnCounter struct {
@@ -98,3 +99,150 @@ func uint322ip(ip uint32) net.IP {
 	b[3] = byte(ip)
 	return b
 }
+
+type perIPBanList struct {
+	lock      sync.Mutex
+	m         map[uint32]*perIPBanEntry
+	lastPrune time.Time
+}
+
+type perIPBanEntry struct {
+	violations     int
+	firstViolation time.Time
+	bannedUntil    time.Time
+
+	// expires is the time after which the entry may be removed.
+	expires time.Time
+}
+
+// perIPBanListPruneInterval is the interval between expired entries
+// removal from perIPBanList.
+const perIPBanListPruneInterval = time.Minute
+
+// getEntry returns the entry for the given ip, creating it if needed.
+//
+// It also removes expired entries from the list once per
+// perIPBanListPruneInterval, so the list doesn't grow without bound.
+//
+// bl.lock must be held by the caller.
+func (bl *perIPBanList) getEntry(ip uint32, t time.Time) *perIPBanEntry {
+	if bl.m == nil {
+		bl.m = make(map[uint32]*perIPBanEntry)
+		bl.lastPrune = t
+	}
+	if t.Sub(bl.lastPrune) > perIPBanListPruneInterval {
+		for k, e := range bl.m {
+			if t.After(e.expires) {
+				delete(bl.m, k)
+			}
+		}
+		bl.lastPrune = t
+	}
+	e := bl.m[ip]
+	if e == nil {
+		e = &perIPBanEntry{}
+		bl.m[ip] = e
+	}
+	return e
+}
+
+func (e *perIPBanEntry) extendExpiration(t time.Time) {
+	if t.After(e.expires) {
+		e.expires = t
+	}
+}
+
+// RegisterViolation registers limit violation for the given ip.
+//
+// The ip is banned for banDuration if it violates limits at least
+// threshold times during banDuration.
+// Returns true if the ip has been banned by the call.
+func (bl *perIPBanList) RegisterViolation(ip uint32, threshold int, banDuration time.Duration) bool {
+	if threshold <= 0 {
+		return false
+	}
+	t := time.Now()
+	bl.lock.Lock()
+	e := bl.getEntry(ip, t)
+	if t.Sub(e.firstViolation) > banDuration {
+		e.violations = 0
+		e.firstViolation = t
+		e.extendExpiration(t.Add(banDuration))
+	}
+	e.violations++
+	mustBan := e.violations >= threshold && !t.Before(e.bannedUntil)
+	if mustBan {
+		e.violations = 0
+		e.bannedUntil = t.Add(banDuration)
+		e.extendExpiration(e.bannedUntil)
+	}
+	bl.lock.Unlock()
+	return mustBan
+}
+
+// Ban bans the given ip for the given duration.
+func (bl *perIPBanList) Ban(ip uint32, duration time.Duration) {
+	t := time.Now()
+	bl.lock.Lock()
+	e := bl.getEntry(ip, t)
+	e.bannedUntil = t.Add(duration)
+	e.extendExpiration(e.bannedUntil)
+	bl.lock.Unlock()
+}
+
+// Unban removes the given ip from the ban list and resets its violations.
+func (bl *perIPBanList) Unban(ip uint32) {
+	bl.lock.Lock()
+	delete(bl.m, ip)
+	bl.lock.Unlock()
+}
+
+// IsBanned returns true if the given ip is banned at the moment.
+func (bl *perIPBanList) IsBanned(ip uint32) bool {
+	bl.lock.Lock()
+	if len(bl.m) == 0 {
+		bl.lock.Unlock()
+		return false
+	}
+	t := time.Now()
+	e := bl.m[ip]
+	banned := e != nil && t.Before(e.bannedUntil)
+	if e != nil && t.After(e.expires) {
+		delete(bl.m, ip)
+	}
+	bl.lock.Unlock()
+	return banned
+}
+
+// AppendBanned appends currently banned ips to dst and returns the result.
+//
+// It also removes expired entries from the list.
+func (bl *perIPBanList) AppendBanned(dst []BannedIP) []BannedIP {
+	t := time.Now()
+	bl.lock.Lock()
+	for ip, e := range bl.m {
+		if t.Before(e.bannedUntil) {
+			dst = append(dst, BannedIP{
+				IP:    uint322ip(ip),
+				Until: e.bannedUntil,
+			})
+			continue
+		}
+		if t.After(e.expires) {
+			delete(bl.m, ip)
+		}
+	}
+	bl.lock.Unlock()
+	return dst
+}
+
+// BannedIP contains information about temporarily banned ip.
+//
+// See Server.BannedIPs for details.
+type BannedIP struct {
+	// IP is the banned ip address.
+	IP net.IP
+
+	// Until is the time when the ban expires.
+	Until time.Time
+}
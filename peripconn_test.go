@@ -2,6 +2,7 @@ package fasthttp
 
 import (
 	"testing"
+	"time"
 )
 
 func TestIPxUint32(t *testing.T) {
@@ -49,6 +50,70 @@ func TestPerIPConnCounter(t *testing.T) {
 	cc.Unregister(123)
 }
 
+func TestPerIPBanList(t *testing.T) {
+	var bl perIPBanList
+
+	if bl.IsBanned(123) {
+		t.Fatalf("Unexpected ban for empty list")
+	}
+	if bl.RegisterViolation(123, 0, time.Minute) {
+		t.Fatalf("Unexpected ban with zero threshold")
+	}
+	for i := 1; i < 3; i++ {
+		if bl.RegisterViolation(123, 3, time.Minute) {
+			t.Fatalf("Unexpected ban after %d violations", i)
+		}
+	}
+	if !bl.RegisterViolation(123, 3, time.Minute) {
+		t.Fatalf("Expecting ban after 3 violations")
+	}
+	if !bl.IsBanned(123) {
+		t.Fatalf("Expecting banned ip")
+	}
+	if bl.IsBanned(456) {
+		t.Fatalf("Unexpected ban for ip without violations")
+	}
+
+	bl.Ban(456, -time.Second)
+	if bl.IsBanned(456) {
+		t.Fatalf("Unexpected ban for expired entry")
+	}
+	if _, ok := bl.m[456]; ok {
+		t.Fatalf("Expired entry must be removed on lookup")
+	}
+	banned := bl.AppendBanned(nil)
+	if len(banned) != 1 || ip2uint32(banned[0].IP) != 123 {
+		t.Fatalf("Unexpected banned ips: %v", banned)
+	}
+
+	bl.Unban(123)
+	if bl.IsBanned(123) {
+		t.Fatalf("Unexpected ban after Unban")
+	}
+}
+
+func TestPerIPBanListPrune(t *testing.T) {
+	var bl perIPBanList
+
+	for ip := uint32(1); ip <= 100; ip++ {
+		bl.Ban(ip, -time.Second)
+	}
+	bl.Ban(1000, time.Minute)
+	if len(bl.m) != 101 {
+		t.Fatalf("Unexpected number of entries: %d. Expecting 101", len(bl.m))
+	}
+
+	// Force pruning on the next insert.
+	bl.lastPrune = time.Now().Add(-2 * perIPBanListPruneInterval)
+	bl.RegisterViolation(2000, 3, time.Minute)
+	if len(bl.m) != 2 {
+		t.Fatalf("Unexpected number of entries after pruning: %d. Expecting 2", len(bl.m))
+	}
+	if !bl.IsBanned(1000) {
+		t.Fatalf("Expecting banned ip after pruning")
+	}
+}
+
 func expectPanic(t *testing.T, f func()) {
 	defer func() {
 		if r := recover(); r == nil {
@@ -0,0 +1,159 @@
+package fasthttp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ProtocolErrorCounts holds the number of connections closed
+// by the server due to protocol errors.
+type ProtocolErrorCounts struct {
+	// MalformedRequest is the number of requests, which couldn't be parsed.
+	MalformedRequest uint64
+
+	// HeaderTooLarge is the number of requests with headers exceeding
+	// Server.ReadBufferSize.
+	HeaderTooLarge uint64
+
+	// BodyTooLarge is the number of requests with bodies exceeding
+	// Server.MaxRequestBodySize.
+	BodyTooLarge uint64
+
+	// Timeout is the number of connections closed due to read or write
+	// timeouts in the middle of the request or the response.
+	Timeout uint64
+}
+
+// ProtocolErrorStats is a snapshot of protocol error counters returned
+// by Server.ProtocolErrorStats.
+type ProtocolErrorStats struct {
+	// Total contains the number of all the protocol errors.
+	Total ProtocolErrorCounts
+
+	// PerIP contains the number of protocol errors per client IP
+	// if Server.ProtocolErrorStatsPerIP is set.
+	PerIP map[string]ProtocolErrorCounts
+}
+
+// maxProtocolErrorIPs is the maximum number of client IPs tracked
+// for Server.ProtocolErrorStatsPerIP.
+const maxProtocolErrorIPs = 10000
+
+// ProtocolErrorStats returns a snapshot of protocol error counters
+// for the server.
+//
+// The counters make abuse patterns such as scanners sending malformed
+// requests or slowloris attacks visible without parsing error logs.
+func (s *Server) ProtocolErrorStats() ProtocolErrorStats {
+	ps := s.getProtocolErrorStats()
+	st := ProtocolErrorStats{
+		Total: ps.total.snapshot(),
+	}
+	if s.ProtocolErrorStatsPerIP {
+		ps.lock.Lock()
+		st.PerIP = make(map[string]ProtocolErrorCounts, len(ps.perIP))
+		for ip, pc := range ps.perIP {
+			st.PerIP[ip] = pc.snapshot()
+		}
+		ps.lock.Unlock()
+	}
+	return st
+}
+
+type serverProtocolErrorStats struct {
+	total protocolErrorCounters
+
+	lock  sync.Mutex
+	perIP map[string]*protocolErrorCounters
+}
+
+// protocolErrorCounters must be allocated separately, so its members
+// are 64-bit aligned on 32-bit architectures.
+type protocolErrorCounters [4]uint64
+
+const (
+	protocolErrorMalformedRequest = iota
+	protocolErrorHeaderTooLarge
+	protocolErrorBodyTooLarge
+	protocolErrorTimeout
+)
+
+func (pc *protocolErrorCounters) snapshot() ProtocolErrorCounts {
+	return ProtocolErrorCounts{
+		MalformedRequest: atomic.LoadUint64(&pc[protocolErrorMalformedRequest]),
+		HeaderTooLarge:   atomic.LoadUint64(&pc[protocolErrorHeaderTooLarge]),
+		BodyTooLarge:     atomic.LoadUint64(&pc[protocolErrorBodyTooLarge]),
+		Timeout:          atomic.LoadUint64(&pc[protocolErrorTimeout]),
+	}
+}
+
+func (s *Server) getProtocolErrorStats() *serverProtocolErrorStats {
+	s.protocolErrorStatsOnce.Do(func() {
+		s.protocolErrorStats = &serverProtocolErrorStats{
+			perIP: make(map[string]*protocolErrorCounters),
+		}
+	})
+	return s.protocolErrorStats
+}
+
+// classifyProtocolError returns the protocol error kind for err
+// returned while reading the request.
+//
+// false is returned if err isn't a protocol error, e.g. if the client
+// resets the connection.
+func classifyProtocolError(err error) (int, bool) {
+	var ne net.Error
+	var sbe *ErrSmallBuffer
+	switch {
+	case errors.As(err, &sbe):
+		return protocolErrorHeaderTooLarge, true
+	case errors.Is(err, ErrBodyTooLarge):
+		return protocolErrorBodyTooLarge, true
+	case errors.As(err, &ne):
+		return protocolErrorTimeout, ne.Timeout()
+	case errors.Is(err, errHijacked):
+		return 0, false
+	}
+	return protocolErrorMalformedRequest, true
+}
+
+// countProtocolError updates protocol error counters for the given kind.
+func (s *Server) countProtocolError(c net.Conn, kind int) {
+	ps := s.getProtocolErrorStats()
+	atomic.AddUint64(&ps.total[kind], 1)
+	if !s.ProtocolErrorStatsPerIP {
+		return
+	}
+	ip, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		ip = c.RemoteAddr().String()
+	}
+	ps.lock.Lock()
+	pc := ps.perIP[ip]
+	if pc == nil && len(ps.perIP) < maxProtocolErrorIPs {
+		pc = &protocolErrorCounters{}
+		ps.perIP[ip] = pc
+	}
+	ps.lock.Unlock()
+	if pc != nil {
+		atomic.AddUint64(&pc[kind], 1)
+	}
+}
+
+// countReadError updates protocol error counters for err returned
+// while reading the request.
+func (s *Server) countReadError(c net.Conn, err error) {
+	if kind, ok := classifyProtocolError(err); ok {
+		s.countProtocolError(c, kind)
+	}
+}
+
+// countWriteError updates protocol error counters if err returned
+// while writing the response is a timeout.
+func (s *Server) countWriteError(c net.Conn, err error) {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		s.countProtocolError(c, protocolErrorTimeout)
+	}
+}
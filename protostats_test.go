@@ -0,0 +1,65 @@
+package fasthttp
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestServerProtocolErrorStats(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("ok")
+		},
+		ReadBufferSize:          1024,
+		MaxRequestBodySize:      10,
+		ProtocolErrorStatsPerIP: true,
+		Logger:                  &customLogger{},
+	}
+
+	requests := []string{
+		"GET / HTTP/1.1\r\nHost: aaa\r\n\r\n",
+		"GET\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: aaa\r\nX-Foo: " + strings.Repeat("x", 2048) + "\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: aaa\r\nContent-Length: 100\r\n\r\n" + strings.Repeat("x", 100),
+	}
+	for _, req := range requests {
+		rw := &readWriter{}
+		rw.r.WriteString(req)
+		s.ServeConn(rw)
+	}
+
+	expected := ProtocolErrorCounts{
+		MalformedRequest: 1,
+		HeaderTooLarge:   1,
+		BodyTooLarge:     1,
+	}
+	st := s.ProtocolErrorStats()
+	if st.Total != expected {
+		t.Fatalf("unexpected total counts: %+v. Expecting %+v", st.Total, expected)
+	}
+	ip := zeroTCPAddr.IP.String()
+	if len(st.PerIP) != 1 || st.PerIP[ip] != expected {
+		t.Fatalf("unexpected per-ip counts: %+v. Expecting %+v for %s", st.PerIP, expected, ip)
+	}
+}
+
+func TestClassifyProtocolError(t *testing.T) {
+	testClassifyProtocolError(t, io.ErrUnexpectedEOF, protocolErrorMalformedRequest, true)
+	testClassifyProtocolError(t, ErrBodyTooLarge, protocolErrorBodyTooLarge, true)
+	testClassifyProtocolError(t, &ErrSmallBuffer{error: fmt.Errorf("foo: %w", errSmallBuffer)}, protocolErrorHeaderTooLarge, true)
+	testClassifyProtocolError(t, fmt.Errorf("error when reading request body: %w", timeoutReaderError{}), protocolErrorTimeout, true)
+	testClassifyProtocolError(t, errHijacked, 0, false)
+}
+
+func testClassifyProtocolError(t *testing.T, err error, expectedKind int, expectedOK bool) {
+	t.Helper()
+	kind, ok := classifyProtocolError(err)
+	if ok != expectedOK {
+		t.Fatalf("unexpected ok for %v: %v. Expecting %v", err, ok, expectedOK)
+	}
+	if ok && kind != expectedKind {
+		t.Fatalf("unexpected kind for %v: %d. Expecting %d", err, kind, expectedKind)
+	}
+}
@@ -0,0 +1,71 @@
+package fasthttp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting the rate of requests
+// sent by HostClient.
+//
+// The bucket may go into debt, so concurrent requests are queued
+// in the order they call reserve.
+type rateLimiter struct {
+	lock sync.Mutex
+
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token from the bucket and returns the duration
+// the request must wait before it may be sent.
+func (rl *rateLimiter) reserve(limit float64, burst int, now time.Time) time.Duration {
+	if burst <= 0 {
+		burst = 1
+	}
+	maxTokens := float64(burst)
+
+	rl.lock.Lock()
+	if rl.last.IsZero() {
+		rl.tokens = maxTokens
+	} else if elapsed := now.Sub(rl.last); elapsed > 0 {
+		rl.tokens += elapsed.Seconds() * limit
+		if rl.tokens > maxTokens {
+			rl.tokens = maxTokens
+		}
+	}
+	if now.After(rl.last) {
+		rl.last = now
+	}
+	rl.tokens--
+	tokens := rl.tokens
+	rl.lock.Unlock()
+
+	if tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tokens / limit * float64(time.Second))
+}
+
+// cancel returns the token taken by reserve to the bucket
+// if the request isn't sent.
+func (rl *rateLimiter) cancel() {
+	rl.lock.Lock()
+	rl.tokens++
+	rl.lock.Unlock()
+}
+
+// waitRateLimit blocks until the request may be sent according
+// to RateLimit or until ctx is canceled.
+func (c *HostClient) waitRateLimit(ctx context.Context) error {
+	if c.RateLimit <= 0 {
+		return nil
+	}
+	d := c.rateLimiter.reserve(c.RateLimit, c.RateLimitBurst, time.Now())
+	if err := sleepBackoff(ctx, d); err != nil {
+		c.rateLimiter.cancel()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	var rl rateLimiter
+	now := time.Now()
+
+	// The burst is available at once.
+	for i := 0; i < 3; i++ {
+		if d := rl.reserve(10, 3, now); d != 0 {
+			t.Fatalf("unexpected wait duration for request #%d: %s", i, d)
+		}
+	}
+
+	// The following requests are queued.
+	if d := rl.reserve(10, 3, now); d != 100*time.Millisecond {
+		t.Fatalf("unexpected wait duration: %s. Expecting %s", d, 100*time.Millisecond)
+	}
+	if d := rl.reserve(10, 3, now); d != 200*time.Millisecond {
+		t.Fatalf("unexpected wait duration: %s. Expecting %s", d, 200*time.Millisecond)
+	}
+
+	// Canceled requests return the token.
+	rl.cancel()
+	if d := rl.reserve(10, 3, now); d != 200*time.Millisecond {
+		t.Fatalf("unexpected wait duration: %s. Expecting %s", d, 200*time.Millisecond)
+	}
+
+	// Tokens are refilled up to the burst.
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if d := rl.reserve(10, 3, now); d != 0 {
+			t.Fatalf("unexpected wait duration for request #%d: %s", i, d)
+		}
+	}
+	if d := rl.reserve(10, 3, now); d == 0 {
+		t.Fatalf("expecting non-zero wait duration after the burst")
+	}
+}
+
+func TestHostClientRateLimit(t *testing.T) {
+	var dials []time.Time
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			dials = append(dials, time.Now())
+			return nil, errors.New("host is down")
+		},
+		RateLimit:      20,
+		RateLimitBurst: 2,
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/")
+	for i := 0; i < 4; i++ {
+		if err := c.Do(req, nil); err == nil {
+			t.Fatalf("expecting non-nil error")
+		}
+	}
+	if len(dials) != 4 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 4", len(dials))
+	}
+	if d := dials[3].Sub(dials[0]); d < 90*time.Millisecond {
+		t.Fatalf("too small delay between the first and the last requests: %s", d)
+	}
+
+	// The wait is interrupted on context cancellation.
+	c.RateLimit = 0.001
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.DoCtx(ctx, req, nil); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.DeadlineExceeded)
+	}
+	if len(dials) != 4 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 4", len(dials))
+	}
+}
@@ -0,0 +1,100 @@
+package fasthttp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// RecordHandler receives raw bytes of a recorded request and response.
+//
+// request contains raw request header followed by request body.
+// Chunked request bodies are recorded after dechunking. response contains
+// raw response bytes as they were sent to the client. Both are truncated
+// to Server.MaxRecordSize.
+//
+// RecordHandler mustn't retain request and response byte slices.
+// Copy them if they must be used after returning from RecordHandler.
+type RecordHandler func(remoteAddr net.Addr, request, response []byte)
+
+const defaultMaxRecordSize = 64 * 1024
+
+// connRecorder records raw request and response bytes for a single request
+// served by the connection.
+type connRecorder struct {
+	w     io.Writer
+	limit int
+
+	req  []byte
+	resp []byte
+}
+
+// Write records p as response bytes and writes it to the connection.
+func (r *connRecorder) Write(p []byte) (int, error) {
+	r.resp = appendLimited(r.resp, p, r.limit)
+	return r.w.Write(p)
+}
+
+func (r *connRecorder) reset(w io.Writer, limit int) {
+	r.w = w
+	r.limit = limit
+	r.req = r.req[:0]
+	r.resp = r.resp[:0]
+}
+
+func appendLimited(dst, src []byte, limit int) []byte {
+	n := limit - len(dst)
+	if n <= 0 {
+		return dst
+	}
+	if n > len(src) {
+		n = len(src)
+	}
+	return append(dst, src[:n]...)
+}
+
+// shouldRecord returns true if the next request must be recorded.
+func (s *Server) shouldRecord() bool {
+	if s.RecordHandler == nil {
+		return false
+	}
+	n := s.RecordSampling
+	if n <= 1 {
+		return true
+	}
+	return atomic.AddUint32(&s.recordCounter, 1)%uint32(n) == 0
+}
+
+func (s *Server) getMaxRecordSize() int {
+	if s.MaxRecordSize <= 0 {
+		return defaultMaxRecordSize
+	}
+	return s.MaxRecordSize
+}
+
+// startRecordResponse redirects bw writes to rec, so the response
+// is recorded while being written to the connection.
+func startRecordResponse(bw *bufio.Writer, ctx *RequestCtx, rec *connRecorder) *bufio.Writer {
+	if bw == nil {
+		bw = acquireWriter(ctx)
+	} else {
+		// Flush previously buffered responses, so they aren't recorded.
+		// Write errors are returned by the subsequent writes.
+		bw.Flush()
+	}
+	bw.Reset(rec)
+	return bw
+}
+
+// finishRecord flushes the recorded response and passes the recording
+// to Server.RecordHandler.
+func (s *Server) finishRecord(bw *bufio.Writer, ctx *RequestCtx, rec *connRecorder) error {
+	err := bw.Flush()
+	bw.Reset(rec.w)
+	if len(rec.req) > rec.limit {
+		rec.req = rec.req[:rec.limit]
+	}
+	s.RecordHandler(ctx.RemoteAddr(), rec.req, rec.resp)
+	return err
+}
@@ -0,0 +1,234 @@
+package fasthttp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// NonceStore remembers nonces of accepted requests for ReplayProtection.
+//
+// NonceStore implementation must be safe for concurrent use.
+type NonceStore interface {
+	// CheckAndStore must return false if the nonce is already stored.
+	// Otherwise it must store the nonce until the given expiration time
+	// and return true.
+	CheckAndStore(nonce string, expiration time.Time) bool
+}
+
+// ReplayProtection rejects replayed requests, i.e. requests with
+// timestamps outside the allowed window and requests with nonces seen
+// before.
+//
+// If Secret is set, then requests must be signed with HMAC-SHA256
+// over the method, the request uri, the timestamp, the nonce and the body.
+// The signature is checked in constant time before the nonce is stored,
+// so unsigned requests cannot fill up the nonce store. Use Sign
+// as Client.SignRequest for signing outgoing requests.
+//
+// It is safe calling ReplayProtection methods from concurrently running
+// goroutines.
+type ReplayProtection struct {
+	// Header containing request timestamp in unix seconds.
+	//
+	// By default X-Timestamp header is used.
+	TimestampHeader string
+
+	// Header containing unique request nonce.
+	//
+	// By default X-Nonce header is used.
+	NonceHeader string
+
+	// Header containing hex-encoded request signature.
+	//
+	// By default X-Signature header is used.
+	SignatureHeader string
+
+	// Secret key for request signatures.
+	//
+	// By default request signatures aren't checked.
+	Secret []byte
+
+	// Maximum difference between request timestamp and the current time.
+	//
+	// By default 5 minutes window is used.
+	Window time.Duration
+
+	// Store for nonces of accepted requests.
+	//
+	// Nonces must be shared among all the servers accepting the requests
+	// for complete protection, so set it to a shared store if the requests
+	// are load-balanced among multiple servers.
+	//
+	// By default nonces are stored in memory.
+	Store NonceStore
+
+	storeOnce   sync.Once
+	memoryStore *memoryNonceStore
+}
+
+const maxNonceLen = 128
+
+var (
+	errReplayTimestamp = errors.New("missing or invalid request timestamp")
+	errReplayNonce     = errors.New("missing or invalid request nonce")
+	errReplaySignature = errors.New("invalid request signature")
+	errReplayed        = errors.New("replayed request")
+	errSignBodyStream  = errors.New("cannot sign request with body stream")
+)
+
+// Handler returns RequestHandler, which calls h only for valid requests,
+// which aren't replayed.
+//
+// StatusUnauthorized is returned for the rest of requests.
+func (rp *ReplayProtection) Handler(h RequestHandler) RequestHandler {
+	return func(ctx *RequestCtx) {
+		if err := rp.Check(ctx, time.Now()); err != nil {
+			ctx.Error(err.Error(), StatusUnauthorized)
+			return
+		}
+		h(ctx)
+	}
+}
+
+// Check returns non-nil error if the request in ctx is invalid
+// or replayed at the given time.
+func (rp *ReplayProtection) Check(ctx *RequestCtx, now time.Time) error {
+	req := &ctx.Request
+	timestamp := req.Header.Peek(rp.timestampHeader())
+	ts, err := ParseUint(timestamp)
+	if err != nil {
+		return errReplayTimestamp
+	}
+	t := time.Unix(int64(ts), 0)
+	window := rp.window()
+	if t.Before(now.Add(-window)) || t.After(now.Add(window)) {
+		return errReplayTimestamp
+	}
+	nonce := req.Header.Peek(rp.nonceHeader())
+	if len(nonce) == 0 || len(nonce) > maxNonceLen {
+		return errReplayNonce
+	}
+	if len(rp.Secret) > 0 {
+		sig, err := hex.DecodeString(b2s(req.Header.Peek(rp.signatureHeader())))
+		if err != nil || !hmac.Equal(sig, rp.signature(req, timestamp, nonce)) {
+			return errReplaySignature
+		}
+	}
+	if !rp.store().CheckAndStore(string(nonce), t.Add(window)) {
+		return errReplayed
+	}
+	return nil
+}
+
+// Sign sets timestamp and nonce headers and signs req with Secret.
+//
+// The existing timestamp and nonce headers are left as is. The function
+// may be used as Client.SignRequest. Requests with body stream cannot
+// be signed.
+func (rp *ReplayProtection) Sign(req *Request) error {
+	if req.IsBodyStream() {
+		return errSignBodyStream
+	}
+	timestampHeader := rp.timestampHeader()
+	if len(req.Header.Peek(timestampHeader)) == 0 {
+		req.Header.SetBytesV(timestampHeader, AppendUint(nil, int(time.Now().Unix())))
+	}
+	nonceHeader := rp.nonceHeader()
+	if len(req.Header.Peek(nonceHeader)) == 0 {
+		var nonce [16]byte
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return err
+		}
+		req.Header.Set(nonceHeader, hex.EncodeToString(nonce[:]))
+	}
+	if len(rp.Secret) > 0 {
+		sig := rp.signature(req, req.Header.Peek(timestampHeader), req.Header.Peek(nonceHeader))
+		req.Header.Set(rp.signatureHeader(), hex.EncodeToString(sig))
+	}
+	return nil
+}
+
+func (rp *ReplayProtection) signature(req *Request, timestamp, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, rp.Secret)
+	mac.Write(req.Header.Method())
+	mac.Write(strLF)
+	mac.Write(req.Header.RequestURI())
+	mac.Write(strLF)
+	mac.Write(timestamp)
+	mac.Write(strLF)
+	mac.Write(nonce)
+	mac.Write(strLF)
+	mac.Write(req.Body())
+	return mac.Sum(nil)
+}
+
+func (rp *ReplayProtection) timestampHeader() string {
+	if len(rp.TimestampHeader) == 0 {
+		return "X-Timestamp"
+	}
+	return rp.TimestampHeader
+}
+
+func (rp *ReplayProtection) nonceHeader() string {
+	if len(rp.NonceHeader) == 0 {
+		return "X-Nonce"
+	}
+	return rp.NonceHeader
+}
+
+func (rp *ReplayProtection) signatureHeader() string {
+	if len(rp.SignatureHeader) == 0 {
+		return "X-Signature"
+	}
+	return rp.SignatureHeader
+}
+
+func (rp *ReplayProtection) window() time.Duration {
+	if rp.Window <= 0 {
+		return 5 * time.Minute
+	}
+	return rp.Window
+}
+
+func (rp *ReplayProtection) store() NonceStore {
+	if rp.Store != nil {
+		return rp.Store
+	}
+	rp.storeOnce.Do(func() {
+		rp.memoryStore = &memoryNonceStore{
+			m: make(map[string]time.Time),
+		}
+	})
+	return rp.memoryStore
+}
+
+// memoryNonceStore is the default in-memory NonceStore.
+type memoryNonceStore struct {
+	lock        sync.Mutex
+	m           map[string]time.Time
+	nextCleanup time.Time
+}
+
+func (s *memoryNonceStore) CheckAndStore(nonce string, expiration time.Time) bool {
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if now.After(s.nextCleanup) {
+		for k, exp := range s.m {
+			if now.After(exp) {
+				delete(s.m, k)
+			}
+		}
+		s.nextCleanup = now.Add(time.Second)
+	}
+	if exp, ok := s.m[nonce]; ok && !now.After(exp) {
+		return false
+	}
+	s.m[nonce] = expiration
+	return true
+}
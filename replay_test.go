@@ -0,0 +1,175 @@
+package fasthttp
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func testReplayProtectionCheck(t *testing.T, rp *ReplayProtection, req *Request, now time.Time, expectedErr error) {
+	t.Helper()
+	var ctx RequestCtx
+	req.CopyTo(&ctx.Request)
+	if err := rp.Check(&ctx, now); err != expectedErr {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, expectedErr)
+	}
+}
+
+func TestReplayProtectionCheck(t *testing.T) {
+	rp := &ReplayProtection{
+		Secret: []byte("secret"),
+		Window: time.Minute,
+	}
+	var req Request
+	req.Header.SetMethod("POST")
+	req.SetRequestURI("/foo?bar=baz")
+	req.SetBodyString("foobar")
+	if err := rp.Sign(&req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	now := time.Now()
+	testReplayProtectionCheck(t, rp, &req, now, nil)
+
+	// The same nonce is rejected.
+	testReplayProtectionCheck(t, rp, &req, now, errReplayed)
+
+	// Modified requests are rejected.
+	req.Header.Set("X-Nonce", "other-nonce")
+	testReplayProtectionCheck(t, rp, &req, now, errReplaySignature)
+	if err := rp.Sign(&req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	req.SetBodyString("barbaz")
+	testReplayProtectionCheck(t, rp, &req, now, errReplaySignature)
+	req.Header.Del("X-Signature")
+	testReplayProtectionCheck(t, rp, &req, now, errReplaySignature)
+
+	// Requests outside the window are rejected.
+	if err := rp.Sign(&req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testReplayProtectionCheck(t, rp, &req, now.Add(2*time.Minute), errReplayTimestamp)
+	testReplayProtectionCheck(t, rp, &req, now.Add(-2*time.Minute), errReplayTimestamp)
+	req.Header.Set("X-Timestamp", "foobar")
+	testReplayProtectionCheck(t, rp, &req, now, errReplayTimestamp)
+
+	req.Header.Del("X-Nonce")
+	req.Header.Del("X-Timestamp")
+	if err := rp.Sign(&req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	req.Header.Del("X-Nonce")
+	testReplayProtectionCheck(t, rp, &req, now, errReplayNonce)
+}
+
+type testNonceStore struct {
+	nonces map[string]time.Time
+}
+
+func (s *testNonceStore) CheckAndStore(nonce string, expiration time.Time) bool {
+	if _, ok := s.nonces[nonce]; ok {
+		return false
+	}
+	s.nonces[nonce] = expiration
+	return true
+}
+
+func TestReplayProtectionStore(t *testing.T) {
+	store := &testNonceStore{
+		nonces: make(map[string]time.Time),
+	}
+	rp := &ReplayProtection{
+		NonceHeader:     "Nonce",
+		TimestampHeader: "Timestamp",
+		Store:           store,
+	}
+	var req Request
+	req.Header.Set("Nonce", "foobar")
+	if err := rp.Sign(&req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(req.Header.Peek("X-Signature")) > 0 {
+		t.Fatalf("unexpected signature for empty Secret: %q", req.Header.Peek("X-Signature"))
+	}
+	now := time.Now()
+	testReplayProtectionCheck(t, rp, &req, now, nil)
+	testReplayProtectionCheck(t, rp, &req, now, errReplayed)
+	exp, ok := store.nonces["foobar"]
+	if !ok {
+		t.Fatalf("missing nonce in the store")
+	}
+	if d := exp.Sub(now); d < 4*time.Minute || d > 6*time.Minute {
+		t.Fatalf("unexpected nonce expiration in %s. Expecting 5m", d)
+	}
+}
+
+func TestMemoryNonceStore(t *testing.T) {
+	s := &memoryNonceStore{
+		m: make(map[string]time.Time),
+	}
+	if !s.CheckAndStore("foo", time.Now().Add(time.Minute)) {
+		t.Fatalf("expecting new nonce to be stored")
+	}
+	if s.CheckAndStore("foo", time.Now().Add(time.Minute)) {
+		t.Fatalf("expecting stored nonce to be rejected")
+	}
+
+	// Expired nonces are removed.
+	s.CheckAndStore("bar", time.Now().Add(-time.Second))
+	s.nextCleanup = time.Time{}
+	s.CheckAndStore("baz", time.Now().Add(time.Minute))
+	if _, ok := s.m["bar"]; ok {
+		t.Fatalf("expired nonce must be removed")
+	}
+}
+
+func TestReplayProtectionHandler(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	rp := &ReplayProtection{
+		Secret: []byte("secret"),
+	}
+	var calls uint32
+	s := &Server{
+		Handler: rp.Handler(func(ctx *RequestCtx) {
+			atomic.AddUint32(&calls, 1)
+		}),
+	}
+	go s.Serve(ln)
+
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		SignRequest: rp.Sign,
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar/foo")
+	req.Header.SetMethod("PUT")
+	req.SetBodyString("foobar")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+
+	// The replayed request is rejected.
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusUnauthorized {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusUnauthorized)
+	}
+	if n := atomic.LoadUint32(&calls); n != 1 {
+		t.Fatalf("unexpected number of handler calls: %d. Expecting 1", n)
+	}
+}
@@ -2,6 +2,7 @@ package fasthttp
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"mime/multipart"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -136,6 +138,10 @@ type RequestHandler func(ctx *RequestCtx)
 //
 // It is safe to call Server methods from concurrently running goroutines.
 type Server struct {
+	// inFlightResponseBytes is accessed atomically, so it must be 64-bit
+	// aligned on 32-bit arches. Keep it at the beginning of the struct.
+	inFlightResponseBytes int64
+
 	noCopy noCopy
 
 	// Handler for processing incoming requests.
@@ -151,6 +157,81 @@ type Server struct {
 	// DefaultConcurrency is used if not set.
 	Concurrency int
 
+	// The number of worker pool shards.
+	//
+	// Each shard has its own goroutine accepting incoming connections,
+	// its own lock and its own list of idle worker goroutines.
+	// Connections accepted by the given acceptor are served by worker
+	// goroutines pinned to the same shard, so multiple connections may be
+	// accepted per scheduler wakeup and contention on worker pool lock
+	// is reduced at very high connection rates. Concurrency is split
+	// evenly among shards. A connection is served by other shards
+	// if all the workers in its own shard are busy.
+	//
+	// By default a single shard is used.
+	WorkerPoolShards int
+
+	// Maximum duration for idle worker goroutine to wait for a new
+	// connection before exiting.
+	//
+	// Increase this duration for re-using worker goroutines
+	// under bursty connection rates.
+	//
+	// By default idle worker goroutines exit after 10 seconds.
+	MaxIdleWorkerDuration time.Duration
+
+	// Callback for setting socket options on each accepted connection
+	// before it is served.
+	//
+	// The connection is closed if ConnControl returns an error.
+	//
+	// Socket options aren't set by default.
+	ConnControl ConnControlFunc
+
+	// Callback for filtering accepted connections by the client address,
+	// e.g. for ip blocklists, allowlists and geo filters.
+	//
+	// The connection is closed without sending a response if AcceptFilter
+	// returns false. AcceptFilter is called before any buffers are allocated
+	// for the connection, so rejected connections are cheap.
+	// It is called from the goroutine accepting connections,
+	// so it must return quickly.
+	//
+	// By default all the connections are accepted.
+	AcceptFilter func(c net.Conn, addr net.Addr) bool
+
+	// Maximum duration for written response data to remain unacknowledged
+	// by the client before the connection is closed.
+	//
+	// TCP_USER_TIMEOUT socket option is used on Linux. Write deadline
+	// is set before each write on other platforms and for connections
+	// without TCP_USER_TIMEOUT support. This allows reclaiming connections
+	// to vanished clients quickly. Hijacked connections aren't limited.
+	//
+	// By default OS settings are used, so connections with stalled
+	// writes may remain open for many minutes.
+	TCPUserTimeout time.Duration
+
+	// Minimum rate in bytes per second for writing responses to clients.
+	//
+	// Connections to clients reading responses slower than the rate
+	// during MinWriteRateWindow are closed. Unlike WriteTimeout, which
+	// limits the whole response write, the rate allows sending large
+	// responses to fast clients, while pathologically slow readers
+	// don't hold connections and response buffers for a long time.
+	// Hijacked connections aren't limited.
+	//
+	// By default the write rate isn't limited.
+	MinWriteRate int
+
+	// The window for measuring the write rate for MinWriteRate.
+	//
+	// Each MinWriteRate*MinWriteRateWindow bytes of the response must be
+	// written during the window, so short stalls are tolerated.
+	//
+	// By default 10 seconds window is used.
+	MinWriteRateWindow time.Duration
+
 	// Whether to disable keep-alive connections.
 	//
 	// The server will close all the incoming connections after sending
@@ -159,6 +240,43 @@ type Server struct {
 	// By default keep-alive connections are enabled.
 	DisableKeepalive bool
 
+	// Whether to ignore 'Connection: keep-alive' header in HTTP/1.0 requests.
+	//
+	// The server closes HTTP/1.0 connections after sending the first
+	// response if this option is set to true.
+	//
+	// By default HTTP/1.0 connections are kept alive if the request
+	// contains 'Connection: keep-alive' header.
+	DisableHTTP10KeepAlive bool
+
+	// Policy for request bodies shorter than Content-Length.
+	//
+	// Such requests are passed to RequestHandler with partial bodies
+	// if the policy is BodyLengthMismatchTruncate or BodyLengthMismatchAccept.
+	// The connection is closed after sending the response for such requests.
+	//
+	// By default such requests are rejected with StatusBadRequest.
+	BodyLengthMismatchPolicy BodyLengthMismatchPolicy
+
+	// Limits for decompressing request bodies via Request.BodyGunzip,
+	// BodyInflate, BodyUnbrotli and BodyUnzstd* in RequestHandler.
+	//
+	// *DecompressLimitError is returned from these methods if
+	// the decompressed body exceeds the limits.
+	//
+	// By default decompressed request bodies aren't limited.
+	DecompressLimits *DecompressLimits
+
+	// Rejects HTTP/1.0 requests with StatusHTTPVersionNotSupported
+	// if set to true.
+	//
+	// This may be useful for modern-only deployments.
+	//
+	// By default HTTP/1.0 requests are served. Streamed response bodies
+	// with unknown size are delimited by closing the connection
+	// for HTTP/1.0 clients, since they don't support chunked encoding.
+	RejectHTTP10 bool
+
 	// Per-connection buffer size for requests' reading.
 	// This also limits the maximum header size.
 	//
@@ -192,6 +310,30 @@ type Server struct {
 	// may be established to the server from a single IP address.
 	MaxConnsPerIP int
 
+	// Maximum number of concurrent requests served per IP.
+	//
+	// The server responds with StatusTooManyRequests to requests
+	// exceeding the limit.
+	//
+	// By default unlimited number of concurrent requests
+	// may be served from a single IP address.
+	MaxRequestsPerIP int
+
+	// The number of MaxConnsPerIP and MaxRequestsPerIP violations
+	// after which the IP is banned for PerIPBanDuration.
+	//
+	// Connections from banned IPs are closed immediately after
+	// StatusForbidden response. Violations are counted
+	// over PerIPBanDuration interval.
+	//
+	// By default IPs aren't banned automatically. See also Server.BanIP.
+	PerIPBanThreshold int
+
+	// The duration of automatic IP ban.
+	//
+	// DefaultPerIPBanDuration is used if not set.
+	PerIPBanDuration time.Duration
+
 	// Maximum number of requests served per connection.
 	//
 	// The server closes connection after the last request.
@@ -213,11 +355,38 @@ type Server struct {
 
 	// Maximum request body size.
 	//
-	// The server rejects requests with bodies exceeding this limit.
+	// The server rejects requests with bodies exceeding this limit
+	// with StatusRequestEntityTooLarge.
 	//
 	// Request body size is limited by DefaultMaxRequestBodySize by default.
 	MaxRequestBodySize int
 
+	// Maximum size of streamed response bodies with unknown size, which
+	// are buffered in order to be sent with Content-Length header.
+	//
+	// Bodies exceeding the size are sent with chunked transfer encoding
+	// after the buffered part. Content-Length is preferred by some proxies
+	// and clients, while chunked encoding sends the first bytes sooner,
+	// since the body isn't buffered.
+	//
+	// By default response bodies set via SetBodyStream with negative
+	// size and via SetBodyStreamWriter are sent with chunked encoding.
+	MaxBufferedStreamBodySize int
+
+	// Maximum total size of buffered response bodies, which are being sent
+	// to clients over all the connections.
+	//
+	// The server degrades gracefully when the limit is exceeded,
+	// e.g. because many slow clients download large responses: streamed
+	// bodies are sent unbuffered with chunked encoding regardless
+	// of MaxBufferedStreamBodySize, and large body buffers aren't kept
+	// for reuse by keep-alive connections. Handlers may switch
+	// to SetBodyStreamWriter for large responses
+	// if RequestCtx.InFlightResponseBytesExceeded returns true.
+	//
+	// By default buffered response bodies aren't limited.
+	MaxInFlightResponseBytes int
+
 	// Aggressively reduces memory usage at the cost of higher CPU usage
 	// if set to true.
 	//
@@ -237,6 +406,180 @@ type Server struct {
 	// Server accepts all the requests by default.
 	GetOnly bool
 
+	// ContinueHandler is called for requests with 'Expect: 100-continue'
+	// header before reading request body.
+	//
+	// Only request headers are available in ctx at the moment.
+	// The handler may reject the request by returning false, so the body
+	// isn't requested from the client. The response set by the handler
+	// (for instance, via ctx.Error("Unauthorized", StatusUnauthorized))
+	// is sent to the client in this case. StatusExpectationFailed
+	// is sent if the handler doesn't set response status code.
+	//
+	// The connection is closed after the rejection, since the client
+	// may send the request body anyway.
+	//
+	// By default all the requests with 'Expect: 100-continue' are accepted.
+	ContinueHandler func(ctx *RequestCtx) bool
+
+	// RequestBodyTee is called after reading request headers
+	// and may return io.Writer, which receives a copy of the request body
+	// while it is read.
+	//
+	// This allows hashing or auditing request bodies without copying them
+	// to additional buffers. Only request headers are available in ctx
+	// at the moment. The request fails with StatusBadRequest if the returned
+	// writer returns an error. See Request.SetBodyTee for details.
+	//
+	// By default request bodies aren't copied.
+	RequestBodyTee func(ctx *RequestCtx) io.Writer
+
+	// RouteSettings is called after reading request headers and may
+	// return settings overriding server-wide limits for the request.
+	//
+	// This allows serving both bulk-ingest paths with big bodies and
+	// latency-critical API paths with tight timeouts by a single Server.
+	// Only request headers are available in ctx at the moment, so
+	// the settings may be chosen by ctx.Path() prefix or ctx.Host().
+	// The callback may return nil for using the server-wide settings.
+	// The returned settings mustn't be modified after returning.
+	//
+	// By default server-wide settings are used for all the requests.
+	RouteSettings func(ctx *RequestCtx) *RouteSettings
+
+	// The value for 'Allow' response header sent to server-wide
+	// 'OPTIONS *' requests.
+	//
+	// The server automatically responds to 'OPTIONS *' requests
+	// without calling Handler if the value is set.
+	//
+	// By default 'OPTIONS *' requests are passed to Handler.
+	// See RequestCtx.IsAsteriskForm.
+	AsteriskOptionsAllow string
+
+	// Reuses compressors for gzip, deflate and zstd response compression
+	// during the connection lifetime if set to true.
+	//
+	// This reduces memory allocations and CPU overhead for keep-alive
+	// connections sending many small compressed responses at the cost
+	// of higher memory usage per connection. Streamed response bodies
+	// are compressed with compressors from global pools anyway.
+	//
+	// By default compressors are obtained from global pools
+	// for each compressed response.
+	ReuseCompressors bool
+
+	// Stores parsed request headers, query args and post args
+	// in a per-request arena if set to true. The arena is reset wholesale
+	// after each request.
+	//
+	// This is an experimental option. It reduces the number of small
+	// buffers held by each RequestCtx and improves cache locality
+	// when parsing requests with many headers and args.
+	// Byte slices obtained from request headers and args mustn't be
+	// retained after returning from RequestHandler, as usual.
+	//
+	// By default each header and arg has its own buffer.
+	UseRequestArena bool
+
+	// Sends error responses generated by the server itself as JSON objects
+	// if set to true:
+	//
+	//     {"code":413,"message":"Request body is too large","request_id":"4294967297"}
+	//
+	// This covers malformed requests, too big request headers and bodies,
+	// timeouts from TimeoutHandler, rejected 'Expect: 100-continue' requests
+	// and exceeded connection and request limits. request_id is the decimal
+	// RequestCtx.ID. It is missing for errors sent before reading
+	// the request. Errors set by RequestHandler via RequestCtx.Error
+	// aren't affected.
+	//
+	// By default errors are sent as plain text.
+	JSONErrors bool
+
+	// Access log writer.
+	//
+	// A line per served request is written to AccessLog in AccessLogFormat
+	// with a single Write call. The line is encoded without memory
+	// allocations, so enabling access log doesn't increase the number
+	// of allocations per request. AccessLog must be safe for concurrent use,
+	// since it is called from concurrently running connections.
+	//
+	// Requests rejected before reading the full request header,
+	// such as malformed requests, aren't logged.
+	//
+	// By default access log is disabled.
+	AccessLog io.Writer
+
+	// AccessLogFormat is the format of AccessLog lines.
+	//
+	// By default AccessLogCommon is used.
+	AccessLogFormat AccessLogFormat
+
+	// Handler for recorded raw requests and responses.
+	//
+	// Raw bytes of sampled requests and their responses are passed
+	// to RecordHandler after the response is sent. This may be used
+	// for debugging protocol issues with specific clients in production.
+	// Requests with malformed headers are recorded as unparsed bytes
+	// read from the connection. Recorded responses are flushed
+	// to the connection immediately, so recording slows down pipelined
+	// requests.
+	//
+	// By default requests aren't recorded.
+	RecordHandler RecordHandler
+
+	// One of each RecordSampling requests is passed to RecordHandler.
+	//
+	// By default all the requests are passed to RecordHandler.
+	RecordSampling int
+
+	// Maximum number of recorded bytes for each request and response
+	// passed to RecordHandler.
+	//
+	// By default 64KB are recorded.
+	MaxRecordSize int
+
+	// Interns frequent request header values such as Content-Type
+	// and User-Agent if set to true.
+	//
+	// This reduces copying of repeated header values on busy servers
+	// and proxies. See RequestHeader.SetInternValues for details.
+	//
+	// Request header values are copied by default.
+	InternHeaderValues bool
+
+	// Rejects requests with malformed headers with StatusBadRequest
+	// if set to true.
+	//
+	// This is useful for front-line gateways, since malformed headers
+	// may be interpreted differently by backends. See
+	// RequestHeader.SetStrictParsing for details.
+	//
+	// Malformed headers are tolerated by default.
+	StrictHeaderParsing bool
+
+	// Policy for header keys and values with CR or LF passed to header
+	// setters in the handler, which could be used for response splitting.
+	//
+	// The policy is applied to ctx.Response.Header and ctx.Request.Header.
+	// See HeaderInjectionPolicy for details.
+	//
+	// By default CR and LF are stripped (HeaderInjectionStrip).
+	HeaderInjectionPolicy HeaderInjectionPolicy
+
+	// Callback called for each response header key and value with CR
+	// or LF, which has been sanitized or rejected by header setters
+	// in the handler.
+	//
+	// It is called after the handler returns, so ctx contains the request,
+	// which made the handler reflect unsafe bytes into the response header.
+	// This allows monitoring response splitting attempts without parsing
+	// logs. See HeaderInjectionPolicy for details.
+	//
+	// By default sanitized and rejected headers aren't reported.
+	HeaderInjectionHandler func(ctx *RequestCtx, hi *HeaderInjection)
+
 	// Logs all errors, including the most frequent
 	// 'connection reset by peer', 'broken pipe' and 'connection timeout'
 	// errors. Such errors are common in production serving real-world
@@ -252,11 +595,69 @@ type Server struct {
 	// By default standard logger from log package is used.
 	Logger Logger
 
+	// Labels for response counters, e.g. route names.
+	//
+	// Request handlers may attribute responses to these labels
+	// via RequestCtx.SetStatsLabel. Response counters per status class
+	// for each label are returned by Server.StatusStats.
+	//
+	// By default responses are counted only per status class.
+	StatsLabels []string
+
+	// Counts protocol errors returned by Server.ProtocolErrorStats
+	// per client IP if set to true.
+	//
+	// Up to 10000 client IPs are tracked in order to limit memory usage.
+	//
+	// By default protocol errors are counted only in total.
+	ProtocolErrorStatsPerIP bool
+
+	// SizeStatsHandler receives request and response sizes and duration
+	// for each request served by the server.
+	//
+	// Responses are flushed to the connection before being written
+	// in order to count their sizes, so the handler slows down pipelined
+	// requests.
+	//
+	// By default request sizes aren't measured.
+	SizeStatsHandler SizeStatsHandler
+
+	// OCSPStapler staples OCSP responses to TLS handshakes for
+	// certificates passed to ServeTLS, ServeTLSEmbed, ListenAndServeTLS
+	// and ListenAndServeTLSEmbed.
+	//
+	// OCSP responses are fetched from OCSP responders and refreshed
+	// in background. Call OCSPStapler.Stop after the server is stopped.
+	//
+	// By default OCSP responses aren't stapled.
+	OCSPStapler *OCSPStapler
+
+	// ECHKeys enables Encrypted Client Hello for ServeTLS, ServeTLSEmbed,
+	// ListenAndServeTLS and ListenAndServeTLSEmbed.
+	//
+	// The keys may be rotated via ECHKeys.Set while the server is running.
+	// Use RequestCtx.TLSConnectionState together with ECHAccepted
+	// for checking whether the request has been received via ECH.
+	// The server fails to start with ErrECHUnsupported if crypto/tls
+	// doesn't support ECH.
+	//
+	// By default ECH is disabled.
+	ECHKeys *ECHKeys
+
 	concurrency      uint32
+	recordCounter    uint32
 	concurrencyCh    chan struct{}
 	perIPConnCounter perIPConnCounter
+	perIPReqCounter  perIPConnCounter
+	perIPBanList     perIPBanList
 	serverName       atomic.Value
 
+	statusStatsOnce sync.Once
+	statusStats     *serverStatusStats
+
+	protocolErrorStatsOnce sync.Once
+	protocolErrorStats     *serverProtocolErrorStats
+
 	ctxPool        sync.Pool
 	readerPool     sync.Pool
 	writerPool     sync.Pool
@@ -264,6 +665,36 @@ type Server struct {
 	bytePool       sync.Pool
 }
 
+// RouteSettings contains per-request overrides returned
+// by Server.RouteSettings.
+type RouteSettings struct {
+	// Maximum request body size.
+	//
+	// Server.MaxRequestBodySize is used by default.
+	MaxRequestBodySize int
+
+	// Maximum duration for reading the request body.
+	//
+	// The deadline set by Server.ReadTimeout is used by default.
+	ReadTimeout time.Duration
+
+	// Maximum duration for writing the response.
+	//
+	// The deadline set by Server.WriteTimeout is used by default.
+	WriteTimeout time.Duration
+
+	// Whether to compress the response with CompressLevel if the client
+	// accepts compressed responses. See CompressHandlerLevel for details.
+	//
+	// By default responses aren't compressed.
+	Compress bool
+
+	// Compression level used if Compress is set.
+	//
+	// See CompressHandlerLevel for supported levels.
+	CompressLevel int
+}
+
 // TimeoutHandler creates RequestHandler, which returns StatusRequestTimeout
 // error with the given msg to the client if h didn't return during
 // the given duration.
@@ -281,7 +712,7 @@ func TimeoutHandler(h RequestHandler, timeout time.Duration, msg string) Request
 		select {
 		case concurrencyCh <- struct{}{}:
 		default:
-			ctx.Error(msg, StatusTooManyRequests)
+			ctx.internalError(msg, StatusTooManyRequests)
 			return
 		}
 
@@ -299,7 +730,9 @@ func TimeoutHandler(h RequestHandler, timeout time.Duration, msg string) Request
 		select {
 		case <-ch:
 		case <-ctx.timeoutTimer.C:
-			ctx.TimeoutError(msg)
+			var resp Response
+			setInternalError(&resp, ctx, msg, StatusRequestTimeout)
+			ctx.TimeoutErrorWithResponse(&resp)
 		}
 		stopTimer(ctx.timeoutTimer)
 	}
@@ -326,20 +759,75 @@ func CompressHandler(h RequestHandler) RequestHandler {
 func CompressHandlerLevel(h RequestHandler, level int) RequestHandler {
 	return func(ctx *RequestCtx) {
 		h(ctx)
-		ce := ctx.Response.Header.PeekBytes(strContentEncoding)
-		if len(ce) > 0 {
-			// Do not compress responses with non-empty
-			// Content-Encoding.
-			return
-		}
-		if ctx.Request.Header.HasAcceptEncodingBytes(strGzip) {
-			ctx.Response.gzipBody(level)
-		} else if ctx.Request.Header.HasAcceptEncodingBytes(strDeflate) {
-			ctx.Response.deflateBody(level)
+		ctx.CompressResponse(level)
+	}
+}
+
+// CompressHandlerZstd returns RequestHandler that transparently compresses
+// response body generated by h with zstd using the given dictionary
+// if the request contains 'zstd' in 'Accept-Encoding' header.
+//
+// The dictionary isn't used if d is nil. Clients must decompress
+// the response with the same dictionary, so use non-nil d only
+// for cooperating services.
+//
+// The response is compressed with gzip or deflate if the client doesn't
+// accept zstd.
+func CompressHandlerZstd(h RequestHandler, d *ZstdDict) RequestHandler {
+	return func(ctx *RequestCtx) {
+		h(ctx)
+		if ctx.Request.Header.HasAcceptEncodingBytes(strZstd) {
+			ctx.CompressResponseZstd(d)
+		} else {
+			ctx.CompressResponse(CompressDefaultCompression)
 		}
 	}
 }
 
+// CompressResponseZstd compresses the response body with zstd
+// using the given dictionary.
+//
+// The dictionary isn't used if d is nil.
+// The caller is responsible for verifying whether the client accepts
+// zstd-compressed responses.
+//
+// Responses with non-empty 'Content-Encoding' header aren't compressed.
+func (ctx *RequestCtx) CompressResponseZstd(d *ZstdDict) {
+	ctx.Response.zstdBody(d, ctx.connCompressors())
+}
+
+func (ctx *RequestCtx) connCompressors() *connCompressors {
+	if ctx.s == nil || !ctx.s.ReuseCompressors {
+		return nil
+	}
+	return &ctx.compressors
+}
+
+// CompressResponse compresses the response body with the given level
+// if the client supports gzip or deflate 'Content-Encoding'.
+//
+// Body streams set via SetBodyStream and SetBodyStreamWriter are compressed
+// on the fly. The data written by StreamWriter is compressed in chunks
+// of limited size, so call Flush on the writer when the data must be
+// propagated to the client.
+//
+// Responses with non-empty 'Content-Encoding' header aren't compressed.
+//
+// See CompressHandlerLevel for supported level values.
+func (ctx *RequestCtx) CompressResponse(level int) {
+	ce := ctx.Response.Header.PeekBytes(strContentEncoding)
+	if len(ce) > 0 {
+		// Do not compress responses with non-empty
+		// Content-Encoding.
+		return
+	}
+	if ctx.Request.Header.HasAcceptEncodingBytes(strGzip) {
+		ctx.Response.gzipBody(level, ctx.connCompressors())
+	} else if ctx.Request.Header.HasAcceptEncodingBytes(strDeflate) {
+		ctx.Response.deflateBody(level, ctx.connCompressors())
+	}
+}
+
 // RequestCtx contains incoming request and manages outgoing response.
 //
 // It is forbidden copying RequestCtx instances.
@@ -387,6 +875,12 @@ type RequestCtx struct {
 	timeoutTimer    *time.Timer
 
 	hijackHandler HijackHandler
+
+	compressors connCompressors
+
+	// statsLabel is the index of Server.StatsLabels item plus one
+	// set via SetStatsLabel.
+	statsLabel int
 }
 
 // HijackHandler must process the hijacked connection c.
@@ -495,7 +989,7 @@ func (ctx *RequestCtx) IsTLS() bool {
 	//
 	//     // other custom fields here
 	// }
-	_, ok := ctx.c.(connTLSer)
+	_, ok := unwrapWriteLimits(ctx.c).(connTLSer)
 	return ok
 }
 
@@ -506,7 +1000,7 @@ func (ctx *RequestCtx) IsTLS() bool {
 // The returned state may be used for verifying TLS version, client certificates,
 // etc.
 func (ctx *RequestCtx) TLSConnectionState() *tls.ConnectionState {
-	tlsConn, ok := ctx.c.(connTLSer)
+	tlsConn, ok := unwrapWriteLimits(ctx.c).(connTLSer)
 	if !ok {
 		return nil
 	}
@@ -761,6 +1255,10 @@ func SaveMultipartFile(fh *multipart.FileHeader, path string) error {
 //
 //   * Query string.
 //   * POST or PUT body.
+//   * Multipart form values.
+//
+// Empty values are skipped. Use FormArgs for obtaining all the values
+// including empty ones.
 //
 // There are more fine-grained methods for obtaining form values:
 //
@@ -789,6 +1287,23 @@ func (ctx *RequestCtx) FormValue(key string) []byte {
 	return nil
 }
 
+// FormValueString returns form value associated with the given key.
+//
+// See FormValue for details.
+func (ctx *RequestCtx) FormValueString(key string) string {
+	return string(ctx.FormValue(key))
+}
+
+// FormArgs returns form arguments combined from the query string,
+// POST or PUT body and multipart form values in the order of precedence.
+//
+// Returned arguments are valid until returning from RequestHandler.
+//
+// See also QueryArgs, PostArgs and Request.FormArgs.
+func (ctx *RequestCtx) FormArgs() *Args {
+	return ctx.Request.FormArgs()
+}
+
 // IsGet returns true if request method is GET.
 func (ctx *RequestCtx) IsGet() bool {
 	return ctx.Request.Header.IsGet()
@@ -809,6 +1324,19 @@ func (ctx *RequestCtx) IsDelete() bool {
 	return ctx.Request.Header.IsDelete()
 }
 
+// IsOptions returns true if request method is OPTIONS.
+func (ctx *RequestCtx) IsOptions() bool {
+	return ctx.Request.Header.IsOptions()
+}
+
+// IsAsteriskForm returns true if the request is server-wide
+// 'OPTIONS *' request.
+//
+// ctx.Path() returns "/" for such requests.
+func (ctx *RequestCtx) IsAsteriskForm() bool {
+	return ctx.Request.Header.IsAsteriskForm()
+}
+
 // Method return request method.
 //
 // Returned value is valid until returning from RequestHandler.
@@ -880,6 +1408,42 @@ func (ctx *RequestCtx) Error(msg string, statusCode int) {
 	ctx.SetBodyString(msg)
 }
 
+// internalError is like Error, but respects Server.JSONErrors.
+//
+// It must be used for errors generated by the server itself.
+func (ctx *RequestCtx) internalError(msg string, statusCode int) {
+	ctx.Response.Reset()
+	setInternalError(&ctx.Response, ctx, msg, statusCode)
+}
+
+func setInternalError(resp *Response, ctx *RequestCtx, msg string, statusCode int) {
+	resp.SetStatusCode(statusCode)
+	if ctx.s == nil || !ctx.s.JSONErrors {
+		resp.Header.SetContentTypeBytes(defaultContentType)
+		resp.SetBodyString(msg)
+		return
+	}
+	resp.Header.SetContentTypeBytes(strJSONErrorType)
+	bb := resp.bodyBuffer()
+	bb.B = appendJSONError(bb.B[:0], statusCode, msg, ctx)
+}
+
+// appendJSONError appends JSON error object to dst.
+//
+// request_id is omitted if ctx is nil.
+func appendJSONError(dst []byte, statusCode int, msg string, ctx *RequestCtx) []byte {
+	dst = append(dst, `{"code":`...)
+	dst = AppendUint(dst, statusCode)
+	dst = append(dst, `,"message":`...)
+	dst = appendJSONString(dst, s2b(msg))
+	if ctx != nil {
+		dst = append(dst, `,"request_id":"`...)
+		dst = strconv.AppendUint(dst, ctx.ID(), 10)
+		dst = append(dst, '"')
+	}
+	return append(dst, '}')
+}
+
 // Success sets response Content-Type and body to the given values.
 func (ctx *RequestCtx) Success(contentType string, body []byte) {
 	ctx.SetContentType(contentType)
@@ -1002,6 +1566,31 @@ func (ctx *RequestCtx) IfModifiedSince(lastModified time.Time) bool {
 	return ifMod.Before(lastModified)
 }
 
+// IfRange returns true if the requested byte range must be served
+// for the resource with the given strong etag and lastModified time.
+//
+// false is returned if 'If-Range' request header doesn't match the resource,
+// i.e. the resource has been changed since the client obtained the previous
+// part of it. The whole resource must be sent in this case.
+// Weak etags never match.
+func (ctx *RequestCtx) IfRange(etag []byte, lastModified time.Time) bool {
+	v := ctx.Request.Header.peek(strIfRange)
+	if len(v) == 0 {
+		return true
+	}
+	if v[0] == '"' {
+		return len(etag) > 0 && bytes.Equal(v, etag)
+	}
+	if bytes.HasPrefix(v, strWeakETagPrefix) {
+		return false
+	}
+	t, err := ParseHTTPDate(v)
+	if err != nil {
+		return false
+	}
+	return t.Equal(lastModified.Truncate(time.Second))
+}
+
 // NotModified resets response and sets '304 Not Modified' response status code.
 func (ctx *RequestCtx) NotModified() {
 	ctx.Response.Reset()
@@ -1200,7 +1789,7 @@ func (s *Server) ListenAndServeTLSEmbed(addr string, certData, keyData []byte) e
 //
 // certFile and keyFile are paths to TLS certificate and key files.
 func (s *Server) ServeTLS(ln net.Listener, certFile, keyFile string) error {
-	lnTLS, err := newTLSListener(ln, certFile, keyFile)
+	lnTLS, err := s.newTLSListener(ln, certFile, keyFile)
 	if err != nil {
 		return err
 	}
@@ -1211,36 +1800,48 @@ func (s *Server) ServeTLS(ln net.Listener, certFile, keyFile string) error {
 //
 // certData and keyData must contain valid TLS certificate and key data.
 func (s *Server) ServeTLSEmbed(ln net.Listener, certData, keyData []byte) error {
-	lnTLS, err := newTLSListenerEmbed(ln, certData, keyData)
+	lnTLS, err := s.newTLSListenerEmbed(ln, certData, keyData)
 	if err != nil {
 		return err
 	}
 	return s.Serve(lnTLS)
 }
 
-func newTLSListener(ln net.Listener, certFile, keyFile string) (net.Listener, error) {
+func (s *Server) newTLSListener(ln net.Listener, certFile, keyFile string) (net.Listener, error) {
 	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
 		return nil, fmt.Errorf("cannot load TLS key pair from certFile=%q and keyFile=%q: %s", certFile, keyFile, err)
 	}
-	return newCertListener(ln, &cert), nil
+	return s.newCertListener(ln, &cert)
 }
 
-func newTLSListenerEmbed(ln net.Listener, certData, keyData []byte) (net.Listener, error) {
+func (s *Server) newTLSListenerEmbed(ln net.Listener, certData, keyData []byte) (net.Listener, error) {
 	cert, err := tls.X509KeyPair(certData, keyData)
 	if err != nil {
 		return nil, fmt.Errorf("cannot load TLS key pair from the provided certData(%d) and keyData(%d): %s",
 			len(certData), len(keyData), err)
 	}
-	return newCertListener(ln, &cert), nil
+	return s.newCertListener(ln, &cert)
 }
 
-func newCertListener(ln net.Listener, cert *tls.Certificate) net.Listener {
+func (s *Server) newCertListener(ln net.Listener, cert *tls.Certificate) (net.Listener, error) {
 	tlsConfig := &tls.Config{
-		Certificates:             []tls.Certificate{*cert},
 		PreferServerCipherSuites: true,
 	}
-	return tls.NewListener(ln, tlsConfig)
+	if s.OCSPStapler != nil {
+		if err := s.OCSPStapler.AddCertificate(*cert); err != nil {
+			return nil, fmt.Errorf("cannot enable OCSP stapling: %w", err)
+		}
+		tlsConfig.GetCertificate = s.OCSPStapler.GetCertificate
+	} else {
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+	if s.ECHKeys != nil {
+		if err := s.ECHKeys.ConfigureServer(tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	return tls.NewListener(ln, tlsConfig), nil
 }
 
 // DefaultConcurrency is the maximum number of concurrent connections
@@ -1251,36 +1852,73 @@ const DefaultConcurrency = 256 * 1024
 //
 // Serve blocks until the given listener returns permanent error.
 func (s *Server) Serve(ln net.Listener) error {
+	maxWorkersCount := s.getConcurrency()
+	s.concurrencyCh = make(chan struct{}, maxWorkersCount)
+
+	shardsCount := s.getWorkerPoolShards(maxWorkersCount)
+	wps := make([]*workerPool, shardsCount)
+	for i := range wps {
+		wps[i] = &workerPool{
+			WorkerFunc:            s.serveConn,
+			MaxWorkersCount:       getShardWorkersCount(maxWorkersCount, shardsCount, i),
+			LogAllErrors:          s.LogAllErrors,
+			MaxIdleWorkerDuration: s.MaxIdleWorkerDuration,
+			Logger:                s.logger(),
+		}
+		wps[i].Start()
+	}
+
+	var err error
+	if shardsCount == 1 {
+		err = s.acceptLoop(ln, wps, 0)
+	} else {
+		errCh := make(chan error, shardsCount)
+		for i := range wps {
+			go func(idx int) {
+				errCh <- s.acceptLoop(ln, wps, idx)
+			}(i)
+		}
+		for range wps {
+			errLocal := <-errCh
+			if errLocal != nil && err == nil {
+				err = errLocal
+
+				// Unblock the remaining acceptors.
+				ln.Close()
+			}
+		}
+	}
+
+	for _, wp := range wps {
+		wp.Stop()
+	}
+	return err
+}
+
+// acceptLoop accepts connections from ln and passes them to wps[idx].
+//
+// Other worker pools from wps are used if wps[idx] has no free workers.
+func (s *Server) acceptLoop(ln net.Listener, wps []*workerPool, idx int) error {
 	var lastOverflowErrorTime time.Time
 	var lastPerIPErrorTime time.Time
+	var lastSocketBudgetErrorTime time.Time
 	var c net.Conn
 	var err error
 
-	maxWorkersCount := s.getConcurrency()
-	s.concurrencyCh = make(chan struct{}, maxWorkersCount)
-	wp := &workerPool{
-		WorkerFunc:      s.serveConn,
-		MaxWorkersCount: maxWorkersCount,
-		LogAllErrors:    s.LogAllErrors,
-		Logger:          s.logger(),
-	}
-	wp.Start()
-
 	for {
-		if c, err = acceptConn(s, ln, &lastPerIPErrorTime); err != nil {
-			wp.Stop()
+		if c, err = acceptConn(s, ln, &lastPerIPErrorTime, &lastSocketBudgetErrorTime); err != nil {
 			if err == io.EOF {
 				return nil
 			}
 			return err
 		}
-		if !wp.Serve(c) {
+		if !serveWorkerPools(wps, idx, c) {
 			s.writeFastError(c, StatusServiceUnavailable,
 				"The connection cannot be served because Server.Concurrency limit exceeded")
 			c.Close()
 			if time.Since(lastOverflowErrorTime) > time.Minute {
 				s.logger().Printf("The incoming connection cannot be served, because %d concurrent connections are served. "+
-					"Try increasing Server.Concurrency", maxWorkersCount)
+					"Try increasing Server.Concurrency", s.getConcurrency())
 				lastOverflowErrorTime = time.Now()
 			}
 
@@ -1296,7 +1934,36 @@ func (s *Server) Serve(ln net.Listener) error {
 	}
 }
 
-func acceptConn(s *Server, ln net.Listener, lastPerIPErrorTime *time.Time) (net.Conn, error) {
+func serveWorkerPools(wps []*workerPool, idx int, c net.Conn) bool {
+	for i := 0; i < len(wps); i++ {
+		wp := wps[(idx+i)%len(wps)]
+		if wp.Serve(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) getWorkerPoolShards(maxWorkersCount int) int {
+	n := s.WorkerPoolShards
+	if n <= 0 {
+		n = 1
+	}
+	if n > maxWorkersCount {
+		n = maxWorkersCount
+	}
+	return n
+}
+
+func getShardWorkersCount(maxWorkersCount, shardsCount, idx int) int {
+	n := maxWorkersCount / shardsCount
+	if idx < maxWorkersCount%shardsCount {
+		n++
+	}
+	return n
+}
+
+func acceptConn(s *Server, ln net.Listener, lastPerIPErrorTime, lastSocketBudgetErrorTime *time.Time) (net.Conn, error) {
 	for {
 		c, err := ln.Accept()
 		if err != nil {
@@ -1317,6 +1984,32 @@ func acceptConn(s *Server, ln net.Listener, lastPerIPErrorTime *time.Time) (net.
 		if c == nil {
 			panic("BUG: net.Listener returned (nil, nil)")
 		}
+		if s.AcceptFilter != nil && !s.AcceptFilter(c, c.RemoteAddr()) {
+			c.Close()
+			continue
+		}
+		if s.isConnBanned(c) {
+			continue
+		}
+		if err = controlConn(c, s.ConnControl); err != nil {
+			s.logger().Printf("Cannot set socket options for connection from %s: %s", c.RemoteAddr(), err)
+			c.Close()
+			continue
+		}
+		tracked, ok := reserveSocket()
+		if !ok {
+			s.writeFastError(c, StatusServiceUnavailable, "The connection cannot be served because of too many open sockets")
+			c.Close()
+			if time.Since(*lastSocketBudgetErrorTime) > time.Minute {
+				s.logger().Printf("The incoming connection from %s cannot be served, because %d sockets are open. "+
+					"See SetMaxOpenSockets", c.RemoteAddr(), OpenSockets())
+				*lastSocketBudgetErrorTime = time.Now()
+			}
+			continue
+		}
+		if tracked {
+			c = wrapBudgetConn(c)
+		}
 		if s.MaxConnsPerIP > 0 {
 			pic := wrapPerIPConn(s, c)
 			if pic == nil {
@@ -1341,6 +2034,7 @@ func wrapPerIPConn(s *Server, c net.Conn) net.Conn {
 	n := s.perIPConnCounter.Register(ip)
 	if n > s.MaxConnsPerIP {
 		s.perIPConnCounter.Unregister(ip)
+		s.registerPerIPViolation(ip)
 		s.writeFastError(c, StatusTooManyRequests, "The number of connections from your ip exceeds MaxConnsPerIP")
 		c.Close()
 		return nil
@@ -1348,6 +2042,113 @@ func wrapPerIPConn(s *Server, c net.Conn) net.Conn {
 	return acquirePerIPConn(c, ip, &s.perIPConnCounter)
 }
 
+func (s *Server) inFlightResponseBytesExceeded() bool {
+	return s.MaxInFlightResponseBytes > 0 && atomic.LoadInt64(&s.inFlightResponseBytes) > int64(s.MaxInFlightResponseBytes)
+}
+
+// InFlightResponseBytesExceeded returns true if the total size of buffered
+// response bodies being sent to clients exceeds
+// Server.MaxInFlightResponseBytes.
+//
+// Handlers generating large responses may use SetBodyStreamWriter
+// in this case, so the response body isn't buffered in memory.
+func (ctx *RequestCtx) InFlightResponseBytesExceeded() bool {
+	return ctx.s != nil && ctx.s.inFlightResponseBytesExceeded()
+}
+
+func (s *Server) reportHeaderInjections(ctx *RequestCtx) {
+	injections := ctx.Response.Header.injections
+	for i := range injections {
+		s.HeaderInjectionHandler(ctx, &injections[i])
+	}
+}
+
+func (s *Server) handlePerIPRequest(ctx *RequestCtx) {
+	ip := getUint32IP(ctx.c)
+	if ip == 0 {
+		s.Handler(ctx)
+		return
+	}
+	n := s.perIPReqCounter.Register(ip)
+	if n > s.MaxRequestsPerIP {
+		s.perIPReqCounter.Unregister(ip)
+		s.registerPerIPViolation(ip)
+		ctx.internalError("The number of concurrent requests from your ip exceeds MaxRequestsPerIP", StatusTooManyRequests)
+		return
+	}
+	s.Handler(ctx)
+	s.perIPReqCounter.Unregister(ip)
+}
+
+// DefaultPerIPBanDuration is the default duration of automatic IP ban.
+//
+// See Server.PerIPBanDuration for details.
+const DefaultPerIPBanDuration = 10 * time.Minute
+
+func (s *Server) getPerIPBanDuration() time.Duration {
+	d := s.PerIPBanDuration
+	if d <= 0 {
+		d = DefaultPerIPBanDuration
+	}
+	return d
+}
+
+func (s *Server) registerPerIPViolation(ip uint32) {
+	banDuration := s.getPerIPBanDuration()
+	if s.perIPBanList.RegisterViolation(ip, s.PerIPBanThreshold, banDuration) {
+		s.logger().Printf("Banning %s for %s, since it exceeded per-ip limits %d times", uint322ip(ip), banDuration, s.PerIPBanThreshold)
+	}
+}
+
+// isConnBanned closes c and returns true if c is established from banned IP.
+func (s *Server) isConnBanned(c net.Conn) bool {
+	ip := getUint32IP(c)
+	if ip == 0 || !s.perIPBanList.IsBanned(ip) {
+		return false
+	}
+	s.writeFastError(c, StatusForbidden, "Your ip is temporarily banned")
+	c.Close()
+	return true
+}
+
+// BanIP bans the given IPv4 address for the given duration.
+//
+// The server closes all the new connections from banned IPs.
+// Already established connections from the ip aren't affected
+// by the ban and are served until they are closed.
+func (s *Server) BanIP(ip net.IP, duration time.Duration) {
+	n := ip2uint32(ip.To4())
+	if n == 0 {
+		return
+	}
+	s.perIPBanList.Ban(n, duration)
+}
+
+// UnbanIP removes the ban for the given IPv4 address.
+//
+// It also resets the limit violations counter for the ip.
+func (s *Server) UnbanIP(ip net.IP) {
+	n := ip2uint32(ip.To4())
+	if n == 0 {
+		return
+	}
+	s.perIPBanList.Unban(n)
+}
+
+// IsIPBanned returns true if the given IPv4 address is banned at the moment.
+func (s *Server) IsIPBanned(ip net.IP) bool {
+	n := ip2uint32(ip.To4())
+	if n == 0 {
+		return false
+	}
+	return s.perIPBanList.IsBanned(n)
+}
+
+// BannedIPs returns IPs banned at the moment.
+func (s *Server) BannedIPs() []BannedIP {
+	return s.perIPBanList.AppendBanned(nil)
+}
+
 var defaultLogger = Logger(log.New(os.Stderr, "", log.LstdFlags))
 
 func (s *Server) logger() Logger {
@@ -1366,6 +2167,12 @@ var (
 	// of concurrenty served connections exceeds Server.Concurrency.
 	ErrConcurrencyLimit = errors.New("canot serve the connection because Server.Concurrency concurrent connections are served")
 
+	// ErrPerIPBanned may be returned from ServeConn if the connection
+	// is established from banned ip.
+	//
+	// See Server.BanIP and Server.PerIPBanThreshold for details.
+	ErrPerIPBanned = errors.New("the ip is banned")
+
 	// ErrKeepaliveTimeout is returned from ServeConn
 	// if the connection lifetime exceeds MaxKeepaliveDuration.
 	ErrKeepaliveTimeout = errors.New("exceeded MaxKeepaliveDuration")
@@ -1381,6 +2188,9 @@ var (
 //
 // ServeConn closes c before returning.
 func (s *Server) ServeConn(c net.Conn) error {
+	if s.isConnBanned(c) {
+		return ErrPerIPBanned
+	}
 	if s.MaxConnsPerIP > 0 {
 		pic := wrapPerIPConn(s, c)
 		if pic == nil {
@@ -1435,6 +2245,13 @@ func nextConnID() uint64 {
 const DefaultMaxRequestBodySize = 4 * 1024 * 1024
 
 func (s *Server) serveConn(c net.Conn) error {
+	if s.TCPUserTimeout > 0 {
+		c = limitWriteStall(c, s.TCPUserTimeout)
+	}
+	if s.MinWriteRate > 0 {
+		c = limitWriteRate(c, s.MinWriteRate, s.MinWriteRateWindow)
+	}
+
 	serverName := s.getServerName()
 	connRequestNum := uint64(0)
 	connID := nextConnID()
@@ -1453,6 +2270,7 @@ func (s *Server) serveConn(c net.Conn) error {
 		bw *bufio.Writer
 
 		err             error
+		readErr         bool
 		timeoutResponse *Response
 		hijackHandler   HijackHandler
 
@@ -1461,11 +2279,38 @@ func (s *Server) serveConn(c net.Conn) error {
 
 		connectionClose bool
 		isHTTP11        bool
+
+		continueRejected bool
+
+		accessLogBuf []byte
+
+		recording bool
+		recorder  *connRecorder
+
+		sizeStats SizeStats
+		sc        *sizeCounter
+
+		rs                *RouteSettings
+		reqMaxBodySize    int
+		hasRouteDeadlines bool
 	)
 	for {
 		connRequestNum++
+		ctx.connID = connID
+		ctx.connRequestNum = connRequestNum
 		ctx.time = currentTime
 
+		if hasRouteDeadlines {
+			// Restore the deadlines overridden by RouteSettings.
+			c.SetReadDeadline(zeroTime)
+			c.SetWriteDeadline(zeroTime)
+			lastReadDeadlineTime = zeroTime
+			lastWriteDeadlineTime = zeroTime
+			hasRouteDeadlines = false
+		}
+		rs = nil
+		reqMaxBodySize = maxRequestBodySize
+
 		if s.ReadTimeout > 0 || s.MaxKeepaliveDuration > 0 {
 			lastReadDeadlineTime = s.updateReadDeadline(c, ctx, lastReadDeadlineTime)
 			if lastReadDeadlineTime.IsZero() {
@@ -1482,9 +2327,38 @@ func (s *Server) serveConn(c net.Conn) error {
 			br, err = acquireByteReader(&ctx)
 		}
 		ctx.Request.isTLS = isTLS
-
+		ctx.Request.bodyLengthPolicy = s.BodyLengthMismatchPolicy
+		ctx.Request.decompressLimits = s.DecompressLimits
+
+		recording = s.shouldRecord()
+		if recording {
+			// The recorder is allocated lazily, so serving connections
+			// without RecordHandler doesn't allocate it.
+			if recorder == nil {
+				recorder = &connRecorder{}
+			}
+			recorder.reset(c, s.getMaxRecordSize())
+		}
 		if err == nil {
-			err = ctx.Request.readLimitBody(br, maxRequestBodySize, s.GetOnly)
+			if recording {
+				ctx.Request.Header.rawRecord = &recorder.req
+			}
+			err = ctx.Request.readHeader(br, s.GetOnly)
+			if err == nil {
+				if s.RouteSettings != nil {
+					rs = s.RouteSettings(ctx)
+					hasRouteDeadlines = s.applyRouteSettings(c, rs, &reqMaxBodySize)
+				}
+				if s.RequestBodyTee != nil {
+					ctx.Request.bodyTee = s.RequestBodyTee(ctx)
+				}
+				err = ctx.Request.readBody(br, reqMaxBodySize)
+			}
+			ctx.Request.Header.rawRecord = nil
+			if recording && err != nil && len(recorder.req) == 0 && br.Buffered() > 0 {
+				// Record unparsed bytes of the malformed request.
+				recorder.req = append(recorder.req, mustPeekBuffered(br)...)
+			}
 			if br.Buffered() == 0 || err != nil {
 				releaseReader(s, br)
 				br = nil
@@ -1497,6 +2371,14 @@ func (s *Server) serveConn(c net.Conn) error {
 		if err != nil {
 			if err == io.EOF {
 				err = nil
+				break
+			}
+			s.countReadError(c, err)
+			readErr = true
+			if recording {
+				bw = startRecordResponse(bw, ctx, recorder)
+				bw = writeErrorResponse(bw, ctx, err)
+				s.finishRecord(bw, ctx, recorder)
 			} else {
 				bw = writeErrorResponse(bw, ctx, err)
 			}
@@ -1505,43 +2387,72 @@ func (s *Server) serveConn(c net.Conn) error {
 
 		// 'Expect: 100-continue' request handling.
 		// See http://www.w3.org/Protocols/rfc2616/rfc2616-sec8.html for details.
+		continueRejected = false
 		if !ctx.Request.Header.noBody() && ctx.Request.MayContinue() {
-			// Send 'HTTP/1.1 100 Continue' response.
-			if bw == nil {
-				bw = acquireWriter(ctx)
-			}
-			bw.Write(strResponseContinue)
-			err = bw.Flush()
-			releaseWriter(s, bw)
-			bw = nil
-			if err != nil {
-				break
-			}
+			if s.ContinueHandler != nil && !s.ContinueHandler(ctx) {
+				continueRejected = true
+				rejectContinue(ctx)
+			} else {
+				// Send 'HTTP/1.1 100 Continue' response.
+				if bw == nil {
+					bw = acquireWriter(ctx)
+				}
+				bw.Write(strResponseContinue)
+				err = bw.Flush()
+				releaseWriter(s, bw)
+				bw = nil
+				if err != nil {
+					break
+				}
 
-			// Read request body.
-			if br == nil {
-				br = acquireReader(ctx)
-			}
-			err = ctx.Request.ContinueReadBody(br, maxRequestBodySize)
-			if br.Buffered() == 0 || err != nil {
-				releaseReader(s, br)
-				br = nil
-			}
-			if err != nil {
-				bw = writeErrorResponse(bw, ctx, err)
-				break
+				// Read request body.
+				if br == nil {
+					br = acquireReader(ctx)
+				}
+				err = ctx.Request.ContinueReadBody(br, reqMaxBodySize)
+				if br.Buffered() == 0 || err != nil {
+					releaseReader(s, br)
+					br = nil
+				}
+				if err != nil {
+					s.countReadError(c, err)
+					readErr = true
+					bw = writeErrorResponse(bw, ctx, err)
+					break
+				}
 			}
 		}
 
-		connectionClose = s.DisableKeepalive || ctx.Request.Header.connectionCloseFast()
+		if recording {
+			recorder.req = appendLimited(recorder.req, ctx.Request.bodyBytes(), recorder.limit)
+		}
+
 		isHTTP11 = ctx.Request.Header.IsHTTP11()
+		connectionClose = s.DisableKeepalive || ctx.Request.Header.connectionCloseFast() ||
+			(!isHTTP11 && s.DisableHTTP10KeepAlive)
 
 		ctx.Response.Header.SetServerBytes(serverName)
-		ctx.connID = connID
-		ctx.connRequestNum = connRequestNum
 		ctx.connTime = connTime
 		ctx.time = currentTime
-		s.Handler(ctx)
+		if s.AccessLog != nil {
+			accessLogBuf = appendAccessLogRequest(accessLogBuf[:0], ctx, s.AccessLogFormat)
+		}
+		if s.SizeStatsHandler != nil {
+			sizeStats.RequestSize = requestReadSize(&ctx.Request)
+		}
+		if continueRejected {
+			// Do not call the handler, since the request has been rejected
+			// by ContinueHandler.
+		} else if !isHTTP11 && s.RejectHTTP10 {
+			ctx.internalError("HTTP/1.0 isn't supported", StatusHTTPVersionNotSupported)
+			ctx.SetConnectionClose()
+		} else if len(s.AsteriskOptionsAllow) > 0 && ctx.IsAsteriskForm() {
+			ctx.Response.Header.SetCanonical(strAllow, s2b(s.AsteriskOptionsAllow))
+		} else if s.MaxRequestsPerIP > 0 {
+			s.handlePerIPRequest(ctx)
+		} else {
+			s.Handler(ctx)
+		}
 
 		timeoutResponse = ctx.timeoutResponse
 		if timeoutResponse != nil {
@@ -1551,6 +2462,11 @@ func (s *Server) serveConn(c net.Conn) error {
 				// Close connection, since br may be attached to the old ctx via ctx.fbr.
 				ctx.SetConnectionClose()
 			}
+		} else if rs != nil && rs.Compress {
+			ctx.CompressResponse(rs.CompressLevel)
+		}
+		if s.HeaderInjectionHandler != nil && timeoutResponse == nil {
+			s.reportHeaderInjections(ctx)
 		}
 
 		if !ctx.IsGet() && ctx.IsHead() {
@@ -1570,6 +2486,25 @@ func (s *Server) serveConn(c net.Conn) error {
 		if s.WriteTimeout > 0 || s.MaxKeepaliveDuration > 0 {
 			lastWriteDeadlineTime = s.updateWriteDeadline(c, ctx, lastWriteDeadlineTime)
 		}
+		if rs != nil && rs.WriteTimeout > 0 {
+			if err := c.SetWriteDeadline(time.Now().Add(rs.WriteTimeout)); err != nil {
+				panic(fmt.Sprintf("BUG: error in SetWriteDeadline(%s): %s", rs.WriteTimeout, err))
+			}
+			hasRouteDeadlines = true
+		}
+
+		if s.MaxBufferedStreamBodySize > 0 && !ctx.Response.mustSkipBody() && !s.inFlightResponseBytesExceeded() {
+			if err = ctx.Response.bufferBodyStream(s.MaxBufferedStreamBodySize); err != nil {
+				break
+			}
+		}
+
+		if !isHTTP11 && ctx.Response.bodyStream != nil && ctx.Response.Header.ContentLength() < 0 {
+			// HTTP/1.0 clients don't support chunked encoding,
+			// so delimit the body by closing the connection.
+			ctx.Response.Header.SetContentLength(-2)
+			ctx.Response.Header.h = delAllArgsBytes(ctx.Response.Header.h, strTransferEncoding)
+		}
 
 		// Verify Request.Header.connectionCloseFast() again,
 		// since request handler might trigger full headers' parsing.
@@ -1587,14 +2522,61 @@ func (s *Server) serveConn(c net.Conn) error {
 			ctx.Response.Header.SetServerBytes(serverName)
 		}
 
-		if bw == nil {
+		if s.AccessLog != nil {
+			accessLogBuf = appendAccessLogResponse(accessLogBuf, &ctx.Response, time.Since(currentTime), s.AccessLogFormat)
+			s.AccessLog.Write(accessLogBuf)
+		}
+
+		if s.SizeStatsHandler != nil {
+			sizeStats.StatusCode = ctx.Response.StatusCode()
+			sizeStats.Label = s.statsLabel(ctx)
+		}
+		s.countResponse(ctx)
+
+		if recording {
+			bw = startRecordResponse(bw, ctx, recorder)
+		} else if bw == nil {
 			bw = acquireWriter(ctx)
 		}
-		if err = writeResponse(ctx, bw); err != nil {
+		if s.SizeStatsHandler != nil {
+			if sc == nil {
+				sc = &sizeCounter{}
+			}
+			if recording {
+				bw = startCountWrites(bw, recorder, sc)
+			} else {
+				bw = startCountWrites(bw, ctx.c, sc)
+			}
+		}
+		var inFlightBytes int64
+		if s.MaxInFlightResponseBytes > 0 {
+			inFlightBytes = int64(len(ctx.Response.bodyBytes()))
+			if atomic.AddInt64(&s.inFlightResponseBytes, inFlightBytes) > int64(s.MaxInFlightResponseBytes) {
+				// Do not keep the body buffer for the connection,
+				// so it may be garbage collected.
+				ctx.Response.keepBodyBuffer = false
+			}
+		}
+		err = writeResponse(ctx, bw)
+		if s.MaxInFlightResponseBytes > 0 {
+			atomic.AddInt64(&s.inFlightResponseBytes, -inFlightBytes)
+			ctx.Response.keepBodyBuffer = !s.ReduceMemoryUsage
+		}
+		if recording {
+			if errRecord := s.finishRecord(bw, ctx, recorder); err == nil {
+				err = errRecord
+			}
+		}
+		if err != nil {
 			break
 		}
+		if s.SizeStatsHandler != nil {
+			sizeStats.ResponseSize = countedSize(bw, sc)
+			sizeStats.Duration = time.Since(currentTime)
+			s.SizeStatsHandler(sizeStats)
+		}
 
-		if br == nil || connectionClose {
+		if br == nil || connectionClose || continueRejected {
 			err = bw.Flush()
 			releaseWriter(s, bw)
 			bw = nil
@@ -1626,6 +2608,7 @@ func (s *Server) serveConn(c net.Conn) error {
 			}
 			c.SetReadDeadline(zeroTime)
 			c.SetWriteDeadline(zeroTime)
+			c = unwrapWriteLimits(c)
 			go hijackConnHandler(hjr, c, s, hijackHandler)
 			hijackHandler = nil
 			err = errHijacked
@@ -1635,6 +2618,9 @@ func (s *Server) serveConn(c net.Conn) error {
 		currentTime = time.Now()
 	}
 
+	if err != nil && !readErr {
+		s.countWriteError(c, err)
+	}
 	if br != nil {
 		releaseReader(s, br)
 	}
@@ -1645,6 +2631,37 @@ func (s *Server) serveConn(c net.Conn) error {
 	return err
 }
 
+// rejectContinue prepares the response for the request rejected
+// by Server.ContinueHandler.
+//
+// The connection is always closed after the response, since the client
+// may send the request body anyway.
+func rejectContinue(ctx *RequestCtx) {
+	if ctx.Response.StatusCode() == StatusOK {
+		ctx.internalError(StatusMessage(StatusExpectationFailed), StatusExpectationFailed)
+	}
+	ctx.SetConnectionClose()
+}
+
+// applyRouteSettings applies rs to the request with already read header.
+//
+// It returns true if the connection deadlines have been overridden.
+func (s *Server) applyRouteSettings(c net.Conn, rs *RouteSettings, maxBodySize *int) bool {
+	if rs == nil {
+		return false
+	}
+	if rs.MaxRequestBodySize > 0 {
+		*maxBodySize = rs.MaxRequestBodySize
+	}
+	if rs.ReadTimeout <= 0 {
+		return false
+	}
+	if err := c.SetReadDeadline(time.Now().Add(rs.ReadTimeout)); err != nil {
+		panic(fmt.Sprintf("BUG: error in SetReadDeadline(%s): %s", rs.ReadTimeout, err))
+	}
+	return true
+}
+
 func (s *Server) updateReadDeadline(c net.Conn, ctx *RequestCtx, lastDeadlineTime time.Time) time.Time {
 	readTimeout := s.ReadTimeout
 	currentTime := ctx.time
@@ -1852,6 +2869,14 @@ func (s *Server) acquireCtx(c net.Conn) *RequestCtx {
 		keepBodyBuffer := !s.ReduceMemoryUsage
 		ctx.Request.keepBodyBuffer = keepBodyBuffer
 		ctx.Response.keepBodyBuffer = keepBodyBuffer
+		ctx.Request.Header.internValues = s.InternHeaderValues
+		ctx.Request.Header.strictParsing = s.StrictHeaderParsing
+		ctx.Request.Header.injectionPolicy = s.HeaderInjectionPolicy
+		ctx.Response.Header.injectionPolicy = s.HeaderInjectionPolicy
+		ctx.Response.Header.recordInjections = s.HeaderInjectionHandler != nil
+		if s.UseRequestArena {
+			ctx.Request.enableArena()
+		}
 	} else {
 		ctx = v.(*RequestCtx)
 	}
@@ -1936,6 +2961,7 @@ func (s *Server) releaseCtx(ctx *RequestCtx) {
 	}
 	ctx.c = nil
 	ctx.fbr.c = nil
+	ctx.compressors.release()
 	s.ctxPool.Put(ctx)
 }
 
@@ -1955,22 +2981,29 @@ func (s *Server) getServerName() []byte {
 }
 
 func (s *Server) writeFastError(w io.Writer, statusCode int, msg string) {
+	contentType := "text/plain"
+	if s.JSONErrors {
+		contentType = string(strJSONErrorType)
+		msg = string(appendJSONError(nil, statusCode, msg, nil))
+	}
 	w.Write(statusLine(statusCode))
 	fmt.Fprintf(w, "Connection: close\r\n"+
 		"Server: %s\r\n"+
 		"Date: %s\r\n"+
-		"Content-Type: text/plain\r\n"+
+		"Content-Type: %s\r\n"+
 		"Content-Length: %d\r\n"+
 		"\r\n"+
 		"%s",
-		s.getServerName(), serverDate.Load(), len(msg), msg)
+		s.getServerName(), serverDate.Load(), contentType, len(msg), msg)
 }
 
 func writeErrorResponse(bw *bufio.Writer, ctx *RequestCtx, err error) *bufio.Writer {
 	if _, ok := err.(*ErrSmallBuffer); ok {
-		ctx.Error("Too big request header", StatusRequestHeaderFieldsTooLarge)
+		ctx.internalError("Too big request header", StatusRequestHeaderFieldsTooLarge)
+	} else if err == ErrBodyTooLarge {
+		ctx.internalError("Request body is too large", StatusRequestEntityTooLarge)
 	} else {
-		ctx.Error("Error when parsing request", StatusBadRequest)
+		ctx.internalError("Error when parsing request", StatusBadRequest)
 	}
 	ctx.SetConnectionClose()
 	if bw == nil {
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,6 +12,8 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -499,6 +502,86 @@ func TestServerMaxConnsPerIPLimit(t *testing.T) {
 	}
 }
 
+func TestServerMaxRequestsPerIPBan(t *testing.T) {
+	startedCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/block" {
+				close(startedCh)
+				<-doneCh
+			}
+			ctx.WriteString("OK")
+		},
+		MaxRequestsPerIP:  1,
+		PerIPBanThreshold: 1,
+		Logger:            &customLogger{},
+	}
+
+	rw1 := &readWriter{}
+	rw1.r.WriteString("GET /block HTTP/1.1\r\nHost: aa\r\n\r\n")
+	ch := make(chan error, 1)
+	go func() {
+		ch <- s.ServeConn(&fakeIPConn{Conn: rw1})
+	}()
+
+	select {
+	case <-startedCh:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	rw2 := &readWriter{}
+	rw2.r.WriteString("GET /foo HTTP/1.1\r\nHost: aa\r\n\r\n")
+	if err := s.ServeConn(&fakeIPConn{Conn: rw2}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	br := bufio.NewReader(&rw2.w)
+	verifyResponse(t, br, StatusTooManyRequests, string(defaultContentType),
+		"The number of concurrent requests from your ip exceeds MaxRequestsPerIP")
+
+	ip := net.ParseIP("1.2.3.4")
+	if !s.IsIPBanned(ip) {
+		t.Fatalf("Expecting %s to be banned", ip)
+	}
+	bannedIPs := s.BannedIPs()
+	if len(bannedIPs) != 1 || !bannedIPs[0].IP.Equal(ip) {
+		t.Fatalf("Unexpected banned ips: %v. Expecting [%s]", bannedIPs, ip)
+	}
+
+	rw3 := &readWriter{}
+	rw3.r.WriteString("GET /foo HTTP/1.1\r\nHost: aa\r\n\r\n")
+	if err := s.ServeConn(&fakeIPConn{Conn: rw3}); err != ErrPerIPBanned {
+		t.Fatalf("Unexpected error: %v. Expecting %v", err, ErrPerIPBanned)
+	}
+	br = bufio.NewReader(&rw3.w)
+	verifyResponse(t, br, StatusForbidden, "text/plain", "Your ip is temporarily banned")
+
+	close(doneCh)
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+	br = bufio.NewReader(&rw1.w)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "OK")
+
+	s.UnbanIP(ip)
+	if s.IsIPBanned(ip) {
+		t.Fatalf("Unexpected ban for %s after UnbanIP", ip)
+	}
+	rw4 := &readWriter{}
+	rw4.r.WriteString("GET /foo HTTP/1.1\r\nHost: aa\r\n\r\n")
+	if err := s.ServeConn(&fakeIPConn{Conn: rw4}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	br = bufio.NewReader(&rw4.w)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "OK")
+}
+
 type fakeIPListener struct {
 	net.Listener
 }
@@ -598,6 +681,349 @@ func TestServerConcurrencyLimit(t *testing.T) {
 	}
 }
 
+func TestServerWorkerPoolShards(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("OK")
+		},
+		Concurrency:      4,
+		WorkerPoolShards: 3,
+		Logger:           &customLogger{},
+	}
+
+	ln := fasthttputil.NewInmemoryListener()
+
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+
+	clientCh := make(chan error, 1)
+	go func() {
+		var resp Response
+
+		// All the shards must serve connections up to Concurrency,
+		// even if the given acceptor's shard is full.
+		for i := 0; i < s.Concurrency; i++ {
+			c, err := ln.Dial()
+			if err != nil {
+				clientCh <- err
+				return
+			}
+			if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+				clientCh <- err
+				return
+			}
+			if err = resp.Read(bufio.NewReader(c)); err != nil {
+				clientCh <- err
+				return
+			}
+			if resp.StatusCode() != StatusOK {
+				clientCh <- fmt.Errorf("unexpected status code for connection #%d: %d. Expecting %d", i, resp.StatusCode(), StatusOK)
+				return
+			}
+		}
+
+		c, err := ln.Dial()
+		if err != nil {
+			clientCh <- err
+			return
+		}
+		if err = resp.Read(bufio.NewReader(c)); err != nil {
+			clientCh <- err
+			return
+		}
+		if resp.StatusCode() != StatusServiceUnavailable {
+			clientCh <- fmt.Errorf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusServiceUnavailable)
+			return
+		}
+		clientCh <- nil
+	}()
+
+	select {
+	case err := <-clientCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case err := <-serverCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestServerConnControl(t *testing.T) {
+	var calls uint32
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("OK")
+		},
+		ConnControl: func(rc syscall.RawConn) error {
+			if atomic.AddUint32(&calls, 1) > 1 {
+				return fmt.Errorf("foobar")
+			}
+			return rc.Control(func(fd uintptr) {})
+		},
+		Logger: &customLogger{},
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+
+	c, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(c)
+	verifyResponse(t, br, StatusOK, "text/plain; charset=utf-8", "OK")
+	c.Close()
+
+	// The connection must be closed if ConnControl returns an error.
+	c, err = net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+	c.Close()
+
+	ln.Close()
+	select {
+	case err := <-serverCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestServerAcceptFilter(t *testing.T) {
+	var calls uint32
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("OK")
+		},
+		AcceptFilter: func(c net.Conn, addr net.Addr) bool {
+			if addr.String() != c.RemoteAddr().String() {
+				return false
+			}
+			return atomic.AddUint32(&calls, 1) == 1
+		},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(c)
+	verifyResponse(t, br, StatusOK, "text/plain; charset=utf-8", "OK")
+	c.Close()
+
+	// The connection must be closed without response if AcceptFilter returns false.
+	c, err = ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+	c.Close()
+
+	ln.Close()
+	select {
+	case err := <-serverCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestServerTCPUserTimeoutWriteStall(t *testing.T) {
+	chunk := createFixedBody(64 * 1024)
+	chunksCount := 100
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+				for i := 0; i < chunksCount; i++ {
+					w.Write(chunk)
+					if err := w.Flush(); err != nil {
+						return
+					}
+				}
+			})
+		},
+		TCPUserTimeout: 50 * time.Millisecond,
+		Logger:         &customLogger{},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Do not read the response, so the server write stalls.
+	time.Sleep(200 * time.Millisecond)
+
+	// The server must close the connection with stalled write.
+	if err = c.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	n, err := io.Copy(ioutil.Discard, c)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n >= int64(len(chunk)*chunksCount) {
+		t.Fatalf("unexpected full response read after write stall")
+	}
+	c.Close()
+
+	ln.Close()
+	select {
+	case err := <-serverCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestRequestCtxIsTLSWriteStall(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	var ctx RequestCtx
+	ctx.c = limitWriteStall(tls.Server(c1, &tls.Config{}), time.Second)
+	if !ctx.IsTLS() {
+		t.Fatalf("expecting TLS connection")
+	}
+	if ctx.TLSConnectionState() == nil {
+		t.Fatalf("expecting non-nil TLS connection state")
+	}
+}
+
+func TestServerMinWriteRate(t *testing.T) {
+	chunk := createFixedBody(64 * 1024)
+	chunksCount := 16
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+				for i := 0; i < chunksCount; i++ {
+					w.Write(chunk)
+					if err := w.Flush(); err != nil {
+						return
+					}
+				}
+			})
+		},
+		MinWriteRate:       1024 * 1024,
+		MinWriteRateWindow: 50 * time.Millisecond,
+		Logger:             &customLogger{},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln)
+
+	readResponse := func(readDelay time.Duration) int64 {
+		c, err := ln.Dial()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer c.Close()
+		if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err = c.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var n int64
+		buf := make([]byte, 1024)
+		for n < int64(len(chunk)*chunksCount) {
+			m, err := c.Read(buf)
+			n += int64(m)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			time.Sleep(readDelay)
+		}
+		return n
+	}
+
+	// Fast clients receive the whole response.
+	if n := readResponse(0); n < int64(len(chunk)*chunksCount) {
+		t.Fatalf("unexpected response size: %d. Expecting at least %d", n, len(chunk)*chunksCount)
+	}
+
+	// The connection to the slow client must be closed.
+	if n := readResponse(10 * time.Millisecond); n >= int64(len(chunk)*chunksCount) {
+		t.Fatalf("unexpected full response read by the slow client")
+	}
+}
+
+func TestGetShardWorkersCount(t *testing.T) {
+	for _, shardsCount := range []int{1, 3, 7, 16} {
+		n := 0
+		for i := 0; i < shardsCount; i++ {
+			m := getShardWorkersCount(100, shardsCount, i)
+			if m < 100/shardsCount || m > 100/shardsCount+1 {
+				t.Fatalf("unexpected workers count for shard #%d of %d: %d", i, shardsCount, m)
+			}
+			n += m
+		}
+		if n != 100 {
+			t.Fatalf("unexpected total workers count for %d shards: %d. Expecting 100", shardsCount, n)
+		}
+	}
+}
+
 func TestServerWriteFastError(t *testing.T) {
 	s := &Server{
 		Name: "foobar",
@@ -1104,21 +1530,99 @@ func TestRequestCtxFormValue(t *testing.T) {
 	}
 }
 
-func TestRequestCtxUserValue(t *testing.T) {
+func TestRequestCtxFormArgs(t *testing.T) {
 	var ctx RequestCtx
+	var req Request
+	req.SetRequestURI("/foo/bar?baz=123&empty=&dup=query")
+	req.SetBodyString("dup=body&empty=body&qqq=port")
+	req.Header.SetContentType("application/x-www-form-urlencoded")
 
-	for i := 0; i < 5; i++ {
-		k := fmt.Sprintf("key-%d", i)
-		ctx.SetUserValue(k, i)
+	ctx.Init(&req, nil, nil)
+
+	if v := ctx.FormValueString("dup"); v != "query" {
+		t.Fatalf("unexpected value %q. Expecting %q", v, "query")
 	}
-	for i := 5; i < 10; i++ {
-		k := fmt.Sprintf("key-%d", i)
-		ctx.SetUserValueBytes([]byte(k), i)
+	if v := ctx.FormValueString("qqq"); v != "port" {
+		t.Fatalf("unexpected value %q. Expecting %q", v, "port")
 	}
 
-	for i := 0; i < 10; i++ {
-		k := fmt.Sprintf("key-%d", i)
-		v := ctx.UserValue(k)
+	// FormValue skips empty values, while empty query args take precedence
+	// over body args in FormArgs.
+	if v := ctx.FormValueString("empty"); v != "body" {
+		t.Fatalf("unexpected value %q. Expecting %q", v, "body")
+	}
+	if v := ctx.FormArgs().Peek("empty"); len(v) > 0 {
+		t.Fatalf("unexpected value %q. Expecting empty value", v)
+	}
+	if !ctx.FormArgs().Has("empty") || ctx.FormArgs().Has("missing") {
+		t.Fatalf("unexpected Has result")
+	}
+	vv := ctx.FormArgs().PeekMulti("dup")
+	if len(vv) != 2 || string(vv[0]) != "query" || string(vv[1]) != "body" {
+		t.Fatalf("unexpected values %q. Expecting [query body]", vv)
+	}
+
+	// The args are rebuilt after the request changes.
+	ctx.QueryArgs().Set("new", "query")
+	if v := ctx.FormArgs().Peek("new"); string(v) != "query" {
+		t.Fatalf("unexpected value %q. Expecting %q", v, "query")
+	}
+	ctx.Request.SetBodyString("qqq=changed")
+	if v := ctx.FormArgs().Peek("qqq"); string(v) != "changed" {
+		t.Fatalf("unexpected value %q. Expecting %q", v, "changed")
+	}
+	ctx.Request.SetRequestURI("/foo/bar?other=1")
+	if ctx.FormArgs().Has("baz") || !ctx.FormArgs().Has("other") {
+		t.Fatalf("stale args after changing request uri: %s", ctx.FormArgs())
+	}
+}
+
+func TestRequestCtxFormArgsMultipart(t *testing.T) {
+	var ctx RequestCtx
+	var req Request
+	req.SetRequestURI("/foo?a=query")
+	req.Header.SetMultipartFormBoundary("foobar")
+	req.SetBodyString("--foobar\r\n" +
+		"Content-Disposition: form-data; name=\"a\"\r\n\r\nform\r\n" +
+		"--foobar\r\n" +
+		"Content-Disposition: form-data; name=\"b\"\r\n\r\nbbb\r\n" +
+		"--foobar--\r\n")
+
+	ctx.Init(&req, nil, nil)
+
+	if v := ctx.FormValueString("a"); v != "query" {
+		t.Fatalf("unexpected value %q. Expecting %q", v, "query")
+	}
+	if v := ctx.FormValueString("b"); v != "bbb" {
+		t.Fatalf("unexpected value %q. Expecting %q", v, "bbb")
+	}
+	vv := ctx.FormArgs().PeekMulti("a")
+	if len(vv) != 2 || string(vv[1]) != "form" {
+		t.Fatalf("unexpected values %q. Expecting [query form]", vv)
+	}
+
+	// The combined args are reset with the request.
+	ctx.Request.Reset()
+	if ctx.FormArgs().Len() != 0 {
+		t.Fatalf("unexpected args after reset: %s", ctx.FormArgs())
+	}
+}
+
+func TestRequestCtxUserValue(t *testing.T) {
+	var ctx RequestCtx
+
+	for i := 0; i < 5; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		ctx.SetUserValue(k, i)
+	}
+	for i := 5; i < 10; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		ctx.SetUserValueBytes([]byte(k), i)
+	}
+
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		v := ctx.UserValue(k)
 		n, ok := v.(int)
 		if !ok || n != i {
 			t.Fatalf("unexpected value obtained for key %q: %v. Expecting %d", k, v, i)
@@ -1131,111 +1635,844 @@ func TestRequestCtxUserValue(t *testing.T) {
 		if v != value {
 			t.Fatalf("unexpected value obtained from VisitUserValues for key: %q, expecting: %#v but got: %#v", key, v, value)
 		}
-	})
-	if len(ctx.userValues) != vlen {
-		t.Fatalf("the length of user values returned from VisitUserValues is not equal to the length of the userValues, expecting: %d but got: %d", len(ctx.userValues), vlen)
+	})
+	if len(ctx.userValues) != vlen {
+		t.Fatalf("the length of user values returned from VisitUserValues is not equal to the length of the userValues, expecting: %d but got: %d", len(ctx.userValues), vlen)
+	}
+}
+
+func TestServerHeadRequest(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			fmt.Fprintf(ctx, "Request method is %q", ctx.Method())
+			ctx.SetContentType("aaa/bbb")
+		},
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("HEAD /foobar HTTP/1.1\r\nHost: aaa.com\r\n\r\n")
+
+	ch := make(chan error)
+	go func() {
+		ch <- s.ServeConn(rw)
+	}()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Unexpected error from serveConn: %s", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("timeout")
+	}
+
+	br := bufio.NewReader(&rw.w)
+	var resp Response
+	resp.SkipBody = true
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("Unexpected error when parsing response: %s", err)
+	}
+	if resp.Header.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.Header.StatusCode(), StatusOK)
+	}
+	if len(resp.Body()) > 0 {
+		t.Fatalf("Unexpected non-zero body %q", resp.Body())
+	}
+	if resp.Header.ContentLength() != 24 {
+		t.Fatalf("unexpected content-length %d. Expecting %d", resp.Header.ContentLength(), 24)
+	}
+	if string(resp.Header.ContentType()) != "aaa/bbb" {
+		t.Fatalf("unexpected content-type %q. Expecting %q", resp.Header.ContentType(), "aaa/bbb")
+	}
+
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("Unexpected error when reading remaining data: %s", err)
+	}
+	if len(data) > 0 {
+		t.Fatalf("unexpected remaining data %q", data)
+	}
+}
+
+func TestServerExpect100Continue(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if !ctx.IsPost() {
+				t.Fatalf("unexpected method %q. Expecting POST", ctx.Method())
+			}
+			if string(ctx.Path()) != "/foo" {
+				t.Fatalf("unexpected path %q. Expecting %q", ctx.Path(), "/foo")
+			}
+			ct := ctx.Request.Header.ContentType()
+			if string(ct) != "a/b" {
+				t.Fatalf("unexpectected content-type: %q. Expecting %q", ct, "a/b")
+			}
+			if string(ctx.PostBody()) != "12345" {
+				t.Fatalf("unexpected body: %q. Expecting %q", ctx.PostBody(), "12345")
+			}
+			ctx.WriteString("foobar")
+		},
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("POST /foo HTTP/1.1\r\nHost: gle.com\r\nExpect: 100-continue\r\nContent-Length: 5\r\nContent-Type: a/b\r\n\r\n12345")
+
+	ch := make(chan error)
+	go func() {
+		ch <- s.ServeConn(rw)
+	}()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Unexpected error from serveConn: %s", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("timeout")
+	}
+
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, string(defaultContentType), "foobar")
+
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("Unexpected error when reading remaining data: %s", err)
+	}
+	if len(data) > 0 {
+		t.Fatalf("unexpected remaining data %q", data)
+	}
+}
+
+func TestServerRequestBodyTee(t *testing.T) {
+	var tee bytes.Buffer
+	s := &Server{
+		RequestBodyTee: func(ctx *RequestCtx) io.Writer {
+			if string(ctx.Path()) != "/tee" {
+				return nil
+			}
+			return &tee
+		},
+		Handler: func(ctx *RequestCtx) {
+			ctx.Success("text/plain", ctx.PostBody())
+		},
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("POST /tee HTTP/1.1\r\nHost: gle.com\r\nContent-Length: 3\r\n\r\nabc")
+	rw.r.WriteString("POST /foo HTTP/1.1\r\nHost: gle.com\r\nContent-Length: 3\r\n\r\ndef")
+	rw.r.WriteString("POST /tee HTTP/1.1\r\nHost: gle.com\r\nExpect: 100-continue\r\nContent-Length: 3\r\n\r\nghi")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error from serveConn: %s", err)
+	}
+
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, "text/plain", "abc")
+	verifyResponse(t, br, StatusOK, "text/plain", "def")
+	if tee.String() != "abcghi" {
+		t.Fatalf("unexpected tee contents: %q. Expecting %q", tee.String(), "abcghi")
+	}
+}
+
+func TestServerRouteSettings(t *testing.T) {
+	bulk := &RouteSettings{
+		MaxRequestBodySize: 100,
+	}
+	api := &RouteSettings{
+		MaxRequestBodySize: 1000,
+		Compress:           true,
+		CompressLevel:      CompressBestSpeed,
+	}
+	s := &Server{
+		MaxRequestBodySize: 10,
+		RouteSettings: func(ctx *RequestCtx) *RouteSettings {
+			switch {
+			case bytes.HasPrefix(ctx.Path(), []byte("/bulk/")):
+				return bulk
+			case bytes.HasPrefix(ctx.Path(), []byte("/api/")):
+				return api
+			}
+			return nil
+		},
+		Handler: func(ctx *RequestCtx) {
+			ctx.Success("text/plain", ctx.PostBody())
+		},
+	}
+
+	body := strings.Repeat("x", 50)
+	rw := &readWriter{}
+	rw.r.WriteString("POST /bulk/foo HTTP/1.1\r\nHost: gle.com\r\nContent-Length: 50\r\n\r\n" + body)
+	rw.r.WriteString("POST /api/foo HTTP/1.1\r\nHost: gle.com\r\nAccept-Encoding: gzip\r\nContent-Length: 500\r\n\r\n" + strings.Repeat("y", 500))
+	rw.r.WriteString("POST /foo HTTP/1.1\r\nHost: gle.com\r\nContent-Length: 50\r\n\r\n" + body)
+	if err := s.ServeConn(rw); err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error from serveConn: %v. Expecting %v", err, ErrBodyTooLarge)
+	}
+
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, "text/plain", body)
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ce := resp.Header.Peek("Content-Encoding"); string(ce) != "gzip" {
+		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, "gzip")
+	}
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusRequestEntityTooLarge)
+	}
+}
+
+func TestServerRouteSettingsReadTimeout(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	s := &Server{
+		RouteSettings: func(ctx *RequestCtx) *RouteSettings {
+			if string(ctx.Path()) == "/slow" {
+				return &RouteSettings{
+					ReadTimeout: 50 * time.Millisecond,
+				}
+			}
+			return nil
+		},
+		Handler: func(ctx *RequestCtx) {
+			ctx.Success("text/plain", ctx.PostBody())
+		},
+		Logger: &customLogger{},
+	}
+	go s.Serve(ln)
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte("POST /fast HTTP/1.1\r\nHost: gle.com\r\nContent-Length: 3\r\n\r\nabc")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(c)
+	verifyResponse(t, br, StatusOK, "text/plain", "abc")
+
+	// The connection must stay open after the deadline set for /slow,
+	// since the deadline is reset for the next request.
+	if _, err := c.Write([]byte("POST /slow HTTP/1.1\r\nHost: gle.com\r\nContent-Length: 3\r\n\r\ndef")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	verifyResponse(t, br, StatusOK, "text/plain", "def")
+	time.Sleep(100 * time.Millisecond)
+	if _, err := c.Write([]byte("POST /fast HTTP/1.1\r\nHost: gle.com\r\nContent-Length: 3\r\n\r\nghi")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	verifyResponse(t, br, StatusOK, "text/plain", "ghi")
+
+	// The body of /slow request isn't sent in time.
+	if _, err := c.Write([]byte("POST /slow HTTP/1.1\r\nHost: gle.com\r\nContent-Length: 3\r\n\r\nj")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var resp Response
+	if err := resp.Read(br); err == nil && resp.StatusCode() == StatusOK {
+		t.Fatalf("expecting the request to fail due to read timeout")
+	}
+}
+
+func TestServerStrictHeaderParsing(t *testing.T) {
+	s := &Server{
+		StrictHeaderParsing: true,
+		Handler: func(ctx *RequestCtx) {
+			ctx.Success("text/plain", ctx.Request.Header.Peek("X-Foo"))
+		},
+		Logger: &customLogger{},
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("GET /foo HTTP/1.1\r\nHost: gle.com\r\nX-Foo: bar\r\n\r\n")
+	rw.r.WriteString("GET /foo HTTP/1.1\r\nHost: gle.com\r\nX-Foo: bar\r\n baz\r\n\r\n")
+	if err := s.ServeConn(rw); !errors.Is(err, errObsFoldHeader) {
+		t.Fatalf("unexpected error from serveConn: %v. Expecting %v", err, errObsFoldHeader)
+	}
+
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, "text/plain", "bar")
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusBadRequest {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusBadRequest)
+	}
+}
+
+func TestServerHeaderInjectionHandler(t *testing.T) {
+	var reports []string
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Response.Header.Set("X-Lang", string(ctx.QueryArgs().Peek("lang")))
+			ctx.Success("text/plain", []byte("ok"))
+		},
+		HeaderInjectionHandler: func(ctx *RequestCtx, hi *HeaderInjection) {
+			reports = append(reports, fmt.Sprintf("%s %s: %q %v", ctx.RequestURI(), hi.Key, hi.Value, hi.Rejected))
+		},
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("GET /foo?lang=en HTTP/1.1\r\nHost: gle.com\r\n\r\n")
+	rw.r.WriteString("GET /foo?lang=en%0d%0aSet-Cookie:x=y HTTP/1.1\r\nHost: gle.com\r\n\r\n")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error from serveConn: %s", err)
+	}
+
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, "text/plain", "ok")
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v := string(resp.Header.Peek("X-Lang")); v != "enSet-Cookie:x=y" {
+		t.Fatalf("unexpected header value: %q. Expecting %q", v, "enSet-Cookie:x=y")
+	}
+	if len(resp.Header.Peek("Set-Cookie")) > 0 {
+		t.Fatalf("unexpected Set-Cookie header injected")
+	}
+	expectedReports := []string{
+		`/foo?lang=en%0d%0aSet-Cookie:x=y X-Lang: "en\r\nSet-Cookie:x=y" false`,
+	}
+	if fmt.Sprint(reports) != fmt.Sprint(expectedReports) {
+		t.Fatalf("unexpected reports: %q. Expecting %q", reports, expectedReports)
+	}
+}
+
+func TestServerHeaderInjectionPolicy(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetContentType(string(ctx.QueryArgs().Peek("type")))
+			ctx.SetBodyString("ok")
+		},
+		HeaderInjectionPolicy: HeaderInjectionError,
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("GET /foo?type=text/plain HTTP/1.1\r\nHost: gle.com\r\n\r\n")
+	rw.r.WriteString("GET /foo?type=text/plain%0d%0aSet-Cookie:x=y HTTP/1.1\r\nHost: gle.com\r\n\r\n")
+	if err := s.ServeConn(rw); !errors.Is(err, ErrHeaderInjection) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrHeaderInjection)
+	}
+
+	if strings.Contains(rw.w.String(), "Set-Cookie") {
+		t.Fatalf("unexpected response written for rejected header: %q", rw.w.Bytes())
+	}
+
+	// Other servers use the default policy.
+	s = &Server{
+		Handler: s.Handler,
+	}
+	rw = &readWriter{}
+	rw.r.WriteString("GET /foo?type=text/plain%0d%0aSet-Cookie:x=y HTTP/1.1\r\nHost: gle.com\r\n\r\n")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error from serveConn: %s", err)
+	}
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, "text/plainSet-Cookie:x=y", "ok")
+}
+
+func TestServerContinueHandlerReject(t *testing.T) {
+	s := &Server{
+		ContinueHandler: func(ctx *RequestCtx) bool {
+			if len(ctx.Request.Header.Peek("Authorization")) == 0 {
+				ctx.Error("Unauthorized", StatusUnauthorized)
+				return false
+			}
+			return true
+		},
+		Handler: func(ctx *RequestCtx) {
+			ctx.Success("text/plain", ctx.PostBody())
+		},
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("POST /foo HTTP/1.1\r\nHost: gle.com\r\nAuthorization: xx\r\nExpect: 100-continue\r\nContent-Length: 3\r\n\r\nabc")
+	rw.r.WriteString("POST /foo HTTP/1.1\r\nHost: gle.com\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n12345")
+	rw.r.WriteString("POST /foo HTTP/1.1\r\nHost: gle.com\r\nAuthorization: xx\r\nExpect: 100-continue\r\nContent-Length: 3\r\n\r\nabc")
+
+	ch := make(chan error)
+	go func() {
+		ch <- s.ServeConn(rw)
+	}()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Unexpected error from serveConn: %s", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("timeout")
+	}
+
+	br := bufio.NewReader(&rw.w)
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("Unexpected error when reading response: %s", err)
+	}
+	if string(resp.Body()) != "abc" {
+		t.Fatalf("Unexpected body %q. Expecting %q", resp.Body(), "abc")
+	}
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("Unexpected error when reading response: %s", err)
+	}
+	if resp.StatusCode() != StatusUnauthorized {
+		t.Fatalf("Unexpected status code %d. Expecting %d", resp.StatusCode(), StatusUnauthorized)
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("Expecting 'Connection: close' for the rejected request")
+	}
+
+	// The connection must be closed after the rejection, so the last
+	// request must remain unserved.
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		t.Fatalf("Unexpected error when reading remaining data: %s", err)
+	}
+	if len(data) > 0 {
+		t.Fatalf("unexpected remaining data %q", data)
+	}
+}
+
+func TestCompressHandlerStreamWriter(t *testing.T) {
+	expectedBody := string(createFixedBody(2e4))
+	h := CompressHandler(func(ctx *RequestCtx) {
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			for i := 0; i < len(expectedBody); i += 1000 {
+				w.WriteString(expectedBody[i : i+1000])
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		})
+	})
+
+	var ctx RequestCtx
+	var resp Response
+	ctx.Request.Header.Set("Accept-Encoding", "gzip")
+	h(&ctx)
+	if ctx.Response.Header.ContentLength() != -1 {
+		t.Fatalf("unexpected Content-Length: %d. Expecting -1", ctx.Response.Header.ContentLength())
+	}
+	s := ctx.Response.String()
+	br := bufio.NewReader(bytes.NewBufferString(s))
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ce := resp.Header.Peek("Content-Encoding")
+	if string(ce) != "gzip" {
+		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, "gzip")
+	}
+	body, err := resp.BodyGunzip()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+	}
+
+	ctx.Request.Reset()
+	ctx.Response.Reset()
+	ctx.Request.Header.Set("Accept-Encoding", "deflate")
+	h(&ctx)
+	s = ctx.Response.String()
+	br = bufio.NewReader(bytes.NewBufferString(s))
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ce = resp.Header.Peek("Content-Encoding")
+	if string(ce) != "deflate" {
+		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, "deflate")
+	}
+	body, err = resp.BodyInflate()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+	}
+}
+
+func TestCompressHandlerZstd(t *testing.T) {
+	d, err := NewZstdDict(testZstdDictData)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedBody := string(testZstdJSON(123))
+	h := CompressHandlerZstd(func(ctx *RequestCtx) {
+		if string(ctx.Path()) == "/stream" {
+			ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+				w.WriteString(expectedBody)
+			})
+			return
+		}
+		ctx.WriteString(expectedBody)
+	}, d)
+
+	for _, path := range []string{"/", "/stream"} {
+		var ctx RequestCtx
+		var resp Response
+		ctx.Request.SetRequestURI(path)
+		ctx.Request.Header.Set("Accept-Encoding", "gzip, zstd")
+		h(&ctx)
+		s := ctx.Response.String()
+		br := bufio.NewReader(bytes.NewBufferString(s))
+		if err := resp.Read(br); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ce := resp.Header.Peek("Content-Encoding")
+		if string(ce) != "zstd" {
+			t.Fatalf("unexpected Content-Encoding for %q: %q. Expecting %q", path, ce, "zstd")
+		}
+		body, err := resp.BodyUnzstdDict(d)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(body) != expectedBody {
+			t.Fatalf("unexpected body %q. Expecting %q", body, expectedBody)
+		}
+	}
+}
+
+func TestServerReuseCompressors(t *testing.T) {
+	expectedBody := string(createFixedBody(2e4))
+	s := &Server{
+		Handler: CompressHandler(func(ctx *RequestCtx) {
+			ctx.WriteString(expectedBody)
+		}),
+		ReuseCompressors: true,
+	}
+
+	rw := &readWriter{}
+	for i := 0; i < 3; i++ {
+		rw.r.WriteString("GET / HTTP/1.1\r\nHost: aa\r\nAccept-Encoding: gzip\r\n\r\n")
+		rw.r.WriteString("GET / HTTP/1.1\r\nHost: aa\r\nAccept-Encoding: deflate\r\n\r\n")
+	}
+
+	ch := make(chan error)
+	go func() {
+		ch <- s.ServeConn(rw)
+	}()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Unexpected error from serveConn: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	br := bufio.NewReader(&rw.w)
+	var resp Response
+	for i := 0; i < 6; i++ {
+		if err := resp.Read(br); err != nil {
+			t.Fatalf("Unexpected error when reading response: %s", err)
+		}
+		var body []byte
+		var err error
+		if i%2 == 0 {
+			body, err = resp.BodyGunzip()
+		} else {
+			body, err = resp.BodyInflate()
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if string(body) != expectedBody {
+			t.Fatalf("Unexpected body for response #%d", i)
+		}
+	}
+}
+
+func TestServerUseRequestArena(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Request.Header.Set("X-Added", "added-header-value")
+			fmt.Fprintf(ctx, "%s|%s|%s|%s", ctx.Request.Header.Peek("X-Foo"), ctx.QueryArgs().Peek("x"),
+				ctx.PostArgs().Peek("y"), ctx.Request.Header.Peek("X-Added"))
+		},
+		UseRequestArena: true,
+	}
+
+	rw := &readWriter{}
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&rw.r, "POST /?x=%d HTTP/1.1\r\nHost: aa\r\nX-Foo: foo%d\r\n"+
+			"Content-Type: application/x-www-form-urlencoded\r\nContent-Length: 5\r\n\r\ny=%03d", i, i, i)
+	}
+
+	ch := make(chan error)
+	go func() {
+		ch <- s.ServeConn(rw)
+	}()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Unexpected error from serveConn: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout")
+	}
+
+	br := bufio.NewReader(&rw.w)
+	for i := 0; i < 5; i++ {
+		expectedBody := fmt.Sprintf("foo%d|%d|%03d|added-header-value", i, i, i)
+		verifyResponse(t, br, StatusOK, "text/plain; charset=utf-8", expectedBody)
+	}
+}
+
+func TestServerJSONErrors(t *testing.T) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	h := func(ctx *RequestCtx) {
+		if string(ctx.Path()) == "/slow" {
+			<-doneCh
+		}
+	}
+	s := &Server{
+		Handler:            TimeoutHandler(h, 20*time.Millisecond, "timeout!!!"),
+		MaxRequestBodySize: 4,
+		JSONErrors:         true,
+	}
+	jsonType := string(strJSONErrorType)
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln)
+
+	testJSONError := func(req string, expectedBody string) {
+		conn, err := ln.Dial()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer conn.Close()
+		if _, err = conn.Write([]byte(req)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		br := bufio.NewReader(conn)
+		var resp Response
+		if err := resp.Read(br); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if string(resp.Header.ContentType()) != jsonType {
+			t.Fatalf("unexpected content-type %q. Expecting %q", resp.Header.ContentType(), jsonType)
+		}
+		// request_id depends on the connection id, so verify only its presence.
+		expectedPrefix := expectedBody + `,"request_id":"`
+		if !strings.HasPrefix(string(resp.Body()), expectedPrefix) {
+			t.Fatalf("unexpected body %q. Expecting it to start with %q", resp.Body(), expectedPrefix)
+		}
+	}
+
+	testJSONError("POST / HTTP/1.1\r\nHost: aa\r\nContent-Length: 10\r\n\r\n0123456789",
+		`{"code":413,"message":"Request body is too large"`)
+	testJSONError("GET\r\n\r\n",
+		`{"code":400,"message":"Error when parsing request"`)
+	testJSONError("GET /slow HTTP/1.1\r\nHost: aa\r\n\r\n",
+		`{"code":408,"message":"timeout!!!"`)
+
+	var w bytes.Buffer
+	s.writeFastError(&w, StatusServiceUnavailable, "\"overloaded\"")
+	br := bufio.NewReader(&w)
+	verifyResponse(t, br, StatusServiceUnavailable, jsonType, `{"code":503,"message":"\"overloaded\""}`)
+}
+
+func TestServerRecordHandler(t *testing.T) {
+	type recording struct {
+		request  string
+		response string
+	}
+	var recordings []recording
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.Path())
+		},
+		RecordHandler: func(remoteAddr net.Addr, request, response []byte) {
+			recordings = append(recordings, recording{string(request), string(response)})
+		},
+		RecordSampling: 2,
+		MaxRecordSize:  60,
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("POST /first HTTP/1.1\r\nHost: aa\r\nContent-Length: 3\r\n\r\nabc")
+	rw.r.WriteString("GET /second HTTP/1.1\r\nHost: aa\r\n\r\n")
+	rw.r.WriteString("POST /third HTTP/1.1\r\nHost: aa\r\nContent-Length: 10\r\n\r\n0123456789")
+	rw.r.WriteString("GET /fourth HTTP/1.1\r\nHost: aa\r\n\r\n")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	br := bufio.NewReader(&rw.w)
+	for _, path := range []string{"/first", "/second", "/third", "/fourth"} {
+		verifyResponse(t, br, StatusOK, string(defaultContentType), path)
+	}
+
+	if len(recordings) != 2 {
+		t.Fatalf("unexpected number of recordings: %d. Expecting 2", len(recordings))
+	}
+	expectedRequests := []string{
+		"GET /second HTTP/1.1\r\nHost: aa\r\n\r\n",
+		"GET /fourth HTTP/1.1\r\nHost: aa\r\n\r\n",
+	}
+	for i, r := range recordings {
+		if r.request != expectedRequests[i] {
+			t.Fatalf("unexpected recorded request #%d %q. Expecting %q", i, r.request, expectedRequests[i])
+		}
+		if !strings.HasPrefix(r.response, "HTTP/1.1 ") || len(r.response) > s.MaxRecordSize {
+			t.Fatalf("unexpected recorded response #%d %q", i, r.response)
+		}
+	}
+
+	// Request bodies are recorded after the header up to MaxRecordSize,
+	// while malformed requests are recorded as is.
+	recordings = recordings[:0]
+	s.RecordSampling = 0
+	rw = &readWriter{}
+	rw.r.WriteString("POST /third HTTP/1.1\r\nHost: aa\r\nContent-Length: 10\r\n\r\n0123456789")
+	rw.r.WriteString("malformed\r\n\r\n")
+	if err := s.ServeConn(rw); err == nil {
+		t.Fatalf("expecting error for malformed request")
+	}
+	expectedRequests = []string{
+		"POST /third HTTP/1.1\r\nHost: aa\r\nContent-Length: 10\r\n\r\n0123456789"[:s.MaxRecordSize],
+		"malformed\r\n\r\n",
+	}
+	if len(recordings) != 2 {
+		t.Fatalf("unexpected number of recordings: %d. Expecting 2", len(recordings))
+	}
+	for i, r := range recordings {
+		if r.request != expectedRequests[i] {
+			t.Fatalf("unexpected recorded request #%d %q. Expecting %q", i, r.request, expectedRequests[i])
+		}
+	}
+	if !strings.HasPrefix(recordings[1].response, "HTTP/1.1 400 Bad Request\r\n") {
+		t.Fatalf("unexpected recorded response %q", recordings[1].response)
 	}
 }
 
-func TestServerHeadRequest(t *testing.T) {
+func TestServerHTTP10(t *testing.T) {
 	s := &Server{
 		Handler: func(ctx *RequestCtx) {
-			fmt.Fprintf(ctx, "Request method is %q", ctx.Method())
-			ctx.SetContentType("aaa/bbb")
+			if string(ctx.Path()) == "/stream" {
+				ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+					w.WriteString("first")
+					w.Flush()
+					w.WriteString("second")
+				})
+				return
+			}
+			ctx.WriteString("foobar")
 		},
 	}
-
-	rw := &readWriter{}
-	rw.r.WriteString("HEAD /foobar HTTP/1.1\r\nHost: aaa.com\r\n\r\n")
-
-	ch := make(chan error)
-	go func() {
-		ch <- s.ServeConn(rw)
-	}()
-
-	select {
-	case err := <-ch:
-		if err != nil {
-			t.Fatalf("Unexpected error from serveConn: %s", err)
+	serve := func(req string) string {
+		rw := &readWriter{}
+		rw.r.WriteString(req)
+		if err := s.ServeConn(rw); err != nil {
+			t.Fatalf("unexpected error: %s", err)
 		}
-	case <-time.After(100 * time.Millisecond):
-		t.Fatalf("timeout")
+		return rw.w.String()
 	}
+	keepAliveReq := "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"
 
-	br := bufio.NewReader(&rw.w)
-	var resp Response
-	resp.SkipBody = true
-	if err := resp.Read(br); err != nil {
-		t.Fatalf("Unexpected error when parsing response: %s", err)
+	// 'Connection: keep-alive' is honored by default.
+	resp := serve(keepAliveReq + keepAliveReq)
+	if n := strings.Count(resp, "HTTP/1.1 200 OK"); n != 2 {
+		t.Fatalf("unexpected number of responses: %d. Expecting 2. Response:\n%s", n, resp)
 	}
-	if resp.Header.StatusCode() != StatusOK {
-		t.Fatalf("unexpected status code: %d. Expecting %d", resp.Header.StatusCode(), StatusOK)
+
+	// Streamed bodies are delimited by connection close.
+	resp = serve("GET /stream HTTP/1.0\r\nConnection: keep-alive\r\n\r\n" + keepAliveReq)
+	if strings.Contains(resp, "Transfer-Encoding") || !strings.Contains(resp, "Connection: close\r\n") {
+		t.Fatalf("unexpected response headers for HTTP/1.0 stream:\n%s", resp)
 	}
-	if len(resp.Body()) > 0 {
-		t.Fatalf("Unexpected non-zero body %q", resp.Body())
+	if !strings.HasSuffix(resp, "\r\n\r\nfirstsecond") {
+		t.Fatalf("unexpected response body for HTTP/1.0 stream:\n%s", resp)
 	}
-	if resp.Header.ContentLength() != 24 {
-		t.Fatalf("unexpected content-length %d. Expecting %d", resp.Header.ContentLength(), 24)
+
+	s.DisableHTTP10KeepAlive = true
+	resp = serve(keepAliveReq + keepAliveReq)
+	if n := strings.Count(resp, "HTTP/1.1 200 OK"); n != 1 || !strings.Contains(resp, "Connection: close\r\n") {
+		t.Fatalf("unexpected response with disabled HTTP/1.0 keep-alive:\n%s", resp)
 	}
-	if string(resp.Header.ContentType()) != "aaa/bbb" {
-		t.Fatalf("unexpected content-type %q. Expecting %q", resp.Header.ContentType(), "aaa/bbb")
+
+	s.RejectHTTP10 = true
+	br := bufio.NewReader(bytes.NewBufferString(serve(keepAliveReq)))
+	verifyResponse(t, br, StatusHTTPVersionNotSupported, string(defaultContentType), "HTTP/1.0 isn't supported")
+
+	resp = serve("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")
+	if !strings.HasSuffix(resp, "\r\n\r\nfoobar") {
+		t.Fatalf("unexpected response for HTTP/1.1 request:\n%s", resp)
 	}
+}
 
-	data, err := ioutil.ReadAll(br)
-	if err != nil {
-		t.Fatalf("Unexpected error when reading remaining data: %s", err)
+func TestServerBodyLengthMismatchPolicy(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.PostBody())
+		},
+		BodyLengthMismatchPolicy: BodyLengthMismatchAccept,
 	}
-	if len(data) > 0 {
-		t.Fatalf("unexpected remaining data %q", data)
+	rw := &readWriter{}
+	rw.r.WriteString("POST / HTTP/1.1\r\nHost: aa\r\nContent-Length: 10\r\n\r\nshort")
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	br := bufio.NewReader(&rw.w)
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(resp.Body()) != "short" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "short")
+	}
+	if !resp.ConnectionClose() {
+		t.Fatalf("expecting 'Connection: close' response header")
 	}
 }
 
-func TestServerExpect100Continue(t *testing.T) {
+func TestServerDecompressLimits(t *testing.T) {
 	s := &Server{
 		Handler: func(ctx *RequestCtx) {
-			if !ctx.IsPost() {
-				t.Fatalf("unexpected method %q. Expecting POST", ctx.Method())
-			}
-			if string(ctx.Path()) != "/foo" {
-				t.Fatalf("unexpected path %q. Expecting %q", ctx.Path(), "/foo")
-			}
-			ct := ctx.Request.Header.ContentType()
-			if string(ct) != "a/b" {
-				t.Fatalf("unexpectected content-type: %q. Expecting %q", ct, "a/b")
-			}
-			if string(ctx.PostBody()) != "12345" {
-				t.Fatalf("unexpected body: %q. Expecting %q", ctx.PostBody(), "12345")
+			body, err := ctx.Request.BodyGunzip()
+			if err != nil {
+				if _, ok := err.(*DecompressLimitError); !ok {
+					t.Errorf("unexpected error: %s. Expecting *DecompressLimitError", err)
+				}
+				ctx.Error("too large", StatusRequestEntityTooLarge)
+				return
 			}
-			ctx.WriteString("foobar")
+			ctx.Write(body)
 		},
+		DecompressLimits: &DecompressLimits{MaxSize: 100},
 	}
 
 	rw := &readWriter{}
-	rw.r.WriteString("POST /foo HTTP/1.1\r\nHost: gle.com\r\nExpect: 100-continue\r\nContent-Length: 5\r\nContent-Type: a/b\r\n\r\n12345")
-
-	ch := make(chan error)
-	go func() {
-		ch <- s.ServeConn(rw)
-	}()
-
-	select {
-	case err := <-ch:
-		if err != nil {
-			t.Fatalf("Unexpected error from serveConn: %s", err)
-		}
-	case <-time.After(100 * time.Millisecond):
-		t.Fatalf("timeout")
+	for _, n := range []int{100, 101} {
+		body := AppendGzipBytes(nil, bytes.Repeat([]byte("a"), n))
+		fmt.Fprintf(&rw.r, "POST / HTTP/1.1\r\nHost: aa\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	if err := s.ServeConn(rw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
 	}
 
 	br := bufio.NewReader(&rw.w)
-	verifyResponse(t, br, StatusOK, string(defaultContentType), "foobar")
-
-	data, err := ioutil.ReadAll(br)
-	if err != nil {
-		t.Fatalf("Unexpected error when reading remaining data: %s", err)
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
 	}
-	if len(data) > 0 {
-		t.Fatalf("unexpected remaining data %q", data)
+	if resp.StatusCode() != StatusOK || len(resp.Body()) != 100 {
+		t.Fatalf("unexpected response: %d, %q", resp.StatusCode(), resp.Body())
+	}
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusRequestEntityTooLarge)
 	}
 }
 
@@ -2320,6 +3557,135 @@ func (rw *readWriterRemoteAddr) LocalAddr() net.Addr {
 	return rw.addr
 }
 
+func TestServerAsteriskOptions(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if ctx.IsAsteriskForm() {
+				t.Fatalf("Unexpected asterisk-form request passed to the handler")
+			}
+			ctx.Success("text/plain", ctx.Path())
+		},
+		AsteriskOptionsAllow: "GET, HEAD, OPTIONS",
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("OPTIONS * HTTP/1.1\r\nHost: aa\r\n\r\n")
+	rw.r.WriteString("OPTIONS /* HTTP/1.1\r\nHost: aa\r\n\r\n")
+
+	ch := make(chan error)
+	go func() {
+		ch <- s.ServeConn(rw)
+	}()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Unexpected error from serveConn: %s", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("timeout")
+	}
+
+	br := bufio.NewReader(&rw.w)
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("Unexpected error when reading response: %s", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("Unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	allow := resp.Header.Peek("Allow")
+	if string(allow) != s.AsteriskOptionsAllow {
+		t.Fatalf("Unexpected Allow header %q. Expecting %q", allow, s.AsteriskOptionsAllow)
+	}
+	if len(resp.Body()) > 0 {
+		t.Fatalf("Unexpected body %q", resp.Body())
+	}
+	verifyResponse(t, br, StatusOK, "text/plain", "/*")
+}
+
+func TestServerAsteriskFormNotOptions(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			t.Fatalf("Unexpected asterisk-form GET request passed to the handler")
+		},
+		AsteriskOptionsAllow: "GET, HEAD, OPTIONS",
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("GET * HTTP/1.1\r\nHost: aa\r\n\r\n")
+
+	ch := make(chan error)
+	go func() {
+		ch <- s.ServeConn(rw)
+	}()
+
+	select {
+	case err := <-ch:
+		if !errors.Is(err, errAsteriskFormNotOptions) {
+			t.Fatalf("Unexpected error from serveConn: %v. Expecting %v", err, errAsteriskFormNotOptions)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("timeout")
+	}
+
+	br := bufio.NewReader(&rw.w)
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		t.Fatalf("Unexpected error when reading response: %s", err)
+	}
+	if resp.StatusCode() != StatusBadRequest {
+		t.Fatalf("Unexpected status code %d. Expecting %d", resp.StatusCode(), StatusBadRequest)
+	}
+}
+
+func TestServerAsteriskOptionsHandler(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if !ctx.IsAsteriskForm() {
+				t.Fatalf("Expecting asterisk-form request")
+			}
+			ctx.Success("text/plain", ctx.Path())
+		},
+	}
+
+	rw := &readWriter{}
+	rw.r.WriteString("OPTIONS * HTTP/1.1\r\nHost: aa\r\n\r\n")
+
+	ch := make(chan error)
+	go func() {
+		ch <- s.ServeConn(rw)
+	}()
+
+	select {
+	case err := <-ch:
+		if err != nil {
+			t.Fatalf("Unexpected error from serveConn: %s", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("timeout")
+	}
+
+	br := bufio.NewReader(&rw.w)
+	verifyResponse(t, br, StatusOK, "text/plain", "/")
+}
+
+func TestRequestCtxIsAsteriskForm(t *testing.T) {
+	var ctx RequestCtx
+	ctx.Request.Header.SetMethod("OPTIONS")
+	ctx.Request.SetRequestURI("*")
+	if !ctx.IsAsteriskForm() {
+		t.Fatalf("Expecting asterisk-form request")
+	}
+	if string(ctx.Path()) != "/" {
+		t.Fatalf("Unexpected path %q. Expecting %q", ctx.Path(), "/")
+	}
+	ctx.Request.Header.SetMethod("GET")
+	if ctx.IsAsteriskForm() {
+		t.Fatalf("Unexpected asterisk-form for GET request")
+	}
+}
+
 func TestServerConnError(t *testing.T) {
 	s := &Server{
 		Handler: func(ctx *RequestCtx) {
@@ -2467,3 +3833,106 @@ func (rw *readWriter) SetReadDeadline(t time.Time) error {
 func (rw *readWriter) SetWriteDeadline(t time.Time) error {
 	return nil
 }
+
+func TestServerMaxBufferedStreamBodySize(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			body := "hello"
+			if string(ctx.Path()) == "/large" {
+				body = strings.Repeat("x", 20)
+			}
+			ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+				for i := 0; i < len(body); i++ {
+					w.WriteByte(body[i])
+					w.Flush()
+				}
+			})
+		},
+		MaxBufferedStreamBodySize: 10,
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln)
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	var resp Response
+
+	if _, err = c.Write([]byte("GET /small HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err = resp.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.Header.ContentLength() != 5 || string(resp.Body()) != "hello" {
+		t.Fatalf("unexpected response: Content-Length=%d, body=%q. Expecting 5, %q",
+			resp.Header.ContentLength(), resp.Body(), "hello")
+	}
+
+	if _, err = c.Write([]byte("GET /large HTTP/1.1\r\nHost: aa\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var h ResponseHeader
+	if err = h.Read(br); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// The body exceeding MaxBufferedStreamBodySize must be sent with chunked encoding.
+	if h.ContentLength() != -1 {
+		t.Fatalf("unexpected Content-Length: %d. Expecting -1", h.ContentLength())
+	}
+	body, err := readBodyChunked(br, 0, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := strings.Repeat("x", 20); string(body) != expected {
+		t.Fatalf("unexpected body %q. Expecting %q", body, expected)
+	}
+}
+
+func TestServerMaxInFlightResponseBytes(t *testing.T) {
+	var exceeded []bool
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			exceeded = append(exceeded, ctx.InFlightResponseBytesExceeded())
+			ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+				w.WriteString("hello")
+			})
+		},
+		MaxBufferedStreamBodySize: 10,
+		MaxInFlightResponseBytes:  100,
+	}
+
+	serve := func() string {
+		rw := &readWriter{}
+		rw.r.WriteString("GET / HTTP/1.1\r\nHost: aa\r\n\r\n")
+		if err := s.ServeConn(rw); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return rw.w.String()
+	}
+
+	// The streamed body is buffered while the limit isn't exceeded.
+	if resp := serve(); !strings.Contains(resp, "Content-Length: 5\r\n") {
+		t.Fatalf("missing Content-Length in the response %q", resp)
+	}
+	if n := atomic.LoadInt64(&s.inFlightResponseBytes); n != 0 {
+		t.Fatalf("unexpected in-flight response bytes: %d. Expecting 0", n)
+	}
+
+	// The streamed body is sent with chunked encoding if the limit is exceeded.
+	atomic.StoreInt64(&s.inFlightResponseBytes, 1000)
+	if resp := serve(); !strings.Contains(resp, "Transfer-Encoding: chunked\r\n") {
+		t.Fatalf("missing chunked Transfer-Encoding in the response %q", resp)
+	}
+	if n := atomic.LoadInt64(&s.inFlightResponseBytes); n != 1000 {
+		t.Fatalf("unexpected in-flight response bytes: %d. Expecting 1000", n)
+	}
+
+	if fmt.Sprint(exceeded) != "[false true]" {
+		t.Fatalf("unexpected InFlightResponseBytesExceeded results: %v. Expecting [false true]", exceeded)
+	}
+}
@@ -0,0 +1,88 @@
+package fasthttp
+
+import (
+	"bufio"
+	"io"
+	"time"
+)
+
+// SizeStats contains sizes and duration of a single request.
+//
+// It is passed to Server.SizeStatsHandler for each served request
+// and to HostClient.SizeStatsHandler for each request attempt, so size
+// and latency histograms may be built without wrapping request handlers
+// and re-measuring bodies.
+type SizeStats struct {
+	// RequestSize is the size of the request header and body in bytes.
+	//
+	// Server accounts chunked request bodies after dechunking.
+	RequestSize int
+
+	// ResponseSize is the size of the response header and body in bytes.
+	//
+	// Clients account chunked response bodies after dechunking
+	// and do not account streamed response bodies.
+	ResponseSize int
+
+	// Duration is the time spent on handling the request and writing
+	// the response by Server, or the duration of the request attempt
+	// for clients.
+	Duration time.Duration
+
+	// StatusCode is the response status code.
+	StatusCode int
+
+	// Label is the label set via RequestCtx.SetStatsLabel for Server
+	// and HostClient.Addr for clients.
+	Label string
+}
+
+// SizeStatsHandler receives stats for each request.
+//
+// SizeStatsHandler is called synchronously by the goroutine serving
+// the request, so it must return quickly.
+type SizeStatsHandler func(stats SizeStats)
+
+// sizeCounter counts bytes written to w.
+type sizeCounter struct {
+	w io.Writer
+	n int
+}
+
+func (sc *sizeCounter) Write(p []byte) (int, error) {
+	n, err := sc.w.Write(p)
+	sc.n += n
+	return n, err
+}
+
+// startCountWrites redirects bw writes to w via sc, so the size
+// of the data written to bw afterwards may be obtained via countedSize.
+func startCountWrites(bw *bufio.Writer, w io.Writer, sc *sizeCounter) *bufio.Writer {
+	// Flush previously buffered data, so it isn't counted.
+	// Write errors are returned by the subsequent writes.
+	bw.Flush()
+	sc.w = w
+	sc.n = 0
+	bw.Reset(sc)
+	return bw
+}
+
+// countedSize returns the number of bytes written to bw
+// since startCountWrites call.
+func countedSize(bw *bufio.Writer, sc *sizeCounter) int {
+	return sc.n + bw.Buffered()
+}
+
+// requestReadSize returns the size of the request read from the wire.
+func requestReadSize(req *Request) int {
+	return req.Header.readSize + len(req.bodyBytes())
+}
+
+// statsLabel returns the label set via ctx.SetStatsLabel.
+func (s *Server) statsLabel(ctx *RequestCtx) string {
+	n := ctx.statsLabel
+	if n <= 0 {
+		return ""
+	}
+	return s.getStatusStats().labels[n-1]
+}
@@ -0,0 +1,82 @@
+package fasthttp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func TestSizeStatsHandler(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	serverStats := make(chan SizeStats, 10)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetStatsLabel("api")
+			ctx.SetStatusCode(StatusCreated)
+			ctx.SetBodyString("response body")
+		},
+		StatsLabels: []string{"api"},
+		SizeStatsHandler: func(stats SizeStats) {
+			serverStats <- stats
+		},
+	}
+	go s.Serve(ln)
+
+	var clientStats []SizeStats
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		SizeStatsHandler: func(stats SizeStats) {
+			clientStats = append(clientStats, stats)
+		},
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://foobar/api")
+	req.Header.SetMethod("POST")
+	req.SetBodyString("request body")
+
+	for i := 0; i < 2; i++ {
+		if err := c.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(clientStats) != i+1 {
+			t.Fatalf("unexpected number of client stats: %d. Expecting %d", len(clientStats), i+1)
+		}
+		cs := clientStats[i]
+		var ss SizeStats
+		select {
+		case ss = <-serverStats:
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+
+		if ss.StatusCode != StatusCreated || cs.StatusCode != StatusCreated {
+			t.Fatalf("unexpected status codes: %d, %d. Expecting %d", ss.StatusCode, cs.StatusCode, StatusCreated)
+		}
+		if ss.Label != "api" {
+			t.Fatalf("unexpected server label %q. Expecting %q", ss.Label, "api")
+		}
+		if cs.Label != "foobar" {
+			t.Fatalf("unexpected client label %q. Expecting %q", cs.Label, "foobar")
+		}
+		if ss.RequestSize != cs.RequestSize || ss.RequestSize <= len("request body") {
+			t.Fatalf("unexpected request sizes: server %d, client %d", ss.RequestSize, cs.RequestSize)
+		}
+		if ss.ResponseSize != cs.ResponseSize || ss.ResponseSize <= len("response body") {
+			t.Fatalf("unexpected response sizes: server %d, client %d", ss.ResponseSize, cs.ResponseSize)
+		}
+		if ss.Duration < 0 || cs.Duration <= 0 {
+			t.Fatalf("unexpected durations: server %s, client %s", ss.Duration, cs.Duration)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package fasthttp
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync/atomic"
+)
+
+// These variables must be 64-bit aligned on 32-bit architectures,
+// so they are declared as separate package-level variables.
+var (
+	maxOpenSockets         int64
+	openSockets            int64
+	socketBudgetRejections uint64
+)
+
+// ErrTooManyOpenSockets is returned by clients when the number of open
+// sockets reaches the limit set via SetMaxOpenSockets.
+var ErrTooManyOpenSockets = errors.New("the number of open sockets reached the limit set via SetMaxOpenSockets")
+
+// SetMaxOpenSockets sets the maximum number of sockets, which may be open
+// simultaneously by all the Servers and clients in the process.
+//
+// Clients return ErrTooManyOpenSockets instead of dialing new connections
+// and Servers reject new connections with 503 Service Unavailable
+// when the limit is reached. Set the limit below the open files limit
+// (see `ulimit -n`), so the process keeps free file descriptors for
+// serving the already established connections and for opening files
+// instead of failing with EMFILE errors.
+//
+// Only sockets opened after the limit is set are tracked.
+// The limit is disabled if n isn't positive.
+//
+// By default the number of open sockets is unlimited.
+func SetMaxOpenSockets(n int) {
+	atomic.StoreInt64(&maxOpenSockets, int64(n))
+}
+
+// OpenSockets returns the number of open sockets tracked by the limit
+// set via SetMaxOpenSockets.
+func OpenSockets() int {
+	return int(atomic.LoadInt64(&openSockets))
+}
+
+// SocketBudgetRejections returns the number of dials and accepted
+// connections rejected because of the limit set via SetMaxOpenSockets.
+func SocketBudgetRejections() uint64 {
+	return atomic.LoadUint64(&socketBudgetRejections)
+}
+
+// reserveSocket reserves a socket in the budget set via SetMaxOpenSockets.
+//
+// ok is false if the budget is exhausted. tracked is true if the socket
+// has been reserved, so it must be released via releaseSocket or
+// by closing the connection wrapped into budgetConn.
+func reserveSocket() (tracked, ok bool) {
+	limit := atomic.LoadInt64(&maxOpenSockets)
+	if limit <= 0 {
+		return false, true
+	}
+	if atomic.AddInt64(&openSockets, 1) > limit {
+		atomic.AddInt64(&openSockets, -1)
+		atomic.AddUint64(&socketBudgetRejections, 1)
+		return false, false
+	}
+	return true, true
+}
+
+func releaseSocket() {
+	atomic.AddInt64(&openSockets, -1)
+}
+
+// budgetConn releases the socket reserved via reserveSocket on Close.
+type budgetConn struct {
+	net.Conn
+
+	closed uint32
+}
+
+func (c *budgetConn) Close() error {
+	if atomic.CompareAndSwapUint32(&c.closed, 0, 1) {
+		releaseSocket()
+	}
+	return c.Conn.Close()
+}
+
+// budgetTLSConn is budgetConn for TLS connections, so RequestCtx.IsTLS
+// and RequestCtx.TLSConnectionState work for them.
+type budgetTLSConn struct {
+	budgetConn
+}
+
+func (c *budgetTLSConn) ConnectionState() tls.ConnectionState {
+	return c.Conn.(connTLSer).ConnectionState()
+}
+
+// wrapBudgetConn wraps c, so the socket reserved via reserveSocket
+// is released when c is closed.
+func wrapBudgetConn(c net.Conn) net.Conn {
+	if _, ok := c.(connTLSer); ok {
+		return &budgetTLSConn{
+			budgetConn: budgetConn{
+				Conn: c,
+			},
+		}
+	}
+	return &budgetConn{
+		Conn: c,
+	}
+}
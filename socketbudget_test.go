@@ -0,0 +1,91 @@
+package fasthttp
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func TestSetMaxOpenSockets(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetBodyString("ok")
+		},
+		Logger: &customLogger{},
+	}
+	go s.Serve(ln)
+
+	prevMaxOpenSockets := int(atomic.LoadInt64(&maxOpenSockets))
+	defer SetMaxOpenSockets(prevMaxOpenSockets)
+
+	SetMaxOpenSockets(1 << 30)
+	openSocketsBase := OpenSockets()
+	rejections := SocketBudgetRejections()
+
+	dial := func(addr string) (net.Conn, error) {
+		return ln.Dial()
+	}
+	c1 := &HostClient{
+		Addr:                "foobar",
+		Dial:                dial,
+		MaxIdleConnDuration: 200 * time.Millisecond,
+	}
+	statusCode, body, err := c1.Get(nil, "http://foobar/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if statusCode != StatusOK || string(body) != "ok" {
+		t.Fatalf("unexpected response: %d %q", statusCode, body)
+	}
+	// The client and the server connections are tracked.
+	if n := OpenSockets() - openSocketsBase; n != 2 {
+		t.Fatalf("unexpected number of open sockets: %d. Expecting 2", n)
+	}
+
+	SetMaxOpenSockets(OpenSockets())
+	c2 := &HostClient{
+		Addr: "foobar",
+		Dial: dial,
+	}
+	if _, _, err = c2.Get(nil, "http://foobar/"); err != ErrTooManyOpenSockets {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTooManyOpenSockets)
+	}
+	if n := SocketBudgetRejections() - rejections; n != 1 {
+		t.Fatalf("unexpected number of rejections: %d. Expecting 1", n)
+	}
+
+	// Untracked client connections are rejected by the server.
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	var resp Response
+	if err = resp.Read(bufio.NewReader(conn)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if resp.StatusCode() != StatusServiceUnavailable {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusServiceUnavailable)
+	}
+
+	// Closing the idle connection releases both sockets.
+	openSocketsBase = OpenSockets()
+	SetMaxOpenSockets(1 << 30)
+	deadline := time.Now().Add(5 * time.Second)
+	for OpenSockets() > openSocketsBase-2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected number of open sockets: %d. Expecting %d", OpenSockets(), openSocketsBase-2)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := OpenSockets(); n != openSocketsBase-2 {
+		t.Fatalf("unexpected number of open sockets: %d. Expecting %d", n, openSocketsBase-2)
+	}
+}
@@ -0,0 +1,169 @@
+package fasthttp
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// ConnControlFunc is called with the raw network connection
+// for setting socket options such as TCP_NODELAY, TCP_QUICKACK or SO_SNDBUF.
+//
+// Use syscall.RawConn.Control for accessing the underlying file descriptor.
+type ConnControlFunc func(c syscall.RawConn) error
+
+// controlConn calls f with the raw connection underlying c.
+//
+// Connections without the underlying raw connection such as in-memory
+// connections are skipped.
+func controlConn(c net.Conn, f ConnControlFunc) error {
+	if f == nil {
+		return nil
+	}
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return f(rc)
+}
+
+// limitWriteStall makes sure data written to c doesn't remain unacknowledged
+// for more than timeout.
+//
+// TCP_USER_TIMEOUT socket option is used if it is supported for c.
+// Otherwise c is wrapped into a connection, which sets write deadline
+// before each write.
+func limitWriteStall(c net.Conn, timeout time.Duration) net.Conn {
+	rawConn := c
+	if pic, ok := c.(*perIPConn); ok {
+		rawConn = pic.Conn
+	}
+	switch bc := rawConn.(type) {
+	case *budgetConn:
+		rawConn = bc.Conn
+	case *budgetTLSConn:
+		rawConn = bc.Conn
+	}
+	if setTCPUserTimeout(rawConn, timeout) {
+		return c
+	}
+	return &writeStallConn{
+		Conn:    c,
+		timeout: timeout,
+	}
+}
+
+// writeStallConn fails writes blocked for more than timeout.
+type writeStallConn struct {
+	net.Conn
+
+	timeout time.Duration
+
+	// deadline is the write deadline set via SetDeadline
+	// or SetWriteDeadline.
+	deadline time.Time
+}
+
+func (c *writeStallConn) Write(p []byte) (int, error) {
+	deadline := time.Now().Add(c.timeout)
+	if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+		deadline = c.deadline
+	}
+	if err := c.Conn.SetWriteDeadline(deadline); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *writeStallConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *writeStallConn) SetWriteDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// defaultMinWriteRateWindow is the default value
+// for Server.MinWriteRateWindow.
+const defaultMinWriteRateWindow = 10 * time.Second
+
+// minWriteRateConn fails writes slower than minRate bytes per second.
+//
+// Data is written in chunks of minRate*window bytes, and each chunk
+// must be written during window.
+type minWriteRateConn struct {
+	net.Conn
+
+	chunkSize int
+	window    time.Duration
+
+	// deadline is the write deadline set via SetDeadline
+	// or SetWriteDeadline.
+	deadline time.Time
+}
+
+func limitWriteRate(c net.Conn, minRate int, window time.Duration) net.Conn {
+	if window <= 0 {
+		window = defaultMinWriteRateWindow
+	}
+	chunkSize := int(float64(minRate) * window.Seconds())
+	if chunkSize <= 0 {
+		chunkSize = 1
+	}
+	return &minWriteRateConn{
+		Conn:      c,
+		chunkSize: chunkSize,
+		window:    window,
+	}
+}
+
+func (c *minWriteRateConn) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > c.chunkSize {
+			chunk = chunk[:c.chunkSize]
+		}
+		deadline := time.Now().Add(c.window)
+		if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+			deadline = c.deadline
+		}
+		if err := c.Conn.SetWriteDeadline(deadline); err != nil {
+			return n, err
+		}
+		m, err := c.Conn.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+func (c *minWriteRateConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *minWriteRateConn) SetWriteDeadline(t time.Time) error {
+	c.deadline = t
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// unwrapWriteLimits returns c without wrappers limiting writes.
+func unwrapWriteLimits(c net.Conn) net.Conn {
+	if rc, ok := c.(*minWriteRateConn); ok {
+		c = rc.Conn
+	}
+	if wsc, ok := c.(*writeStallConn); ok {
+		c = wsc.Conn
+	}
+	return c
+}
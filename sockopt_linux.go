@@ -0,0 +1,35 @@
+// +build linux
+
+package fasthttp
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// tcpUserTimeout is TCP_USER_TIMEOUT socket option from linux/tcp.h.
+const tcpUserTimeout = 0x12
+
+// setTCPUserTimeout sets TCP_USER_TIMEOUT socket option on c.
+//
+// false is returned if the option cannot be set on c.
+func setTCPUserTimeout(c net.Conn, timeout time.Duration) bool {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	msecs := int(timeout / time.Millisecond)
+	if msecs <= 0 {
+		msecs = 1
+	}
+	var errSet error
+	err = rc.Control(func(fd uintptr) {
+		errSet = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, msecs)
+	})
+	return err == nil && errSet == nil
+}
@@ -0,0 +1,65 @@
+// +build linux
+
+package fasthttp
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func TestSetTCPUserTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer ln.Close()
+
+	c, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	if !setTCPUserTimeout(c, 1500*time.Millisecond) {
+		t.Fatalf("cannot set TCP_USER_TIMEOUT")
+	}
+	rc, err := c.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var n int
+	var errGet error
+	if err = rc.Control(func(fd uintptr) {
+		n, errGet = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout)
+	}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if errGet != nil {
+		t.Fatalf("unexpected error: %s", errGet)
+	}
+	if n != 1500 {
+		t.Fatalf("unexpected TCP_USER_TIMEOUT: %d. Expecting 1500", n)
+	}
+
+	if wc := limitWriteStall(c, time.Second); wc != c {
+		t.Fatalf("unexpected connection wrapping for TCP connection")
+	}
+
+	inmemoryLn := fasthttputil.NewInmemoryListener()
+	defer inmemoryLn.Close()
+	ic, err := inmemoryLn.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer ic.Close()
+	if setTCPUserTimeout(ic, time.Second) {
+		t.Fatalf("TCP_USER_TIMEOUT mustn't be set on in-memory connection")
+	}
+	if _, ok := limitWriteStall(ic, time.Second).(*writeStallConn); !ok {
+		t.Fatalf("in-memory connection must be wrapped into writeStallConn")
+	}
+}
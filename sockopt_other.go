@@ -0,0 +1,14 @@
+// +build !linux
+
+package fasthttp
+
+import (
+	"net"
+	"time"
+)
+
+// setTCPUserTimeout always returns false, since TCP_USER_TIMEOUT
+// socket option is supported only on Linux.
+func setTCPUserTimeout(c net.Conn, timeout time.Duration) bool {
+	return false
+}
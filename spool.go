@@ -0,0 +1,370 @@
+package fasthttp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Doer sends HTTP requests.
+//
+// Client, HostClient, PipelineClient and LBClient implement Doer.
+type Doer interface {
+	Do(req *Request, resp *Response) error
+}
+
+// ErrRequestSpooled is returned by RequestSpool.Do if the request
+// has been persisted for delivery in background.
+var ErrRequestSpooled = errors.New("the request has been spooled for delivery in background")
+
+// ErrSpoolFull is returned by RequestSpool.Do if the request cannot be
+// spooled, since the spool size reached RequestSpool.MaxSize.
+var ErrSpoolFull = errors.New("the request spool is full")
+
+// RequestSpool sends requests via Client and persists failed requests
+// to Dir, so they are delivered in background when the host recovers.
+//
+// Spooled requests are delivered in the original order. New requests
+// are spooled without sending while the spool isn't empty, so they
+// don't overtake previously failed requests. Spooled requests survive
+// process restarts.
+//
+// RequestSpool is useful for telemetry shippers, which mustn't lose data
+// during upstream outages. Responses for spooled requests are discarded.
+//
+// Call Start before using RequestSpool.
+type RequestSpool struct {
+	// Client used for sending requests.
+	Client Doer
+
+	// Directory for spooled requests.
+	//
+	// The directory is created if it is missing.
+	Dir string
+
+	// The maximum total size in bytes of spooled requests.
+	//
+	// ErrSpoolFull is returned if the request cannot be spooled
+	// because of the limit.
+	//
+	// By default the spool size is unlimited.
+	MaxSize int64
+
+	// The maximum number of delivery attempts for the spooled request.
+	//
+	// The request is dropped after the given number of failed attempts,
+	// so a request rejected by the host doesn't block the spool forever.
+	//
+	// By default spooled requests are retried until they are delivered.
+	MaxAttempts int
+
+	// The interval between delivery attempts for spooled requests.
+	//
+	// By default spooled requests are retried every second.
+	RetryInterval time.Duration
+
+	// Callback deciding whether the request delivery failed.
+	//
+	// By default errors and 5xx responses are failures.
+	IsFailure func(resp *Response, err error) bool
+
+	// Logger for dropped requests.
+	//
+	// By default standard logger from log package is used.
+	Logger Logger
+
+	// doLock serializes Do calls, so requests sent directly don't
+	// overtake the requests being spooled.
+	doLock sync.Mutex
+
+	lock     sync.Mutex
+	queue    []*spooledRequest
+	size     int64
+	nextID   uint64
+	stopCh   chan struct{}
+	stopWG   sync.WaitGroup
+	attempts int
+}
+
+type spooledRequest struct {
+	path string
+	size int64
+}
+
+const spooledRequestSuffix = ".req"
+
+// Start loads requests spooled by the previous runs from Dir
+// and starts delivering spooled requests in background.
+func (s *RequestSpool) Start() error {
+	if s.Client == nil {
+		return errors.New("RequestSpool.Client must be set")
+	}
+	if s.Dir == "" {
+		return errors.New("RequestSpool.Dir must be set")
+	}
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return fmt.Errorf("cannot create spool directory %q: %w", s.Dir, err)
+	}
+	fis, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return fmt.Errorf("cannot read spool directory %q: %w", s.Dir, err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopCh != nil {
+		return errors.New("RequestSpool is already started")
+	}
+	s.queue = s.queue[:0]
+	s.size = 0
+	for _, fi := range fis {
+		name := fi.Name()
+		path := filepath.Join(s.Dir, name)
+		if strings.HasSuffix(name, ".tmp") {
+			// Remove incomplete file left after a crash.
+			os.Remove(path)
+			continue
+		}
+		if !strings.HasSuffix(name, spooledRequestSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, spooledRequestSuffix), 16, 64)
+		if err != nil {
+			continue
+		}
+		if id >= s.nextID {
+			s.nextID = id + 1
+		}
+		s.queue = append(s.queue, &spooledRequest{
+			path: path,
+			size: fi.Size(),
+		})
+		s.size += fi.Size()
+	}
+	// File names are zero-padded ids, so they are sorted in spool order.
+	sort.Slice(s.queue, func(i, j int) bool {
+		return s.queue[i].path < s.queue[j].path
+	})
+
+	stopCh := make(chan struct{})
+	s.stopCh = stopCh
+	s.stopWG.Add(1)
+	go func() {
+		defer s.stopWG.Done()
+		s.run(stopCh)
+	}()
+	return nil
+}
+
+// Stop stops delivering spooled requests.
+//
+// Spooled requests are delivered after the next Start call.
+func (s *RequestSpool) Stop() {
+	s.lock.Lock()
+	stopCh := s.stopCh
+	s.stopCh = nil
+	s.lock.Unlock()
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	s.stopWG.Wait()
+}
+
+// Pending returns the number of spooled requests.
+func (s *RequestSpool) Pending() int {
+	s.lock.Lock()
+	n := len(s.queue)
+	s.lock.Unlock()
+	return n
+}
+
+// Do sends req via Client and fills resp with the response.
+//
+// req is persisted to Dir if it fails or if there are pending spooled
+// requests. ErrRequestSpooled is returned in this case and resp isn't
+// filled. Request body stream is read into memory before sending
+// the request.
+//
+// Concurrent Do calls are serialized in order to preserve the order
+// of requests.
+func (s *RequestSpool) Do(req *Request, resp *Response) error {
+	if err := req.readBodyStream(); err != nil {
+		return err
+	}
+	s.doLock.Lock()
+	defer s.doLock.Unlock()
+	if s.Pending() == 0 {
+		nilResp := resp == nil
+		if nilResp {
+			resp = AcquireResponse()
+		}
+		err := s.Client.Do(req, resp)
+		failed := s.isFailure(resp, err)
+		if nilResp {
+			ReleaseResponse(resp)
+		}
+		if !failed {
+			return err
+		}
+	}
+	if err := s.spool(req); err != nil {
+		return err
+	}
+	return ErrRequestSpooled
+}
+
+func (s *RequestSpool) isFailure(resp *Response, err error) bool {
+	if s.IsFailure != nil {
+		return s.IsFailure(resp, err)
+	}
+	return err != nil || resp.StatusCode() >= 500
+}
+
+func (s *RequestSpool) retryInterval() time.Duration {
+	if s.RetryInterval <= 0 {
+		return time.Second
+	}
+	return s.RetryInterval
+}
+
+func (s *RequestSpool) logger() Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return defaultLogger
+}
+
+// spool persists req to Dir.
+//
+// The file contains the full request uri on the first line followed
+// by the request written via Request.WriteTo.
+func (s *RequestSpool) spool(req *Request) error {
+	var bb ByteBuffer
+	bb.B = append(bb.B, req.URI().FullURI()...)
+	bb.B = append(bb.B, '\n')
+	if _, err := req.WriteTo(&bb); err != nil {
+		return fmt.Errorf("cannot serialize request: %w", err)
+	}
+	size := int64(len(bb.B))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.MaxSize > 0 && s.size+size > s.MaxSize {
+		return ErrSpoolFull
+	}
+	path := filepath.Join(s.Dir, fmt.Sprintf("%016X%s", s.nextID, spooledRequestSuffix))
+	s.nextID++
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, bb.B, 0600); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot spool request to %q: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot move spooled request from %q to %q: %w", tmpPath, path, err)
+	}
+	s.queue = append(s.queue, &spooledRequest{
+		path: path,
+		size: size,
+	})
+	s.size += size
+	return nil
+}
+
+func (s *RequestSpool) run(stopCh <-chan struct{}) {
+	t := time.NewTicker(s.retryInterval())
+	defer t.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-t.C:
+			s.deliver(stopCh)
+		}
+	}
+}
+
+// deliver sends spooled requests in order until the first failure.
+func (s *RequestSpool) deliver(stopCh <-chan struct{}) {
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	resp := AcquireResponse()
+	defer ReleaseResponse(resp)
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+
+		s.lock.Lock()
+		if len(s.queue) == 0 {
+			s.lock.Unlock()
+			return
+		}
+		sr := s.queue[0]
+		s.lock.Unlock()
+
+		req.Reset()
+		if err := readSpooledRequest(sr.path, req); err != nil {
+			s.logger().Printf("dropping spooled request %q: %s", sr.path, err)
+			s.remove(sr)
+			continue
+		}
+		err := s.Client.Do(req, resp)
+		if !s.isFailure(resp, err) {
+			s.remove(sr)
+			continue
+		}
+		s.attempts++
+		if s.MaxAttempts > 0 && s.attempts >= s.MaxAttempts {
+			if err == nil {
+				err = fmt.Errorf("unexpected status code %d", resp.StatusCode())
+			}
+			s.logger().Printf("dropping spooled request to %q after %d failed attempts: %s", req.URI().FullURI(), s.attempts, err)
+			s.remove(sr)
+			continue
+		}
+		return
+	}
+}
+
+// remove removes the first spooled request sr from the spool.
+func (s *RequestSpool) remove(sr *spooledRequest) {
+	os.Remove(sr.path)
+	s.attempts = 0
+	s.lock.Lock()
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	s.size -= sr.size
+	s.lock.Unlock()
+}
+
+func readSpooledRequest(path string, req *Request) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	uri, err := br.ReadSlice('\n')
+	if err != nil {
+		return fmt.Errorf("cannot read request uri: %w", err)
+	}
+	if err = req.Read(br); err != nil {
+		return fmt.Errorf("cannot read request: %w", err)
+	}
+	req.SetRequestURIBytes(uri[:len(uri)-1])
+	return nil
+}
@@ -0,0 +1,236 @@
+package fasthttp
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func TestRequestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttp-spool")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	var bodiesLock sync.Mutex
+	var bodies []string
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			bodiesLock.Lock()
+			bodies = append(bodies, string(ctx.PostBody()))
+			bodiesLock.Unlock()
+		},
+	}
+	go s.Serve(ln)
+
+	var healthy uint32
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			if atomic.LoadUint32(&healthy) == 0 {
+				return nil, errors.New("host is down")
+			}
+			return ln.Dial()
+		},
+	}
+	sp := &RequestSpool{
+		Client:        c,
+		Dir:           dir,
+		RetryInterval: 10 * time.Millisecond,
+		Logger:        &customLogger{},
+	}
+	if err = sp.Start(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.Header.SetMethod("POST")
+	for i := 0; i < 3; i++ {
+		req.SetRequestURI("http://foobar/")
+		req.SetBodyString(fmt.Sprintf("request %d", i))
+		if err = sp.Do(req, nil); err != ErrRequestSpooled {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, ErrRequestSpooled)
+		}
+	}
+	if n := sp.Pending(); n != 3 {
+		t.Fatalf("unexpected number of pending requests: %d. Expecting 3", n)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, fi := range fis {
+		// Spooled requests may contain credentials.
+		if perm := fi.Mode().Perm(); perm != 0600 {
+			t.Fatalf("unexpected permissions for %q: %o. Expecting %o", fi.Name(), perm, 0600)
+		}
+	}
+
+	// Spooled requests are loaded on restart.
+	sp.Stop()
+	sp = &RequestSpool{
+		Client:        c,
+		Dir:           dir,
+		RetryInterval: 10 * time.Millisecond,
+	}
+	if err = sp.Start(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer sp.Stop()
+	if n := sp.Pending(); n != 3 {
+		t.Fatalf("unexpected number of pending requests: %d. Expecting 3", n)
+	}
+
+	atomic.StoreUint32(&healthy, 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for sp.Pending() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	bodiesLock.Lock()
+	defer bodiesLock.Unlock()
+	if len(bodies) != 3 {
+		t.Fatalf("unexpected number of delivered requests: %d. Expecting 3", len(bodies))
+	}
+	for i, body := range bodies {
+		if expected := fmt.Sprintf("request %d", i); body != expected {
+			t.Fatalf("unexpected body #%d: %q. Expecting %q", i, body, expected)
+		}
+	}
+	fis, err = ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(fis) != 0 {
+		t.Fatalf("unexpected number of files in spool directory: %d. Expecting 0", len(fis))
+	}
+}
+
+func TestRequestSpoolLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttp-spool")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var dials uint32
+	sp := &RequestSpool{
+		Client: &HostClient{
+			Addr: "foobar",
+			Dial: func(addr string) (net.Conn, error) {
+				atomic.AddUint32(&dials, 1)
+				return nil, errors.New("host is down")
+			},
+		},
+		Dir:           dir,
+		MaxSize:       100,
+		MaxAttempts:   2,
+		RetryInterval: 10 * time.Millisecond,
+		Logger:        &customLogger{},
+	}
+	if err = sp.Start(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer sp.Stop()
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/")
+	if err = sp.Do(req, nil); err != ErrRequestSpooled {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrRequestSpooled)
+	}
+	req.Reset()
+	req.SetRequestURI("http://foobar/")
+	req.Header.SetMethod("POST")
+	req.SetBodyString(string(make([]byte, 100)))
+	if err = sp.Do(req, nil); err != ErrSpoolFull {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrSpoolFull)
+	}
+
+	// The request is dropped after MaxAttempts delivery attempts.
+	deadline := time.Now().Add(5 * time.Second)
+	for sp.Pending() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadUint32(&dials); n != 3 {
+		t.Fatalf("unexpected number of dials: %d. Expecting 3", n)
+	}
+}
+
+type spoolTestDoer func(req *Request, resp *Response) error
+
+func (d spoolTestDoer) Do(req *Request, resp *Response) error {
+	return d(req, resp)
+}
+
+func TestRequestSpoolConcurrentDo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasthttp-spool")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var calls uint32
+	sendingCh := make(chan struct{})
+	failCh := make(chan struct{})
+	sp := &RequestSpool{
+		Client: spoolTestDoer(func(req *Request, resp *Response) error {
+			if atomic.AddUint32(&calls, 1) == 1 {
+				close(sendingCh)
+				<-failCh
+			}
+			return errors.New("host is down")
+		}),
+		Dir:           dir,
+		RetryInterval: time.Hour,
+	}
+	if err = sp.Start(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer sp.Stop()
+
+	errCh := make(chan error, 2)
+	doRequest := func() {
+		req := AcquireRequest()
+		req.SetRequestURI("http://foobar/")
+		errCh <- sp.Do(req, nil)
+		ReleaseRequest(req)
+	}
+	go doRequest()
+	<-sendingCh
+
+	// The second request must wait for the first request and then
+	// be spooled after it instead of being sent directly.
+	go doRequest()
+	time.Sleep(50 * time.Millisecond)
+	close(failCh)
+	for i := 0; i < 2; i++ {
+		if err := <-errCh; err != ErrRequestSpooled {
+			t.Fatalf("unexpected error: %v. Expecting %v", err, ErrRequestSpooled)
+		}
+	}
+	if n := atomic.LoadUint32(&calls); n != 1 {
+		t.Fatalf("unexpected number of sent requests: %d. Expecting 1", n)
+	}
+	if n := sp.Pending(); n != 2 {
+		t.Fatalf("unexpected number of pending requests: %d. Expecting 2", n)
+	}
+}
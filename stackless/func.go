@@ -3,8 +3,57 @@ package stackless
 import (
 	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
+// SetWorkersCount sets the number of worker goroutines for each stackless
+// wrapper returned from NewFunc.
+//
+// Workers are started on the first call to the wrapper, so the setting
+// applies only to wrappers, which weren't called yet. Call SetWorkersCount
+// at program start before processing any requests.
+//
+// Smaller number of workers reduces the number of idle goroutines, while
+// bigger number of workers may improve throughput for wrappers called
+// concurrently from many goroutines.
+//
+// runtime.GOMAXPROCS(-1) workers are used by default.
+func SetWorkersCount(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt32(&workersCount, int32(n))
+}
+
+// SetQueueSizePerWorker sets the maximum number of pending calls per worker
+// for each stackless wrapper returned from NewFunc.
+//
+// The wrapper returns false if the queue is full.
+// The setting applies only to wrappers, which weren't called yet.
+//
+// 2048 pending calls per worker are allowed by default.
+func SetQueueSizePerWorker(n int) {
+	if n <= 0 {
+		n = defaultQueueSizePerWorker
+	}
+	atomic.StoreInt32(&queueSizePerWorker, int32(n))
+}
+
+const defaultQueueSizePerWorker = 2048
+
+var (
+	workersCount       int32
+	queueSizePerWorker int32 = defaultQueueSizePerWorker
+)
+
+func getWorkersCount() int {
+	n := int(atomic.LoadInt32(&workersCount))
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(-1)
+	}
+	return n
+}
+
 // NewFunc returns stackless wrapper for the function f.
 //
 // Unlike f, the returned stackless wrapper doesn't use stack space
@@ -23,9 +72,10 @@ func NewFunc(f func(ctx interface{})) func(ctx interface{}) bool {
 		panic("BUG: f cannot be nil")
 	}
 
-	funcWorkCh := make(chan *funcWork, runtime.GOMAXPROCS(-1)*2048)
+	var funcWorkCh chan *funcWork
 	onceInit := func() {
-		n := runtime.GOMAXPROCS(-1)
+		n := getWorkersCount()
+		funcWorkCh = make(chan *funcWork, n*int(atomic.LoadInt32(&queueSizePerWorker)))
 		for i := 0; i < n; i++ {
 			go funcWorker(funcWorkCh, f)
 		}
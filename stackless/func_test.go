@@ -24,6 +24,28 @@ func TestNewFuncSimple(t *testing.T) {
 	}
 }
 
+func TestNewFuncWorkersCount(t *testing.T) {
+	SetWorkersCount(1)
+	SetQueueSizePerWorker(1)
+	defer func() {
+		SetWorkersCount(0)
+		SetQueueSizePerWorker(0)
+	}()
+
+	var n uint64
+	f := NewFunc(func(ctx interface{}) {
+		atomic.AddUint64(&n, uint64(ctx.(int)))
+	})
+	for i := 0; i < 100; i++ {
+		if !f(2) {
+			t.Fatalf("f mustn't return false")
+		}
+	}
+	if n != 200 {
+		t.Fatalf("Unexpected n: %d. Expecting %d", n, 200)
+	}
+}
+
 func TestNewFuncMulti(t *testing.T) {
 	var n1, n2 uint64
 	f1 := NewFunc(func(ctx interface{}) {
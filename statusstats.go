@@ -0,0 +1,114 @@
+package fasthttp
+
+import (
+	"sync/atomic"
+)
+
+// StatusClassCounts holds the number of responses per status class.
+type StatusClassCounts struct {
+	Status1xx uint64
+	Status2xx uint64
+	Status3xx uint64
+	Status4xx uint64
+	Status5xx uint64
+}
+
+// StatusStats is a snapshot of response counters returned
+// by Server.StatusStats.
+type StatusStats struct {
+	// Total contains the number of all the responses sent by the server.
+	Total StatusClassCounts
+
+	// Labels contains the number of responses per label from Server.StatsLabels.
+	//
+	// See RequestCtx.SetStatsLabel for details.
+	Labels map[string]StatusClassCounts
+}
+
+// StatusStats returns a snapshot of response counters for the server.
+//
+// The counters are updated with atomic operations without locks,
+// so the function may be called at any time for metrics scraping.
+func (s *Server) StatusStats() StatusStats {
+	ss := s.getStatusStats()
+	st := StatusStats{
+		Total:  ss.total.snapshot(),
+		Labels: make(map[string]StatusClassCounts, len(ss.labels)),
+	}
+	for i, label := range ss.labels {
+		st.Labels[label] = ss.labelCounters[i].snapshot()
+	}
+	return st
+}
+
+// SetStatsLabel attributes the response to the given label
+// in Server.StatusStats.
+//
+// The label must be registered in Server.StatsLabels. Unknown labels
+// are ignored. The response is counted only in StatusStats.Total
+// if SetStatsLabel isn't called.
+func (ctx *RequestCtx) SetStatsLabel(label string) {
+	ss := ctx.s.getStatusStats()
+	ctx.statsLabel = ss.labelsIdx[label]
+}
+
+type serverStatusStats struct {
+	total statusCounters
+
+	labels        []string
+	labelsIdx     map[string]int
+	labelCounters []statusCounters
+}
+
+// statusCounters must be allocated separately, so its members
+// are 64-bit aligned on 32-bit architectures.
+type statusCounters [5]uint64
+
+func (sc *statusCounters) inc(statusCode int) {
+	n := statusCode/100 - 1
+	if n < 0 || n >= len(sc) {
+		return
+	}
+	atomic.AddUint64(&sc[n], 1)
+}
+
+func (sc *statusCounters) snapshot() StatusClassCounts {
+	return StatusClassCounts{
+		Status1xx: atomic.LoadUint64(&sc[0]),
+		Status2xx: atomic.LoadUint64(&sc[1]),
+		Status3xx: atomic.LoadUint64(&sc[2]),
+		Status4xx: atomic.LoadUint64(&sc[3]),
+		Status5xx: atomic.LoadUint64(&sc[4]),
+	}
+}
+
+func (s *Server) getStatusStats() *serverStatusStats {
+	s.statusStatsOnce.Do(func() {
+		ss := &serverStatusStats{
+			labelsIdx: make(map[string]int, len(s.StatsLabels)),
+		}
+		for _, label := range s.StatsLabels {
+			if _, ok := ss.labelsIdx[label]; ok {
+				continue
+			}
+			ss.labels = append(ss.labels, label)
+			// Label indexes start from 1, so zero RequestCtx.statsLabel
+			// means there is no label.
+			ss.labelsIdx[label] = len(ss.labels)
+		}
+		ss.labelCounters = make([]statusCounters, len(ss.labels))
+		s.statusStats = ss
+	})
+	return s.statusStats
+}
+
+// countResponse updates response counters for the response in ctx.
+func (s *Server) countResponse(ctx *RequestCtx) {
+	ss := s.getStatusStats()
+	statusCode := ctx.Response.StatusCode()
+	ss.total.inc(statusCode)
+	if n := ctx.statsLabel; n > 0 {
+		ss.labelCounters[n-1].inc(statusCode)
+		ctx.statsLabel = 0
+	}
+}
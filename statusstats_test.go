@@ -0,0 +1,61 @@
+package fasthttp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func TestServerStatusStats(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/api":
+				ctx.SetStatsLabel("api")
+			case "/api/missing":
+				ctx.SetStatsLabel("api")
+				ctx.NotFound()
+			case "/redirect":
+				ctx.SetStatsLabel("unknown")
+				ctx.Redirect("/api", StatusFound)
+			default:
+				ctx.Error("error", StatusInternalServerError)
+			}
+		},
+		StatsLabels: []string{"api", "static"},
+	}
+	go s.Serve(ln)
+
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	for _, path := range []string{"/api", "/api", "/api/missing", "/redirect", "/foo"} {
+		if _, _, err := c.Get(nil, "http://foobar"+path); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	st := s.StatusStats()
+	// Get follows the redirect to /api.
+	expectedTotal := StatusClassCounts{Status2xx: 3, Status3xx: 1, Status4xx: 1, Status5xx: 1}
+	if st.Total != expectedTotal {
+		t.Fatalf("unexpected total counts: %+v. Expecting %+v", st.Total, expectedTotal)
+	}
+	if len(st.Labels) != 2 {
+		t.Fatalf("unexpected number of labels: %d. Expecting 2", len(st.Labels))
+	}
+	expectedAPI := StatusClassCounts{Status2xx: 3, Status4xx: 1}
+	if st.Labels["api"] != expectedAPI {
+		t.Fatalf("unexpected counts for api label: %+v. Expecting %+v", st.Labels["api"], expectedAPI)
+	}
+	if st.Labels["static"] != (StatusClassCounts{}) {
+		t.Fatalf("unexpected counts for static label: %+v. Expecting zero counts", st.Labels["static"])
+	}
+}
@@ -25,8 +25,62 @@ type StreamWriter func(w *bufio.Writer)
 // Close must be called on the returned reader after all the required data
 // has been read. Otherwise goroutine leak may occur.
 //
+// sw is started on the first Read or Close call on the returned reader.
+// Close may be called concurrently with Read in order to interrupt it.
+//
 // See also Response.SetBodyStreamWriter.
 func NewStreamReader(sw StreamWriter) io.ReadCloser {
+	return &streamReader{
+		sw: sw,
+	}
+}
+
+type streamReader struct {
+	sw StreamWriter
+
+	lock sync.Mutex
+	r    io.ReadCloser
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	return sr.reader().Read(p)
+}
+
+func (sr *streamReader) Close() error {
+	// Start sw anyway, since the caller may expect it is called.
+	return sr.reader().Close()
+}
+
+// reader returns the reader for sr, starting sw on the first call.
+func (sr *streamReader) reader() io.ReadCloser {
+	sr.lock.Lock()
+	if sr.r == nil {
+		sr.r = startStreamWriter(sr.sw)
+	}
+	r := sr.r
+	sr.lock.Unlock()
+	return r
+}
+
+// unstartedStreamWriter returns StreamWriter for r if r is returned
+// from NewStreamReader and the StreamWriter isn't started yet.
+//
+// The caller becomes responsible for starting the returned StreamWriter.
+func unstartedStreamWriter(r io.Reader) StreamWriter {
+	sr, ok := r.(*streamReader)
+	if !ok {
+		return nil
+	}
+	sr.lock.Lock()
+	started := sr.r != nil
+	sr.lock.Unlock()
+	if started {
+		return nil
+	}
+	return sr.sw
+}
+
+func startStreamWriter(sw StreamWriter) io.ReadCloser {
 	pc := fasthttputil.NewPipeConns()
 	pw := pc.Conn1()
 	pr := pc.Conn2()
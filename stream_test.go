@@ -37,6 +37,49 @@ func TestNewStreamReader(t *testing.T) {
 	}
 }
 
+func TestStreamReaderCloseWithoutRead(t *testing.T) {
+	ch := make(chan struct{})
+	r := NewStreamReader(func(w *bufio.Writer) {
+		close(ch)
+	})
+	if err := r.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout when waiting for stream writer")
+	}
+}
+
+func TestStreamReaderConcurrentReadClose(t *testing.T) {
+	r := NewStreamReader(func(w *bufio.Writer) {
+		data := createFixedBody(4000)
+		for {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	ch := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(ioutil.Discard, r)
+		ch <- err
+	}()
+	if err := r.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout when waiting for Read to return")
+	}
+}
+
 func TestStreamReaderClose(t *testing.T) {
 	firstLine := "the first line must pass"
 	ch := make(chan error, 1)
@@ -13,20 +13,26 @@ var (
 	strSlashDotSlash    = []byte("/./")
 	strSlashDotDotSlash = []byte("/../")
 	strCRLF             = []byte("\r\n")
+	strLF               = []byte("\n")
+	strZeroChunk        = []byte("0\r\n")
 	strHTTP             = []byte("http")
 	strHTTPS            = []byte("https")
+	strUnix             = []byte("unix")
 	strHTTP11           = []byte("HTTP/1.1")
 	strColonSlashSlash  = []byte("://")
 	strColonSpace       = []byte(": ")
 	strGMT              = []byte("GMT")
+	strAsterisk         = []byte("*")
 
 	strResponseContinue = []byte("HTTP/1.1 100 Continue\r\n\r\n")
 
-	strGet    = []byte("GET")
-	strHead   = []byte("HEAD")
-	strPost   = []byte("POST")
-	strPut    = []byte("PUT")
-	strDelete = []byte("DELETE")
+	strGet     = []byte("GET")
+	strHead    = []byte("HEAD")
+	strPost    = []byte("POST")
+	strPut     = []byte("PUT")
+	strDelete  = []byte("DELETE")
+	strOptions = []byte("OPTIONS")
+	strConnect = []byte("CONNECT")
 
 	strExpect           = []byte("Expect")
 	strConnection       = []byte("Connection")
@@ -37,6 +43,7 @@ var (
 	strReferer          = []byte("Referer")
 	strServer           = []byte("Server")
 	strTransferEncoding = []byte("Transfer-Encoding")
+	strTrailer          = []byte("Trailer")
 	strContentEncoding  = []byte("Content-Encoding")
 	strAcceptEncoding   = []byte("Accept-Encoding")
 	strUserAgent        = []byte("User-Agent")
@@ -44,10 +51,24 @@ var (
 	strSetCookie        = []byte("Set-Cookie")
 	strLocation         = []byte("Location")
 	strIfModifiedSince  = []byte("If-Modified-Since")
+	strIfRange          = []byte("If-Range")
+	strETag             = []byte("Etag")
+	strWeakETagPrefix   = []byte("W/")
 	strLastModified     = []byte("Last-Modified")
 	strAcceptRanges     = []byte("Accept-Ranges")
 	strRange            = []byte("Range")
 	strContentRange     = []byte("Content-Range")
+	strAllow            = []byte("Allow")
+	strVary             = []byte("Vary")
+
+	strAuthorization      = []byte("Authorization")
+	strProxyAuthorization = []byte("Proxy-Authorization")
+
+	strSecWebSocketKey     = []byte("Sec-WebSocket-Key")
+	strSecWebSocketVersion = []byte("Sec-WebSocket-Version")
+	strSecWebSocketAccept  = []byte("Sec-WebSocket-Accept")
+
+	strClientAcceptEncoding = []byte("gzip, deflate, br, zstd")
 
 	strCookieExpires  = []byte("expires")
 	strCookieDomain   = []byte("domain")
@@ -58,9 +79,11 @@ var (
 	strClose               = []byte("close")
 	strGzip                = []byte("gzip")
 	strDeflate             = []byte("deflate")
+	strZstd                = []byte("zstd")
+	strBr                  = []byte("br")
 	strKeepAlive           = []byte("keep-alive")
-	strKeepAliveCamelCase  = []byte("Keep-Alive")
 	strUpgrade             = []byte("Upgrade")
+	strWebSocket           = []byte("websocket")
 	strChunked             = []byte("chunked")
 	strIdentity            = []byte("identity")
 	str100Continue         = []byte("100-continue")
@@ -70,4 +93,15 @@ var (
 	strBytes               = []byte("bytes")
 	strTextSlash           = []byte("text/")
 	strApplicationSlash    = []byte("application/")
+	strJSONErrorType       = []byte("application/json; charset=utf-8")
+)
+
+var (
+	strHTTP2Authority = []byte(":authority")
+	strHTTP2Method    = []byte(":method")
+	strHTTP2Path      = []byte(":path")
+	strHTTP2Scheme    = []byte(":scheme")
+	strHTTP2Status    = []byte(":status")
+	strHTTP2TE        = []byte("te")
+	strTrailers       = []byte("trailers")
 )
@@ -1,6 +1,7 @@
 package fasthttp
 
 import (
+	"context"
 	"errors"
 	"net"
 	"strconv"
@@ -115,6 +116,62 @@ func DialDualStackTimeout(addr string, timeout time.Duration) (net.Conn, error)
 	return getDialer(timeout, true)(addr)
 }
 
+// DialContext dials the given TCP addr using tcp4 until ctx is canceled.
+//
+// This function has the following additional features comparing to net.Dial:
+//
+//   * It reduces load on DNS resolver by caching resolved TCP addressed
+//     for DefaultDNSCacheDuration.
+//   * It dials all the resolved TCP addresses in round-robin manner until
+//     connection is established. This may be useful if certain addresses
+//     are temporarily unreachable.
+//   * Each address is dialed for up to timeout. DefaultDialTimeout is used
+//     if timeout isn't positive. The total dial duration may reach timeout
+//     multiplied by the number of addresses, so use ctx for limiting it.
+//   * The number of concurrent dials is limited in the same way as for Dial.
+//
+// This dialer is intended for custom code wrapping before passing
+// to Client.DialCtx or HostClient.DialCtx.
+//
+// The addr passed to the function must contain port.
+func DialContext(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	return dialerStd.dialCtx(ctx, addr, timeout)
+}
+
+// DialDualStackContext dials the given TCP addr using both tcp4 and tcp6
+// until ctx is canceled.
+//
+// Resolved addresses are dialed according to Happy Eyeballs algorithm
+// from RFC 8305: addresses of distinct families are interleaved and
+// the next address is dialed if the previous attempt doesn't complete
+// in 250ms, so unreachable ipv6 or ipv4 networks don't delay establishing
+// the connection. The first established connection is returned.
+// Each address is dialed for up to timeout. DefaultDialTimeout is used
+// if timeout isn't positive. The total dial duration may exceed timeout
+// if multiple addresses are dialed, so use ctx for limiting it.
+// The number of concurrent dials is limited in the same way as for Dial.
+//
+// The addr passed to the function must contain port.
+func DialDualStackContext(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	return dialerDualStack.dialCtx(ctx, addr, timeout)
+}
+
+// Resolver resolves host names into IP addresses.
+//
+// net.Resolver implements Resolver, so it may be used for resolving
+// host names via custom DNS servers. Resolver may be used
+// for service discovery such as Consul DNS too.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// FlushDNSCache removes TCP addresses cached by Dial* functions,
+// so host names are resolved again on the next dial.
+func FlushDNSCache() {
+	dialerStd.flushDNSCache()
+	dialerDualStack.flushDNSCache()
+}
+
 func getDialer(timeout time.Duration, dualStack bool) DialFunc {
 	if timeout <= 0 {
 		timeout = DefaultDialTimeout
@@ -152,8 +209,19 @@ var (
 type tcpDialer struct {
 	DualStack bool
 
-	tcpAddrsLock sync.Mutex
-	tcpAddrsMap  map[string]*tcpAddrEntry
+	// Resolver is used for resolving host names.
+	//
+	// net.DefaultResolver is used if Resolver is nil.
+	Resolver Resolver
+
+	// DNSCacheDuration is the duration for caching resolved TCP addresses.
+	//
+	// DefaultDNSCacheDuration is used if DNSCacheDuration isn't positive.
+	DNSCacheDuration time.Duration
+
+	tcpAddrsLock      sync.Mutex
+	tcpAddrsMap       map[string]*tcpAddrEntry
+	tcpAddrsCleanTime time.Time
 
 	concurrencyCh chan struct{}
 
@@ -162,15 +230,32 @@ type tcpDialer struct {
 
 const maxDialConcurrency = 1000
 
-func (d *tcpDialer) NewDial(timeout time.Duration) DialFunc {
+func (d *tcpDialer) init() {
 	d.once.Do(func() {
 		d.concurrencyCh = make(chan struct{}, maxDialConcurrency)
 		d.tcpAddrsMap = make(map[string]*tcpAddrEntry)
-		go d.tcpAddrsClean()
 	})
+}
+
+func (d *tcpDialer) dnsCacheDuration() time.Duration {
+	if d.DNSCacheDuration <= 0 {
+		return DefaultDNSCacheDuration
+	}
+	return d.DNSCacheDuration
+}
+
+func (d *tcpDialer) flushDNSCache() {
+	d.init()
+	d.tcpAddrsLock.Lock()
+	d.tcpAddrsMap = make(map[string]*tcpAddrEntry)
+	d.tcpAddrsLock.Unlock()
+}
+
+func (d *tcpDialer) NewDial(timeout time.Duration) DialFunc {
+	d.init()
 
 	return func(addr string) (net.Conn, error) {
-		addrs, idx, err := d.getTCPAddrs(addr)
+		addrs, idx, err := d.getTCPAddrs(context.Background(), addr)
 		if err != nil {
 			return nil, err
 		}
@@ -197,6 +282,177 @@ func (d *tcpDialer) NewDial(timeout time.Duration) DialFunc {
 	}
 }
 
+func (d *tcpDialer) dialCtx(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	d.init()
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+	addrs, idx, err := d.getTCPAddrs(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rotate addresses, so the load is spread among them.
+	n := uint32(len(addrs))
+	rotated := make([]net.TCPAddr, 0, n)
+	for i := uint32(0); i < n; i++ {
+		rotated = append(rotated, addrs[(idx+i)%n])
+	}
+
+	if d.DualStack {
+		return dialHappyEyeballs(ctx, interleaveAddrFamilies(rotated), timeout, d.concurrencyCh)
+	}
+	var conn net.Conn
+	for i := range rotated {
+		conn, err = dialTCPCtx(ctx, "tcp4", &rotated[i], timeout, d.concurrencyCh)
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return nil, err
+}
+
+// happyEyeballsDelay is the delay before dialing the next address
+// while the previous attempt is in progress.
+//
+// See https://tools.ietf.org/html/rfc8305#section-5 .
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// dialHappyEyeballs dials addrs in parallel with happyEyeballsDelay
+// between attempts and returns the first established connection.
+func dialHappyEyeballs(ctx context.Context, addrs []net.TCPAddr, timeout time.Duration, concurrencyCh chan struct{}) (net.Conn, error) {
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch := make(chan dialResult, len(addrs))
+	next := 0
+	pending := 0
+	startDial := func() {
+		addr := &addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dialTCPCtx(dialCtx, "tcp", addr, timeout, concurrencyCh)
+			ch <- dialResult{conn, err}
+		}()
+	}
+
+	startDial()
+	t := time.NewTimer(happyEyeballsDelay)
+	defer t.Stop()
+	var err error
+	for pending > 0 {
+		select {
+		case r := <-ch:
+			pending--
+			if r.err == nil {
+				if pending > 0 {
+					go closeDialResults(ch, pending)
+				}
+				return r.conn, nil
+			}
+			err = r.err
+			if next < len(addrs) && ctx.Err() == nil {
+				// Do not wait for the delay after failed attempt.
+				startDial()
+				stopTimer(t)
+				t.Reset(happyEyeballsDelay)
+			}
+		case <-t.C:
+			if next < len(addrs) && ctx.Err() == nil {
+				startDial()
+				t.Reset(happyEyeballsDelay)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return nil, err
+}
+
+// closeDialResults closes connections from the remaining n dial attempts,
+// which completed after the connection has been already established.
+func closeDialResults(ch <-chan dialResult, n int) {
+	for i := 0; i < n; i++ {
+		if r := <-ch; r.conn != nil {
+			r.conn.Close()
+		}
+	}
+}
+
+// interleaveAddrFamilies reorders addrs, so ipv6 and ipv4 addresses
+// alternate starting from the family of the first address.
+func interleaveAddrFamilies(addrs []net.TCPAddr) []net.TCPAddr {
+	if len(addrs) == 0 {
+		return addrs
+	}
+	var primary, secondary []net.TCPAddr
+	isIPv4 := addrs[0].IP.To4() != nil
+	for _, addr := range addrs {
+		if (addr.IP.To4() != nil) == isIPv4 {
+			primary = append(primary, addr)
+		} else {
+			secondary = append(secondary, addr)
+		}
+	}
+	result := make([]net.TCPAddr, 0, len(addrs))
+	for len(primary) > 0 || len(secondary) > 0 {
+		if len(primary) > 0 {
+			result = append(result, primary[0])
+			primary = primary[1:]
+		}
+		if len(secondary) > 0 {
+			result = append(result, secondary[0])
+			secondary = secondary[1:]
+		}
+	}
+	return result
+}
+
+// dialTCPCtx dials addr for up to timeout.
+//
+// The number of concurrent dials is limited by concurrencyCh capacity.
+// The time spent waiting for the free slot is counted in timeout.
+func dialTCPCtx(ctx context.Context, network string, addr *net.TCPAddr, timeout time.Duration, concurrencyCh chan struct{}) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+	select {
+	case concurrencyCh <- struct{}{}:
+	default:
+		tc := acquireTimer(timeout)
+		var err error
+		select {
+		case concurrencyCh <- struct{}{}:
+		case <-tc.C:
+			err = ErrDialTimeout
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		releaseTimer(tc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer func() {
+		<-concurrencyCh
+	}()
+
+	d := net.Dialer{
+		Deadline: deadline,
+	}
+	conn, err := d.DialContext(ctx, network, addr.String())
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && ctx.Err() == nil {
+			return nil, ErrDialTimeout
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
 func tryDial(network string, addr *net.TCPAddr, deadline time.Time, concurrencyCh chan struct{}) (net.Conn, error) {
 	timeout := -time.Since(deadline)
 	if timeout <= 0 {
@@ -282,33 +538,36 @@ type tcpAddrEntry struct {
 // by Dial* functions.
 const DefaultDNSCacheDuration = time.Minute
 
-func (d *tcpDialer) tcpAddrsClean() {
-	expireDuration := 2 * DefaultDNSCacheDuration
-	for {
-		time.Sleep(time.Second)
-		t := time.Now()
+// tcpAddrsClean removes expired entries from d.tcpAddrsMap.
+//
+// It must be called under d.tcpAddrsLock.
+func (d *tcpDialer) tcpAddrsClean(t time.Time) {
+	if t.Sub(d.tcpAddrsCleanTime) < time.Second {
+		return
+	}
+	d.tcpAddrsCleanTime = t
 
-		d.tcpAddrsLock.Lock()
-		for k, e := range d.tcpAddrsMap {
-			if t.Sub(e.resolveTime) > expireDuration {
-				delete(d.tcpAddrsMap, k)
-			}
+	expireDuration := 2 * d.dnsCacheDuration()
+	for k, e := range d.tcpAddrsMap {
+		if t.Sub(e.resolveTime) > expireDuration {
+			delete(d.tcpAddrsMap, k)
 		}
-		d.tcpAddrsLock.Unlock()
 	}
 }
 
-func (d *tcpDialer) getTCPAddrs(addr string) ([]net.TCPAddr, uint32, error) {
+func (d *tcpDialer) getTCPAddrs(ctx context.Context, addr string) ([]net.TCPAddr, uint32, error) {
+	t := time.Now()
 	d.tcpAddrsLock.Lock()
+	d.tcpAddrsClean(t)
 	e := d.tcpAddrsMap[addr]
-	if e != nil && !e.pending && time.Since(e.resolveTime) > DefaultDNSCacheDuration {
+	if e != nil && !e.pending && t.Sub(e.resolveTime) > d.dnsCacheDuration() {
 		e.pending = true
 		e = nil
 	}
 	d.tcpAddrsLock.Unlock()
 
 	if e == nil {
-		addrs, err := resolveTCPAddrs(addr, d.DualStack)
+		addrs, err := resolveTCPAddrs(ctx, d.Resolver, addr, d.DualStack)
 		if err != nil {
 			d.tcpAddrsLock.Lock()
 			e = d.tcpAddrsMap[addr]
@@ -333,7 +592,7 @@ func (d *tcpDialer) getTCPAddrs(addr string) ([]net.TCPAddr, uint32, error) {
 	return e.addrs, idx, nil
 }
 
-func resolveTCPAddrs(addr string, dualStack bool) ([]net.TCPAddr, error) {
+func resolveTCPAddrs(ctx context.Context, resolver Resolver, addr string, dualStack bool) ([]net.TCPAddr, error) {
 	host, portS, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
@@ -343,7 +602,14 @@ func resolveTCPAddrs(addr string, dualStack bool) ([]net.TCPAddr, error) {
 		return nil, err
 	}
 
-	ips, err := net.LookupIP(host)
+	if ip := net.ParseIP(host); ip != nil {
+		// Do not pass IP addresses to custom resolvers.
+		resolver = nil
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupIPAddr(ctx, host)
 	if err != nil {
 		return nil, err
 	}
@@ -351,7 +617,7 @@ func resolveTCPAddrs(addr string, dualStack bool) ([]net.TCPAddr, error) {
 	n := len(ips)
 	addrs := make([]net.TCPAddr, 0, n)
 	for i := 0; i < n; i++ {
-		ip := ips[i]
+		ip := ips[i].IP
 		if !dualStack && ip.To4() == nil {
 			continue
 		}
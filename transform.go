@@ -0,0 +1,170 @@
+package fasthttp
+
+import (
+	"bufio"
+	"io"
+)
+
+// ResponseBodyTransformer must return io.WriteCloser, which writes
+// the transformed response body to w.
+//
+// Close is called after the whole body is written to the returned writer.
+// It must write the remaining transformed data to w, but mustn't close w.
+// The returned writer may implement Flush() error for passing flushes
+// of streamed bodies to w.
+//
+// The transformer may modify ctx.Response.Header, e.g. set
+// Content-Encoding. It may return nil if the response body mustn't be
+// transformed, e.g. if the response has unsupported Content-Type.
+type ResponseBodyTransformer func(ctx *RequestCtx, w io.Writer) io.WriteCloser
+
+// TransformResponseBodyHandler returns RequestHandler, which applies
+// transformers to the response body generated by h.
+//
+// See RequestCtx.TransformResponseBody for details.
+func TransformResponseBodyHandler(h RequestHandler, transformers ...ResponseBodyTransformer) RequestHandler {
+	return func(ctx *RequestCtx) {
+		h(ctx)
+		ctx.TransformResponseBody(transformers...)
+	}
+}
+
+// TransformResponseBody applies transformers to the response body.
+//
+// Transformers are chained, i.e. the first transformer receives
+// the original body and the output of each transformer is passed
+// to the next one, so minification must precede compression.
+// Both ordinary and streamed bodies are transformed. Streamed bodies
+// are transformed on the fly while sending them to the client,
+// so Content-Length is reset for them.
+//
+// The response is replaced with StatusInternalServerError
+// if ordinary body transformation fails.
+func (ctx *RequestCtx) TransformResponseBody(transformers ...ResponseBodyTransformer) {
+	resp := &ctx.Response
+	if resp.mustSkipBody() || ctx.Request.Header.IsHead() {
+		return
+	}
+	if resp.bodyStream != nil {
+		tw := newTransformWriter(ctx, transformers)
+		if tw == nil {
+			return
+		}
+		resp.Header.SetContentLength(-1)
+		bs := resp.bodyStream
+		if bsw := unstartedStreamWriter(bs); bsw != nil {
+			resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
+				tw.dst.w = sw
+				writeCompressedStream(tw, sw, bsw)
+				tw.Close()
+			})
+		} else {
+			resp.bodyStream = NewStreamReader(func(sw *bufio.Writer) {
+				tw.dst.w = sw
+				fw := &flushWriter{
+					wf: tw,
+					bw: sw,
+				}
+				copyZeroAlloc(fw, bs)
+				tw.Close()
+				if bsc, ok := bs.(io.Closer); ok {
+					bsc.Close()
+				}
+			})
+		}
+		return
+	}
+
+	tw := newTransformWriter(ctx, transformers)
+	if tw == nil {
+		return
+	}
+	w := responseBodyPool.Get()
+	tw.dst.w = w
+	_, err := tw.Write(resp.bodyBytes())
+	if errc := tw.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		responseBodyPool.Put(w)
+		ctx.Logger().Printf("cannot transform response body: %s", err)
+		ctx.Error("Internal Server Error", StatusInternalServerError)
+		return
+	}
+
+	// Hack: swap resp.body with w.
+	if resp.body != nil {
+		responseBodyPool.Put(resp.body)
+	}
+	resp.body = w
+}
+
+// transformWriter writes data via a chain of transforming writers.
+type transformWriter struct {
+	// ws[0] receives the original data.
+	ws  []io.WriteCloser
+	dst *lazyWriter
+}
+
+// lazyWriter allows setting the destination writer after the chain
+// of transforming writers is created.
+type lazyWriter struct {
+	w io.Writer
+}
+
+func (w *lazyWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func newTransformWriter(ctx *RequestCtx, transformers []ResponseBodyTransformer) *transformWriter {
+	dst := &lazyWriter{}
+	var ws []io.WriteCloser
+	var w io.Writer = dst
+	for i := len(transformers) - 1; i >= 0; i-- {
+		tw := transformers[i](ctx, w)
+		if tw == nil {
+			continue
+		}
+		ws = append(ws, tw)
+		w = tw
+	}
+	if len(ws) == 0 {
+		return nil
+	}
+	// Reverse ws, so ws[0] receives the original data.
+	for i, j := 0, len(ws)-1; i < j; i, j = i+1, j-1 {
+		ws[i], ws[j] = ws[j], ws[i]
+	}
+	return &transformWriter{
+		ws:  ws,
+		dst: dst,
+	}
+}
+
+func (tw *transformWriter) Write(p []byte) (int, error) {
+	return tw.ws[0].Write(p)
+}
+
+// Flush flushes all the writers implementing Flush() error.
+func (tw *transformWriter) Flush() error {
+	for _, w := range tw.ws {
+		if wf, ok := w.(writeFlusher); ok {
+			if err := wf.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes all the writers starting from ws[0], so the remaining
+// data is propagated down the chain.
+func (tw *transformWriter) Close() error {
+	var firstErr error
+	for _, w := range tw.ws {
+		if err := w.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,131 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type upperWriter struct {
+	w io.Writer
+}
+
+func (w *upperWriter) Write(p []byte) (int, error) {
+	return w.w.Write(bytes.ToUpper(p))
+}
+
+func (w *upperWriter) Close() error {
+	return nil
+}
+
+func upperTransformer(ctx *RequestCtx, w io.Writer) io.WriteCloser {
+	if !bytes.HasPrefix(ctx.Response.Header.ContentType(), []byte("text/")) {
+		return nil
+	}
+	return &upperWriter{w: w}
+}
+
+func gzipTransformer(ctx *RequestCtx, w io.Writer) io.WriteCloser {
+	ctx.Response.Header.Set("Content-Encoding", "gzip")
+	return gzip.NewWriter(w)
+}
+
+func testTransformResponseBody(t *testing.T, h RequestHandler, expectedContentEncoding, expectedBody string) {
+	t.Helper()
+	var ctx RequestCtx
+	TransformResponseBodyHandler(h, upperTransformer, gzipTransformer)(&ctx)
+
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	if err := ctx.Response.Write(bw); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	bw.Flush()
+	var resp Response
+	if err := resp.Read(bufio.NewReader(&buf)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ce := string(resp.Header.Peek("Content-Encoding"))
+	if ce != expectedContentEncoding {
+		t.Fatalf("unexpected Content-Encoding: %q. Expecting %q", ce, expectedContentEncoding)
+	}
+	body, err := resp.BodyGunzip()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(body) != expectedBody {
+		t.Fatalf("unexpected body: %q. Expecting %q", body, expectedBody)
+	}
+}
+
+func TestTransformResponseBody(t *testing.T) {
+	testTransformResponseBody(t, func(ctx *RequestCtx) {
+		ctx.SetContentType("text/plain")
+		ctx.SetBodyString("hello, world")
+	}, "gzip", "HELLO, WORLD")
+
+	testTransformResponseBody(t, func(ctx *RequestCtx) {
+		ctx.SetContentType("application/json")
+		ctx.SetBodyString("hello, world")
+	}, "gzip", "hello, world")
+
+	testTransformResponseBody(t, func(ctx *RequestCtx) {
+		ctx.SetContentType("text/plain")
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			w.WriteString("foo")
+			w.Flush()
+			w.WriteString("bar")
+		})
+	}, "gzip", "FOOBAR")
+
+	testTransformResponseBody(t, func(ctx *RequestCtx) {
+		ctx.SetContentType("text/plain")
+		ctx.SetBodyStream(strings.NewReader("foobar"), -1)
+	}, "gzip", "FOOBAR")
+}
+
+func TestTransformResponseBodySkip(t *testing.T) {
+	var ctx RequestCtx
+	ctx.Request.Header.SetMethod("HEAD")
+	ctx.SetBodyString("foobar")
+	ctx.TransformResponseBody(gzipTransformer)
+	if len(ctx.Response.Header.Peek("Content-Encoding")) > 0 {
+		t.Fatalf("unexpected Content-Encoding for HEAD response")
+	}
+
+	ctx.Request.Header.SetMethod("GET")
+	ctx.SetContentType("image/png")
+	ctx.TransformResponseBody(upperTransformer)
+	if string(ctx.Response.Body()) != "foobar" {
+		t.Fatalf("unexpected body: %q. Expecting %q", ctx.Response.Body(), "foobar")
+	}
+}
+
+type errorWriteCloser struct{}
+
+func (errorWriteCloser) Write(p []byte) (int, error) {
+	return 0, errors.New("transform error")
+}
+
+func (errorWriteCloser) Close() error {
+	return nil
+}
+
+func TestTransformResponseBodyError(t *testing.T) {
+	var ctx RequestCtx
+	ctx.s = &Server{Logger: &customLogger{}}
+	ctx.SetBodyString("foobar")
+	ctx.TransformResponseBody(func(ctx *RequestCtx, w io.Writer) io.WriteCloser {
+		return errorWriteCloser{}
+	})
+	if ctx.Response.StatusCode() != StatusInternalServerError {
+		t.Fatalf("unexpected status code: %d. Expecting %d", ctx.Response.StatusCode(), StatusInternalServerError)
+	}
+	if strings.Contains(string(ctx.Response.Body()), "foobar") {
+		t.Fatalf("unexpected body: %q", ctx.Response.Body())
+	}
+}
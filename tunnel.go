@@ -0,0 +1,81 @@
+package fasthttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+var errTunnelRejected = errors.New("proxy rejected CONNECT request")
+
+// DialTunnel establishes a tunnel to addr via CONNECT request sent
+// to the HTTP proxy at Addr and returns the raw tunneled connection.
+//
+// The tunnel may carry arbitrary protocols such as database or SSH
+// connections. The connection to the proxy is established in the same way
+// as connections for requests, so Dial, DialCtx, DialTimeout, IsTLS,
+// TLSConfig and other dial settings are applied. The connection doesn't
+// belong to the HostClient connection pool and isn't limited by MaxConns.
+// Close must be called on the returned connection after it is no longer
+// needed.
+//
+// Additional CONNECT request headers such as Proxy-Authorization may be
+// set in req. req may be nil. The proxy response header is stored to resp
+// if resp isn't nil. The tunnel establishment is aborted when ctx is
+// canceled or ReadTimeout elapses. ctx isn't used afterwards.
+func (c *HostClient) DialTunnel(ctx context.Context, addr string, req *Request, resp *Response) (net.Conn, error) {
+	if req == nil {
+		req = AcquireRequest()
+		defer ReleaseRequest(req)
+	}
+	if resp == nil {
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+	req.Header.SetMethodBytes(strConnect)
+	req.Header.SetRequestURI(addr)
+	req.Header.SetHost(addr)
+	if len(req.Header.UserAgent()) == 0 {
+		req.Header.SetUserAgentBytes(c.getClientName())
+	}
+
+	conn, _, err := c.dialHostHardCtx(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	br, err := c.upgradeConn(ctx, conn, req, resp)
+	if err == nil && (resp.StatusCode() < 200 || resp.StatusCode() > 299) {
+		err = fmt.Errorf("%w: cannot connect to %q: status code %d", errTunnelRejected, addr, resp.StatusCode())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if br.Buffered() > 0 {
+		// The data sent by addr right after the tunnel establishment
+		// is already read into br.
+		conn = &tunnelConn{
+			Conn: conn,
+			br:   br,
+		}
+	}
+	return conn, nil
+}
+
+// tunnelConn reads data buffered by br before reading from Conn.
+type tunnelConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	if c.br != nil {
+		if c.br.Buffered() > 0 {
+			return c.br.Read(p)
+		}
+		c.br = nil
+	}
+	return c.Conn.Read(p)
+}
@@ -0,0 +1,99 @@
+package fasthttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+// startTunnelProxy starts HTTP proxy, which requires the given
+// Proxy-Authorization header, sends banner to CONNECT tunnels
+// to db:5432 and echoes the data sent to the tunnels afterwards.
+func startTunnelProxy(t *testing.T, auth string) *fasthttputil.InmemoryListener {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				var req Request
+				if err := req.Read(br); err != nil {
+					return
+				}
+				if string(req.Header.Method()) != "CONNECT" || string(req.Header.RequestURI()) != "db:5432" {
+					conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+					return
+				}
+				if string(req.Header.Peek("Proxy-Authorization")) != auth {
+					conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n"))
+					return
+				}
+				// The banner is sent together with the response header.
+				conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nbanner"))
+				io.Copy(conn, br)
+			}()
+		}
+	}()
+	return ln
+}
+
+func TestHostClientDialTunnel(t *testing.T) {
+	ln := startTunnelProxy(t, "Basic Zm9vOmJhcg==")
+	defer ln.Close()
+
+	c := &HostClient{
+		Addr: "proxy",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	var resp Response
+	conn, err := c.DialTunnel(context.Background(), "db:5432", req, &resp)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+
+	buf := make([]byte, 6)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(buf) != "banner" {
+		t.Fatalf("unexpected data read from the tunnel: %q. Expecting %q", buf, "banner")
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	buf = buf[:4]
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("unexpected data read from the tunnel: %q. Expecting %q", buf, "ping")
+	}
+
+	// invalid credentials
+	_, err = c.DialTunnel(context.Background(), "db:5432", nil, &resp)
+	if !errors.Is(err, errTunnelRejected) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errTunnelRejected)
+	}
+	if resp.StatusCode() != StatusProxyAuthRequired {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusProxyAuthRequired)
+	}
+}
@@ -51,6 +51,10 @@ type URI struct {
 	fullURI    []byte
 	requestURI []byte
 
+	// injectionPolicy is the header injection policy of the request
+	// the uri belongs to.
+	injectionPolicy HeaderInjectionPolicy
+
 	h *RequestHeader
 }
 
@@ -100,12 +104,14 @@ func (u *URI) QueryString() []byte {
 // SetQueryString sets URI query string.
 func (u *URI) SetQueryString(queryString string) {
 	u.queryString = append(u.queryString[:0], queryString...)
+	u.queryString = stripURICRLF(u.injectionPolicy, u.queryString)
 	u.parsedQueryArgs = false
 }
 
 // SetQueryStringBytes sets URI query string.
 func (u *URI) SetQueryStringBytes(queryString []byte) {
 	u.queryString = append(u.queryString[:0], queryString...)
+	u.queryString = stripURICRLF(u.injectionPolicy, u.queryString)
 	u.parsedQueryArgs = false
 }
 
@@ -185,6 +191,7 @@ func (u *URI) Reset() {
 	// There is no need in u.requestURI = u.requestURI[:0], since requestURI
 	// is calculated on each call to RequestURI().
 
+	u.injectionPolicy = HeaderInjectionStrip
 	u.h = nil
 }
 
@@ -203,12 +210,14 @@ func (u *URI) Host() []byte {
 // SetHost sets host for the uri.
 func (u *URI) SetHost(host string) {
 	u.host = append(u.host[:0], host...)
+	u.host = stripURICRLF(u.injectionPolicy, u.host)
 	lowercaseBytes(u.host)
 }
 
 // SetHostBytes sets host for the uri.
 func (u *URI) SetHostBytes(host []byte) {
 	u.host = append(u.host[:0], host...)
+	u.host = stripURICRLF(u.injectionPolicy, u.host)
 	lowercaseBytes(u.host)
 }
 
@@ -224,6 +233,7 @@ func (u *URI) Parse(host, uri []byte) {
 
 func (u *URI) parseQuick(uri []byte, h *RequestHeader, isTLS bool) {
 	u.parse(nil, uri, h)
+	u.injectionPolicy = h.injectionPolicy
 	if isTLS {
 		u.scheme = append(u.scheme[:0], strHTTPS...)
 	}
@@ -239,6 +249,14 @@ func (u *URI) parse(host, uri []byte, h *RequestHeader) {
 	u.host = append(u.host, host...)
 	lowercaseBytes(u.host)
 
+	if bytes.Equal(uri, strAsterisk) {
+		// Asterisk-form request target refers to the whole server,
+		// so the path is normalized to "/".
+		u.pathOriginal = append(u.pathOriginal, uri...)
+		u.path = append(u.path, '/')
+		return
+	}
+
 	b := uri
 	queryIndex := bytes.IndexByte(b, '?')
 	fragmentIndex := bytes.IndexByte(b, '#')
@@ -336,6 +354,10 @@ func normalizePath(dst, src []byte) []byte {
 
 // RequestURI returns RequestURI - i.e. URI without Scheme and Host.
 func (u *URI) RequestURI() []byte {
+	if bytes.Equal(u.pathOriginal, strAsterisk) {
+		u.requestURI = append(u.requestURI[:0], strAsterisk...)
+		return u.requestURI
+	}
 	dst := appendQuotedPath(u.requestURI[:0], u.Path())
 	if u.queryArgs.Len() > 0 {
 		dst = append(dst, '?')
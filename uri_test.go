@@ -60,6 +60,17 @@ func testURIAcquireRelease(t *testing.T) {
 	}
 }
 
+func TestURIAsteriskForm(t *testing.T) {
+	var u URI
+	u.Parse([]byte("aaa.com"), []byte("*"))
+	if string(u.Path()) != "/" {
+		t.Fatalf("Unexpected path %q. Expecting %q", u.Path(), "/")
+	}
+	if string(u.RequestURI()) != "*" {
+		t.Fatalf("Unexpected requestURI %q. Expecting %q", u.RequestURI(), "*")
+	}
+}
+
 func TestURILastPathSegment(t *testing.T) {
 	testURILastPathSegment(t, "", "")
 	testURILastPathSegment(t, "/", "")
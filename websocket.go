@@ -0,0 +1,452 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// WebSocketOpcode is WebSocket frame opcode.
+//
+// See https://tools.ietf.org/html/rfc6455#section-5.2 .
+type WebSocketOpcode byte
+
+// WebSocket frame opcodes.
+const (
+	WebSocketContinuation WebSocketOpcode = 0x0
+	WebSocketText         WebSocketOpcode = 0x1
+	WebSocketBinary       WebSocketOpcode = 0x2
+	WebSocketClose        WebSocketOpcode = 0x8
+	WebSocketPing         WebSocketOpcode = 0x9
+	WebSocketPong         WebSocketOpcode = 0xA
+)
+
+// WebSocketCloseNormal is the status code for normal WebSocket closure.
+const WebSocketCloseNormal = 1000
+
+// WebSocketCloseError is returned from WebSocketConn.ReadMessage
+// when the peer closes the connection.
+type WebSocketCloseError struct {
+	// Code is the status code sent by the peer.
+	//
+	// Code is zero if the peer didn't send status code.
+	Code int
+
+	// Reason is the close reason sent by the peer.
+	Reason string
+}
+
+func (e *WebSocketCloseError) Error() string {
+	return fmt.Sprintf("websocket connection closed by peer with code %d and reason %q", e.Code, e.Reason)
+}
+
+var (
+	errWebSocketHandshake       = errors.New("unexpected response to websocket handshake")
+	errWebSocketReservedBits    = errors.New("websocket frame has non-zero reserved bits")
+	errWebSocketControlFrame    = errors.New("websocket control frame is fragmented or too long")
+	errWebSocketReservedOpcode  = errors.New("websocket frame has reserved opcode")
+	errWebSocketUnexpectedFrame = errors.New("unexpected websocket frame")
+)
+
+// webSocketGUID is appended to Sec-WebSocket-Key for obtaining
+// Sec-WebSocket-Accept.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// defaultMaxWebSocketPayloadSize limits the size of read frame payloads
+// and messages if the limit isn't set explicitly.
+const defaultMaxWebSocketPayloadSize = 64 * 1024 * 1024
+
+// webSocketReadChunkSize is the maximum number of payload bytes allocated
+// at once while reading a frame, so the memory isn't allocated upfront
+// for big payload lengths announced by the peer.
+const webSocketReadChunkSize = 64 * 1024
+
+// WebSocketConn is WebSocket connection established via
+// HostClient.DialWebSocket or Client.DialWebSocket.
+//
+// ReadFrame and ReadMessage mustn't be called concurrently.
+// The remaining methods may be called concurrently with reads
+// and with each other.
+type WebSocketConn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	isClient bool
+
+	// maxPayloadSize limits the size of read frame payloads and messages.
+	maxPayloadSize int
+
+	writeLock   sync.Mutex
+	bw          *bufio.Writer
+	closeSent   bool
+	frameHeader [14]byte
+}
+
+func newWebSocketConn(conn net.Conn, br *bufio.Reader, isClient bool, maxPayloadSize int) *WebSocketConn {
+	if maxPayloadSize <= 0 {
+		maxPayloadSize = defaultMaxWebSocketPayloadSize
+	}
+	return &WebSocketConn{
+		conn:           conn,
+		br:             br,
+		bw:             bufio.NewWriter(conn),
+		isClient:       isClient,
+		maxPayloadSize: maxPayloadSize,
+	}
+}
+
+// NetConn returns the underlying connection.
+//
+// It may be used for setting read and write deadlines.
+func (c *WebSocketConn) NetConn() net.Conn {
+	return c.conn
+}
+
+// WriteFrame writes a single frame with the given opcode and payload.
+//
+// fin must be set for the last frame of the message. Continuation frames
+// of fragmented message must have WebSocketContinuation opcode.
+func (c *WebSocketConn) WriteFrame(op WebSocketOpcode, fin bool, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if c.closeSent {
+		return ErrConnectionClosed
+	}
+	if op == WebSocketClose {
+		c.closeSent = true
+	}
+	return c.writeFrame(op, fin, payload)
+}
+
+func (c *WebSocketConn) writeFrame(op WebSocketOpcode, fin bool, payload []byte) error {
+	h := c.frameHeader[:2]
+	h[0] = byte(op)
+	if fin {
+		h[0] |= 0x80
+	}
+	n := len(payload)
+	switch {
+	case n < 126:
+		h[1] = byte(n)
+	case n <= 0xffff:
+		h[1] = 126
+		h = h[:4]
+		binary.BigEndian.PutUint16(h[2:], uint16(n))
+	default:
+		h[1] = 127
+		h = h[:10]
+		binary.BigEndian.PutUint64(h[2:], uint64(n))
+	}
+
+	// Client frames must be masked.
+	// See https://tools.ietf.org/html/rfc6455#section-5.3 .
+	var mask []byte
+	if c.isClient {
+		h[1] |= 0x80
+		k := len(h)
+		h = h[:k+4]
+		mask = h[k:]
+		if _, err := io.ReadFull(rand.Reader, mask); err != nil {
+			return fmt.Errorf("cannot generate websocket frame mask: %w", err)
+		}
+	}
+	if _, err := c.bw.Write(h); err != nil {
+		return err
+	}
+	if mask == nil {
+		if _, err := c.bw.Write(payload); err != nil {
+			return err
+		}
+		return c.bw.Flush()
+	}
+
+	var buf [512]byte
+	for i := 0; i < len(payload); i += len(buf) {
+		chunk := buf[:copy(buf[:], payload[i:])]
+		maskWebSocketPayload(chunk, mask, i)
+		if _, err := c.bw.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return c.bw.Flush()
+}
+
+// WriteMessage writes non-fragmented message with the given opcode
+// and data.
+func (c *WebSocketConn) WriteMessage(op WebSocketOpcode, data []byte) error {
+	return c.WriteFrame(op, true, data)
+}
+
+// ReadFrame reads a single frame, appends its unmasked payload to dst
+// and returns the extended dst.
+//
+// Control frames aren't processed by ReadFrame. Use ReadMessage for
+// automatic replies to ping and close frames. Frames with reserved
+// opcodes are rejected.
+func (c *WebSocketConn) ReadFrame(dst []byte) (op WebSocketOpcode, fin bool, payload []byte, err error) {
+	var h [8]byte
+	if _, err = io.ReadFull(c.br, h[:2]); err != nil {
+		return 0, false, dst, err
+	}
+	if h[0]&0x70 != 0 {
+		return 0, false, dst, errWebSocketReservedBits
+	}
+	fin = h[0]&0x80 != 0
+	op = WebSocketOpcode(h[0] & 0x0f)
+	if op > WebSocketBinary && op < WebSocketClose || op > WebSocketPong {
+		return 0, false, dst, errWebSocketReservedOpcode
+	}
+	masked := h[1]&0x80 != 0
+	n := uint64(h[1] & 0x7f)
+	if op >= WebSocketClose && (!fin || n > 125) {
+		return 0, false, dst, errWebSocketControlFrame
+	}
+	switch n {
+	case 126:
+		if _, err = io.ReadFull(c.br, h[:2]); err != nil {
+			return 0, false, dst, err
+		}
+		n = uint64(binary.BigEndian.Uint16(h[:2]))
+	case 127:
+		if _, err = io.ReadFull(c.br, h[:8]); err != nil {
+			return 0, false, dst, err
+		}
+		n = binary.BigEndian.Uint64(h[:8])
+	}
+	if n > uint64(c.maxPayloadSize) {
+		return 0, false, dst, ErrBodyTooLarge
+	}
+	var mask []byte
+	if masked {
+		if _, err = io.ReadFull(c.br, h[:4]); err != nil {
+			return 0, false, dst, err
+		}
+		mask = h[:4]
+	}
+
+	offset := len(dst)
+	for n > 0 {
+		chunk := n
+		if chunk > webSocketReadChunkSize {
+			chunk = webSocketReadChunkSize
+		}
+		start := len(dst)
+		dst = append(dst, make([]byte, chunk)...)
+		if _, err = io.ReadFull(c.br, dst[start:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, false, dst[:offset], err
+		}
+		n -= chunk
+	}
+	if mask != nil {
+		maskWebSocketPayload(dst[offset:], mask, 0)
+	}
+	return op, fin, dst, nil
+}
+
+// ReadMessage reads the next data message, appends it to dst
+// and returns the extended dst.
+//
+// Fragmented messages are reassembled. Ping frames are answered
+// with pong frames and pong frames are skipped. *WebSocketCloseError
+// is returned if the peer closes the connection. The close frame
+// is echoed to the peer in this case.
+func (c *WebSocketConn) ReadMessage(dst []byte) (WebSocketOpcode, []byte, error) {
+	var msgOp WebSocketOpcode
+	offset := len(dst)
+	for {
+		start := len(dst)
+		op, fin, b, err := c.ReadFrame(dst)
+		if err != nil {
+			return 0, dst[:offset], err
+		}
+		switch op {
+		case WebSocketPing:
+			if err := c.WriteFrame(WebSocketPong, true, b[start:]); err != nil && err != ErrConnectionClosed {
+				return 0, dst[:offset], err
+			}
+			dst = b[:start]
+			continue
+		case WebSocketPong:
+			dst = b[:start]
+			continue
+		case WebSocketClose:
+			return 0, dst[:offset], c.handleClose(b[start:])
+		case WebSocketContinuation:
+			if msgOp == 0 {
+				return 0, dst[:offset], errWebSocketUnexpectedFrame
+			}
+		default:
+			if msgOp != 0 {
+				return 0, dst[:offset], errWebSocketUnexpectedFrame
+			}
+			msgOp = op
+		}
+		dst = b
+		if len(dst)-offset > c.maxPayloadSize {
+			return 0, dst[:offset], ErrBodyTooLarge
+		}
+		if fin {
+			return msgOp, dst, nil
+		}
+	}
+}
+
+func (c *WebSocketConn) handleClose(payload []byte) error {
+	ce := &WebSocketCloseError{}
+	if len(payload) >= 2 {
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Reason = string(payload[2:])
+	}
+	// Echo the status code. See https://tools.ietf.org/html/rfc6455#section-5.5.1 .
+	if len(payload) > 2 {
+		payload = payload[:2]
+	}
+	if err := c.WriteFrame(WebSocketClose, true, payload); err != nil && err != ErrConnectionClosed {
+		return err
+	}
+	return ce
+}
+
+// WriteClose sends close frame with the given status code and reason
+// to the peer.
+//
+// The peer is expected to reply with close frame, which is returned
+// from ReadMessage as *WebSocketCloseError. Close must be called
+// afterwards for closing the underlying connection.
+func (c *WebSocketConn) WriteClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	return c.WriteFrame(WebSocketClose, true, payload)
+}
+
+// Close sends close frame with WebSocketCloseNormal status code if it
+// hasn't been sent yet and closes the underlying connection.
+func (c *WebSocketConn) Close() error {
+	err := c.WriteClose(WebSocketCloseNormal, "")
+	err1 := c.conn.Close()
+	if err == nil || err == ErrConnectionClosed {
+		err = err1
+	}
+	return err
+}
+
+func maskWebSocketPayload(b, mask []byte, offset int) {
+	for i := range b {
+		b[i] ^= mask[(offset+i)&3]
+	}
+}
+
+// webSocketAccept returns Sec-WebSocket-Accept value for the given
+// Sec-WebSocket-Key.
+func webSocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// DialWebSocket establishes WebSocket connection to the given req uri.
+//
+// ws and wss schemes are handled as http and https. The HostClient
+// for the request host is selected in the same way as in Do.
+//
+// See HostClient.DialWebSocket for details.
+func (c *Client) DialWebSocket(ctx context.Context, req *Request, resp *Response) (*WebSocketConn, error) {
+	setWebSocketScheme(req)
+	unixSocket, err := rewriteUnixURI(req.URI())
+	if err != nil {
+		return nil, err
+	}
+	if len(unixSocket) > 0 {
+		defer restoreUnixURI(req.URI(), unixSocket)
+	}
+	hc, err := c.hostClient(req, unixSocket)
+	if err != nil {
+		return nil, err
+	}
+	return hc.DialWebSocket(ctx, req, resp)
+}
+
+func setWebSocketScheme(req *Request) {
+	uri := req.URI()
+	switch string(uri.Scheme()) {
+	case "ws":
+		uri.SetSchemeBytes(strHTTP)
+	case "wss":
+		uri.SetSchemeBytes(strHTTPS)
+	}
+}
+
+// DialWebSocket establishes WebSocket connection with the host
+// by sending upgrade request req.
+//
+// The connection is dialed via Dial or DialCtx, so it goes through
+// the configured proxies, TLS config and dial settings. The connection
+// doesn't belong to the HostClient connection pool and isn't limited
+// by MaxConns. Close must be called on the returned connection after
+// it is no longer needed.
+//
+// Upgrade headers are added to req. Additional headers such as
+// Sec-WebSocket-Protocol may be set on req before the call.
+// The handshake response header is stored to resp if resp isn't nil.
+// The handshake is aborted when ctx is canceled or ReadTimeout elapses.
+// ctx isn't used after the handshake.
+//
+// Read messages are limited by MaxResponseBodySize. Messages are limited
+// to 64MB if MaxResponseBodySize isn't set.
+func (c *HostClient) DialWebSocket(ctx context.Context, req *Request, resp *Response) (*WebSocketConn, error) {
+	if resp == nil {
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+	setWebSocketScheme(req)
+
+	var key [16]byte
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, fmt.Errorf("cannot generate websocket key: %w", err)
+	}
+	encodedKey := base64.StdEncoding.EncodeToString(key[:])
+	req.Header.SetMethodBytes(strGet)
+	req.Header.SetBytesKV(strConnection, strUpgrade)
+	req.Header.SetBytesKV(strUpgrade, strWebSocket)
+	req.Header.SetBytesK(strSecWebSocketVersion, "13")
+	req.Header.SetBytesK(strSecWebSocketKey, encodedKey)
+	if len(req.Header.UserAgent()) == 0 {
+		req.Header.SetUserAgentBytes(c.getClientName())
+	}
+
+	conn, _, err := c.dialHostHardCtx(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	br, err := c.webSocketHandshake(ctx, conn, req, resp, encodedKey)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newWebSocketConn(conn, br, true, c.MaxResponseBodySize), nil
+}
+
+func (c *HostClient) webSocketHandshake(ctx context.Context, conn net.Conn, req *Request, resp *Response, key string) (*bufio.Reader, error) {
+	br, err := c.upgradeConn(ctx, conn, req, resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != StatusSwitchingProtocols ||
+		!bytes.EqualFold(resp.Header.PeekBytes(strUpgrade), strWebSocket) ||
+		string(resp.Header.PeekBytes(strSecWebSocketAccept)) != webSocketAccept(key) {
+		return nil, fmt.Errorf("%w: status code %d", errWebSocketHandshake, resp.StatusCode())
+	}
+	return br, nil
+}
@@ -0,0 +1,224 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/VictoriaMetrics/fasthttp/fasthttputil"
+)
+
+func startWebSocketEchoServer(t *testing.T) *fasthttputil.InmemoryListener {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if !bytes.Equal(ctx.Request.Header.Peek("Upgrade"), strWebSocket) {
+				ctx.SetBodyString("not a websocket")
+				return
+			}
+			key := string(ctx.Request.Header.PeekBytes(strSecWebSocketKey))
+			ctx.SetStatusCode(StatusSwitchingProtocols)
+			ctx.Response.Header.SetBytesKV(strConnection, strUpgrade)
+			ctx.Response.Header.SetBytesKV(strUpgrade, strWebSocket)
+			ctx.Response.Header.SetBytesK(strSecWebSocketAccept, webSocketAccept(key))
+			ctx.Hijack(func(c net.Conn) {
+				wc := newWebSocketConn(c, bufio.NewReader(c), false, 0)
+				var buf []byte
+				for {
+					op, msg, err := wc.ReadMessage(buf[:0])
+					if err != nil {
+						return
+					}
+					if string(msg) == "ping me" {
+						if err := wc.WriteMessage(WebSocketPing, []byte("foo")); err != nil {
+							return
+						}
+					}
+					if err := wc.WriteMessage(op, msg); err != nil {
+						return
+					}
+					buf = msg
+				}
+			})
+		},
+	}
+	go s.Serve(ln)
+	return ln
+}
+
+func TestHostClientDialWebSocket(t *testing.T) {
+	ln := startWebSocketEchoServer(t)
+	defer ln.Close()
+
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("ws://foobar/ws")
+	var resp Response
+	wc, err := c.DialWebSocket(context.Background(), req, &resp)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer wc.Close()
+	if resp.StatusCode() != StatusSwitchingProtocols {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusSwitchingProtocols)
+	}
+
+	testMessage := func(expectedOp WebSocketOpcode, expectedMsg []byte) {
+		t.Helper()
+		op, msg, err := wc.ReadMessage(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if op != expectedOp {
+			t.Fatalf("unexpected opcode: %d. Expecting %d", op, expectedOp)
+		}
+		if !bytes.Equal(msg, expectedMsg) {
+			t.Fatalf("unexpected message with length %d. Expecting length %d", len(msg), len(expectedMsg))
+		}
+	}
+
+	if err := wc.WriteMessage(WebSocketText, []byte("hello")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testMessage(WebSocketText, []byte("hello"))
+
+	// fragmented message
+	if err := wc.WriteFrame(WebSocketText, false, []byte("foo")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := wc.WriteFrame(WebSocketContinuation, true, []byte("bar")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testMessage(WebSocketText, []byte("foobar"))
+
+	// big message with 64-bit payload length
+	big := bytes.Repeat([]byte("0123456789"), 10000)
+	if err := wc.WriteMessage(WebSocketBinary, big); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testMessage(WebSocketBinary, big)
+
+	// ping must be answered and skipped
+	if err := wc.WriteMessage(WebSocketText, []byte("ping me")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testMessage(WebSocketText, []byte("ping me"))
+
+	if err := wc.WriteClose(WebSocketCloseNormal, "bye"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	_, _, err = wc.ReadMessage(nil)
+	var ce *WebSocketCloseError
+	if !errors.As(err, &ce) {
+		t.Fatalf("unexpected error: %v. Expecting *WebSocketCloseError", err)
+	}
+	if ce.Code != WebSocketCloseNormal {
+		t.Fatalf("unexpected close code: %d. Expecting %d", ce.Code, WebSocketCloseNormal)
+	}
+	if err := wc.WriteMessage(WebSocketText, []byte("hello")); err != ErrConnectionClosed {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrConnectionClosed)
+	}
+}
+
+func TestClientDialWebSocket(t *testing.T) {
+	ln := startWebSocketEchoServer(t)
+	defer ln.Close()
+
+	c := &Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+		MaxResponseBodySize: 10,
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("ws://foobar/ws")
+	wc, err := c.DialWebSocket(context.Background(), req, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer wc.Close()
+
+	if err := wc.WriteMessage(WebSocketText, []byte("too long message")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, _, err := wc.ReadMessage(nil); err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBodyTooLarge)
+	}
+}
+
+func TestHostClientDialWebSocketHandshakeError(t *testing.T) {
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetBodyString("not a websocket")
+		},
+	}
+	go s.Serve(ln)
+
+	c := &HostClient{
+		Addr: "foobar",
+		Dial: func(addr string) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://foobar/ws")
+	var resp Response
+	_, err := c.DialWebSocket(context.Background(), req, &resp)
+	if !errors.Is(err, errWebSocketHandshake) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errWebSocketHandshake)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = c.DialWebSocket(ctx, req, nil); err != context.Canceled {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, context.Canceled)
+	}
+}
+
+func TestWebSocketConnReadFrameInvalid(t *testing.T) {
+	readFrame := func(frame string) ([]byte, error) {
+		t.Helper()
+		wc := newWebSocketConn(nil, bufio.NewReader(bytes.NewBufferString(frame)), true, 0)
+		_, _, payload, err := wc.ReadFrame(nil)
+		return payload, err
+	}
+
+	// reserved opcodes
+	for _, op := range []byte{0x3, 0x7, 0xb, 0xf} {
+		if _, err := readFrame(string([]byte{0x80 | op, 0})); err != errWebSocketReservedOpcode {
+			t.Fatalf("unexpected error for opcode %d: %v. Expecting %v", op, err, errWebSocketReservedOpcode)
+		}
+	}
+
+	// payload length exceeding the default limit
+	if _, err := readFrame("\x82\x7f\x00\x00\x00\x00\x10\x00\x00\x00"); err != ErrBodyTooLarge {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBodyTooLarge)
+	}
+
+	// truncated payload with big length mustn't be allocated upfront
+	payload, err := readFrame("\x82\x7f\x00\x00\x00\x00\x02\x00\x00\x00foo")
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.ErrUnexpectedEOF)
+	}
+	if cap(payload) > 2*webSocketReadChunkSize {
+		t.Fatalf("too big payload buffer allocated: %d bytes", cap(payload))
+	}
+}
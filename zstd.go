@@ -0,0 +1,200 @@
+package fasthttp
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ZstdDict is a zstd dictionary shared between cooperating services.
+//
+// Compression with shared dictionary substantially improves compression
+// ratio for small similar payloads such as JSON responses.
+// The same dictionary must be used for compression and decompression.
+//
+// ZstdDict may be used from concurrently running goroutines.
+type ZstdDict struct {
+	dict []byte
+
+	encoderPool    sync.Pool
+	decoder        *zstd.Decoder
+	streamDecoders chan *zstd.Decoder
+}
+
+// NewZstdDict returns ZstdDict for the given dictionary contents.
+//
+// The dictionary must be in zstd dictionary format. Such dictionaries
+// may be trained with 'zstd --train' command.
+func NewZstdDict(dict []byte) (*ZstdDict, error) {
+	d := &ZstdDict{
+		dict:           append([]byte(nil), dict...),
+		streamDecoders: make(chan *zstd.Decoder, maxPooledZstdStreamDecoders),
+	}
+	zw, err := newZstdEncoder(d)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use zstd dictionary for compression: %s", err)
+	}
+	d.encoderPool.Put(zw)
+	d.decoder, err = newZstdDecoder(d)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use zstd dictionary for decompression: %s", err)
+	}
+	return d, nil
+}
+
+func newZstdEncoder(d *ZstdDict) (*zstd.Encoder, error) {
+	if d == nil {
+		return zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	}
+	return zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderDict(d.dict))
+}
+
+func newZstdDecoder(d *ZstdDict) (*zstd.Decoder, error) {
+	if d == nil {
+		return zstd.NewReader(nil)
+	}
+	return zstd.NewReader(nil, zstd.WithDecoderDicts(d.dict))
+}
+
+func acquireZstdEncoder(d *ZstdDict) *zstd.Encoder {
+	p := &zstdEncoderPool
+	if d != nil {
+		p = &d.encoderPool
+	}
+	v := p.Get()
+	if v == nil {
+		zw, err := newZstdEncoder(d)
+		if err != nil {
+			panic(fmt.Sprintf("BUG: unexpected error when creating zstd encoder: %s", err))
+		}
+		return zw
+	}
+	return v.(*zstd.Encoder)
+}
+
+func releaseZstdEncoder(zw *zstd.Encoder, d *ZstdDict) {
+	p := &zstdEncoderPool
+	if d != nil {
+		p = &d.encoderPool
+	}
+	p.Put(zw)
+}
+
+var zstdEncoderPool sync.Pool
+
+func getZstdDecoder(d *ZstdDict) *zstd.Decoder {
+	if d != nil {
+		return d.decoder
+	}
+	zstdDecoderOnce.Do(func() {
+		zr, err := newZstdDecoder(nil)
+		if err != nil {
+			panic(fmt.Sprintf("BUG: unexpected error when creating zstd decoder: %s", err))
+		}
+		zstdDecoder = zr
+	})
+	return zstdDecoder
+}
+
+var (
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+)
+
+// AppendZstdBytes appends zstd-compressed src to dst and returns
+// the resulting dst.
+func AppendZstdBytes(dst, src []byte) []byte {
+	return AppendZstdBytesDict(dst, src, nil)
+}
+
+// AppendZstdBytesDict appends src compressed with zstd using the given
+// dictionary to dst and returns the resulting dst.
+//
+// The dictionary isn't used if d is nil.
+func AppendZstdBytesDict(dst, src []byte, d *ZstdDict) []byte {
+	zw := acquireZstdEncoder(d)
+	dst = zw.EncodeAll(src, dst)
+	releaseZstdEncoder(zw, d)
+	return dst
+}
+
+// AppendUnzstdBytes appends decompressed src to dst and returns
+// the resulting dst.
+//
+// src must be compressed with zstd without dictionary.
+func AppendUnzstdBytes(dst, src []byte) ([]byte, error) {
+	return AppendUnzstdBytesDict(dst, src, nil)
+}
+
+// AppendUnzstdBytesDict appends src decompressed with the given zstd
+// dictionary to dst and returns the resulting dst.
+//
+// The dictionary isn't used if d is nil.
+func AppendUnzstdBytesDict(dst, src []byte, d *ZstdDict) ([]byte, error) {
+	return getZstdDecoder(d).DecodeAll(src, dst)
+}
+
+// AppendUnzstdBytes appends src decompressed with the given zstd dictionary
+// to dst and returns the resulting dst.
+//
+// The dictionary isn't used if d is nil.
+//
+// *DecompressLimitError is returned if the decompressed data exceeds l.
+func (l *DecompressLimits) AppendUnzstdBytes(dst, src []byte, d *ZstdDict) ([]byte, error) {
+	// Decode the stream incrementally, so the limit is checked
+	// before the whole data is decompressed.
+	zr, err := acquireZstdStreamDecoder(d)
+	if err != nil {
+		return dst, err
+	}
+	if err = zr.Reset(&byteSliceReader{src}); err != nil {
+		releaseZstdStreamDecoder(zr, d)
+		return dst, err
+	}
+	w := l.newWriter(dst, len(src))
+	_, err = copyZeroAlloc(w, zr)
+	releaseZstdStreamDecoder(zr, d)
+	return w.bw.b, err
+}
+
+// maxPooledZstdStreamDecoders is the maximum number of idle zstd
+// stream decoders kept per dictionary.
+//
+// Stream decoders own background goroutines, so they are pooled
+// in bounded channels instead of sync.Pool, which could drop them
+// without closing.
+const maxPooledZstdStreamDecoders = 16
+
+var zstdStreamDecoders = make(chan *zstd.Decoder, maxPooledZstdStreamDecoders)
+
+func acquireZstdStreamDecoder(d *ZstdDict) (*zstd.Decoder, error) {
+	ch := zstdStreamDecoders
+	if d != nil {
+		ch = d.streamDecoders
+	}
+	select {
+	case zr := <-ch:
+		return zr, nil
+	default:
+	}
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if d != nil {
+		opts = append(opts, zstd.WithDecoderDicts(d.dict))
+	}
+	return zstd.NewReader(nil, opts...)
+}
+
+func releaseZstdStreamDecoder(zr *zstd.Decoder, d *ZstdDict) {
+	ch := zstdStreamDecoders
+	if d != nil {
+		ch = d.streamDecoders
+	}
+	// Drop the reference to the decoded data.
+	zr.Reset(nil)
+	select {
+	case ch <- zr:
+	default:
+		zr.Close()
+	}
+}
@@ -0,0 +1,112 @@
+package fasthttp
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// testZstdDictData is a zstd dictionary trained with 'zstd --train'
+// on small JSON documents.
+var testZstdDictData = []byte{
+	0x37, 0xa4, 0x30, 0xec, 0x35, 0xaa, 0x71, 0x4e, 0x1c, 0x10, 0xe0, 0x0a, 0x95, 0x0e, 0xff, 0xff,
+	0xff, 0xff, 0xc0, 0x67, 0xc0, 0xff, 0xa9, 0xe4, 0x96, 0x5b, 0xee, 0x2d, 0x13, 0x6b, 0xf7, 0x7a,
+	0x6b, 0xad, 0xb5, 0xd6, 0x02, 0x23, 0x05, 0x00, 0x00, 0x00, 0x00, 0xab, 0xcd, 0x06, 0x00, 0x00,
+	0x04, 0x40, 0x43, 0x04, 0x01, 0x40, 0x45, 0x02, 0x00, 0xc5, 0x04, 0xa2, 0x8e, 0x12, 0x04, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0, 0x94, 0x0c, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xc0, 0x1b, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc4, 0x8c, 0xed, 0x29, 0x04, 0x00, 0x43, 0x13,
+	0x4e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+	0x04, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00, 0x31, 0x37, 0x2d, 0x30, 0x31, 0x2d, 0x30, 0x38,
+	0x54, 0x31, 0x30, 0x3a, 0x30, 0x30, 0x3a, 0x30, 0x30, 0x5a, 0x22, 0x7d, 0x0a, 0x7b, 0x22, 0x69,
+	0x64, 0x22, 0x3a, 0x31, 0x37, 0x39, 0x2c, 0x22, 0x6e, 0x61, 0x6d, 0x65, 0x22, 0x3a, 0x22, 0x75,
+	0x73, 0x65, 0x72, 0x31, 0x37, 0x39, 0x22, 0x2c, 0x22, 0x65, 0x31, 0x37, 0x2d, 0x30, 0x31, 0x2d,
+	0x30, 0x39, 0x54, 0x31, 0x30, 0x3a, 0x30, 0x30, 0x3a, 0x30, 0x30, 0x5a, 0x22, 0x7d, 0x0a, 0x7b,
+	0x22, 0x69, 0x64, 0x22, 0x3a, 0x31, 0x39, 0x30, 0x2c, 0x22, 0x6e, 0x61, 0x6d, 0x65, 0x22, 0x3a,
+	0x22, 0x75, 0x73, 0x65, 0x72, 0x31, 0x39, 0x30, 0x22, 0x2c, 0x22, 0x65, 0x30, 0x31, 0x37, 0x2d,
+	0x30, 0x31, 0x2d, 0x30, 0x31, 0x54, 0x31, 0x30, 0x3a, 0x30, 0x30, 0x3a, 0x30, 0x30, 0x5a, 0x22,
+	0x7d, 0x0a, 0x7b, 0x22, 0x69, 0x64, 0x22, 0x3a, 0x35, 0x2c, 0x22, 0x6e, 0x61, 0x6d, 0x65, 0x22,
+	0x3a, 0x22, 0x75, 0x73, 0x65, 0x72, 0x35, 0x22, 0x2c, 0x22, 0x65, 0x6d, 0x61, 0x69, 0x31, 0x37,
+	0x2d, 0x30, 0x31, 0x2d, 0x30, 0x33, 0x54, 0x31, 0x30, 0x3a, 0x30, 0x30, 0x3a, 0x30, 0x30, 0x5a,
+	0x22, 0x7d, 0x0a, 0x7b, 0x22, 0x69, 0x64, 0x22, 0x3a, 0x31, 0x31, 0x37, 0x2c, 0x22, 0x6e, 0x61,
+	0x6d, 0x65, 0x22, 0x3a, 0x22, 0x75, 0x73, 0x65, 0x72, 0x31, 0x31, 0x37, 0x22, 0x2c, 0x22, 0x65,
+	0x31, 0x37, 0x2d, 0x30, 0x31, 0x2d, 0x30, 0x34, 0x54, 0x31, 0x30, 0x3a, 0x30, 0x30, 0x3a, 0x30,
+	0x30, 0x5a, 0x22, 0x7d, 0x0a, 0x7b, 0x22, 0x69, 0x64, 0x22, 0x3a, 0x32, 0x31, 0x34, 0x2c, 0x22,
+	0x6e, 0x61, 0x6d, 0x65, 0x22, 0x3a, 0x22, 0x75, 0x73, 0x65, 0x72, 0x32, 0x31, 0x34, 0x22, 0x2c,
+	0x22, 0x65, 0x65, 0x61, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x22, 0x3a, 0x22, 0x32, 0x30, 0x31,
+	0x37, 0x2d, 0x30, 0x31, 0x2d, 0x30, 0x32, 0x54, 0x31, 0x30, 0x3a, 0x30, 0x30, 0x3a, 0x30, 0x30,
+}
+
+func testZstdJSON(n int) []byte {
+	return []byte(fmt.Sprintf(`{"id":%d,"name":"user%d","email":"user%d@example.com","status":"active","roles":["reader","writer"]}`, n, n, n))
+}
+
+func TestNewZstdDictInvalid(t *testing.T) {
+	if _, err := NewZstdDict([]byte("foobar")); err == nil {
+		t.Fatalf("expecting non-nil error for invalid dictionary")
+	}
+}
+
+func TestZstdBytes(t *testing.T) {
+	for _, s := range []string{"", "foobar", string(createFixedBody(1e5))} {
+		src := []byte(s)
+		compressed := AppendZstdBytes(nil, src)
+		b, err := AppendUnzstdBytes(nil, compressed)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(b, src) {
+			t.Fatalf("unexpected decompressed data: %q. Expecting %q", b, src)
+		}
+	}
+}
+
+func TestZstdBytesDict(t *testing.T) {
+	d, err := NewZstdDict(testZstdDictData)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		src := testZstdJSON(i)
+		compressed := AppendZstdBytesDict(nil, src, d)
+		b, err := AppendUnzstdBytesDict(nil, compressed, d)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(b, src) {
+			t.Fatalf("unexpected decompressed data: %q. Expecting %q", b, src)
+		}
+		if _, err := AppendUnzstdBytes(nil, compressed); err == nil {
+			t.Fatalf("expecting non-nil error when decompressing without dictionary")
+		}
+	}
+}
+
+func TestZstdBytesDictConcurrent(t *testing.T) {
+	d, err := NewZstdDict(testZstdDictData)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ch := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func(n int) {
+			for j := 0; j < 100; j++ {
+				src := testZstdJSON(n*100 + j)
+				b, err := AppendUnzstdBytesDict(nil, AppendZstdBytesDict(nil, src, d), d)
+				if err != nil {
+					ch <- err
+					return
+				}
+				if !bytes.Equal(b, src) {
+					ch <- fmt.Errorf("unexpected decompressed data: %q. Expecting %q", b, src)
+					return
+				}
+			}
+			ch <- nil
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		if err := <-ch; err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+}